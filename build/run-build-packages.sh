@@ -294,8 +294,19 @@ package_go_binary services/crunch-dispatch-local crunch-dispatch-local \
     "Dispatch Crunch containers on the local system"
 package_go_binary services/crunch-dispatch-slurm crunch-dispatch-slurm \
     "Dispatch Crunch containers to a SLURM cluster"
+# crunch-run is built with in-process Keep mount support, which
+# links libfuse. The other arvados-server packages are not.
+case "$TARGET" in
+    centos*)
+        libfuse=fuse-libs
+        ;;
+    *)
+        libfuse=libfuse2
+        ;;
+esac
 package_go_binary cmd/arvados-server crunch-run \
-    "Supervise a single Crunch container"
+    "Supervise a single Crunch container" agpl-3.0.txt fuse \
+    --depends fuse --depends "$libfuse"
 package_go_binary services/crunchstat crunchstat \
     "Gather cpu/memory/network statistics of running Crunch jobs"
 package_go_binary services/health arvados-health \
@@ -24,6 +24,7 @@ var (
 		"edit":   cli.Edit,
 		"get":    cli.Get,
 		"keep":   cli.Keep,
+		"login":  cli.Login,
 		"tag":    cli.Tag,
 		"ws":     cli.Ws,
 
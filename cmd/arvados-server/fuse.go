@@ -0,0 +1,23 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+// +build fuse
+
+// Building with "-tags fuse" adds support for crunch-run
+// -keep-mount=in-process. The resulting binary links libfuse, so
+// only the crunch-run package is built this way.
+
+package main
+
+import (
+	"git.arvados.org/arvados.git/lib/crunchrun"
+	"git.arvados.org/arvados.git/lib/mount"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+)
+
+func init() {
+	crunchrun.InProcessKeepMount = func(mountpoint string, client *arvados.Client, opts crunchrun.KeepMountOptions) (crunchrun.KeepMount, error) {
+		return mount.MountInProcess(mountpoint, client, opts.Tmp, opts.BlockCache, opts.FUSEOptions)
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"git.arvados.org/arvados.git/lib/cmd"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/ghodss/yaml"
+)
+
+// APICall handles "arvados-client {type} {method} [--param=value
+// ...]" for all object types (user, group, container, etc.), using
+// the API server's discovery document to determine the available
+// methods and their parameters.
+var APICall cmd.Handler = apiCallCmd{}
+
+type apiCallCmd struct{}
+
+// apiParam is a flag.Value for a method parameter given on the
+// command line. The string value is converted to the type given in
+// the discovery document after parsing.
+type apiParam struct {
+	typ   string
+	value string
+	set   bool
+}
+
+func (p *apiParam) String() string { return p.value }
+
+func (p *apiParam) Set(s string) error {
+	p.value, p.set = s, true
+	return nil
+}
+
+func (p *apiParam) IsBoolFlag() bool { return p.typ == "boolean" }
+
+func (apiCallCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(stderr, "%s\n", err)
+		}
+	}()
+
+	split := strings.Split(prog, " ")
+	if len(split) < 2 {
+		fmt.Fprintf(stderr, "internal error: no api model in %q\n", prog)
+		return 2
+	}
+	objType := split[len(split)-1]
+
+	client := arvados.NewClientFromEnv()
+	dd, err := loadDiscoveryDocument(client)
+	if err != nil {
+		err = fmt.Errorf("error getting discovery document: %s", err)
+		return 1
+	}
+	_, rsc, err := findResource(dd, objType)
+	if err != nil {
+		return 2
+	}
+	var methods []string
+	for name := range rsc.Methods {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods)
+
+	// Accept the parameters of all of this resource's methods
+	// here, and check which ones apply to the given method after
+	// parsing.
+	flags, opts := legacyFlagSet()
+	flags.SetOutput(stderr)
+	params := map[string]*apiParam{}
+	for _, name := range methods {
+		m := rsc.Methods[name]
+		for pname, p := range m.Parameters {
+			if params[pname] == nil && flags.Lookup(pname) == nil {
+				params[pname] = &apiParam{typ: p.Type}
+				flags.Var(params[pname], pname, p.Description)
+			}
+		}
+		for pname := range m.Request.Properties {
+			if params[pname] == nil && flags.Lookup(pname) == nil {
+				params[pname] = &apiParam{typ: "object"}
+				flags.Var(params[pname], pname, "Attributes of the "+pname+" (JSON or YAML)")
+			}
+		}
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s <method> [--parameter=value ...]\nmethods: %s\noptions:\n", prog, strings.Join(methods, ", "))
+		flags.PrintDefaults()
+	}
+	// Flags can appear before and after the method name.
+	err = flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 2
+	}
+	method := flags.Arg(0)
+	err = flags.Parse(flags.Args()[1:])
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+	if opts.Short {
+		opts.Format = "uuid"
+	}
+	m, ok := rsc.Methods[method]
+	if !ok {
+		err = fmt.Errorf("%s: unknown method %q (available methods: %s)", prog, method, strings.Join(methods, ", "))
+		return 2
+	}
+	given := map[string]string{}
+	for pname, p := range params {
+		if p.set {
+			given[pname] = p.value
+		}
+	}
+	path, query, err := apiCallParams(dd, m, method, given)
+	if err != nil {
+		return 2
+	}
+
+	if opts.DryRun {
+		err = printObject(stdout, map[string]interface{}{
+			"method": m.HTTPMethod,
+			"path":   path,
+			"params": query,
+		}, "json")
+		if err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	var resp map[string]interface{}
+	if method == "list" {
+		resp, err = listAllPages(client, m.HTTPMethod, path, query)
+	} else {
+		err = client.RequestAndDecode(&resp, m.HTTPMethod, path, nil, query)
+	}
+	if err != nil {
+		err = fmt.Errorf("%s %s: %s", m.HTTPMethod, path, err)
+		return 1
+	}
+	if items, ok := resp["items"].([]interface{}); ok && opts.Format == "uuid" {
+		for _, item := range items {
+			if item, ok := item.(map[string]interface{}); ok {
+				fmt.Fprintln(stdout, item["uuid"])
+			}
+		}
+		return 0
+	}
+	err = printObject(stdout, resp, opts.Format)
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// apiCallParams checks the given parameters (name => string value
+// from the command line) against the method's parameters in the
+// discovery document, converts them to the appropriate types, and
+// returns the request path (with path parameters filled in) and the
+// remaining parameters.
+func apiCallParams(dd *arvados.DiscoveryDocument, m arvados.ResourceMethod, method string, given map[string]string) (string, map[string]interface{}, error) {
+	path := strings.TrimPrefix(dd.BasePath+m.Path, "/")
+	query := map[string]interface{}{}
+	for pname, s := range given {
+		typ := "object"
+		p, ok := m.Parameters[pname]
+		if ok {
+			typ = p.Type
+		} else if _, ok = m.Request.Properties[pname]; !ok {
+			return "", nil, fmt.Errorf("parameter --%s is not valid for method %q", pname, method)
+		}
+		v, err := convertAPIParam(typ, s)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid value for --%s: %s", pname, err)
+		}
+		if p.Location == "path" {
+			path = strings.Replace(path, "{"+pname+"}", url.PathEscape(s), -1)
+		} else {
+			query[pname] = v
+		}
+	}
+	var missing []string
+	for pname, p := range m.Parameters {
+		if _, ok := given[pname]; p.Required && !ok {
+			missing = append(missing, "--"+pname)
+		}
+	}
+	if m.Request.Required {
+		// One of the request body properties (e.g.,
+		// --collection) is required.
+		var props []string
+		found := false
+		for pname := range m.Request.Properties {
+			_, ok := given[pname]
+			found = found || ok
+			props = append(props, "--"+pname)
+		}
+		if !found {
+			missing = append(missing, props...)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", nil, fmt.Errorf("missing required parameter(s) for method %q: %s", method, strings.Join(missing, ", "))
+	}
+	return path, query, nil
+}
+
+// convertAPIParam converts a command line value to the given
+// discovery document type. Arrays and objects can be given in JSON
+// or YAML; arrays can also be given as comma-separated strings, like
+// "--select=uuid,name".
+func convertAPIParam(typ, s string) (interface{}, error) {
+	switch typ {
+	case "boolean":
+		return strconv.ParseBool(s)
+	case "integer":
+		return strconv.ParseInt(s, 10, 64)
+	case "array":
+		if !strings.HasPrefix(strings.TrimSpace(s), "[") {
+			var list []interface{}
+			for _, elem := range strings.Split(s, ",") {
+				list = append(list, strings.TrimSpace(elem))
+			}
+			return list, nil
+		}
+		var list []interface{}
+		err := yaml.Unmarshal([]byte(s), &list)
+		return list, err
+	case "object", "hash":
+		var obj map[string]interface{}
+		err := yaml.Unmarshal([]byte(s), &obj)
+		return obj, err
+	default:
+		return s, nil
+	}
+}
+
+// listAllPages calls a list method repeatedly until all matching
+// items (or, if a "limit" parameter is given, that many items) have
+// been retrieved, and returns the first page's response with the
+// items from all pages.
+//
+// The first page is requested with the caller's parameters, so the
+// server's default order applies if the caller didn't specify one.
+// If there are more pages to retrieve, and the caller didn't specify
+// an order, a limit, or a select list without uuid, listAllPages
+// starts over in uuid order and retrieves each page using a "uuid >"
+// filter rather than an increasing offset, so items added or deleted
+// while paging don't cause other items to be skipped or repeated.
+func listAllPages(client *arvados.Client, httpMethod, path string, query map[string]interface{}) (map[string]interface{}, error) {
+	var offset, limit int64 = 0, -1
+	if v, ok := query["offset"].(int64); ok {
+		offset = v
+	}
+	if v, ok := query["limit"].(int64); ok {
+		limit = v
+	}
+	_, ordered := query["order"]
+	keyset := !ordered && limit < 0 && selectsUUID(query["select"])
+	// paging is true once we have started over in uuid order.
+	paging := false
+	filters, _ := query["filters"].([]interface{})
+	var resp map[string]interface{}
+	var items []interface{}
+	for {
+		pageQuery := map[string]interface{}{}
+		for k, v := range query {
+			pageQuery[k] = v
+		}
+		pageOffset := offset + int64(len(items))
+		if paging {
+			pageQuery["order"] = []interface{}{"uuid"}
+			if len(items) > 0 {
+				last, _ := items[len(items)-1].(map[string]interface{})
+				lastUUID, ok := last["uuid"].(string)
+				if !ok {
+					return nil, fmt.Errorf("cannot get next page: item %d has no uuid", len(items)-1)
+				}
+				pageQuery["filters"] = append(append([]interface{}(nil), filters...), []interface{}{"uuid", ">", lastUUID})
+				pageOffset = 0
+			}
+		}
+		pageQuery["offset"] = pageOffset
+		if limit >= 0 {
+			pageQuery["limit"] = limit - int64(len(items))
+		}
+		var page map[string]interface{}
+		err := client.RequestAndDecode(&page, httpMethod, path, nil, pageQuery)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil {
+			resp = page
+		}
+		pageItems, _ := page["items"].([]interface{})
+		items = append(items, pageItems...)
+		if len(pageItems) == 0 || (limit >= 0 && int64(len(items)) >= limit) {
+			break
+		}
+		if avail, ok := page["items_available"].(float64); ok && pageOffset+int64(len(pageItems)) >= int64(avail) {
+			break
+		}
+		if keyset && !paging {
+			// The first page (in the server's default
+			// order) isn't everything. Start over in uuid
+			// order.
+			paging = true
+			resp, items = nil, nil
+		}
+	}
+	if items == nil {
+		items = []interface{}{}
+	}
+	resp["items"] = items
+	resp["offset"] = offset
+	resp["limit"] = len(items)
+	return resp, nil
+}
+
+// selectsUUID returns true if a list request with the given select
+// parameter will return the uuid of each item.
+func selectsUUID(sel interface{}) bool {
+	attrs, ok := sel.([]interface{})
+	if !ok {
+		return sel == nil
+	}
+	for _, attr := range attrs {
+		if attr == "uuid" {
+			return true
+		}
+	}
+	return false
+}
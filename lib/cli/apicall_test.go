@@ -0,0 +1,328 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&APICallSuite{})
+
+type APICallSuite struct {
+	server *httptest.Server
+	env    map[string]string
+
+	mtx          sync.Mutex
+	discoveryReq int
+	requests     []*http.Request
+}
+
+const stubDiscoveryDoc = `{
+ "basePath": "/arvados/v1/",
+ "schemas": {"Collection": {"uuidPrefix": "4zz18"}},
+ "resources": {
+  "collections": {
+   "methods": {
+    "get": {
+     "httpMethod": "GET",
+     "path": "collections/{uuid}",
+     "parameters": {"uuid": {"type": "string", "required": true, "location": "path"}},
+     "response": {"$ref": "Collection"}
+    },
+    "list": {
+     "httpMethod": "GET",
+     "path": "collections",
+     "parameters": {
+      "filters": {"type": "array", "location": "query"},
+      "select": {"type": "array", "location": "query"},
+      "order": {"type": "array", "location": "query"},
+      "limit": {"type": "integer", "location": "query"},
+      "offset": {"type": "integer", "location": "query"},
+      "include_trash": {"type": "boolean", "location": "query"}
+     },
+     "response": {"$ref": "CollectionList"}
+    },
+    "create": {
+     "httpMethod": "POST",
+     "path": "collections",
+     "parameters": {"ensure_unique_name": {"type": "boolean", "location": "query"}},
+     "request": {"required": true, "properties": {"collection": {"$ref": "Collection"}}},
+     "response": {"$ref": "Collection"}
+    }
+   }
+  }
+ }
+}`
+
+// ServeHTTP implements a tiny API server with 5 collections, which
+// returns at most 2 items per page. The only filter it applies is
+// "uuid >".
+func (s *APICallSuite) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	s.mtx.Lock()
+	s.requests = append(s.requests, req)
+	s.mtx.Unlock()
+	switch {
+	case req.URL.Path == "/discovery/v1/apis/arvados/v1/rest":
+		s.mtx.Lock()
+		s.discoveryReq++
+		s.mtx.Unlock()
+		w.Write([]byte(stubDiscoveryDoc))
+	case req.URL.Path == "/arvados/v1/collections" && req.Method == "GET":
+		offset, _ := strconv.Atoi(req.Form.Get("offset"))
+		limit := 2
+		if l, err := strconv.Atoi(req.Form.Get("limit")); err == nil && l < limit {
+			limit = l
+		}
+		var filters [][]interface{}
+		json.Unmarshal([]byte(req.Form.Get("filters")), &filters)
+		var matching []string
+		for i := 0; i < 5; i++ {
+			uuid := fmt.Sprintf("zzzzz-4zz18-%015d", i)
+			match := true
+			for _, f := range filters {
+				if f[0] == "uuid" && f[1] == ">" && uuid <= f[2].(string) {
+					match = false
+				}
+			}
+			if match {
+				matching = append(matching, uuid)
+			}
+		}
+		items := []interface{}{}
+		for i := offset; i < len(matching) && len(items) < limit; i++ {
+			items = append(items, map[string]interface{}{"uuid": matching[i]})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"kind":            "arvados#collectionList",
+			"items":           items,
+			"items_available": len(matching),
+			"offset":          offset,
+			"limit":           limit,
+		})
+	case strings.HasPrefix(req.URL.Path, "/arvados/v1/collections/") && req.Method == "GET":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"uuid": strings.TrimPrefix(req.URL.Path, "/arvados/v1/collections/"),
+			"name": "foo",
+		})
+	case req.URL.Path == "/arvados/v1/collections" && req.Method == "POST":
+		var attrs map[string]interface{}
+		json.Unmarshal([]byte(req.Form.Get("collection")), &attrs)
+		attrs["uuid"] = "zzzzz-4zz18-newnewnewnewnew"
+		json.NewEncoder(w).Encode(attrs)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *APICallSuite) SetUpTest(c *check.C) {
+	s.server = httptest.NewTLSServer(s)
+	s.discoveryReq = 0
+	s.requests = nil
+	s.env = map[string]string{}
+	for k, v := range map[string]string{
+		"ARVADOS_API_HOST":          strings.TrimPrefix(s.server.URL, "https://"),
+		"ARVADOS_API_HOST_INSECURE": "1",
+		"ARVADOS_API_TOKEN":         "xyzzy",
+		"XDG_CACHE_HOME":            c.MkDir(),
+	} {
+		s.env[k] = os.Getenv(k)
+		os.Setenv(k, v)
+	}
+}
+
+func (s *APICallSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+	for k, v := range s.env {
+		os.Setenv(k, v)
+	}
+}
+
+func (s *APICallSuite) run(args ...string) (int, string, string) {
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	exited := APICall.RunCommand("arvados-client collection", args, bytes.NewReader(nil), stdout, stderr)
+	return exited, stdout.String(), stderr.String()
+}
+
+func (s *APICallSuite) listRequests() int {
+	n := 0
+	for _, req := range s.requests {
+		if req.URL.Path == "/arvados/v1/collections" && req.Method == "GET" {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *APICallSuite) TestListAllPages(c *check.C) {
+	exited, stdout, stderr := s.run("list", "--select=uuid,name", `--filters=[["name","like","foo%"]]`, "--short")
+	c.Check(stderr, check.Equals, "")
+	c.Check(exited, check.Equals, 0)
+	c.Check(stdout, check.Equals, "zzzzz-4zz18-000000000000000\nzzzzz-4zz18-000000000000001\nzzzzz-4zz18-000000000000002\nzzzzz-4zz18-000000000000003\nzzzzz-4zz18-000000000000004\n")
+
+	// Without --order, the first request uses the server's
+	// default order. There are more pages, so the remaining
+	// requests start over in uuid order using a "uuid >" filter.
+	c.Check(s.listRequests(), check.Equals, 4)
+	first := s.requests[len(s.requests)-4]
+	c.Check(first.Form.Get("order"), check.Equals, "")
+	c.Check(first.Form.Get("filters"), check.Equals, `[["name","like","foo%"]]`)
+	second := s.requests[len(s.requests)-3]
+	c.Check(second.Form.Get("order"), check.Equals, `["uuid"]`)
+	c.Check(second.Form.Get("filters"), check.Equals, `[["name","like","foo%"]]`)
+	last := s.requests[len(s.requests)-1]
+	c.Check(last.Form.Get("select"), check.Equals, `["uuid","name"]`)
+	c.Check(last.Form.Get("filters"), check.Equals, `[["name","like","foo%"],["uuid","\u003e","zzzzz-4zz18-000000000000003"]]`)
+	c.Check(last.Form.Get("order"), check.Equals, `["uuid"]`)
+	c.Check(last.Form.Get("offset"), check.Equals, "0")
+
+	// With --order, or without uuid in --select, pages are
+	// retrieved by offset.
+	for _, args := range [][]string{
+		{"list", "--order=name", "--short"},
+		{"list", "--select=name"},
+	} {
+		s.requests = nil
+		exited, _, stderr = s.run(args...)
+		c.Check(stderr, check.Equals, "")
+		c.Check(exited, check.Equals, 0)
+		c.Check(s.listRequests(), check.Equals, 3)
+		last = s.requests[len(s.requests)-1]
+		c.Check(last.Form.Get("filters"), check.Equals, "")
+		c.Check(last.Form.Get("offset"), check.Equals, "4")
+	}
+
+	// With --limit, the server's default order is kept, and
+	// pages are retrieved by offset.
+	s.requests = nil
+	exited, stdout, _ = s.run("list", "--limit=3", "--offset=1")
+	c.Check(s.listRequests(), check.Equals, 2)
+	for _, req := range s.requests {
+		c.Check(req.Form.Get("order"), check.Equals, "")
+		c.Check(req.Form.Get("filters"), check.Equals, "")
+	}
+	c.Check(s.requests[len(s.requests)-1].Form.Get("offset"), check.Equals, "3")
+	c.Check(exited, check.Equals, 0)
+	var resp struct {
+		Items  []map[string]interface{}
+		Offset int
+		Limit  int
+	}
+	c.Check(json.Unmarshal([]byte(stdout), &resp), check.IsNil)
+	c.Check(resp.Items, check.HasLen, 3)
+	c.Check(resp.Items[0]["uuid"], check.Equals, "zzzzz-4zz18-000000000000001")
+	c.Check(resp.Offset, check.Equals, 1)
+	c.Check(resp.Limit, check.Equals, 3)
+
+	// A single page is retrieved in the server's default order.
+	for _, args := range [][]string{
+		{"list", "--limit=2", "--short"},
+		{"list", `--filters=[["uuid",">","zzzzz-4zz18-000000000000002"]]`, "--short"},
+	} {
+		s.requests = nil
+		exited, stdout, stderr = s.run(args...)
+		c.Check(stderr, check.Equals, "")
+		c.Check(exited, check.Equals, 0)
+		c.Check(strings.Count(stdout, "\n"), check.Equals, 2)
+		c.Check(s.listRequests(), check.Equals, 1)
+		c.Check(s.requests[len(s.requests)-1].Form.Get("order"), check.Equals, "")
+	}
+}
+
+func (s *APICallSuite) TestGet(c *check.C) {
+	exited, stdout, stderr := s.run("--format=yaml", "get", "--uuid=zzzzz-4zz18-aaaaaaaaaaaaaaa")
+	c.Check(stderr, check.Equals, "")
+	c.Check(exited, check.Equals, 0)
+	c.Check(stdout, check.Equals, "name: foo\nuuid: zzzzz-4zz18-aaaaaaaaaaaaaaa\n")
+}
+
+func (s *APICallSuite) TestCreate(c *check.C) {
+	exited, stdout, stderr := s.run("create", "--collection", "{name: bar}", "--ensure_unique_name")
+	c.Check(stderr, check.Equals, "")
+	c.Check(exited, check.Equals, 0)
+	c.Check(stdout, check.Matches, `(?ms).*"name": "bar".*`)
+	last := s.requests[len(s.requests)-1]
+	c.Check(last.Form.Get("ensure_unique_name"), check.Equals, "true")
+}
+
+func (s *APICallSuite) TestDryRun(c *check.C) {
+	exited, stdout, _ := s.run("-n", "get", "--uuid=zzzzz-4zz18-aaaaaaaaaaaaaaa")
+	c.Check(exited, check.Equals, 0)
+	c.Check(stdout, check.Matches, `(?ms).*"method": "GET",\n  "params": {},\n  "path": "arvados/v1/collections/zzzzz-4zz18-aaaaaaaaaaaaaaa".*`)
+	for _, req := range s.requests {
+		c.Check(req.URL.Path, check.Equals, "/discovery/v1/apis/arvados/v1/rest")
+	}
+}
+
+func (s *APICallSuite) TestInvalidParams(c *check.C) {
+	exited, _, stderr := s.run("get")
+	c.Check(exited, check.Equals, 2)
+	c.Check(stderr, check.Equals, "missing required parameter(s) for method \"get\": --uuid\n")
+
+	exited, _, stderr = s.run("create")
+	c.Check(exited, check.Equals, 2)
+	c.Check(stderr, check.Equals, "missing required parameter(s) for method \"create\": --collection\n")
+
+	exited, _, stderr = s.run("get", "--uuid=zzzzz-4zz18-aaaaaaaaaaaaaaa", "--limit=3")
+	c.Check(exited, check.Equals, 2)
+	c.Check(stderr, check.Equals, "parameter --limit is not valid for method \"get\"\n")
+
+	exited, _, stderr = s.run("list", "--limit=three")
+	c.Check(exited, check.Equals, 2)
+	c.Check(stderr, check.Matches, `invalid value for --limit: .*\n`)
+
+	exited, _, stderr = s.run("frobnicate")
+	c.Check(exited, check.Equals, 2)
+	c.Check(stderr, check.Equals, "arvados-client collection: unknown method \"frobnicate\" (available methods: create, get, list)\n")
+
+	exited, _, stderr = s.run("list", "--bogus")
+	c.Check(exited, check.Equals, 2)
+	c.Check(stderr, check.Matches, `(?ms).*bogus.*`)
+}
+
+func (s *APICallSuite) TestDiscoveryCache(c *check.C) {
+	for i := 0; i < 3; i++ {
+		exited, _, _ := s.run("get", "--uuid=zzzzz-4zz18-aaaaaaaaaaaaaaa")
+		c.Check(exited, check.Equals, 0)
+	}
+	c.Check(s.discoveryReq, check.Equals, 1)
+}
+
+func (s *APICallSuite) TestConvertAPIParam(c *check.C) {
+	for _, trial := range []struct {
+		typ    string
+		in     string
+		expect interface{}
+	}{
+		{"string", "foo", "foo"},
+		{"integer", "123", int64(123)},
+		{"boolean", "true", true},
+		{"boolean", "false", false},
+		{"array", "uuid, name", []interface{}{"uuid", "name"}},
+		{"array", `["name desc"]`, []interface{}{"name desc"}},
+		{"array", `[["uuid", "=", "x"]]`, []interface{}{[]interface{}{"uuid", "=", "x"}}},
+		{"object", `{"a": 1}`, map[string]interface{}{"a": float64(1)}},
+		{"object", "a: b", map[string]interface{}{"a": "b"}},
+	} {
+		v, err := convertAPIParam(trial.typ, trial.in)
+		c.Check(err, check.IsNil)
+		c.Check(v, check.DeepEquals, trial.expect, check.Commentf("%s %q", trial.typ, trial.in))
+	}
+	_, err := convertAPIParam("integer", "x")
+	c.Check(err, check.NotNil)
+	_, err = convertAPIParam("object", "[1]")
+	c.Check(err, check.NotNil)
+}
@@ -0,0 +1,621 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"git.arvados.org/arvados.git/lib/cmd"
+	"git.arvados.org/arvados.git/lib/config"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"git.arvados.org/arvados.git/sdk/go/keepclient"
+	"github.com/ghodss/yaml"
+	"rsc.io/getopt"
+)
+
+var Copy cmd.Handler = copyCmd{}
+
+var (
+	pdhRe         = regexp.MustCompile(`^[0-9a-f]{32}\+[0-9]+$`)
+	locatorRe     = regexp.MustCompile(`^[0-9a-f]{32}\+[0-9]+(\+\S+)*$`)
+	uuidRe        = regexp.MustCompile(`^[0-9a-z]{5}-[0-9a-z]{5}-[0-9a-z]{15}$`)
+	dockerLinkCls = []interface{}{"docker_image_repo+tag", "docker_image_hash"}
+)
+
+// copyCmd copies collections, workflows, and projects from one
+// cluster to another, like the legacy arv-copy tool.
+type copyCmd struct{}
+
+func (copyCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", prog, err)
+		}
+	}()
+
+	flags := getopt.NewFlagSet(prog, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", arvados.DefaultConfigFile, "Site configuration `file`, listing the source and destination clusters in RemoteClusters")
+	srcID := flags.String("src", "", "Cluster ID to copy from (default: inferred from the object UUID)")
+	dstID := flags.String("dst", "", "Cluster ID to copy to (default: the cluster in the config file)")
+	projectUUID := flags.String("project-uuid", "", "UUID of the project to copy into (default: the destination user's home project)")
+	recursive := flags.Bool("recursive", true, "Copy dependencies (collections and Docker images used by workflows) and subprojects")
+	noRecursive := flags.Bool("no-recursive", false, "Do not copy dependencies or subprojects")
+	force := flags.Bool("force", false, "Copy collections even if an identical collection already exists in the destination project")
+	verbose := flags.Bool("verbose", false, "Log progress on stderr")
+	flags.Alias("v", "verbose")
+	flags.Alias("f", "force")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s [options] object-uuid\n", prog)
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	id := flags.Arg(0)
+	if *srcID == "" {
+		if !uuidRe.MatchString(id) {
+			err = errors.New("--src is required when copying by portable data hash")
+			return 2
+		}
+		*srcID = id[:5]
+	}
+
+	ldr := config.NewLoader(nil, ctxlog.New(stderr, "text", "info"))
+	ldr.Path = *configPath
+	ldr.SkipLegacy = true
+	cfg, err := ldr.Load()
+	if err != nil {
+		return 1
+	}
+	cluster, err := cfg.GetCluster("")
+	if err != nil {
+		return 1
+	}
+	if *dstID == "" {
+		*dstID = cluster.ClusterID
+	}
+
+	cp := &copier{
+		force:     *force,
+		recursive: *recursive && !*noRecursive,
+		blocks:    map[string]string{},
+		copied:    map[string]string{},
+	}
+	if *verbose {
+		cp.logf = func(f string, args ...interface{}) { fmt.Fprintf(stderr, f+"\n", args...) }
+	} else {
+		cp.logf = func(string, ...interface{}) {}
+	}
+	cp.src, cp.srcKC, err = copyClients(cluster, *srcID)
+	if err != nil {
+		return 1
+	}
+	cp.dst, cp.dstKC, err = copyClients(cluster, *dstID)
+	if err != nil {
+		return 1
+	}
+	if *projectUUID == "" {
+		var u arvados.User
+		err = cp.dst.RequestAndDecode(&u, "GET", "arvados/v1/users/current", nil, nil)
+		if err != nil {
+			err = fmt.Errorf("error getting current user on %s: %s", *dstID, err)
+			return 1
+		}
+		*projectUUID = u.UUID
+	}
+
+	newUUID, err := cp.copyObject(id, *projectUUID)
+	if err != nil {
+		return 1
+	}
+	fmt.Fprintf(stderr, "%s: Success: created copy with uuid %s\n", prog, newUUID)
+	fmt.Fprintln(stdout, newUUID)
+	return 0
+}
+
+// copyClients returns API and Keep clients for the given cluster.
+//
+// The cluster must be the local cluster or one of its
+// RemoteClusters. The token is taken from
+// ~/.config/arvados/{clusterID}.conf (the same file used by the
+// legacy arv-copy tool) if it exists. ARVADOS_API_TOKEN is used only
+// for the local cluster: sending it to a remote cluster would leak
+// the local token and fail anyway.
+func copyClients(cluster *arvados.Cluster, clusterID string) (*arvados.Client, *keepclient.KeepClient, error) {
+	var client *arvados.Client
+	if clusterID == cluster.ClusterID {
+		var err error
+		client, err = arvados.NewClientFromConfig(cluster)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if rc, ok := cluster.RemoteClusters[clusterID]; ok && rc.Host != "" {
+		client = &arvados.Client{
+			Scheme:   rc.Scheme,
+			APIHost:  rc.Host,
+			Insecure: rc.Insecure,
+		}
+	} else {
+		return nil, nil, fmt.Errorf("cluster %s is not listed in RemoteClusters", clusterID)
+	}
+	if clusterID == cluster.ClusterID {
+		client.AuthToken = os.Getenv("ARVADOS_API_TOKEN")
+	}
+	settingsFile := "~/.config/arvados/" + clusterID + ".conf"
+	if home, err := os.UserHomeDir(); err == nil {
+		settingsFile = filepath.Join(home, ".config", "arvados", clusterID+".conf")
+		settings, err := readSettingsFile(settingsFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+		if tok := settings["ARVADOS_API_TOKEN"]; tok != "" {
+			client.AuthToken = tok
+		}
+	}
+	if client.AuthToken == "" {
+		return nil, nil, fmt.Errorf("no API token for cluster %s (set ARVADOS_API_TOKEN in %s)", clusterID, settingsFile)
+	}
+	ac, err := arvadosclient.New(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	if client.Scheme != "" {
+		ac.Scheme = client.Scheme
+	}
+	kc, err := keepclient.MakeKeepClient(ac)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error setting up keep client for cluster %s: %s", clusterID, err)
+	}
+	return client, kc, nil
+}
+
+// readSettingsFile reads a file of KEY=value lines, like
+// ~/.config/arvados/settings.conf.
+func readSettingsFile(fnm string) (map[string]string, error) {
+	f, err := os.Open(fnm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	settings := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if kv := strings.SplitN(line, "=", 2); len(kv) == 2 {
+			settings[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return settings, scanner.Err()
+}
+
+type copier struct {
+	src, dst     *arvados.Client
+	srcKC, dstKC *keepclient.KeepClient
+	force        bool
+	recursive    bool
+	logf         func(string, ...interface{})
+
+	// Source block hash => signed destination locator
+	blocks map[string]string
+	// Destination project + "/" + source collection UUID or
+	// PDH => destination collection UUID
+	copied map[string]string
+}
+
+// copyObject copies the object with the given UUID (or portable data
+// hash) into the given destination project, and returns the UUID of
+// the copy.
+func (cp *copier) copyObject(id, owner string) (string, error) {
+	if pdhRe.MatchString(id) {
+		return cp.copyCollection(id, owner)
+	}
+	if !uuidRe.MatchString(id) {
+		return "", fmt.Errorf("%q is not a UUID or portable data hash", id)
+	}
+	switch id[6:11] {
+	case "4zz18":
+		return cp.copyCollection(id, owner)
+	case "7fd4e":
+		return cp.copyWorkflow(id, owner)
+	case "j7d0g":
+		return cp.copyProject(id, owner)
+	default:
+		return "", fmt.Errorf("cannot copy %s: unsupported object type", id)
+	}
+}
+
+// copyCollection copies the given collection, including its data
+// blocks, and returns the UUID of the copy. Unless force is set, an
+// existing collection with the same content in the destination
+// project is used instead of making a new copy.
+func (cp *copier) copyCollection(id, owner string) (string, error) {
+	if uuid, ok := cp.copied[owner+"/"+id]; ok {
+		return uuid, nil
+	}
+	var coll map[string]interface{}
+	err := cp.src.RequestAndDecode(&coll, "GET", "arvados/v1/collections/"+id, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("error getting collection %s: %s", id, err)
+	}
+	pdh, _ := coll["portable_data_hash"].(string)
+	if !cp.force {
+		var existing arvados.CollectionList
+		err = cp.dst.RequestAndDecode(&existing, "GET", "arvados/v1/collections", nil, arvados.ResourceListParams{
+			Filters: []arvados.Filter{
+				{Attr: "portable_data_hash", Operator: "=", Operand: pdh},
+				{Attr: "owner_uuid", Operator: "=", Operand: owner},
+			},
+			Limit: &[]int{1}[0],
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(existing.Items) > 0 {
+			uuid := existing.Items[0].UUID
+			cp.logf("skipping collection %s: identical collection %s already exists in %s", id, uuid, owner)
+			cp.copied[owner+"/"+id] = uuid
+			return uuid, nil
+		}
+	}
+
+	cp.logf("copying collection %s (%s)", id, pdh)
+	manifest, _ := coll["manifest_text"].(string)
+	manifest, err = rewriteManifest(manifest, cp.copyBlock)
+	if err != nil {
+		return "", fmt.Errorf("error copying data for collection %s: %s", id, err)
+	}
+	name, _ := coll["name"].(string)
+	if name == "" {
+		name = "Copy of " + pdh
+	}
+	var created arvados.Collection
+	err = cp.dst.RequestAndDecode(&created, "POST", "arvados/v1/collections", nil, map[string]interface{}{
+		"ensure_unique_name": true,
+		"collection": map[string]interface{}{
+			"owner_uuid":    owner,
+			"name":          name,
+			"description":   coll["description"],
+			"properties":    coll["properties"],
+			"manifest_text": manifest,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating copy of collection %s: %s", id, err)
+	}
+	if created.PortableDataHash != pdh {
+		return "", fmt.Errorf("copy of collection %s has portable data hash %s, expected %s", id, created.PortableDataHash, pdh)
+	}
+	cp.copied[owner+"/"+id] = created.UUID
+	cp.copied[owner+"/"+pdh] = created.UUID
+	return created.UUID, nil
+}
+
+// copyBlock copies a data block from the source cluster to the
+// destination cluster, and returns the destination's signed
+// locator. The source block is verified against its hash when it
+// is read.
+func (cp *copier) copyBlock(loc string) (string, error) {
+	hash := loc[:32]
+	if newloc, ok := cp.blocks[hash]; ok {
+		return newloc, nil
+	}
+	rdr, _, _, err := cp.srcKC.Get(loc)
+	if err != nil {
+		return "", err
+	}
+	buf, err := ioutil.ReadAll(rdr)
+	if err == nil {
+		err = rdr.Close()
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading block %s: %s", hash, err)
+	}
+	newloc, _, err := cp.dstKC.PutB(buf)
+	if err != nil {
+		return "", fmt.Errorf("error writing block %s: %s", hash, err)
+	}
+	cp.blocks[hash] = newloc
+	return newloc, nil
+}
+
+// rewriteManifest returns a copy of manifest with each block locator
+// replaced by mapLocator(locator).
+func rewriteManifest(manifest string, mapLocator func(string) (string, error)) (string, error) {
+	var out strings.Builder
+	for _, line := range strings.Split(manifest, "\n") {
+		if line == "" {
+			continue
+		}
+		tokens := strings.Split(line, " ")
+		for i, tok := range tokens {
+			if i == 0 || !locatorRe.MatchString(tok) {
+				continue
+			}
+			loc, err := mapLocator(tok)
+			if err != nil {
+				return "", err
+			}
+			tokens[i] = loc
+		}
+		out.WriteString(strings.Join(tokens, " "))
+		out.WriteString("\n")
+	}
+	return out.String(), nil
+}
+
+// copyWorkflow copies the given workflow, and (if recursive is set)
+// the collections and Docker images it refers to.
+func (cp *copier) copyWorkflow(uuid, owner string) (string, error) {
+	var wf arvados.Workflow
+	err := cp.src.RequestAndDecode(&wf, "GET", "arvados/v1/workflows/"+uuid, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("error getting workflow %s: %s", uuid, err)
+	}
+	definition := wf.Definition
+	if cp.recursive {
+		refs, images, err := workflowDependencies(definition)
+		if err != nil {
+			return "", fmt.Errorf("error parsing definition of workflow %s: %s", uuid, err)
+		}
+		for _, ref := range refs {
+			newUUID, err := cp.copyCollection(ref, owner)
+			if err != nil {
+				return "", err
+			}
+			if uuidRe.MatchString(ref) {
+				// References by PDH stay valid on the
+				// destination; references by UUID need
+				// to point to the copy.
+				definition = strings.Replace(definition, "keep:"+ref, "keep:"+newUUID, -1)
+			}
+		}
+		for _, image := range images {
+			err = cp.copyDockerImage(image, owner)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	cp.logf("copying workflow %s", uuid)
+	var created arvados.Workflow
+	err = cp.dst.RequestAndDecode(&created, "POST", "arvados/v1/workflows", nil, map[string]interface{}{
+		"ensure_unique_name": true,
+		"workflow": map[string]interface{}{
+			"owner_uuid":  owner,
+			"name":        wf.Name,
+			"description": wf.Description,
+			"definition":  definition,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating copy of workflow %s: %s", uuid, err)
+	}
+	return created.UUID, nil
+}
+
+// workflowDependencies returns the collections (UUIDs or PDHs
+// referenced as "keep:...") and Docker images (dockerPull) used by
+// a workflow definition.
+func workflowDependencies(definition string) (refs, images []string, err error) {
+	var doc interface{}
+	err = yaml.Unmarshal([]byte(definition), &doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := map[string]bool{}
+	var walk func(interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, v := range v {
+				if s, ok := v.(string); ok && k == "dockerPull" && !seen[s] {
+					seen[s] = true
+					images = append(images, s)
+				}
+				walk(v)
+			}
+		case []interface{}:
+			for _, v := range v {
+				walk(v)
+			}
+		case string:
+			if !strings.HasPrefix(v, "keep:") {
+				return
+			}
+			if m := keepSourceRe.FindStringSubmatch(v); m != nil && !seen[m[1]] {
+				seen[m[1]] = true
+				refs = append(refs, m[1])
+			}
+		}
+	}
+	walk(doc)
+	return refs, images, nil
+}
+
+// dockerRepoTag splits a Docker image name into repository and tag,
+// using "latest" if no tag is given.
+func dockerRepoTag(image string) (string, string) {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+// copyDockerImage copies the collection containing the given Docker
+// image, along with the links that identify it as a Docker image.
+func (cp *copier) copyDockerImage(image, owner string) error {
+	repo, tag := dockerRepoTag(image)
+	var links arvados.LinkList
+	err := cp.src.RequestAndDecode(&links, "GET", "arvados/v1/links", nil, arvados.ResourceListParams{
+		Filters: []arvados.Filter{
+			{Attr: "link_class", Operator: "=", Operand: "docker_image_repo+tag"},
+			{Attr: "name", Operator: "=", Operand: repo + ":" + tag},
+		},
+		Order: "created_at desc",
+		Limit: &[]int{1}[0],
+	})
+	if err != nil {
+		return err
+	}
+	if len(links.Items) == 0 {
+		return fmt.Errorf("Docker image %s:%s not found on source cluster", repo, tag)
+	}
+	srcUUID := links.Items[0].HeadUUID
+	cp.logf("copying Docker image %s:%s (%s)", repo, tag, srcUUID)
+	dstUUID, err := cp.copyCollection(srcUUID, owner)
+	if err != nil {
+		return err
+	}
+
+	err = cp.src.RequestAndDecode(&links, "GET", "arvados/v1/links", nil, arvados.ResourceListParams{
+		Filters: []arvados.Filter{
+			{Attr: "head_uuid", Operator: "=", Operand: srcUUID},
+			{Attr: "link_class", Operator: "in", Operand: dockerLinkCls},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, link := range links.Items {
+		var existing arvados.LinkList
+		err = cp.dst.RequestAndDecode(&existing, "GET", "arvados/v1/links", nil, arvados.ResourceListParams{
+			Filters: []arvados.Filter{
+				{Attr: "head_uuid", Operator: "=", Operand: dstUUID},
+				{Attr: "link_class", Operator: "=", Operand: link.LinkClass},
+				{Attr: "name", Operator: "=", Operand: link.Name},
+			},
+			Limit: &[]int{1}[0],
+		})
+		if err != nil {
+			return err
+		}
+		if len(existing.Items) > 0 {
+			continue
+		}
+		err = cp.dst.RequestAndDecode(nil, "POST", "arvados/v1/links", nil, map[string]interface{}{
+			"link": map[string]interface{}{
+				"owner_uuid": owner,
+				"link_class": link.LinkClass,
+				"name":       link.Name,
+				"head_uuid":  dstUUID,
+				"properties": link.Properties,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("error creating %s link for Docker image %s: %s", link.LinkClass, image, err)
+		}
+	}
+	return nil
+}
+
+// copyProject copies the given project and the collections and
+// workflows in it. If recursive is set, subprojects are copied too.
+func (cp *copier) copyProject(uuid, owner string) (string, error) {
+	var proj arvados.Group
+	err := cp.src.RequestAndDecode(&proj, "GET", "arvados/v1/groups/"+uuid, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("error getting project %s: %s", uuid, err)
+	}
+	if proj.GroupClass != "project" {
+		return "", fmt.Errorf("cannot copy %s: group_class is %q, not \"project\"", uuid, proj.GroupClass)
+	}
+	cp.logf("copying project %s (%q)", uuid, proj.Name)
+	var created arvados.Group
+	err = cp.dst.RequestAndDecode(&created, "POST", "arvados/v1/groups", nil, map[string]interface{}{
+		"ensure_unique_name": true,
+		"group": map[string]interface{}{
+			"owner_uuid":  owner,
+			"name":        proj.Name,
+			"description": proj.Description,
+			"properties":  proj.Properties,
+			"group_class": "project",
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating copy of project %s: %s", uuid, err)
+	}
+
+	ownedBy := []arvados.Filter{{Attr: "owner_uuid", Operator: "=", Operand: uuid}}
+	for _, path := range []string{"arvados/v1/collections", "arvados/v1/workflows"} {
+		items, err := listAllUUIDs(cp.src, path, ownedBy)
+		if err != nil {
+			return "", err
+		}
+		for _, item := range items {
+			_, err = cp.copyObject(item, created.UUID)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	if cp.recursive {
+		subprojects, err := listAllUUIDs(cp.src, "arvados/v1/groups", append(ownedBy, arvados.Filter{Attr: "group_class", Operator: "=", Operand: "project"}))
+		if err != nil {
+			return "", err
+		}
+		for _, sub := range subprojects {
+			_, err = cp.copyProject(sub, created.UUID)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return created.UUID, nil
+}
+
+// listAllUUIDs returns the UUIDs of all items matching the given
+// filters, fetching as many pages as needed.
+func listAllUUIDs(client *arvados.Client, path string, filters []arvados.Filter) ([]string, error) {
+	var uuids []string
+	for {
+		var page struct {
+			Items []struct {
+				UUID string `json:"uuid"`
+			} `json:"items"`
+		}
+		err := client.RequestAndDecode(&page, "GET", path, nil, arvados.ResourceListParams{
+			Select:  []string{"uuid"},
+			Filters: append(filters, arvados.Filter{Attr: "uuid", Operator: ">", Operand: lastOf(uuids)}),
+			Order:   "uuid",
+			Count:   "none",
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Items) == 0 {
+			return uuids, nil
+		}
+		for _, item := range page.Items {
+			uuids = append(uuids, item.UUID)
+		}
+	}
+}
+
+func lastOf(uuids []string) string {
+	if len(uuids) == 0 {
+		return ""
+	}
+	return uuids[len(uuids)-1]
+}
@@ -0,0 +1,534 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&CopySuite{})
+
+type CopySuite struct {
+	src, dst *copyStub
+	cluster  *arvados.Cluster
+	env      map[string]string
+}
+
+var copyStubInfix = map[string]string{
+	"collections": "4zz18",
+	"workflows":   "7fd4e",
+	"groups":      "j7d0g",
+	"links":       "o0j0j",
+}
+
+var copyStubBlockRe = regexp.MustCompile(`^/([0-9a-f]{32})`)
+
+// copyStub is a tiny API and Keep server for one cluster. List
+// requests return at most 2 items per page, in UUID order.
+type copyStub struct {
+	prefix string
+	// If badPDH is set, created collections get the wrong
+	// portable data hash.
+	badPDH bool
+
+	mtx      sync.Mutex
+	server   *httptest.Server
+	serial   int
+	objects  map[string]map[string]interface{}
+	blocks   map[string][]byte
+	tokens   map[string]bool
+	requests []string
+}
+
+func newCopyStub(prefix string) *copyStub {
+	stub := &copyStub{
+		prefix:  prefix,
+		objects: map[string]map[string]interface{}{},
+		blocks:  map[string][]byte{},
+		tokens:  map[string]bool{},
+	}
+	stub.server = httptest.NewTLSServer(stub)
+	return stub
+}
+
+func (stub *copyStub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	stub.mtx.Lock()
+	defer stub.mtx.Unlock()
+	stub.requests = append(stub.requests, req.Method+" "+req.URL.Path)
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		stub.tokens[strings.TrimPrefix(auth, "OAuth2 ")] = true
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/arvados/v1/")
+	parts := strings.Split(path, "/")
+	switch {
+	case req.URL.Path == "/discovery/v1/apis/arvados/v1/rest":
+		w.Write([]byte(`{}`))
+	case req.URL.Path == "/arvados/v1/keep_services/accessible":
+		addr := stub.server.Listener.Addr().(*net.TCPAddr)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"items": []interface{}{map[string]interface{}{
+				"uuid":             stub.prefix + "-bi6l4-000000000000000",
+				"service_host":     addr.IP.String(),
+				"service_port":     addr.Port,
+				"service_ssl_flag": true,
+				"service_type":     "proxy",
+			}},
+		})
+	case copyStubBlockRe.MatchString(req.URL.Path) && req.Method == "PUT":
+		data, _ := ioutil.ReadAll(req.Body)
+		hash := fmt.Sprintf("%x", md5.Sum(data))
+		stub.blocks[hash] = data
+		w.Header().Set("X-Keep-Replicas-Stored", "2")
+		fmt.Fprintf(w, "%s+%d+A%s@0\n", hash, len(data), stub.prefix)
+	case copyStubBlockRe.MatchString(req.URL.Path) && req.Method == "GET":
+		data, ok := stub.blocks[copyStubBlockRe.FindStringSubmatch(req.URL.Path)[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case copyStubInfix[parts[0]] == "":
+		w.WriteHeader(http.StatusNotFound)
+	case len(parts) == 2 && req.Method == "GET":
+		obj, ok := stub.objects[parts[1]]
+		for _, uuid := range stub.uuids(parts[0]) {
+			if !ok && stub.objects[uuid]["portable_data_hash"] == parts[1] {
+				obj, ok = stub.objects[uuid], true
+			}
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(obj)
+	case len(parts) == 1 && req.Method == "GET":
+		var filters [][]interface{}
+		json.Unmarshal([]byte(req.Form.Get("filters")), &filters)
+		items := []interface{}{}
+		for _, uuid := range stub.uuids(parts[0]) {
+			if len(items) < 2 && copyStubMatch(stub.objects[uuid], filters) {
+				items = append(items, stub.objects[uuid])
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	case len(parts) == 1 && req.Method == "POST":
+		var attrs map[string]interface{}
+		json.Unmarshal([]byte(req.Form.Get(strings.TrimSuffix(parts[0], "s"))), &attrs)
+		if parts[0] == "collections" {
+			manifest, _ := attrs["manifest_text"].(string)
+			for _, tok := range strings.Split(strings.Replace(manifest, "\n", " ", -1), " ") {
+				if locatorRe.MatchString(tok) && stub.blocks[tok[:32]] == nil {
+					w.WriteHeader(http.StatusUnprocessableEntity)
+					return
+				}
+			}
+			attrs["portable_data_hash"] = arvados.PortableDataHash(manifest)
+			if stub.badPDH {
+				attrs["portable_data_hash"] = "d41d8cd98f00b204e9800998ecf8427e+0"
+			}
+		}
+		json.NewEncoder(w).Encode(stub.add(parts[0], attrs))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// add stores a new object with the given attributes, and returns it
+// with a UUID assigned. The caller must hold mtx, or be the only
+// goroutine using the stub.
+func (stub *copyStub) add(resource string, attrs map[string]interface{}) map[string]interface{} {
+	stub.serial++
+	attrs["uuid"] = fmt.Sprintf("%s-%s-%015d", stub.prefix, copyStubInfix[resource], stub.serial)
+	stub.objects[attrs["uuid"].(string)] = attrs
+	return attrs
+}
+
+// addCollection stores the given files as a new collection, with one
+// block per file, and returns the collection.
+func (stub *copyStub) addCollection(owner string, files ...string) map[string]interface{} {
+	manifest := "."
+	for i, data := range files {
+		hash := fmt.Sprintf("%x", md5.Sum([]byte(data)))
+		stub.blocks[hash] = []byte(data)
+		manifest += fmt.Sprintf(" %s+%d+A%s@0", hash, len(data), stub.prefix)
+		files[i] = fmt.Sprintf("%d:%d:file%d", 0, len(data), i)
+	}
+	manifest += " " + strings.Join(files, " ") + "\n"
+	return stub.add("collections", map[string]interface{}{
+		"owner_uuid":         owner,
+		"name":               "collection",
+		"manifest_text":      manifest,
+		"portable_data_hash": arvados.PortableDataHash(manifest),
+	})
+}
+
+// uuids returns the UUIDs of the given type, in order.
+func (stub *copyStub) uuids(resource string) []string {
+	var uuids []string
+	for uuid := range stub.objects {
+		if uuid[6:11] == copyStubInfix[resource] {
+			uuids = append(uuids, uuid)
+		}
+	}
+	sort.Strings(uuids)
+	return uuids
+}
+
+func (stub *copyStub) countRequests(req string) int {
+	stub.mtx.Lock()
+	defer stub.mtx.Unlock()
+	n := 0
+	for _, r := range stub.requests {
+		if r == req {
+			n++
+		}
+	}
+	return n
+}
+
+func copyStubMatch(obj map[string]interface{}, filters [][]interface{}) bool {
+	for _, f := range filters {
+		val := fmt.Sprint(obj[f[0].(string)])
+		switch f[1] {
+		case "=":
+			if val != fmt.Sprint(f[2]) {
+				return false
+			}
+		case ">":
+			if val <= fmt.Sprint(f[2]) {
+				return false
+			}
+		case "in":
+			found := false
+			for _, operand := range f[2].([]interface{}) {
+				found = found || val == fmt.Sprint(operand)
+			}
+			if !found {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (s *CopySuite) SetUpTest(c *check.C) {
+	s.src = newCopyStub("yyyyy")
+	s.dst = newCopyStub("zzzzz")
+	dstURL, _ := url.Parse(s.dst.server.URL)
+	s.cluster = &arvados.Cluster{
+		ClusterID: "zzzzz",
+		RemoteClusters: map[string]arvados.RemoteCluster{
+			"yyyyy": {
+				Scheme:   "https",
+				Host:     strings.TrimPrefix(s.src.server.URL, "https://"),
+				Insecure: true,
+			},
+		},
+	}
+	s.cluster.Services.Controller.ExternalURL = arvados.URL(*dstURL)
+	s.cluster.TLS.Insecure = true
+
+	home := c.MkDir()
+	os.MkdirAll(filepath.Join(home, ".config", "arvados"), 0700)
+	s.env = map[string]string{}
+	for k, v := range map[string]string{
+		"HOME":              home,
+		"ARVADOS_API_TOKEN": "zzzzz-token",
+	} {
+		s.env[k] = os.Getenv(k)
+		os.Setenv(k, v)
+	}
+}
+
+func (s *CopySuite) TearDownTest(c *check.C) {
+	s.src.server.Close()
+	s.dst.server.Close()
+	for k, v := range s.env {
+		os.Setenv(k, v)
+	}
+}
+
+func (s *CopySuite) writeSettings(c *check.C, clusterID, token string) {
+	fnm := filepath.Join(os.Getenv("HOME"), ".config", "arvados", clusterID+".conf")
+	err := ioutil.WriteFile(fnm, []byte("ARVADOS_API_TOKEN="+token+"\n"), 0600)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *CopySuite) copier(c *check.C) *copier {
+	s.writeSettings(c, "yyyyy", "yyyyy-token")
+	cp := &copier{
+		recursive: true,
+		blocks:    map[string]string{},
+		copied:    map[string]string{},
+		logf:      c.Logf,
+	}
+	var err error
+	cp.src, cp.srcKC, err = copyClients(s.cluster, "yyyyy")
+	c.Assert(err, check.IsNil)
+	cp.dst, cp.dstKC, err = copyClients(s.cluster, "zzzzz")
+	c.Assert(err, check.IsNil)
+	return cp
+}
+
+func (s *CopySuite) TestCopyClientsToken(c *check.C) {
+	// The local token must not be sent to the remote cluster.
+	_, _, err := copyClients(s.cluster, "yyyyy")
+	c.Check(err, check.ErrorMatches, `no API token for cluster yyyyy .*/\.config/arvados/yyyyy\.conf.*`)
+	c.Check(s.src.tokens["zzzzz-token"], check.Equals, false)
+
+	s.writeSettings(c, "yyyyy", "yyyyy-token")
+	client, _, err := copyClients(s.cluster, "yyyyy")
+	c.Assert(err, check.IsNil)
+	c.Check(client.AuthToken, check.Equals, "yyyyy-token")
+
+	client, _, err = copyClients(s.cluster, "zzzzz")
+	c.Assert(err, check.IsNil)
+	c.Check(client.AuthToken, check.Equals, "zzzzz-token")
+
+	s.writeSettings(c, "zzzzz", "zzzzz-token2")
+	client, _, err = copyClients(s.cluster, "zzzzz")
+	c.Assert(err, check.IsNil)
+	c.Check(client.AuthToken, check.Equals, "zzzzz-token2")
+
+	_, _, err = copyClients(s.cluster, "xxxxx")
+	c.Check(err, check.ErrorMatches, `cluster xxxxx is not listed in RemoteClusters`)
+}
+
+func (s *CopySuite) TestCopyCollection(c *check.C) {
+	cp := s.copier(c)
+	coll := s.src.addCollection("yyyyy-j7d0g-000000000000000", "foo", "bar")
+	owner := "zzzzz-j7d0g-000000000000000"
+
+	uuid, err := cp.copyObject(coll["uuid"].(string), owner)
+	c.Assert(err, check.IsNil)
+	copied := s.dst.objects[uuid]
+	c.Assert(copied, check.NotNil)
+	c.Check(copied["owner_uuid"], check.Equals, owner)
+	c.Check(copied["portable_data_hash"], check.Equals, coll["portable_data_hash"])
+	c.Check(copied["manifest_text"], check.Matches, `(?ms).* [0-9a-f]{32}\+3\+Azzzzz@0 .*`)
+	c.Check(s.dst.blocks, check.HasLen, 2)
+	c.Check(s.src.tokens, check.DeepEquals, map[string]bool{"yyyyy-token": true})
+	c.Check(s.dst.tokens, check.DeepEquals, map[string]bool{"zzzzz-token": true})
+
+	// An identical collection already exists in the
+	// destination project, so no copy is made.
+	cp.copied = map[string]string{}
+	again, err := cp.copyObject(coll["portable_data_hash"].(string), owner)
+	c.Check(err, check.IsNil)
+	c.Check(again, check.Equals, uuid)
+	c.Check(s.dst.countRequests("POST /arvados/v1/collections"), check.Equals, 1)
+
+	cp.copied = map[string]string{}
+	cp.force = true
+	again, err = cp.copyObject(coll["uuid"].(string), owner)
+	c.Check(err, check.IsNil)
+	c.Check(again, check.Not(check.Equals), uuid)
+	c.Check(s.dst.countRequests("POST /arvados/v1/collections"), check.Equals, 2)
+}
+
+func (s *CopySuite) TestCopyCollectionWrongPDH(c *check.C) {
+	cp := s.copier(c)
+	coll := s.src.addCollection("yyyyy-j7d0g-000000000000000", "foo")
+	s.dst.badPDH = true
+	_, err := cp.copyObject(coll["uuid"].(string), "zzzzz-j7d0g-000000000000000")
+	c.Check(err, check.ErrorMatches, `copy of collection .* has portable data hash d41d8cd98f00b204e9800998ecf8427e\+0, expected .*`)
+}
+
+func (s *CopySuite) TestCopyWorkflow(c *check.C) {
+	cp := s.copier(c)
+	srcOwner := "yyyyy-j7d0g-000000000000000"
+	tool := s.src.addCollection(srcOwner, "tool")
+	input := s.src.addCollection(srcOwner, "input")
+	image := s.src.addCollection(srcOwner, "image")
+	for _, link := range []map[string]interface{}{
+		{"link_class": "docker_image_repo+tag", "name": "arvados/jobs:2.0"},
+		{"link_class": "docker_image_hash", "name": "sha256:abcde"},
+	} {
+		link["head_uuid"] = image["uuid"]
+		link["owner_uuid"] = srcOwner
+		s.src.add("links", link)
+	}
+	wf := s.src.add("workflows", map[string]interface{}{
+		"owner_uuid": srcOwner,
+		"name":       "wf",
+		"definition": fmt.Sprintf(`{"cwlVersion": "v1.0", "class": "Workflow", "hints": [{"class": "DockerRequirement", "dockerPull": "arvados/jobs:2.0"}], "steps": [{"run": "keep:%s/tool.cwl", "in": {"x": {"default": {"class": "File", "location": "keep:%s/file0"}}}}]}`, tool["uuid"], input["portable_data_hash"]),
+	})
+	owner := "zzzzz-j7d0g-000000000000000"
+
+	uuid, err := cp.copyObject(wf["uuid"].(string), owner)
+	c.Assert(err, check.IsNil)
+	copied := s.dst.objects[uuid]
+	c.Assert(copied, check.NotNil)
+	c.Check(copied["name"], check.Equals, "wf")
+	c.Check(s.dst.uuids("collections"), check.HasLen, 3)
+	newTool := cp.copied[owner+"/"+tool["uuid"].(string)]
+	c.Check(newTool, check.Matches, `zzzzz-4zz18-.*`)
+	c.Check(copied["definition"], check.Matches, `.*"keep:`+newTool+`/tool.cwl".*`)
+	c.Check(copied["definition"], check.Matches, `.*"keep:`+regexp.QuoteMeta(input["portable_data_hash"].(string))+`/file0".*`)
+
+	newImage := cp.copied[owner+"/"+image["uuid"].(string)]
+	var linkNames []string
+	for _, linkUUID := range s.dst.uuids("links") {
+		link := s.dst.objects[linkUUID]
+		c.Check(link["head_uuid"], check.Equals, newImage)
+		c.Check(link["owner_uuid"], check.Equals, owner)
+		linkNames = append(linkNames, link["link_class"].(string)+" "+link["name"].(string))
+	}
+	sort.Strings(linkNames)
+	c.Check(linkNames, check.DeepEquals, []string{"docker_image_hash sha256:abcde", "docker_image_repo+tag arvados/jobs:2.0"})
+
+	// Copying the image again doesn't duplicate the links.
+	err = cp.copyDockerImage("arvados/jobs:2.0", owner)
+	c.Check(err, check.IsNil)
+	c.Check(s.dst.uuids("links"), check.HasLen, 2)
+
+	err = cp.copyDockerImage("arvados/jobs:1.0", owner)
+	c.Check(err, check.ErrorMatches, `Docker image arvados/jobs:1.0 not found on source cluster`)
+}
+
+func (s *CopySuite) TestCopyProject(c *check.C) {
+	cp := s.copier(c)
+	proj := s.src.add("groups", map[string]interface{}{
+		"owner_uuid":  "yyyyy-tpzed-000000000000000",
+		"name":        "proj",
+		"group_class": "project",
+	})
+	sub := s.src.add("groups", map[string]interface{}{
+		"owner_uuid":  proj["uuid"],
+		"name":        "sub",
+		"group_class": "project",
+	})
+	// More collections than fit on one page of list results.
+	for _, data := range []string{"foo", "bar", "baz"} {
+		s.src.addCollection(proj["uuid"].(string), data)
+	}
+	s.src.addCollection(sub["uuid"].(string), "waz")
+	s.src.add("workflows", map[string]interface{}{
+		"owner_uuid": proj["uuid"],
+		"name":       "wf",
+		"definition": `{"cwlVersion": "v1.0", "class": "Workflow"}`,
+	})
+	owner := "zzzzz-j7d0g-000000000000000"
+
+	uuid, err := cp.copyObject(proj["uuid"].(string), owner)
+	c.Assert(err, check.IsNil)
+	c.Check(s.dst.objects[uuid]["name"], check.Equals, "proj")
+	c.Check(s.dst.objects[uuid]["owner_uuid"], check.Equals, owner)
+	c.Check(s.dst.uuids("groups"), check.HasLen, 2)
+	c.Check(s.dst.uuids("collections"), check.HasLen, 4)
+	c.Check(s.dst.uuids("workflows"), check.HasLen, 1)
+	for _, collUUID := range s.dst.uuids("collections") {
+		owner := s.dst.objects[collUUID]["owner_uuid"].(string)
+		c.Check(s.dst.objects[owner]["group_class"], check.Equals, "project")
+	}
+
+	cp.recursive = false
+	_, err = cp.copyObject(proj["uuid"].(string), owner)
+	c.Assert(err, check.IsNil)
+	c.Check(s.dst.uuids("groups"), check.HasLen, 3)
+	c.Check(s.dst.uuids("collections"), check.HasLen, 7)
+
+	group := s.src.add("groups", map[string]interface{}{"group_class": "role"})
+	_, err = cp.copyObject(group["uuid"].(string), owner)
+	c.Check(err, check.ErrorMatches, `cannot copy .*: group_class is "role", not "project"`)
+}
+
+func (s *CopySuite) TestRewriteManifest(c *check.C) {
+	manifest := ". acbd18db4cc2f85cedef654fccc4a4d8+3+Aabc@123 37b51d194a7513e45b56f6524f2d51f2+3+Rzzzzz-abc 0:3:foo 3:3:bar\n" +
+		"./dir\\040name acbd18db4cc2f85cedef654fccc4a4d8+3+Aabc@123 0:3:foo\n"
+	var seen []string
+	out, err := rewriteManifest(manifest, func(loc string) (string, error) {
+		seen = append(seen, loc)
+		return loc[:34] + "+Anew@456", nil
+	})
+	c.Check(err, check.IsNil)
+	c.Check(seen, check.DeepEquals, []string{
+		"acbd18db4cc2f85cedef654fccc4a4d8+3+Aabc@123",
+		"37b51d194a7513e45b56f6524f2d51f2+3+Rzzzzz-abc",
+		"acbd18db4cc2f85cedef654fccc4a4d8+3+Aabc@123",
+	})
+	c.Check(out, check.Equals, ". acbd18db4cc2f85cedef654fccc4a4d8+3+Anew@456 37b51d194a7513e45b56f6524f2d51f2+3+Anew@456 0:3:foo 3:3:bar\n"+
+		"./dir\\040name acbd18db4cc2f85cedef654fccc4a4d8+3+Anew@456 0:3:foo\n")
+}
+
+func (s *CopySuite) TestWorkflowDependencies(c *check.C) {
+	definition := `
+cwlVersion: v1.0
+$graph:
+- class: Workflow
+  id: "#main"
+  hints:
+    DockerRequirement:
+      dockerPull: arvados/jobs:2.0
+  inputs:
+    ref:
+      type: File
+      default:
+        class: File
+        location: keep:acbd18db4cc2f85cedef654fccc4a4d8+3/foo
+  steps:
+    - run: keep:zzzzz-4zz18-fy296fx3hot09f7/tool.cwl
+      requirements:
+        - class: DockerRequirement
+          dockerPull: debian
+    - run: keep:acbd18db4cc2f85cedef654fccc4a4d8+3/other.cwl
+`
+	refs, images, err := workflowDependencies(definition)
+	c.Check(err, check.IsNil)
+	c.Check(refs, check.HasLen, 2)
+	c.Check(strings.Join(refs, " "), check.Matches, `.*acbd18db4cc2f85cedef654fccc4a4d8\+3.*`)
+	c.Check(strings.Join(refs, " "), check.Matches, `.*zzzzz-4zz18-fy296fx3hot09f7.*`)
+	c.Check(images, check.HasLen, 2)
+	c.Check(strings.Join(images, " "), check.Matches, `.*arvados/jobs:2.0.*`)
+	c.Check(strings.Join(images, " "), check.Matches, `.*debian.*`)
+
+	_, _, err = workflowDependencies("{")
+	c.Check(err, check.NotNil)
+}
+
+func (s *CopySuite) TestDockerRepoTag(c *check.C) {
+	for _, trial := range []struct{ image, repo, tag string }{
+		{"debian", "debian", "latest"},
+		{"debian:10", "debian", "10"},
+		{"arvados/jobs:2.0", "arvados/jobs", "2.0"},
+		{"registry.example:5000/foo", "registry.example:5000/foo", "latest"},
+		{"registry.example:5000/foo:bar", "registry.example:5000/foo", "bar"},
+	} {
+		repo, tag := dockerRepoTag(trial.image)
+		c.Check(repo, check.Equals, trial.repo)
+		c.Check(tag, check.Equals, trial.tag)
+	}
+}
+
+func (s *CopySuite) TestReadSettingsFile(c *check.C) {
+	fnm := filepath.Join(c.MkDir(), "zzzzz.conf")
+	err := ioutil.WriteFile(fnm, []byte("# comment\nARVADOS_API_HOST=zzzzz.example\n\nARVADOS_API_TOKEN = abc=def\n"), 0600)
+	c.Assert(err, check.IsNil)
+	settings, err := readSettingsFile(fnm)
+	c.Check(err, check.IsNil)
+	c.Check(settings, check.DeepEquals, map[string]string{
+		"ARVADOS_API_HOST":  "zzzzz.example",
+		"ARVADOS_API_TOKEN": "abc=def",
+	})
+}
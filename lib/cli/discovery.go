@@ -0,0 +1,86 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+)
+
+// discoveryCacheTTL is how long a cached discovery document is used
+// before fetching a new one.
+const discoveryCacheTTL = 24 * time.Hour
+
+// loadDiscoveryDocument returns the API server's discovery document,
+// using a copy cached in ~/.cache/arvados/discovery-{host}.json if
+// it is less than discoveryCacheTTL old.
+//
+// Errors reading or writing the cache file are ignored.
+func loadDiscoveryDocument(client *arvados.Client) (*arvados.DiscoveryDocument, error) {
+	var cacheFile string
+	if dir, err := os.UserCacheDir(); err == nil {
+		cacheFile = filepath.Join(dir, "arvados", "discovery-"+strings.Replace(client.APIHost, "/", "_", -1)+".json")
+		if fi, err := os.Stat(cacheFile); err == nil && time.Since(fi.ModTime()) < discoveryCacheTTL {
+			if buf, err := ioutil.ReadFile(cacheFile); err == nil {
+				var dd arvados.DiscoveryDocument
+				if json.Unmarshal(buf, &dd) == nil && len(dd.Resources) > 0 {
+					return &dd, nil
+				}
+			}
+		}
+	}
+
+	var raw json.RawMessage
+	err := client.RequestAndDecode(&raw, "GET", "discovery/v1/apis/arvados/v1/rest", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var dd arvados.DiscoveryDocument
+	err = json.Unmarshal(raw, &dd)
+	if err != nil {
+		return nil, err
+	}
+	if cacheFile != "" {
+		if os.MkdirAll(filepath.Dir(cacheFile), 0700) == nil {
+			tmp, err := ioutil.TempFile(filepath.Dir(cacheFile), ".discovery-")
+			if err == nil {
+				_, err = tmp.Write(raw)
+				if err == nil {
+					err = tmp.Close()
+				} else {
+					tmp.Close()
+				}
+				if err == nil {
+					err = os.Rename(tmp.Name(), cacheFile)
+				}
+				if err != nil {
+					os.Remove(tmp.Name())
+				}
+			}
+		}
+	}
+	return &dd, nil
+}
+
+// findResource returns the model name (e.g., "ContainerRequest") and
+// API resource for the given object type, which may be given as a
+// model name, a snake_case model name ("container_request"), or a
+// resource name ("container_requests").
+func findResource(dd *arvados.DiscoveryDocument, objType string) (string, arvados.Resource, error) {
+	for name, rsc := range dd.Resources {
+		model := rsc.Methods["get"].Response.Ref
+		if model != "" && (objType == name || objType == model || snakeToCamel(objType) == model) {
+			return model, rsc, nil
+		}
+	}
+	return "", arvados.Resource{}, fmt.Errorf("unknown object type %q", objType)
+}
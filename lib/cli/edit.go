@@ -0,0 +1,327 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+
+	"git.arvados.org/arvados.git/lib/cmd"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/ghodss/yaml"
+)
+
+var (
+	Create cmd.Handler = createCmd{}
+	Edit   cmd.Handler = editCmd{}
+)
+
+var errEditAborted = errors.New("edit aborted")
+
+// createCmd opens an editor on an empty object of the given type,
+// and creates the object on the server when the editor exits.
+type createCmd struct{}
+
+func (createCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var err error
+	defer func() {
+		if err != nil && err != errEditAborted {
+			fmt.Fprintf(stderr, "%s\n", err)
+		}
+	}()
+
+	flags, opts := legacyFlagSet()
+	projectUUID := flags.String("project-uuid", "", "Create the new object in the specified project")
+	flags.SetOutput(stderr)
+	err = flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if len(flags.Args()) != 1 {
+		fmt.Fprintf(stderr, "usage of %s:\n", prog)
+		flags.PrintDefaults()
+		return 2
+	}
+	if opts.Short {
+		opts.Format = "uuid"
+	}
+
+	client := arvados.NewClientFromEnv()
+	dd, err := loadDiscoveryDocument(client)
+	if err != nil {
+		return 1
+	}
+	model, path, err := createPath(dd, flags.Args()[0])
+	if err != nil {
+		return 2
+	}
+
+	obj := map[string]interface{}{}
+	if *projectUUID != "" {
+		obj["owner_uuid"] = *projectUUID
+	}
+	var created map[string]interface{}
+	err = editObject(obj, opts.Format, stdin, stderr, func(edited map[string]interface{}) error {
+		if opts.DryRun {
+			created = edited
+			return nil
+		}
+		return client.RequestAndDecode(&created, "POST", path, nil, map[string]interface{}{
+			camelToSnake(model): edited,
+		})
+	})
+	if err != nil {
+		return 1
+	}
+	err = printObject(stdout, created, opts.Format)
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// editCmd opens an editor on an existing object, and updates the
+// fields that were changed when the editor exits.
+type editCmd struct{}
+
+func (editCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var err error
+	defer func() {
+		if err != nil && err != errEditAborted {
+			fmt.Fprintf(stderr, "%s\n", err)
+		}
+	}()
+
+	flags, opts := legacyFlagSet()
+	flags.SetOutput(stderr)
+	err = flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if len(flags.Args()) < 1 {
+		fmt.Fprintf(stderr, "usage of %s:\n", prog)
+		flags.PrintDefaults()
+		return 2
+	}
+	if opts.Short {
+		opts.Format = "uuid"
+	}
+	uuid, fields := flags.Args()[0], flags.Args()[1:]
+
+	client := arvados.NewClientFromEnv()
+	path, err := client.PathForUUID("show", uuid)
+	if err != nil {
+		return 1
+	}
+	kind, err := client.KindForUUID(uuid)
+	if err != nil {
+		return 1
+	}
+	var obj map[string]interface{}
+	err = client.RequestAndDecode(&obj, "GET", path, nil, nil)
+	if err != nil {
+		err = fmt.Errorf("GET %s: %s", path, err)
+		return 1
+	}
+	if len(fields) > 0 {
+		sel := map[string]interface{}{}
+		for _, f := range fields {
+			v, ok := obj[f]
+			if !ok {
+				err = fmt.Errorf("%s has no field %q", uuid, f)
+				return 2
+			}
+			sel[f] = v
+		}
+		obj = sel
+	}
+
+	updated := obj
+	err = editObject(obj, opts.Format, stdin, stderr, func(edited map[string]interface{}) error {
+		changed := changedFields(obj, edited)
+		if len(changed) == 0 {
+			fmt.Fprintln(stderr, "Object unchanged, did not update.")
+			return nil
+		}
+		if opts.DryRun {
+			updated = edited
+			return nil
+		}
+		return client.RequestAndDecode(&updated, "PATCH", path, nil, map[string]interface{}{
+			camelToSnake(strings.TrimPrefix(kind, "arvados#")): changed,
+		})
+	})
+	if err != nil {
+		return 1
+	}
+	err = printObject(stdout, updated, opts.Format)
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// createPath returns the model name (e.g., "ContainerRequest") and
+// API path for creating an object of the given type (see
+// findResource).
+func createPath(dd *arvados.DiscoveryDocument, objType string) (string, string, error) {
+	model, rsc, err := findResource(dd, objType)
+	if err != nil {
+		return "", "", err
+	}
+	m, ok := rsc.Methods["create"]
+	if !ok {
+		return "", "", fmt.Errorf("cannot create objects of type %q", objType)
+	}
+	return model, strings.TrimPrefix(dd.BasePath+m.Path, "/"), nil
+}
+
+// camelToSnake converts "ContainerRequest" or "containerRequest" to
+// "container_request".
+func camelToSnake(s string) string {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' {
+			if i > 0 {
+				out = append(out, '_')
+			}
+			c += 'a' - 'A'
+		}
+		out = append(out, c)
+	}
+	return string(out)
+}
+
+// snakeToCamel converts "container_request" to "ContainerRequest".
+func snakeToCamel(s string) string {
+	var out []byte
+	upper := true
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' {
+			upper = true
+			continue
+		}
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		out = append(out, c)
+	}
+	return string(out)
+}
+
+// changedFields returns the fields of edited whose values differ
+// from orig. Fields that were deleted in edited are ignored.
+func changedFields(orig, edited map[string]interface{}) map[string]interface{} {
+	changed := map[string]interface{}{}
+	for k, v := range edited {
+		if ov, ok := orig[k]; !ok || !reflect.DeepEqual(ov, v) {
+			changed[k] = v
+		}
+	}
+	return changed
+}
+
+// editObject writes obj to a temporary file in the given format
+// ("yaml" or "json"), opens it in the user's editor ($VISUAL,
+// $EDITOR, or nano), and passes the edited object to save. If the
+// edited file can't be parsed, or save returns an error, the user
+// is asked whether to edit the file again.
+func editObject(obj map[string]interface{}, format string, stdin io.Reader, stderr io.Writer, save func(map[string]interface{}) error) error {
+	var buf []byte
+	var err error
+	ext := ".json"
+	if format == "yaml" {
+		ext = ".yaml"
+		buf, err = yaml.Marshal(obj)
+	} else {
+		buf, err = json.MarshalIndent(obj, "", "  ")
+		buf = append(buf, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "arvados-client-edit-*"+ext)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(buf)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "nano"
+	}
+	answers := bufio.NewReader(stdin)
+	for {
+		err = runEditor(editor, f.Name(), stdin, stderr)
+		if err == nil {
+			err = func() error {
+				buf, err := ioutil.ReadFile(f.Name())
+				if err != nil {
+					return err
+				}
+				var edited map[string]interface{}
+				if format == "yaml" {
+					err = yaml.Unmarshal(buf, &edited)
+				} else {
+					err = json.Unmarshal(buf, &edited)
+				}
+				if err != nil {
+					return fmt.Errorf("parse error: %s", err)
+				}
+				return save(edited)
+			}()
+		}
+		if err == nil {
+			return nil
+		}
+		fmt.Fprintf(stderr, "%s\nEdit again? [Y/n] ", err)
+		answer, rerr := answers.ReadString('\n')
+		if answer = strings.TrimSpace(answer); strings.HasPrefix(strings.ToLower(answer), "n") || (rerr != nil && answer == "") {
+			fmt.Fprintln(stderr)
+			return errEditAborted
+		}
+	}
+}
+
+// runEditor runs the given editor command (which may include
+// arguments) on the named file.
+func runEditor(editor, fnm string, stdin io.Reader, stderr io.Writer) error {
+	cmd := exec.Command("/bin/sh", "-c", editor+` "$1"`, "sh", fnm)
+	if f, ok := stdin.(*os.File); ok {
+		// Give the editor direct access to the terminal. Any
+		// other reader is left alone so the "edit again?"
+		// prompt can read from it.
+		cmd.Stdin = f
+	}
+	// Keep stdout clean for the resulting object.
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("editor %q failed: %s", editor, err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&EditSuite{})
+
+type EditSuite struct{}
+
+func (s *EditSuite) TearDownTest(c *check.C) {
+	os.Unsetenv("VISUAL")
+	os.Unsetenv("EDITOR")
+}
+
+// setEditor sets $EDITOR to a shell script with the given body. The
+// file being edited is "$1".
+func (s *EditSuite) setEditor(c *check.C, script string) {
+	fnm := filepath.Join(c.MkDir(), "editor")
+	err := ioutil.WriteFile(fnm, []byte("#!/bin/sh\n"+script+"\n"), 0755)
+	c.Assert(err, check.IsNil)
+	os.Unsetenv("VISUAL")
+	os.Setenv("EDITOR", fnm)
+}
+
+func (s *EditSuite) TestNameConversions(c *check.C) {
+	c.Check(camelToSnake("ContainerRequest"), check.Equals, "container_request")
+	c.Check(camelToSnake("containerRequest"), check.Equals, "container_request")
+	c.Check(camelToSnake("Collection"), check.Equals, "collection")
+	c.Check(snakeToCamel("container_request"), check.Equals, "ContainerRequest")
+	c.Check(snakeToCamel("collection"), check.Equals, "Collection")
+}
+
+func (s *EditSuite) TestCreatePath(c *check.C) {
+	dd := &arvados.DiscoveryDocument{
+		BasePath: "/arvados/v1/",
+		Resources: map[string]arvados.Resource{
+			"container_requests": {Methods: map[string]arvados.ResourceMethod{
+				"get":    {Path: "container_requests/{uuid}", Response: arvados.MethodResponse{Ref: "ContainerRequest"}},
+				"create": {Path: "container_requests"},
+			}},
+			"logs": {Methods: map[string]arvados.ResourceMethod{
+				"get": {Path: "logs/{uuid}", Response: arvados.MethodResponse{Ref: "Log"}},
+			}},
+		},
+	}
+	for _, objType := range []string{"container_request", "ContainerRequest", "container_requests"} {
+		model, path, err := createPath(dd, objType)
+		c.Check(err, check.IsNil)
+		c.Check(model, check.Equals, "ContainerRequest")
+		c.Check(path, check.Equals, "arvados/v1/container_requests")
+	}
+	_, _, err := createPath(dd, "log")
+	c.Check(err, check.ErrorMatches, `cannot create.*`)
+	_, _, err = createPath(dd, "widget")
+	c.Check(err, check.ErrorMatches, `unknown object type.*`)
+}
+
+func (s *EditSuite) TestChangedFields(c *check.C) {
+	orig := map[string]interface{}{
+		"name":       "foo",
+		"properties": map[string]interface{}{"a": "b"},
+		"replicas":   float64(2),
+	}
+	edited := map[string]interface{}{
+		"name":        "foo",
+		"properties":  map[string]interface{}{"a": "c"},
+		"description": "new",
+	}
+	c.Check(changedFields(orig, edited), check.DeepEquals, map[string]interface{}{
+		"properties":  map[string]interface{}{"a": "c"},
+		"description": "new",
+	})
+	c.Check(changedFields(orig, orig), check.HasLen, 0)
+}
+
+func (s *EditSuite) TestEditObject(c *check.C) {
+	for _, format := range []string{"json", "yaml"} {
+		s.setEditor(c, `sed -i -e s/foo/bar/ "$1"`)
+		var saved map[string]interface{}
+		err := editObject(map[string]interface{}{"name": "foo", "n": 3}, format, strings.NewReader(""), bytes.NewBuffer(nil), func(edited map[string]interface{}) error {
+			saved = edited
+			return nil
+		})
+		c.Check(err, check.IsNil)
+		c.Check(saved, check.DeepEquals, map[string]interface{}{"name": "bar", "n": float64(3)})
+	}
+}
+
+func (s *EditSuite) TestEditAgain(c *check.C) {
+	// First edit produces invalid JSON; second edit fixes it.
+	s.setEditor(c, `if grep -q broken "$1"; then echo '{"name":"fixed"}' >"$1"; else echo '{broken' >"$1"; fi`)
+	stderr := bytes.NewBuffer(nil)
+	var saved map[string]interface{}
+	err := editObject(map[string]interface{}{"name": "foo"}, "json", strings.NewReader("y\n"), stderr, func(edited map[string]interface{}) error {
+		saved = edited
+		return nil
+	})
+	c.Check(err, check.IsNil)
+	c.Check(stderr.String(), check.Matches, `(?ms)parse error.*Edit again\? \[Y/n\] `)
+	c.Check(saved, check.DeepEquals, map[string]interface{}{"name": "fixed"})
+
+	// Save fails, user declines to edit again.
+	s.setEditor(c, `true`)
+	stderr.Reset()
+	err = editObject(map[string]interface{}{"name": "foo"}, "json", strings.NewReader("n\n"), stderr, func(map[string]interface{}) error {
+		return errors.New("permission denied")
+	})
+	c.Check(err, check.Equals, errEditAborted)
+	c.Check(stderr.String(), check.Matches, `(?ms)permission denied\nEdit again\? \[Y/n\] \n`)
+}
+
+func (s *EditSuite) TestEditCollection(c *check.C) {
+	defer arvados.NewClientFromEnv().RequestAndDecode(nil, "POST", "database/reset", nil, nil)
+	s.setEditor(c, `sed -i -e 's/"name": ".*"/"name": "edited by test"/' "$1"`)
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	exited := Edit.RunCommand("arvados-client edit", []string{arvadostest.FooCollection, "name", "description"}, strings.NewReader(""), stdout, stderr)
+	c.Check(stderr.String(), check.Equals, "")
+	c.Check(exited, check.Equals, 0)
+	c.Check(stdout.String(), check.Matches, `(?ms).*"name": "edited by test".*`)
+}
@@ -7,7 +7,6 @@ package cli
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os/exec"
 	"strings"
 	"syscall"
@@ -16,58 +15,18 @@ import (
 )
 
 var (
-	Create = rubyArvCmd{"create"}
-	Edit   = rubyArvCmd{"edit"}
-
-	Copy = externalCmd{"arv-copy"}
-	Tag  = externalCmd{"arv-tag"}
-	Ws   = externalCmd{"arv-ws"}
+	Tag = externalCmd{"arv-tag"}
+	Ws  = externalCmd{"arv-ws"}
 
 	Keep = cmd.Multi(map[string]cmd.Handler{
-		"get":       externalCmd{"arv-get"},
-		"put":       externalCmd{"arv-put"},
-		"ls":        externalCmd{"arv-ls"},
+		"get":       keepGet,
+		"put":       keepPut,
+		"ls":        keepLs,
 		"normalize": externalCmd{"arv-normalize"},
 		"docker":    externalCmd{"arv-keepdocker"},
 	})
-	// user, group, container, specimen, etc.
-	APICall = apiCallCmd{}
 )
 
-// When using the ruby "arv" command, flags must come before the
-// subcommand: "arv --format=yaml get foo" works, but "arv get
-// --format=yaml foo" does not work.
-func legacyFlagsToFront(subcommand string, argsin []string) (argsout []string) {
-	flags, _ := LegacyFlagSet()
-	flags.SetOutput(ioutil.Discard)
-	flags.Parse(argsin)
-	narg := flags.NArg()
-	argsout = append(argsout, argsin[:len(argsin)-narg]...)
-	argsout = append(argsout, subcommand)
-	argsout = append(argsout, argsin[len(argsin)-narg:]...)
-	return
-}
-
-type apiCallCmd struct{}
-
-func (cmd apiCallCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
-	split := strings.Split(prog, " ")
-	if len(split) < 2 {
-		fmt.Fprintf(stderr, "internal error: no api model in %q\n", prog)
-		return 2
-	}
-	model := split[len(split)-1]
-	return externalCmd{"arv"}.RunCommand("arv", legacyFlagsToFront(model, args), stdin, stdout, stderr)
-}
-
-type rubyArvCmd struct {
-	subcommand string
-}
-
-func (rc rubyArvCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
-	return externalCmd{"arv"}.RunCommand("arv", legacyFlagsToFront(rc.subcommand, args), stdin, stdout, stderr)
-}
-
 type externalCmd struct {
 	prog string
 }
@@ -90,9 +49,7 @@ func (ec externalCmd) RunCommand(prog string, args []string, stdin io.Reader, st
 		return 1
 	case *exec.Error:
 		fmt.Fprintln(stderr, err)
-		if ec.prog == "arv" {
-			fmt.Fprint(stderr, rubyInstallHints)
-		} else if strings.HasPrefix(ec.prog, "arv-") {
+		if strings.HasPrefix(ec.prog, "arv-") {
 			fmt.Fprint(stderr, pythonInstallHints)
 		}
 		return 1
@@ -103,12 +60,6 @@ func (ec externalCmd) RunCommand(prog string, args []string, stdin io.Reader, st
 }
 
 var (
-	rubyInstallHints = `
-Note: This subcommand uses the arvados-cli Ruby gem. If that is not
-installed, try "gem install arvados-cli", or see
-https://doc.arvados.org/install for more details.
-
-`
 	pythonInstallHints = `
 Note: This subcommand uses the "arvados" Python module. If that is
 not installed, try:
@@ -19,6 +19,12 @@ type LegacyFlagValues struct {
 }
 
 func LegacyFlagSet() (cmd.FlagSet, *LegacyFlagValues) {
+	return legacyFlagSet()
+}
+
+// legacyFlagSet returns the same flags as LegacyFlagSet, as a
+// *getopt.FlagSet so callers can add their own.
+func legacyFlagSet() (*getopt.FlagSet, *LegacyFlagValues) {
 	values := &LegacyFlagValues{Format: "json"}
 	flags := getopt.NewFlagSet("", flag.ContinueOnError)
 	flags.BoolVar(&values.DryRun, "dry-run", false, "Don't actually do anything")
@@ -54,22 +54,32 @@ func (getCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, st
 		err = fmt.Errorf("GET %s: %s", path, err)
 		return 1
 	}
-	if opts.Format == "yaml" {
+	err = printObject(stdout, obj, opts.Format)
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// printObject writes obj to w in the given format: "json", "yaml",
+// or "uuid".
+func printObject(w io.Writer, obj map[string]interface{}, format string) error {
+	var err error
+	if format == "yaml" {
 		var buf []byte
 		buf, err = yaml.Marshal(obj)
 		if err == nil {
-			_, err = stdout.Write(buf)
+			_, err = w.Write(buf)
 		}
-	} else if opts.Format == "uuid" {
-		fmt.Fprintln(stdout, obj["uuid"])
+	} else if format == "uuid" {
+		_, err = fmt.Fprintln(w, obj["uuid"])
 	} else {
-		enc := json.NewEncoder(stdout)
+		enc := json.NewEncoder(w)
 		enc.SetIndent("", "  ")
 		err = enc.Encode(obj)
 	}
 	if err != nil {
-		err = fmt.Errorf("encoding: %s", err)
-		return 1
+		return fmt.Errorf("encoding: %s", err)
 	}
-	return 0
+	return nil
 }
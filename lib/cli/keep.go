@@ -0,0 +1,84 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+	"git.arvados.org/arvados.git/sdk/go/keepclient"
+)
+
+var (
+	keepSourceRe = regexp.MustCompile(`^(?:keep:)?([0-9a-z]{5}-4zz18-[0-9a-z]{15}|[0-9a-f]{32}\+[0-9]+(?:\+[^/]*)?)(?:/(.*))?$`)
+	manifestRe   = regexp.MustCompile(`[\000-\040:\s\\]`)
+)
+
+// keepClient is the subset of *keepclient.KeepClient needed to
+// load and save collection filesystems.
+type keepClient interface {
+	ReadAt(locator string, p []byte, off int) (int, error)
+	LocalLocator(locator string) (string, error)
+	PutB(p []byte) (string, int, error)
+}
+
+// keepClients returns an API client and a Keep client configured
+// from the environment.
+func keepClients() (*arvados.Client, *keepclient.KeepClient, error) {
+	client := arvados.NewClientFromEnv()
+	ac, err := arvadosclient.New(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	kc, err := keepclient.MakeKeepClient(ac)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, kc, nil
+}
+
+// parseKeepSource splits a source argument like
+// "keep:{uuid-or-pdh}/dir/file" into a collection ID and a path
+// within the collection. The returned path has no leading or
+// trailing slashes.
+func parseKeepSource(src string) (id, path string, err error) {
+	m := keepSourceRe.FindStringSubmatch(src)
+	if m == nil {
+		return "", "", fmt.Errorf("%q is not a collection UUID or portable data hash", src)
+	}
+	return m[1], strings.Trim(m[2], "/"), nil
+}
+
+// loadCollection retrieves the collection with the given UUID or
+// portable data hash.
+func loadCollection(client *arvados.Client, id string) (*arvados.Collection, error) {
+	var coll arvados.Collection
+	err := client.RequestAndDecode(&coll, "GET", "arvados/v1/collections/"+id, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving collection %s: %s", id, err)
+	}
+	return &coll, nil
+}
+
+// manifestEscape escapes a file or stream name for use in a manifest.
+func manifestEscape(s string) string {
+	return manifestRe.ReplaceAllStringFunc(s, func(seq string) string {
+		return fmt.Sprintf("\\%03o", byte(seq[0]))
+	})
+}
+
+// isTerminal returns true if w is a terminal device.
+func isTerminal(w interface{}) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
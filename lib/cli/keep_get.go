@@ -0,0 +1,285 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"crypto/md5"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"git.arvados.org/arvados.git/lib/cmd"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"rsc.io/getopt"
+)
+
+var keepGet cmd.Handler = keepGetCmd{}
+
+// keepGetCmd copies data from Keep to a local file or directory,
+// like the legacy arv-get tool.
+//
+// Every block is verified against the MD5 hash in its locator as it
+// is read (see keepclient.HashCheckingReader), and the size of each
+// file written is checked against the manifest.
+type keepGetCmd struct{}
+
+type keepGetter struct {
+	fs           arvados.CollectionFileSystem
+	stdout       io.Writer
+	stderr       io.Writer
+	force        bool
+	skipExisting bool
+	md5sum       bool
+	progress     bool
+	total        int64
+	done         int64
+}
+
+func (keepGetCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", prog, err)
+		}
+	}()
+
+	g := keepGetter{stdout: stdout, stderr: stderr}
+	flags := getopt.NewFlagSet(prog, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.BoolVar(&g.force, "f", false, "Overwrite existing files while writing.")
+	flags.BoolVar(&g.skipExisting, "skip-existing", false, "Skip files that already exist. The default behavior is to refuse to write *anything* if any of the output files already exist.")
+	flags.BoolVar(&g.md5sum, "md5sum", false, "Display the MD5 hash of each file as it is read from Keep.")
+	recursive := flags.Bool("r", false, "Retrieve all files in the specified collection/prefix. This is the default behavior if the \"locator\" argument ends with a forward slash.")
+	progress := flags.Bool("progress", isTerminal(stderr), "Display human-readable progress on stderr (default if stderr is a tty).")
+	noProgress := flags.Bool("no-progress", false, "Do not display human-readable progress on stderr.")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s [options] locator [destination]\n", prog)
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		return 2
+	}
+	if g.force && g.skipExisting {
+		err = errors.New("-f and --skip-existing are mutually exclusive")
+		return 2
+	}
+	g.progress = *progress && !*noProgress
+
+	src := flags.Arg(0)
+	id, srcPath, err := parseKeepSource(src)
+	if err != nil {
+		return 2
+	}
+	if strings.HasSuffix(src, "/") {
+		*recursive = true
+	}
+	dst := flags.Arg(1)
+
+	client, kc, err := keepClients()
+	if err != nil {
+		return 1
+	}
+	coll, err := loadCollection(client, id)
+	if err != nil {
+		return 1
+	}
+
+	if srcPath == "" && !*recursive {
+		// Just the collection ID: write the manifest.
+		if dst == "" {
+			dst = "-"
+		}
+		err = g.writeFile(dst, strings.NewReader(coll.ManifestText), int64(len(coll.ManifestText)), "")
+		if err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	g.fs, err = coll.FileSystem(client, kc)
+	if err != nil {
+		return 1
+	}
+	fi, err := g.fs.Stat("." + cleanDir(srcPath))
+	if err != nil {
+		err = fmt.Errorf("%s: %s", src, err)
+		return 1
+	}
+	if fi.IsDir() != *recursive {
+		if fi.IsDir() {
+			err = fmt.Errorf("%s is a directory (use -r or add a trailing slash to retrieve it)", src)
+		} else {
+			err = fmt.Errorf("%s is a file, not a directory", src)
+		}
+		return 2
+	}
+
+	if *recursive {
+		if dst == "" {
+			dst = "."
+		}
+		err = g.getDir("."+cleanDir(srcPath), dst)
+	} else {
+		if dst == "" {
+			dst = "-"
+		} else if dfi, err := os.Stat(dst); err == nil && dfi.IsDir() {
+			dst = filepath.Join(dst, path.Base(srcPath))
+		}
+		g.total = fi.Size()
+		err = g.getFile("."+cleanDir(srcPath), dst)
+	}
+	if g.progress && g.total > 0 {
+		fmt.Fprint(stderr, "\n")
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// getDir copies every file under srcDir to the corresponding path
+// under dstDir. Unless force or skipExisting is set, it refuses to
+// write anything if any of the destination files already exist.
+func (g *keepGetter) getDir(srcDir, dstDir string) error {
+	type todo struct{ src, dst string }
+	var todos []todo
+	var walk func(string) error
+	walk = func(dir string) error {
+		f, err := g.fs.Open(dir)
+		if err != nil {
+			return err
+		}
+		fis, err := f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+		for _, fi := range fis {
+			p := path.Join(dir, fi.Name())
+			if fi.IsDir() {
+				err = walk(p)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			rel := p
+			if base := path.Clean(srcDir); base != "." {
+				rel = strings.TrimPrefix(p, base+"/")
+			}
+			dst := filepath.Join(dstDir, filepath.FromSlash(rel))
+			if _, err := os.Stat(dst); err == nil {
+				if g.skipExisting {
+					continue
+				} else if !g.force {
+					return fmt.Errorf("local file %s already exists", dst)
+				}
+			}
+			todos = append(todos, todo{p, dst})
+			g.total += fi.Size()
+		}
+		return nil
+	}
+	err := walk(srcDir)
+	if err != nil {
+		return err
+	}
+	for _, t := range todos {
+		err = os.MkdirAll(filepath.Dir(t.dst), 0777)
+		if err != nil {
+			return err
+		}
+		err = g.getFile(t.src, t.dst)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getFile copies a single file from the collection to dst ("-"
+// means stdout).
+func (g *keepGetter) getFile(src, dst string) error {
+	f, err := g.fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return g.writeFile(dst, f, fi.Size(), src)
+}
+
+// writeFile copies size bytes from r to dst ("-" means stdout).
+func (g *keepGetter) writeFile(dst string, r io.Reader, size int64, name string) error {
+	if dst == "-" {
+		return g.copy(g.stdout, r, size, name)
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !g.force {
+		flag |= os.O_EXCL
+	}
+	f, err := os.OpenFile(dst, flag, 0666)
+	if os.IsExist(err) {
+		return fmt.Errorf("local file %s already exists", dst)
+	} else if err != nil {
+		return err
+	}
+	err = g.copy(f, r, size, name)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// copy copies size bytes from r to w, checking that exactly the
+// expected number of bytes were read. If md5sum is enabled, the MD5
+// hash of the data is printed to stdout, labeled with name.
+func (g *keepGetter) copy(w io.Writer, r io.Reader, size int64, name string) error {
+	hash := md5.New()
+	n, err := io.Copy(io.MultiWriter(w, hash, progressWriter{g}), r)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	if n != size {
+		return fmt.Errorf("%s: read %d bytes, expected %d", name, n, size)
+	}
+	if g.md5sum && name != "" {
+		fmt.Fprintf(g.stdout, "%x  ./%s\n", hash.Sum(nil), path.Clean(name))
+	}
+	return nil
+}
+
+// progressWriter updates the progress display as data is written.
+type progressWriter struct{ g *keepGetter }
+
+func (pw progressWriter) Write(p []byte) (int, error) {
+	before := pw.g.done
+	pw.g.done += int64(len(p))
+	if pw.g.progress && pw.g.total > 0 && (before>>20 != pw.g.done>>20 || pw.g.done == pw.g.total) {
+		fmt.Fprint(pw.g.stderr, humanProgress(pw.g.done, pw.g.total))
+	}
+	return len(p), nil
+}
+
+// humanProgress returns a progress indicator in the format used by
+// the legacy Python tools.
+func humanProgress(done, total int64) string {
+	return fmt.Sprintf("\r%dM / %dM %.1f%% ", done>>20, total>>20, 100*float64(done)/float64(total))
+}
@@ -0,0 +1,112 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+
+	"git.arvados.org/arvados.git/lib/cmd"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"rsc.io/getopt"
+)
+
+var keepLs cmd.Handler = keepLsCmd{}
+
+// keepLsCmd lists the files in a collection, in the same format as
+// the legacy arv-ls tool.
+type keepLsCmd struct{}
+
+func (keepLsCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", prog, err)
+		}
+	}()
+
+	flags := getopt.NewFlagSet(prog, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	sizes := flags.Bool("s", false, "List file sizes, in KiB.")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s [-s] locator\n", prog)
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	id, dir, err := parseKeepSource(flags.Arg(0))
+	if err != nil {
+		return 2
+	}
+	client, kc, err := keepClients()
+	if err != nil {
+		return 1
+	}
+	coll, err := loadCollection(client, id)
+	if err != nil {
+		return 1
+	}
+	fs, err := coll.FileSystem(client, kc)
+	if err != nil {
+		return 1
+	}
+	err = keepLsWalk(fs, "."+cleanDir(dir), *sizes, stdout)
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// cleanDir returns "" for the collection root, otherwise "/dir".
+func cleanDir(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	return "/" + dir
+}
+
+// keepLsWalk prints each file under dir (recursively, in name order)
+// as "./path/to/file", optionally preceded by its size in KiB.
+func keepLsWalk(fs arvados.CollectionFileSystem, dir string, sizes bool, w io.Writer) error {
+	f, err := fs.Open(dir)
+	if err != nil {
+		return err
+	}
+	fis, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	for _, fi := range fis {
+		p := path.Join(dir, fi.Name())
+		if fi.IsDir() {
+			err = keepLsWalk(fs, p, sizes, w)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if sizes {
+			_, err = fmt.Fprintf(w, "%10d ", (fi.Size()+1023)/1024)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = fmt.Fprintf(w, "./%s\n", p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,678 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/cmd"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"rsc.io/getopt"
+)
+
+var keepPut cmd.Handler = keepPutCmd{}
+
+// keepPutCmd copies local files and directories to Keep and saves
+// them as a collection, like the legacy arv-put tool.
+type keepPutCmd struct{}
+
+func (keepPutCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", prog, err)
+		}
+	}()
+
+	flags := getopt.NewFlagSet(prog, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	name := flags.String("name", "", "Save the collection with the specified name. Default: \"Saved at {time} by {username}@{host}\".")
+	projectUUID := flags.String("project-uuid", "", "Save the collection in the specified project, instead of your home project.")
+	pdh := flags.Bool("portable-data-hash", false, "Print the portable data hash instead of the collection UUID.")
+	stream := flags.Bool("stream", false, "Print the manifest text on stdout instead of saving a collection.")
+	filename := flags.String("filename", "stdin", "Use the given filename in the manifest when reading data from stdin (\"-\").")
+	threads := flags.Int("threads", 2, "Number of blocks to upload concurrently.")
+	progress := flags.Bool("progress", isTerminal(stderr), "Display human-readable progress on stderr (default if stderr is a tty).")
+	noProgress := flags.Bool("no-progress", false, "Do not display human-readable progress on stderr.")
+	resume := flags.Bool("resume", true, "Continue interrupted uploads from cached state.")
+	noResume := flags.Bool("no-resume", false, "Do not continue interrupted uploads from cached state.")
+	useCache := flags.Bool("cache", true, "Save upload state in a cache file for resuming.")
+	noCache := flags.Bool("no-cache", false, "Do not save upload state in a cache file for resuming.")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s [options] path [path ...]\n", prog)
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 2
+	}
+	if *threads < 1 {
+		err = errors.New("--threads must be at least 1")
+		return 2
+	}
+	if *stream && (*name != "" || *projectUUID != "" || *pdh) {
+		err = errors.New("--stream cannot be combined with --name, --project-uuid, or --portable-data-hash")
+		return 2
+	}
+
+	client, kc, err := keepClients()
+	if err != nil {
+		return 1
+	}
+
+	up := &uploader{
+		kc:        kc,
+		stdin:     stdin,
+		stderr:    stderr,
+		threads:   *threads,
+		blockSize: putBlockSize,
+		progress:  *progress && !*noProgress,
+	}
+	err = up.addPaths(flags.Args(), *filename)
+	if err != nil {
+		return 1
+	}
+	if *useCache && !*noCache {
+		up.cache, err = openPutCache(client.APIHost, up.files)
+		if err != nil {
+			return 1
+		}
+		defer up.cache.Close()
+		if *resume && !*noResume {
+			up.resume()
+		}
+	}
+	err = up.upload()
+	if err != nil {
+		return 1
+	}
+	manifest, err := up.manifest(client, kc)
+	if err != nil {
+		return 1
+	}
+	if *stream {
+		_, err = io.WriteString(stdout, manifest)
+		if err != nil {
+			return 1
+		}
+		up.cache.Remove()
+		return 0
+	}
+
+	if *name == "" {
+		*name = defaultCollectionName()
+	}
+	attrs := map[string]interface{}{
+		"name":          *name,
+		"manifest_text": manifest,
+	}
+	if *projectUUID != "" {
+		attrs["owner_uuid"] = *projectUUID
+	}
+	var coll arvados.Collection
+	err = client.RequestAndDecode(&coll, "POST", "arvados/v1/collections", nil, map[string]interface{}{
+		"ensure_unique_name": true,
+		"collection":         attrs,
+	})
+	if err != nil {
+		err = fmt.Errorf("error saving collection: %s", err)
+		return 1
+	}
+	up.cache.Remove()
+	fmt.Fprintf(stderr, "%s: Collection saved as '%s'\n", prog, coll.Name)
+	if *pdh {
+		fmt.Fprintln(stdout, coll.PortableDataHash)
+	} else {
+		fmt.Fprintln(stdout, coll.UUID)
+	}
+	return 0
+}
+
+// defaultCollectionName returns a name like the one the legacy
+// arv-put tool uses when --name is not given.
+func defaultCollectionName() string {
+	username := "unknown"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("Saved at %s by %s@%s", time.Now().Format("2006-01-02 15:04:05 MST"), username, hostname)
+}
+
+// putBlockSize is the size of the data blocks written to Keep. Data
+// from small files is packed together into shared blocks.
+const putBlockSize = 1 << 26
+
+// putSegment is a contiguous range of a local file that has been
+// written to Keep.
+type putSegment struct {
+	Locator string `json:"locator"`
+	Offset  int    `json:"offset"` // position within the block
+	Length  int    `json:"length"`
+}
+
+// putFile is a local file (or stdin) to be uploaded.
+type putFile struct {
+	localPath string // "" for stdin
+	collPath  string // path within the collection
+	size      int64
+	modTime   time.Time
+
+	// Segments that have been written to Keep, in file order.
+	// Together they cover the first committed bytes of the file.
+	segments  []putSegment
+	committed int64
+
+	// Segments that have been written to Keep but can't be
+	// appended to segments yet because an earlier segment is
+	// still being written, keyed by file offset.
+	pending map[int64]putSegment
+}
+
+// putBlock is a block of data to be written to Keep, along with the
+// file ranges it contains.
+type putBlock struct {
+	data   []byte
+	pieces []putPiece
+}
+
+type putPiece struct {
+	file       *putFile
+	fileOffset int64
+	blockOff   int
+	length     int
+}
+
+type uploader struct {
+	kc        keepPutter
+	stdin     io.Reader
+	stderr    io.Writer
+	threads   int
+	blockSize int
+	progress  bool
+	cache     *putCache
+	files     []*putFile
+
+	mtx   sync.Mutex
+	total int64
+	done  int64
+}
+
+type keepPutter interface {
+	PutB([]byte) (string, int, error)
+}
+
+// addPaths adds the files named by the command line arguments.
+//
+// A single directory argument, or a directory argument with a
+// trailing slash, contributes its contents to the top level of the
+// collection. Otherwise, each directory becomes a subdirectory
+// named after its basename.
+func (up *uploader) addPaths(args []string, stdinName string) error {
+	for _, arg := range args {
+		if arg == "-" {
+			up.files = append(up.files, &putFile{collPath: stdinName, size: -1})
+			continue
+		}
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			err = up.addFile(arg, filepath.Base(arg), fi)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		prefix := filepath.Base(filepath.Clean(arg))
+		if len(args) == 1 || strings.HasSuffix(arg, "/") {
+			prefix = ""
+		}
+		err = filepath.Walk(arg, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(arg, p)
+			if err != nil {
+				return err
+			}
+			return up.addFile(p, path.Join(prefix, filepath.ToSlash(rel)), fi)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	seen := map[string]bool{}
+	for _, f := range up.files {
+		if seen[f.collPath] {
+			return fmt.Errorf("more than one file would be saved as %q", f.collPath)
+		}
+		seen[f.collPath] = true
+	}
+	return nil
+}
+
+func (up *uploader) addFile(localPath, collPath string, fi os.FileInfo) error {
+	abs, err := filepath.Abs(localPath)
+	if err != nil {
+		return err
+	}
+	up.files = append(up.files, &putFile{
+		localPath: abs,
+		collPath:  collPath,
+		size:      fi.Size(),
+		modTime:   fi.ModTime(),
+	})
+	return nil
+}
+
+// resume restores the committed segments of unchanged files from
+// the cache.
+func (up *uploader) resume() {
+	for _, f := range up.files {
+		if f.localPath == "" {
+			continue
+		}
+		ent, ok := up.cache.Files[f.localPath]
+		if !ok || ent.Size != f.size || !ent.ModTime.Equal(f.modTime) {
+			continue
+		}
+		for _, seg := range ent.Segments {
+			if !putLocatorUsable(seg.Locator) {
+				// Signature is missing or about to
+				// expire; re-upload from here on.
+				break
+			}
+			f.segments = append(f.segments, seg)
+			f.committed += int64(seg.Length)
+		}
+		if len(f.segments) > 0 {
+			fmt.Fprintf(up.stderr, "resuming upload of %s at byte %d\n", f.localPath, f.committed)
+		}
+	}
+}
+
+// putLocatorUsable returns true if the given signed locator has at
+// least an hour left before its signature expires.
+func putLocatorUsable(loc string) bool {
+	for _, hint := range strings.Split(loc, "+")[2:] {
+		if !strings.HasPrefix(hint, "A") {
+			continue
+		}
+		at := strings.LastIndex(hint, "@")
+		if at < 0 {
+			return false
+		}
+		exp, err := strconv.ParseInt(hint[at+1:], 16, 64)
+		if err != nil {
+			return false
+		}
+		return time.Unix(exp, 0).After(time.Now().Add(time.Hour))
+	}
+	return false
+}
+
+// upload reads all files, packs the data into blocks, and writes
+// the blocks to Keep using up.threads concurrent writers.
+func (up *uploader) upload() error {
+	for _, f := range up.files {
+		if f.size > 0 {
+			up.total += f.size
+			up.done += f.committed
+		}
+	}
+	todo := make(chan *putBlock, up.threads)
+	errs := make(chan error, up.threads+1)
+	var wg sync.WaitGroup
+	for i := 0; i < up.threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for blk := range todo {
+				loc, _, err := up.kc.PutB(blk.data)
+				if err != nil {
+					errs <- err
+					// Drain the queue so the reader
+					// doesn't block.
+					for range todo {
+					}
+					return
+				}
+				up.commit(blk, loc)
+			}
+		}()
+	}
+
+	err := up.readFiles(todo, errs)
+	close(todo)
+	wg.Wait()
+	if up.progress && up.total > 0 {
+		fmt.Fprint(up.stderr, "\n")
+	}
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-errs:
+		return err
+	default:
+	}
+	return up.cache.Save()
+}
+
+// readFiles reads the data that hasn't already been committed and
+// sends it to todo in blocks. It stops early if a writer reports an
+// error.
+func (up *uploader) readFiles(todo chan<- *putBlock, errs <-chan error) error {
+	blk := &putBlock{data: make([]byte, 0, up.blockSize)}
+	flush := func() error {
+		if len(blk.data) == 0 {
+			return nil
+		}
+		select {
+		case todo <- blk:
+		case err := <-errs:
+			return err
+		}
+		blk = &putBlock{data: make([]byte, 0, up.blockSize)}
+		return nil
+	}
+	for _, f := range up.files {
+		err := up.readFile(f, &blk, flush)
+		if err != nil {
+			return err
+		}
+	}
+	return flush()
+}
+
+func (up *uploader) readFile(f *putFile, blk **putBlock, flush func() error) error {
+	var r io.Reader
+	if f.localPath == "" {
+		r = up.stdin
+	} else {
+		if f.committed == f.size {
+			return nil
+		}
+		fh, err := os.Open(f.localPath)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		_, err = fh.Seek(f.committed, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		r = fh
+	}
+	offset := f.committed
+	for {
+		b := *blk
+		if len(b.data) == cap(b.data) {
+			if err := flush(); err != nil {
+				return err
+			}
+			b = *blk
+		}
+		n, err := io.ReadFull(r, b.data[len(b.data):cap(b.data)])
+		if n > 0 {
+			b.pieces = append(b.pieces, putPiece{
+				file:       f,
+				fileOffset: offset,
+				blockOff:   len(b.data),
+				length:     n,
+			})
+			b.data = b.data[:len(b.data)+n]
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if f.localPath == "" {
+		f.size = offset
+		up.mtx.Lock()
+		up.total += offset
+		up.mtx.Unlock()
+	} else if offset != f.size {
+		return fmt.Errorf("%s: file size changed during upload (expected %d bytes, read %d)", f.localPath, f.size, offset)
+	}
+	return nil
+}
+
+// commit records that blk has been written to Keep as loc, and
+// updates the cache.
+func (up *uploader) commit(blk *putBlock, loc string) {
+	up.mtx.Lock()
+	defer up.mtx.Unlock()
+	for _, p := range blk.pieces {
+		f := p.file
+		if f.pending == nil {
+			f.pending = map[int64]putSegment{}
+		}
+		f.pending[p.fileOffset] = putSegment{Locator: loc, Offset: p.blockOff, Length: p.length}
+		for {
+			seg, ok := f.pending[f.committed]
+			if !ok {
+				break
+			}
+			delete(f.pending, f.committed)
+			f.segments = append(f.segments, seg)
+			f.committed += int64(seg.Length)
+		}
+		up.done += int64(p.length)
+	}
+	if up.progress && up.total > 0 {
+		fmt.Fprint(up.stderr, humanProgress(up.done, up.total))
+	}
+	if up.cache != nil {
+		up.cache.update(up.files)
+		if err := up.cache.Save(); err != nil {
+			fmt.Fprintf(up.stderr, "warning: error saving upload cache: %s\n", err)
+		}
+	}
+}
+
+// manifest returns the normalized manifest text for the uploaded
+// files.
+func (up *uploader) manifest(client *arvados.Client, kc keepClient) (string, error) {
+	dirs := map[string][]*putFile{}
+	for _, f := range up.files {
+		dir := path.Dir(f.collPath)
+		dirs[dir] = append(dirs[dir], f)
+	}
+	var names []string
+	for dir := range dirs {
+		names = append(names, dir)
+	}
+	sort.Strings(names)
+	var m strings.Builder
+	for _, dir := range names {
+		var locators []string
+		blockStart := map[string]int64{}
+		var streamSize int64
+		var tokens []string
+		for _, f := range dirs[dir] {
+			for _, seg := range f.segments {
+				if _, ok := blockStart[seg.Locator]; !ok {
+					size, err := strconv.ParseInt(strings.Split(seg.Locator, "+")[1], 10, 64)
+					if err != nil {
+						return "", fmt.Errorf("bad locator %q", seg.Locator)
+					}
+					blockStart[seg.Locator] = streamSize
+					streamSize += size
+					locators = append(locators, seg.Locator)
+				}
+			}
+		}
+		if len(locators) == 0 {
+			locators = append(locators, "d41d8cd98f00b204e9800998ecf8427e+0")
+		}
+		for _, f := range dirs[dir] {
+			name := manifestEscape(path.Base(f.collPath))
+			if len(f.segments) == 0 {
+				tokens = append(tokens, "0:0:"+name)
+				continue
+			}
+			var pos, length int64 = -1, 0
+			for _, seg := range f.segments {
+				start := blockStart[seg.Locator] + int64(seg.Offset)
+				if pos >= 0 && pos+length == start {
+					length += int64(seg.Length)
+					continue
+				}
+				if pos >= 0 {
+					tokens = append(tokens, fmt.Sprintf("%d:%d:%s", pos, length, name))
+				}
+				pos, length = start, int64(seg.Length)
+			}
+			tokens = append(tokens, fmt.Sprintf("%d:%d:%s", pos, length, name))
+		}
+		stream := "."
+		if dir != "." {
+			stream = "./" + manifestEscape(dir)
+		}
+		fmt.Fprintf(&m, "%s %s %s\n", stream, strings.Join(locators, " "), strings.Join(tokens, " "))
+	}
+	coll := arvados.Collection{ManifestText: m.String()}
+	fs, err := coll.FileSystem(client, kc)
+	if err != nil {
+		return "", err
+	}
+	return fs.MarshalManifest(".")
+}
+
+// putCache is a local record of the data that has been written to
+// Keep during an upload, so an interrupted upload can be resumed
+// without re-sending the same data. The cache file is locked while
+// the upload is in progress, so two processes can't upload the same
+// files at the same time.
+type putCache struct {
+	Files map[string]putCacheEntry `json:"files"`
+
+	file *os.File
+}
+
+type putCacheEntry struct {
+	Size     int64        `json:"size"`
+	ModTime  time.Time    `json:"mtime"`
+	Segments []putSegment `json:"segments"`
+}
+
+// openPutCache opens and locks the cache file for uploading the
+// given files to the given cluster.
+func openPutCache(apiHost string, files []*putFile) (*putCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, "arvados", "arvados-client-put")
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	h := md5.New()
+	fmt.Fprintf(h, "%s\n", apiHost)
+	for _, f := range files {
+		fmt.Fprintf(h, "%s\000%s\n", f.localPath, f.collPath)
+	}
+	fnm := filepath.Join(dir, fmt.Sprintf("%x", h.Sum(nil)))
+	file, err := os.OpenFile(fnm, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		file.Close()
+		return nil, fmt.Errorf("another process is already uploading these files (cache file %s is locked)", fnm)
+	} else if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error locking cache file %s: %s", fnm, err)
+	}
+	cache := &putCache{file: file}
+	err = json.NewDecoder(file).Decode(cache)
+	if err != nil || cache.Files == nil {
+		// Missing or unusable cache: start over.
+		cache.Files = map[string]putCacheEntry{}
+	}
+	return cache, nil
+}
+
+// update replaces the cache entries for the given files with their
+// current state.
+func (cache *putCache) update(files []*putFile) {
+	for _, f := range files {
+		if f.localPath == "" {
+			continue
+		}
+		cache.Files[f.localPath] = putCacheEntry{
+			Size:     f.size,
+			ModTime:  f.modTime,
+			Segments: append([]putSegment(nil), f.segments...),
+		}
+	}
+}
+
+// Save writes the cache to disk. It is a no-op if cache is nil.
+func (cache *putCache) Save() error {
+	if cache == nil {
+		return nil
+	}
+	buf, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	err = cache.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = cache.file.WriteAt(buf, 0)
+	if err != nil {
+		return err
+	}
+	return cache.file.Sync()
+}
+
+// Remove deletes the cache file after a successful upload. It is a
+// no-op if cache is nil.
+func (cache *putCache) Remove() {
+	if cache == nil {
+		return
+	}
+	os.Remove(cache.file.Name())
+}
+
+// Close releases the lock on the cache file. It is a no-op if cache
+// is nil.
+func (cache *putCache) Close() error {
+	if cache == nil {
+		return nil
+	}
+	return cache.file.Close()
+}
@@ -0,0 +1,315 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&KeepSuite{})
+
+type KeepSuite struct{}
+
+// stubKeep stores blocks in memory. If failAfter > 0, PutB fails
+// after that many blocks have been stored.
+type stubKeep struct {
+	mtx       sync.Mutex
+	blocks    map[string][]byte
+	puts      int
+	failAfter int
+}
+
+func (kc *stubKeep) PutB(p []byte) (string, int, error) {
+	kc.mtx.Lock()
+	defer kc.mtx.Unlock()
+	if kc.failAfter > 0 && kc.puts >= kc.failAfter {
+		return "", 0, errors.New("stub write failure")
+	}
+	kc.puts++
+	if kc.blocks == nil {
+		kc.blocks = map[string][]byte{}
+	}
+	hash := fmt.Sprintf("%x", md5.Sum(p))
+	kc.blocks[hash] = append([]byte(nil), p...)
+	return fmt.Sprintf("%s+%d+A%s@%x", hash, len(p), strings.Repeat("0", 40), time.Now().Add(time.Hour*24*14).Unix()), 1, nil
+}
+
+func (kc *stubKeep) ReadAt(locator string, p []byte, off int) (int, error) {
+	kc.mtx.Lock()
+	defer kc.mtx.Unlock()
+	buf, ok := kc.blocks[locator[:32]]
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	return copy(p, buf[off:]), nil
+}
+
+func (kc *stubKeep) LocalLocator(locator string) (string, error) {
+	return locator, nil
+}
+
+func (s *KeepSuite) SetUpTest(c *check.C) {
+	os.Setenv("XDG_CACHE_HOME", c.MkDir())
+}
+
+func (s *KeepSuite) TearDownTest(c *check.C) {
+	os.Unsetenv("XDG_CACHE_HOME")
+}
+
+// makeTree creates the given files (path => content) under a new
+// temporary directory, and returns the directory.
+func (s *KeepSuite) makeTree(c *check.C, files map[string]string) string {
+	dir := c.MkDir()
+	for name, content := range files {
+		fnm := filepath.Join(dir, name)
+		c.Assert(os.MkdirAll(filepath.Dir(fnm), 0777), check.IsNil)
+		c.Assert(ioutil.WriteFile(fnm, []byte(content), 0666), check.IsNil)
+	}
+	return dir
+}
+
+// checkManifest checks that the given manifest contains exactly the
+// expected files.
+func (s *KeepSuite) checkManifest(c *check.C, manifest string, kc *stubKeep, expect map[string]string) {
+	fs, err := (&arvados.Collection{ManifestText: manifest}).FileSystem(nil, kc)
+	c.Assert(err, check.IsNil)
+	for name, content := range expect {
+		f, err := fs.Open(name)
+		if !c.Check(err, check.IsNil, check.Commentf("%s", name)) {
+			continue
+		}
+		buf, err := ioutil.ReadAll(f)
+		c.Check(err, check.IsNil)
+		c.Check(string(buf), check.Equals, content, check.Commentf("%s", name))
+		f.Close()
+	}
+	c.Check(fs.Size(), check.Equals, func() (n int64) {
+		for _, content := range expect {
+			n += int64(len(content))
+		}
+		return
+	}())
+}
+
+func (s *KeepSuite) TestPutPacking(c *check.C) {
+	files := map[string]string{
+		"foo":              "foo",
+		"empty":            "",
+		"dir/bar":          "barbarbarbar",
+		"dir/sub/baz":      "baz",
+		"name:with spaces": "x",
+	}
+	dir := s.makeTree(c, files)
+	kc := &stubKeep{}
+	up := &uploader{kc: kc, stderr: ioutil.Discard, threads: 3, blockSize: 5}
+	c.Assert(up.addPaths([]string{dir}, "stdin"), check.IsNil)
+	c.Assert(up.upload(), check.IsNil)
+	// 19 bytes in 5-byte blocks
+	c.Check(kc.puts, check.Equals, 4)
+	manifest, err := up.manifest(nil, kc)
+	c.Assert(err, check.IsNil)
+	c.Check(manifest, check.Matches, `(?ms)\. .* 0:0:empty .*:name\\072with\\040spaces\n\./dir .*\n\./dir/sub .*\n`)
+	s.checkManifest(c, manifest, kc, files)
+}
+
+func (s *KeepSuite) TestPutPaths(c *check.C) {
+	dir := s.makeTree(c, map[string]string{"a/foo": "foo", "b/bar": "bar"})
+	for _, trial := range []struct {
+		args   []string
+		expect []string
+	}{
+		{[]string{"a"}, []string{"foo"}},
+		{[]string{"a", "b"}, []string{"a/foo", "b/bar"}},
+		{[]string{"a/", "b"}, []string{"foo", "b/bar"}},
+		{[]string{"a/foo", "-"}, []string{"foo", "stdin"}},
+	} {
+		up := &uploader{}
+		var args []string
+		for _, arg := range trial.args {
+			if arg != "-" {
+				arg = dir + "/" + arg
+			}
+			args = append(args, arg)
+		}
+		c.Assert(up.addPaths(args, "stdin"), check.IsNil)
+		var got []string
+		for _, f := range up.files {
+			got = append(got, f.collPath)
+		}
+		c.Check(got, check.DeepEquals, trial.expect, check.Commentf("%q", trial.args))
+	}
+
+	up := &uploader{}
+	c.Check(up.addPaths([]string{dir + "/a/", dir + "/a/foo"}, "stdin"), check.ErrorMatches, `.*more than one file.*"foo".*`)
+}
+
+func (s *KeepSuite) TestPutStdin(c *check.C) {
+	kc := &stubKeep{}
+	up := &uploader{kc: kc, stdin: strings.NewReader("hello world"), stderr: ioutil.Discard, threads: 2, blockSize: 4}
+	c.Assert(up.addPaths([]string{"-"}, "greeting"), check.IsNil)
+	c.Assert(up.upload(), check.IsNil)
+	manifest, err := up.manifest(nil, kc)
+	c.Assert(err, check.IsNil)
+	s.checkManifest(c, manifest, kc, map[string]string{"greeting": "hello world"})
+}
+
+func (s *KeepSuite) TestPutResume(c *check.C) {
+	files := map[string]string{
+		"foo": "foofoofoofoofoo",
+		"bar": "barbarbar",
+		"baz": "bazbazbazbaz",
+	}
+	dir := s.makeTree(c, files)
+
+	// First attempt fails after 3 blocks have been written.
+	kc := &stubKeep{failAfter: 3}
+	up := &uploader{kc: kc, stderr: ioutil.Discard, threads: 1, blockSize: 4}
+	c.Assert(up.addPaths([]string{dir}, "stdin"), check.IsNil)
+	cache, err := openPutCache("zzzzz.example", up.files)
+	c.Assert(err, check.IsNil)
+	up.cache = cache
+	c.Check(up.upload(), check.ErrorMatches, `stub write failure`)
+
+	// Can't start a second upload while the first one holds
+	// the lock.
+	_, err = openPutCache("zzzzz.example", up.files)
+	c.Check(err, check.ErrorMatches, `another process is already uploading.*`)
+	c.Assert(cache.Close(), check.IsNil)
+
+	// Second attempt resumes where the first one left off.
+	kc.failAfter = 0
+	kc.puts = 0
+	up = &uploader{kc: kc, stderr: ioutil.Discard, threads: 2, blockSize: 4}
+	c.Assert(up.addPaths([]string{dir}, "stdin"), check.IsNil)
+	up.cache, err = openPutCache("zzzzz.example", up.files)
+	c.Assert(err, check.IsNil)
+	defer up.cache.Close()
+	up.resume()
+	c.Check(up.files[0].committed, check.Equals, int64(9)) // bar
+	c.Check(up.files[1].committed, check.Equals, int64(3)) // baz
+	c.Check(up.files[2].committed, check.Equals, int64(0)) // foo
+	c.Assert(up.upload(), check.IsNil)
+	// 36 bytes total, 12 bytes (3 blocks) already written
+	c.Check(kc.puts, check.Equals, 6)
+	manifest, err := up.manifest(nil, kc)
+	c.Assert(err, check.IsNil)
+	s.checkManifest(c, manifest, kc, files)
+
+	// Cached segments are not used for a file that has been
+	// modified since the last attempt.
+	c.Assert(up.cache.Close(), check.IsNil)
+	c.Assert(os.Chtimes(filepath.Join(dir, "baz"), time.Now(), time.Now().Add(time.Minute)), check.IsNil)
+	up = &uploader{kc: kc, stderr: ioutil.Discard, threads: 2, blockSize: 4}
+	c.Assert(up.addPaths([]string{dir}, "stdin"), check.IsNil)
+	up.cache, err = openPutCache("zzzzz.example", up.files)
+	c.Assert(err, check.IsNil)
+	up.resume()
+	c.Check(up.files[0].committed, check.Equals, int64(9))
+	c.Check(up.files[1].committed, check.Equals, int64(0))
+	c.Check(up.files[2].committed, check.Equals, int64(15))
+
+	// After a successful upload, the cache is removed.
+	up.cache.Remove()
+	c.Assert(up.cache.Close(), check.IsNil)
+	up.cache, err = openPutCache("zzzzz.example", up.files)
+	c.Assert(err, check.IsNil)
+	c.Check(up.cache.Files, check.HasLen, 0)
+}
+
+func (s *KeepSuite) TestPutLocatorUsable(c *check.C) {
+	hash := "acbd18db4cc2f85cedef654fccc4a4d8"
+	sig := strings.Repeat("0", 40)
+	c.Check(putLocatorUsable(fmt.Sprintf("%s+3+A%s@%x", hash, sig, time.Now().Add(48*time.Hour).Unix())), check.Equals, true)
+	c.Check(putLocatorUsable(fmt.Sprintf("%s+3+K@zzzzz+A%s@%x", hash, sig, time.Now().Add(48*time.Hour).Unix())), check.Equals, true)
+	c.Check(putLocatorUsable(fmt.Sprintf("%s+3+A%s@%x", hash, sig, time.Now().Add(time.Minute).Unix())), check.Equals, false)
+	c.Check(putLocatorUsable(hash+"+3"), check.Equals, false)
+}
+
+func (s *KeepSuite) TestParseKeepSource(c *check.C) {
+	for _, trial := range []struct {
+		src  string
+		id   string
+		path string
+	}{
+		{arvadostest.FooCollection, arvadostest.FooCollection, ""},
+		{"keep:" + arvadostest.FooCollection + "/", arvadostest.FooCollection, ""},
+		{arvadostest.FooCollectionPDH + "/dir/foo", arvadostest.FooCollectionPDH, "dir/foo"},
+		{"keep:" + arvadostest.FooCollectionPDH + "/dir/", arvadostest.FooCollectionPDH, "dir"},
+	} {
+		id, path, err := parseKeepSource(trial.src)
+		c.Check(err, check.IsNil)
+		c.Check(id, check.Equals, trial.id)
+		c.Check(path, check.Equals, trial.path)
+	}
+	_, _, err := parseKeepSource("foo/bar")
+	c.Check(err, check.NotNil)
+}
+
+func (s *KeepSuite) TestLsFooCollection(c *check.C) {
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	exited := keepLs.RunCommand("arvados-client keep ls", []string{"-s", arvadostest.FooCollection}, bytes.NewReader(nil), stdout, stderr)
+	c.Check(stderr.String(), check.Equals, "")
+	c.Check(exited, check.Equals, 0)
+	c.Check(stdout.String(), check.Equals, "         1 ./foo\n")
+}
+
+func (s *KeepSuite) TestGetFile(c *check.C) {
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	exited := keepGet.RunCommand("arvados-client keep get", []string{"--no-progress", arvadostest.FooCollection + "/foo"}, bytes.NewReader(nil), stdout, stderr)
+	c.Check(stderr.String(), check.Equals, "")
+	c.Check(exited, check.Equals, 0)
+	c.Check(stdout.String(), check.Equals, "foo")
+}
+
+func (s *KeepSuite) TestPutGetRoundTrip(c *check.C) {
+	files := map[string]string{
+		"foo":     "foo",
+		"dir/bar": "bar",
+		"empty":   "",
+	}
+	src := s.makeTree(c, files)
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	exited := keepPut.RunCommand("arvados-client keep put", []string{"--no-progress", "--name", "keep put test", "--portable-data-hash", src}, bytes.NewReader(nil), stdout, stderr)
+	c.Check(stderr.String(), check.Matches, `.*Collection saved as 'keep put test.*'\n`)
+	c.Assert(exited, check.Equals, 0)
+	pdh := strings.TrimSpace(stdout.String())
+	c.Check(pdh, check.Matches, `[0-9a-f]{32}\+\d+`)
+
+	dst := c.MkDir()
+	stdout.Reset()
+	stderr.Reset()
+	exited = keepGet.RunCommand("arvados-client keep get", []string{"--no-progress", "--md5sum", pdh + "/", dst}, bytes.NewReader(nil), stdout, stderr)
+	c.Check(stderr.String(), check.Equals, "")
+	c.Assert(exited, check.Equals, 0)
+	c.Check(stdout.String(), check.Matches, `(?ms).*37b51d194a7513e45b56f6524f2d51f2  \./dir/bar\n.*`)
+	for name, content := range files {
+		buf, err := ioutil.ReadFile(filepath.Join(dst, name))
+		c.Check(err, check.IsNil)
+		c.Check(string(buf), check.Equals, content)
+	}
+
+	// Refuse to overwrite existing files unless -f is given.
+	exited = keepGet.RunCommand("arvados-client keep get", []string{"--no-progress", pdh + "/", dst}, bytes.NewReader(nil), stdout, stderr)
+	c.Check(exited, check.Equals, 1)
+	c.Check(stderr.String(), check.Matches, `.*already exists\n`)
+	exited = keepGet.RunCommand("arvados-client keep get", []string{"--no-progress", "-f", pdh + "/", dst}, bytes.NewReader(nil), stdout, stderr)
+	c.Check(exited, check.Equals, 0)
+}
@@ -0,0 +1,107 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/cmd"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+)
+
+// Login obtains an API token using the device authorization flow:
+// it displays a code and a URL, waits for the user to approve the
+// request in a web browser, and prints the resulting token on
+// stdout.
+var Login cmd.Handler = loginCmd{}
+
+type loginCmd struct {
+	// Called between polling requests; time.Sleep if nil.
+	sleep func(time.Duration)
+}
+
+func (lc loginCmd) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(stderr, "%s\n", err)
+		}
+	}()
+
+	flags := flag.NewFlagSet("", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	env := flags.Bool("env", false, "Print token as a shell command (export ARVADOS_API_TOKEN=...)")
+	err = flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if len(flags.Args()) != 0 {
+		fmt.Fprintf(stderr, "usage of %s:\n", prog)
+		flags.PrintDefaults()
+		return 2
+	}
+	sleep := lc.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	client := arvados.NewClientFromEnv()
+	client.AuthToken = ""
+	var da arvados.DeviceAuthorization
+	err = client.RequestAndDecode(&da, "POST", arvados.EndpointDeviceAuthorizationCreate.Path, nil, nil)
+	if err != nil {
+		err = fmt.Errorf("error starting device authorization: %s", err)
+		return 1
+	}
+	fmt.Fprintf(stderr, "To log in, visit %s\nand enter the code %s\n", da.VerificationURI, da.UserCode)
+	if da.VerificationURIComplete != "" {
+		fmt.Fprintf(stderr, "(or visit %s)\n", da.VerificationURIComplete)
+	}
+
+	interval := time.Duration(da.Interval) * time.Second
+	if interval <= 0 {
+		// Default specified by RFC 8628 section 3.2
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(da.ExpiresIn) * time.Second)
+	for {
+		sleep(interval)
+		var aca arvados.APIClientAuthorization
+		err = client.RequestAndDecode(&aca, "POST", arvados.EndpointDeviceAuthorizationToken.Path, nil, arvados.DeviceAuthorizationTokenOptions{DeviceCode: da.DeviceCode})
+		if err == nil {
+			if *env {
+				fmt.Fprintf(stdout, "export ARVADOS_API_TOKEN=%s\n", aca.TokenV2())
+			} else {
+				fmt.Fprintln(stdout, aca.TokenV2())
+			}
+			return 0
+		}
+		var terr *arvados.TransactionError
+		if !errors.As(err, &terr) || len(terr.Errors) == 0 {
+			err = fmt.Errorf("error polling for token: %s", err)
+			return 1
+		}
+		switch terr.Errors[0] {
+		case "authorization_pending":
+		case "slow_down":
+			// RFC 8628 section 3.5
+			interval += 5 * time.Second
+		case "expired_token":
+			err = errors.New("code expired before login was approved")
+			return 1
+		default:
+			err = fmt.Errorf("error polling for token: %s", err)
+			return 1
+		}
+		if da.ExpiresIn > 0 && time.Now().After(deadline) {
+			err = errors.New("code expired before login was approved")
+			return 1
+		}
+	}
+}
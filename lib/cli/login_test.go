@@ -0,0 +1,114 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&LoginSuite{})
+
+type LoginSuite struct {
+	server *httptest.Server
+	// errors to return from successive token requests before
+	// succeeding
+	pollErrors []string
+	polls      int
+	slept      []time.Duration
+
+	savedHost, savedInsecure string
+}
+
+func (s *LoginSuite) SetUpTest(c *check.C) {
+	s.pollErrors = nil
+	s.polls = 0
+	s.slept = nil
+	s.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/arvados/v1/device_authorizations":
+			c.Check(req.Header.Get("Authorization"), check.Equals, "")
+			json.NewEncoder(w).Encode(arvados.DeviceAuthorization{
+				DeviceCode:              "devicecode",
+				UserCode:                "BCDF-GHJK",
+				VerificationURI:         "https://example.com/device",
+				VerificationURIComplete: "https://example.com/device?user_code=BCDF-GHJK",
+				ExpiresIn:               600,
+				Interval:                3,
+			})
+		case "/arvados/v1/device_authorizations/token":
+			c.Check(req.Form.Get("device_code"), check.Equals, "devicecode")
+			s.polls++
+			if s.polls <= len(s.pollErrors) {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string][]string{"errors": {s.pollErrors[s.polls-1]}})
+				return
+			}
+			json.NewEncoder(w).Encode(arvados.APIClientAuthorization{
+				UUID:     "zzzzz-gj3su-aaaaaaaaaaaaaaa",
+				APIToken: "secrettoken",
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	s.savedHost, s.savedInsecure = os.Getenv("ARVADOS_API_HOST"), os.Getenv("ARVADOS_API_HOST_INSECURE")
+	os.Setenv("ARVADOS_API_HOST", s.server.Listener.Addr().String())
+	os.Setenv("ARVADOS_API_HOST_INSECURE", "1")
+}
+
+func (s *LoginSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+	os.Setenv("ARVADOS_API_HOST", s.savedHost)
+	os.Setenv("ARVADOS_API_HOST_INSECURE", s.savedInsecure)
+}
+
+func (s *LoginSuite) run(args ...string) (exited int, stdout, stderr *bytes.Buffer) {
+	stdout = bytes.NewBuffer(nil)
+	stderr = bytes.NewBuffer(nil)
+	cmd := loginCmd{sleep: func(d time.Duration) { s.slept = append(s.slept, d) }}
+	exited = cmd.RunCommand("arvados-client login", args, bytes.NewReader(nil), stdout, stderr)
+	return
+}
+
+func (s *LoginSuite) TestSuccess(c *check.C) {
+	s.pollErrors = []string{"authorization_pending", "slow_down", "authorization_pending"}
+	exited, stdout, stderr := s.run()
+	c.Check(exited, check.Equals, 0)
+	c.Check(stdout.String(), check.Equals, "v2/zzzzz-gj3su-aaaaaaaaaaaaaaa/secrettoken\n")
+	c.Check(stderr.String(), check.Matches, `(?ms).*https://example.com/device\n.*BCDF-GHJK\n.*`)
+	c.Check(s.polls, check.Equals, 4)
+	c.Check(s.slept, check.DeepEquals, []time.Duration{3 * time.Second, 3 * time.Second, 8 * time.Second, 8 * time.Second})
+}
+
+func (s *LoginSuite) TestEnvFormat(c *check.C) {
+	exited, stdout, _ := s.run("-env")
+	c.Check(exited, check.Equals, 0)
+	c.Check(stdout.String(), check.Equals, "export ARVADOS_API_TOKEN=v2/zzzzz-gj3su-aaaaaaaaaaaaaaa/secrettoken\n")
+}
+
+func (s *LoginSuite) TestExpired(c *check.C) {
+	s.pollErrors = []string{"authorization_pending", "expired_token"}
+	exited, stdout, stderr := s.run()
+	c.Check(exited, check.Equals, 1)
+	c.Check(stdout.String(), check.Equals, "")
+	c.Check(stderr.String(), check.Matches, `(?ms).*code expired before login was approved\n`)
+}
+
+func (s *LoginSuite) TestUnexpectedError(c *check.C) {
+	s.pollErrors = []string{"access_denied"}
+	exited, _, stderr := s.run()
+	c.Check(exited, check.Equals, 1)
+	c.Check(stderr.String(), check.Matches, `(?ms).*error polling for token:.*access_denied\n`)
+}
@@ -0,0 +1,129 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package gce_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+
+	"git.arvados.org/arvados.git/lib/cloud/cloudtest"
+	"git.arvados.org/arvados.git/lib/cloud/gce"
+	"git.arvados.org/arvados.git/lib/dispatchcloud/test"
+	"golang.org/x/crypto/ssh"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&CloudtestSuite{})
+
+// CloudtestSuite runs the gce driver through the cloudtest harness
+// ("arvados-server cloudtest"), using a fake GCE API server and a
+// stub SSH server in place of the test instance.
+type CloudtestSuite struct{}
+
+var secretRe = regexp.MustCompile(`echo -n "([0-9a-f]+)" >/var/run/arvados-instance-secret`)
+
+func (s *CloudtestSuite) TestCloudtest(c *check.C) {
+	pubkey, _ := test.LoadTestKey(c, "../../dispatchcloud/test/sshkey_dispatch")
+	_, hostkey := test.LoadTestKey(c, "../../dispatchcloud/test/sshkey_vm")
+	privkeyPEM, err := ioutil.ReadFile("../../dispatchcloud/test/sshkey_dispatch")
+	c.Assert(err, check.IsNil)
+
+	api := gce.NewFakeAPI("127.0.0.1")
+	defer api.Close()
+
+	sshd := &test.SSHService{
+		HostKey:        hostkey,
+		AuthorizedUser: "crunch",
+		AuthorizedKeys: []ssh.PublicKey{pubkey},
+		Exec: func(env map[string]string, command string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+			switch command {
+			case "sudo cat /var/run/arvados-instance-secret":
+				// The startup script would have
+				// written the secret here.
+				for _, script := range api.Metadata("startup-script") {
+					if m := secretRe.FindStringSubmatch(script); m != nil {
+						fmt.Fprint(stdout, m[1])
+						return 0
+					}
+				}
+				return 1
+			case "crunch-run --list":
+				return 0
+			default:
+				fmt.Fprintf(stderr, "%s: command not found\n", command)
+				return 127
+			}
+		},
+	}
+	c.Assert(sshd.Start(), check.IsNil)
+	defer sshd.Close()
+	_, sshPort, err := net.SplitHostPort(sshd.Address())
+	c.Assert(err, check.IsNil)
+
+	// Service account credentials whose token URI is the fake
+	// API server, so the driver authenticates the same way it
+	// does with a real service account key file.
+	credentials, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "test-project",
+		"private_key_id": "0123456789abcdef",
+		"private_key":    string(privkeyPEM),
+		"client_email":   "dispatcher@test-project.iam.gserviceaccount.com",
+		"client_id":      "123456789",
+		"token_uri":      api.URL + "/token",
+	})
+	c.Assert(err, check.IsNil)
+
+	config := map[string]interface{}{
+		"Clusters": map[string]interface{}{
+			"zzzzz": map[string]interface{}{
+				"Containers": map[string]interface{}{
+					"DispatchPrivateKey": string(privkeyPEM),
+					"CloudVMs": map[string]interface{}{
+						"Driver":           "gce",
+						"ImageID":          "projects/test-project/global/images/blob",
+						"SSHPort":          sshPort,
+						"BootProbeCommand": "crunch-run --list",
+						"SyncInterval":     "10ms",
+						"ProbeInterval":    "10ms",
+						"TimeoutBooting":   "20s",
+						"DriverParameters": map[string]interface{}{
+							"CredentialsJSON": string(credentials),
+							"Project":         "test-project",
+							"Zone":            "test-zone",
+							"Network":         "global/networks/default",
+							"AdminUsername":   "crunch",
+							"Endpoint":        api.URL + "/compute/v1/projects/",
+						},
+					},
+				},
+				"InstanceTypes": map[string]interface{}{
+					"tiny": map[string]interface{}{
+						"ProviderType": "n1-standard-1",
+						"VCPUs":        1,
+						"RAM":          "3840MB",
+						"Price":        .05,
+					},
+				},
+			},
+		},
+	}
+	confdata, err := json.Marshal(config)
+	c.Assert(err, check.IsNil)
+
+	var stdout, stderr bytes.Buffer
+	exitcode := cloudtest.Command.RunCommand("arvados-server cloudtest", []string{"-config", "-", "-command", "crunch-run --list"}, bytes.NewReader(confdata), &stdout, &stderr)
+	c.Logf("%s", stderr.String())
+	c.Check(exitcode, check.Equals, 0)
+	c.Check(stderr.String(), check.Not(check.Matches), `(?ms).*level=error.*`)
+	c.Check(strings.Contains(stderr.String(), `msg="created instance"`), check.Equals, true)
+	c.Check(api.Instances(), check.Equals, 0)
+}
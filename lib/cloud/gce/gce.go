@@ -0,0 +1,456 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/cloud"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/jmcvetta/randutil"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// Driver is the gce implementation of the cloud.Driver interface.
+var Driver = cloud.DriverFunc(newGCEInstanceSet)
+
+const (
+	// Metadata item that holds the exact (JSON-encoded) instance
+	// tags. Labels hold a lossy copy that is used for filtering.
+	tagsMetadataKey = "arvados-tags"
+
+	defaultDiskType         = "pd-standard"
+	defaultOperationTimeout = 5 * time.Minute
+	operationPollInterval   = 2 * time.Second
+	defaultRetryAfter       = 20 * time.Second
+)
+
+type gceInstanceSetConfig struct {
+	CredentialsJSON  string
+	Project          string
+	Zone             string
+	Network          string
+	Subnetwork       string
+	ServiceAccount   string
+	DiskType         string
+	AdminUsername    string
+	OperationTimeout arvados.Duration
+	Endpoint         string
+}
+
+type gceInstanceSet struct {
+	gceconfig     gceInstanceSetConfig
+	instanceSetID cloud.InstanceSetID
+	logger        logrus.FieldLogger
+	service       *compute.Service
+	pollInterval  time.Duration
+	ctx           context.Context
+	stopFunc      context.CancelFunc
+}
+
+func newGCEInstanceSet(config json.RawMessage, instanceSetID cloud.InstanceSetID, _ cloud.SharedResourceTags, logger logrus.FieldLogger) (cloud.InstanceSet, error) {
+	return newGCEInstanceSetWithOptions(config, instanceSetID, logger)
+}
+
+// newGCEInstanceSetWithOptions is like newGCEInstanceSet, but passes
+// extra options to the API client (e.g., a test server endpoint).
+func newGCEInstanceSetWithOptions(config json.RawMessage, instanceSetID cloud.InstanceSetID, logger logrus.FieldLogger, opts ...option.ClientOption) (*gceInstanceSet, error) {
+	instanceSet := &gceInstanceSet{
+		instanceSetID: instanceSetID,
+		logger:        logger,
+		pollInterval:  operationPollInterval,
+	}
+	err := json.Unmarshal(config, &instanceSet.gceconfig)
+	if err != nil {
+		return nil, err
+	}
+	if instanceSet.gceconfig.Project == "" || instanceSet.gceconfig.Zone == "" {
+		return nil, errors.New("Project and Zone must be configured")
+	}
+	if instanceSet.gceconfig.DiskType == "" {
+		instanceSet.gceconfig.DiskType = defaultDiskType
+	}
+	if instanceSet.gceconfig.OperationTimeout <= 0 {
+		instanceSet.gceconfig.OperationTimeout = arvados.Duration(defaultOperationTimeout)
+	}
+	if creds := instanceSet.gceconfig.CredentialsJSON; creds != "" {
+		opts = append([]option.ClientOption{option.WithCredentialsJSON([]byte(creds))}, opts...)
+	}
+	if ep := instanceSet.gceconfig.Endpoint; ep != "" {
+		opts = append([]option.ClientOption{option.WithEndpoint(ep)}, opts...)
+	}
+	instanceSet.ctx, instanceSet.stopFunc = context.WithCancel(context.Background())
+	instanceSet.service, err = compute.NewService(instanceSet.ctx, opts...)
+	if err != nil {
+		instanceSet.stopFunc()
+		return nil, err
+	}
+	return instanceSet, nil
+}
+
+func (instanceSet *gceInstanceSet) Create(
+	instanceType arvados.InstanceType,
+	imageID cloud.ImageID,
+	newTags cloud.InstanceTags,
+	initCommand cloud.InitCommand,
+	publicKey ssh.PublicKey) (cloud.Instance, error) {
+
+	cfg := instanceSet.gceconfig
+	name, err := randutil.String(15, "abcdefghijklmnopqrstuvwxyz0123456789")
+	if err != nil {
+		return nil, err
+	}
+	name = "compute-" + name
+
+	metadata, err := tagsMetadata(nil, newTags)
+	if err != nil {
+		return nil, err
+	}
+	metadata.Items = append(metadata.Items,
+		&compute.MetadataItems{
+			Key:   "startup-script",
+			Value: googleapi.String("#!/bin/sh\n" + string(initCommand) + "\n"),
+		})
+	if publicKey != nil {
+		metadata.Items = append(metadata.Items,
+			&compute.MetadataItems{
+				Key:   "ssh-keys",
+				Value: googleapi.String(cfg.AdminUsername + ":" + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey)))),
+			})
+	}
+
+	diskType := "zones/" + cfg.Zone + "/diskTypes/" + cfg.DiskType
+	disks := []*compute.AttachedDisk{{
+		AutoDelete: true,
+		Boot:       true,
+		InitializeParams: &compute.AttachedDiskInitializeParams{
+			DiskType:    diskType,
+			SourceImage: string(imageID),
+		},
+	}}
+	if instanceType.AddedScratch > 0 {
+		disks = append(disks, &compute.AttachedDisk{
+			AutoDelete: true,
+			InitializeParams: &compute.AttachedDiskInitializeParams{
+				DiskType:   diskType,
+				DiskSizeGb: (int64(instanceType.AddedScratch) + (1<<30 - 1)) >> 30,
+			},
+		})
+	}
+
+	inst := &compute.Instance{
+		Name:        name,
+		MachineType: "zones/" + cfg.Zone + "/machineTypes/" + instanceType.ProviderType,
+		Disks:       disks,
+		Labels:      labels(newTags),
+		Metadata:    metadata,
+		NetworkInterfaces: []*compute.NetworkInterface{{
+			Network:    cfg.Network,
+			Subnetwork: cfg.Subnetwork,
+		}},
+		Scheduling: &compute.Scheduling{
+			AutomaticRestart:  googleapi.Bool(!instanceType.Preemptible),
+			OnHostMaintenance: "MIGRATE",
+			Preemptible:       instanceType.Preemptible,
+		},
+	}
+	if instanceType.Preemptible {
+		// Preemptible instances can't be live-migrated.
+		inst.Scheduling.OnHostMaintenance = "TERMINATE"
+	}
+	if cfg.ServiceAccount != "" {
+		inst.ServiceAccounts = []*compute.ServiceAccount{{
+			Email:  cfg.ServiceAccount,
+			Scopes: []string{compute.CloudPlatformScope},
+		}}
+	}
+
+	ctx, cancel := context.WithTimeout(instanceSet.ctx, time.Duration(cfg.OperationTimeout))
+	defer cancel()
+	op, err := instanceSet.service.Instances.Insert(cfg.Project, cfg.Zone, inst).Context(ctx).Do()
+	if err != nil {
+		return nil, wrapGCEError(err)
+	}
+	// Errors like QUOTA_EXCEEDED are reported only when the
+	// operation finishes.
+	err = instanceSet.waitOperation(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	created, err := instanceSet.service.Instances.Get(cfg.Project, cfg.Zone, name).Context(ctx).Do()
+	if err != nil {
+		instanceSet.logger.WithError(err).WithField("Instance", name).Warn("error getting new instance details")
+		created = inst
+	}
+	return &gceInstance{provider: instanceSet, instance: created}, nil
+}
+
+// waitOperation waits for the given zone operation to finish, and
+// returns its error, if any.
+func (instanceSet *gceInstanceSet) waitOperation(ctx context.Context, op *compute.Operation) error {
+	cfg := instanceSet.gceconfig
+	for op.Status != "DONE" {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("operation %s: %s", op.Name, ctx.Err())
+		case <-time.After(instanceSet.pollInterval):
+		}
+		var err error
+		op, err = instanceSet.service.ZoneOperations.Get(cfg.Project, cfg.Zone, op.Name).Context(ctx).Do()
+		if err != nil {
+			return wrapGCEError(err)
+		}
+	}
+	if op.Error == nil || len(op.Error.Errors) == 0 {
+		return nil
+	}
+	var msgs []string
+	quota := false
+	for _, e := range op.Error.Errors {
+		msgs = append(msgs, e.Code+": "+e.Message)
+		if e.Code == "QUOTA_EXCEEDED" {
+			quota = true
+		}
+	}
+	err := fmt.Errorf("operation %s failed: %s", op.Name, strings.Join(msgs, "; "))
+	if quota {
+		return &gceQuotaError{err}
+	}
+	return err
+}
+
+func (instanceSet *gceInstanceSet) Instances(tags cloud.InstanceTags) (instances []cloud.Instance, err error) {
+	cfg := instanceSet.gceconfig
+	call := instanceSet.service.Instances.List(cfg.Project, cfg.Zone)
+	if filter := labelFilter(tags); filter != "" {
+		call = call.Filter(filter)
+	}
+	err = call.Pages(instanceSet.ctx, func(list *compute.InstanceList) error {
+		for _, inst := range list.Items {
+			if inst.Status == "STOPPING" {
+				// Being deleted (or preempted). A
+				// preempted instance reappears as
+				// TERMINATED, and should be
+				// destroyed by the caller.
+				continue
+			}
+			instances = append(instances, &gceInstance{provider: instanceSet, instance: inst})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, wrapGCEError(err)
+	}
+	return instances, nil
+}
+
+func (instanceSet *gceInstanceSet) Stop() {
+	instanceSet.stopFunc()
+}
+
+type gceInstance struct {
+	provider *gceInstanceSet
+	instance *compute.Instance
+}
+
+func (inst *gceInstance) ID() cloud.InstanceID {
+	return cloud.InstanceID(inst.instance.Name)
+}
+
+func (inst *gceInstance) String() string {
+	return inst.instance.Name
+}
+
+func (inst *gceInstance) ProviderType() string {
+	return path.Base(inst.instance.MachineType)
+}
+
+func (inst *gceInstance) SetTags(newTags cloud.InstanceTags) error {
+	cfg := inst.provider.gceconfig
+	ctx := inst.provider.ctx
+	current, err := inst.provider.service.Instances.Get(cfg.Project, cfg.Zone, inst.instance.Name).Context(ctx).Do()
+	if err != nil {
+		return wrapGCEError(err)
+	}
+	metadata, err := tagsMetadata(current.Metadata, newTags)
+	if err != nil {
+		return err
+	}
+	_, err = inst.provider.service.Instances.SetMetadata(cfg.Project, cfg.Zone, inst.instance.Name, metadata).Context(ctx).Do()
+	if err != nil {
+		return wrapGCEError(err)
+	}
+	_, err = inst.provider.service.Instances.SetLabels(cfg.Project, cfg.Zone, inst.instance.Name, &compute.InstancesSetLabelsRequest{
+		Labels:           labels(newTags),
+		LabelFingerprint: current.LabelFingerprint,
+	}).Context(ctx).Do()
+	if err != nil {
+		return wrapGCEError(err)
+	}
+	inst.instance.Metadata = metadata
+	inst.instance.Labels = labels(newTags)
+	return nil
+}
+
+func (inst *gceInstance) Tags() cloud.InstanceTags {
+	if md := inst.instance.Metadata; md != nil {
+		for _, item := range md.Items {
+			if item.Key != tagsMetadataKey || item.Value == nil {
+				continue
+			}
+			var tags cloud.InstanceTags
+			err := json.Unmarshal([]byte(*item.Value), &tags)
+			if err == nil && tags == nil {
+				return cloud.InstanceTags{}
+			} else if err == nil {
+				return tags
+			}
+			inst.provider.logger.WithError(err).WithField("Instance", inst.instance.Name).Warn("error decoding tags from instance metadata")
+		}
+	}
+	// Not created by this driver. Labels are the best we have.
+	tags := cloud.InstanceTags{}
+	for k, v := range inst.instance.Labels {
+		tags[k] = v
+	}
+	return tags
+}
+
+func (inst *gceInstance) Destroy() error {
+	cfg := inst.provider.gceconfig
+	_, err := inst.provider.service.Instances.Delete(cfg.Project, cfg.Zone, inst.instance.Name).Context(inst.provider.ctx).Do()
+	return wrapGCEError(err)
+}
+
+func (inst *gceInstance) Address() string {
+	for _, iface := range inst.instance.NetworkInterfaces {
+		if iface.NetworkIP != "" {
+			return iface.NetworkIP
+		}
+	}
+	return ""
+}
+
+func (inst *gceInstance) RemoteUser() string {
+	return inst.provider.gceconfig.AdminUsername
+}
+
+func (inst *gceInstance) VerifyHostKey(ssh.PublicKey, *ssh.Client) error {
+	return cloud.ErrNotImplemented
+}
+
+// tagsMetadata returns a copy of md (which may be nil) with its
+// tags item replaced by the given tags.
+func tagsMetadata(md *compute.Metadata, tags cloud.InstanceTags) (*compute.Metadata, error) {
+	buf, err := json.Marshal(tags)
+	if err != nil {
+		return nil, err
+	}
+	newmd := &compute.Metadata{}
+	if md != nil {
+		newmd.Fingerprint = md.Fingerprint
+		for _, item := range md.Items {
+			if item.Key != tagsMetadataKey {
+				newmd.Items = append(newmd.Items, item)
+			}
+		}
+	}
+	newmd.Items = append(newmd.Items, &compute.MetadataItems{
+		Key:   tagsMetadataKey,
+		Value: googleapi.String(string(buf)),
+	})
+	return newmd, nil
+}
+
+// labels returns GCE labels corresponding to the given tags. Label
+// keys and values are restricted to 63 lowercase letters, digits,
+// underscores, and dashes, and keys must start with a letter, so
+// some tags cannot be represented exactly.
+func labels(tags cloud.InstanceTags) map[string]string {
+	labels := map[string]string{}
+	for k, v := range tags {
+		k = labelString(k)
+		if k == "" || k[0] < 'a' || k[0] > 'z' {
+			k = labelString("x" + k)
+		}
+		labels[k] = labelString(v)
+	}
+	return labels
+}
+
+func labelString(s string) string {
+	buf := []byte(strings.ToLower(s))
+	for i, c := range buf {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' && c != '-' {
+			buf[i] = '_'
+		}
+	}
+	if len(buf) > 63 {
+		buf = buf[:63]
+	}
+	return string(buf)
+}
+
+// labelFilter returns a filter expression that matches instances
+// whose labels correspond to the given tags.
+func labelFilter(tags cloud.InstanceTags) string {
+	var exprs []string
+	for k, v := range labels(tags) {
+		exprs = append(exprs, fmt.Sprintf("(labels.%s = %q)", k, v))
+	}
+	sort.Strings(exprs)
+	return strings.Join(exprs, " ")
+}
+
+type gceRateLimitError struct {
+	error
+	earliestRetry time.Time
+}
+
+func (err *gceRateLimitError) EarliestRetry() time.Time {
+	return err.earliestRetry
+}
+
+type gceQuotaError struct {
+	error
+}
+
+func (*gceQuotaError) IsQuotaError() bool {
+	return true
+}
+
+// wrapGCEError returns an error that implements cloud.RateLimitError
+// or cloud.QuotaError if err is a rate limit or quota error
+// response. Otherwise it returns err.
+func wrapGCEError(err error) error {
+	gerr, ok := err.(*googleapi.Error)
+	if !ok {
+		return err
+	}
+	reasons := map[string]bool{}
+	for _, e := range gerr.Errors {
+		reasons[e.Reason] = true
+	}
+	if gerr.Code == http.StatusTooManyRequests || reasons["rateLimitExceeded"] || reasons["userRateLimitExceeded"] {
+		// The API doesn't say when to retry.
+		return &gceRateLimitError{err, time.Now().Add(defaultRetryAfter)}
+	}
+	if reasons["quotaExceeded"] {
+		return &gceQuotaError{err}
+	}
+	return err
+}
@@ -0,0 +1,517 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+//
+//
+// How to manually run individual tests against the real cloud:
+//
+// $ go test -v git.arvados.org/arvados.git/lib/cloud/gce -live-gce-cfg gceconfig.yml -check.f=TestCreate
+//
+// Tests should be run individually and in the order they are listed in the file:
+//
+// Example gceconfig.yml:
+//
+// ImageIDForTestSuite: projects/xxxxxx/global/images/xxxxxx
+// DriverParameters:
+//       CredentialsJSON: '{"type": "service_account", ...}'
+//       Project: xxxxxx
+//       Zone: us-central1-a
+//       Network: global/networks/default
+//       AdminUsername: crunch
+//
+// Otherwise, tests run against a fake GCE API server.
+
+package gce
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/cloud"
+	"git.arvados.org/arvados.git/lib/dispatchcloud/test"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/config"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+	check "gopkg.in/check.v1"
+)
+
+var live = flag.String("live-gce-cfg", "", "Test with real GCE API, provide config file")
+
+// Gocheck boilerplate
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type GCEInstanceSetSuite struct {
+	fake   *fakeGCE
+	server *httptest.Server
+}
+
+var _ = check.Suite(&GCEInstanceSetSuite{})
+
+type testConfig struct {
+	ImageIDForTestSuite string
+	DriverParameters    json.RawMessage
+}
+
+// fakeGCE implements the parts of the Compute Engine REST API used by
+// the driver.
+type fakeGCE struct {
+	sync.Mutex
+	instances map[string]*compute.Instance
+	ops       map[string]*compute.Operation
+	serial    int
+
+	// If not zero, respond to instance inserts with this HTTP
+	// status and error reason.
+	insertStatus int
+	insertReason string
+
+	// If not empty, fail insert operations with this error
+	// code.
+	insertOpError string
+
+	// If not empty, use this as the network address of new
+	// instances instead of 10.0.0.N.
+	networkIP string
+}
+
+var (
+	fakeOperationRe = regexp.MustCompile(`^/compute/v1/projects/[^/]+/zones/[^/]+/operations/([^/]+)$`)
+	fakeInstancesRe = regexp.MustCompile(`^/compute/v1/projects/[^/]+/zones/[^/]+/instances(/([^/]+))?(/(setLabels|setMetadata))?$`)
+	fakeFilterRe    = regexp.MustCompile(`\(labels\.([-_a-z0-9]+) = "([^"]*)"\)`)
+)
+
+func (f *fakeGCE) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if req.URL.Path == "/token" {
+		// OAuth2 token endpoint, used with service account
+		// credentials.
+		f.respond(w, map[string]interface{}{
+			"access_token": "fake-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+		return
+	}
+	if m := fakeOperationRe.FindStringSubmatch(req.URL.Path); m != nil {
+		op, ok := f.ops[m[1]]
+		if !ok {
+			f.error(w, http.StatusNotFound, "notFound")
+			return
+		}
+		f.respond(w, op)
+		return
+	}
+	m := fakeInstancesRe.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		f.error(w, http.StatusNotFound, "notFound")
+		return
+	}
+	name, action := m[2], m[4]
+	switch {
+	case req.Method == "GET" && name == "":
+		list := &compute.InstanceList{}
+		filters := fakeFilterRe.FindAllStringSubmatch(req.FormValue("filter"), -1)
+	instances:
+		for _, inst := range f.instances {
+			for _, filter := range filters {
+				if inst.Labels[filter[1]] != filter[2] {
+					continue instances
+				}
+			}
+			list.Items = append(list.Items, inst)
+		}
+		f.respond(w, list)
+	case req.Method == "POST" && name == "":
+		if f.insertStatus != 0 {
+			f.error(w, f.insertStatus, f.insertReason)
+			return
+		}
+		var inst compute.Instance
+		err := json.NewDecoder(req.Body).Decode(&inst)
+		if err != nil {
+			f.error(w, http.StatusBadRequest, "invalid")
+			return
+		}
+		f.serial++
+		op := &compute.Operation{Name: fmt.Sprintf("op-%d", f.serial), Status: "DONE"}
+		if f.insertOpError != "" {
+			op.Error = &compute.OperationError{Errors: []*compute.OperationErrorErrors{{Code: f.insertOpError, Message: "fake error"}}}
+		} else {
+			inst.Status = "RUNNING"
+			inst.LabelFingerprint = fmt.Sprintf("fp-%d", f.serial)
+			inst.Metadata.Fingerprint = fmt.Sprintf("fp-%d", f.serial)
+			inst.NetworkInterfaces[0].NetworkIP = fmt.Sprintf("10.0.0.%d", f.serial)
+			if f.networkIP != "" {
+				inst.NetworkInterfaces[0].NetworkIP = f.networkIP
+			}
+			f.instances[inst.Name] = &inst
+		}
+		f.ops[op.Name] = op
+		// Make the client poll for the result.
+		f.respond(w, &compute.Operation{Name: op.Name, Status: "RUNNING"})
+	case f.instances[name] == nil:
+		f.error(w, http.StatusNotFound, "notFound")
+	case req.Method == "GET" && action == "":
+		f.respond(w, f.instances[name])
+	case req.Method == "DELETE" && action == "":
+		delete(f.instances, name)
+		f.respond(w, &compute.Operation{Name: "delete", Status: "RUNNING"})
+	case req.Method == "POST" && action == "setLabels":
+		var slr compute.InstancesSetLabelsRequest
+		json.NewDecoder(req.Body).Decode(&slr)
+		inst := f.instances[name]
+		if slr.LabelFingerprint != inst.LabelFingerprint {
+			f.error(w, http.StatusPreconditionFailed, "conditionNotMet")
+			return
+		}
+		f.serial++
+		inst.Labels = slr.Labels
+		inst.LabelFingerprint = fmt.Sprintf("fp-%d", f.serial)
+		f.respond(w, &compute.Operation{Name: "setLabels", Status: "RUNNING"})
+	case req.Method == "POST" && action == "setMetadata":
+		var md compute.Metadata
+		json.NewDecoder(req.Body).Decode(&md)
+		inst := f.instances[name]
+		if md.Fingerprint != inst.Metadata.Fingerprint {
+			f.error(w, http.StatusPreconditionFailed, "conditionNotMet")
+			return
+		}
+		f.serial++
+		md.Fingerprint = fmt.Sprintf("fp-%d", f.serial)
+		inst.Metadata = &md
+		f.respond(w, &compute.Operation{Name: "setMetadata", Status: "RUNNING"})
+	default:
+		f.error(w, http.StatusMethodNotAllowed, "badRequest")
+	}
+}
+
+func (f *fakeGCE) respond(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeGCE) error(w http.ResponseWriter, code int, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": "fake error: " + reason,
+			"errors":  []map[string]string{{"reason": reason, "message": "fake error"}},
+		},
+	})
+}
+
+func (s *GCEInstanceSetSuite) SetUpTest(c *check.C) {
+	s.fake = &fakeGCE{
+		instances: map[string]*compute.Instance{},
+		ops:       map[string]*compute.Operation{},
+	}
+	s.server = httptest.NewServer(s.fake)
+}
+
+func (s *GCEInstanceSetSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+}
+
+func (s *GCEInstanceSetSuite) skipIfLive(c *check.C) {
+	if *live != "" {
+		c.Skip("test uses fake API server")
+	}
+}
+
+func (s *GCEInstanceSetSuite) GetInstanceSet(c *check.C) (*gceInstanceSet, cloud.ImageID, arvados.Cluster) {
+	cluster := arvados.Cluster{
+		InstanceTypes: arvados.InstanceTypeMap(map[string]arvados.InstanceType{
+			"tiny": {
+				Name:         "tiny",
+				ProviderType: "n1-standard-1",
+				VCPUs:        1,
+				RAM:          3840000000,
+				Scratch:      10000000000,
+				Price:        .05,
+			},
+			"tiny-with-extra-scratch": {
+				Name:         "tiny-with-extra-scratch",
+				ProviderType: "n1-standard-1",
+				VCPUs:        1,
+				RAM:          3840000000,
+				Price:        .05,
+				AddedScratch: 20000000000,
+			},
+			"tiny-preemptible": {
+				Name:         "tiny-preemptible",
+				ProviderType: "n1-standard-1",
+				VCPUs:        1,
+				RAM:          3840000000,
+				Scratch:      10000000000,
+				Price:        .01,
+				Preemptible:  true,
+			},
+		})}
+	if *live != "" {
+		var exampleCfg testConfig
+		err := config.LoadFile(&exampleCfg, *live)
+		c.Assert(err, check.IsNil)
+		is, err := newGCEInstanceSetWithOptions(exampleCfg.DriverParameters, "test123", logrus.StandardLogger())
+		c.Assert(err, check.IsNil)
+		return is, cloud.ImageID(exampleCfg.ImageIDForTestSuite), cluster
+	}
+	is, err := newGCEInstanceSetWithOptions(json.RawMessage(`{
+		"Project": "test-project",
+		"Zone": "test-zone",
+		"Network": "global/networks/default",
+		"AdminUsername": "crunch"
+	}`), "test123", logrus.StandardLogger(),
+		option.WithEndpoint(s.server.URL+"/compute/v1/projects/"),
+		option.WithHTTPClient(s.server.Client()),
+		option.WithoutAuthentication())
+	c.Assert(err, check.IsNil)
+	is.pollInterval = time.Millisecond
+	return is, cloud.ImageID("projects/test-project/global/images/blob"), cluster
+}
+
+func (s *GCEInstanceSetSuite) create(c *check.C, it string, tags cloud.InstanceTags) (cloud.Instance, error) {
+	is, img, cluster := s.GetInstanceSet(c)
+	pk, _ := test.LoadTestKey(c, "../../dispatchcloud/test/sshkey_dispatch")
+	return is.Create(cluster.InstanceTypes[it], img, tags, "umask 0600; echo -n test-file-data >/var/run/test-file", pk)
+}
+
+func (s *GCEInstanceSetSuite) TestCreate(c *check.C) {
+	inst, err := s.create(c, "tiny", cloud.InstanceTags{
+		"TestTagName":   "Test tag value",
+		"tagprefix:Key": "value with spaces",
+	})
+	c.Assert(err, check.IsNil)
+
+	tags := inst.Tags()
+	c.Check(tags["TestTagName"], check.Equals, "Test tag value")
+	c.Check(tags["tagprefix:Key"], check.Equals, "value with spaces")
+	c.Check(inst.ProviderType(), check.Equals, "n1-standard-1")
+	c.Check(inst.RemoteUser(), check.Equals, "crunch")
+	c.Logf("inst.String()=%v Address()=%v Tags()=%v", inst.String(), inst.Address(), tags)
+
+	if *live != "" {
+		return
+	}
+	c.Check(inst.Address(), check.Equals, "10.0.0.1")
+	created := s.fake.instances[string(inst.ID())]
+	c.Assert(created, check.NotNil)
+	c.Check(created.Name, check.Matches, `compute-[a-z0-9]{15}`)
+	c.Check(created.MachineType, check.Equals, "zones/test-zone/machineTypes/n1-standard-1")
+	c.Check(created.Labels, check.DeepEquals, map[string]string{
+		"testtagname":   "test_tag_value",
+		"tagprefix_key": "value_with_spaces",
+	})
+	c.Check(created.Disks, check.HasLen, 1)
+	c.Check(created.Disks[0].InitializeParams.SourceImage, check.Equals, "projects/test-project/global/images/blob")
+	c.Check(created.Scheduling.Preemptible, check.Equals, false)
+	c.Check(created.NetworkInterfaces[0].Network, check.Equals, "global/networks/default")
+	md := map[string]string{}
+	for _, item := range created.Metadata.Items {
+		md[item.Key] = *item.Value
+	}
+	c.Check(md["startup-script"], check.Equals, "#!/bin/sh\numask 0600; echo -n test-file-data >/var/run/test-file\n")
+	c.Check(md["ssh-keys"], check.Matches, `crunch:ssh-rsa \S+`)
+}
+
+func (s *GCEInstanceSetSuite) TestCreateWithExtraScratch(c *check.C) {
+	inst, err := s.create(c, "tiny-with-extra-scratch", cloud.InstanceTags{"TestTagName": "test tag value"})
+	c.Assert(err, check.IsNil)
+	if *live != "" {
+		return
+	}
+	created := s.fake.instances[string(inst.ID())]
+	c.Assert(created.Disks, check.HasLen, 2)
+	c.Check(created.Disks[1].Boot, check.Equals, false)
+	c.Check(created.Disks[1].AutoDelete, check.Equals, true)
+	c.Check(created.Disks[1].InitializeParams.DiskSizeGb, check.Equals, int64(19))
+}
+
+func (s *GCEInstanceSetSuite) TestCreatePreemptible(c *check.C) {
+	inst, err := s.create(c, "tiny-preemptible", cloud.InstanceTags{"TestTagName": "test tag value"})
+	c.Assert(err, check.IsNil)
+	if *live != "" {
+		return
+	}
+	created := s.fake.instances[string(inst.ID())]
+	c.Check(created.Scheduling.Preemptible, check.Equals, true)
+	c.Check(*created.Scheduling.AutomaticRestart, check.Equals, false)
+	c.Check(created.Scheduling.OnHostMaintenance, check.Equals, "TERMINATE")
+}
+
+func (s *GCEInstanceSetSuite) TestQuotaError(c *check.C) {
+	s.skipIfLive(c)
+	s.fake.insertStatus = http.StatusForbidden
+	s.fake.insertReason = "quotaExceeded"
+	_, err := s.create(c, "tiny", nil)
+	c.Assert(err, check.NotNil)
+	_, ok := err.(cloud.QuotaError)
+	c.Check(ok, check.Equals, true, check.Commentf("%T %s", err, err))
+
+	s.fake.insertStatus = 0
+	s.fake.insertOpError = "QUOTA_EXCEEDED"
+	_, err = s.create(c, "tiny", nil)
+	c.Assert(err, check.NotNil)
+	_, ok = err.(cloud.QuotaError)
+	c.Check(ok, check.Equals, true, check.Commentf("%T %s", err, err))
+	c.Check(s.fake.instances, check.HasLen, 0)
+
+	s.fake.insertOpError = "ZONE_RESOURCE_POOL_EXHAUSTED"
+	_, err = s.create(c, "tiny", nil)
+	c.Assert(err, check.NotNil)
+	_, ok = err.(cloud.QuotaError)
+	c.Check(ok, check.Equals, false)
+	c.Check(err, check.ErrorMatches, `.*ZONE_RESOURCE_POOL_EXHAUSTED: fake error`)
+}
+
+func (s *GCEInstanceSetSuite) TestRateLimitError(c *check.C) {
+	s.skipIfLive(c)
+	for _, trial := range []struct {
+		status int
+		reason string
+	}{
+		{http.StatusTooManyRequests, "rateLimitExceeded"},
+		{http.StatusForbidden, "userRateLimitExceeded"},
+	} {
+		s.fake.insertStatus = trial.status
+		s.fake.insertReason = trial.reason
+		_, err := s.create(c, "tiny", nil)
+		c.Assert(err, check.NotNil)
+		rle, ok := err.(cloud.RateLimitError)
+		c.Assert(ok, check.Equals, true, check.Commentf("%T %s", err, err))
+		wait := time.Until(rle.EarliestRetry())
+		c.Check(wait > defaultRetryAfter-time.Second && wait <= defaultRetryAfter, check.Equals, true, check.Commentf("wait %v", wait))
+	}
+}
+
+func (s *GCEInstanceSetSuite) TestTagInstances(c *check.C) {
+	is, _, _ := s.GetInstanceSet(c)
+	if *live == "" {
+		_, err := s.create(c, "tiny", cloud.InstanceTags{"TestTagName": "test tag value"})
+		c.Assert(err, check.IsNil)
+	}
+
+	l, err := is.Instances(nil)
+	c.Assert(err, check.IsNil)
+
+	for _, i := range l {
+		tg := i.Tags()
+		tg["TestTag2"] = "123 test tag 2"
+		c.Check(i.SetTags(tg), check.IsNil)
+	}
+
+	l, err = is.Instances(cloud.InstanceTags{"TestTag2": "123 test tag 2"})
+	c.Assert(err, check.IsNil)
+	if *live == "" {
+		c.Assert(l, check.HasLen, 1)
+	}
+	for _, i := range l {
+		c.Check(i.Tags()["TestTag2"], check.Equals, "123 test tag 2")
+	}
+}
+
+func (s *GCEInstanceSetSuite) TestListInstances(c *check.C) {
+	is, _, _ := s.GetInstanceSet(c)
+	if *live == "" {
+		for _, setID := range []string{"set1", "set2", "set2"} {
+			_, err := s.create(c, "tiny", cloud.InstanceTags{"ArvadosInstanceSetID": setID})
+			c.Assert(err, check.IsNil)
+		}
+		l, err := is.Instances(cloud.InstanceTags{"ArvadosInstanceSetID": "set2"})
+		c.Assert(err, check.IsNil)
+		c.Check(l, check.HasLen, 2)
+	}
+
+	l, err := is.Instances(nil)
+	c.Assert(err, check.IsNil)
+	if *live == "" {
+		c.Check(l, check.HasLen, 3)
+	}
+	for _, i := range l {
+		c.Logf("%v %v %v", i.String(), i.Address(), i.Tags())
+	}
+}
+
+func (s *GCEInstanceSetSuite) TestDestroyInstances(c *check.C) {
+	is, _, _ := s.GetInstanceSet(c)
+	if *live == "" {
+		_, err := s.create(c, "tiny", nil)
+		c.Assert(err, check.IsNil)
+	}
+
+	l, err := is.Instances(nil)
+	c.Assert(err, check.IsNil)
+
+	for _, i := range l {
+		c.Check(i.Destroy(), check.IsNil)
+	}
+	if *live == "" {
+		c.Check(s.fake.instances, check.HasLen, 0)
+	}
+}
+
+func (s *GCEInstanceSetSuite) TestLabels(c *check.C) {
+	c.Check(labels(cloud.InstanceTags{
+		"ArvadosInstanceType":   "Standard_DS1_v2.x",
+		"0key":                  "",
+		strings.Repeat("k", 70): strings.Repeat("V", 70),
+	}), check.DeepEquals, map[string]string{
+		"arvadosinstancetype":   "standard_ds1_v2_x",
+		"x0key":                 "",
+		strings.Repeat("k", 63): strings.Repeat("v", 63),
+	})
+}
+
+// FakeAPI is a fake GCE API server for tests outside this package
+// (see cloudtest_test.go). New instances get the given network
+// address.
+type FakeAPI struct {
+	*httptest.Server
+	fake *fakeGCE
+}
+
+func NewFakeAPI(networkIP string) *FakeAPI {
+	fake := &fakeGCE{
+		instances: map[string]*compute.Instance{},
+		ops:       map[string]*compute.Operation{},
+		networkIP: networkIP,
+	}
+	return &FakeAPI{Server: httptest.NewServer(fake), fake: fake}
+}
+
+// Metadata returns the values of the given metadata key on all
+// existing instances.
+func (f *FakeAPI) Metadata(key string) []string {
+	f.fake.Lock()
+	defer f.fake.Unlock()
+	var values []string
+	for _, inst := range f.fake.instances {
+		for _, item := range inst.Metadata.Items {
+			if item.Key == key && item.Value != nil {
+				values = append(values, *item.Value)
+			}
+		}
+	}
+	return values
+}
+
+// Instances returns the number of existing instances.
+func (f *FakeAPI) Instances() int {
+	f.fake.Lock()
+	defer f.fake.Unlock()
+	return len(f.fake.instances)
+}
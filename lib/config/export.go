@@ -100,6 +100,8 @@ var whitelist = map[string]bool{
 	"Collections.TrashSweepInterval":               false,
 	"Collections.TrustAllContent":                  false,
 	"Collections.WebDAVCache":                      false,
+	"Collections.WebDAVLockMaxTimeout":             false,
+	"Collections.WebDAVLocks":                      false,
 	"Collections.BalanceCollectionBatch":           false,
 	"Collections.BalancePeriod":                    false,
 	"Collections.BlobMissingReport":                false,
@@ -110,6 +112,7 @@ var whitelist = map[string]bool{
 	"Containers.CrunchRunArgumentsList":            false,
 	"Containers.DefaultKeepCacheRAM":               true,
 	"Containers.DispatchPrivateKey":                false,
+	"Containers.FairShare":                         false,
 	"Containers.JobsAPI":                           true,
 	"Containers.JobsAPI.Enable":                    true,
 	"Containers.JobsAPI.GitInternalDir":            false,
@@ -119,6 +122,9 @@ var whitelist = map[string]bool{
 	"Containers.MaxDispatchAttempts":               false,
 	"Containers.MaxRetryAttempts":                  true,
 	"Containers.MinRetryPeriod":                    true,
+	"Containers.PreemptibleFallbackAttempts":       false,
+	"Containers.PreemptibleFallbackDelay":          false,
+	"Containers.Quotas":                            false,
 	"Containers.ReserveExtraRAM":                   true,
 	"Containers.SLURM":                             false,
 	"Containers.StaleLockTimeout":                  false,
@@ -131,14 +137,44 @@ var whitelist = map[string]bool{
 	"InstanceTypes.*":                              true,
 	"InstanceTypes.*.*":                            true,
 	"Login":                                        true,
+	"Login.DeviceAuthorization":                    true,
+	"Login.DeviceAuthorization.CodeLifetime":       false,
+	"Login.DeviceAuthorization.Enable":             true,
+	"Login.DeviceAuthorization.PollInterval":       false,
+	"Login.DeviceAuthorization.TokenLifetime":      false,
+	"Login.DeviceAuthorization.TokenScopes":        false,
 	"Login.GoogleClientID":                         false,
 	"Login.GoogleClientSecret":                     false,
 	"Login.GoogleAlternateEmailAddresses":          false,
+	"Login.OpenIDConnect":                          true,
+	"Login.OpenIDConnect.ClientID":                 false,
+	"Login.OpenIDConnect.ClientSecret":             false,
+	"Login.OpenIDConnect.EmailClaim":               false,
+	"Login.OpenIDConnect.EmailVerifiedClaim":       false,
+	"Login.OpenIDConnect.Enable":                   true,
+	"Login.OpenIDConnect.Issuer":                   false,
+	"Login.OpenIDConnect.NameClaim":                false,
+	"Login.OpenIDConnect.Scopes":                   false,
+	"Login.OpenIDConnect.UsernameClaim":            false,
 	"Login.PAM":                                    true,
 	"Login.PAMService":                             false,
 	"Login.PAMDefaultEmailDomain":                  false,
 	"Login.ProviderAppID":                          false,
 	"Login.ProviderAppSecret":                      false,
+	"Login.LDAP":                                   true,
+	"Login.LDAP.AppendDomain":                      false,
+	"Login.LDAP.EmailAttribute":                    false,
+	"Login.LDAP.Enable":                            true,
+	"Login.LDAP.InsecureTLS":                       false,
+	"Login.LDAP.SearchAttribute":                   false,
+	"Login.LDAP.SearchBase":                        false,
+	"Login.LDAP.SearchBindPassword":                false,
+	"Login.LDAP.SearchBindUser":                    false,
+	"Login.LDAP.SearchFilters":                     false,
+	"Login.LDAP.StartTLS":                          false,
+	"Login.LDAP.StripDomain":                       false,
+	"Login.LDAP.URL":                               false,
+	"Login.LDAP.UsernameAttribute":                 false,
 	"Login.LoginCluster":                           true,
 	"Login.RemoteTokenRefresh":                     true,
 	"Mail":                                         true,
@@ -162,6 +198,10 @@ var whitelist = map[string]bool{
 	"Services.*":                                   true,
 	"Services.*.ExternalURL":                       true,
 	"Services.*.InternalURLs":                      false,
+	"StorageClasses":                               true,
+	"StorageClasses.*":                             true,
+	"StorageClasses.*.DataShards":                  true,
+	"StorageClasses.*.ParityShards":                true,
 	"SystemLogs":                                   false,
 	"SystemRootToken":                              false,
 	"TLS":                                          false,
@@ -16,6 +16,7 @@ import (
 	"strings"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/erasure"
 	"github.com/ghodss/yaml"
 	"github.com/imdario/mergo"
 	"github.com/sirupsen/logrus"
@@ -271,6 +272,8 @@ func (ldr *Loader) Load() (*arvados.Config, error) {
 			checkKeyConflict(fmt.Sprintf("Clusters.%s.PostgreSQL.Connection", id), cc.PostgreSQL.Connection),
 			ldr.checkEmptyKeepstores(cc),
 			ldr.checkUnlistedKeepstores(cc),
+			checkStorageClasses(cc),
+			checkWebDAVLocks(cc),
 		} {
 			if err != nil {
 				return nil, err
@@ -292,6 +295,32 @@ func checkKeyConflict(label string, m map[string]string) error {
 	return nil
 }
 
+func checkStorageClasses(cluster arvados.Cluster) error {
+	for class, sc := range cluster.StorageClasses {
+		if sc.DataShards == 0 && sc.ParityShards == 0 {
+			continue
+		}
+		err := (erasure.Scheme{DataShards: sc.DataShards, ParityShards: sc.ParityShards}).Validate()
+		if err != nil {
+			return fmt.Errorf("StorageClasses.%s: %s", class, err)
+		}
+	}
+	return nil
+}
+
+func checkWebDAVLocks(cluster arvados.Cluster) error {
+	switch cluster.Collections.WebDAVLocks {
+	case "memory", "none":
+	case "api":
+		if cluster.SystemRootToken == "" {
+			return errors.New("Collections.WebDAVLocks: \"api\" requires SystemRootToken")
+		}
+	default:
+		return fmt.Errorf("Collections.WebDAVLocks: unsupported value %q (should be \"memory\", \"api\", or \"none\")", cluster.Collections.WebDAVLocks)
+	}
+	return nil
+}
+
 func removeSampleKeys(m map[string]interface{}) {
 	delete(m, "SAMPLE")
 	for _, v := range m {
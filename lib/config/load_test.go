@@ -290,6 +290,40 @@ Clusters:
 	c.Check(err, check.ErrorMatches, `Clusters.zzzzz.PostgreSQL.Connection: multiple entries for "(dbname|host)".*`)
 }
 
+func (s *LoadSuite) TestStorageClassErasureCoding(c *check.C) {
+	cfg, err := testLoader(c, `
+Clusters:
+ zzzzz:
+  StorageClasses:
+   archive:
+    DataShards: 4
+    ParityShards: 2
+`, nil).Load()
+	c.Assert(err, check.IsNil)
+	c.Check(cfg.Clusters["zzzzz"].StorageClasses["archive"], check.Equals, arvados.StorageClassConfig{DataShards: 4, ParityShards: 2})
+
+	_, err = testLoader(c, `
+Clusters:
+ zzzzz:
+  StorageClasses:
+   archive:
+    DataShards: 4
+`, nil).Load()
+	c.Check(err, check.ErrorMatches, `StorageClasses.archive: invalid erasure coding scheme 4\+0.*`)
+}
+
+func (s *LoadSuite) TestWebDAVLocks(c *check.C) {
+	cfg, err := testLoader(c, `{"Clusters":{"zzzzz":{}}}`, nil).Load()
+	c.Assert(err, check.IsNil)
+	c.Check(cfg.Clusters["zzzzz"].Collections.WebDAVLocks, check.Equals, "memory")
+
+	_, err = testLoader(c, `{"Clusters":{"zzzzz":{"Collections":{"WebDAVLocks":"api"},"SystemRootToken":""}}}`, nil).Load()
+	c.Check(err, check.ErrorMatches, `Collections.WebDAVLocks: "api" requires SystemRootToken`)
+
+	_, err = testLoader(c, `{"Clusters":{"zzzzz":{"Collections":{"WebDAVLocks":"bogus"}}}}`, nil).Load()
+	c.Check(err, check.ErrorMatches, `Collections.WebDAVLocks: unsupported value "bogus".*`)
+}
+
 func (s *LoadSuite) TestBadType(c *check.C) {
 	for _, data := range []string{`
 Clusters:
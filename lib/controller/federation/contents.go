@@ -0,0 +1,249 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package federation
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+)
+
+// GroupContents returns the contents of a project (or a user's home
+// project, or everything shared with the current user if no UUID is
+// given).
+//
+// The query is sent to more than one cluster if options include
+// federated=true (all clusters) or a filter of the form
+// ["cluster_id","in",[a,b,c,...]] or ["cluster_id","=",a] (the
+// given clusters). In that case the same restrictions apply as for a
+// federated list query (see splitListRequest): count must be "none",
+// and limit, offset, and order must not be given. Each cluster's
+// results are retrieved in full, and the total number of items must
+// not exceed the local cluster's response page size limit.
+func (conn *Conn) GroupContents(ctx context.Context, options arvados.GroupContentsOptions) (arvados.ObjectList, error) {
+	clusterIDs, filters, err := conn.splitClusterIDFilters(options.Filters)
+	if err != nil {
+		return arvados.ObjectList{}, err
+	}
+	options.Filters = filters
+	// If the caller gave a cluster_id filter, clusterIDs is
+	// exactly the set of clusters they asked for.
+	filtered := clusterIDs != nil
+	federated := options.Federated
+	// Don't ask remote clusters to fan out again.
+	options.Federated = false
+	if clusterIDs == nil && federated {
+		clusterIDs = []string{conn.cluster.ClusterID}
+		for id := range conn.remotes {
+			clusterIDs = append(clusterIDs, id)
+		}
+		sort.Strings(clusterIDs)
+	}
+	if clusterIDs == nil {
+		if options.ClusterID != "" {
+			return conn.chooseBackend(options.ClusterID).GroupContents(ctx, options)
+		}
+		return conn.chooseBackend(options.UUID).GroupContents(ctx, options)
+	}
+	if len(options.UUID) == 27 && options.UUID[6:11] != "tpzed" {
+		// A project's contents are all stored on the
+		// project's own cluster. (A user's home project is
+		// different: a federated user can own objects on any
+		// cluster.) If that cluster is excluded by a
+		// cluster_id filter, nothing matches.
+		if filtered {
+			found := false
+			for _, id := range clusterIDs {
+				found = found || id == options.UUID[:5]
+			}
+			if !found {
+				return arvados.ObjectList{Items: []interface{}{}, Included: []interface{}{}}, nil
+			}
+		}
+		return conn.chooseBackend(options.UUID).GroupContents(ctx, options)
+	}
+	if len(clusterIDs) == 0 {
+		return arvados.ObjectList{Items: []interface{}{}, Included: []interface{}{}}, nil
+	}
+	if len(clusterIDs) == 1 {
+		// No need to merge results, so there is no need to
+		// restrict limit/offset/order.
+		be, err := conn.contentsBackend(clusterIDs[0])
+		if err != nil {
+			return arvados.ObjectList{}, err
+		}
+		return be.GroupContents(ctx, options)
+	}
+	if options.Count != "none" {
+		return arvados.ObjectList{}, httpErrorf(http.StatusBadRequest, "cannot execute federated contents query unless count==\"none\"")
+	}
+	if options.Limit >= 0 || options.Offset != 0 || len(options.Order) > 0 {
+		return arvados.ObjectList{}, httpErrorf(http.StatusBadRequest, "cannot execute federated contents query with limit, offset, or order parameter")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mtx sync.Mutex
+	var merged arvados.ObjectList
+	included := map[string]bool{}
+	max := conn.cluster.API.MaxItemsPerResponse
+	errs := make(chan error, len(clusterIDs))
+	for _, clusterID := range clusterIDs {
+		go func(clusterID string) {
+			// This goroutine sends exactly one value to
+			// errs.
+			be, err := conn.contentsBackend(clusterID)
+			if err != nil {
+				errs <- err
+				return
+			}
+			remoteOpts := options
+			remoteOpts.ClusterID = ""
+			for {
+				page, err := be.GroupContents(ctx, remoteOpts)
+				if err != nil {
+					errs <- httpErrorf(http.StatusBadGateway, err.Error())
+					return
+				}
+				mtx.Lock()
+				merged.Items = append(merged.Items, page.Items...)
+				for _, item := range page.Included {
+					uuid := itemUUID(item)
+					if uuid == "" || !included[uuid] {
+						included[uuid] = true
+						merged.Included = append(merged.Included, item)
+					}
+				}
+				n := len(merged.Items)
+				mtx.Unlock()
+				if max > 0 && n > max {
+					errs <- httpErrorf(http.StatusBadRequest, "cannot execute federated contents query because number of results exceeds page size limit %d", max)
+					return
+				}
+				if len(page.Items) == 0 {
+					// Zero items == no more
+					// results exist, no need to
+					// get another page.
+					break
+				}
+				remoteOpts.Offset += int64(len(page.Items))
+			}
+			errs <- nil
+		}(clusterID)
+	}
+
+	// Wait for all goroutines to return, then return the first
+	// non-nil error, if any.
+	var firstErr error
+	for range clusterIDs {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+			// Signal to any remaining calls that further
+			// effort is futile.
+			cancel()
+		}
+	}
+	if firstErr != nil {
+		return arvados.ObjectList{}, firstErr
+	}
+
+	// Apply the default/implied order, "modified_at desc"
+	sort.SliceStable(merged.Items, func(i, j int) bool {
+		return itemModifiedAt(merged.Items[j]).Before(itemModifiedAt(merged.Items[i]))
+	})
+	if merged.Items == nil {
+		merged.Items = []interface{}{}
+	}
+	if merged.Included == nil {
+		merged.Included = []interface{}{}
+	}
+	merged.ItemsAvailable = len(merged.Items)
+	return merged, nil
+}
+
+// splitClusterIDFilters removes any "cluster_id" filters from the
+// given filters and returns the cluster IDs that match all of them,
+// along with the remaining filters. If there are no cluster_id
+// filters, the returned cluster ID slice is nil.
+func (conn *Conn) splitClusterIDFilters(filters []arvados.Filter) ([]string, []arvados.Filter, error) {
+	var matchAll map[string]bool
+	var remaining []arvados.Filter
+	for _, f := range filters {
+		if f.Attr != "cluster_id" {
+			remaining = append(remaining, f)
+			continue
+		}
+		match := map[string]bool{}
+		switch operand := f.Operand.(type) {
+		case string:
+			if f.Operator != "=" {
+				return nil, nil, httpErrorf(http.StatusBadRequest, "invalid operator %q for cluster_id filter (must be \"=\" or \"in\")", f.Operator)
+			}
+			match[operand] = true
+		case []interface{}:
+			if f.Operator != "in" {
+				return nil, nil, httpErrorf(http.StatusBadRequest, "invalid operator %q for cluster_id filter (must be \"=\" or \"in\")", f.Operator)
+			}
+			for _, v := range operand {
+				if id, ok := v.(string); ok {
+					match[id] = true
+				}
+			}
+		case []string:
+			if f.Operator != "in" {
+				return nil, nil, httpErrorf(http.StatusBadRequest, "invalid operator %q for cluster_id filter (must be \"=\" or \"in\")", f.Operator)
+			}
+			for _, id := range operand {
+				match[id] = true
+			}
+		default:
+			return nil, nil, httpErrorf(http.StatusBadRequest, "invalid operand type %T for filter %q", f.Operand, f)
+		}
+		if matchAll == nil {
+			matchAll = match
+		} else {
+			for id := range matchAll {
+				if !match[id] {
+					delete(matchAll, id)
+				}
+			}
+		}
+	}
+	if matchAll == nil {
+		return nil, remaining, nil
+	}
+	clusterIDs := make([]string, 0, len(matchAll))
+	for id := range matchAll {
+		clusterIDs = append(clusterIDs, id)
+	}
+	sort.Strings(clusterIDs)
+	return clusterIDs, remaining, nil
+}
+
+func (conn *Conn) contentsBackend(clusterID string) (backend, error) {
+	if clusterID == conn.cluster.ClusterID {
+		return conn.local, nil
+	} else if be, ok := conn.remotes[clusterID]; ok {
+		return be, nil
+	}
+	return nil, httpErrorf(http.StatusNotFound, "cannot execute federated contents query: no proxy available for cluster %q", clusterID)
+}
+
+func itemUUID(item interface{}) string {
+	m, _ := item.(map[string]interface{})
+	uuid, _ := m["uuid"].(string)
+	return uuid
+}
+
+func itemModifiedAt(item interface{}) time.Time {
+	m, _ := item.(map[string]interface{})
+	s, _ := m["modified_at"].(string)
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
@@ -0,0 +1,194 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&GroupContentsSuite{})
+
+type contentsLister struct {
+	arvadostest.APIStub
+	ItemsToReturn []interface{}
+	MaxPageSize   int
+}
+
+func (cl *contentsLister) GroupContents(ctx context.Context, options arvados.GroupContentsOptions) (resp arvados.ObjectList, _ error) {
+	cl.APIStub.GroupContents(ctx, options)
+	resp.Items = []interface{}{}
+	for i := int(options.Offset); i < len(cl.ItemsToReturn); i++ {
+		if cl.MaxPageSize > 0 && len(resp.Items) >= cl.MaxPageSize {
+			break
+		}
+		if options.Limit >= 0 && int64(len(resp.Items)) >= options.Limit {
+			break
+		}
+		resp.Items = append(resp.Items, cl.ItemsToReturn[i])
+	}
+	resp.Included = []interface{}{
+		map[string]interface{}{"uuid": arvadostest.ActiveUserUUID},
+	}
+	return
+}
+
+type GroupContentsSuite struct {
+	FederationSuite
+	backends map[string]*contentsLister
+}
+
+func (s *GroupContentsSuite) SetUpTest(c *check.C) {
+	s.FederationSuite.SetUpTest(c)
+	s.cluster.API.MaxItemsPerResponse = 10
+	s.backends = map[string]*contentsLister{}
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, id := range []string{"aaaaa", "bbbbb", "ccccc"} {
+		cl := &contentsLister{MaxPageSize: 2}
+		for j := 0; j < 3; j++ {
+			cl.ItemsToReturn = append(cl.ItemsToReturn, map[string]interface{}{
+				"uuid":        fmt.Sprintf("%s-4zz18-%s%010d", id, id, j),
+				"modified_at": t0.Add(time.Duration(j*3+i) * time.Minute).Format(time.RFC3339Nano),
+			})
+		}
+		s.backends[id] = cl
+		if i == 0 {
+			s.fed.local = cl
+		} else if i == 1 {
+			s.addDirectRemote(c, id, cl)
+		} else {
+			s.addHTTPRemote(c, id, cl)
+		}
+	}
+}
+
+func (s *GroupContentsSuite) uuids(resp arvados.ObjectList) []string {
+	var uuids []string
+	for _, item := range resp.Items {
+		uuids = append(uuids, itemUUID(item))
+	}
+	return uuids
+}
+
+func (s *GroupContentsSuite) TestLocalOnly(c *check.C) {
+	resp, err := s.fed.GroupContents(s.ctx, arvados.GroupContentsOptions{Limit: -1})
+	c.Assert(err, check.IsNil)
+	c.Check(resp.Items, check.HasLen, 2)
+	c.Check(s.backends["aaaaa"].Calls(nil), check.HasLen, 1)
+	c.Check(s.backends["bbbbb"].Calls(nil), check.HasLen, 0)
+	c.Check(s.backends["ccccc"].Calls(nil), check.HasLen, 0)
+}
+
+func (s *GroupContentsSuite) TestSingleRemoteCluster(c *check.C) {
+	resp, err := s.fed.GroupContents(s.ctx, arvados.GroupContentsOptions{
+		Limit:   1,
+		Order:   []string{"name"},
+		Filters: []arvados.Filter{{Attr: "cluster_id", Operator: "=", Operand: "bbbbb"}},
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(s.uuids(resp), check.DeepEquals, []string{"bbbbb-4zz18-bbbbb0000000000"})
+	c.Check(s.backends["aaaaa"].Calls(nil), check.HasLen, 0)
+	calls := s.backends["bbbbb"].Calls(nil)
+	c.Assert(calls, check.HasLen, 1)
+	opts := calls[0].Options.(arvados.GroupContentsOptions)
+	c.Check(opts.Filters, check.HasLen, 0)
+	c.Check(opts.Limit, check.Equals, int64(1))
+}
+
+func (s *GroupContentsSuite) TestFederated(c *check.C) {
+	for _, opts := range []arvados.GroupContentsOptions{
+		{Limit: -1, Count: "none", Federated: true},
+		{Limit: -1, Count: "none", Filters: []arvados.Filter{{Attr: "cluster_id", Operator: "in", Operand: []interface{}{"aaaaa", "bbbbb", "ccccc"}}}},
+		{Limit: -1, Count: "none", Federated: true, UUID: "bbbbb-tpzed-xurymjxw79nv3jz"},
+	} {
+		for _, cl := range s.backends {
+			cl.APIStub = arvadostest.APIStub{}
+		}
+		resp, err := s.fed.GroupContents(s.ctx, opts)
+		c.Assert(err, check.IsNil)
+		c.Check(s.uuids(resp), check.DeepEquals, []string{
+			"ccccc-4zz18-ccccc0000000002",
+			"bbbbb-4zz18-bbbbb0000000002",
+			"aaaaa-4zz18-aaaaa0000000002",
+			"ccccc-4zz18-ccccc0000000001",
+			"bbbbb-4zz18-bbbbb0000000001",
+			"aaaaa-4zz18-aaaaa0000000001",
+			"ccccc-4zz18-ccccc0000000000",
+			"bbbbb-4zz18-bbbbb0000000000",
+			"aaaaa-4zz18-aaaaa0000000000",
+		})
+		c.Check(resp.Included, check.HasLen, 1)
+		c.Check(resp.ItemsAvailable, check.Equals, 9)
+		for id, cl := range s.backends {
+			// 2 items, 1 item, 0 items
+			calls := cl.Calls(nil)
+			c.Check(calls, check.HasLen, 3, check.Commentf("cluster %s", id))
+			for _, call := range calls {
+				c.Check(call.Options.(arvados.GroupContentsOptions).Federated, check.Equals, false)
+			}
+		}
+	}
+}
+
+func (s *GroupContentsSuite) TestFederatedProject(c *check.C) {
+	_, err := s.fed.GroupContents(s.ctx, arvados.GroupContentsOptions{Limit: -1, Federated: true, UUID: "ccccc-j7d0g-aaaaaaaaaaaaaaa"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.backends["aaaaa"].Calls(nil), check.HasLen, 0)
+	c.Check(s.backends["bbbbb"].Calls(nil), check.HasLen, 0)
+	c.Check(s.backends["ccccc"].Calls(nil), check.HasLen, 1)
+}
+
+func (s *GroupContentsSuite) TestProjectClusterFilter(c *check.C) {
+	resp, err := s.fed.GroupContents(s.ctx, arvados.GroupContentsOptions{
+		Limit:   -1,
+		UUID:    "ccccc-j7d0g-aaaaaaaaaaaaaaa",
+		Filters: []arvados.Filter{{Attr: "cluster_id", Operator: "=", Operand: "bbbbb"}},
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(resp.Items, check.HasLen, 0)
+	for id, cl := range s.backends {
+		c.Check(cl.Calls(nil), check.HasLen, 0, check.Commentf("cluster %s", id))
+	}
+
+	resp, err = s.fed.GroupContents(s.ctx, arvados.GroupContentsOptions{
+		Limit:   -1,
+		UUID:    "ccccc-j7d0g-aaaaaaaaaaaaaaa",
+		Filters: []arvados.Filter{{Attr: "cluster_id", Operator: "in", Operand: []interface{}{"bbbbb", "ccccc"}}},
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(resp.Items, check.HasLen, 2)
+	c.Check(s.backends["bbbbb"].Calls(nil), check.HasLen, 0)
+	c.Check(s.backends["ccccc"].Calls(nil), check.HasLen, 1)
+}
+
+func (s *GroupContentsSuite) TestFederatedRestrictions(c *check.C) {
+	for _, trial := range []struct {
+		opts      arvados.GroupContentsOptions
+		expectErr string
+	}{
+		{arvados.GroupContentsOptions{Limit: -1, Federated: true}, `.*count=="none".*`},
+		{arvados.GroupContentsOptions{Limit: 5, Count: "none", Federated: true}, `.*limit, offset, or order.*`},
+		{arvados.GroupContentsOptions{Limit: -1, Offset: 1, Count: "none", Federated: true}, `.*limit, offset, or order.*`},
+		{arvados.GroupContentsOptions{Limit: -1, Order: []string{"name"}, Count: "none", Federated: true}, `.*limit, offset, or order.*`},
+		{arvados.GroupContentsOptions{Limit: -1, Count: "none", Filters: []arvados.Filter{{Attr: "cluster_id", Operator: "in", Operand: []string{"aaaaa", "zzzzz"}}}}, `.*no proxy available for cluster "zzzzz".*`},
+		{arvados.GroupContentsOptions{Limit: -1, Count: "none", Filters: []arvados.Filter{{Attr: "cluster_id", Operator: "like", Operand: "aaaaa"}}}, `.*invalid operator.*`},
+	} {
+		_, err := s.fed.GroupContents(s.ctx, trial.opts)
+		c.Check(err, check.ErrorMatches, trial.expectErr, check.Commentf("%#v", trial.opts))
+	}
+}
+
+func (s *GroupContentsSuite) TestFederatedTooManyResults(c *check.C) {
+	s.cluster.API.MaxItemsPerResponse = 5
+	_, err := s.fed.GroupContents(s.ctx, arvados.GroupContentsOptions{Limit: -1, Count: "none", Federated: true})
+	c.Check(err, check.ErrorMatches, `.*exceeds page size limit 5.*`)
+	c.Check(errStatus(err), check.Equals, http.StatusBadRequest)
+}
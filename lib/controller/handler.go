@@ -81,6 +81,10 @@ func (h *Handler) setup() {
 		Routes: health.Routes{"ping": func() error { _, err := h.db(&http.Request{}); return err }},
 	})
 
+	hs := http.NotFoundHandler()
+	hs = prepend(hs, h.proxyRailsAPI)
+	hs = h.setupProxyRemoteCluster(hs)
+
 	rtr := router.New(federation.New(h.Cluster))
 	mux.Handle("/arvados/v1/config", rtr)
 	mux.Handle("/"+arvados.EndpointUserAuthenticate.Path, rtr)
@@ -88,15 +92,29 @@ func (h *Handler) setup() {
 	if !h.Cluster.ForceLegacyAPI14 {
 		mux.Handle("/arvados/v1/collections", rtr)
 		mux.Handle("/arvados/v1/collections/", rtr)
+		mux.Handle("/arvados/v1/groups", rtr)
+		mux.Handle("/arvados/v1/groups/", rtr)
+		mux.Handle("/arvados/v1/links", rtr)
+		mux.Handle("/arvados/v1/links/", rtr)
+		// Creating a container request on a remote cluster
+		// involves issuing a runtime token, which is still
+		// done by the legacy federation handler (see
+		// fed_containers.go).
+		mux.Handle("/arvados/v1/container_requests", postTo(hs, rtr))
+		mux.Handle("/arvados/v1/container_requests/", rtr)
 		mux.Handle("/arvados/v1/users", rtr)
 		mux.Handle("/arvados/v1/users/", rtr)
 		mux.Handle("/login", rtr)
 		mux.Handle("/logout", rtr)
+		mux.Handle("/device", rtr)
+		mux.Handle("/arvados/v1/device_authorizations", rtr)
+		mux.Handle("/arvados/v1/device_authorizations/", rtr)
 	}
 
-	hs := http.NotFoundHandler()
-	hs = prepend(hs, h.proxyRailsAPI)
-	hs = h.setupProxyRemoteCluster(hs)
+	// The container log endpoint is only implemented by rtr;
+	// other container APIs are still handled by the legacy
+	// federation handler.
+	mux.Handle("/arvados/v1/containers/", suffixTo("/log", rtr, hs))
 	mux.Handle("/", hs)
 	h.handlerStack = mux
 
@@ -140,6 +158,30 @@ func (h *Handler) db(req *http.Request) (*sql.DB, error) {
 
 type middlewareFunc func(http.ResponseWriter, *http.Request, http.Handler)
 
+// postTo returns a handler that sends POST requests to postHandler
+// and all other requests to otherHandler.
+func postTo(postHandler, otherHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" {
+			postHandler.ServeHTTP(w, req)
+		} else {
+			otherHandler.ServeHTTP(w, req)
+		}
+	})
+}
+
+// suffixTo sends requests whose path ends with suffix to
+// suffixHandler, and all other requests to otherHandler.
+func suffixTo(suffix string, suffixHandler, otherHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, suffix) {
+			suffixHandler.ServeHTTP(w, req)
+		} else {
+			otherHandler.ServeHTTP(w, req)
+		}
+	})
+}
+
 func prepend(next http.Handler, middleware middlewareFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		middleware(w, req, next)
@@ -18,14 +18,16 @@ type Conn struct {
 	cluster     *arvados.Cluster
 	*railsProxy // handles API methods that aren't defined on Conn itself
 	loginController
+	deviceAuthorizer *deviceAuthorizer
 }
 
 func NewConn(cluster *arvados.Cluster) *Conn {
 	railsProxy := railsproxy.NewConn(cluster)
 	return &Conn{
-		cluster:         cluster,
-		railsProxy:      railsProxy,
-		loginController: chooseLoginController(cluster, railsProxy),
+		cluster:          cluster,
+		railsProxy:       railsProxy,
+		loginController:  chooseLoginController(cluster, railsProxy),
+		deviceAuthorizer: &deviceAuthorizer{Cluster: cluster, RailsProxy: railsProxy},
 	}
 }
 
@@ -40,3 +42,19 @@ func (conn *Conn) Login(ctx context.Context, opts arvados.LoginOptions) (arvados
 func (conn *Conn) UserAuthenticate(ctx context.Context, opts arvados.UserAuthenticateOptions) (arvados.APIClientAuthorization, error) {
 	return conn.loginController.UserAuthenticate(ctx, opts)
 }
+
+func (conn *Conn) DeviceAuthorizationCreate(ctx context.Context) (arvados.DeviceAuthorization, error) {
+	return conn.deviceAuthorizer.Create(ctx)
+}
+
+func (conn *Conn) DeviceAuthorizationToken(ctx context.Context, opts arvados.DeviceAuthorizationTokenOptions) (arvados.APIClientAuthorization, error) {
+	return conn.deviceAuthorizer.Token(ctx, opts)
+}
+
+func (conn *Conn) DeviceAuthorizationVerify(ctx context.Context, opts arvados.DeviceAuthorizationVerifyOptions) (arvados.LoginResponse, error) {
+	return conn.deviceAuthorizer.Verify(ctx, opts)
+}
+
+func (conn *Conn) DeviceAuthorizationApprove(ctx context.Context, opts arvados.DeviceAuthorizationVerifyOptions) (arvados.LoginResponse, error) {
+	return conn.deviceAuthorizer.Approve(ctx, opts)
+}
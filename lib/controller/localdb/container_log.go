@@ -0,0 +1,124 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package localdb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+)
+
+// Log event types written by crunch-run for a container. Other log
+// entries with the container's UUID as object_uuid (e.g., "update"
+// audit logs) are not part of the container log.
+var containerLogEventTypes = []string{"crunch-run", "stdout", "stderr", "arv-mount", "crunchstat", "hoststat", "node", "node-info", "container"}
+
+// containerLogPollInterval is the time between queries for new log
+// entries when following a container log.
+var containerLogPollInterval = time.Second
+
+// ContainerLog checks that the container exists and is readable,
+// and returns a handler that streams its log entries as
+// newline-delimited JSON (one arvados.ContainerLogEvent per line).
+func (conn *Conn) ContainerLog(ctx context.Context, opts arvados.ContainerLogOptions) (http.Handler, error) {
+	ctr, err := conn.railsProxy.ContainerGet(ctx, arvados.GetOptions{UUID: opts.UUID, Select: containerStateSelect})
+	if err != nil {
+		return nil, err
+	}
+	return &containerLogStream{
+		ctx:   ctx,
+		conn:  conn,
+		opts:  opts,
+		state: ctr.State,
+	}, nil
+}
+
+var containerStateSelect = []string{"uuid", "state"}
+
+type containerLogStream struct {
+	ctx   context.Context // includes the caller's credentials
+	conn  *Conn
+	opts  arvados.ContainerLogOptions
+	state arvados.ContainerState
+}
+
+func (s *containerLogStream) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := s.ctx
+	logger := ctxlog.FromContext(ctx)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	after := s.opts.After
+	for {
+		// Check the container state before getting logs, so
+		// we don't miss entries added between our last log
+		// query and the container finishing.
+		final := s.state == arvados.ContainerStateComplete || s.state == arvados.ContainerStateCancelled
+		n, err := s.sendPage(ctx, enc, &after)
+		if err != nil {
+			logger.WithError(err).Error("error sending container log")
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if n > 0 {
+			// There might be more entries ready to send.
+			continue
+		}
+		if !s.opts.Follow || final {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(containerLogPollInterval):
+		}
+		ctr, err := s.conn.railsProxy.ContainerGet(ctx, arvados.GetOptions{UUID: s.opts.UUID, Select: containerStateSelect})
+		if err != nil {
+			logger.WithError(err).Error("error getting container state")
+			return
+		}
+		s.state = ctr.State
+	}
+}
+
+// sendPage sends one page of log entries with IDs greater than
+// *after, updates *after, and returns the number of entries sent.
+func (s *containerLogStream) sendPage(ctx context.Context, enc *json.Encoder, after *uint64) (int, error) {
+	logs, err := s.conn.railsProxy.LogList(ctx, arvados.ListOptions{
+		Select: []string{"id", "event_type", "created_at", "properties"},
+		Filters: []arvados.Filter{
+			{Attr: "object_uuid", Operator: "=", Operand: s.opts.UUID},
+			{Attr: "event_type", Operator: "in", Operand: containerLogEventTypes},
+			{Attr: "id", Operator: ">", Operand: *after},
+		},
+		Order: []string{"id"},
+		Count: "none",
+		Limit: -1,
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, l := range logs.Items {
+		text, _ := l.Properties["text"].(string)
+		err = enc.Encode(arvados.ContainerLogEvent{
+			ID:        l.ID,
+			EventType: l.EventType,
+			CreatedAt: l.CreatedAt,
+			Text:      text,
+		})
+		if err != nil {
+			return 0, err
+		}
+		*after = l.ID
+	}
+	return len(logs.Items), nil
+}
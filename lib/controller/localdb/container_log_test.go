@@ -0,0 +1,162 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package localdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/controller/railsproxy"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	"git.arvados.org/arvados.git/sdk/go/auth"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&ContainerLogSuite{})
+
+// ContainerLogSuite tests ContainerLog using a stub RailsAPI server.
+type ContainerLogSuite struct {
+	conn *Conn
+	stub *httptest.Server
+
+	mtx      sync.Mutex
+	state    arvados.ContainerState
+	logs     []arvados.Log
+	logCalls int
+	// called (with mtx locked) each time logs are listed
+	onList func()
+}
+
+const containerLogUUID = "zzzzz-dz642-logstreamtest01"
+
+func (s *ContainerLogSuite) SetUpTest(c *check.C) {
+	s.state = arvados.ContainerStateRunning
+	s.logs = nil
+	s.logCalls = 0
+	s.onList = nil
+	s.stub = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		if req.Header.Get("Authorization") != "Bearer "+arvadostest.ActiveToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Path {
+		case "/arvados/v1/containers/" + containerLogUUID:
+			json.NewEncoder(w).Encode(arvados.Container{UUID: containerLogUUID, State: s.state})
+		case "/arvados/v1/logs":
+			var filters []arvados.Filter
+			err := json.Unmarshal([]byte(req.FormValue("filters")), &filters)
+			c.Check(err, check.IsNil)
+			c.Check(filters, check.HasLen, 3)
+			after := uint64(filters[2].Operand.(float64))
+			s.logCalls++
+			if s.onList != nil {
+				s.onList()
+			}
+			var resp arvados.LogList
+			for _, l := range s.logs {
+				if l.ID > after {
+					resp.Items = append(resp.Items, l)
+				}
+			}
+			json.NewEncoder(w).Encode(resp)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	cluster := &arvados.Cluster{ClusterID: "zzzzz"}
+	arvadostest.SetServiceURL(&cluster.Services.RailsAPI, s.stub.URL)
+	s.conn = &Conn{cluster: cluster, railsProxy: railsproxy.NewConn(cluster)}
+	containerLogPollInterval = time.Millisecond
+}
+
+func (s *ContainerLogSuite) TearDownTest(c *check.C) {
+	s.stub.Close()
+}
+
+func (s *ContainerLogSuite) addLog(id uint64, eventType, text string) {
+	s.logs = append(s.logs, arvados.Log{
+		ID:         id,
+		EventType:  eventType,
+		Properties: map[string]interface{}{"text": text},
+	})
+}
+
+func (s *ContainerLogSuite) get(c *check.C, opts arvados.ContainerLogOptions) ([]arvados.ContainerLogEvent, *httptest.ResponseRecorder) {
+	ctx := auth.NewContext(context.Background(), &auth.Credentials{Tokens: []string{arvadostest.ActiveToken}})
+	h, err := s.conn.ContainerLog(ctx, opts)
+	c.Assert(err, check.IsNil)
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	var events []arvados.ContainerLogEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var ev arvados.ContainerLogEvent
+		c.Check(json.Unmarshal(scanner.Bytes(), &ev), check.IsNil)
+		events = append(events, ev)
+	}
+	return events, resp
+}
+
+func (s *ContainerLogSuite) TestGet(c *check.C) {
+	s.addLog(3, "crunch-run", "hello\n")
+	s.addLog(5, "stderr", "oops\n")
+	events, resp := s.get(c, arvados.ContainerLogOptions{UUID: containerLogUUID})
+	c.Check(resp.Code, check.Equals, http.StatusOK)
+	c.Check(resp.Header().Get("Content-Type"), check.Equals, "application/x-ndjson")
+	c.Assert(events, check.HasLen, 2)
+	c.Check(events[0].ID, check.Equals, uint64(3))
+	c.Check(events[0].EventType, check.Equals, "crunch-run")
+	c.Check(events[0].Text, check.Equals, "hello\n")
+	c.Check(events[1].EventType, check.Equals, "stderr")
+
+	events, _ = s.get(c, arvados.ContainerLogOptions{UUID: containerLogUUID, After: 3})
+	c.Assert(events, check.HasLen, 1)
+	c.Check(events[0].ID, check.Equals, uint64(5))
+}
+
+func (s *ContainerLogSuite) TestFollow(c *check.C) {
+	s.addLog(1, "crunch-run", "starting\n")
+	s.onList = func() {
+		switch s.logCalls {
+		case 3:
+			s.addLog(2, "stdout", "working\n")
+		case 5:
+			s.state = arvados.ContainerStateComplete
+			s.addLog(3, "crunch-run", "finished\n")
+		}
+	}
+	events, resp := s.get(c, arvados.ContainerLogOptions{UUID: containerLogUUID, Follow: true})
+	c.Check(resp.Code, check.Equals, http.StatusOK)
+	c.Assert(events, check.HasLen, 3)
+	for i, ev := range events {
+		c.Check(ev.ID, check.Equals, uint64(i+1))
+	}
+}
+
+func (s *ContainerLogSuite) TestErrors(c *check.C) {
+	for _, trial := range []struct {
+		uuid  string
+		token string
+		code  int
+	}{
+		{containerLogUUID, arvadostest.SpectatorToken, http.StatusUnauthorized},
+		{"zzzzz-dz642-nonexistent0000", arvadostest.ActiveToken, http.StatusNotFound},
+	} {
+		ctx := auth.NewContext(context.Background(), &auth.Credentials{Tokens: []string{trial.token}})
+		_, err := s.conn.ContainerLog(ctx, arvados.ContainerLogOptions{UUID: trial.uuid})
+		c.Assert(err, check.NotNil, check.Commentf("%+v", trial))
+		c.Check(err.(interface{ HTTPStatus() int }).HTTPStatus(), check.Equals, trial.code, check.Commentf("%+v", trial))
+	}
+	_, err := s.conn.ContainerLog(context.Background(), arvados.ContainerLogOptions{UUID: containerLogUUID})
+	c.Check(err, check.ErrorMatches, `no token provided`)
+}
@@ -0,0 +1,311 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package localdb
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/auth"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"git.arvados.org/arvados.git/sdk/go/httpserver"
+)
+
+// Characters used in user codes. Vowels are omitted to avoid
+// spelling words, and the remaining letters are unambiguous when
+// read aloud or typed on a phone keyboard.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+const deviceCodeAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// Error codes returned by the token endpoint, as specified by RFC
+// 8628 section 3.5.
+var (
+	errAuthorizationPending = httpserver.ErrorWithStatus(errors.New("authorization_pending"), http.StatusBadRequest)
+	errSlowDown             = httpserver.ErrorWithStatus(errors.New("slow_down"), http.StatusBadRequest)
+	errExpiredToken         = httpserver.ErrorWithStatus(errors.New("expired_token"), http.StatusBadRequest)
+	errDeviceAuthDisabled   = httpserver.ErrorWithStatus(errors.New("device authorization is not enabled on this cluster"), http.StatusNotFound)
+)
+
+type pendingDeviceAuth struct {
+	userCode   string
+	expires    time.Time
+	lastPoll   time.Time
+	approving  bool // a token is being created by Approve
+	approved   bool
+	authorized arvados.APIClientAuthorization
+}
+
+// deviceAuthorizer implements the OAuth2 device authorization flow
+// (RFC 8628).
+//
+// Pending requests are kept in memory, so this only works when a
+// single controller process handles the device authorization
+// endpoints: a device must poll the same process that issued its
+// code, and the user must approve it there too.
+type deviceAuthorizer struct {
+	Cluster    *arvados.Cluster
+	RailsProxy *railsProxy
+
+	mtx        sync.Mutex
+	byDevice   map[string]*pendingDeviceAuth
+	byUserCode map[string]string // user code => device code
+}
+
+// Create starts a new device authorization request.
+func (da *deviceAuthorizer) Create(ctx context.Context) (arvados.DeviceAuthorization, error) {
+	conf := da.Cluster.Login.DeviceAuthorization
+	if !conf.Enable {
+		return arvados.DeviceAuthorization{}, errDeviceAuthDisabled
+	}
+	deviceCode, err := randomString(deviceCodeAlphabet, 40)
+	if err != nil {
+		return arvados.DeviceAuthorization{}, err
+	}
+	verifyURL, err := (*url.URL)(&da.Cluster.Services.Controller.ExternalURL).Parse("/" + arvados.EndpointDeviceAuthorizationVerify.Path)
+	if err != nil {
+		return arvados.DeviceAuthorization{}, err
+	}
+
+	da.mtx.Lock()
+	defer da.mtx.Unlock()
+	da.sweep(time.Now())
+	var userCode string
+	for {
+		code, err := randomString(userCodeAlphabet, 8)
+		if err != nil {
+			return arvados.DeviceAuthorization{}, err
+		}
+		userCode = code[:4] + "-" + code[4:]
+		if _, dup := da.byUserCode[userCode]; !dup {
+			break
+		}
+	}
+	if da.byDevice == nil {
+		da.byDevice = map[string]*pendingDeviceAuth{}
+		da.byUserCode = map[string]string{}
+	}
+	da.byDevice[deviceCode] = &pendingDeviceAuth{
+		userCode: userCode,
+		expires:  time.Now().Add(conf.CodeLifetime.Duration()),
+	}
+	da.byUserCode[userCode] = deviceCode
+
+	complete := *verifyURL
+	complete.RawQuery = url.Values{"user_code": {userCode}}.Encode()
+	return arvados.DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verifyURL.String(),
+		VerificationURIComplete: complete.String(),
+		ExpiresIn:               int(conf.CodeLifetime.Duration().Seconds()),
+		Interval:                int(conf.PollInterval.Duration().Seconds()),
+	}, nil
+}
+
+// Token returns the token issued for the given device code, if the
+// user has approved the request. Otherwise it returns one of the
+// errors specified by RFC 8628: authorization_pending, slow_down, or
+// expired_token.
+func (da *deviceAuthorizer) Token(ctx context.Context, opts arvados.DeviceAuthorizationTokenOptions) (arvados.APIClientAuthorization, error) {
+	if !da.Cluster.Login.DeviceAuthorization.Enable {
+		return arvados.APIClientAuthorization{}, errDeviceAuthDisabled
+	}
+	now := time.Now()
+	da.mtx.Lock()
+	defer da.mtx.Unlock()
+	da.sweep(now)
+	pending, ok := da.byDevice[opts.DeviceCode]
+	if !ok {
+		return arvados.APIClientAuthorization{}, errExpiredToken
+	}
+	if pending.approved {
+		delete(da.byDevice, opts.DeviceCode)
+		delete(da.byUserCode, pending.userCode)
+		return pending.authorized, nil
+	}
+	lastPoll := pending.lastPoll
+	pending.lastPoll = now
+	if now.Sub(lastPoll) < da.Cluster.Login.DeviceAuthorization.PollInterval.Duration() {
+		return arvados.APIClientAuthorization{}, errSlowDown
+	}
+	return arvados.APIClientAuthorization{}, errAuthorizationPending
+}
+
+// Verify shows the user a page asking them to confirm the request
+// identified by the given user code. If the request does not carry
+// a token, the user is redirected to log in first.
+func (da *deviceAuthorizer) Verify(ctx context.Context, opts arvados.DeviceAuthorizationVerifyOptions) (arvados.LoginResponse, error) {
+	if !da.Cluster.Login.DeviceAuthorization.Enable {
+		return loginError(errDeviceAuthDisabled)
+	}
+	userCode := normalizeUserCode(opts.UserCode)
+	token := currentToken(ctx)
+	if token == "" {
+		verifyURL, err := (*url.URL)(&da.Cluster.Services.Controller.ExternalURL).Parse("/" + arvados.EndpointDeviceAuthorizationVerify.Path)
+		if err != nil {
+			return loginError(err)
+		}
+		verifyURL.RawQuery = url.Values{"user_code": {userCode}}.Encode()
+		loginURL, err := (*url.URL)(&da.Cluster.Services.Controller.ExternalURL).Parse("/" + arvados.EndpointLogin.Path)
+		if err != nil {
+			return loginError(err)
+		}
+		loginURL.RawQuery = url.Values{"return_to": {verifyURL.String()}}.Encode()
+		return arvados.LoginResponse{RedirectLocation: loginURL.String()}, nil
+	}
+	var resp arvados.LoginResponse
+	err := deviceVerifyForm.Execute(&resp.HTML, map[string]string{
+		"UserCode": userCode,
+		"Token":    token,
+		"Action":   "/" + arvados.EndpointDeviceAuthorizationApprove.Path,
+	})
+	return resp, err
+}
+
+// Approve issues a token on behalf of the current user and makes it
+// available to the device that is polling with the device code
+// corresponding to the given user code.
+func (da *deviceAuthorizer) Approve(ctx context.Context, opts arvados.DeviceAuthorizationVerifyOptions) (arvados.LoginResponse, error) {
+	conf := da.Cluster.Login.DeviceAuthorization
+	if !conf.Enable {
+		return loginError(errDeviceAuthDisabled)
+	}
+	if currentToken(ctx) == "" {
+		return loginError(errors.New("not logged in"))
+	}
+	userCode := normalizeUserCode(opts.UserCode)
+	errInvalid := fmt.Errorf("code %q is invalid or has expired", userCode)
+
+	// Reserve the request before creating a token, so concurrent
+	// or repeated approvals don't create tokens that nobody will
+	// receive.
+	da.mtx.Lock()
+	da.sweep(time.Now())
+	deviceCode, ok := da.byUserCode[userCode]
+	pending := da.byDevice[deviceCode]
+	if !ok || pending == nil {
+		da.mtx.Unlock()
+		return loginError(errInvalid)
+	} else if pending.approving || pending.approved {
+		da.mtx.Unlock()
+		return loginError(fmt.Errorf("code %q has already been approved", userCode))
+	}
+	pending.approving = true
+	da.mtx.Unlock()
+
+	attrs := map[string]interface{}{
+		"scopes": conf.TokenScopes,
+	}
+	if conf.TokenLifetime > 0 {
+		attrs["expires_at"] = time.Now().UTC().Add(conf.TokenLifetime.Duration())
+	}
+	aca, err := da.RailsProxy.APIClientAuthorizationCreate(ctx, arvados.CreateOptions{Attrs: attrs})
+	if err != nil {
+		da.mtx.Lock()
+		pending.approving = false
+		da.mtx.Unlock()
+		return loginError(fmt.Errorf("error creating token: %s", err))
+	}
+
+	da.mtx.Lock()
+	if da.byDevice[deviceCode] != pending || time.Now().After(pending.expires) {
+		// The request expired while we were creating the
+		// token.
+		da.mtx.Unlock()
+		_, err := da.RailsProxy.APIClientAuthorizationDelete(ctx, arvados.DeleteOptions{UUID: aca.UUID})
+		if err != nil {
+			ctxlog.FromContext(ctx).WithError(err).WithField("UUID", aca.UUID).Error("error deleting unused device authorization token")
+		}
+		return loginError(errInvalid)
+	}
+	pending.approving = false
+	pending.approved = true
+	pending.authorized = aca
+	da.mtx.Unlock()
+	ctxlog.FromContext(ctx).WithField("UUID", aca.UUID).Info("device authorization approved")
+
+	var resp arvados.LoginResponse
+	err = deviceApprovedPage.Execute(&resp.HTML, nil)
+	return resp, err
+}
+
+// sweep removes expired requests. Caller must have lock.
+func (da *deviceAuthorizer) sweep(now time.Time) {
+	for deviceCode, pending := range da.byDevice {
+		if now.After(pending.expires) {
+			delete(da.byDevice, deviceCode)
+			delete(da.byUserCode, pending.userCode)
+		}
+	}
+}
+
+// normalizeUserCode accepts user codes typed with or without the
+// dash, in either case, and with stray whitespace.
+func normalizeUserCode(code string) string {
+	code = strings.ToUpper(strings.Join(strings.Fields(code), ""))
+	code = strings.Replace(code, "-", "", -1)
+	if len(code) == 8 {
+		code = code[:4] + "-" + code[4:]
+	}
+	return code
+}
+
+func currentToken(ctx context.Context) string {
+	creds, ok := auth.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, t := range creds.Tokens {
+		if t != "" {
+			return t
+		}
+	}
+	return ""
+}
+
+func randomString(alphabet string, length int) (string, error) {
+	max := big.NewInt(int64(len(alphabet)))
+	buf := make([]byte, length)
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = alphabet[n.Int64()]
+	}
+	return string(buf), nil
+}
+
+var deviceVerifyForm = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html><head><title>Authorize device</title></head>
+<body>
+<h2>Authorize device</h2>
+<form method="POST" action="{{.Action}}">
+<p>Confirm that this code matches the one displayed on your device. If you approve, the device will be able to access Arvados on your behalf.</p>
+<p><label>Code <input name="user_code" type="text" value="{{.UserCode}}"></label></p>
+<input name="api_token" type="hidden" value="{{.Token}}">
+<p><input type="submit" value="Approve"></p>
+</form>
+</body></html>
+`))
+
+var deviceApprovedPage = template.Must(template.New("approved").Parse(`<!DOCTYPE html>
+<html><head><title>Device authorized</title></head>
+<body>
+<h2>Device authorized</h2>
+<p>You can close this window and return to your device.</p>
+</body></html>
+`))
@@ -0,0 +1,150 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package localdb
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/config"
+	"git.arvados.org/arvados.git/lib/controller/railsproxy"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	"git.arvados.org/arvados.git/sdk/go/auth"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&DeviceAuthorizationSuite{})
+
+type DeviceAuthorizationSuite struct {
+	cluster *arvados.Cluster
+	da      *deviceAuthorizer
+}
+
+func (s *DeviceAuthorizationSuite) SetUpTest(c *check.C) {
+	cfg, err := config.NewLoader(nil, ctxlog.TestLogger(c)).Load()
+	c.Assert(err, check.IsNil)
+	s.cluster, err = cfg.GetCluster("")
+	c.Assert(err, check.IsNil)
+	s.cluster.Login.DeviceAuthorization.Enable = true
+	s.cluster.Login.DeviceAuthorization.CodeLifetime = arvados.Duration(time.Minute)
+	s.cluster.Login.DeviceAuthorization.PollInterval = arvados.Duration(time.Hour)
+	s.cluster.Login.DeviceAuthorization.TokenLifetime = arvados.Duration(time.Hour)
+	s.da = &deviceAuthorizer{
+		Cluster:    s.cluster,
+		RailsProxy: railsproxy.NewConn(s.cluster),
+	}
+}
+
+func (s *DeviceAuthorizationSuite) TestDisabled(c *check.C) {
+	s.cluster.Login.DeviceAuthorization.Enable = false
+	_, err := s.da.Create(context.Background())
+	c.Check(err, check.ErrorMatches, `.*not enabled.*`)
+	_, err = s.da.Token(context.Background(), arvados.DeviceAuthorizationTokenOptions{DeviceCode: "abc"})
+	c.Check(err, check.ErrorMatches, `.*not enabled.*`)
+}
+
+func (s *DeviceAuthorizationSuite) TestCreate(c *check.C) {
+	resp, err := s.da.Create(context.Background())
+	c.Assert(err, check.IsNil)
+	c.Check(resp.DeviceCode, check.Matches, `[a-z0-9]{40}`)
+	c.Check(resp.UserCode, check.Matches, `[B-Z]{4}-[B-Z]{4}`)
+	c.Check(resp.ExpiresIn, check.Equals, 60)
+	c.Check(resp.Interval, check.Equals, 3600)
+	target, err := url.Parse(resp.VerificationURIComplete)
+	c.Assert(err, check.IsNil)
+	c.Check(target.Path, check.Equals, "/device")
+	c.Check(target.Query().Get("user_code"), check.Equals, resp.UserCode)
+	c.Check(resp.VerificationURI, check.Equals, "https://"+s.cluster.Services.Controller.ExternalURL.Host+"/device")
+}
+
+func (s *DeviceAuthorizationSuite) TestTokenPending(c *check.C) {
+	resp, err := s.da.Create(context.Background())
+	c.Assert(err, check.IsNil)
+	opts := arvados.DeviceAuthorizationTokenOptions{DeviceCode: resp.DeviceCode}
+	_, err = s.da.Token(context.Background(), opts)
+	c.Check(err, check.ErrorMatches, `authorization_pending`)
+	c.Check(err.(interface{ HTTPStatus() int }).HTTPStatus(), check.Equals, 400)
+	// Polling again before PollInterval elapses
+	_, err = s.da.Token(context.Background(), opts)
+	c.Check(err, check.ErrorMatches, `slow_down`)
+}
+
+func (s *DeviceAuthorizationSuite) TestTokenExpired(c *check.C) {
+	s.cluster.Login.DeviceAuthorization.CodeLifetime = arvados.Duration(-time.Second)
+	resp, err := s.da.Create(context.Background())
+	c.Assert(err, check.IsNil)
+	_, err = s.da.Token(context.Background(), arvados.DeviceAuthorizationTokenOptions{DeviceCode: resp.DeviceCode})
+	c.Check(err, check.ErrorMatches, `expired_token`)
+	_, err = s.da.Token(context.Background(), arvados.DeviceAuthorizationTokenOptions{DeviceCode: "bogus"})
+	c.Check(err, check.ErrorMatches, `expired_token`)
+}
+
+func (s *DeviceAuthorizationSuite) TestVerifyRedirectsToLogin(c *check.C) {
+	resp, err := s.da.Verify(context.Background(), arvados.DeviceAuthorizationVerifyOptions{UserCode: "bcdf ghjk"})
+	c.Assert(err, check.IsNil)
+	target, err := url.Parse(resp.RedirectLocation)
+	c.Assert(err, check.IsNil)
+	c.Check(target.Path, check.Equals, "/login")
+	returnTo, err := url.Parse(target.Query().Get("return_to"))
+	c.Assert(err, check.IsNil)
+	c.Check(returnTo.Path, check.Equals, "/device")
+	c.Check(returnTo.Query().Get("user_code"), check.Equals, "BCDF-GHJK")
+}
+
+func (s *DeviceAuthorizationSuite) TestVerifyShowsForm(c *check.C) {
+	ctx := auth.NewContext(context.Background(), &auth.Credentials{Tokens: []string{arvadostest.ActiveTokenV2}})
+	resp, err := s.da.Verify(ctx, arvados.DeviceAuthorizationVerifyOptions{UserCode: "BCDF-GHJK"})
+	c.Assert(err, check.IsNil)
+	c.Check(resp.RedirectLocation, check.Equals, "")
+	c.Check(resp.HTML.String(), check.Matches, `(?ms).*<form method="POST" action="/device">.*value="BCDF-GHJK".*`)
+	c.Check(resp.HTML.String(), check.Matches, `(?ms).*name="api_token" type="hidden" value="`+arvadostest.ActiveTokenV2+`".*`)
+}
+
+func (s *DeviceAuthorizationSuite) TestApproveInvalidCode(c *check.C) {
+	ctx := auth.NewContext(context.Background(), &auth.Credentials{Tokens: []string{arvadostest.ActiveTokenV2}})
+	resp, err := s.da.Approve(ctx, arvados.DeviceAuthorizationVerifyOptions{UserCode: "BCDF-GHJK"})
+	c.Assert(err, check.IsNil)
+	c.Check(resp.HTML.String(), check.Matches, `(?ms).*invalid or has expired.*`)
+}
+
+func (s *DeviceAuthorizationSuite) TestApproveTwice(c *check.C) {
+	resp, err := s.da.Create(context.Background())
+	c.Assert(err, check.IsNil)
+	ctx := auth.NewContext(context.Background(), &auth.Credentials{Tokens: []string{arvadostest.ActiveTokenV2}})
+	// Another approval is in progress (or finished): this one
+	// must fail without creating a token. (RailsProxy is unset,
+	// so creating a token would panic.)
+	s.da.RailsProxy = nil
+	for _, approved := range []bool{false, true} {
+		s.da.byDevice[resp.DeviceCode].approving = !approved
+		s.da.byDevice[resp.DeviceCode].approved = approved
+		page, err := s.da.Approve(ctx, arvados.DeviceAuthorizationVerifyOptions{UserCode: resp.UserCode})
+		c.Assert(err, check.IsNil)
+		c.Check(page.HTML.String(), check.Matches, `(?ms).*has already been approved.*`)
+	}
+}
+
+func (s *DeviceAuthorizationSuite) TestApproveSuccess(c *check.C) {
+	resp, err := s.da.Create(context.Background())
+	c.Assert(err, check.IsNil)
+	ctx := auth.NewContext(context.Background(), &auth.Credentials{Tokens: []string{arvadostest.ActiveTokenV2}})
+	page, err := s.da.Approve(ctx, arvados.DeviceAuthorizationVerifyOptions{UserCode: resp.UserCode})
+	c.Assert(err, check.IsNil)
+	c.Check(page.HTML.String(), check.Matches, `(?ms).*Device authorized.*`)
+
+	aca, err := s.da.Token(context.Background(), arvados.DeviceAuthorizationTokenOptions{DeviceCode: resp.DeviceCode})
+	c.Assert(err, check.IsNil)
+	c.Check(aca.UUID, check.Matches, `zzzzz-gj3su-.*`)
+	c.Check(aca.APIToken, check.Not(check.Equals), "")
+	c.Check(aca.Scopes, check.DeepEquals, []string{"all"})
+	c.Check(aca.ExpiresAt, check.Not(check.Equals), "")
+
+	// Token is only handed out once
+	_, err = s.da.Token(context.Background(), arvados.DeviceAuthorizationTokenOptions{DeviceCode: resp.DeviceCode})
+	c.Check(err, check.ErrorMatches, `expired_token`)
+}
@@ -91,7 +91,7 @@ func (ctrl *googleLoginController) Login(ctx context.Context, opts arvados.Login
 			return loginError(err)
 		}
 		conf.RedirectURL = callback.String()
-		state := ctrl.newOAuth2State([]byte(ctrl.Cluster.SystemRootToken), opts.Remote, opts.ReturnTo)
+		state := newOAuth2State([]byte(ctrl.Cluster.SystemRootToken), opts.Remote, opts.ReturnTo)
 		return arvados.LoginResponse{
 			RedirectLocation: conf.AuthCodeURL(state.String(),
 				// prompt=select_account tells Google
@@ -103,7 +103,7 @@ func (ctrl *googleLoginController) Login(ctx context.Context, opts arvados.Login
 		}, nil
 	} else {
 		// Callback after Google sign-in.
-		state := ctrl.parseOAuth2State(opts.State)
+		state := parseOAuth2State(opts.State)
 		if !state.verify([]byte(ctrl.Cluster.SystemRootToken)) {
 			return loginError(errors.New("invalid OAuth2 state"))
 		}
@@ -237,7 +237,7 @@ func loginError(sendError error) (resp arvados.LoginResponse, err error) {
 	return
 }
 
-func (ctrl *googleLoginController) newOAuth2State(key []byte, remote, returnTo string) oauth2State {
+func newOAuth2State(key []byte, remote, returnTo string) oauth2State {
 	s := oauth2State{
 		Time:     time.Now().Unix(),
 		Remote:   remote,
@@ -254,7 +254,7 @@ type oauth2State struct {
 	ReturnTo string // redirect target
 }
 
-func (ctrl *googleLoginController) parseOAuth2State(encoded string) (s oauth2State) {
+func parseOAuth2State(encoded string) (s oauth2State) {
 	// Errors are not checked. If decoding/parsing fails, the
 	// token will be rejected by verify().
 	decoded, _ := base64.RawURLEncoding.DecodeString(encoded)
@@ -0,0 +1,226 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package localdb
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+
+	"git.arvados.org/arvados.git/lib/controller/rpc"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"git.arvados.org/arvados.git/sdk/go/httpserver"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+type ldapLoginController struct {
+	Cluster    *arvados.Cluster
+	RailsProxy *railsProxy
+}
+
+func (ctrl *ldapLoginController) Logout(ctx context.Context, opts arvados.LogoutOptions) (arvados.LogoutResponse, error) {
+	return noopLogout(ctrl.Cluster, opts)
+}
+
+// Login presents a username/password form that submits the
+// credentials to the UserAuthenticate endpoint, then redirects to
+// opts.ReturnTo with the resulting token.
+func (ctrl *ldapLoginController) Login(ctx context.Context, opts arvados.LoginOptions) (arvados.LoginResponse, error) {
+	if opts.ReturnTo == "" {
+		return loginError(errors.New("missing return_to parameter"))
+	}
+	if opts.Remote != "" {
+		return loginError(errors.New("interactive login with a remote cluster ID is not available"))
+	}
+	var resp arvados.LoginResponse
+	err := ldapLoginForm.Execute(&resp.HTML, map[string]string{
+		"ReturnTo":     opts.ReturnTo,
+		"Authenticate": "/" + arvados.EndpointUserAuthenticate.Path,
+	})
+	return resp, err
+}
+
+var ldapLoginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><title>Log in</title></head>
+<body>
+<h2>Log in</h2>
+<form id="login">
+<p><label>Username <input name="username" type="text" autofocus></label></p>
+<p><label>Password <input name="password" type="password"></label></p>
+<p><input type="submit" value="Log in"></p>
+<p id="error"></p>
+</form>
+<script>
+document.getElementById("login").addEventListener("submit", function(ev) {
+  ev.preventDefault();
+  var form = ev.target;
+  var req = new XMLHttpRequest();
+  req.open("POST", {{.Authenticate}});
+  req.setRequestHeader("Content-Type", "application/x-www-form-urlencoded");
+  req.onload = function() {
+    var resp = JSON.parse(req.responseText);
+    if (req.status != 200) {
+      document.getElementById("error").textContent = (resp.errors || ["login failed"]).join("; ");
+      return;
+    }
+    var target = new URL({{.ReturnTo}});
+    target.searchParams.set("api_token", "v2/" + resp.uuid + "/" + resp.api_token);
+    window.location = target.toString();
+  };
+  req.send("username=" + encodeURIComponent(form.username.value) +
+           "&password=" + encodeURIComponent(form.password.value));
+});
+</script>
+</body></html>
+`))
+
+func (ctrl *ldapLoginController) UserAuthenticate(ctx context.Context, opts arvados.UserAuthenticateOptions) (arvados.APIClientAuthorization, error) {
+	log := ctxlog.FromContext(ctx)
+	conf := ctrl.Cluster.Login.LDAP
+	errFailed := httpserver.ErrorWithStatus(fmt.Errorf("LDAP: Authentication failure (with username %q and password)", opts.Username), http.StatusUnauthorized)
+
+	if conf.SearchAttribute == "" {
+		return arvados.APIClientAuthorization{}, errors.New("config error: SearchAttribute is blank")
+	}
+	if opts.Password == "" {
+		log.WithField("username", opts.Username).Error("refusing to authenticate with empty password")
+		return arvados.APIClientAuthorization{}, errFailed
+	}
+
+	log = log.WithField("URL", conf.URL.String())
+	var l *ldap.Conn
+	var err error
+	if conf.URL.Scheme == "ldaps" {
+		// ldap.DialURL does not currently allow us to control
+		// tls.Config, so we need to figure out the port
+		// ourselves and call DialTLS.
+		host, port, splitErr := net.SplitHostPort(conf.URL.Host)
+		if splitErr != nil {
+			// Assume error means no port given
+			host = conf.URL.Host
+			port = ldap.DefaultLdapsPort
+		}
+		l, err = ldap.DialTLS("tcp", net.JoinHostPort(host, port), &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: conf.InsecureTLS,
+		})
+	} else {
+		l, err = ldap.DialURL(conf.URL.String())
+	}
+	if err != nil {
+		log.WithError(err).Error("ldap connection failed")
+		return arvados.APIClientAuthorization{}, err
+	}
+	defer l.Close()
+
+	if conf.StartTLS && conf.URL.Scheme != "ldaps" {
+		var tlsconfig tls.Config
+		if conf.InsecureTLS {
+			tlsconfig.InsecureSkipVerify = true
+		} else {
+			if host, _, err := net.SplitHostPort(conf.URL.Host); err != nil {
+				// Assume SplitHostPort error means
+				// port was not specified
+				tlsconfig.ServerName = conf.URL.Host
+			} else {
+				tlsconfig.ServerName = host
+			}
+		}
+		err = l.StartTLS(&tlsconfig)
+		if err != nil {
+			log.WithError(err).Error("ldap starttls failed")
+			return arvados.APIClientAuthorization{}, err
+		}
+	}
+
+	username := opts.Username
+	if at := strings.Index(username, "@"); at >= 0 {
+		if conf.StripDomain == "*" || strings.ToLower(conf.StripDomain) == strings.ToLower(username[at+1:]) {
+			username = username[:at]
+		}
+	}
+	if conf.AppendDomain != "" && !strings.Contains(username, "@") {
+		username = username + "@" + conf.AppendDomain
+	}
+
+	if conf.SearchBindUser != "" {
+		err = l.Bind(conf.SearchBindUser, conf.SearchBindPassword)
+		if err != nil {
+			log.WithError(err).WithField("user", conf.SearchBindUser).Error("ldap authentication failed")
+			return arvados.APIClientAuthorization{}, err
+		}
+	}
+
+	search := fmt.Sprintf("(%s=%s)", ldap.EscapeFilter(conf.SearchAttribute), ldap.EscapeFilter(username))
+	if conf.SearchFilters != "" {
+		search = fmt.Sprintf("(&%s%s)", conf.SearchFilters, search)
+	}
+	log = log.WithField("search", search)
+	req := ldap.NewSearchRequest(
+		conf.SearchBase,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		search,
+		[]string{conf.EmailAttribute, conf.UsernameAttribute},
+		nil)
+	resp, err := l.Search(req)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoResultsReturned) ||
+		ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) ||
+		(err == nil && len(resp.Entries) == 0) {
+		log.WithError(err).Info("ldap lookup returned no results")
+		return arvados.APIClientAuthorization{}, errFailed
+	} else if err != nil {
+		log.WithError(err).Error("ldap lookup failed")
+		return arvados.APIClientAuthorization{}, err
+	}
+	if len(resp.Entries) > 1 {
+		log.WithField("entries", len(resp.Entries)).Error("ldap lookup returned more than one entry")
+		return arvados.APIClientAuthorization{}, httpserver.ErrorWithStatus(fmt.Errorf("LDAP: lookup for username %q returned more than one entry", opts.Username), http.StatusUnauthorized)
+	}
+	userdn := resp.Entries[0].DN
+	if userdn == "" {
+		log.Warn("refusing to authenticate with empty dn")
+		return arvados.APIClientAuthorization{}, errFailed
+	}
+	log = log.WithField("DN", userdn)
+
+	attrs := map[string]string{}
+	for _, attr := range resp.Entries[0].Attributes {
+		if attr == nil || len(attr.Values) == 0 {
+			continue
+		}
+		attrs[strings.ToLower(attr.Name)] = attr.Values[0]
+	}
+	log.WithField("attrs", attrs).Debug("ldap search succeeded")
+
+	// Now that we have the DN, try authenticating.
+	err = l.Bind(userdn, opts.Password)
+	if err != nil {
+		log.WithError(err).Info("ldap user authentication failed")
+		return arvados.APIClientAuthorization{}, errFailed
+	}
+	log.Debug("ldap authentication succeeded")
+
+	email := attrs[strings.ToLower(conf.EmailAttribute)]
+	if email == "" {
+		log.Errorf("ldap returned no email address in %q attribute", conf.EmailAttribute)
+		return arvados.APIClientAuthorization{}, errors.New("authentication succeeded but ldap returned no email address")
+	}
+	authinfo := rpc.UserSessionAuthInfo{
+		Email:    email,
+		Username: opts.Username,
+	}
+	if uname := attrs[strings.ToLower(conf.UsernameAttribute)]; uname != "" {
+		authinfo.Username = uname
+	}
+	log.WithFields(logrus.Fields{"user": authinfo.Username, "email": authinfo.Email}).Debug("ldap login succeeded")
+	return createAPIClientAuthorization(ctx, ctrl.RailsProxy, ctrl.Cluster.SystemRootToken, authinfo)
+}
@@ -0,0 +1,291 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package localdb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"git.arvados.org/arvados.git/lib/config"
+	"git.arvados.org/arvados.git/lib/controller/rpc"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&LDAPSuite{})
+
+type LDAPSuite struct {
+	cluster  *arvados.Cluster
+	ctrl     *ldapLoginController
+	ldap     *ldapStandIn
+	railsSpy *arvadostest.Proxy
+}
+
+func (s *LDAPSuite) TearDownSuite(c *check.C) {
+	// Undo any changes/additions to the user database so they
+	// don't affect subsequent tests.
+	arvadostest.ResetEnv()
+	c.Check(arvados.NewClientFromEnv().RequestAndDecode(nil, "POST", "database/reset", nil, nil), check.IsNil)
+}
+
+func (s *LDAPSuite) SetUpTest(c *check.C) {
+	var err error
+	s.ldap, err = newLDAPStandIn()
+	c.Assert(err, check.IsNil)
+	s.ldap.entries["cn=Search,dc=example,dc=com"] = ldapStandInEntry{
+		password: "searchpassword",
+	}
+	s.ldap.entries["uid=goodusername,ou=users,dc=example,dc=com"] = ldapStandInEntry{
+		password: "goodpassword",
+		attrs: map[string]string{
+			"uid":         "goodusername",
+			"mail":        "goodusername@example.com",
+			"objectClass": "person",
+		},
+	}
+	s.ldap.entries["uid=nomail,ou=users,dc=example,dc=com"] = ldapStandInEntry{
+		password: "nomailpassword",
+		attrs: map[string]string{
+			"uid":         "nomail",
+			"objectClass": "person",
+		},
+	}
+
+	cfg, err := config.NewLoader(nil, ctxlog.TestLogger(c)).Load()
+	c.Assert(err, check.IsNil)
+	s.cluster, err = cfg.GetCluster("")
+	c.Assert(err, check.IsNil)
+	s.cluster.Login.LDAP.Enable = true
+	s.cluster.Login.LDAP.URL = arvados.URL{Scheme: "ldap", Host: s.ldap.Addr()}
+	s.cluster.Login.LDAP.StartTLS = false
+	s.cluster.Login.LDAP.SearchBindUser = "cn=Search,dc=example,dc=com"
+	s.cluster.Login.LDAP.SearchBindPassword = "searchpassword"
+	s.cluster.Login.LDAP.SearchBase = "ou=users,dc=example,dc=com"
+	s.cluster.Login.LDAP.SearchFilters = "(objectClass=person)"
+	s.cluster.Login.LDAP.StripDomain = "example.com"
+	s.railsSpy = arvadostest.NewProxy(c, s.cluster.Services.RailsAPI)
+	s.ctrl = &ldapLoginController{
+		Cluster:    s.cluster,
+		RailsProxy: rpc.NewConn(s.cluster.ClusterID, s.railsSpy.URL, true, rpc.PassthroughTokenProvider),
+	}
+}
+
+func (s *LDAPSuite) TearDownTest(c *check.C) {
+	s.railsSpy.Close()
+	s.ldap.Close()
+}
+
+func (s *LDAPSuite) TestChooseLoginController(c *check.C) {
+	_, ok := chooseLoginController(s.cluster, nil).(*ldapLoginController)
+	c.Check(ok, check.Equals, true)
+}
+
+func (s *LDAPSuite) TestLoginForm(c *check.C) {
+	resp, err := s.ctrl.Login(context.Background(), arvados.LoginOptions{ReturnTo: "https://app.example.com/foo?bar"})
+	c.Check(err, check.IsNil)
+	c.Check(resp.RedirectLocation, check.Equals, "")
+	c.Check(resp.HTML.String(), check.Matches, `(?ms).*<form.*name="password".*`)
+	c.Check(resp.HTML.String(), check.Matches, `(?ms).*arvados/v1/users/authenticate.*`)
+	c.Check(resp.HTML.String(), check.Matches, `(?ms).*"https://app.example.com/foo\?bar".*`)
+
+	resp, err = s.ctrl.Login(context.Background(), arvados.LoginOptions{})
+	c.Check(err, check.IsNil)
+	c.Check(resp.HTML.String(), check.Matches, `.*missing return_to parameter.*`)
+}
+
+func (s *LDAPSuite) TestLoginSuccess(c *check.C) {
+	for _, username := range []string{"goodusername", "goodusername@example.com"} {
+		resp, err := s.ctrl.UserAuthenticate(context.Background(), arvados.UserAuthenticateOptions{
+			Username: username,
+			Password: "goodpassword",
+		})
+		c.Check(err, check.IsNil)
+		c.Check(resp.APIToken, check.Not(check.Equals), "")
+		c.Check(resp.UUID, check.Matches, `zzzzz-gj3su-.*`)
+		c.Check(resp.Scopes, check.DeepEquals, []string{"all"})
+
+		authinfo := getCallbackAuthInfo(c, s.railsSpy)
+		c.Check(authinfo.Email, check.Equals, "goodusername@example.com")
+		c.Check(authinfo.Username, check.Equals, "goodusername")
+	}
+}
+
+func (s *LDAPSuite) TestLoginFailure(c *check.C) {
+	for _, trial := range []struct {
+		username string
+		password string
+	}{
+		{"goodusername", "badpassword"},
+		{"goodusername", ""},
+		{"bogususername", "goodpassword"},
+		{"goodusername@bogus.example.com", "goodpassword"},
+	} {
+		c.Logf("trial: %+v", trial)
+		resp, err := s.ctrl.UserAuthenticate(context.Background(), arvados.UserAuthenticateOptions{
+			Username: trial.username,
+			Password: trial.password,
+		})
+		c.Check(err, check.ErrorMatches, fmt.Sprintf(`LDAP: Authentication failure \(with username %q and password\)`, trial.username))
+		hs, ok := err.(interface{ HTTPStatus() int })
+		if c.Check(ok, check.Equals, true) {
+			c.Check(hs.HTTPStatus(), check.Equals, http.StatusUnauthorized)
+		}
+		c.Check(resp.APIToken, check.Equals, "")
+	}
+}
+
+func (s *LDAPSuite) TestLoginNoEmail(c *check.C) {
+	resp, err := s.ctrl.UserAuthenticate(context.Background(), arvados.UserAuthenticateOptions{
+		Username: "nomail",
+		Password: "nomailpassword",
+	})
+	c.Check(err, check.ErrorMatches, `.*ldap returned no email address.*`)
+	c.Check(resp.APIToken, check.Equals, "")
+}
+
+func (s *LDAPSuite) TestSearchBindFailure(c *check.C) {
+	s.cluster.Login.LDAP.SearchBindPassword = "wrongpassword"
+	_, err := s.ctrl.UserAuthenticate(context.Background(), arvados.UserAuthenticateOptions{
+		Username: "goodusername",
+		Password: "goodpassword",
+	})
+	c.Check(err, check.NotNil)
+	c.Check(ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials), check.Equals, true)
+}
+
+type ldapStandInEntry struct {
+	password string
+	attrs    map[string]string
+}
+
+// ldapStandIn is a minimal in-process LDAP server that supports
+// simple bind and equality searches, which is enough to exercise
+// ldapLoginController.
+type ldapStandIn struct {
+	ln      net.Listener
+	entries map[string]ldapStandInEntry
+	wg      sync.WaitGroup
+}
+
+func newLDAPStandIn() (*ldapStandIn, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	srv := &ldapStandIn{ln: ln, entries: map[string]ldapStandInEntry{}}
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			srv.wg.Add(1)
+			go func() {
+				defer srv.wg.Done()
+				defer conn.Close()
+				srv.serve(conn)
+			}()
+		}
+	}()
+	return srv, nil
+}
+
+func (srv *ldapStandIn) Addr() string {
+	return srv.ln.Addr().String()
+}
+
+func (srv *ldapStandIn) Close() {
+	srv.ln.Close()
+	srv.wg.Wait()
+}
+
+var ldapStandInFilterRegexp = regexp.MustCompile(`\(([^()=&|!]+)=([^()]*)\)`)
+
+func (srv *ldapStandIn) serve(conn net.Conn) {
+	for {
+		req, err := ber.ReadPacket(conn)
+		if err != nil || len(req.Children) < 2 {
+			return
+		}
+		msgid := req.Children[0].Value.(int64)
+		op := req.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := ldap.LDAPResultInvalidCredentials
+			if ent, ok := srv.entries[dn]; ok && ent.password == password {
+				code = ldap.LDAPResultSuccess
+			}
+			srv.respond(conn, msgid, ldap.ApplicationBindResponse, code)
+		case ldap.ApplicationSearchRequest:
+			base := op.Children[0].Value.(string)
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				srv.respond(conn, msgid, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError)
+				continue
+			}
+			for dn, ent := range srv.entries {
+				if !strings.HasSuffix(dn, ","+base) || !ent.matches(filter) {
+					continue
+				}
+				resp := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+				resp.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgid, "MessageID"))
+				entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+				entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+				attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+				for k, v := range ent.attrs {
+					attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+					attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, k, "Name"))
+					vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+					vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+					attr.AppendChild(vals)
+					attrs.AppendChild(attr)
+				}
+				entry.AppendChild(attrs)
+				resp.AppendChild(entry)
+				conn.Write(resp.Bytes())
+			}
+			srv.respond(conn, msgid, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			srv.respond(conn, msgid, ldap.ApplicationExtendedResponse, ldap.LDAPResultUnwillingToPerform)
+		}
+	}
+}
+
+// matches returns true if all of the (attr=value) terms in filter
+// match the entry's attributes.
+func (ent ldapStandInEntry) matches(filter string) bool {
+	for _, m := range ldapStandInFilterRegexp.FindAllStringSubmatch(filter, -1) {
+		if ent.attrs[m[1]] != m[2] {
+			return false
+		}
+	}
+	return true
+}
+
+func (srv *ldapStandIn) respond(conn net.Conn, msgid int64, tag ber.Tag, code int) {
+	resp := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	resp.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgid, "MessageID"))
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, ldap.LDAPResultCodeMap[uint16(code)], "diagnosticMessage"))
+	resp.AppendChild(result)
+	conn.Write(resp.Bytes())
+}
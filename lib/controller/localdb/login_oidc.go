@@ -0,0 +1,159 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package localdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"git.arvados.org/arvados.git/lib/controller/rpc"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/auth"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"git.arvados.org/arvados.git/sdk/go/httpserver"
+	"github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+)
+
+// oidcLoginController authenticates users with a generic OpenID
+// Connect provider (e.g., Keycloak), using the issuer, client
+// credentials, and claim names in cluster.Login.OpenIDConnect.
+type oidcLoginController struct {
+	Cluster    *arvados.Cluster
+	RailsProxy *railsProxy
+
+	provider *oidc.Provider
+	mu       sync.Mutex
+}
+
+func (ctrl *oidcLoginController) getProvider() (*oidc.Provider, error) {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+	if ctrl.provider == nil {
+		issuer := ctrl.Cluster.Login.OpenIDConnect.Issuer
+		if issuer == "" {
+			return nil, errors.New("configuration error: Login.OpenIDConnect.Issuer is empty")
+		}
+		provider, err := oidc.NewProvider(context.Background(), issuer)
+		if err != nil {
+			return nil, err
+		}
+		ctrl.provider = provider
+	}
+	return ctrl.provider, nil
+}
+
+func (ctrl *oidcLoginController) Logout(ctx context.Context, opts arvados.LogoutOptions) (arvados.LogoutResponse, error) {
+	return noopLogout(ctrl.Cluster, opts)
+}
+
+func (ctrl *oidcLoginController) Login(ctx context.Context, opts arvados.LoginOptions) (arvados.LoginResponse, error) {
+	provider, err := ctrl.getProvider()
+	if err != nil {
+		return loginError(fmt.Errorf("error setting up OpenID Connect provider: %s", err))
+	}
+	callback, err := (*url.URL)(&ctrl.Cluster.Services.Controller.ExternalURL).Parse("/" + arvados.EndpointLogin.Path)
+	if err != nil {
+		return loginError(fmt.Errorf("error making redirect URL: %s", err))
+	}
+	conf := &oauth2.Config{
+		ClientID:     ctrl.Cluster.Login.OpenIDConnect.ClientID,
+		ClientSecret: ctrl.Cluster.Login.OpenIDConnect.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID, "profile", "email"}, ctrl.Cluster.Login.OpenIDConnect.Scopes...),
+		RedirectURL:  callback.String(),
+	}
+	if opts.State == "" {
+		// Initiate OpenID Connect sign-in.
+		if opts.ReturnTo == "" {
+			return loginError(errors.New("missing return_to parameter"))
+		}
+		state := newOAuth2State([]byte(ctrl.Cluster.SystemRootToken), opts.Remote, opts.ReturnTo)
+		return arvados.LoginResponse{
+			RedirectLocation: conf.AuthCodeURL(state.String()),
+		}, nil
+	}
+	// Callback after OpenID Connect sign-in.
+	state := parseOAuth2State(opts.State)
+	if !state.verify([]byte(ctrl.Cluster.SystemRootToken)) {
+		return loginError(errors.New("invalid OAuth2 state"))
+	}
+	oauth2Token, err := conf.Exchange(ctx, opts.Code)
+	if err != nil {
+		return loginError(fmt.Errorf("error in OAuth2 exchange: %s", err))
+	}
+	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
+	if !ok {
+		return loginError(errors.New("error in OAuth2 exchange: no ID token in OAuth2 token"))
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: conf.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return loginError(fmt.Errorf("error verifying ID token: %s", err))
+	}
+	authinfo, err := ctrl.getAuthInfo(ctx, idToken)
+	if err != nil {
+		return loginError(err)
+	}
+	ctxRoot := auth.NewContext(ctx, &auth.Credentials{Tokens: []string{ctrl.Cluster.SystemRootToken}})
+	return ctrl.RailsProxy.UserSessionCreate(ctxRoot, rpc.UserSessionCreateOptions{
+		ReturnTo: state.Remote + "," + state.ReturnTo,
+		AuthInfo: *authinfo,
+	})
+}
+
+func (ctrl *oidcLoginController) UserAuthenticate(ctx context.Context, opts arvados.UserAuthenticateOptions) (arvados.APIClientAuthorization, error) {
+	return arvados.APIClientAuthorization{}, httpserver.ErrorWithStatus(errors.New("username/password authentication is not available"), http.StatusBadRequest)
+}
+
+// Extract the user's email address, name, and (if configured)
+// username from the ID token claims, using the claim names given in
+// the cluster config.
+func (ctrl *oidcLoginController) getAuthInfo(ctx context.Context, idToken *oidc.IDToken) (*rpc.UserSessionAuthInfo, error) {
+	var ret rpc.UserSessionAuthInfo
+	defer ctxlog.FromContext(ctx).WithField("ret", &ret).Debug("getAuthInfo returned")
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("error extracting claims from ID token: %s", err)
+	}
+	cfg := ctrl.Cluster.Login.OpenIDConnect
+	emailClaim := cfg.EmailClaim
+	if emailClaim == "" {
+		emailClaim = "email"
+	}
+	nameClaim := cfg.NameClaim
+	if nameClaim == "" {
+		nameClaim = "name"
+	}
+
+	email, _ := claims[emailClaim].(string)
+	if email == "" {
+		return nil, fmt.Errorf("cannot log in without an email address (ID token has no %q claim)", emailClaim)
+	}
+	if claim := cfg.EmailVerifiedClaim; claim != "" {
+		if verified, _ := claims[claim].(bool); !verified {
+			return nil, fmt.Errorf("cannot log in with unverified email address %q", email)
+		}
+	}
+	ret.Email = email
+
+	if name, _ := claims[nameClaim].(string); name != "" {
+		if names := strings.Fields(strings.TrimSpace(name)); len(names) > 1 {
+			ret.FirstName = strings.Join(names[0:len(names)-1], " ")
+			ret.LastName = names[len(names)-1]
+		} else if len(names) == 1 {
+			ret.FirstName = names[0]
+		}
+	}
+	if claim := cfg.UsernameClaim; claim != "" {
+		ret.Username, _ = claims[claim].(string)
+	}
+	return &ret, nil
+}
@@ -0,0 +1,278 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package localdb
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/config"
+	"git.arvados.org/arvados.git/lib/controller/rpc"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	check "gopkg.in/check.v1"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+var _ = check.Suite(&OIDCLoginSuite{})
+
+type OIDCLoginSuite struct {
+	cluster    *arvados.Cluster
+	localdb    *Conn
+	railsSpy   *arvadostest.Proxy
+	fakeIssuer *httptest.Server
+	issuerKey  *rsa.PrivateKey
+
+	// expected token request
+	validCode string
+	// claims to include in the ID token returned by the token
+	// endpoint
+	claims map[string]interface{}
+}
+
+func (s *OIDCLoginSuite) TearDownSuite(c *check.C) {
+	// Undo any changes/additions to the user database so they
+	// don't affect subsequent tests.
+	arvadostest.ResetEnv()
+	c.Check(arvados.NewClientFromEnv().RequestAndDecode(nil, "POST", "database/reset", nil, nil), check.IsNil)
+}
+
+func (s *OIDCLoginSuite) SetUpTest(c *check.C) {
+	var err error
+	s.issuerKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, check.IsNil)
+
+	s.claims = map[string]interface{}{
+		"upn":           "joe.smith@example.com",
+		"mail_verified": true,
+		"display_name":  "Joe P. Smith",
+		"preferred":     "jsmith",
+	}
+	s.fakeIssuer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		c.Logf("fakeIssuer: got req: %s %s %s", req.Method, req.URL, req.Form)
+		w.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"issuer":                 s.fakeIssuer.URL,
+				"authorization_endpoint": s.fakeIssuer.URL + "/auth",
+				"token_endpoint":         s.fakeIssuer.URL + "/token",
+				"jwks_uri":               s.fakeIssuer.URL + "/jwks",
+				"userinfo_endpoint":      s.fakeIssuer.URL + "/userinfo",
+			})
+		case "/token":
+			if req.Form.Get("code") != s.validCode || s.validCode == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			claims := map[string]interface{}{
+				"iss":   s.fakeIssuer.URL,
+				"aud":   []string{"test%client$id"},
+				"sub":   "fake-user-id",
+				"exp":   time.Now().UTC().Add(time.Minute).UnixNano(),
+				"iat":   time.Now().UTC().UnixNano(),
+				"nonce": "fake-nonce",
+			}
+			for k, v := range s.claims {
+				claims[k] = v
+			}
+			idToken, _ := json.Marshal(claims)
+			json.NewEncoder(w).Encode(struct {
+				AccessToken  string `json:"access_token"`
+				TokenType    string `json:"token_type"`
+				RefreshToken string `json:"refresh_token"`
+				ExpiresIn    int32  `json:"expires_in"`
+				IDToken      string `json:"id_token"`
+			}{
+				AccessToken:  s.fakeToken(c, []byte("fake access token")),
+				TokenType:    "Bearer",
+				RefreshToken: "test-refresh-token",
+				ExpiresIn:    30,
+				IDToken:      s.fakeToken(c, idToken),
+			})
+		case "/jwks":
+			json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+				Keys: []jose.JSONWebKey{
+					{Key: s.issuerKey.Public(), Algorithm: string(jose.RS256), KeyID: ""},
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	s.validCode = fmt.Sprintf("abcdefgh-%d", time.Now().Unix())
+
+	cfg, err := config.NewLoader(nil, ctxlog.TestLogger(c)).Load()
+	c.Assert(err, check.IsNil)
+	s.cluster, err = cfg.GetCluster("")
+	c.Assert(err, check.IsNil)
+	s.cluster.Login.ProviderAppID = ""
+	s.cluster.Login.ProviderAppSecret = ""
+	s.cluster.Login.OpenIDConnect.Enable = true
+	s.cluster.Login.OpenIDConnect.Issuer = s.fakeIssuer.URL
+	s.cluster.Login.OpenIDConnect.ClientID = "test%client$id"
+	s.cluster.Login.OpenIDConnect.ClientSecret = "test#client/secret"
+	s.cluster.Login.OpenIDConnect.EmailClaim = "upn"
+	s.cluster.Login.OpenIDConnect.EmailVerifiedClaim = "mail_verified"
+	s.cluster.Login.OpenIDConnect.NameClaim = "display_name"
+	s.cluster.Login.OpenIDConnect.UsernameClaim = "preferred"
+	s.cluster.Login.OpenIDConnect.Scopes = []string{"groups"}
+
+	s.localdb = NewConn(s.cluster)
+	_, ok := s.localdb.loginController.(*oidcLoginController)
+	c.Assert(ok, check.Equals, true)
+
+	s.railsSpy = arvadostest.NewProxy(c, s.cluster.Services.RailsAPI)
+	*s.localdb.railsProxy = *rpc.NewConn(s.cluster.ClusterID, s.railsSpy.URL, true, rpc.PassthroughTokenProvider)
+}
+
+func (s *OIDCLoginSuite) TearDownTest(c *check.C) {
+	s.railsSpy.Close()
+	s.fakeIssuer.Close()
+}
+
+func (s *OIDCLoginSuite) TestLogout(c *check.C) {
+	resp, err := s.localdb.Logout(context.Background(), arvados.LogoutOptions{ReturnTo: "https://foo.example.com/bar"})
+	c.Check(err, check.IsNil)
+	c.Check(resp.RedirectLocation, check.Equals, "https://foo.example.com/bar")
+}
+
+func (s *OIDCLoginSuite) TestLogin_Start(c *check.C) {
+	for _, remote := range []string{"", "zzzzz"} {
+		resp, err := s.localdb.Login(context.Background(), arvados.LoginOptions{Remote: remote, ReturnTo: "https://app.example.com/foo?bar"})
+		c.Check(err, check.IsNil)
+		target, err := url.Parse(resp.RedirectLocation)
+		c.Check(err, check.IsNil)
+		issuerURL, _ := url.Parse(s.fakeIssuer.URL)
+		c.Check(target.Host, check.Equals, issuerURL.Host)
+		c.Check(target.Path, check.Equals, "/auth")
+		q := target.Query()
+		c.Check(q.Get("client_id"), check.Equals, "test%client$id")
+		c.Check(strings.Fields(q.Get("scope")), check.DeepEquals, []string{"openid", "profile", "email", "groups"})
+		state := parseOAuth2State(q.Get("state"))
+		c.Check(state.verify([]byte(s.cluster.SystemRootToken)), check.Equals, true)
+		c.Check(state.Remote, check.Equals, remote)
+		c.Check(state.ReturnTo, check.Equals, "https://app.example.com/foo?bar")
+	}
+}
+
+func (s *OIDCLoginSuite) TestLogin_InvalidState(c *check.C) {
+	s.startLogin(c)
+	resp, err := s.localdb.Login(context.Background(), arvados.LoginOptions{
+		Code:  s.validCode,
+		State: "bogus-state",
+	})
+	c.Check(err, check.IsNil)
+	c.Check(resp.RedirectLocation, check.Equals, "")
+	c.Check(resp.HTML.String(), check.Matches, `(?ms).*invalid OAuth2 state.*`)
+}
+
+func (s *OIDCLoginSuite) TestLogin_InvalidCode(c *check.C) {
+	state := s.startLogin(c)
+	resp, err := s.localdb.Login(context.Background(), arvados.LoginOptions{
+		Code:  "first-try-a-bogus-code",
+		State: state,
+	})
+	c.Check(err, check.IsNil)
+	c.Check(resp.RedirectLocation, check.Equals, "")
+	c.Check(resp.HTML.String(), check.Matches, `(?ms).*error in OAuth2 exchange.*cannot fetch token.*`)
+}
+
+func (s *OIDCLoginSuite) TestLogin_UnverifiedEmail(c *check.C) {
+	s.claims["mail_verified"] = false
+	state := s.startLogin(c)
+	resp, err := s.localdb.Login(context.Background(), arvados.LoginOptions{
+		Code:  s.validCode,
+		State: state,
+	})
+	c.Check(err, check.IsNil)
+	c.Check(resp.RedirectLocation, check.Equals, "")
+	c.Check(resp.HTML.String(), check.Matches, `(?ms).*cannot log in with unverified email address "joe.smith@example.com".*`)
+}
+
+func (s *OIDCLoginSuite) TestLogin_NoEmail(c *check.C) {
+	delete(s.claims, "upn")
+	state := s.startLogin(c)
+	resp, err := s.localdb.Login(context.Background(), arvados.LoginOptions{
+		Code:  s.validCode,
+		State: state,
+	})
+	c.Check(err, check.IsNil)
+	c.Check(resp.RedirectLocation, check.Equals, "")
+	c.Check(resp.HTML.String(), check.Matches, `(?ms).*cannot log in without an email address.*"upn".*`)
+}
+
+func (s *OIDCLoginSuite) TestLogin_Success(c *check.C) {
+	state := s.startLogin(c)
+	resp, err := s.localdb.Login(context.Background(), arvados.LoginOptions{
+		Code:  s.validCode,
+		State: state,
+	})
+	c.Check(err, check.IsNil)
+	c.Check(resp.HTML.String(), check.Equals, "")
+	target, err := url.Parse(resp.RedirectLocation)
+	c.Check(err, check.IsNil)
+	c.Check(target.Host, check.Equals, "app.example.com")
+	c.Check(target.Path, check.Equals, "/foo")
+	c.Check(target.Query().Get("api_token"), check.Matches, `v2/zzzzz-gj3su-.{15}/.{32,50}`)
+
+	authinfo := getCallbackAuthInfo(c, s.railsSpy)
+	c.Check(authinfo.FirstName, check.Equals, "Joe P.")
+	c.Check(authinfo.LastName, check.Equals, "Smith")
+	c.Check(authinfo.Email, check.Equals, "joe.smith@example.com")
+	c.Check(authinfo.Username, check.Equals, "jsmith")
+	c.Check(authinfo.AlternateEmails, check.HasLen, 0)
+}
+
+func (s *OIDCLoginSuite) TestLogin_NoVerifiedClaim(c *check.C) {
+	s.cluster.Login.OpenIDConnect.EmailVerifiedClaim = ""
+	delete(s.claims, "mail_verified")
+	state := s.startLogin(c)
+	_, err := s.localdb.Login(context.Background(), arvados.LoginOptions{
+		Code:  s.validCode,
+		State: state,
+	})
+	c.Check(err, check.IsNil)
+	authinfo := getCallbackAuthInfo(c, s.railsSpy)
+	c.Check(authinfo.Email, check.Equals, "joe.smith@example.com")
+}
+
+func (s *OIDCLoginSuite) startLogin(c *check.C) (state string) {
+	// Initiate login, but instead of following the redirect to
+	// the provider, just grab state from the redirect URL.
+	resp, err := s.localdb.Login(context.Background(), arvados.LoginOptions{ReturnTo: "https://app.example.com/foo?bar"})
+	c.Check(err, check.IsNil)
+	target, err := url.Parse(resp.RedirectLocation)
+	c.Check(err, check.IsNil)
+	state = target.Query().Get("state")
+	c.Check(state, check.Not(check.Equals), "")
+	return
+}
+
+func (s *OIDCLoginSuite) fakeToken(c *check.C, payload []byte) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: s.issuerKey}, nil)
+	if err != nil {
+		c.Error(err)
+	}
+	object, err := signer.Sign(payload)
+	if err != nil {
+		c.Error(err)
+	}
+	t, err := object.CompactSerialize()
+	if err != nil {
+		c.Error(err)
+	}
+	return t
+}
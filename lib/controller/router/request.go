@@ -75,7 +75,7 @@ func (rtr *router) loadRequestParams(req *http.Request, attrsKey string) (map[st
 		for _, v := range values {
 			params[k], err = guessAndParse(k, v)
 			if err != nil {
-				return nil, err
+				return nil, httpError(http.StatusBadRequest, err)
 			}
 		}
 	}
@@ -160,6 +160,7 @@ func (rtr *router) transcode(src interface{}, dst interface{}) error {
 var intParams = map[string]bool{
 	"limit":  true,
 	"offset": true,
+	"after":  true,
 }
 
 var boolParams = map[string]bool{
@@ -170,6 +171,10 @@ var boolParams = map[string]bool{
 	"redirect_to_new_user":    true,
 	"send_notification_email": true,
 	"bypass_federation":       true,
+	"recursive":               true,
+	"federated":               true,
+	"exclude_home_project":    true,
+	"follow":                  true,
 }
 
 func stringToBool(s string) bool {
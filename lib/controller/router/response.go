@@ -31,6 +31,9 @@ func (rtr *router) responseOptions(opts interface{}) (responseOptions, error) {
 	case *arvados.ListOptions:
 		rOpts.Select = opts.Select
 		rOpts.Count = opts.Count
+	case *arvados.GroupContentsOptions:
+		rOpts.Select = opts.Select
+		rOpts.Count = opts.Count
 	}
 	return rOpts, nil
 }
@@ -145,7 +148,10 @@ func (rtr *router) sendError(w http.ResponseWriter, err error) {
 
 var infixMap = map[string]interface{}{
 	"4zz18": arvados.Collection{},
+	"dz642": arvados.Container{},
 	"j7d0g": arvados.Group{},
+	"o0j2j": arvados.Link{},
+	"xvhdp": arvados.ContainerRequest{},
 }
 
 var mungeKind = regexp.MustCompile(`\..`)
@@ -120,6 +120,74 @@ func (s *RouterSuite) TestOptions(c *check.C) {
 			shouldCall:  "CollectionList",
 			withOptions: arvados.ListOptions{Limit: 123, Offset: 456, IncludeTrash: true, IncludeOldVersions: true},
 		},
+		{
+			method:      "GET",
+			path:        "/arvados/v1/groups/contents?recursive=true&include=owner_uuid",
+			shouldCall:  "GroupContents",
+			withOptions: arvados.GroupContentsOptions{Limit: -1, Recursive: true, Include: "owner_uuid"},
+		},
+		{
+			method:      "GET",
+			path:        "/arvados/v1/groups/" + arvadostest.AProjectUUID + "/contents?limit=10",
+			shouldCall:  "GroupContents",
+			withOptions: arvados.GroupContentsOptions{UUID: arvadostest.AProjectUUID, Limit: 10},
+		},
+		{
+			method:      "GET",
+			path:        "/arvados/v1/groups/" + arvadostest.AProjectUUID,
+			shouldCall:  "GroupGet",
+			withOptions: arvados.GetOptions{UUID: arvadostest.AProjectUUID},
+		},
+		{
+			method:      "GET",
+			path:        "/arvados/v1/groups/shared?include=owner_uuid&limit=10",
+			shouldCall:  "GroupShared",
+			withOptions: arvados.ListOptions{Limit: 10, Include: "owner_uuid"},
+		},
+		{
+			method:      "POST",
+			path:        "/arvados/v1/groups/" + arvadostest.AProjectUUID + "/trash",
+			shouldCall:  "GroupTrash",
+			withOptions: arvados.DeleteOptions{UUID: arvadostest.AProjectUUID},
+		},
+		{
+			method:      "POST",
+			path:        "/arvados/v1/groups/" + arvadostest.AProjectUUID + "/untrash?ensure_unique_name=true",
+			shouldCall:  "GroupUntrash",
+			withOptions: arvados.UntrashOptions{UUID: arvadostest.AProjectUUID, EnsureUniqueName: true},
+		},
+		{
+			method:      "GET",
+			path:        "/arvados/v1/groups",
+			shouldCall:  "GroupList",
+			withOptions: arvados.ListOptions{Limit: -1},
+		},
+		{
+			method:      "DELETE",
+			path:        "/arvados/v1/links/" + arvadostest.ActiveUserCanReadAllUsersLinkUUID,
+			shouldCall:  "LinkDelete",
+			withOptions: arvados.DeleteOptions{UUID: arvadostest.ActiveUserCanReadAllUsersLinkUUID},
+		},
+		{
+			method:      "POST",
+			path:        "/arvados/v1/links",
+			body:        `{"link":{"link_class":"tag"}}`,
+			header:      http.Header{"Content-Type": {"application/json"}},
+			shouldCall:  "LinkCreate",
+			withOptions: arvados.CreateOptions{Attrs: map[string]interface{}{"link_class": "tag"}},
+		},
+		{
+			method:      "PATCH",
+			path:        "/arvados/v1/container_requests/" + arvadostest.QueuedContainerRequestUUID,
+			shouldCall:  "ContainerRequestUpdate",
+			withOptions: arvados.UpdateOptions{UUID: arvadostest.QueuedContainerRequestUUID},
+		},
+		{
+			method:      "GET",
+			path:        "/arvados/v1/container_requests",
+			shouldCall:  "ContainerRequestList",
+			withOptions: arvados.ListOptions{Limit: -1},
+		},
 		{
 			method:       "PATCH",
 			path:         "/arvados/v1/collections",
@@ -158,6 +226,48 @@ func (s *RouterSuite) TestOptions(c *check.C) {
 	}
 }
 
+// The container log endpoint responds with a stream rather than a
+// JSON object, so it isn't tested with doRequest.
+func (s *RouterSuite) TestContainerLog(c *check.C) {
+	logPath := "/arvados/v1/containers/" + arvadostest.RunningContainerUUID + "/log"
+	for _, trial := range []struct {
+		path         string
+		shouldStatus int // zero value means 200
+		withOptions  interface{}
+	}{
+		{
+			path:        logPath,
+			withOptions: arvados.ContainerLogOptions{UUID: arvadostest.RunningContainerUUID},
+		},
+		{
+			path:        logPath + "?after=12&follow=true",
+			withOptions: arvados.ContainerLogOptions{UUID: arvadostest.RunningContainerUUID, After: 12, Follow: true},
+		},
+		{
+			path:         logPath + "?after=x",
+			shouldStatus: http.StatusBadRequest,
+		},
+	} {
+		s.stub = arvadostest.APIStub{}
+		c.Logf("trial: %#v", trial)
+		req := httptest.NewRequest("GET", trial.path, nil)
+		req.Header.Set("Authorization", "Bearer "+arvadostest.ActiveToken)
+		rr := httptest.NewRecorder()
+		s.rtr.ServeHTTP(rr, req)
+		calls := s.stub.Calls(nil)
+		if trial.shouldStatus != 0 {
+			c.Check(rr.Code, check.Equals, trial.shouldStatus)
+			c.Check(calls, check.HasLen, 0)
+			continue
+		}
+		c.Check(rr.Code, check.Equals, http.StatusOK)
+		if c.Check(calls, check.HasLen, 1) {
+			c.Check(calls[0].Method, isMethodNamed, "ContainerLog")
+			c.Check(calls[0].Options, check.DeepEquals, trial.withOptions)
+		}
+	}
+}
+
 var _ = check.Suite(&RouterIntegrationSuite{})
 
 type RouterIntegrationSuite struct {
@@ -0,0 +1,197 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+)
+
+// How long checkpoint collections are kept (if not updated) before
+// being trashed.
+var checkpointTTL = 14 * 24 * time.Hour
+
+// setupCheckpointMount prepares the host directory for the
+// container's "checkpoint" mount at bind. If the container record
+// has a checkpoint from a previous attempt, its content is restored
+// into the directory.
+func (runner *ContainerRunner) setupCheckpointMount(bind string) error {
+	if runner.checkpointPath != "" {
+		return fmt.Errorf("cannot use more than one checkpoint mount (%q, %q)", runner.checkpointPath, bind)
+	}
+	if bind == runner.Container.OutputPath || strings.HasPrefix(bind, runner.Container.OutputPath+"/") {
+		return fmt.Errorf("checkpoint mount %q cannot be in the output directory", bind)
+	}
+	tmpdir, err := runner.MkTempDir(runner.parentTemp, "checkpoint")
+	if err != nil {
+		return fmt.Errorf("While creating checkpoint temp dir: %v", err)
+	}
+	err = os.Chmod(tmpdir, 0777|os.ModeSetgid)
+	if err != nil {
+		return fmt.Errorf("While Chmod checkpoint temp dir: %v", err)
+	}
+	if pdh := runner.Container.Checkpoint; pdh != "" {
+		runner.CrunchLog.Printf("Restoring checkpoint %s to %s", pdh, bind)
+		var coll arvados.Collection
+		err = runner.ContainerArvClient.Get("collections", pdh, nil, &coll)
+		if err != nil {
+			return fmt.Errorf("error fetching checkpoint collection %s: %v", pdh, err)
+		}
+		fs, err := coll.FileSystem(runner.containerClient, runner.ContainerKeepClient)
+		if err != nil {
+			return fmt.Errorf("error reading checkpoint collection %s: %v", pdh, err)
+		}
+		err = restoreDir(fs, "/", tmpdir)
+		if err != nil {
+			return fmt.Errorf("error restoring checkpoint: %v", err)
+		}
+		runner.checkpointManifest = coll.ManifestText
+	}
+	runner.checkpointPath = bind
+	runner.checkpointHostDir = tmpdir
+	runner.Binds = append(runner.Binds, fmt.Sprintf("%s:%s", tmpdir, bind))
+	return nil
+}
+
+// restoreDir copies the content of directory src in fs to the host
+// directory dst, which must already exist.
+func restoreDir(fs arvados.CollectionFileSystem, src, dst string) error {
+	f, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	fis, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		srcpath := filepath.Join(src, fi.Name())
+		dstpath := filepath.Join(dst, fi.Name())
+		if fi.IsDir() {
+			err = os.Mkdir(dstpath, 0777)
+			if err != nil {
+				return err
+			}
+			err = restoreDir(fs, srcpath, dstpath)
+		} else {
+			err = restoreFile(fs, srcpath, dstpath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restoreFile(fs arvados.CollectionFileSystem, src, dst string) error {
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// startCheckpoints starts a goroutine that saves a checkpoint every
+// runner.checkpointInterval. The returned func stops the goroutine
+// and waits for it to finish.
+func (runner *ContainerRunner) startCheckpoints() (stop func()) {
+	if runner.checkpointHostDir == "" {
+		return func() {}
+	}
+	ticker := time.NewTicker(runner.checkpointInterval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			err := runner.saveCheckpoint()
+			if err != nil {
+				runner.CrunchLog.Printf("error saving checkpoint: %v", err)
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+	}
+}
+
+// saveCheckpoint saves the current content of the checkpoint
+// directory to a collection (owned by the container's user, so a
+// retry of this container can read it) and records the collection
+// on the container record. Nothing is saved if the content hasn't
+// changed since the last checkpoint.
+func (runner *ContainerRunner) saveCheckpoint() error {
+	txt, err := (&copier{
+		client:        runner.containerClient,
+		arvClient:     runner.ContainerArvClient,
+		keepClient:    runner.ContainerKeepClient,
+		hostOutputDir: runner.checkpointHostDir,
+		ctrOutputDir:  runner.checkpointPath,
+		binds:         []string{runner.checkpointHostDir + ":" + runner.checkpointPath},
+		mounts:        map[string]arvados.Mount{runner.checkpointPath: {Kind: "tmp"}},
+		logger:        log.New(ioutil.Discard, "", 0),
+	}).Copy()
+	if err != nil {
+		return err
+	}
+	if txt == runner.checkpointManifest {
+		return nil
+	}
+	exp := time.Now().Add(checkpointTTL)
+	updates := arvadosclient.Dict{
+		"name":          "checkpoint for " + runner.Container.UUID,
+		"manifest_text": txt,
+		"trash_at":      exp,
+		"delete_at":     exp,
+	}
+	reqBody := arvadosclient.Dict{"collection": updates}
+	var coll arvados.Collection
+	if runner.checkpointUUID == "" {
+		reqBody["ensure_unique_name"] = true
+		err = runner.ContainerArvClient.Create("collections", reqBody, &coll)
+	} else {
+		err = runner.ContainerArvClient.Update("collections", runner.checkpointUUID, reqBody, &coll)
+	}
+	if err != nil {
+		return fmt.Errorf("error saving checkpoint collection: %v", err)
+	}
+	runner.checkpointUUID = coll.UUID
+	err = runner.DispatcherArvClient.Update("containers", runner.Container.UUID, arvadosclient.Dict{
+		"container": arvadosclient.Dict{"checkpoint": coll.PortableDataHash},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error updating container checkpoint to %s: %v", coll.PortableDataHash, err)
+	}
+	runner.checkpointManifest = txt
+	runner.CrunchLog.Printf("Saved checkpoint %s", coll.PortableDataHash)
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	. "gopkg.in/check.v1"
+)
+
+// blockKeepClient is a KeepTestClient that can read back the blocks
+// that were written to it.
+type blockKeepClient struct {
+	*KeepTestClient
+	blocks map[string][]byte
+}
+
+func (kc *blockKeepClient) PutB(buf []byte) (string, int, error) {
+	hash := fmt.Sprintf("%x", md5.Sum(buf))
+	kc.blocks[hash] = append([]byte(nil), buf...)
+	return fmt.Sprintf("%s+%d", hash, len(buf)), len(buf), nil
+}
+
+func (kc *blockKeepClient) ReadAt(locator string, p []byte, off int) (int, error) {
+	buf, ok := kc.blocks[locator[:32]]
+	if !ok {
+		return 0, fmt.Errorf("block not found: %s", locator)
+	}
+	return copy(p, buf[off:]), nil
+}
+
+// hostDir returns the host directory bind-mounted at the given
+// container path.
+func hostDir(binds []string, ctrPath string) string {
+	for _, bind := range binds {
+		if strings.HasSuffix(bind, ":"+ctrPath) {
+			return bind[:len(bind)-len(ctrPath)-1]
+		}
+	}
+	return ""
+}
+
+func (s *TestSuite) TestCheckpointSave(c *C) {
+	ckptManifest := ". 7eedced2241bcccda700ccac1b5915d6+6 0:6:state\n"
+	ckptPDH := fmt.Sprintf("%x+%d", md5.Sum([]byte(ckptManifest)), len(ckptManifest))
+	e := newStubExecutor(0, func(e *stubExecutor) {
+		dir := hostDir(e.spec.Binds, "/ckpt")
+		c.Assert(dir, Not(Equals), "")
+		err := ioutil.WriteFile(dir+"/state", []byte("step 1"), 0666)
+		c.Assert(err, IsNil)
+		api := s.runner.DispatcherArvClient.(*ArvTestClient)
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			api.Lock()
+			saved := api.CalledWith("container.checkpoint", ckptPDH)
+			api.Unlock()
+			if saved != nil {
+				return
+			}
+		}
+		c.Error("timed out waiting for checkpoint")
+	})
+	_, cr := s.stubRunHelper(c, `{
+    "command": ["true"],
+    "container_image": "a45557269dcb65a6b78f9ac061c0850b+120",
+    "cwd": ".",
+    "environment": {},
+    "mounts": {"/tmp": {"kind": "tmp"}, "/ckpt": {"kind": "checkpoint"}},
+    "output_path": "/tmp",
+    "priority": 1,
+    "runtime_constraints": {},
+    "state": "Locked"
+}`, e, func(cr *ContainerRunner) {
+		cr.checkpointInterval = 20 * time.Millisecond
+	})
+
+	c.Check(e.spec.Binds, HasLen, 2)
+	c.Check(cr.ContainerArvClient.(*ArvTestClient).CalledWith("collection.name", "checkpoint for "+cr.Container.UUID), NotNil)
+	c.Check(cr.ContainerArvClient.(*ArvTestClient).CalledWith("collection.manifest_text", ckptManifest), NotNil)
+	c.Check(cr.checkpointUUID, Not(Equals), "")
+}
+
+func (s *TestSuite) TestCheckpointRestore(c *C) {
+	kc := &blockKeepClient{
+		KeepTestClient: &KeepTestClient{},
+		blocks:         map[string][]byte{"7eedced2241bcccda700ccac1b5915d6": []byte("step 1")},
+	}
+	ckptManifest := "./dir 7eedced2241bcccda700ccac1b5915d6+6 0:6:state\n"
+	ckptPDH := fmt.Sprintf("%x+%d", md5.Sum([]byte(ckptManifest)), len(ckptManifest))
+
+	var restored string
+	e := newStubExecutor(0, func(e *stubExecutor) {
+		buf, err := ioutil.ReadFile(hostDir(e.spec.Binds, "/ckpt") + "/dir/state")
+		c.Check(err, IsNil)
+		restored = string(buf)
+	})
+	api, _ := s.stubRunHelper(c, `{
+    "command": ["true"],
+    "container_image": "a45557269dcb65a6b78f9ac061c0850b+120",
+    "cwd": ".",
+    "environment": {},
+    "mounts": {"/tmp": {"kind": "tmp"}, "/ckpt": {"kind": "checkpoint"}},
+    "output_path": "/tmp",
+    "priority": 1,
+    "runtime_constraints": {},
+    "checkpoint": "`+ckptPDH+`",
+    "state": "Locked"
+}`, e, func(cr *ContainerRunner) {
+		cr.MkArvClient = func(token string) (IArvadosClient, IKeepClient, *arvados.Client, error) {
+			return &ArvTestClient{manifests: map[string]string{ckptPDH: ckptManifest}}, kc, nil, nil
+		}
+	})
+
+	c.Check(restored, Equals, "step 1")
+	c.Check(api.Logs["crunch-run"].String(), Matches, `(?ms).*Restoring checkpoint `+regexp.QuoteMeta(ckptPDH)+` to /ckpt.*`)
+}
+
+func (s *TestSuite) TestCheckpointMountErrors(c *C) {
+	for _, trial := range []struct {
+		mounts map[string]arvados.Mount
+		err    string
+	}{
+		{
+			mounts: map[string]arvados.Mount{"/tmp": {Kind: "checkpoint"}},
+			err:    `checkpoint mount "/tmp" cannot be in the output directory`,
+		},
+		{
+			mounts: map[string]arvados.Mount{"/tmp": {Kind: "tmp"}, "/ckpt1": {Kind: "checkpoint"}, "/ckpt2": {Kind: "checkpoint"}},
+			err:    `cannot use more than one checkpoint mount \("/ckpt1", "/ckpt2"\)`,
+		},
+	} {
+		cr, err := NewContainerRunner(s.client, &ArvTestClient{}, &KeepTestClient{}, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+		c.Assert(err, IsNil)
+		realTemp := c.MkDir()
+		cr.parentTemp = realTemp
+		cr.MkTempDir = func(_ string, prefix string) (string, error) {
+			return ioutil.TempDir(realTemp, prefix)
+		}
+		cr.RunArvMount = (&ArvMountCmdLine{}).ArvMountTest
+		cr.Container.Mounts = trial.mounts
+		cr.Container.OutputPath = "/tmp"
+		err = cr.SetupMounts()
+		c.Check(err, ErrorMatches, trial.err)
+	}
+}
@@ -34,11 +34,6 @@ import (
 	"git.arvados.org/arvados.git/sdk/go/keepclient"
 	"git.arvados.org/arvados.git/sdk/go/manifest"
 	"golang.org/x/net/context"
-
-	dockertypes "github.com/docker/docker/api/types"
-	dockercontainer "github.com/docker/docker/api/types/container"
-	dockernetwork "github.com/docker/docker/api/types/network"
-	dockerclient "github.com/docker/docker/client"
 )
 
 type command struct{}
@@ -74,20 +69,6 @@ type RunArvMount func(args []string, tok string) (*exec.Cmd, error)
 
 type MkTempDir func(string, string) (string, error)
 
-// ThinDockerClient is the minimal Docker client interface used by crunch-run.
-type ThinDockerClient interface {
-	ContainerAttach(ctx context.Context, container string, options dockertypes.ContainerAttachOptions) (dockertypes.HijackedResponse, error)
-	ContainerCreate(ctx context.Context, config *dockercontainer.Config, hostConfig *dockercontainer.HostConfig,
-		networkingConfig *dockernetwork.NetworkingConfig, containerName string) (dockercontainer.ContainerCreateCreatedBody, error)
-	ContainerStart(ctx context.Context, container string, options dockertypes.ContainerStartOptions) error
-	ContainerRemove(ctx context.Context, container string, options dockertypes.ContainerRemoveOptions) error
-	ContainerWait(ctx context.Context, container string, condition dockercontainer.WaitCondition) (<-chan dockercontainer.ContainerWaitOKBody, <-chan error)
-	ContainerInspect(ctx context.Context, id string) (dockertypes.ContainerJSON, error)
-	ImageInspectWithRaw(ctx context.Context, image string) (dockertypes.ImageInspect, []byte, error)
-	ImageLoad(ctx context.Context, input io.Reader, quiet bool) (dockertypes.ImageLoadResponse, error)
-	ImageRemove(ctx context.Context, image string, options dockertypes.ImageRemoveOptions) ([]dockertypes.ImageDeleteResponseItem, error)
-}
-
 type PsProcess interface {
 	CmdlineSlice() ([]string, error)
 }
@@ -95,7 +76,7 @@ type PsProcess interface {
 // ContainerRunner is the main stateful struct used for a single execution of a
 // container.
 type ContainerRunner struct {
-	Docker ThinDockerClient
+	executor containerExecutor
 
 	// Dispatcher client is initialized with the Dispatcher token.
 	// This is a privileged token used to manage container status
@@ -119,35 +100,33 @@ type ContainerRunner struct {
 	ContainerArvClient  IArvadosClient
 	ContainerKeepClient IKeepClient
 
-	Container       arvados.Container
-	ContainerConfig dockercontainer.Config
-	HostConfig      dockercontainer.HostConfig
-	token           string
-	ContainerID     string
-	ExitCode        *int
-	NewLogWriter    NewLogWriter
-	loggingDone     chan bool
-	CrunchLog       *ThrottledLogger
-	Stdout          io.WriteCloser
-	Stderr          io.WriteCloser
-	logUUID         string
-	logMtx          sync.Mutex
-	LogCollection   arvados.CollectionFileSystem
-	LogsPDH         *string
-	RunArvMount     RunArvMount
-	MkTempDir       MkTempDir
-	ArvMount        *exec.Cmd
-	ArvMountPoint   string
-	HostOutputDir   string
-	Binds           []string
-	Volumes         map[string]struct{}
-	OutputPDH       *string
-	SigChan         chan os.Signal
-	ArvMountExit    chan error
-	SecretMounts    map[string]arvados.Mount
-	MkArvClient     func(token string) (IArvadosClient, IKeepClient, *arvados.Client, error)
-	finalState      string
-	parentTemp      string
+	Container     arvados.Container
+	token         string
+	imageID       string
+	ExitCode      *int
+	NewLogWriter  NewLogWriter
+	CrunchLog     *ThrottledLogger
+	Stdout        io.WriteCloser
+	Stderr        io.WriteCloser
+	executorStdin io.Closer
+	logUUID       string
+	logMtx        sync.Mutex
+	LogCollection arvados.CollectionFileSystem
+	LogsPDH       *string
+	RunArvMount   RunArvMount
+	MountKeepFS   MountKeepFS // if non-nil, used instead of RunArvMount
+	MkTempDir     MkTempDir
+	ArvMount      *exec.Cmd
+	ArvMountPoint string
+	HostOutputDir string
+	Binds         []string
+	OutputPDH     *string
+	SigChan       chan os.Signal
+	ArvMountExit  chan error
+	SecretMounts  map[string]arvados.Mount
+	MkArvClient   func(token string) (IArvadosClient, IKeepClient, *arvados.Client, error)
+	finalState    string
+	parentTemp    string
 
 	statLogger       io.WriteCloser
 	statReporter     *crunchstat.Reporter
@@ -157,7 +136,7 @@ type ContainerRunner struct {
 	cgroupRoot       string
 	// What we expect the container's cgroup parent to be.
 	expectCgroupParent string
-	// What we tell docker to use as the container's cgroup
+	// What we tell the executor to use as the container's cgroup
 	// parent. Note: Ideally we would use the same field for both
 	// expectCgroupParent and setCgroupParent, and just make it
 	// default to "docker". However, when using docker < 1.10 with
@@ -170,18 +149,27 @@ type ContainerRunner struct {
 	setCgroupParent string
 
 	cStateLock sync.Mutex
+	cCreated   bool // CreateContainer() succeeded
 	cCancelled bool // StopContainer() invoked
-	cRemoved   bool // docker confirmed the container no longer exists
 
 	enableNetwork string // one of "default" or "always"
-	networkMode   string // passed through to HostConfig.NetworkMode
+	networkMode   string // passed through to containerSpec.NetworkMode
 	arvMountLog   *ThrottledLogger
 
-	containerWatchdogInterval time.Duration
+	keepMount KeepMount // in-process Keep mount, if MountKeepFS is used
+	outputTmp string    // name of the keepMount tmp dir used as output dir
+
+	checkpointInterval time.Duration
+	checkpointPath     string // container path of "checkpoint" mount, if any
+	checkpointHostDir  string
+	checkpointUUID     string // collection where checkpoints are saved
+	checkpointManifest string // content of last checkpoint
+
+	structuredLogs bool // write log lines as JSON records
 }
 
 // setupSignals sets up signal handling to gracefully terminate the underlying
-// Docker container and update state when receiving a TERM, INT or QUIT signal.
+// container and update state when receiving a TERM, INT or QUIT signal.
 func (runner *ContainerRunner) setupSignals() {
 	runner.SigChan = make(chan os.Signal, 1)
 	signal.Notify(runner.SigChan, syscall.SIGTERM)
@@ -195,24 +183,21 @@ func (runner *ContainerRunner) setupSignals() {
 	}(runner.SigChan)
 }
 
-// stop the underlying Docker container.
+// stop the underlying container.
 func (runner *ContainerRunner) stop(sig os.Signal) {
 	runner.cStateLock.Lock()
 	defer runner.cStateLock.Unlock()
 	if sig != nil {
 		runner.CrunchLog.Printf("caught signal: %v", sig)
 	}
-	if runner.ContainerID == "" {
+	if !runner.cCreated {
 		return
 	}
 	runner.cCancelled = true
-	runner.CrunchLog.Printf("removing container")
-	err := runner.Docker.ContainerRemove(context.TODO(), runner.ContainerID, dockertypes.ContainerRemoveOptions{Force: true})
+	runner.CrunchLog.Printf("stopping container")
+	err := runner.executor.Stop()
 	if err != nil {
-		runner.CrunchLog.Printf("error removing container: %s", err)
-	}
-	if err == nil || strings.Contains(err.Error(), "No such container: "+runner.ContainerID) {
-		runner.cRemoved = true
+		runner.CrunchLog.Printf("error stopping container: %s", err)
 	}
 }
 
@@ -258,7 +243,7 @@ func (runner *ContainerRunner) checkBrokenNode(goterr error) bool {
 }
 
 // LoadImage determines the docker image id from the container record and
-// checks if it is available in the local Docker image store.  If not, it loads
+// checks if it is available to the container executor.  If not, it loads
 // the image from Keep.
 func (runner *ContainerRunner) LoadImage() (err error) {
 
@@ -281,8 +266,7 @@ func (runner *ContainerRunner) LoadImage() (err error) {
 
 	runner.CrunchLog.Printf("Using Docker image id '%s'", imageID)
 
-	_, _, err = runner.Docker.ImageInspectWithRaw(context.TODO(), imageID)
-	if err != nil {
+	if !runner.executor.ImageLoaded(imageID, runner.Container.ContainerImage) {
 		runner.CrunchLog.Print("Loading Docker image from keep")
 
 		var readCloser io.ReadCloser
@@ -291,22 +275,15 @@ func (runner *ContainerRunner) LoadImage() (err error) {
 			return fmt.Errorf("While creating ManifestFileReader for container image: %v", err)
 		}
 
-		response, err := runner.Docker.ImageLoad(context.TODO(), readCloser, true)
+		err = runner.executor.LoadImage(imageID, runner.Container.ContainerImage, readCloser)
 		if err != nil {
-			return fmt.Errorf("While loading container image into Docker: %v", err)
-		}
-
-		defer response.Body.Close()
-		rbody, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			return fmt.Errorf("Reading response to image load: %v", err)
+			return err
 		}
-		runner.CrunchLog.Printf("Docker response: %s", rbody)
 	} else {
 		runner.CrunchLog.Print("Docker image is available")
 	}
 
-	runner.ContainerConfig.Image = imageID
+	runner.imageID = imageID
 
 	runner.ContainerKeepClient.ClearBlockCache()
 
@@ -429,6 +406,7 @@ func (runner *ContainerRunner) SetupMounts() (err error) {
 
 	pdhOnly := true
 	tmpcount := 0
+	var tmpNames []string
 	arvMountCmd := []string{
 		"--foreground",
 		"--allow-other",
@@ -441,7 +419,6 @@ func (runner *ContainerRunner) SetupMounts() (err error) {
 
 	collectionPaths := []string{}
 	runner.Binds = nil
-	runner.Volumes = make(map[string]struct{})
 	needCertMount := true
 	type copyFile struct {
 		src  string
@@ -536,10 +513,15 @@ func (runner *ContainerRunner) SetupMounts() (err error) {
 					src += "/" + mnt.Path
 				}
 			} else {
-				src = fmt.Sprintf("%s/tmp%d", runner.ArvMountPoint, tmpcount)
+				tmpName := fmt.Sprintf("tmp%d", tmpcount)
+				src = fmt.Sprintf("%s/%s", runner.ArvMountPoint, tmpName)
 				arvMountCmd = append(arvMountCmd, "--mount-tmp")
-				arvMountCmd = append(arvMountCmd, fmt.Sprintf("tmp%d", tmpcount))
+				arvMountCmd = append(arvMountCmd, tmpName)
+				tmpNames = append(tmpNames, tmpName)
 				tmpcount += 1
+				if bind == runner.Container.OutputPath {
+					runner.outputTmp = tmpName
+				}
 			}
 			if mnt.Writable {
 				if bind == runner.Container.OutputPath {
@@ -604,6 +586,12 @@ func (runner *ContainerRunner) SetupMounts() (err error) {
 				runner.Binds = append(runner.Binds, fmt.Sprintf("%s:%s:ro", tmpfn, bind))
 			}
 
+		case mnt.Kind == "checkpoint":
+			err = runner.setupCheckpointMount(bind)
+			if err != nil {
+				return err
+			}
+
 		case mnt.Kind == "git_tree":
 			tmpdir, err := runner.MkTempDir(runner.parentTemp, "git_tree")
 			if err != nil {
@@ -638,9 +626,16 @@ func (runner *ContainerRunner) SetupMounts() (err error) {
 	}
 	arvMountCmd = append(arvMountCmd, runner.ArvMountPoint)
 
-	runner.ArvMount, err = runner.RunArvMount(arvMountCmd, token)
-	if err != nil {
-		return fmt.Errorf("While trying to start arv-mount: %v", err)
+	if runner.MountKeepFS != nil {
+		err = runner.startKeepMount(token, tmpNames)
+		if err != nil {
+			return fmt.Errorf("While trying to mount Keep: %v", err)
+		}
+	} else {
+		runner.ArvMount, err = runner.RunArvMount(arvMountCmd, token)
+		if err != nil {
+			return fmt.Errorf("While trying to start arv-mount: %v", err)
+		}
 	}
 
 	for _, p := range collectionPaths {
@@ -691,54 +686,6 @@ func (runner *ContainerRunner) SetupMounts() (err error) {
 	return nil
 }
 
-func (runner *ContainerRunner) ProcessDockerAttach(containerReader io.Reader) {
-	// Handle docker log protocol
-	// https://docs.docker.com/engine/reference/api/docker_remote_api_v1.15/#attach-to-a-container
-	defer close(runner.loggingDone)
-
-	header := make([]byte, 8)
-	var err error
-	for err == nil {
-		_, err = io.ReadAtLeast(containerReader, header, 8)
-		if err != nil {
-			if err == io.EOF {
-				err = nil
-			}
-			break
-		}
-		readsize := int64(header[7]) | (int64(header[6]) << 8) | (int64(header[5]) << 16) | (int64(header[4]) << 24)
-		if header[0] == 1 {
-			// stdout
-			_, err = io.CopyN(runner.Stdout, containerReader, readsize)
-		} else {
-			// stderr
-			_, err = io.CopyN(runner.Stderr, containerReader, readsize)
-		}
-	}
-
-	if err != nil {
-		runner.CrunchLog.Printf("error reading docker logs: %v", err)
-	}
-
-	err = runner.Stdout.Close()
-	if err != nil {
-		runner.CrunchLog.Printf("error closing stdout logs: %v", err)
-	}
-
-	err = runner.Stderr.Close()
-	if err != nil {
-		runner.CrunchLog.Printf("error closing stderr logs: %v", err)
-	}
-
-	if runner.statReporter != nil {
-		runner.statReporter.Stop()
-		err = runner.statLogger.Close()
-		if err != nil {
-			runner.CrunchLog.Printf("error closing crunchstat logs: %v", err)
-		}
-	}
-}
-
 func (runner *ContainerRunner) stopHoststat() error {
 	if runner.hoststatReporter == nil {
 		return nil
@@ -773,7 +720,7 @@ func (runner *ContainerRunner) startCrunchstat() error {
 	}
 	runner.statLogger = NewThrottledLogger(w)
 	runner.statReporter = &crunchstat.Reporter{
-		CID:          runner.ContainerID,
+		CID:          runner.executor.CgroupID(),
 		Logger:       log.New(runner.statLogger, "", 0),
 		CgroupParent: runner.expectCgroupParent,
 		CgroupRoot:   runner.cgroupRoot,
@@ -937,15 +884,11 @@ func (runner *ContainerRunner) logAPIResponse(label, path string, params map[str
 	return true, nil
 }
 
-// AttachStreams connects the docker container stdin, stdout and stderr logs
-// to the Arvados logger which logs to Keep and the API server logs table.
-func (runner *ContainerRunner) AttachStreams() (err error) {
-
-	runner.CrunchLog.Print("Attaching container streams")
-
-	// If stdin mount is provided, attach it to the docker container
-	var stdinRdr arvados.File
-	var stdinJson []byte
+// setupStdio prepares the container's stdin (from a collection file
+// or JSON content, if a "stdin" mount is provided), stdout and stderr
+// (to log files, or to files in the output directory if "stdout" or
+// "stderr" mounts are provided).
+func (runner *ContainerRunner) setupStdio() (stdin io.Reader, err error) {
 	if stdinMnt, ok := runner.Container.Mounts["stdin"]; ok {
 		if stdinMnt.Kind == "collection" {
 			var stdinColl arvados.Collection
@@ -955,42 +898,36 @@ func (runner *ContainerRunner) AttachStreams() (err error) {
 			}
 			err = runner.ContainerArvClient.Get("collections", collId, nil, &stdinColl)
 			if err != nil {
-				return fmt.Errorf("While getting stdin collection: %v", err)
+				return nil, fmt.Errorf("While getting stdin collection: %v", err)
 			}
 
-			stdinRdr, err = runner.ContainerKeepClient.ManifestFileReader(
+			stdinRdr, err := runner.ContainerKeepClient.ManifestFileReader(
 				manifest.Manifest{Text: stdinColl.ManifestText},
 				stdinMnt.Path)
 			if os.IsNotExist(err) {
-				return fmt.Errorf("stdin collection path not found: %v", stdinMnt.Path)
+				return nil, fmt.Errorf("stdin collection path not found: %v", stdinMnt.Path)
 			} else if err != nil {
-				return fmt.Errorf("While getting stdin collection path %v: %v", stdinMnt.Path, err)
+				return nil, fmt.Errorf("While getting stdin collection path %v: %v", stdinMnt.Path, err)
 			}
+			stdin = stdinReader{Reader: stdinRdr, runner: runner}
+			runner.executorStdin = stdinRdr
 		} else if stdinMnt.Kind == "json" {
-			stdinJson, err = json.Marshal(stdinMnt.Content)
+			stdinJson, err := json.Marshal(stdinMnt.Content)
 			if err != nil {
-				return fmt.Errorf("While encoding stdin json data: %v", err)
+				return nil, fmt.Errorf("While encoding stdin json data: %v", err)
 			}
+			stdin = bytes.NewReader(stdinJson)
 		}
 	}
 
-	stdinUsed := stdinRdr != nil || len(stdinJson) != 0
-	response, err := runner.Docker.ContainerAttach(context.TODO(), runner.ContainerID,
-		dockertypes.ContainerAttachOptions{Stream: true, Stdin: stdinUsed, Stdout: true, Stderr: true})
-	if err != nil {
-		return fmt.Errorf("While attaching container stdout/stderr streams: %v", err)
-	}
-
-	runner.loggingDone = make(chan bool)
-
 	if stdoutMnt, ok := runner.Container.Mounts["stdout"]; ok {
 		stdoutFile, err := runner.getStdoutFile(stdoutMnt.Path)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		runner.Stdout = stdoutFile
 	} else if w, err := runner.NewLogWriter("stdout"); err != nil {
-		return err
+		return nil, err
 	} else {
 		runner.Stdout = NewThrottledLogger(w)
 	}
@@ -998,39 +935,57 @@ func (runner *ContainerRunner) AttachStreams() (err error) {
 	if stderrMnt, ok := runner.Container.Mounts["stderr"]; ok {
 		stderrFile, err := runner.getStdoutFile(stderrMnt.Path)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		runner.Stderr = stderrFile
 	} else if w, err := runner.NewLogWriter("stderr"); err != nil {
-		return err
+		return nil, err
 	} else {
 		runner.Stderr = NewThrottledLogger(w)
 	}
+	return stdin, nil
+}
 
-	if stdinRdr != nil {
-		go func() {
-			_, err := io.Copy(response.Conn, stdinRdr)
-			if err != nil {
-				runner.CrunchLog.Printf("While writing stdin collection to docker container: %v", err)
-				runner.stop(nil)
-			}
-			stdinRdr.Close()
-			response.CloseWrite()
-		}()
-	} else if len(stdinJson) != 0 {
-		go func() {
-			_, err := io.Copy(response.Conn, bytes.NewReader(stdinJson))
-			if err != nil {
-				runner.CrunchLog.Printf("While writing stdin json to docker container: %v", err)
-				runner.stop(nil)
-			}
-			response.CloseWrite()
-		}()
+// stdinReader wraps the container's stdin source, and stops the
+// container if reading from it fails.
+type stdinReader struct {
+	io.Reader
+	runner *ContainerRunner
+}
+
+func (r stdinReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.runner.CrunchLog.Printf("While reading stdin: %v", err)
+		r.runner.stop(nil)
 	}
+	return n, err
+}
 
-	go runner.ProcessDockerAttach(response.Reader)
+// closeStdio closes the container's stdin source and the stdout and
+// stderr logs, after the container has exited.
+func (runner *ContainerRunner) closeStdio() {
+	if runner.executorStdin != nil {
+		runner.executorStdin.Close()
+	}
 
-	return nil
+	err := runner.Stdout.Close()
+	if err != nil {
+		runner.CrunchLog.Printf("error closing stdout logs: %v", err)
+	}
+
+	err = runner.Stderr.Close()
+	if err != nil {
+		runner.CrunchLog.Printf("error closing stderr logs: %v", err)
+	}
+
+	if runner.statReporter != nil {
+		runner.statReporter.Stop()
+		err = runner.statLogger.Close()
+		if err != nil {
+			runner.CrunchLog.Printf("error closing crunchstat logs: %v", err)
+		}
+	}
 }
 
 func (runner *ContainerRunner) getStdoutFile(mntPath string) (*os.File, error) {
@@ -1058,39 +1013,26 @@ func (runner *ContainerRunner) getStdoutFile(mntPath string) (*os.File, error) {
 	return stdoutFile, nil
 }
 
-// CreateContainer creates the docker container.
+// CreateContainer creates the container, with its stdin, stdout and
+// stderr connected to the relevant mounts and logs.
 func (runner *ContainerRunner) CreateContainer() error {
-	runner.CrunchLog.Print("Creating Docker container")
+	runner.CrunchLog.Print("Creating container")
 
-	runner.ContainerConfig.Cmd = runner.Container.Command
+	spec := containerSpec{
+		Image:        runner.imageID,
+		VCPUs:        runner.Container.RuntimeConstraints.VCPUs,
+		RAM:          int64(runner.Container.RuntimeConstraints.RAM),
+		Command:      runner.Container.Command,
+		Binds:        runner.Binds,
+		NetworkMode:  runner.networkMode,
+		CgroupParent: runner.setCgroupParent,
+	}
 	if runner.Container.Cwd != "." {
-		runner.ContainerConfig.WorkingDir = runner.Container.Cwd
+		spec.WorkingDir = runner.Container.Cwd
 	}
 
 	for k, v := range runner.Container.Environment {
-		runner.ContainerConfig.Env = append(runner.ContainerConfig.Env, k+"="+v)
-	}
-
-	runner.ContainerConfig.Volumes = runner.Volumes
-
-	maxRAM := int64(runner.Container.RuntimeConstraints.RAM)
-	minDockerRAM := int64(16)
-	if maxRAM < minDockerRAM*1024*1024 {
-		// Docker daemon won't let you set a limit less than ~10 MiB
-		maxRAM = minDockerRAM * 1024 * 1024
-	}
-	runner.HostConfig = dockercontainer.HostConfig{
-		Binds: runner.Binds,
-		LogConfig: dockercontainer.LogConfig{
-			Type: "none",
-		},
-		Resources: dockercontainer.Resources{
-			CgroupParent: runner.setCgroupParent,
-			NanoCPUs:     int64(runner.Container.RuntimeConstraints.VCPUs) * 1000000000,
-			Memory:       maxRAM, // RAM
-			MemorySwap:   maxRAM, // RAM+swap
-			KernelMemory: maxRAM, // kernel portion
-		},
+		spec.Env = append(spec.Env, k+"="+v)
 	}
 
 	if wantAPI := runner.Container.RuntimeConstraints.API; wantAPI != nil && *wantAPI {
@@ -1098,47 +1040,44 @@ func (runner *ContainerRunner) CreateContainer() error {
 		if err != nil {
 			return err
 		}
-		runner.ContainerConfig.Env = append(runner.ContainerConfig.Env,
+		spec.Env = append(spec.Env,
 			"ARVADOS_API_TOKEN="+tok,
 			"ARVADOS_API_HOST="+os.Getenv("ARVADOS_API_HOST"),
 			"ARVADOS_API_HOST_INSECURE="+os.Getenv("ARVADOS_API_HOST_INSECURE"),
 		)
-		runner.HostConfig.NetworkMode = dockercontainer.NetworkMode(runner.networkMode)
+		spec.EnableNetwork = true
 	} else {
-		if runner.enableNetwork == "always" {
-			runner.HostConfig.NetworkMode = dockercontainer.NetworkMode(runner.networkMode)
-		} else {
-			runner.HostConfig.NetworkMode = dockercontainer.NetworkMode("none")
-		}
+		spec.EnableNetwork = runner.enableNetwork == "always"
 	}
 
-	_, stdinUsed := runner.Container.Mounts["stdin"]
-	runner.ContainerConfig.OpenStdin = stdinUsed
-	runner.ContainerConfig.StdinOnce = stdinUsed
-	runner.ContainerConfig.AttachStdin = stdinUsed
-	runner.ContainerConfig.AttachStdout = true
-	runner.ContainerConfig.AttachStderr = true
-
-	createdBody, err := runner.Docker.ContainerCreate(context.TODO(), &runner.ContainerConfig, &runner.HostConfig, nil, runner.Container.UUID)
+	runner.CrunchLog.Print("Attaching container streams")
+	var err error
+	spec.Stdin, err = runner.setupStdio()
 	if err != nil {
-		return fmt.Errorf("While creating container: %v", err)
+		return err
 	}
+	spec.Stdout = runner.Stdout
+	spec.Stderr = runner.Stderr
 
-	runner.ContainerID = createdBody.ID
-
-	return runner.AttachStreams()
+	err = runner.executor.Create(spec)
+	if err != nil {
+		return err
+	}
+	runner.cStateLock.Lock()
+	runner.cCreated = true
+	runner.cStateLock.Unlock()
+	return nil
 }
 
-// StartContainer starts the docker container created by CreateContainer.
+// StartContainer starts the container created by CreateContainer.
 func (runner *ContainerRunner) StartContainer() error {
-	runner.CrunchLog.Printf("Starting Docker container id '%s'", runner.ContainerID)
+	runner.CrunchLog.Print("Starting container")
 	runner.cStateLock.Lock()
 	defer runner.cStateLock.Unlock()
 	if runner.cCancelled {
 		return ErrCancelled
 	}
-	err := runner.Docker.ContainerStart(context.TODO(), runner.ContainerID,
-		dockertypes.ContainerStartOptions{})
+	err := runner.executor.Start()
 	if err != nil {
 		var advice string
 		if m, e := regexp.MatchString("(?ms).*(exec|System error).*(no such file or directory|file not found).*", err.Error()); m && e == nil {
@@ -1155,52 +1094,32 @@ func (runner *ContainerRunner) WaitFinish() error {
 	var runTimeExceeded <-chan time.Time
 	runner.CrunchLog.Print("Waiting for container to finish")
 
-	waitOk, waitErr := runner.Docker.ContainerWait(context.TODO(), runner.ContainerID, dockercontainer.WaitConditionNotRunning)
+	type waitResult struct {
+		exitCode int
+		err      error
+	}
+	waitDone := make(chan waitResult, 1)
+	go func() {
+		exitCode, err := runner.executor.Wait(context.Background())
+		waitDone <- waitResult{exitCode, err}
+	}()
 	arvMountExit := runner.ArvMountExit
 	if timeout := runner.Container.SchedulingParameters.MaxRunTime; timeout > 0 {
 		runTimeExceeded = time.After(time.Duration(timeout) * time.Second)
 	}
 
-	containerGone := make(chan struct{})
-	go func() {
-		defer close(containerGone)
-		if runner.containerWatchdogInterval < 1 {
-			runner.containerWatchdogInterval = time.Minute
-		}
-		for range time.NewTicker(runner.containerWatchdogInterval).C {
-			ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(runner.containerWatchdogInterval))
-			ctr, err := runner.Docker.ContainerInspect(ctx, runner.ContainerID)
-			cancel()
-			runner.cStateLock.Lock()
-			done := runner.cRemoved || runner.ExitCode != nil
-			runner.cStateLock.Unlock()
-			if done {
-				return
-			} else if err != nil {
-				runner.CrunchLog.Printf("Error inspecting container: %s", err)
-				runner.checkBrokenNode(err)
-				return
-			} else if ctr.State == nil || !(ctr.State.Running || ctr.State.Status == "created") {
-				runner.CrunchLog.Printf("Container is not running: State=%v", ctr.State)
-				return
-			}
-		}
-	}()
-
 	for {
 		select {
-		case waitBody := <-waitOk:
-			runner.CrunchLog.Printf("Container exited with code: %v", waitBody.StatusCode)
-			code := int(waitBody.StatusCode)
-			runner.ExitCode = &code
-
-			// wait for stdout/stderr to complete
-			<-runner.loggingDone
+		case result := <-waitDone:
+			runner.closeStdio()
+			if result.err != nil {
+				runner.checkBrokenNode(result.err)
+				return result.err
+			}
+			runner.CrunchLog.Printf("Container exited with code: %v", result.exitCode)
+			runner.ExitCode = &result.exitCode
 			return nil
 
-		case err := <-waitErr:
-			return fmt.Errorf("container wait: %v", err)
-
 		case <-arvMountExit:
 			runner.CrunchLog.Printf("arv-mount exited while container is still running.  Stopping container.")
 			runner.stop(nil)
@@ -1212,9 +1131,6 @@ func (runner *ContainerRunner) WaitFinish() error {
 			runner.CrunchLog.Printf("maximum run time exceeded. Stopping container.")
 			runner.stop(nil)
 			runTimeExceeded = nil
-
-		case <-containerGone:
-			return errors.New("docker client never returned status")
 		}
 	}
 }
@@ -1285,19 +1201,31 @@ func (runner *ContainerRunner) CaptureOutput() error {
 		}
 	}
 
-	txt, err := (&copier{
-		client:        runner.containerClient,
-		arvClient:     runner.ContainerArvClient,
-		keepClient:    runner.ContainerKeepClient,
-		hostOutputDir: runner.HostOutputDir,
-		ctrOutputDir:  runner.Container.OutputPath,
-		binds:         runner.Binds,
-		mounts:        runner.Container.Mounts,
-		secretMounts:  runner.SecretMounts,
-		logger:        runner.CrunchLog,
-	}).Copy()
-	if err != nil {
-		return err
+	var txt string
+	var err error
+	if fs := runner.outputCollection(); fs != nil {
+		// The output directory is a collection in our own
+		// Keep mount, so we don't need to walk the tree and
+		// re-upload the files.
+		txt, err = fs.MarshalManifest(".")
+		if err != nil {
+			return fmt.Errorf("error saving output collection: %v", err)
+		}
+	} else {
+		txt, err = (&copier{
+			client:        runner.containerClient,
+			arvClient:     runner.ContainerArvClient,
+			keepClient:    runner.ContainerKeepClient,
+			hostOutputDir: runner.HostOutputDir,
+			ctrOutputDir:  runner.Container.OutputPath,
+			binds:         runner.Binds,
+			mounts:        runner.Container.Mounts,
+			secretMounts:  runner.SecretMounts,
+			logger:        runner.CrunchLog,
+		}).Copy()
+		if err != nil {
+			return err
+		}
 	}
 	if n := len(regexp.MustCompile(` [0-9a-f]+\+\S*\+R`).FindAllStringIndex(txt, -1)); n > 0 {
 		runner.CrunchLog.Printf("Copying %d data blocks from remote input collections...", n)
@@ -1327,7 +1255,9 @@ func (runner *ContainerRunner) CaptureOutput() error {
 }
 
 func (runner *ContainerRunner) CleanupDirs() {
-	if runner.ArvMount != nil {
+	if runner.keepMount != nil {
+		runner.stopKeepMount()
+	} else if runner.ArvMount != nil {
 		var delay int64 = 8
 		umount := exec.Command("arv-mount", fmt.Sprintf("--unmount-timeout=%d", delay), "--unmount", runner.ArvMountPoint)
 		umount.Stdout = runner.CrunchLog
@@ -1403,6 +1333,7 @@ func (runner *ContainerRunner) CommitLogs() error {
 			UUID:          runner.Container.UUID,
 			loggingStream: "crunch-run",
 			writeCloser:   nil,
+			structured:    runner.structuredLogs,
 		})
 		runner.CrunchLog.Immediate = log.New(os.Stderr, runner.Container.UUID+" ", 0)
 	}()
@@ -1526,9 +1457,20 @@ func (runner *ContainerRunner) NewArvLogWriter(name string) (io.WriteCloser, err
 		UUID:          runner.Container.UUID,
 		loggingStream: name,
 		writeCloser:   writer,
+		structured:    runner.structuredLogs,
 	}, nil
 }
 
+// setStructuredLogs switches the runner's logs, including the
+// already-open crunch-run log, to structured (JSON) format. It must
+// be called before anything is written to CrunchLog.
+func (runner *ContainerRunner) setStructuredLogs() {
+	runner.structuredLogs = true
+	if w, ok := runner.CrunchLog.writer.(*ArvLogWriter); ok {
+		w.structured = true
+	}
+}
+
 // Run the full container lifecycle.
 func (runner *ContainerRunner) Run() (err error) {
 	runner.CrunchLog.Printf("crunch-run %s started", cmd.Version.String())
@@ -1661,6 +1603,7 @@ func (runner *ContainerRunner) Run() (err error) {
 		runner.checkBrokenNode(err)
 		return
 	}
+	defer runner.startCheckpoints()()
 
 	err = runner.WaitFinish()
 	if err == nil && !runner.IsCancelled() {
@@ -1717,18 +1660,17 @@ func (runner *ContainerRunner) fetchContainerRecord() error {
 func NewContainerRunner(dispatcherClient *arvados.Client,
 	dispatcherArvClient IArvadosClient,
 	dispatcherKeepClient IKeepClient,
-	docker ThinDockerClient,
 	containerUUID string) (*ContainerRunner, error) {
 
 	cr := &ContainerRunner{
 		dispatcherClient:     dispatcherClient,
 		DispatcherArvClient:  dispatcherArvClient,
 		DispatcherKeepClient: dispatcherKeepClient,
-		Docker:               docker,
 	}
 	cr.NewLogWriter = cr.NewArvLogWriter
 	cr.RunArvMount = cr.ArvMountCmd
 	cr.MkTempDir = ioutil.TempDir
+	cr.checkpointInterval = 10 * time.Minute
 	cr.MkArvClient = func(token string) (IArvadosClient, IKeepClient, *arvados.Client, error) {
 		cl, err := arvadosclient.MakeArvadosClient()
 		if err != nil {
@@ -1765,6 +1707,7 @@ func NewContainerRunner(dispatcherClient *arvados.Client,
 func (command) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
 	flags := flag.NewFlagSet(prog, flag.ContinueOnError)
 	statInterval := flags.Duration("crunchstat-interval", 10*time.Second, "sampling period for periodic resource usage reporting")
+	checkpointInterval := flags.Duration("checkpoint-interval", 10*time.Minute, "time between saving snapshots of the container's checkpoint mount, if any")
 	cgroupRoot := flags.String("cgroup-root", "/sys/fs/cgroup", "path to sysfs cgroup tree")
 	cgroupParent := flags.String("cgroup-parent", "docker", "name of container's parent cgroup (ignored if -cgroup-parent-subsystem is used)")
 	cgroupParentSubsystem := flags.String("cgroup-parent-subsystem", "", "use current cgroup for given subsystem as parent cgroup for container")
@@ -1782,6 +1725,9 @@ func (command) RunCommand(prog string, args []string, stdin io.Reader, stdout, s
 	networkMode := flags.String("container-network-mode", "default",
 		`Set networking mode for container.  Corresponds to Docker network mode (--net).
     	`)
+	runtimeEngine := flags.String("runtime-engine", "docker", "container runtime: docker or singularity")
+	keepMount := flags.String("keep-mount", "arv-mount", "how to mount Keep collections: arv-mount or in-process")
+	logFormat := flags.String("log-format", "text", "format of container log files and log entries: text or json")
 	memprofile := flags.String("memprofile", "", "write memory profile to `file` after running container")
 	flags.Duration("check-containerd", 0, "Ignored. Exists for compatibility with older versions.")
 
@@ -1831,6 +1777,11 @@ func (command) RunCommand(prog string, args []string, stdin io.Reader, stdout, s
 		return 1
 	}
 
+	if *logFormat != "text" && *logFormat != "json" {
+		log.Printf("%s: unsupported log format %q", containerId, *logFormat)
+		return 1
+	}
+
 	log.Printf("crunch-run %s started", cmd.Version.String())
 	time.Sleep(*sleep)
 
@@ -1853,20 +1804,14 @@ func (command) RunCommand(prog string, args []string, stdin io.Reader, stdout, s
 	kc.BlockCache = &keepclient.BlockCache{MaxBlocks: 2}
 	kc.Retries = 4
 
-	// API version 1.21 corresponds to Docker 1.9, which is currently the
-	// minimum version we want to support.
-	docker, dockererr := dockerclient.NewClient(dockerclient.DefaultDockerHost, "1.21", nil, nil)
-
-	cr, err := NewContainerRunner(arvados.NewClientFromEnv(), api, kc, docker, containerId)
+	cr, err := NewContainerRunner(arvados.NewClientFromEnv(), api, kc, containerId)
 	if err != nil {
 		log.Print(err)
 		return 1
 	}
-	if dockererr != nil {
-		cr.CrunchLog.Printf("%s: %v", containerId, dockererr)
-		cr.checkBrokenNode(dockererr)
-		cr.CrunchLog.Close()
-		return 1
+
+	if *logFormat == "json" {
+		cr.setStructuredLogs()
 	}
 
 	parentTemp, tmperr := cr.MkTempDir("", "crunch-run."+containerId+".")
@@ -1875,8 +1820,42 @@ func (command) RunCommand(prog string, args []string, stdin io.Reader, stdout, s
 		return 1
 	}
 
+	switch *keepMount {
+	case "arv-mount":
+	case "in-process":
+		if InProcessKeepMount == nil {
+			err = fmt.Errorf("in-process Keep mount is not supported by this build")
+		}
+		cr.MountKeepFS = InProcessKeepMount
+	default:
+		err = fmt.Errorf("unsupported keep mount type %q", *keepMount)
+	}
+	if err != nil {
+		log.Printf("%s: %v", containerId, err)
+		cr.CrunchLog.Close()
+		os.RemoveAll(parentTemp)
+		return 1
+	}
+
+	switch *runtimeEngine {
+	case "docker":
+		cr.executor, err = newDockerExecutor(containerId, cr.CrunchLog.Printf, 0)
+	case "singularity":
+		cr.executor, err = newSingularityExecutor(cr.CrunchLog.Printf, parentTemp)
+	default:
+		err = fmt.Errorf("unsupported runtime engine %q", *runtimeEngine)
+	}
+	if err != nil {
+		cr.CrunchLog.Printf("%s: %v", containerId, err)
+		cr.checkBrokenNode(err)
+		cr.CrunchLog.Close()
+		os.RemoveAll(parentTemp)
+		return 1
+	}
+
 	cr.parentTemp = parentTemp
 	cr.statInterval = *statInterval
+	cr.checkpointInterval = *checkpointInterval
 	cr.cgroupRoot = *cgroupRoot
 	cr.expectCgroupParent = *cgroupParent
 	cr.enableNetwork = *enableNetwork
@@ -0,0 +1,254 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	dockernetwork "github.com/docker/docker/api/types/network"
+	dockerclient "github.com/docker/docker/client"
+	"golang.org/x/net/context"
+)
+
+// ThinDockerClient is the minimal Docker client interface used by crunch-run.
+type ThinDockerClient interface {
+	ContainerAttach(ctx context.Context, container string, options dockertypes.ContainerAttachOptions) (dockertypes.HijackedResponse, error)
+	ContainerCreate(ctx context.Context, config *dockercontainer.Config, hostConfig *dockercontainer.HostConfig,
+		networkingConfig *dockernetwork.NetworkingConfig, containerName string) (dockercontainer.ContainerCreateCreatedBody, error)
+	ContainerStart(ctx context.Context, container string, options dockertypes.ContainerStartOptions) error
+	ContainerRemove(ctx context.Context, container string, options dockertypes.ContainerRemoveOptions) error
+	ContainerWait(ctx context.Context, container string, condition dockercontainer.WaitCondition) (<-chan dockercontainer.ContainerWaitOKBody, <-chan error)
+	ContainerInspect(ctx context.Context, id string) (dockertypes.ContainerJSON, error)
+	ImageInspectWithRaw(ctx context.Context, image string) (dockertypes.ImageInspect, []byte, error)
+	ImageLoad(ctx context.Context, input io.Reader, quiet bool) (dockertypes.ImageLoadResponse, error)
+	ImageRemove(ctx context.Context, image string, options dockertypes.ImageRemoveOptions) ([]dockertypes.ImageDeleteResponseItem, error)
+}
+
+// dockerExecutor is a containerExecutor that runs containers using
+// the Docker daemon.
+type dockerExecutor struct {
+	containerUUID    string
+	logf             func(string, ...interface{})
+	watchdogInterval time.Duration
+	dockerclient     ThinDockerClient
+	containerID      string
+	doneIO           chan struct{}
+
+	mtx     sync.Mutex
+	removed bool // docker confirmed the container no longer exists
+}
+
+func newDockerExecutor(containerUUID string, logf func(string, ...interface{}), watchdogInterval time.Duration) (*dockerExecutor, error) {
+	// API version 1.21 corresponds to Docker 1.9, which is
+	// currently the minimum version we want to support.
+	client, err := dockerclient.NewClient(dockerclient.DefaultDockerHost, "1.21", nil, nil)
+	if watchdogInterval < 1 {
+		watchdogInterval = time.Minute
+	}
+	return &dockerExecutor{
+		containerUUID:    containerUUID,
+		logf:             logf,
+		watchdogInterval: watchdogInterval,
+		dockerclient:     client,
+	}, err
+}
+
+func (e *dockerExecutor) ImageLoaded(imageID, imagePDH string) bool {
+	_, _, err := e.dockerclient.ImageInspectWithRaw(context.TODO(), imageID)
+	return err == nil
+}
+
+func (e *dockerExecutor) LoadImage(imageID, imagePDH string, tarball io.Reader) error {
+	response, err := e.dockerclient.ImageLoad(context.TODO(), tarball, true)
+	if err != nil {
+		return fmt.Errorf("While loading container image into Docker: %v", err)
+	}
+	defer response.Body.Close()
+	rbody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("Reading response to image load: %v", err)
+	}
+	e.logf("Docker response: %s", rbody)
+	return nil
+}
+
+func (e *dockerExecutor) Create(spec containerSpec) error {
+	stdinUsed := spec.Stdin != nil
+	cfg := dockercontainer.Config{
+		Image:        spec.Image,
+		Cmd:          spec.Command,
+		WorkingDir:   spec.WorkingDir,
+		Env:          spec.Env,
+		Volumes:      map[string]struct{}{},
+		OpenStdin:    stdinUsed,
+		StdinOnce:    stdinUsed,
+		AttachStdin:  stdinUsed,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+
+	maxRAM := spec.RAM
+	minDockerRAM := int64(16)
+	if maxRAM < minDockerRAM*1024*1024 {
+		// Docker daemon won't let you set a limit less than ~10 MiB
+		maxRAM = minDockerRAM * 1024 * 1024
+	}
+	hostCfg := dockercontainer.HostConfig{
+		Binds: spec.Binds,
+		LogConfig: dockercontainer.LogConfig{
+			Type: "none",
+		},
+		NetworkMode: dockercontainer.NetworkMode("none"),
+		Resources: dockercontainer.Resources{
+			CgroupParent: spec.CgroupParent,
+			NanoCPUs:     int64(spec.VCPUs) * 1000000000,
+			Memory:       maxRAM, // RAM
+			MemorySwap:   maxRAM, // RAM+swap
+			KernelMemory: maxRAM, // kernel portion
+		},
+	}
+	if spec.EnableNetwork {
+		hostCfg.NetworkMode = dockercontainer.NetworkMode(spec.NetworkMode)
+	}
+
+	created, err := e.dockerclient.ContainerCreate(context.TODO(), &cfg, &hostCfg, nil, e.containerUUID)
+	if err != nil {
+		return fmt.Errorf("While creating container: %v", err)
+	}
+	e.containerID = created.ID
+	return e.startIO(spec.Stdin, spec.Stdout, spec.Stderr)
+}
+
+// startIO attaches to the container's stdin, stdout, and stderr
+// streams, and starts goroutines to copy data to and from them.
+func (e *dockerExecutor) startIO(stdin io.Reader, stdout, stderr io.Writer) error {
+	response, err := e.dockerclient.ContainerAttach(context.TODO(), e.containerID,
+		dockertypes.ContainerAttachOptions{Stream: true, Stdin: stdin != nil, Stdout: true, Stderr: true})
+	if err != nil {
+		return fmt.Errorf("While attaching container stdout/stderr streams: %v", err)
+	}
+	if stdin != nil {
+		go func() {
+			_, err := io.Copy(response.Conn, stdin)
+			if err != nil {
+				e.logf("While writing stdin to docker container: %v", err)
+				e.Stop()
+			}
+			response.CloseWrite()
+		}()
+	}
+	e.doneIO = make(chan struct{})
+	go e.handleStdoutStderr(response.Reader, stdout, stderr)
+	return nil
+}
+
+// handleStdoutStderr demultiplexes the container's output stream,
+// according to the docker log protocol
+// (https://docs.docker.com/engine/reference/api/docker_remote_api_v1.15/#attach-to-a-container).
+func (e *dockerExecutor) handleStdoutStderr(containerReader io.Reader, stdout, stderr io.Writer) {
+	defer close(e.doneIO)
+
+	header := make([]byte, 8)
+	var err error
+	for err == nil {
+		_, err = io.ReadAtLeast(containerReader, header, 8)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			break
+		}
+		readsize := int64(header[7]) | (int64(header[6]) << 8) | (int64(header[5]) << 16) | (int64(header[4]) << 24)
+		if header[0] == 1 {
+			// stdout
+			_, err = io.CopyN(stdout, containerReader, readsize)
+		} else {
+			// stderr
+			_, err = io.CopyN(stderr, containerReader, readsize)
+		}
+	}
+	if err != nil {
+		e.logf("error reading docker logs: %v", err)
+	}
+}
+
+func (e *dockerExecutor) Start() error {
+	return e.dockerclient.ContainerStart(context.TODO(), e.containerID,
+		dockertypes.ContainerStartOptions{})
+}
+
+func (e *dockerExecutor) CgroupID() string {
+	return e.containerID
+}
+
+func (e *dockerExecutor) Stop() error {
+	err := e.dockerclient.ContainerRemove(context.TODO(), e.containerID, dockertypes.ContainerRemoveOptions{Force: true})
+	if err != nil && strings.Contains(err.Error(), "No such container: "+e.containerID) {
+		err = nil
+	}
+	if err == nil {
+		e.mtx.Lock()
+		e.removed = true
+		e.mtx.Unlock()
+	}
+	return err
+}
+
+func (e *dockerExecutor) Wait(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Check periodically that the container still exists, in
+	// case the docker daemon loses track of it and never
+	// returns a status.
+	watchdogErr := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(e.watchdogInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			dctx, dcancel := context.WithDeadline(ctx, time.Now().Add(e.watchdogInterval))
+			ctr, err := e.dockerclient.ContainerInspect(dctx, e.containerID)
+			dcancel()
+			e.mtx.Lock()
+			removed := e.removed
+			e.mtx.Unlock()
+			if ctx.Err() != nil || removed {
+				return
+			} else if err != nil {
+				watchdogErr <- fmt.Errorf("error inspecting container: %s", err)
+				return
+			} else if ctr.State == nil || !(ctr.State.Running || ctr.State.Status == "created") {
+				watchdogErr <- fmt.Errorf("Container is not running: State=%v", ctr.State)
+				return
+			}
+		}
+	}()
+
+	waitOk, waitErr := e.dockerclient.ContainerWait(ctx, e.containerID, dockercontainer.WaitConditionNotRunning)
+	select {
+	case waitBody := <-waitOk:
+		// wait for stdout/stderr to complete
+		<-e.doneIO
+		return int(waitBody.StatusCode), nil
+	case err := <-waitErr:
+		return -1, fmt.Errorf("container wait: %v", err)
+	case err := <-watchdogErr:
+		return -1, err
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// containerSpec describes a container to be run by a
+// containerExecutor.
+type containerSpec struct {
+	Image      string
+	VCPUs      int
+	RAM        int64
+	WorkingDir string // empty means use the image's default
+	Command    []string
+
+	// Environment variables, in "NAME=value" form.
+	Env []string
+
+	// Bind mounts, in "hostpath:containerpath" or
+	// "hostpath:containerpath:ro" form.
+	Binds []string
+
+	EnableNetwork bool
+	NetworkMode   string // docker network mode, normally "default"
+	CgroupParent  string
+
+	Stdin  io.Reader // nil means no stdin
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// A containerExecutor runs a container using a particular container
+// runtime (Docker, Singularity, etc).
+//
+// The ContainerRunner calls LoadImage (if ImageLoaded returns false),
+// then Create, Start, and Wait. Stop can be called at any time after
+// Create.
+type containerExecutor interface {
+	// ImageLoaded returns true if the image with the given ID,
+	// stored in the collection with the given portable data
+	// hash, is already available, i.e., LoadImage doesn't need
+	// to be called.
+	ImageLoaded(imageID, imagePDH string) bool
+
+	// LoadImage loads the image with the given ID from a tarball
+	// in "docker save" format, as stored in Keep by
+	// arv-keepdocker in the collection with the given portable
+	// data hash.
+	LoadImage(imageID, imagePDH string, tarball io.Reader) error
+
+	// Create prepares a container according to the given spec,
+	// including connecting its stdin, stdout, and stderr, but
+	// does not start it.
+	Create(spec containerSpec) error
+
+	// Start starts the container prepared by Create.
+	Start() error
+
+	// CgroupID returns the container's cgroup ID, for collecting
+	// resource usage statistics, or "" if it's unknown.
+	CgroupID() string
+
+	// Stop kills the container. Wait returns soon afterward.
+	Stop() error
+
+	// Wait waits for the container to exit, and returns its exit
+	// code. When Wait returns, everything the container wrote to
+	// stdout and stderr has been written to the spec's Stdout
+	// and Stderr.
+	Wait(context.Context) (int, error)
+}
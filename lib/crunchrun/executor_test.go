@@ -0,0 +1,225 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+)
+
+// stubExecutor is a containerExecutor that doesn't run anything. It
+// records the spec passed to Create, and calls the given function in
+// place of the container process.
+type stubExecutor struct {
+	loaded   map[string]bool
+	loadErr  error
+	spec     containerSpec
+	created  bool
+	started  bool
+	stopped  chan struct{}
+	exitCode int
+	fn       func(e *stubExecutor)
+}
+
+func newStubExecutor(exitCode int, fn func(e *stubExecutor)) *stubExecutor {
+	return &stubExecutor{
+		loaded:   map[string]bool{},
+		stopped:  make(chan struct{}),
+		exitCode: exitCode,
+		fn:       fn,
+	}
+}
+
+func (e *stubExecutor) ImageLoaded(imageID, imagePDH string) bool { return e.loaded[imageID] }
+
+func (e *stubExecutor) LoadImage(imageID, imagePDH string, tarball io.Reader) error {
+	if e.loadErr != nil {
+		return e.loadErr
+	}
+	_, err := io.Copy(ioutil.Discard, tarball)
+	if err != nil {
+		return err
+	}
+	e.loaded[imageID] = true
+	return nil
+}
+
+func (e *stubExecutor) Create(spec containerSpec) error {
+	e.spec = spec
+	e.created = true
+	return nil
+}
+
+func (e *stubExecutor) Start() error {
+	e.started = true
+	return nil
+}
+
+func (e *stubExecutor) CgroupID() string { return "stub" }
+
+func (e *stubExecutor) Stop() error {
+	select {
+	case <-e.stopped:
+	default:
+		close(e.stopped)
+	}
+	return nil
+}
+
+func (e *stubExecutor) Wait(context.Context) (int, error) {
+	e.fn(e)
+	return e.exitCode, nil
+}
+
+// stubRunHelper runs the given container record with a stubExecutor.
+// If setup funcs are given, they are called before running the
+// container.
+func (s *TestSuite) stubRunHelper(c *C, record string, e *stubExecutor, setup ...func(*ContainerRunner)) (*ArvTestClient, *ContainerRunner) {
+	rec := arvados.Container{}
+	err := json.Unmarshal([]byte(record), &rec)
+	c.Assert(err, IsNil)
+
+	api := &ArvTestClient{Container: rec}
+	kc := &KeepTestClient{}
+	defer kc.Close()
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	c.Assert(err, IsNil)
+	s.runner = cr
+	cr.executor = e
+	cr.statInterval = 100 * time.Millisecond
+	am := &ArvMountCmdLine{}
+	cr.RunArvMount = am.ArvMountTest
+
+	realTemp, err := ioutil.TempDir("", "crunchrun_test_stub-")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(realTemp)
+	tempcount := 0
+	cr.MkTempDir = func(_ string, prefix string) (string, error) {
+		tempcount++
+		d := fmt.Sprintf("%s/%s%d", realTemp, prefix, tempcount)
+		return d, os.Mkdir(d, os.ModePerm)
+	}
+	cr.MkArvClient = func(token string) (IArvadosClient, IKeepClient, *arvados.Client, error) {
+		return &ArvTestClient{}, &KeepTestClient{}, nil, nil
+	}
+	for _, f := range setup {
+		f(cr)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- cr.Run()
+	}()
+	select {
+	case <-time.After(20 * time.Second):
+		c.Fatal("timed out")
+	case err = <-done:
+		c.Check(err, IsNil)
+	}
+	return api, cr
+}
+
+func (s *TestSuite) TestStubExecutorSpec(c *C) {
+	e := newStubExecutor(3, func(e *stubExecutor) {
+		fmt.Fprintln(e.spec.Stdout, "hello stdout")
+		fmt.Fprintln(e.spec.Stderr, "hello stderr")
+	})
+	api, _ := s.stubRunHelper(c, `{
+    "command": ["echo", "hello"],
+    "container_image": "a45557269dcb65a6b78f9ac061c0850b+120",
+    "cwd": "/bin",
+    "environment": {"FROBIZ": "bilbo"},
+    "mounts": {
+        "/tmp": {"kind": "tmp"},
+        "/etc/foo.json": {"kind": "json", "content": {"foo": "bar"}}
+    },
+    "output_path": "/tmp",
+    "priority": 1,
+    "runtime_constraints": {"vcpus": 2, "ram": 1000000000},
+    "state": "Locked"
+}`, e)
+
+	c.Check(e.loaded[hwImageId], Equals, true)
+	c.Check(e.started, Equals, true)
+	c.Check(e.spec.Image, Equals, hwImageId)
+	c.Check(e.spec.Command, DeepEquals, []string{"echo", "hello"})
+	c.Check(e.spec.WorkingDir, Equals, "/bin")
+	c.Check(e.spec.Env, DeepEquals, []string{"FROBIZ=bilbo"})
+	c.Check(e.spec.VCPUs, Equals, 2)
+	c.Check(e.spec.RAM, Equals, int64(1000000000))
+	c.Check(e.spec.EnableNetwork, Equals, false)
+	c.Check(e.spec.Stdin, IsNil)
+	binds := append([]string(nil), e.spec.Binds...)
+	sort.Strings(binds)
+	c.Assert(binds, HasLen, 2)
+	c.Check(binds[0], Matches, `.*/json\d+/mountdata.json:/etc/foo.json:ro`)
+	c.Check(binds[1], Matches, `.*/tmp\d+:/tmp`)
+
+	c.Check(api.CalledWith("container.exit_code", 3), NotNil)
+	c.Check(api.CalledWith("container.state", "Complete"), NotNil)
+	c.Check(api.Logs["stdout"].String(), Matches, `(?ms).*hello stdout\n`)
+	c.Check(api.Logs["stderr"].String(), Matches, `(?ms).*hello stderr\n`)
+	c.Check(api.Logs["crunch-run"].String(), Matches, `(?ms).*Container exited with code: 3.*`)
+}
+
+func (s *TestSuite) TestStubExecutorAPIAndStdin(c *C) {
+	var stdin []byte
+	e := newStubExecutor(0, func(e *stubExecutor) {
+		stdin, _ = ioutil.ReadAll(e.spec.Stdin)
+	})
+	_, _ = s.stubRunHelper(c, `{
+    "command": ["cat"],
+    "container_image": "a45557269dcb65a6b78f9ac061c0850b+120",
+    "cwd": ".",
+    "environment": {},
+    "mounts": {
+        "/tmp": {"kind": "tmp"},
+        "stdin": {"kind": "json", "content": {"foo": "bar"}}
+    },
+    "output_path": "/tmp",
+    "priority": 1,
+    "runtime_constraints": {"API": true},
+    "state": "Locked"
+}`, e)
+
+	c.Check(string(stdin), Equals, `{"foo":"bar"}`)
+	c.Check(e.spec.WorkingDir, Equals, "")
+	c.Check(e.spec.EnableNetwork, Equals, true)
+	c.Assert(e.spec.Env, HasLen, 3)
+	c.Check(strings.HasPrefix(e.spec.Env[0], "ARVADOS_API_TOKEN=v2/"), Equals, true)
+	c.Check(strings.HasPrefix(e.spec.Env[1], "ARVADOS_API_HOST="), Equals, true)
+}
+
+func (s *TestSuite) TestStubExecutorStopOnSignal(c *C) {
+	e := newStubExecutor(137, func(e *stubExecutor) {
+		s.runner.SigChan <- syscall.SIGINT
+		<-e.stopped
+	})
+	api, cr := s.stubRunHelper(c, `{
+    "command": ["sleep", "30"],
+    "container_image": "a45557269dcb65a6b78f9ac061c0850b+120",
+    "cwd": ".",
+    "environment": {},
+    "mounts": {"/tmp": {"kind": "tmp"}},
+    "output_path": "/tmp",
+    "priority": 1,
+    "runtime_constraints": {},
+    "state": "Locked"
+}`, e)
+	c.Check(cr.IsCancelled(), Equals, true)
+	c.Check(api.CalledWith("container.state", "Cancelled"), NotNil)
+	c.Check(api.Logs["crunch-run"].String(), Matches, `(?ms).*stopping container.*`)
+}
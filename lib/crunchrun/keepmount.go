@@ -0,0 +1,122 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+)
+
+// A KeepMount is a Keep filesystem mounted by the crunch-run process
+// itself, instead of an arv-mount child process.
+type KeepMount interface {
+	// TmpCollection returns the collection filesystem that backs
+	// the writable top-level directory with the given name, or
+	// nil if there is no such directory.
+	TmpCollection(name string) arvados.CollectionFileSystem
+
+	// Unmount unmounts the filesystem.
+	Unmount() error
+
+	// Done returns a channel that is closed when the filesystem
+	// is unmounted.
+	Done() <-chan struct{}
+}
+
+// KeepMountOptions are passed to a MountKeepFS func.
+type KeepMountOptions struct {
+	// Names of writable top-level directories, each backed by a
+	// new empty collection.
+	Tmp []string
+
+	// Block cache size (number of 64 MiB blocks). Zero means use
+	// the default.
+	BlockCache int
+
+	// Options passed through to the FUSE library, like
+	// []string{"-o", "allow_other"}.
+	FUSEOptions []string
+}
+
+// MountKeepFS mounts a Keep filesystem at mountpoint, using the given
+// client, and returns when the mount is ready. The filesystem has a
+// by_id directory (like arv-mount --mount-by-id) and the writable
+// directories specified in opts.
+type MountKeepFS func(mountpoint string, client *arvados.Client, opts KeepMountOptions) (KeepMount, error)
+
+// InProcessKeepMount, if non-nil, is used to mount Keep when
+// crunch-run is invoked with -keep-mount=in-process.
+//
+// It is nil unless the calling program provides one (normally
+// lib/mount.MountInProcess). This avoids adding a FUSE library
+// dependency to every program that uses this package.
+var InProcessKeepMount MountKeepFS
+
+// startKeepMount mounts Keep at runner.ArvMountPoint using
+// runner.MountKeepFS, and arranges for runner.ArvMountExit to be
+// closed when the filesystem is unmounted.
+func (runner *ContainerRunner) startKeepMount(token string, tmp []string) error {
+	client := arvados.NewClientFromEnv()
+	client.AuthToken = token
+
+	opts := KeepMountOptions{
+		Tmp:         tmp,
+		FUSEOptions: []string{"-o", "allow_other"},
+	}
+	if ram := runner.Container.RuntimeConstraints.KeepCacheRAM; ram > 0 {
+		opts.BlockCache = int((ram + 1<<26 - 1) >> 26)
+	}
+
+	runner.CrunchLog.Printf("Mounting Keep at %s (in-process)", runner.ArvMountPoint)
+	km, err := runner.MountKeepFS(runner.ArvMountPoint, client, opts)
+	if err != nil {
+		return err
+	}
+	runner.keepMount = km
+	runner.ArvMountExit = make(chan error)
+	go func() {
+		<-km.Done()
+		close(runner.ArvMountExit)
+	}()
+	return nil
+}
+
+// stopKeepMount unmounts the in-process Keep mount, and waits (with a
+// timeout) for it to finish.
+func (runner *ContainerRunner) stopKeepMount() {
+	runner.CrunchLog.Printf("Unmounting %s", runner.ArvMountPoint)
+	err := runner.keepMount.Unmount()
+	if err != nil {
+		runner.CrunchLog.Printf("Error unmounting: %v", err)
+		return
+	}
+	select {
+	case <-runner.keepMount.Done():
+	case <-time.After(9 * time.Second):
+		runner.CrunchLog.Printf("Timed out waiting for unmount")
+	}
+}
+
+// outputCollection returns the collection filesystem backing the
+// container's output directory, if the output directory is a
+// writable collection mounted in-process and can be captured without
+// walking the output directory: i.e., there are no other mounts
+// below it. Otherwise it returns nil.
+func (runner *ContainerRunner) outputCollection() arvados.CollectionFileSystem {
+	if runner.keepMount == nil || runner.outputTmp == "" {
+		return nil
+	}
+	prefix := runner.Container.OutputPath + "/"
+	for _, mounts := range []map[string]arvados.Mount{runner.Container.Mounts, runner.SecretMounts} {
+		for bind := range mounts {
+			if strings.HasPrefix(bind, prefix) && bind != prefix {
+				return nil
+			}
+		}
+	}
+	return runner.keepMount.TmpCollection(runner.outputTmp)
+}
@@ -0,0 +1,154 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	. "gopkg.in/check.v1"
+)
+
+// fakeKeepMount is a KeepMount that doesn't use FUSE. Tmp dirs are
+// created as empty host directories, and backed by in-memory
+// collections that tests can write to directly.
+type fakeKeepMount struct {
+	mountpoint string
+	opts       KeepMountOptions
+	tmp        map[string]arvados.CollectionFileSystem
+	unmounted  bool
+	done       chan struct{}
+	once       sync.Once
+}
+
+func (s *TestSuite) fakeMountKeepFS(fkm **fakeKeepMount) MountKeepFS {
+	return func(mountpoint string, client *arvados.Client, opts KeepMountOptions) (KeepMount, error) {
+		km := &fakeKeepMount{
+			mountpoint: mountpoint,
+			opts:       opts,
+			tmp:        map[string]arvados.CollectionFileSystem{},
+			done:       make(chan struct{}),
+		}
+		for _, name := range opts.Tmp {
+			err := os.Mkdir(mountpoint+"/"+name, 0777)
+			if err != nil {
+				return nil, err
+			}
+			km.tmp[name], err = (&arvados.Collection{}).FileSystem(s.client, &KeepTestClient{})
+			if err != nil {
+				return nil, err
+			}
+		}
+		*fkm = km
+		return km, nil
+	}
+}
+
+func (km *fakeKeepMount) TmpCollection(name string) arvados.CollectionFileSystem {
+	return km.tmp[name]
+}
+
+func (km *fakeKeepMount) Unmount() error {
+	km.once.Do(func() {
+		km.unmounted = true
+		close(km.done)
+	})
+	return nil
+}
+
+func (km *fakeKeepMount) Done() <-chan struct{} {
+	return km.done
+}
+
+func (s *TestSuite) TestKeepMountInProcessOutput(c *C) {
+	var km *fakeKeepMount
+	e := newStubExecutor(0, func(e *stubExecutor) {
+		fs := km.TmpCollection("tmp0")
+		c.Assert(fs, NotNil)
+		c.Assert(fs.Mkdir("dir", 0755), IsNil)
+		f, err := fs.OpenFile("dir/foo", os.O_CREATE|os.O_WRONLY, 0644)
+		c.Assert(err, IsNil)
+		_, err = f.Write([]byte("foo"))
+		c.Check(err, IsNil)
+		c.Check(f.Close(), IsNil)
+	})
+	_, cr := s.stubRunHelper(c, `{
+    "command": ["true"],
+    "container_image": "a45557269dcb65a6b78f9ac061c0850b+120",
+    "cwd": ".",
+    "environment": {},
+    "mounts": {"/out": {"kind": "collection", "writable": true}},
+    "output_path": "/out",
+    "priority": 1,
+    "runtime_constraints": {"keep_cache_ram": 100000000},
+    "state": "Locked"
+}`, e, func(cr *ContainerRunner) {
+		cr.MountKeepFS = s.fakeMountKeepFS(&km)
+	})
+
+	c.Assert(km, NotNil)
+	c.Check(km.opts.Tmp, DeepEquals, []string{"tmp0"})
+	c.Check(km.opts.BlockCache, Equals, 2)
+	c.Check(km.opts.FUSEOptions, DeepEquals, []string{"-o", "allow_other"})
+	c.Check(km.unmounted, Equals, true)
+	c.Check(e.spec.Binds, DeepEquals, []string{km.mountpoint + "/tmp0:/out"})
+	c.Check(cr.ContainerArvClient.(*ArvTestClient).CalledWith("collection.manifest_text", "./dir acbd18db4cc2f85cedef654fccc4a4d8+3 0:3:foo\n"), NotNil)
+}
+
+func (s *TestSuite) TestKeepMountInProcessMountsBelowOutput(c *C) {
+	// With another mount below the output dir, the output
+	// collection isn't used directly: the output dir is walked
+	// as usual, which means reading the manifest from the
+	// mounted collection's .arvados#collection file.
+	var km *fakeKeepMount
+	e := newStubExecutor(0, func(e *stubExecutor) {
+		err := ioutil.WriteFile(km.mountpoint+"/tmp0/.arvados#collection", []byte(`{"manifest_text":". 9bb58f26192e4ba00f01e2e7b136bbd8+13 0:13:foo.json\n"}`), 0644)
+		c.Check(err, IsNil)
+	})
+	_, cr := s.stubRunHelper(c, `{
+    "command": ["true"],
+    "container_image": "a45557269dcb65a6b78f9ac061c0850b+120",
+    "cwd": ".",
+    "environment": {},
+    "mounts": {
+        "/out": {"kind": "collection", "writable": true},
+        "/out/foo.json": {"kind": "json", "content": {"foo": "bar"}}
+    },
+    "output_path": "/out",
+    "priority": 1,
+    "runtime_constraints": {},
+    "state": "Locked"
+}`, e, func(cr *ContainerRunner) {
+		cr.MountKeepFS = s.fakeMountKeepFS(&km)
+	})
+
+	c.Assert(km, NotNil)
+	c.Check(km.opts.BlockCache, Equals, 0)
+	c.Check(cr.ContainerArvClient.(*ArvTestClient).CalledWith("collection.manifest_text", ". 9bb58f26192e4ba00f01e2e7b136bbd8+13 0:13:foo.json\n"), NotNil)
+}
+
+func (s *TestSuite) TestKeepMountInProcessError(c *C) {
+	api := &ArvTestClient{}
+	kc := &KeepTestClient{}
+	defer kc.Close()
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzz-zzzzzzzzzzzzzzz")
+	c.Assert(err, IsNil)
+	realTemp := c.MkDir()
+	cr.parentTemp = realTemp
+	cr.MkTempDir = func(_ string, prefix string) (string, error) {
+		return ioutil.TempDir(realTemp, prefix)
+	}
+	cr.MountKeepFS = func(string, *arvados.Client, KeepMountOptions) (KeepMount, error) {
+		return nil, errors.New("fuse is broken")
+	}
+	cr.Container.Mounts = map[string]arvados.Mount{"/tmp": {Kind: "tmp"}}
+	cr.Container.OutputPath = "/tmp"
+	err = cr.SetupMounts()
+	c.Check(err, ErrorMatches, `While trying to mount Keep: fuse is broken`)
+	c.Check(cr.keepMount, IsNil)
+}
@@ -7,6 +7,7 @@ package crunchrun
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -203,11 +204,15 @@ var crunchLogUpdateSize = int64(1 << 25)
 // ArvLogWriter is an io.WriteCloser that processes each write by
 // writing it through to another io.WriteCloser (typically a
 // CollectionFileWriter) and creating an Arvados log entry.
+//
+// If structured is true, each "timestamp message" line is converted
+// to a JSON record (see jsonLogLine) before being written.
 type ArvLogWriter struct {
 	ArvClient     IArvadosClient
 	UUID          string
 	loggingStream string
 	writeCloser   io.WriteCloser
+	structured    bool
 
 	// for rate limiting
 	bytesLogged                  int64
@@ -227,7 +232,11 @@ func (arvlog *ArvLogWriter) Write(p []byte) (int, error) {
 	// Write to the next writer in the chain (a file in Keep)
 	var err1 error
 	if arvlog.writeCloser != nil {
-		_, err1 = arvlog.writeCloser.Write(p)
+		if arvlog.structured {
+			_, err1 = arvlog.writeCloser.Write(arvlog.formatLines(p))
+		} else {
+			_, err1 = arvlog.writeCloser.Write(p)
+		}
 	}
 
 	// write to API after checking rate limit
@@ -237,7 +246,7 @@ func (arvlog *ArvLogWriter) Write(p []byte) (int, error) {
 		// It has been more than throttle_period seconds since the last
 		// checkpoint; so reset the throttle
 		if arvlog.logThrottleBytesSkipped > 0 {
-			arvlog.bufToFlush.WriteString(fmt.Sprintf("%s Skipped %d bytes of log\n", RFC3339Timestamp(now.UTC()), arvlog.logThrottleBytesSkipped))
+			arvlog.bufToFlush.Write(arvlog.formatLine([]byte(fmt.Sprintf("%s Skipped %d bytes of log", RFC3339Timestamp(now.UTC()), arvlog.logThrottleBytesSkipped))))
 		}
 
 		arvlog.logThrottleResetTime = now.Add(crunchLogThrottlePeriod)
@@ -262,7 +271,7 @@ func (arvlog *ArvLogWriter) Write(p []byte) (int, error) {
 		// check rateLimit
 		logOpen, msg := arvlog.rateLimit(line, now)
 		if logOpen {
-			arvlog.bufToFlush.WriteString(string(msg) + "\n")
+			arvlog.bufToFlush.Write(arvlog.formatLine(msg))
 		}
 	}
 
@@ -298,7 +307,65 @@ func (arvlog *ArvLogWriter) Close() (err error) {
 	return err
 }
 
+// jsonLogLine is the format of each line written by an ArvLogWriter
+// in structured mode.
+type jsonLogLine struct {
+	Time          string `json:"time"`
+	Stream        string `json:"stream"`
+	ContainerUUID string `json:"container_uuid"`
+	Severity      string `json:"severity"`
+	Message       string `json:"msg"`
+}
+
+// formatLines returns p with each non-empty line converted by
+// formatLine.
+func (arvlog *ArvLogWriter) formatLines(p []byte) []byte {
+	var out bytes.Buffer
+	for _, line := range bytes.Split(p, []byte("\n")) {
+		if len(line) > 0 {
+			out.Write(arvlog.formatLine(line))
+		}
+	}
+	return out.Bytes()
+}
+
+// formatLine returns the given log line (without trailing newline)
+// in the writer's output format, with a trailing newline.
+//
+// In structured mode, the line's leading timestamp, if any, is used
+// as the record's time; otherwise the current time is used.
+func (arvlog *ArvLogWriter) formatLine(line []byte) []byte {
+	if !arvlog.structured {
+		return append(append([]byte(nil), line...), '\n')
+	}
+	rec := jsonLogLine{
+		Stream:        arvlog.loggingStream,
+		ContainerUUID: arvlog.UUID,
+		Severity:      "info",
+		Message:       string(line),
+	}
+	if arvlog.loggingStream == "stderr" {
+		rec.Severity = "error"
+	}
+	if matches := timestampRegexp.FindSubmatch(line); matches != nil {
+		if t, err := time.Parse(time.RFC3339Nano, string(matches[1])); err == nil {
+			rec.Time = RFC3339Timestamp(t.UTC())
+			rec.Message = string(matches[2])
+		}
+	}
+	if rec.Time == "" {
+		rec.Time = RFC3339Timestamp(time.Now().UTC())
+	}
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		// Can't happen: all fields are strings.
+		panic(err)
+	}
+	return append(buf, '\n')
+}
+
 var lineRegexp = regexp.MustCompile(`^\S+ (.*)`)
+var timestampRegexp = regexp.MustCompile(`^(\S+) (.*)`)
 
 // Test for hard cap on total output and for log throttling. Returns whether
 // the log line should go to output or not. Returns message if limit exceeded.
@@ -6,6 +6,7 @@ package crunchrun
 
 import (
 	"fmt"
+	"io/ioutil"
 	"strings"
 	"testing"
 	"time"
@@ -45,7 +46,7 @@ func (s *LoggingTestSuite) TestWriteLogs(c *C) {
 	api := &ArvTestClient{}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 	cr.CrunchLog.Timestamper = (&TestTimestamper{}).Timestamp
 
@@ -74,7 +75,7 @@ func (s *LoggingTestSuite) TestWriteLogsLarge(c *C) {
 	api := &ArvTestClient{}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 	cr.CrunchLog.Timestamper = (&TestTimestamper{}).Timestamp
 	cr.CrunchLog.Immediate = nil
@@ -97,7 +98,7 @@ func (s *LoggingTestSuite) TestWriteMultipleLogs(c *C) {
 	api := &ArvTestClient{}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 	ts := &TestTimestamper{}
 	cr.CrunchLog.Timestamper = ts.Timestamp
@@ -131,6 +132,42 @@ func (s *LoggingTestSuite) TestWriteMultipleLogs(c *C) {
 	c.Check(mt, Equals, ". 48f9023dc683a850b1c9b482b14c4b97+163 0:83:crunch-run.txt 83:80:stdout.txt\n")
 }
 
+func (s *LoggingTestSuite) TestWriteStructuredLogs(c *C) {
+	api := &ArvTestClient{}
+	kc := &KeepTestClient{}
+	defer kc.Close()
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzzzzzzzzzzzz")
+	c.Assert(err, IsNil)
+	cr.setStructuredLogs()
+	ts := &TestTimestamper{}
+	cr.CrunchLog.Timestamper = ts.Timestamp
+	w, err := cr.NewLogWriter("stderr")
+	c.Assert(err, IsNil)
+	stderr := NewThrottledLogger(w)
+	stderr.Timestamper = ts.Timestamp
+
+	cr.CrunchLog.Print("Hello world!")
+	stderr.Print(`Oops "quoted"`)
+	cr.CrunchLog.Close()
+	stderr.Close()
+
+	logText := make(map[string]string)
+	for _, content := range api.Content {
+		log := content["log"].(arvadosclient.Dict)
+		logText[log["event_type"].(string)] += log["properties"].(map[string]string)["text"]
+	}
+	c.Check(logText["crunch-run"], Equals, `{"time":"2015-12-29T15:51:45.000000001Z","stream":"crunch-run","container_uuid":"zzzzz-zzzzzzzzzzzzzzz","severity":"info","msg":"Hello world!"}
+`)
+	c.Check(logText["stderr"], Equals, `{"time":"2015-12-29T15:51:45.000000002Z","stream":"stderr","container_uuid":"zzzzz-zzzzzzzzzzzzzzz","severity":"error","msg":"Oops \"quoted\""}
+`)
+
+	f, err := cr.LogCollection.Open("crunch-run.txt")
+	c.Assert(err, IsNil)
+	buf, err := ioutil.ReadAll(f)
+	c.Check(err, IsNil)
+	c.Check(string(buf), Equals, logText["crunch-run"])
+}
+
 func (s *LoggingTestSuite) TestLogUpdate(c *C) {
 	for _, trial := range []struct {
 		maxBytes    int64
@@ -146,7 +183,7 @@ func (s *LoggingTestSuite) TestLogUpdate(c *C) {
 		api := &ArvTestClient{}
 		kc := &KeepTestClient{}
 		defer kc.Close()
-		cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzzzzzzzzzzzz")
+		cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzzzzzzzzzzzz")
 		c.Assert(err, IsNil)
 		ts := &TestTimestamper{}
 		cr.CrunchLog.Timestamper = ts.Timestamp
@@ -197,7 +234,7 @@ func (s *LoggingTestSuite) testWriteLogsWithRateLimit(c *C, throttleParam string
 	api := &ArvTestClient{}
 	kc := &KeepTestClient{}
 	defer kc.Close()
-	cr, err := NewContainerRunner(s.client, api, kc, nil, "zzzzz-zzzzzzzzzzzzzzz")
+	cr, err := NewContainerRunner(s.client, api, kc, "zzzzz-zzzzzzzzzzzzzzz")
 	c.Assert(err, IsNil)
 	cr.CrunchLog.Timestamper = (&TestTimestamper{}).Timestamp
 
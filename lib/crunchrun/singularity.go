@@ -0,0 +1,236 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"golang.org/x/net/context"
+)
+
+// singularityExecutor is a containerExecutor that runs containers
+// using Singularity (or Apptainer), which doesn't need a daemon or
+// root privileges.
+//
+// The container runs as a child process of crunch-run, so it stays
+// in crunch-run's own cgroup: containerSpec.CgroupParent names a
+// docker cgroup parent, and is not used. CPU and memory limits, and
+// network isolation, are only applied when running as root, because
+// Singularity can't set up cgroups or network namespaces for an
+// unprivileged user.
+type singularityExecutor struct {
+	logf          func(string, ...interface{})
+	binary        string // "singularity", or a test stub
+	tmpdir        string
+	cacheDir      string // converted images, named {pdh}.sif; "" means no cache
+	privileged    bool   // running as root
+	imageFilename string // SIF image converted from the docker tarball
+
+	mtx   sync.Mutex
+	child *exec.Cmd
+}
+
+func newSingularityExecutor(logf func(string, ...interface{}), tmpdir string) (*singularityExecutor, error) {
+	var cacheDir string
+	if dir, err := os.UserCacheDir(); err == nil {
+		cacheDir = filepath.Join(dir, "arvados", "singularity")
+	}
+	return &singularityExecutor{
+		logf:       logf,
+		binary:     "singularity",
+		tmpdir:     tmpdir,
+		cacheDir:   cacheDir,
+		privileged: os.Getuid() == 0,
+	}, nil
+}
+
+// ImageLoaded returns true if a SIF image converted from the given
+// image collection is already in the cache directory.
+func (e *singularityExecutor) ImageLoaded(imageID, imagePDH string) bool {
+	if e.cacheDir == "" {
+		return false
+	}
+	imageFilename := filepath.Join(e.cacheDir, imagePDH+".sif")
+	if _, err := os.Stat(imageFilename); err != nil {
+		return false
+	}
+	e.imageFilename = imageFilename
+	return true
+}
+
+// LoadImage saves the tarball to a temporary file and converts it to
+// a SIF image in the cache directory, so other containers using the
+// same image collection don't need to convert it again.
+func (e *singularityExecutor) LoadImage(imageID, imagePDH string, tarball io.Reader) error {
+	tarFilename := filepath.Join(e.tmpdir, imageID+".tar")
+	f, err := os.Create(tarFilename)
+	if err != nil {
+		return fmt.Errorf("While saving container image tarball: %v", err)
+	}
+	defer os.Remove(tarFilename)
+	_, err = io.Copy(f, tarball)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("While saving container image tarball: %v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("While saving container image tarball: %v", err)
+	}
+
+	// Convert into a new directory next to the cached image, so
+	// the finished image can be renamed into place atomically
+	// even if another crunch-run process is converting the same
+	// image.
+	builddir := e.tmpdir
+	if e.cacheDir != "" {
+		err = os.MkdirAll(e.cacheDir, 0700)
+		if err == nil {
+			builddir, err = ioutil.TempDir(e.cacheDir, ".build-")
+		}
+		if err != nil {
+			e.logf("Not caching converted image: %v", err)
+			builddir = e.tmpdir
+		} else {
+			defer os.RemoveAll(builddir)
+		}
+	}
+	imageFilename := filepath.Join(builddir, imageID+".sif")
+	e.logf("Converting Docker image to Singularity image %s", imageFilename)
+	build := exec.Command(e.binary, "build", imageFilename, "docker-archive://"+tarFilename)
+	// Keep singularity's build cache in our temp dir, rather than
+	// the invoking user's home directory.
+	build.Env = append(os.Environ(),
+		"SINGULARITY_TMPDIR="+e.tmpdir,
+		"SINGULARITY_CACHEDIR="+e.tmpdir)
+	out, err := build.CombinedOutput()
+	if len(out) > 0 {
+		e.logf("%s", out)
+	}
+	if err != nil {
+		return fmt.Errorf("While converting container image: %v", err)
+	}
+	if builddir != e.tmpdir {
+		cachedFilename := filepath.Join(e.cacheDir, imagePDH+".sif")
+		err = os.Rename(imageFilename, cachedFilename)
+		if err != nil {
+			return fmt.Errorf("While saving converted container image: %v", err)
+		}
+		imageFilename = cachedFilename
+	}
+	e.imageFilename = imageFilename
+	return nil
+}
+
+func (e *singularityExecutor) Create(spec containerSpec) error {
+	args := []string{"exec", "--containall", "--cleanenv"}
+	if spec.WorkingDir != "" {
+		args = append(args, "--pwd", spec.WorkingDir)
+	}
+	if !spec.EnableNetwork {
+		if e.privileged {
+			args = append(args, "--net", "--network=none")
+		} else {
+			e.logf("Warning: not running as root, so the container will have network access")
+		}
+	} else if spec.NetworkMode != "" && spec.NetworkMode != "default" && spec.NetworkMode != "host" {
+		// Singularity shares the host's network by
+		// default. Other modes name a CNI network
+		// configuration.
+		if e.privileged {
+			args = append(args, "--net", "--network="+spec.NetworkMode)
+		} else {
+			e.logf("Warning: not running as root, so network mode %q is ignored", spec.NetworkMode)
+		}
+	}
+	if e.privileged {
+		if spec.VCPUs > 0 {
+			args = append(args, fmt.Sprintf("--cpus=%d", spec.VCPUs))
+		}
+		if spec.RAM > 0 {
+			args = append(args, fmt.Sprintf("--memory=%d", spec.RAM))
+		}
+	} else if spec.VCPUs > 0 || spec.RAM > 0 {
+		e.logf("Warning: not running as root, so CPU and memory limits are not enforced")
+	}
+	for _, bind := range spec.Binds {
+		args = append(args, "--bind", bind)
+	}
+	args = append(args, e.imageFilename)
+	args = append(args, spec.Command...)
+
+	// With --cleanenv, only variables with the SINGULARITYENV_
+	// prefix are passed into the container (with the prefix
+	// removed).
+	env := []string{"PATH=" + os.Getenv("PATH")}
+	for _, kv := range spec.Env {
+		env = append(env, "SINGULARITYENV_"+kv)
+	}
+
+	child := exec.Command(e.binary, args...)
+	child.Env = env
+	child.Stdin = spec.Stdin
+	child.Stdout = spec.Stdout
+	child.Stderr = spec.Stderr
+	e.mtx.Lock()
+	e.child = child
+	e.mtx.Unlock()
+	return nil
+}
+
+func (e *singularityExecutor) Start() error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.child.Start()
+}
+
+func (e *singularityExecutor) CgroupID() string {
+	return ""
+}
+
+func (e *singularityExecutor) Stop() error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if e.child == nil || e.child.Process == nil {
+		// not started
+		return nil
+	}
+	err := e.child.Process.Signal(syscall.SIGKILL)
+	if err != nil && err.Error() == "os: process already finished" {
+		err = nil
+	}
+	return err
+}
+
+func (e *singularityExecutor) Wait(ctx context.Context) (int, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			e.Stop()
+		case <-done:
+		}
+	}()
+	err := e.child.Wait()
+	if err, ok := err.(*exec.ExitError); ok {
+		if ws, ok := err.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			// Report the exit code the same way as docker,
+			// e.g., 137 for SIGKILL.
+			return 128 + int(ws.Signal()), nil
+		}
+		return err.ExitCode(), nil
+	} else if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,210 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package crunchrun
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&singularitySuite{})
+
+type singularitySuite struct {
+	tmpdir   string
+	executor *singularityExecutor
+}
+
+// fakeSingularity is a stand-in for the singularity program. It
+// "builds" an image by copying the tarball, and "execs" a container
+// by saving its command line arguments and running the container
+// command on the host.
+const fakeSingularity = `#!/bin/sh
+set -e
+dir=$(dirname "$0")
+case "$1" in
+build)
+	cp "${3#docker-archive://}" "$2"
+	;;
+exec)
+	shift
+	echo "$@" >"$dir/exec-args"
+	while [ $# -gt 0 ]; do
+		case "$1" in
+		--bind|--pwd) shift 2 ;;
+		--*) shift ;;
+		*) break ;;
+		esac
+	done
+	shift
+	exec "$@"
+	;;
+*)
+	exit 1
+	;;
+esac
+`
+
+func (s *singularitySuite) SetUpTest(c *C) {
+	var err error
+	s.tmpdir, err = ioutil.TempDir("", "crunchrun_singularity_test-")
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(s.tmpdir+"/singularity", []byte(fakeSingularity), 0755)
+	c.Assert(err, IsNil)
+	s.executor, err = newSingularityExecutor(c.Logf, s.tmpdir)
+	c.Assert(err, IsNil)
+	s.executor.binary = s.tmpdir + "/singularity"
+	s.executor.cacheDir = s.tmpdir + "/cache"
+	s.executor.privileged = true
+}
+
+func (s *singularitySuite) TearDownTest(c *C) {
+	os.RemoveAll(s.tmpdir)
+}
+
+func (s *singularitySuite) TestLoadImage(c *C) {
+	c.Check(s.executor.ImageLoaded("abcdef", "fa1afe1+10"), Equals, false)
+	err := s.executor.LoadImage("abcdef", "fa1afe1+10", strings.NewReader("image content"))
+	c.Assert(err, IsNil)
+	c.Check(s.executor.imageFilename, Equals, s.tmpdir+"/cache/fa1afe1+10.sif")
+	buf, err := ioutil.ReadFile(s.executor.imageFilename)
+	c.Check(err, IsNil)
+	c.Check(string(buf), Equals, "image content")
+
+	// Temporary tarball and build directory should be deleted.
+	_, err = os.Stat(s.tmpdir + "/abcdef.tar")
+	c.Check(os.IsNotExist(err), Equals, true)
+	cached, err := ioutil.ReadDir(s.tmpdir + "/cache")
+	c.Assert(err, IsNil)
+	c.Check(cached, HasLen, 1)
+
+	// Another executor using the same cache directory should
+	// find the converted image.
+	e, err := newSingularityExecutor(c.Logf, c.MkDir())
+	c.Assert(err, IsNil)
+	e.cacheDir = s.executor.cacheDir
+	c.Check(e.ImageLoaded("abcdef", "fa1afe1+10"), Equals, true)
+	c.Check(e.imageFilename, Equals, s.tmpdir+"/cache/fa1afe1+10.sif")
+	c.Check(e.ImageLoaded("abcdef", "c0ffee+10"), Equals, false)
+}
+
+func (s *singularitySuite) TestLoadImageNoCache(c *C) {
+	s.executor.cacheDir = ""
+	c.Check(s.executor.ImageLoaded("abcdef", "fa1afe1+10"), Equals, false)
+	err := s.executor.LoadImage("abcdef", "fa1afe1+10", strings.NewReader("image content"))
+	c.Assert(err, IsNil)
+	c.Check(s.executor.imageFilename, Equals, s.tmpdir+"/abcdef.sif")
+}
+
+func (s *singularitySuite) TestRun(c *C) {
+	err := s.executor.LoadImage("abcdef", "fa1afe1+10", strings.NewReader("image content"))
+	c.Assert(err, IsNil)
+	var stdout, stderr bytes.Buffer
+	// The fake singularity program doesn't strip the
+	// SINGULARITYENV_ prefix from environment variables.
+	err = s.executor.Create(containerSpec{
+		Command:    []string{"sh", "-c", "read x; echo $x $SINGULARITYENV_FROBIZ; echo oops >&2; exit 3"},
+		WorkingDir: "/bin",
+		VCPUs:      2,
+		RAM:        1 << 30,
+		Env:        []string{"FROBIZ=bilbo"},
+		Binds:      []string{"/host/a:/a", "/host/b:/b:ro"},
+		Stdin:      strings.NewReader("hello\n"),
+		Stdout:     &stdout,
+		Stderr:     &stderr,
+	})
+	c.Assert(err, IsNil)
+	err = s.executor.Start()
+	c.Assert(err, IsNil)
+	code, err := s.executor.Wait(context.Background())
+	c.Check(err, IsNil)
+	c.Check(code, Equals, 3)
+	c.Check(stdout.String(), Equals, "hello bilbo\n")
+	c.Check(stderr.String(), Equals, "oops\n")
+
+	args, err := ioutil.ReadFile(s.tmpdir + "/exec-args")
+	c.Check(err, IsNil)
+	c.Check(string(args), Matches, `--containall --cleanenv --pwd /bin --net --network=none --cpus=2 --memory=1073741824 --bind /host/a:/a --bind /host/b:/b:ro `+s.tmpdir+`/cache/fa1afe1\+10.sif sh -c .*\n`)
+}
+
+func (s *singularitySuite) TestEnableNetwork(c *C) {
+	err := s.executor.Create(containerSpec{
+		Command:       []string{"true"},
+		EnableNetwork: true,
+		Stdout:        ioutil.Discard,
+		Stderr:        ioutil.Discard,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.executor.Start(), IsNil)
+	code, err := s.executor.Wait(context.Background())
+	c.Check(err, IsNil)
+	c.Check(code, Equals, 0)
+	args, err := ioutil.ReadFile(s.tmpdir + "/exec-args")
+	c.Check(err, IsNil)
+	c.Check(string(args), Not(Matches), `(?s).*--net.*`)
+}
+
+func (s *singularitySuite) TestNetworkMode(c *C) {
+	err := s.executor.Create(containerSpec{
+		Command:       []string{"true"},
+		EnableNetwork: true,
+		NetworkMode:   "bridge",
+		Stdout:        ioutil.Discard,
+		Stderr:        ioutil.Discard,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.executor.Start(), IsNil)
+	_, err = s.executor.Wait(context.Background())
+	c.Check(err, IsNil)
+	args, err := ioutil.ReadFile(s.tmpdir + "/exec-args")
+	c.Check(err, IsNil)
+	c.Check(string(args), Matches, `.*--net --network=bridge .*\n`)
+}
+
+func (s *singularitySuite) TestUnprivileged(c *C) {
+	s.executor.privileged = false
+	err := s.executor.Create(containerSpec{
+		Command:     []string{"true"},
+		VCPUs:       2,
+		RAM:         1 << 30,
+		NetworkMode: "bridge",
+		Stdout:      ioutil.Discard,
+		Stderr:      ioutil.Discard,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.executor.Start(), IsNil)
+	code, err := s.executor.Wait(context.Background())
+	c.Check(err, IsNil)
+	c.Check(code, Equals, 0)
+	args, err := ioutil.ReadFile(s.tmpdir + "/exec-args")
+	c.Check(err, IsNil)
+	c.Check(string(args), Not(Matches), `(?s).*--(net|cpus|memory).*`)
+}
+
+func (s *singularitySuite) TestStop(c *C) {
+	c.Check(s.executor.Stop(), IsNil)
+	err := s.executor.Create(containerSpec{
+		Command: []string{"sleep", "10"},
+		Stdout:  ioutil.Discard,
+		Stderr:  ioutil.Discard,
+	})
+	c.Assert(err, IsNil)
+	c.Check(s.executor.Stop(), IsNil)
+	c.Assert(s.executor.Start(), IsNil)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		s.executor.Stop()
+	}()
+	t0 := time.Now()
+	code, err := s.executor.Wait(context.Background())
+	c.Check(err, IsNil)
+	c.Check(code, Equals, 137)
+	c.Check(time.Since(t0) < 5*time.Second, Equals, true)
+}
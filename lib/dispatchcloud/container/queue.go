@@ -24,13 +24,22 @@ type APIClient interface {
 }
 
 // A QueueEnt is an entry in the queue, consisting of a container
-// record and the instance type that should be used to run it.
+// record, the instance type that should be used to run it, and
+// information about the container request that asked for it.
 type QueueEnt struct {
 	// The container to run. Only the UUID, State, Priority,
 	// RuntimeConstraints, Mounts, and ContainerImage fields are
 	// populated.
 	Container    arvados.Container    `json:"container"`
 	InstanceType arvados.InstanceType `json:"instance_type"`
+
+	// The highest-priority container request for this container,
+	// its owner (user or project), and the user who submitted it
+	// (i.e., last modified it). These are empty if no container
+	// request was found.
+	RequestUUID string `json:"request_uuid"`
+	OwnerUUID   string `json:"owner_uuid"`
+	UserUUID    string `json:"user_uuid"`
 }
 
 // String implements fmt.Stringer by returning the queued container's
@@ -57,10 +66,11 @@ type Queue struct {
 	chooseType typeChooser
 	client     APIClient
 
-	auth    *arvados.APIClientAuthorization
-	current map[string]QueueEnt
-	updated time.Time
-	mtx     sync.Mutex
+	auth      *arvados.APIClientAuthorization
+	current   map[string]QueueEnt
+	updated   time.Time
+	fetchReqs bool
+	mtx       sync.Mutex
 
 	// Methods that modify the Queue (like Lock) add the affected
 	// container UUIDs to dontupdate. When applying a batch of
@@ -171,6 +181,18 @@ func (cq *Queue) Entries() (entries map[string]QueueEnt, threshold time.Time) {
 	return
 }
 
+// SetFetchRequests determines whether Update looks up the container
+// request for each newly queued container, in order to populate the
+// RequestUUID, OwnerUUID, and UserUUID fields of its queue entry.
+// This costs additional API calls, so it should only be enabled when
+// a scheduling policy needs that information. It is disabled by
+// default.
+func (cq *Queue) SetFetchRequests(enable bool) {
+	cq.mtx.Lock()
+	defer cq.mtx.Unlock()
+	cq.fetchReqs = enable
+}
+
 // Update refreshes the cache from the Arvados API. It adds newly
 // queued containers, and updates the state of previously queued
 // containers.
@@ -178,12 +200,23 @@ func (cq *Queue) Update() error {
 	cq.mtx.Lock()
 	cq.dontupdate = map[string]struct{}{}
 	updateStarted := time.Now()
+	fetchReqs := cq.fetchReqs
 	cq.mtx.Unlock()
 
 	next, err := cq.poll()
 	if err != nil {
 		return err
 	}
+	var requests map[string]arvados.ContainerRequest
+	if fetchReqs {
+		requests, err = cq.fetchRequests(next)
+		if err != nil {
+			// Keep updating the containers we already
+			// have, but don't add new ones without their
+			// request info: try again on the next Update.
+			cq.logger.WithError(err).Warn("error fetching container requests, not adding new containers to queue")
+		}
+	}
 
 	cq.mtx.Lock()
 	defer cq.mtx.Unlock()
@@ -194,7 +227,10 @@ func (cq *Queue) Update() error {
 			continue
 		}
 		if cur, ok := cq.current[uuid]; !ok {
-			cq.addEnt(uuid, *ctr)
+			if fetchReqs && requests == nil {
+				continue
+			}
+			cq.addEnt(uuid, *ctr, requests[uuid])
 		} else {
 			cur.Container = *ctr
 			cq.current[uuid] = cur
@@ -229,7 +265,7 @@ func (cq *Queue) delEnt(uuid string, state arvados.ContainerState) {
 	delete(cq.current, uuid)
 }
 
-func (cq *Queue) addEnt(uuid string, ctr arvados.Container) {
+func (cq *Queue) addEnt(uuid string, ctr arvados.Container, cr arvados.ContainerRequest) {
 	it, err := cq.chooseType(&ctr)
 	if err != nil && (ctr.State == arvados.ContainerStateQueued || ctr.State == arvados.ContainerStateLocked) {
 		// We assume here that any chooseType error is a hard
@@ -283,8 +319,17 @@ func (cq *Queue) addEnt(uuid string, ctr arvados.Container) {
 		"State":         ctr.State,
 		"Priority":      ctr.Priority,
 		"InstanceType":  it.Name,
+		"RequestUUID":   cr.UUID,
+		"OwnerUUID":     cr.OwnerUUID,
+		"UserUUID":      cr.ModifiedByUserUUID,
 	}).Info("adding container to queue")
-	cq.current[uuid] = QueueEnt{Container: ctr, InstanceType: it}
+	cq.current[uuid] = QueueEnt{
+		Container:    ctr,
+		InstanceType: it,
+		RequestUUID:  cr.UUID,
+		OwnerUUID:    cr.OwnerUUID,
+		UserUUID:     cr.ModifiedByUserUUID,
+	}
 }
 
 // Lock acquires the dispatch lock for the given container.
@@ -469,6 +514,56 @@ func (cq *Queue) poll() (map[string]*arvados.Container, error) {
 	return next, nil
 }
 
+// fetchRequests returns the highest-priority container request for
+// each container in next that is not already in the queue. Only the
+// UUID, OwnerUUID, ModifiedByUserUUID, ContainerUUID, and Priority
+// fields are populated.
+func (cq *Queue) fetchRequests(next map[string]*arvados.Container) (map[string]arvados.ContainerRequest, error) {
+	var todo []string
+	cq.mtx.Lock()
+	for uuid := range next {
+		if _, ok := cq.current[uuid]; !ok {
+			todo = append(todo, uuid)
+		}
+	}
+	cq.mtx.Unlock()
+
+	requests := map[string]arvados.ContainerRequest{}
+	for len(todo) > 0 {
+		batch := todo
+		if len(batch) > 20 {
+			batch = batch[:20]
+		}
+		todo = todo[len(batch):]
+		params := arvados.ResourceListParams{
+			Select:  []string{"uuid", "owner_uuid", "modified_by_user_uuid", "container_uuid", "priority"},
+			Order:   "uuid",
+			Count:   "none",
+			Filters: []arvados.Filter{{Attr: "container_uuid", Operator: "in", Operand: batch}},
+		}
+		for {
+			var list arvados.ContainerRequestList
+			err := cq.client.RequestAndDecode(&list, "GET", "arvados/v1/container_requests", nil, params)
+			if err != nil {
+				return nil, err
+			}
+			if len(list.Items) == 0 {
+				break
+			}
+			for _, cr := range list.Items {
+				if prev, ok := requests[cr.ContainerUUID]; !ok || cr.Priority > prev.Priority {
+					requests[cr.ContainerUUID] = cr
+				}
+			}
+			params.Filters = []arvados.Filter{
+				{Attr: "container_uuid", Operator: "in", Operand: batch},
+				{Attr: "uuid", Operator: ">", Operand: list.Items[len(list.Items)-1].UUID},
+			}
+		}
+	}
+	return requests, nil
+}
+
 func (cq *Queue) fetchAll(initialParams arvados.ResourceListParams) ([]arvados.Container, error) {
 	var results []arvados.Container
 	params := initialParams
@@ -490,7 +585,7 @@ func (cq *Queue) fetchAll(initialParams arvados.ResourceListParams) ([]arvados.C
 
 		results = append(results, list.Items...)
 		if len(params.Order) == 1 && params.Order == "uuid" {
-			params.Filters = append(initialParams.Filters, arvados.Filter{"uuid", ">", list.Items[len(list.Items)-1].UUID})
+			params.Filters = append(initialParams.Filters, arvados.Filter{Attr: "uuid", Operator: ">", Operand: list.Items[len(list.Items)-1].UUID})
 		} else {
 			params.Offset += len(list.Items)
 		}
@@ -5,7 +5,9 @@
 package container
 
 import (
+	"encoding/json"
 	"errors"
+	"io"
 	"os"
 	"sync"
 	"testing"
@@ -46,6 +48,7 @@ func (suite *IntegrationSuite) TestGetLockUnlockCancel(c *check.C) {
 
 	client := arvados.NewClientFromEnv()
 	cq := NewQueue(logger(), nil, typeChooser, client)
+	cq.SetFetchRequests(true)
 
 	err := cq.Update()
 	c.Check(err, check.IsNil)
@@ -55,8 +58,11 @@ func (suite *IntegrationSuite) TestGetLockUnlockCancel(c *check.C) {
 	c.Check(time.Since(threshold) < time.Minute, check.Equals, true)
 	c.Check(time.Since(threshold) > 0, check.Equals, true)
 
-	_, ok := ents[arvadostest.QueuedContainerUUID]
+	ent, ok := ents[arvadostest.QueuedContainerUUID]
 	c.Check(ok, check.Equals, true)
+	c.Check(ent.OwnerUUID, check.Equals, arvadostest.ActiveUserUUID)
+	c.Check(ent.UserUUID, check.Equals, arvadostest.ActiveUserUUID)
+	c.Check(ent.RequestUUID, check.Matches, `zzzzz-xvhdp-.*`)
 
 	var wg sync.WaitGroup
 	for uuid, ent := range ents {
@@ -161,3 +167,73 @@ func (suite *IntegrationSuite) waitfor(c *check.C, timeout time.Duration, fn fun
 		time.Sleep(timeout / 1000)
 	}
 }
+
+var _ = check.Suite(&QueueSuite{})
+
+type QueueSuite struct{}
+
+// stubAPIClient serves a single queued container, and counts
+// container request lookups.
+type stubAPIClient struct {
+	requestErr error
+	mtx        sync.Mutex
+	requests   int
+}
+
+func (sc *stubAPIClient) RequestAndDecode(dst interface{}, method, path string, body io.Reader, params interface{}) error {
+	var resp interface{}
+	switch path {
+	case "arvados/v1/api_client_authorizations/current":
+		resp = arvados.APIClientAuthorization{UUID: "zzzzz-gj3su-000000000000000"}
+	case "arvados/v1/containers":
+		var items []arvados.Container
+		if lp, ok := params.(arvados.ResourceListParams); ok && lp.Offset == 0 && len(lp.Filters) == 2 && lp.Filters[0].Attr == "state" {
+			items = []arvados.Container{{UUID: "zzzzz-dz642-queuedcontainer", State: arvados.ContainerStateQueued, Priority: 1}}
+		}
+		resp = arvados.ContainerList{Items: items}
+	case "arvados/v1/container_requests":
+		sc.mtx.Lock()
+		sc.requests++
+		sc.mtx.Unlock()
+		if sc.requestErr != nil {
+			return sc.requestErr
+		}
+		resp = arvados.ContainerRequestList{}
+	default:
+		return errors.New("unexpected request: " + method + " " + path)
+	}
+	buf, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, dst)
+}
+
+func (s *QueueSuite) TestFetchRequests(c *check.C) {
+	typeChooser := func(ctr *arvados.Container) (arvados.InstanceType, error) {
+		return arvados.InstanceType{Name: "testType"}, nil
+	}
+
+	// Disabled (default): no container request lookups.
+	client := &stubAPIClient{}
+	cq := NewQueue(logger(), nil, typeChooser, client)
+	c.Check(cq.Update(), check.IsNil)
+	ents, _ := cq.Entries()
+	c.Check(ents, check.HasLen, 1)
+	c.Check(client.requests, check.Equals, 0)
+
+	// Enabled, but lookup fails: Update succeeds, and the new
+	// container is added on a later Update.
+	client = &stubAPIClient{requestErr: errors.New("test error")}
+	cq = NewQueue(logger(), nil, typeChooser, client)
+	cq.SetFetchRequests(true)
+	c.Check(cq.Update(), check.IsNil)
+	ents, _ = cq.Entries()
+	c.Check(ents, check.HasLen, 0)
+	c.Check(client.requests, check.Equals, 1)
+	client.requestErr = nil
+	c.Check(cq.Update(), check.IsNil)
+	ents, _ = cq.Entries()
+	c.Check(ents, check.HasLen, 1)
+	c.Check(client.requests, check.Equals, 2)
+}
@@ -31,8 +31,9 @@ import (
 )
 
 const (
-	defaultPollInterval     = time.Second
-	defaultStaleLockTimeout = time.Minute
+	defaultPollInterval      = time.Second
+	defaultStaleLockTimeout  = time.Minute
+	defaultFairShareHalfLife = time.Hour
 )
 
 type pool interface {
@@ -59,6 +60,9 @@ type dispatcher struct {
 	httpHandler http.Handler
 	sshKey      ssh.Signer
 
+	sched    *scheduler.Scheduler // nil until run() starts it
+	schedMtx sync.Mutex
+
 	setupOnce sync.Once
 	stop      chan struct{}
 	stopped   chan struct{}
@@ -154,6 +158,7 @@ func (disp *dispatcher) initialize() {
 		mux := httprouter.New()
 		mux.HandlerFunc("GET", "/arvados/v1/dispatch/containers", disp.apiContainers)
 		mux.HandlerFunc("POST", "/arvados/v1/dispatch/containers/kill", disp.apiContainerKill)
+		mux.HandlerFunc("GET", "/arvados/v1/dispatch/fairshare", disp.apiFairShare)
 		mux.HandlerFunc("GET", "/arvados/v1/dispatch/instances", disp.apiInstances)
 		mux.HandlerFunc("POST", "/arvados/v1/dispatch/instances/hold", disp.apiInstanceHold)
 		mux.HandlerFunc("POST", "/arvados/v1/dispatch/instances/drain", disp.apiInstanceDrain)
@@ -181,7 +186,37 @@ func (disp *dispatcher) run() {
 	if pollInterval <= 0 {
 		pollInterval = defaultPollInterval
 	}
-	sched := scheduler.New(disp.Context, disp.queue, disp.pool, staleLockTimeout, pollInterval)
+	fallback := scheduler.FallbackPolicy{
+		MaxAttempts: disp.Cluster.Containers.PreemptibleFallbackAttempts,
+		MaxWait:     time.Duration(disp.Cluster.Containers.PreemptibleFallbackDelay),
+	}
+	for _, it := range disp.Cluster.InstanceTypes {
+		if it.Preemptible {
+			// Fallback is only relevant if some
+			// containers can run on preemptible
+			// instances.
+			fallback.ChooseType = func(ctr *arvados.Container) (arvados.InstanceType, error) {
+				return ChooseFallbackInstanceType(disp.Cluster, ctr)
+			}
+			break
+		}
+	}
+	sched := scheduler.New(disp.Context, disp.queue, disp.pool, staleLockTimeout, pollInterval, fallback, disp.fairSharePolicy(), scheduler.QuotaPolicy{
+		PerUser:    disp.Cluster.Containers.Quotas.PerUser,
+		PerProject: disp.Cluster.Containers.Quotas.PerProject,
+		Overrides:  disp.Cluster.Containers.Quotas.Overrides,
+	}, scheduler.PackingPolicy{
+		MaxContainers: disp.Cluster.Containers.CloudVMs.MaxContainersPerInstance,
+		ChooseType: func(it arvados.InstanceType, n int) (arvados.InstanceType, bool) {
+			return ChoosePackingInstanceType(disp.Cluster, it, n)
+		},
+	})
+	if cq, ok := disp.queue.(*container.Queue); ok {
+		cq.SetFetchRequests(sched.NeedRequests())
+	}
+	disp.schedMtx.Lock()
+	disp.sched = sched
+	disp.schedMtx.Unlock()
 	sched.Start()
 	defer sched.Stop()
 
@@ -190,12 +225,59 @@ func (disp *dispatcher) run() {
 
 // Management API: all active and queued containers.
 func (disp *dispatcher) apiContainers(w http.ResponseWriter, r *http.Request) {
+	type entry struct {
+		container.QueueEnt
+		SchedulingStatus string `json:"scheduling_status,omitempty"`
+	}
 	var resp struct {
-		Items []container.QueueEnt `json:"items"`
+		Items []entry `json:"items"`
+	}
+	var status map[string]string
+	sched := disp.scheduler()
+	if sched != nil {
+		status = sched.SchedulingStatus()
 	}
 	qEntries, _ := disp.queue.Entries()
-	for _, ent := range qEntries {
-		resp.Items = append(resp.Items, ent)
+	for uuid, ent := range qEntries {
+		resp.Items = append(resp.Items, entry{QueueEnt: ent, SchedulingStatus: status[uuid]})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Return the running scheduler, or nil if run() hasn't started it
+// yet.
+func (disp *dispatcher) scheduler() *scheduler.Scheduler {
+	disp.schedMtx.Lock()
+	defer disp.schedMtx.Unlock()
+	return disp.sched
+}
+
+func (disp *dispatcher) fairSharePolicy() scheduler.FairSharePolicy {
+	fs := disp.Cluster.Containers.FairShare
+	halfLife := time.Duration(fs.UsageHalfLife)
+	if halfLife <= 0 {
+		halfLife = defaultFairShareHalfLife
+	}
+	return scheduler.FairSharePolicy{
+		Enable:       fs.Enable,
+		HalfLife:     halfLife,
+		DefaultShare: fs.DefaultShare,
+		Shares:       fs.Shares,
+	}
+}
+
+// Management API: current share, usage, and load of each container
+// owner (empty if fair-share scheduling is disabled).
+func (disp *dispatcher) apiFairShare(w http.ResponseWriter, r *http.Request) {
+	var resp struct {
+		Items []scheduler.FairShare `json:"items"`
+	}
+	sched := disp.scheduler()
+	if sched != nil {
+		resp.Items = sched.FairShares()
+	}
+	if resp.Items == nil {
+		resp.Items = []scheduler.FairShare{}
 	}
 	json.NewEncoder(w).Encode(resp)
 }
@@ -7,6 +7,7 @@ package dispatchcloud
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
@@ -211,6 +212,76 @@ func (s *DispatcherSuite) TestDispatchToStubDriver(c *check.C) {
 	c.Check(resp.Body.String(), check.Matches, `(?ms).*instances_disappeared{state="unknown"} 0\n.*`)
 }
 
+// If the cloud provider fails to create preemptible instances, a
+// preemptible container falls back to a non-preemptible instance
+// after PreemptibleFallbackAttempts failed create calls.
+func (s *DispatcherSuite) TestPreemptibleFallbackAfterCreateErrors(c *check.C) {
+	preemptible := test.InstanceType(2)
+	preemptible.Name += ".preemptible"
+	preemptible.ProviderType += ".preemptible"
+	preemptible.Preemptible = true
+	preemptible.Price /= 3
+	s.cluster.InstanceTypes[preemptible.Name] = preemptible
+	s.cluster.Containers.PreemptibleFallbackAttempts = 2
+
+	var mtx sync.Mutex
+	createErrors := 0
+	var created []string
+	s.stubDriver.CreateError = func(it arvados.InstanceType) error {
+		if !it.Preemptible {
+			return nil
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		createErrors++
+		return errors.New("no spot capacity")
+	}
+	Drivers["test"] = s.stubDriver
+	s.disp.setupOnce.Do(s.disp.initialize)
+	queue := &test.Queue{
+		ChooseType: func(ctr *arvados.Container) (arvados.InstanceType, error) {
+			return ChooseInstanceType(s.cluster, ctr)
+		},
+		Containers: []arvados.Container{{
+			UUID:     test.ContainerUUID(1),
+			State:    arvados.ContainerStateQueued,
+			Priority: 1,
+			RuntimeConstraints: arvados.RuntimeConstraints{
+				RAM:   1 << 30,
+				VCPUs: 2,
+			},
+			SchedulingParameters: arvados.SchedulingParameters{
+				Preemptible: true,
+			},
+		}},
+	}
+	s.disp.queue = queue
+
+	done := make(chan struct{})
+	s.stubDriver.Queue = queue
+	s.stubDriver.SetupVM = func(stubvm *test.StubVM) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		created = append(created, stubvm.Instance().ProviderType())
+		stubvm.Boot = time.Now()
+		stubvm.ExecuteContainer = func(ctr arvados.Container) int {
+			close(done)
+			return 0
+		}
+	}
+
+	go s.disp.run()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		c.Fatal("timed out waiting for container to run")
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	c.Check(createErrors, check.Equals, 2)
+	c.Check(created, check.DeepEquals, []string{test.InstanceType(2).ProviderType})
+}
+
 func (s *DispatcherSuite) TestAPIPermissions(c *check.C) {
 	s.cluster.ManagementToken = "abcdefgh"
 	Drivers["test"] = s.stubDriver
@@ -251,6 +322,63 @@ func (s *DispatcherSuite) TestAPIDisabled(c *check.C) {
 	}
 }
 
+func (s *DispatcherSuite) TestFairShareAPI(c *check.C) {
+	s.cluster.ManagementToken = "abcdefgh"
+	s.cluster.Containers.FairShare.Enable = true
+	s.cluster.Containers.FairShare.Shares = map[string]float64{"zzzzz-j7d0g-000000000000001": 2}
+	Drivers["test"] = s.stubDriver
+	s.disp.setupOnce.Do(s.disp.initialize)
+	queue := &test.Queue{
+		ChooseType: func(ctr *arvados.Container) (arvados.InstanceType, error) {
+			return ChooseInstanceType(s.cluster, ctr)
+		},
+		Owners: map[string]string{
+			test.ContainerUUID(1): "zzzzz-j7d0g-000000000000001",
+			test.ContainerUUID(2): "zzzzz-tpzed-000000000000002",
+		},
+	}
+	for i := 1; i <= 2; i++ {
+		queue.Containers = append(queue.Containers, arvados.Container{
+			UUID:     test.ContainerUUID(i),
+			State:    arvados.ContainerStateQueued,
+			Priority: 1,
+			RuntimeConstraints: arvados.RuntimeConstraints{
+				RAM:   1 << 30,
+				VCPUs: 1,
+			},
+		})
+	}
+	queue.Update()
+	s.disp.queue = queue
+	go s.disp.run()
+
+	type fairShareResponse struct {
+		Items []struct {
+			OwnerUUID string  `json:"owner_uuid"`
+			Share     float64 `json:"share"`
+		}
+	}
+	var sr fairShareResponse
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline) && len(sr.Items) < 2; time.Sleep(time.Millisecond) {
+		req := httptest.NewRequest("GET", "/arvados/v1/dispatch/fairshare", nil)
+		req.Header.Set("Authorization", "Bearer abcdefgh")
+		resp := httptest.NewRecorder()
+		s.disp.ServeHTTP(resp, req)
+		c.Assert(resp.Code, check.Equals, http.StatusOK)
+		err := json.Unmarshal(resp.Body.Bytes(), &sr)
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(sr.Items, check.HasLen, 2)
+	shares := map[string]float64{}
+	for _, item := range sr.Items {
+		shares[item.OwnerUUID] = item.Share
+	}
+	c.Check(shares, check.DeepEquals, map[string]float64{
+		"zzzzz-j7d0g-000000000000001": 2,
+		"zzzzz-tpzed-000000000000002": 1,
+	})
+}
+
 func (s *DispatcherSuite) TestInstancesAPI(c *check.C) {
 	s.cluster.ManagementToken = "abcdefgh"
 	s.cluster.Containers.CloudVMs.TimeoutBooting = arvados.Duration(time.Second)
@@ -85,7 +85,52 @@ func EstimateScratchSpace(ctr *arvados.Container) (needScratch int64) {
 
 // ChooseInstanceType returns the cheapest available
 // arvados.InstanceType big enough to run ctr.
-func ChooseInstanceType(cc *arvados.Cluster, ctr *arvados.Container) (best arvados.InstanceType, err error) {
+//
+// If ctr can run on a preemptible instance, the cheapest suitable
+// preemptible type is chosen. If no preemptible type is big enough,
+// the cheapest suitable non-preemptible type is chosen instead.
+func ChooseInstanceType(cc *arvados.Cluster, ctr *arvados.Container) (arvados.InstanceType, error) {
+	if ctr.SchedulingParameters.Preemptible {
+		if best, err := chooseInstanceType(cc, ctr, true); err == nil {
+			return best, nil
+		}
+	}
+	return chooseInstanceType(cc, ctr, false)
+}
+
+// ChooseFallbackInstanceType returns the cheapest available
+// non-preemptible arvados.InstanceType big enough to run ctr,
+// regardless of whether ctr can run on a preemptible instance. The
+// scheduler uses it when a preemptible container has failed to start
+// on preemptible instances too many times.
+func ChooseFallbackInstanceType(cc *arvados.Cluster, ctr *arvados.Container) (arvados.InstanceType, error) {
+	return chooseInstanceType(cc, ctr, false)
+}
+
+// ChoosePackingInstanceType returns the cheapest available
+// arvados.InstanceType with enough VCPUs, RAM, and scratch space to
+// run n containers at once, each of which needs the resources of
+// instance type it. The result is preemptible if and only if it is
+// preemptible.
+//
+// ok is false if no configured instance type is big enough.
+func ChoosePackingInstanceType(cc *arvados.Cluster, it arvados.InstanceType, n int) (best arvados.InstanceType, ok bool) {
+	for _, t := range cc.InstanceTypes {
+		switch {
+		case ok && t.Price > best.Price:
+		case t.VCPUs < it.VCPUs*n:
+		case t.RAM < it.RAM*arvados.ByteSize(n):
+		case t.Scratch < it.Scratch*arvados.ByteSize(n):
+		case t.Preemptible != it.Preemptible:
+		case ok && t.Price == best.Price && (t.RAM < best.RAM || t.VCPUs < best.VCPUs):
+		default:
+			best, ok = t, true
+		}
+	}
+	return
+}
+
+func chooseInstanceType(cc *arvados.Cluster, ctr *arvados.Container, preemptible bool) (best arvados.InstanceType, err error) {
 	if len(cc.InstanceTypes) == 0 {
 		err = ErrInstanceTypesNotConfigured
 		return
@@ -105,7 +150,7 @@ func ChooseInstanceType(cc *arvados.Cluster, ctr *arvados.Container) (best arvad
 		case int64(it.Scratch) < needScratch:
 		case int64(it.RAM) < needRAM:
 		case it.VCPUs < needVCPUs:
-		case it.Preemptible != ctr.SchedulingParameters.Preemptible:
+		case it.Preemptible != preemptible:
 		case it.Price == best.Price && (it.RAM < best.RAM || it.VCPUs < best.VCPUs):
 			// Equal price, but worse specs
 		default:
@@ -141,3 +141,68 @@ func (*NodeSizeSuite) TestScratchForDockerImage(c *check.C) {
 	// Short manifest will return 0
 	c.Check(n, check.Equals, int64(0))
 }
+
+func (*NodeSizeSuite) TestChoosePreemptibleWithoutPreemptibleTypes(c *check.C) {
+	menu := map[string]arvados.InstanceType{
+		"costly": {Price: 4.4, RAM: 4000000000, VCPUs: 8, Scratch: 2 * GiB, Name: "costly"},
+		"best":   {Price: 2.2, RAM: 2000000000, VCPUs: 4, Scratch: 2 * GiB, Name: "best"},
+		"small":  {Price: 1.1, RAM: 1000000000, VCPUs: 2, Scratch: 2 * GiB, Preemptible: true, Name: "small"},
+	}
+	best, err := ChooseInstanceType(&arvados.Cluster{InstanceTypes: menu}, &arvados.Container{
+		RuntimeConstraints: arvados.RuntimeConstraints{
+			VCPUs: 4,
+			RAM:   1500000000,
+		},
+		SchedulingParameters: arvados.SchedulingParameters{
+			Preemptible: true,
+		},
+	})
+	c.Check(err, check.IsNil)
+	c.Check(best.Name, check.Equals, "best")
+	c.Check(best.Preemptible, check.Equals, false)
+}
+
+func (*NodeSizeSuite) TestChooseFallback(c *check.C) {
+	menu := map[string]arvados.InstanceType{
+		"costly":      {Price: 4.4, RAM: 4000000000, VCPUs: 8, Scratch: 2 * GiB, Name: "costly"},
+		"on demand":   {Price: 2.2, RAM: 2000000000, VCPUs: 4, Scratch: 2 * GiB, Name: "on demand"},
+		"preemptible": {Price: 1.1, RAM: 2000000000, VCPUs: 4, Scratch: 2 * GiB, Preemptible: true, Name: "preemptible"},
+	}
+	ctr := &arvados.Container{
+		RuntimeConstraints: arvados.RuntimeConstraints{
+			VCPUs: 2,
+			RAM:   1000000000,
+		},
+		SchedulingParameters: arvados.SchedulingParameters{
+			Preemptible: true,
+		},
+	}
+	best, err := ChooseInstanceType(&arvados.Cluster{InstanceTypes: menu}, ctr)
+	c.Check(err, check.IsNil)
+	c.Check(best.Name, check.Equals, "preemptible")
+
+	best, err = ChooseFallbackInstanceType(&arvados.Cluster{InstanceTypes: menu}, ctr)
+	c.Check(err, check.IsNil)
+	c.Check(best.Name, check.Equals, "on demand")
+
+	ctr.RuntimeConstraints.VCPUs = 16
+	_, err = ChooseFallbackInstanceType(&arvados.Cluster{InstanceTypes: menu}, ctr)
+	c.Check(err, check.FitsTypeOf, ConstraintsNotSatisfiableError{})
+}
+
+func (*NodeSizeSuite) TestChoosePacking(c *check.C) {
+	menu := map[string]arvados.InstanceType{
+		"small":       {Price: 1.0, RAM: 2 * GiB, VCPUs: 1, Scratch: 10 * GiB, Name: "small"},
+		"medium":      {Price: 3.0, RAM: 8 * GiB, VCPUs: 4, Scratch: 40 * GiB, Name: "medium"},
+		"large":       {Price: 5.0, RAM: 16 * GiB, VCPUs: 8, Scratch: 80 * GiB, Name: "large"},
+		"preemptible": {Price: 0.5, RAM: 16 * GiB, VCPUs: 8, Scratch: 80 * GiB, Preemptible: true, Name: "preemptible"},
+	}
+	cc := &arvados.Cluster{InstanceTypes: menu}
+	for n, expect := range map[int]string{1: "small", 2: "medium", 4: "medium", 5: "large", 8: "large"} {
+		best, ok := ChoosePackingInstanceType(cc, menu["small"], n)
+		c.Check(ok, check.Equals, true)
+		c.Check(best.Name, check.Equals, expect, check.Commentf("n=%d", n))
+	}
+	_, ok := ChoosePackingInstanceType(cc, menu["small"], 9)
+	c.Check(ok, check.Equals, false)
+}
@@ -0,0 +1,203 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package scheduler
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/dispatchcloud/container"
+)
+
+// A FairSharePolicy determines the order in which the scheduler
+// considers queued containers.
+//
+// When enabled, cluster capacity is divided among container owners
+// (see container.QueueEnt.OwnerUUID) in proportion to their shares,
+// taking into account each owner's recent resource usage. When
+// disabled (the zero value), containers are considered in priority
+// order.
+type FairSharePolicy struct {
+	Enable bool
+
+	// Usage is discounted by half after this interval. Must be
+	// positive if Enable is true.
+	HalfLife time.Duration
+
+	// Share for owners not listed in Shares. Zero is treated as
+	// 1.
+	DefaultShare float64
+
+	// Share for specific owners.
+	Shares map[string]float64
+}
+
+func (fsp FairSharePolicy) share(owner string) float64 {
+	if share, ok := fsp.Shares[owner]; ok && share > 0 {
+		return share
+	}
+	if fsp.DefaultShare > 0 {
+		return fsp.DefaultShare
+	}
+	return 1
+}
+
+// charge returns the usage that a container using the given number
+// of VCPUs will eventually accumulate, if it keeps running
+// indefinitely. (The decayed sum of a constant load L is
+// L*HalfLife/ln(2).)
+func (fsp FairSharePolicy) charge(vcpus int) float64 {
+	return float64(vcpus) * fsp.HalfLife.Seconds() / math.Ln2
+}
+
+// FairShare is a snapshot of an owner's share and usage, as reported
+// by (*Scheduler)FairShares.
+type FairShare struct {
+	OwnerUUID         string  `json:"owner_uuid"`
+	Share             float64 `json:"share"`
+	Usage             float64 `json:"usage"`
+	Load              float64 `json:"load"`
+	RunningContainers int     `json:"running_containers"`
+	RunningVCPUs      int     `json:"running_vcpus"`
+	QueuedContainers  int     `json:"queued_containers"`
+}
+
+// FairShares returns the share, recent usage, and current load of
+// each owner that has queued or running containers or recent usage,
+// as of the last scheduling iteration. Usage is measured in
+// (discounted) VCPU-seconds; load is usage plus the charge for
+// currently running containers, divided by share. Owners with the
+// lowest load are served first.
+//
+// The result is empty if fair-share scheduling is not enabled.
+func (sch *Scheduler) FairShares() []FairShare {
+	sch.mtx.Lock()
+	defer sch.mtx.Unlock()
+	return append([]FairShare(nil), sch.fairShares...)
+}
+
+// updateUsage adds usage by running containers since the last
+// update, and discounts previously recorded usage according to the
+// half-life.
+func (sch *Scheduler) updateUsage(now time.Time, entries map[string]container.QueueEnt, running map[string]time.Time) {
+	if !sch.usageUpdated.IsZero() {
+		elapsed := now.Sub(sch.usageUpdated).Seconds()
+		decay := math.Exp2(-elapsed / sch.fairShare.HalfLife.Seconds())
+		for owner, usage := range sch.usage {
+			if usage *= decay; usage < 1 {
+				delete(sch.usage, owner)
+			} else {
+				sch.usage[owner] = usage
+			}
+		}
+		for uuid, exited := range running {
+			ent, ok := entries[uuid]
+			if !ok || !exited.IsZero() {
+				continue
+			}
+			sch.usage[ent.OwnerUUID] += float64(ent.InstanceType.VCPUs) * elapsed
+		}
+	}
+	sch.usageUpdated = now
+}
+
+// fairShareOrder returns the given queue entries (which must already
+// be sorted by priority) in the order they should be considered for
+// scheduling.
+//
+// Each owner's entries remain in priority order. At each step, the
+// next entry is taken from the owner with the lowest load (usage
+// plus charges for running containers and entries taken so far,
+// divided by share). Ties go to the higher-priority entry.
+func (sch *Scheduler) fairShareOrder(sorted []container.QueueEnt, entries map[string]container.QueueEnt, running map[string]time.Time) []container.QueueEnt {
+	sch.updateUsage(time.Now(), entries, running)
+
+	stats := map[string]*FairShare{}
+	stat := func(owner string) *FairShare {
+		fs, ok := stats[owner]
+		if !ok {
+			fs = &FairShare{
+				OwnerUUID: owner,
+				Share:     sch.fairShare.share(owner),
+				Usage:     sch.usage[owner],
+			}
+			stats[owner] = fs
+		}
+		return fs
+	}
+	for owner := range sch.usage {
+		stat(owner)
+	}
+
+	// Per-owner queues of entries waiting to be scheduled.
+	waiting := map[string][]container.QueueEnt{}
+	var ordered []container.QueueEnt
+	for _, ent := range sorted {
+		fs := stat(ent.OwnerUUID)
+		if exited, ok := running[ent.Container.UUID]; ok {
+			if exited.IsZero() {
+				fs.RunningContainers++
+				fs.RunningVCPUs += ent.InstanceType.VCPUs
+			}
+			// Order doesn't matter: runQueue skips
+			// running containers.
+			ordered = append(ordered, ent)
+			continue
+		}
+		fs.QueuedContainers++
+		waiting[ent.OwnerUUID] = append(waiting[ent.OwnerUUID], ent)
+	}
+	load := map[string]float64{}
+	for owner, fs := range stats {
+		load[owner] = fs.Usage + sch.fairShare.charge(fs.RunningVCPUs)
+		fs.Load = load[owner] / fs.Share
+	}
+
+	for len(waiting) > 0 {
+		var next string
+		found := false
+		for owner, ents := range waiting {
+			if !found {
+				next, found = owner, true
+				continue
+			}
+			l, nl := load[owner]/stats[owner].Share, load[next]/stats[next].Share
+			switch {
+			case l < nl:
+			case l > nl:
+				continue
+			case ents[0].Container.Priority < waiting[next][0].Container.Priority:
+				continue
+			case ents[0].Container.Priority == waiting[next][0].Container.Priority && owner > next:
+				continue
+			}
+			next = owner
+		}
+		ent := waiting[next][0]
+		ordered = append(ordered, ent)
+		load[next] += sch.fairShare.charge(ent.InstanceType.VCPUs)
+		if len(waiting[next]) == 1 {
+			delete(waiting, next)
+		} else {
+			waiting[next] = waiting[next][1:]
+		}
+	}
+
+	snapshot := make([]FairShare, 0, len(stats))
+	for _, fs := range stats {
+		snapshot = append(snapshot, *fs)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		if snapshot[i].Load != snapshot[j].Load {
+			return snapshot[i].Load < snapshot[j].Load
+		}
+		return snapshot[i].OwnerUUID < snapshot[j].OwnerUUID
+	})
+	sch.mtx.Lock()
+	sch.fairShares = snapshot
+	sch.mtx.Unlock()
+	return ordered
+}
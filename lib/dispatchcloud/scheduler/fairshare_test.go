@@ -0,0 +1,151 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/dispatchcloud/test"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&FairShareSuite{})
+
+type FairShareSuite struct {
+	queue test.Queue
+	pool  stubPool
+}
+
+const (
+	ownerA = "zzzzz-tpzed-aaaaaaaaaaaaaaa"
+	ownerB = "zzzzz-j7d0g-bbbbbbbbbbbbbbb"
+)
+
+// Owner A has 4 high-priority containers; owner B has 2
+// low-priority containers. There are 3 idle workers, and no more can
+// be created.
+func (s *FairShareSuite) SetUpTest(c *check.C) {
+	s.queue = test.Queue{
+		ChooseType: chooseType,
+		Owners:     map[string]string{},
+	}
+	for i := 1; i <= 6; i++ {
+		owner, priority := ownerA, int64(100+i)
+		if i > 4 {
+			owner, priority = ownerB, int64(i)
+		}
+		s.queue.Containers = append(s.queue.Containers, arvados.Container{
+			UUID:     test.ContainerUUID(i),
+			Priority: priority,
+			State:    arvados.ContainerStateLocked,
+			RuntimeConstraints: arvados.RuntimeConstraints{
+				VCPUs: 1,
+				RAM:   1 << 30,
+			},
+		})
+		s.queue.Owners[test.ContainerUUID(i)] = owner
+	}
+	s.queue.Update()
+	s.pool = stubPool{
+		atQuota: true,
+		unalloc: map[arvados.InstanceType]int{
+			test.InstanceType(1): 3,
+		},
+		idle: map[arvados.InstanceType]int{
+			test.InstanceType(1): 3,
+		},
+		running: map[string]time.Time{},
+	}
+}
+
+func (s *FairShareSuite) scheduler(c *check.C, fsp FairSharePolicy) *Scheduler {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	return New(ctx, &s.queue, &s.pool, time.Millisecond, time.Millisecond, FallbackPolicy{}, fsp, QuotaPolicy{}, PackingPolicy{})
+}
+
+func (s *FairShareSuite) TestDisabled(c *check.C) {
+	sch := s.scheduler(c, FairSharePolicy{})
+	sch.runQueue()
+	c.Check(s.pool.starts, check.DeepEquals, []string{uuids[4], uuids[3], uuids[2]})
+	c.Check(sch.FairShares(), check.HasLen, 0)
+}
+
+func (s *FairShareSuite) TestEqualShares(c *check.C) {
+	sch := s.scheduler(c, FairSharePolicy{Enable: true, HalfLife: time.Hour})
+	sch.runQueue()
+	c.Check(s.pool.starts, check.DeepEquals, []string{uuids[4], uuids[6], uuids[3]})
+}
+
+func (s *FairShareSuite) TestUnequalShares(c *check.C) {
+	sch := s.scheduler(c, FairSharePolicy{
+		Enable:   true,
+		HalfLife: time.Hour,
+		Shares:   map[string]float64{ownerB: 2},
+	})
+	sch.runQueue()
+	c.Check(s.pool.starts, check.DeepEquals, []string{uuids[4], uuids[6], uuids[5]})
+
+	shares := map[string]FairShare{}
+	for _, fs := range sch.FairShares() {
+		shares[fs.OwnerUUID] = fs
+	}
+	c.Check(shares, check.HasLen, 2)
+	c.Check(shares[ownerA].Share, check.Equals, 1.0)
+	c.Check(shares[ownerA].QueuedContainers, check.Equals, 4)
+	c.Check(shares[ownerB].Share, check.Equals, 2.0)
+	c.Check(shares[ownerB].QueuedContainers, check.Equals, 2)
+}
+
+// Containers that are already running, and usage accumulated while
+// running, count against their owner's share.
+func (s *FairShareSuite) TestUsage(c *check.C) {
+	s.queue.Containers = append(s.queue.Containers, arvados.Container{
+		UUID:     test.ContainerUUID(7),
+		Priority: 1,
+		State:    arvados.ContainerStateRunning,
+		RuntimeConstraints: arvados.RuntimeConstraints{
+			VCPUs: 1,
+			RAM:   1 << 30,
+		},
+	})
+	s.queue.Owners[test.ContainerUUID(7)] = ownerA
+	s.queue.Update()
+	s.pool.running[test.ContainerUUID(7)] = time.Time{}
+
+	sch := s.scheduler(c, FairSharePolicy{Enable: true, HalfLife: time.Hour})
+	sch.runQueue()
+	c.Check(s.pool.starts, check.DeepEquals, []string{uuids[6], uuids[4], uuids[5]})
+
+	shares := sch.FairShares()
+	c.Assert(shares, check.HasLen, 2)
+	c.Check(shares[1].OwnerUUID, check.Equals, ownerA)
+	c.Check(shares[1].RunningContainers, check.Equals, 1)
+	c.Check(shares[1].RunningVCPUs, check.Equals, 1)
+
+	// Pretend the last update was 10 minutes ago, and only
+	// ownerA's container has been running: it accumulates 600
+	// VCPU-seconds.
+	s.pool.running = map[string]time.Time{test.ContainerUUID(7): time.Time{}}
+	sch.usageUpdated = time.Now().Add(-10 * time.Minute)
+	sch.runQueue()
+	shares = sch.FairShares()
+	c.Assert(shares, check.HasLen, 2)
+	c.Check(shares[1].OwnerUUID, check.Equals, ownerA)
+	c.Check(shares[1].Usage > 599 && shares[1].Usage < 601, check.Equals, true, check.Commentf("usage %v", shares[1].Usage))
+}
+
+func (s *FairShareSuite) TestUsageDecay(c *check.C) {
+	sch := s.scheduler(c, FairSharePolicy{Enable: true, HalfLife: time.Hour})
+	sch.usage[ownerA] = 1000
+	sch.usage[ownerB] = 1.5
+	sch.usageUpdated = time.Now().Add(-time.Hour)
+	sch.updateUsage(time.Now(), nil, nil)
+	c.Check(sch.usage[ownerA] > 499 && sch.usage[ownerA] < 501, check.Equals, true, check.Commentf("usage %v", sch.usage[ownerA]))
+	_, ok := sch.usage[ownerB]
+	c.Check(ok, check.Equals, false)
+}
@@ -0,0 +1,215 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package scheduler
+
+import (
+	"time"
+
+	"git.arvados.org/arvados.git/lib/dispatchcloud/container"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/sirupsen/logrus"
+)
+
+// A FallbackPolicy determines when a container that was assigned a
+// preemptible instance type should be run on a non-preemptible
+// instance type instead.
+//
+// The zero value never falls back.
+type FallbackPolicy struct {
+	// Return the non-preemptible instance type to use for the
+	// given container. If nil, containers never fall back.
+	ChooseType func(*arvados.Container) (arvados.InstanceType, error)
+
+	// Fall back after this many failed attempts to run the
+	// container request's containers on preemptible instances:
+	// either the cloud provider failed to create an instance, or
+	// the instance went away before the container finished. Zero
+	// means no limit.
+	MaxAttempts int
+
+	// Fall back if the container has been waiting this long
+	// without being started. Zero means no limit.
+	MaxWait time.Duration
+}
+
+// forgetAttemptsAfter is how long the scheduler remembers attempts
+// for a container request after its last container has left the
+// queue. This allows for the delay between a container being
+// cancelled and its retry appearing in the queue.
+const forgetAttemptsAfter = 10 * time.Minute
+
+// Record of attempts to run a container request's containers on
+// preemptible instances.
+type attemptState struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+	attempts  int
+	// Non-nil once the scheduler has decided which instance type
+	// to fall back to.
+	fallback *arvados.InstanceType
+}
+
+// instanceType returns the instance type the scheduler should use
+// for the given queue entry. This is normally ent.InstanceType, but
+// if the container has failed to start on preemptible instances too
+// many times, or has been waiting too long, it is a non-preemptible
+// type chosen by the fallback policy.
+//
+// Attempts are counted per container request, so they carry over to
+// the new container that is created when a container is cancelled
+// (e.g., because its preemptible instance was reclaimed) and its
+// request is retried. Once a container falls back, its request's
+// subsequent containers also use the fallback type.
+func (sch *Scheduler) instanceType(ent container.QueueEnt) arvados.InstanceType {
+	if !ent.InstanceType.Preemptible || sch.fallback.ChooseType == nil {
+		return ent.InstanceType
+	}
+	sch.mtx.Lock()
+	defer sch.mtx.Unlock()
+	st := sch.attemptStateLocked(attemptKey(ent))
+	if st.fallback != nil {
+		return *st.fallback
+	}
+	var reason string
+	if max := sch.fallback.MaxAttempts; max > 0 && st.attempts >= max {
+		reason = "too many failed attempts"
+	} else if max := sch.fallback.MaxWait; max > 0 && time.Since(st.firstSeen) >= max {
+		reason = "waited too long"
+	} else {
+		return ent.InstanceType
+	}
+	logger := sch.logger.WithFields(logrus.Fields{
+		"ContainerUUID": ent.Container.UUID,
+		"RequestUUID":   ent.RequestUUID,
+		"InstanceType":  ent.InstanceType.Name,
+		"Attempts":      st.attempts,
+		"Waited":        time.Since(st.firstSeen).String(),
+	})
+	it, err := sch.fallback.ChooseType(&ent.Container)
+	if err != nil {
+		// Don't try again on every runQueue iteration.
+		logger.WithError(err).Warn("cannot fall back to non-preemptible instance type")
+		it = ent.InstanceType
+	} else {
+		logger.WithField("FallbackInstanceType", it.Name).Infof("falling back to non-preemptible instance type because %s", reason)
+	}
+	st.fallback = &it
+	return it
+}
+
+// addAttempt records a failed attempt to run the given queue entry's
+// container on the given (preemptible) instance type.
+func (sch *Scheduler) addAttempt(ent container.QueueEnt, it arvados.InstanceType) {
+	if !it.Preemptible {
+		return
+	}
+	sch.mtx.Lock()
+	defer sch.mtx.Unlock()
+	sch.addAttemptLocked(attemptKey(ent))
+}
+
+// Caller must have sch.mtx locked.
+func (sch *Scheduler) addAttemptLocked(key string) {
+	st := sch.attemptStateLocked(key)
+	if st.fallback == nil {
+		st.attempts++
+	}
+}
+
+// waitForCreate records that a new instance of type newType is being
+// created so the given queue entry's container can run on it. If
+// the create call fails, it counts as a failed attempt (see
+// checkCreateFailures).
+func (sch *Scheduler) waitForCreate(ent container.QueueEnt, it, newType arvados.InstanceType) {
+	if !it.Preemptible || sch.fallback.ChooseType == nil {
+		return
+	}
+	if sch.creatingFor[newType] == nil {
+		sch.creatingFor[newType] = map[string]bool{}
+	}
+	sch.creatingFor[newType][attemptKey(ent)] = true
+}
+
+// checkCreateFailures checks whether any create calls have failed
+// since the last runQueue, and records a failed attempt for each
+// container request that was waiting for an instance of the failed
+// type. Create calls that the pool refuses without trying (e.g.,
+// because of quota or rate limits) are not counted.
+//
+// Requests whose containers are no longer waiting (e.g., they are
+// running, or have left the queue) are forgotten.
+func (sch *Scheduler) checkCreateFailures(sorted []container.QueueEnt, running map[string]time.Time) {
+	if sch.fallback.ChooseType == nil {
+		return
+	}
+	waiting := map[string]bool{}
+	for _, ent := range sorted {
+		if _, ok := running[ent.Container.UUID]; !ok && ent.Container.State != arvados.ContainerStateRunning {
+			waiting[attemptKey(ent)] = true
+		}
+	}
+	failures := sch.pool.CreateFailures()
+	sch.mtx.Lock()
+	defer sch.mtx.Unlock()
+	for it, keys := range sch.creatingFor {
+		failed := failures[it] > sch.createFailures[it]
+		for key := range keys {
+			if !waiting[key] {
+				delete(keys, key)
+			} else if failed {
+				sch.logger.WithFields(logrus.Fields{
+					"RequestUUID":  key,
+					"InstanceType": it.Name,
+				}).Info("failed attempt to create preemptible instance")
+				sch.addAttemptLocked(key)
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(sch.creatingFor, it)
+		}
+	}
+	sch.createFailures = failures
+}
+
+// forgetAttempts discards the attempt records of container requests
+// that have had no containers in the given queue entries for
+// forgetAttemptsAfter.
+func (sch *Scheduler) forgetAttempts(qEntries map[string]container.QueueEnt) {
+	now := time.Now()
+	sch.mtx.Lock()
+	defer sch.mtx.Unlock()
+	for _, ent := range qEntries {
+		if st, ok := sch.attempts[attemptKey(ent)]; ok {
+			st.lastSeen = now
+		}
+	}
+	for key, st := range sch.attempts {
+		if now.Sub(st.lastSeen) > forgetAttemptsAfter {
+			delete(sch.attempts, key)
+		}
+	}
+}
+
+// attemptKey returns the key used to track attempts for the given
+// queue entry: the UUID of its container request, or (if no request
+// was found) its container UUID.
+func attemptKey(ent container.QueueEnt) string {
+	if ent.RequestUUID != "" {
+		return ent.RequestUUID
+	}
+	return ent.Container.UUID
+}
+
+// Caller must have sch.mtx locked.
+func (sch *Scheduler) attemptStateLocked(key string) *attemptState {
+	st, ok := sch.attempts[key]
+	if !ok {
+		now := time.Now()
+		st = &attemptState{firstSeen: now, lastSeen: now}
+		sch.attempts[key] = st
+	}
+	return st
+}
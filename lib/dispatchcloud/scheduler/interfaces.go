@@ -36,6 +36,7 @@ type WorkerPool interface {
 	CountWorkers() map[worker.State]int
 	AtQuota() bool
 	Create(arvados.InstanceType) bool
+	CreateFailures() map[arvados.InstanceType]int
 	Shutdown(arvados.InstanceType) bool
 	StartContainer(arvados.InstanceType, arvados.Container) bool
 	KillContainer(uuid, reason string) bool
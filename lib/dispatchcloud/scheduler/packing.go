@@ -0,0 +1,183 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package scheduler
+
+import (
+	"sort"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/dispatchcloud/container"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+)
+
+// A PackingPolicy allows the scheduler to run several containers on
+// a single worker.
+//
+// When packing is enabled, a container needs the resources (VCPUs,
+// RAM, and scratch space) of the instance type it would otherwise
+// run on, and can be mapped onto any unallocated worker with enough
+// room. When new workers are needed, the scheduler may create a
+// larger instance to host several waiting containers, if that costs
+// no more than creating one instance for each.
+//
+// The zero value disables packing.
+type PackingPolicy struct {
+	// Maximum number of containers per worker. 0 or 1 means no
+	// packing.
+	MaxContainers int
+
+	// ChooseType returns the cheapest instance type that can
+	// host n containers, each needing the resources of it. The
+	// second return value is false if there is no such type.
+	ChooseType func(it arvados.InstanceType, n int) (arvados.InstanceType, bool)
+}
+
+func (pp PackingPolicy) enabled() bool {
+	return pp.MaxContainers > 1
+}
+
+// slot is an unallocated (idle, booting, etc.) worker that
+// containers can be mapped onto during a single runQueue iteration.
+type slot struct {
+	it         arvados.InstanceType
+	containers int
+	vcpus      int
+	ram        arvados.ByteSize
+	scratch    arvados.ByteSize
+}
+
+func (s *slot) fits(it arvados.InstanceType, policy PackingPolicy) bool {
+	if s.containers == 0 && s.it == it {
+		return true
+	} else if !policy.enabled() || s.containers >= policy.MaxContainers {
+		return false
+	} else if s.it.Preemptible && !it.Preemptible {
+		return false
+	}
+	return s.vcpus+it.VCPUs <= s.it.VCPUs &&
+		s.ram+it.RAM <= s.it.RAM &&
+		s.scratch+it.Scratch <= s.it.Scratch
+}
+
+// slots tracks the capacity of unallocated workers during a single
+// runQueue iteration.
+//
+// Without packing, a container can only be mapped onto an
+// unallocated worker of its own instance type, and each worker hosts
+// at most one container.
+type slots struct {
+	policy PackingPolicy
+	slots  []*slot
+}
+
+func newSlots(policy PackingPolicy, unalloc map[arvados.InstanceType]int) *slots {
+	ss := &slots{policy: policy}
+	for it, n := range unalloc {
+		for i := 0; i < n; i++ {
+			ss.add(it)
+		}
+	}
+	// Make the choice of slots deterministic.
+	sort.Slice(ss.slots, func(i, j int) bool {
+		return ss.slots[i].it.Price < ss.slots[j].it.Price ||
+			ss.slots[i].it.Price == ss.slots[j].it.Price && ss.slots[i].it.Name < ss.slots[j].it.Name
+	})
+	return ss
+}
+
+// add adds a slot for a new worker of the given type.
+func (ss *slots) add(it arvados.InstanceType) {
+	ss.slots = append(ss.slots, &slot{it: it})
+}
+
+// take maps a container that needs the resources of the given type
+// onto a slot, and returns false if there is no room.
+//
+// Slots that already have containers mapped onto them are filled
+// first, then unused slots of the same type, then the cheapest
+// unused slot that has room.
+func (ss *slots) take(it arvados.InstanceType) bool {
+	var best *slot
+	rank := func(s *slot) int {
+		switch {
+		case s.containers > 0:
+			return 0
+		case s.it == it:
+			return 1
+		default:
+			return 2
+		}
+	}
+	for _, s := range ss.slots {
+		if s.fits(it, ss.policy) && (best == nil || rank(s) < rank(best)) {
+			best = s
+		}
+	}
+	if best == nil {
+		return false
+	}
+	best.containers++
+	best.vcpus += it.VCPUs
+	best.ram += it.RAM
+	best.scratch += it.Scratch
+	return true
+}
+
+// unused returns the instance types of slots that have no containers
+// mapped onto them.
+func (ss *slots) unused() map[arvados.InstanceType]bool {
+	unused := map[arvados.InstanceType]bool{}
+	for _, s := range ss.slots {
+		if s.containers == 0 {
+			unused[s.it] = true
+		}
+	}
+	return unused
+}
+
+// packingType returns the instance type to create for the given
+// entry, which needs a new worker of type it, considering the
+// entries waiting behind it. If packing is enabled and enough
+// entries of the same type are waiting, this is a larger type that
+// can host several of them at no greater cost than creating a worker
+// for each.
+func (sch *Scheduler) packingType(it arvados.InstanceType, waiting []container.QueueEnt, running map[string]time.Time) arvados.InstanceType {
+	if !sch.packing.enabled() || sch.packing.ChooseType == nil {
+		return it
+	}
+	n := 1
+	for _, ent := range waiting {
+		if n >= sch.packing.MaxContainers {
+			break
+		}
+		if _, running := running[ent.Container.UUID]; running || ent.Container.Priority < 1 {
+			continue
+		}
+		if (ent.Container.State == arvados.ContainerStateQueued || ent.Container.State == arvados.ContainerStateLocked) && sch.instanceType(ent) == it {
+			n++
+		}
+	}
+	for ; n > 1; n-- {
+		if big, ok := sch.packing.ChooseType(it, n); ok && big.Price <= it.Price*float64(n) {
+			return big
+		}
+	}
+	return it
+}
+
+// startPacked starts the given container on a worker that is already
+// running other containers, if packing is enabled and a worker has
+// room. Otherwise, it updates dontstart so runQueue doesn't try to
+// start other containers of the same type during this iteration.
+func (sch *Scheduler) startPacked(dontstart map[arvados.InstanceType]bool, it arvados.InstanceType, ctr arvados.Container) bool {
+	if !sch.packing.enabled() || dontstart[it] {
+		return false
+	}
+	if sch.pool.StartContainer(it, ctr) {
+		return true
+	}
+	dontstart[it] = true
+	return false
+}
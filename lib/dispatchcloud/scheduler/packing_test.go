@@ -0,0 +1,98 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/dispatchcloud/test"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&PackingSuite{})
+
+type PackingSuite struct{}
+
+func (*PackingSuite) queue(n int) *test.Queue {
+	queue := &test.Queue{ChooseType: chooseType}
+	for i := 1; i <= n; i++ {
+		queue.Containers = append(queue.Containers, arvados.Container{
+			UUID:     test.ContainerUUID(i),
+			Priority: int64(100 - i),
+			State:    arvados.ContainerStateLocked,
+			RuntimeConstraints: arvados.RuntimeConstraints{
+				VCPUs: 1,
+				RAM:   1 << 30,
+			},
+		})
+	}
+	queue.Update()
+	return queue
+}
+
+func (*PackingSuite) policy(max int) PackingPolicy {
+	return PackingPolicy{
+		MaxContainers: max,
+		ChooseType: func(it arvados.InstanceType, n int) (arvados.InstanceType, bool) {
+			if it.VCPUs*n > 8 {
+				return arvados.InstanceType{}, false
+			}
+			return test.InstanceType(it.VCPUs * n), true
+		},
+	}
+}
+
+// Create one instance big enough for several waiting containers,
+// instead of one instance for each.
+func (s *PackingSuite) TestCreateLargerInstance(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	queue := s.queue(6)
+	pool := stubPool{
+		unalloc:   map[arvados.InstanceType]int{},
+		idle:      map[arvados.InstanceType]int{},
+		running:   map[string]time.Time{},
+		canCreate: 10,
+	}
+	New(ctx, queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{}, FairSharePolicy{}, QuotaPolicy{}, s.policy(4)).runQueue()
+	c.Check(pool.creates, check.DeepEquals, []arvados.InstanceType{test.InstanceType(4), test.InstanceType(2)})
+	c.Check(pool.starts, check.DeepEquals, []string{test.ContainerUUID(1)})
+}
+
+// Map several containers onto an unallocated worker of a larger
+// type, and create new instances only for the rest.
+func (s *PackingSuite) TestUseLargerWorker(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	queue := s.queue(3)
+	pool := stubPool{
+		unalloc: map[arvados.InstanceType]int{
+			test.InstanceType(4): 1,
+		},
+		idle:      map[arvados.InstanceType]int{},
+		running:   map[string]time.Time{},
+		canCreate: 10,
+	}
+	New(ctx, queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{}, FairSharePolicy{}, QuotaPolicy{}, s.policy(2)).runQueue()
+	c.Check(pool.creates, check.DeepEquals, []arvados.InstanceType{test.InstanceType(1)})
+	c.Check(pool.shutdowns, check.Equals, 0)
+}
+
+// Without packing, a larger unallocated worker doesn't help.
+func (s *PackingSuite) TestDisabled(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	queue := s.queue(2)
+	pool := stubPool{
+		unalloc: map[arvados.InstanceType]int{
+			test.InstanceType(4): 1,
+		},
+		idle:      map[arvados.InstanceType]int{},
+		running:   map[string]time.Time{},
+		canCreate: 10,
+	}
+	New(ctx, queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{}, FairSharePolicy{}, QuotaPolicy{}, PackingPolicy{}).runQueue()
+	c.Check(pool.creates, check.DeepEquals, []arvados.InstanceType{test.InstanceType(1), test.InstanceType(1)})
+}
@@ -0,0 +1,134 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/dispatchcloud/container"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+)
+
+// A QuotaPolicy limits the number of containers, and the number of
+// VCPUs requested by containers, that can be running or starting at
+// once on behalf of any single user or project.
+//
+// The zero value has no limits.
+type QuotaPolicy struct {
+	// Limits for each user (container.QueueEnt.UserUUID).
+	PerUser arvados.ContainerQuota
+
+	// Limits for each project (container.QueueEnt.OwnerUUID, if
+	// it is a project).
+	PerProject arvados.ContainerQuota
+
+	// Limits for specific users and projects, replacing PerUser
+	// and PerProject.
+	Overrides map[string]arvados.ContainerQuota
+}
+
+// enabled returns true if the policy imposes any limits.
+func (qp QuotaPolicy) enabled() bool {
+	return qp.PerUser != (arvados.ContainerQuota{}) || qp.PerProject != (arvados.ContainerQuota{}) || len(qp.Overrides) > 0
+}
+
+func (qp QuotaPolicy) limit(uuid string, dflt arvados.ContainerQuota) arvados.ContainerQuota {
+	if q, ok := qp.Overrides[uuid]; ok {
+		return q
+	}
+	return dflt
+}
+
+type quotaUsage struct {
+	containers int
+	vcpus      int
+}
+
+// quotaTracker tracks the resources in use by each user and project
+// during a single runQueue iteration.
+type quotaTracker struct {
+	policy QuotaPolicy
+	usage  map[string]*quotaUsage
+}
+
+// newQuotaTracker returns a quotaTracker that accounts for all of
+// the given entries that are running, or locked (i.e., starting).
+func newQuotaTracker(policy QuotaPolicy, entries []container.QueueEnt, running map[string]time.Time) *quotaTracker {
+	qt := &quotaTracker{policy: policy, usage: map[string]*quotaUsage{}}
+	for _, ent := range entries {
+		exited, isRunning := running[ent.Container.UUID]
+		if isRunning && exited.IsZero() || !isRunning && ent.Container.State == arvados.ContainerStateLocked {
+			qt.add(ent)
+		}
+	}
+	return qt
+}
+
+// subjects returns the user and project (if any) whose quotas apply
+// to the given entry, along with their limits.
+func (qt *quotaTracker) subjects(ent container.QueueEnt) map[string]arvados.ContainerQuota {
+	subj := map[string]arvados.ContainerQuota{}
+	user := ent.UserUUID
+	if user == "" && isUserUUID(ent.OwnerUUID) {
+		user = ent.OwnerUUID
+	}
+	if user != "" {
+		subj[user] = qt.policy.limit(user, qt.policy.PerUser)
+	}
+	if ent.OwnerUUID != "" && !isUserUUID(ent.OwnerUUID) {
+		subj[ent.OwnerUUID] = qt.policy.limit(ent.OwnerUUID, qt.policy.PerProject)
+	}
+	return subj
+}
+
+// check returns a non-empty explanation if starting the given
+// entry would exceed its user's or project's quota.
+func (qt *quotaTracker) check(ent container.QueueEnt) string {
+	for uuid, limit := range qt.subjects(ent) {
+		usage := qt.usage[uuid]
+		if usage == nil {
+			usage = &quotaUsage{}
+		}
+		if limit.MaxContainers > 0 && usage.containers+1 > limit.MaxContainers {
+			return fmt.Sprintf("waiting for other containers to finish: %s has %d containers running (limit %d)", uuid, usage.containers, limit.MaxContainers)
+		}
+		if limit.MaxVCPUs > 0 && usage.vcpus+ent.Container.RuntimeConstraints.VCPUs > limit.MaxVCPUs {
+			return fmt.Sprintf("waiting for other containers to finish: %s has %d VCPUs in use (limit %d, container needs %d)", uuid, usage.vcpus, limit.MaxVCPUs, ent.Container.RuntimeConstraints.VCPUs)
+		}
+	}
+	return ""
+}
+
+// add records the given entry's resources as in use by its user and
+// project.
+func (qt *quotaTracker) add(ent container.QueueEnt) {
+	for uuid := range qt.subjects(ent) {
+		usage := qt.usage[uuid]
+		if usage == nil {
+			usage = &quotaUsage{}
+			qt.usage[uuid] = usage
+		}
+		usage.containers++
+		usage.vcpus += ent.Container.RuntimeConstraints.VCPUs
+	}
+}
+
+func isUserUUID(uuid string) bool {
+	return len(uuid) == 27 && uuid[6:11] == "tpzed"
+}
+
+// SchedulingStatus returns, for each queued container that the
+// scheduler is deliberately not starting (e.g., because of a quota),
+// an explanation of why. It reflects the last scheduling iteration.
+func (sch *Scheduler) SchedulingStatus() map[string]string {
+	sch.mtx.Lock()
+	defer sch.mtx.Unlock()
+	status := make(map[string]string, len(sch.schedulingStatus))
+	for uuid, reason := range sch.schedulingStatus {
+		status[uuid] = reason
+	}
+	return status
+}
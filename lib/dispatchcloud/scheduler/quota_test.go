@@ -0,0 +1,144 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"git.arvados.org/arvados.git/lib/dispatchcloud/test"
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&QuotaSuite{})
+
+type QuotaSuite struct{}
+
+const (
+	quotaUser1   = "zzzzz-tpzed-000000000000001"
+	quotaUser2   = "zzzzz-tpzed-000000000000002"
+	quotaProject = "zzzzz-j7d0g-000000000000001"
+)
+
+func (*QuotaSuite) queueEnt(i int, state arvados.ContainerState, vcpus int) arvados.Container {
+	return arvados.Container{
+		UUID:     test.ContainerUUID(i),
+		Priority: int64(100 - i),
+		State:    state,
+		RuntimeConstraints: arvados.RuntimeConstraints{
+			VCPUs: vcpus,
+			RAM:   1 << 30,
+		},
+	}
+}
+
+func (*QuotaSuite) waitLocked(c *check.C, queue *test.Queue, uuids ...string) {
+	deadline := time.Now().Add(time.Second)
+	for _, uuid := range uuids {
+		for {
+			ctr, _ := queue.Get(uuid)
+			if ctr.State == arvados.ContainerStateLocked {
+				break
+			} else if time.Now().After(deadline) {
+				c.Fatalf("timed out waiting for %s to be locked", uuid)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func (s *QuotaSuite) idlePool() stubPool {
+	return stubPool{
+		unalloc: map[arvados.InstanceType]int{
+			test.InstanceType(1): 10,
+			test.InstanceType(2): 10,
+		},
+		idle: map[arvados.InstanceType]int{
+			test.InstanceType(1): 10,
+			test.InstanceType(2): 10,
+		},
+		running: map[string]time.Time{},
+	}
+}
+
+// User1's third container stays queued, but doesn't prevent user2's
+// lower-priority container from being locked.
+func (s *QuotaSuite) TestPerUserContainers(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	queue := test.Queue{
+		ChooseType: chooseType,
+		Containers: []arvados.Container{
+			s.queueEnt(1, arvados.ContainerStateQueued, 1),
+			s.queueEnt(2, arvados.ContainerStateQueued, 1),
+			s.queueEnt(3, arvados.ContainerStateQueued, 1),
+			s.queueEnt(4, arvados.ContainerStateQueued, 1),
+		},
+		Users: map[string]string{
+			test.ContainerUUID(1): quotaUser1,
+			test.ContainerUUID(2): quotaUser1,
+			test.ContainerUUID(3): quotaUser1,
+			test.ContainerUUID(4): quotaUser2,
+		},
+	}
+	queue.Update()
+	pool := s.idlePool()
+	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{}, FairSharePolicy{}, QuotaPolicy{
+		PerUser: arvados.ContainerQuota{MaxContainers: 2},
+	}, PackingPolicy{})
+	sch.runQueue()
+	s.waitLocked(c, &queue, test.ContainerUUID(1), test.ContainerUUID(2), test.ContainerUUID(4))
+	ctr, _ := queue.Get(test.ContainerUUID(3))
+	c.Check(ctr.State, check.Equals, arvados.ContainerStateQueued)
+	status := sch.SchedulingStatus()
+	c.Check(status, check.HasLen, 1)
+	c.Check(status[test.ContainerUUID(3)], check.Matches, `.*`+quotaUser1+` has 2 containers running \(limit 2\)`)
+
+	// Once the locked containers are counted, they still
+	// prevent user1's third container from being locked.
+	queue.Update()
+	sch.runQueue()
+	ctr, _ = queue.Get(test.ContainerUUID(3))
+	c.Check(ctr.State, check.Equals, arvados.ContainerStateQueued)
+	c.Check(sch.SchedulingStatus(), check.HasLen, 1)
+}
+
+// A running container counts toward its project's VCPU limit.
+// Overrides replace the default per-project limits.
+func (s *QuotaSuite) TestPerProjectVCPUs(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	queue := test.Queue{
+		ChooseType: chooseType,
+		Containers: []arvados.Container{
+			s.queueEnt(1, arvados.ContainerStateRunning, 2),
+			s.queueEnt(2, arvados.ContainerStateQueued, 2),
+			s.queueEnt(3, arvados.ContainerStateQueued, 1),
+			s.queueEnt(4, arvados.ContainerStateQueued, 2),
+		},
+		Owners: map[string]string{
+			test.ContainerUUID(1): quotaProject,
+			test.ContainerUUID(2): quotaProject,
+			test.ContainerUUID(3): quotaProject,
+			test.ContainerUUID(4): quotaUser2,
+		},
+	}
+	queue.Update()
+	pool := s.idlePool()
+	pool.running[test.ContainerUUID(1)] = time.Time{}
+	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{}, FairSharePolicy{}, QuotaPolicy{
+		PerProject: arvados.ContainerQuota{MaxVCPUs: 1},
+		Overrides: map[string]arvados.ContainerQuota{
+			quotaProject: {MaxVCPUs: 3},
+		},
+	}, PackingPolicy{})
+	sch.runQueue()
+	s.waitLocked(c, &queue, test.ContainerUUID(3), test.ContainerUUID(4))
+	ctr, _ := queue.Get(test.ContainerUUID(2))
+	c.Check(ctr.State, check.Equals, arvados.ContainerStateQueued)
+	status := sch.SchedulingStatus()
+	c.Check(status, check.HasLen, 1)
+	c.Check(status[test.ContainerUUID(2)], check.Matches, `.*`+quotaProject+` has 2 VCPUs in use \(limit 3, container needs 2\)`)
+}
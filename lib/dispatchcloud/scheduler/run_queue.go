@@ -24,7 +24,11 @@ func (sch *Scheduler) runQueue() {
 	})
 
 	running := sch.pool.Running()
-	unalloc := sch.pool.Unallocated()
+	sch.checkCreateFailures(sorted, running)
+	if sch.fairShare.Enable {
+		sorted = sch.fairShareOrder(sorted, unsorted, running)
+	}
+	unalloc := newSlots(sch.packing, sch.pool.Unallocated())
 
 	sch.logger.WithFields(logrus.Fields{
 		"Containers": len(sorted),
@@ -32,11 +36,18 @@ func (sch *Scheduler) runQueue() {
 	}).Debug("runQueue")
 
 	dontstart := map[arvados.InstanceType]bool{}
+	quota := newQuotaTracker(sch.quota, sorted, running)
+	status := map[string]string{}
+	defer func() {
+		sch.mtx.Lock()
+		sch.schedulingStatus = status
+		sch.mtx.Unlock()
+	}()
 	var overquota []container.QueueEnt // entries that are unmappable because of worker pool quota
 
 tryrun:
-	for i, ctr := range sorted {
-		ctr, it := ctr.Container, ctr.InstanceType
+	for i, ent := range sorted {
+		ctr, it := ent.Container, sch.instanceType(ent)
 		logger := sch.logger.WithFields(logrus.Fields{
 			"ContainerUUID": ctr.UUID,
 			"InstanceType":  it.Name,
@@ -46,23 +57,34 @@ tryrun:
 		}
 		switch ctr.State {
 		case arvados.ContainerStateQueued:
-			if unalloc[it] < 1 && sch.pool.AtQuota() {
+			if reason := quota.check(ent); reason != "" {
+				// Leave it in the queue, but don't
+				// let it block other users'/projects'
+				// containers.
+				logger.WithField("Reason", reason).Debug("not locking: over quota")
+				status[ctr.UUID] = reason
+				continue
+			}
+			if !unalloc.take(it) && sch.pool.AtQuota() {
 				logger.Debug("not locking: AtQuota and no unalloc workers")
 				overquota = sorted[i:]
 				break tryrun
 			}
 			go sch.lockContainer(logger, ctr.UUID)
-			unalloc[it]--
+			quota.add(ent)
 		case arvados.ContainerStateLocked:
-			if unalloc[it] > 0 {
-				unalloc[it]--
+			if unalloc.take(it) {
+				// Mapped onto an unallocated worker.
+			} else if sch.startPacked(dontstart, it, ctr) {
+				continue
 			} else if sch.pool.AtQuota() {
 				logger.Debug("not starting: AtQuota and no unalloc workers")
 				overquota = sorted[i:]
 				break tryrun
 			} else {
-				logger.Info("creating new instance")
-				if !sch.pool.Create(it) {
+				newType := sch.packingType(it, sorted[i+1:], running)
+				logger.WithField("NewInstanceType", newType.Name).Info("creating new instance")
+				if !sch.pool.Create(newType) {
 					// (Note pool.Create works
 					// asynchronously and logs its
 					// own failures, so we don't
@@ -81,6 +103,9 @@ tryrun:
 					overquota = sorted[i:]
 					break tryrun
 				}
+				sch.waitForCreate(ent, it, newType)
+				unalloc.add(newType)
+				unalloc.take(it)
 			}
 
 			if dontstart[it] {
@@ -112,10 +137,7 @@ tryrun:
 		}
 		// Shut down idle workers that didn't get any
 		// containers mapped onto them before we hit quota.
-		for it, n := range unalloc {
-			if n < 1 {
-				continue
-			}
+		for it := range unalloc.unused() {
 			sch.pool.Shutdown(it)
 		}
 	}
@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"git.arvados.org/arvados.git/lib/dispatchcloud/container"
 	"git.arvados.org/arvados.git/lib/dispatchcloud/test"
 	"git.arvados.org/arvados.git/lib/dispatchcloud/worker"
 	"git.arvados.org/arvados.git/sdk/go/arvados"
@@ -40,8 +41,13 @@ type stubPool struct {
 	atQuota   bool
 	canCreate int
 	creates   []arvados.InstanceType
-	starts    []string
-	shutdowns int
+	// If failCreate returns true, Create returns true but the
+	// instance never appears, as if the cloud provider rejected
+	// the create call asynchronously.
+	failCreate func(arvados.InstanceType) bool
+	failed     map[arvados.InstanceType]int
+	starts     []string
+	shutdowns  int
 	sync.Mutex
 }
 
@@ -74,9 +80,25 @@ func (p *stubPool) Create(it arvados.InstanceType) bool {
 		return false
 	}
 	p.canCreate--
+	if p.failCreate != nil && p.failCreate(it) {
+		if p.failed == nil {
+			p.failed = map[arvados.InstanceType]int{}
+		}
+		p.failed[it]++
+		return true
+	}
 	p.unalloc[it]++
 	return true
 }
+func (p *stubPool) CreateFailures() map[arvados.InstanceType]int {
+	p.Lock()
+	defer p.Unlock()
+	r := map[arvados.InstanceType]int{}
+	for it, n := range p.failed {
+		r[it] = n
+	}
+	return r
+}
 func (p *stubPool) ForgetContainer(uuid string) {
 }
 func (p *stubPool) KillContainer(uuid, reason string) bool {
@@ -180,7 +202,7 @@ func (*SchedulerSuite) TestUseIdleWorkers(c *check.C) {
 		running:   map[string]time.Time{},
 		canCreate: 0,
 	}
-	New(ctx, &queue, &pool, time.Millisecond, time.Millisecond).runQueue()
+	New(ctx, &queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{}, FairSharePolicy{}, QuotaPolicy{}, PackingPolicy{}).runQueue()
 	c.Check(pool.creates, check.DeepEquals, []arvados.InstanceType{test.InstanceType(1)})
 	c.Check(pool.starts, check.DeepEquals, []string{test.ContainerUUID(4)})
 	c.Check(pool.running, check.HasLen, 1)
@@ -236,7 +258,7 @@ func (*SchedulerSuite) TestShutdownAtQuota(c *check.C) {
 			starts:    []string{},
 			canCreate: 0,
 		}
-		New(ctx, &queue, &pool, time.Millisecond, time.Millisecond).runQueue()
+		New(ctx, &queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{}, FairSharePolicy{}, QuotaPolicy{}, PackingPolicy{}).runQueue()
 		c.Check(pool.creates, check.DeepEquals, shouldCreate)
 		c.Check(pool.starts, check.DeepEquals, []string{})
 		c.Check(pool.shutdowns, check.Not(check.Equals), 0)
@@ -325,7 +347,7 @@ func (*SchedulerSuite) TestStartWhileCreating(c *check.C) {
 		},
 	}
 	queue.Update()
-	New(ctx, &queue, &pool, time.Millisecond, time.Millisecond).runQueue()
+	New(ctx, &queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{}, FairSharePolicy{}, QuotaPolicy{}, PackingPolicy{}).runQueue()
 	c.Check(pool.creates, check.DeepEquals, []arvados.InstanceType{test.InstanceType(2), test.InstanceType(1)})
 	c.Check(pool.starts, check.DeepEquals, []string{uuids[6], uuids[5], uuids[3], uuids[2]})
 	running := map[string]bool{}
@@ -368,10 +390,264 @@ func (*SchedulerSuite) TestKillNonexistentContainer(c *check.C) {
 		},
 	}
 	queue.Update()
-	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond)
+	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{}, FairSharePolicy{}, QuotaPolicy{}, PackingPolicy{})
 	c.Check(pool.running, check.HasLen, 1)
 	sch.sync()
 	for deadline := time.Now().Add(time.Second); len(pool.Running()) > 0 && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
 	}
 	c.Check(pool.Running(), check.HasLen, 0)
 }
+
+func choosePreemptibleType(ctr *arvados.Container) (arvados.InstanceType, error) {
+	it := test.InstanceType(ctr.RuntimeConstraints.VCPUs)
+	it.Name += ".preemptible"
+	it.Preemptible = true
+	it.Price /= 3
+	return it, nil
+}
+
+// After MaxAttempts failed attempts to create a preemptible instance,
+// create a non-preemptible instance instead.
+func (*SchedulerSuite) TestFallbackAfterFailedCreates(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	queue := test.Queue{
+		ChooseType: choosePreemptibleType,
+		Containers: []arvados.Container{
+			{
+				UUID:     test.ContainerUUID(1),
+				Priority: 1,
+				State:    arvados.ContainerStateLocked,
+				RuntimeConstraints: arvados.RuntimeConstraints{
+					VCPUs: 2,
+					RAM:   2 << 30,
+				},
+				SchedulingParameters: arvados.SchedulingParameters{
+					Preemptible: true,
+				},
+			},
+		},
+	}
+	queue.Update()
+	pool := stubPool{
+		unalloc:    map[arvados.InstanceType]int{},
+		idle:       map[arvados.InstanceType]int{},
+		running:    map[string]time.Time{},
+		canCreate:  10,
+		failCreate: func(it arvados.InstanceType) bool { return it.Preemptible },
+	}
+	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{
+		ChooseType:  chooseType,
+		MaxAttempts: 2,
+	}, FairSharePolicy{}, QuotaPolicy{}, PackingPolicy{})
+	for i := 0; i < 4; i++ {
+		sch.runQueue()
+	}
+	preemptible, _ := choosePreemptibleType(&queue.Containers[0])
+	c.Check(pool.creates, check.DeepEquals, []arvados.InstanceType{
+		preemptible,
+		preemptible,
+		test.InstanceType(2),
+	})
+}
+
+// Create calls that the pool refuses without trying (because of
+// quota, rate limits, etc.) don't count as failed attempts.
+func (*SchedulerSuite) TestNoFallbackWhenCreateRefused(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	queue := test.Queue{
+		ChooseType: choosePreemptibleType,
+		Containers: []arvados.Container{
+			{
+				UUID:     test.ContainerUUID(1),
+				Priority: 1,
+				State:    arvados.ContainerStateLocked,
+				RuntimeConstraints: arvados.RuntimeConstraints{
+					VCPUs: 2,
+					RAM:   2 << 30,
+				},
+				SchedulingParameters: arvados.SchedulingParameters{
+					Preemptible: true,
+				},
+			},
+		},
+	}
+	queue.Update()
+	pool := stubPool{
+		unalloc: map[arvados.InstanceType]int{},
+		idle:    map[arvados.InstanceType]int{},
+		running: map[string]time.Time{},
+	}
+	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{
+		ChooseType:  chooseType,
+		MaxAttempts: 2,
+	}, FairSharePolicy{}, QuotaPolicy{}, PackingPolicy{})
+	for i := 0; i < 4; i++ {
+		sch.runQueue()
+		// The scheduler unlocks the container after each
+		// refused create; lock it again as lockContainer
+		// would on the next iteration.
+		c.Check(queue.Lock(test.ContainerUUID(1)), check.IsNil)
+	}
+	preemptible, _ := choosePreemptibleType(&queue.Containers[0])
+	c.Check(pool.creates, check.HasLen, 4)
+	for _, it := range pool.creates {
+		c.Check(it, check.Equals, preemptible)
+	}
+}
+
+// After waiting MaxWait, create a non-preemptible instance even if
+// the preemptible instance is still booting.
+func (*SchedulerSuite) TestFallbackAfterWait(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	queue := test.Queue{
+		ChooseType: choosePreemptibleType,
+		Containers: []arvados.Container{
+			{
+				UUID:     test.ContainerUUID(1),
+				Priority: 1,
+				State:    arvados.ContainerStateLocked,
+				RuntimeConstraints: arvados.RuntimeConstraints{
+					VCPUs: 2,
+					RAM:   2 << 30,
+				},
+				SchedulingParameters: arvados.SchedulingParameters{
+					Preemptible: true,
+				},
+			},
+		},
+	}
+	queue.Update()
+	pool := stubPool{
+		unalloc:   map[arvados.InstanceType]int{},
+		idle:      map[arvados.InstanceType]int{},
+		running:   map[string]time.Time{},
+		canCreate: 2,
+	}
+	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{
+		ChooseType: chooseType,
+		MaxWait:    50 * time.Millisecond,
+	}, FairSharePolicy{}, QuotaPolicy{}, PackingPolicy{})
+	sch.runQueue()
+	sch.runQueue()
+	time.Sleep(60 * time.Millisecond)
+	sch.runQueue()
+	sch.runQueue()
+	preemptible, _ := choosePreemptibleType(&queue.Containers[0])
+	c.Check(pool.creates, check.DeepEquals, []arvados.InstanceType{
+		preemptible,
+		test.InstanceType(2),
+	})
+}
+
+// A container that is requeued after its crunch-run process exits
+// (e.g., because its preemptible instance was reclaimed) counts as a
+// failed attempt.
+func (*SchedulerSuite) TestFallbackAfterRequeue(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	queue := test.Queue{
+		ChooseType: choosePreemptibleType,
+		Containers: []arvados.Container{
+			{
+				UUID:     test.ContainerUUID(1),
+				Priority: 1,
+				State:    arvados.ContainerStateLocked,
+				RuntimeConstraints: arvados.RuntimeConstraints{
+					VCPUs: 2,
+					RAM:   2 << 30,
+				},
+				SchedulingParameters: arvados.SchedulingParameters{
+					Preemptible: true,
+				},
+			},
+		},
+	}
+	pool := stubPool{
+		unalloc: map[arvados.InstanceType]int{},
+		idle:    map[arvados.InstanceType]int{},
+		running: map[string]time.Time{
+			test.ContainerUUID(1): time.Now(),
+		},
+	}
+	queue.Update()
+	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{
+		ChooseType:  chooseType,
+		MaxAttempts: 1,
+	}, FairSharePolicy{}, QuotaPolicy{}, PackingPolicy{})
+	sch.sync()
+	for deadline := time.Now().Add(time.Second); queue.Containers[0].State != arvados.ContainerStateQueued; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			c.Fatal("timed out waiting for requeue")
+		}
+	}
+	ent := container.QueueEnt{Container: queue.Containers[0]}
+	ent.InstanceType, _ = choosePreemptibleType(&ent.Container)
+	for deadline := time.Now().Add(time.Second); sch.instanceType(ent).Preemptible; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			c.Fatal("timed out waiting for fallback")
+		}
+	}
+	c.Check(sch.instanceType(ent), check.Equals, test.InstanceType(2))
+}
+
+// A Running container whose worker disappears (e.g., because its
+// preemptible instance was reclaimed) is cancelled, and counts as a
+// failed attempt for its container request: the retry container,
+// which has a new UUID, falls back to a non-preemptible instance.
+func (*SchedulerSuite) TestFallbackAfterPreemption(c *check.C) {
+	ctx := ctxlog.Context(context.Background(), ctxlog.TestLogger(c))
+	reqUUID := "zzzzz-xvhdp-000000000000001"
+	queue := test.Queue{
+		ChooseType: choosePreemptibleType,
+		Containers: []arvados.Container{
+			{
+				UUID:     test.ContainerUUID(1),
+				Priority: 1,
+				State:    arvados.ContainerStateRunning,
+				RuntimeConstraints: arvados.RuntimeConstraints{
+					VCPUs: 2,
+					RAM:   2 << 30,
+				},
+				SchedulingParameters: arvados.SchedulingParameters{
+					Preemptible: true,
+				},
+			},
+		},
+		Requests: map[string]string{
+			test.ContainerUUID(1): reqUUID,
+			test.ContainerUUID(2): reqUUID,
+		},
+	}
+	queue.Update()
+	pool := stubPool{
+		unalloc: map[arvados.InstanceType]int{},
+		idle:    map[arvados.InstanceType]int{},
+		running: map[string]time.Time{},
+	}
+	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{
+		ChooseType:  chooseType,
+		MaxAttempts: 1,
+	}, FairSharePolicy{}, QuotaPolicy{}, PackingPolicy{})
+	sch.sync()
+	for deadline := time.Now().Add(time.Second); queue.Containers[0].State != arvados.ContainerStateCancelled; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			c.Fatal("timed out waiting for cancel")
+		}
+	}
+
+	// The container request is retried with a new container.
+	retry := queue.Containers[0]
+	retry.UUID = test.ContainerUUID(2)
+	retry.State = arvados.ContainerStateLocked
+	queue.Notify(retry)
+	queue.Update()
+	sch.sync()
+	ents, _ := queue.Entries()
+	c.Assert(ents[retry.UUID].RequestUUID, check.Equals, reqUUID)
+	for deadline := time.Now().Add(time.Second); sch.instanceType(ents[retry.UUID]).Preemptible; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			c.Fatal("timed out waiting for fallback")
+		}
+	}
+	sch.runQueue()
+	c.Check(pool.creates, check.DeepEquals, []arvados.InstanceType{test.InstanceType(2)})
+}
@@ -11,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"git.arvados.org/arvados.git/sdk/go/ctxlog"
 	"github.com/sirupsen/logrus"
 )
@@ -27,16 +28,48 @@ import (
 //
 // If it encounters errors while creating new workers, a Scheduler
 // shuts down idle workers, in case they are consuming quota.
+//
+// A Scheduler keeps track of failed attempts to run each container
+// request's containers on preemptible instances, and uses its FallbackPolicy to decide
+// when to run the container on a non-preemptible instance instead.
+//
+// If its FairSharePolicy is enabled, a Scheduler considers queued
+// containers in fair-share order rather than strictly by priority.
+//
+// A Scheduler leaves containers in the queue (without locking them)
+// if starting them would exceed their user's or project's quota.
+//
+// If its PackingPolicy is enabled, a Scheduler runs several
+// containers on a single worker when they fit.
 type Scheduler struct {
 	logger              logrus.FieldLogger
 	queue               ContainerQueue
 	pool                WorkerPool
 	staleLockTimeout    time.Duration
 	queueUpdateInterval time.Duration
-
-	uuidOp map[string]string // operation in progress: "lock", "cancel", ...
-	mtx    sync.Mutex
-	wakeup *time.Timer
+	fallback            FallbackPolicy
+	fairShare           FairSharePolicy
+	quota               QuotaPolicy
+	packing             PackingPolicy
+
+	uuidOp   map[string]string        // operation in progress: "lock", "cancel", ...
+	attempts map[string]*attemptState // preemptible instance attempts per container request
+	mtx      sync.Mutex
+	wakeup   *time.Timer
+
+	// Fallback state, used only by runQueue
+	createFailures map[arvados.InstanceType]int             // pool.CreateFailures() as of the last runQueue
+	creatingFor    map[arvados.InstanceType]map[string]bool // instance type => attempt keys waiting for a create call
+
+	// Fair-share state, used only by runQueue (except fairShares,
+	// which is protected by mtx)
+	usage        map[string]float64 // owner UUID => discounted VCPU-seconds
+	usageUpdated time.Time
+	fairShares   []FairShare
+
+	// Reasons for not starting containers, as of the last runQueue
+	// (protected by mtx)
+	schedulingStatus map[string]string
 
 	runOnce sync.Once
 	stop    chan struct{}
@@ -47,20 +80,36 @@ type Scheduler struct {
 //
 // Any given queue and pool should not be used by more than one
 // scheduler at a time.
-func New(ctx context.Context, queue ContainerQueue, pool WorkerPool, staleLockTimeout, queueUpdateInterval time.Duration) *Scheduler {
+func New(ctx context.Context, queue ContainerQueue, pool WorkerPool, staleLockTimeout, queueUpdateInterval time.Duration, fallback FallbackPolicy, fairShare FairSharePolicy, quota QuotaPolicy, packing PackingPolicy) *Scheduler {
 	return &Scheduler{
 		logger:              ctxlog.FromContext(ctx),
 		queue:               queue,
 		pool:                pool,
 		staleLockTimeout:    staleLockTimeout,
 		queueUpdateInterval: queueUpdateInterval,
+		fallback:            fallback,
+		fairShare:           fairShare,
+		quota:               quota,
+		packing:             packing,
 		wakeup:              time.NewTimer(time.Second),
 		stop:                make(chan struct{}),
 		stopped:             make(chan struct{}),
 		uuidOp:              map[string]string{},
+		attempts:            map[string]*attemptState{},
+		creatingFor:         map[arvados.InstanceType]map[string]bool{},
+		usage:               map[string]float64{},
 	}
 }
 
+// NeedRequests returns true if the scheduler's policies use the
+// container request information in queue entries (RequestUUID,
+// OwnerUUID, and UserUUID).
+func (sch *Scheduler) NeedRequests() bool {
+	return sch.fairShare.Enable ||
+		sch.quota.enabled() ||
+		(sch.fallback.ChooseType != nil && (sch.fallback.MaxAttempts > 0 || sch.fallback.MaxWait > 0))
+}
+
 // Start starts the scheduler.
 func (sch *Scheduler) Start() {
 	go sch.runOnce.Do(sch.run)
@@ -30,7 +30,16 @@ func (sch *Scheduler) sync() {
 		switch ent.Container.State {
 		case arvados.ContainerStateRunning:
 			if !running {
-				go sch.cancel(uuid, "not running on any worker")
+				// The worker might have been a
+				// preemptible instance that was
+				// reclaimed by the cloud provider. If
+				// the container request is retried,
+				// the retry will use a new container.
+				go func(ent container.QueueEnt) {
+					if sch.cancel(ent.Container.UUID, "not running on any worker") {
+						sch.addAttempt(ent, sch.instanceType(ent))
+					}
+				}(ent)
 			} else if !exited.IsZero() && qUpdated.After(exited) {
 				go sch.cancel(uuid, "state=Running after crunch-run exited")
 			} else if ent.Container.Priority == 0 {
@@ -71,7 +80,14 @@ func (sch *Scheduler) sync() {
 			}
 		case arvados.ContainerStateLocked:
 			if running && !exited.IsZero() && qUpdated.After(exited) {
-				go sch.requeue(ent, "crunch-run exited")
+				go func(ent container.QueueEnt) {
+					// If the worker was a preemptible
+					// instance, it might have been
+					// reclaimed by the cloud provider.
+					if sch.requeue(ent, "crunch-run exited") {
+						sch.addAttempt(ent, sch.instanceType(ent))
+					}
+				}(ent)
 			} else if running && exited.IsZero() && ent.Container.Priority == 0 {
 				go sch.kill(uuid, "priority=0")
 			} else if !running && ent.Container.Priority == 0 {
@@ -89,11 +105,14 @@ func (sch *Scheduler) sync() {
 			go sch.kill(uuid, "not in queue")
 		}
 	}
+	sch.forgetAttempts(qEntries)
 }
 
-func (sch *Scheduler) cancel(uuid string, reason string) {
+// cancel cancels the given container, and returns true if
+// successful.
+func (sch *Scheduler) cancel(uuid string, reason string) bool {
 	if !sch.uuidLock(uuid, "cancel") {
-		return
+		return false
 	}
 	defer sch.uuidUnlock(uuid)
 	logger := sch.logger.WithField("ContainerUUID", uuid)
@@ -101,7 +120,9 @@ func (sch *Scheduler) cancel(uuid string, reason string) {
 	err := sch.queue.Cancel(uuid)
 	if err != nil {
 		logger.WithError(err).Print("error cancelling container")
+		return false
 	}
+	return true
 }
 
 func (sch *Scheduler) kill(uuid string, reason string) {
@@ -109,10 +130,12 @@ func (sch *Scheduler) kill(uuid string, reason string) {
 	sch.pool.ForgetContainer(uuid)
 }
 
-func (sch *Scheduler) requeue(ent container.QueueEnt, reason string) {
+// requeue unlocks the given container, and returns true if
+// successful.
+func (sch *Scheduler) requeue(ent container.QueueEnt, reason string) bool {
 	uuid := ent.Container.UUID
 	if !sch.uuidLock(uuid, "cancel") {
-		return
+		return false
 	}
 	defer sch.uuidUnlock(uuid)
 	logger := sch.logger.WithFields(logrus.Fields{
@@ -124,5 +147,7 @@ func (sch *Scheduler) requeue(ent container.QueueEnt, reason string) {
 	err := sch.queue.Unlock(uuid)
 	if err != nil {
 		logger.WithError(err).Error("error requeueing container")
+		return false
 	}
+	return true
 }
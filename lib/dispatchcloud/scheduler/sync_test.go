@@ -48,7 +48,7 @@ func (*SchedulerSuite) TestForgetIrrelevantContainers(c *check.C) {
 	ents, _ := queue.Entries()
 	c.Check(ents, check.HasLen, 1)
 
-	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond)
+	sch := New(ctx, &queue, &pool, time.Millisecond, time.Millisecond, FallbackPolicy{}, FairSharePolicy{}, QuotaPolicy{}, PackingPolicy{})
 	sch.sync()
 
 	ents, _ = queue.Entries()
@@ -23,6 +23,13 @@ type Queue struct {
 	// must not be nil.
 	ChooseType func(*arvados.Container) (arvados.InstanceType, error)
 
+	// Requests, Owners, and Users map container UUIDs to the
+	// RequestUUID, OwnerUUID, and UserUUID values that should
+	// appear in the corresponding queue entries. They can be nil.
+	Requests map[string]string
+	Owners   map[string]string
+	Users    map[string]string
+
 	entries     map[string]container.QueueEnt
 	updTime     time.Time
 	subscribers map[<-chan struct{}]chan struct{}
@@ -142,6 +149,9 @@ func (q *Queue) Update() error {
 			upd[ctr.UUID] = container.QueueEnt{
 				Container:    ctr,
 				InstanceType: it,
+				RequestUUID:  q.Requests[ctr.UUID],
+				OwnerUUID:    q.Owners[ctr.UUID],
+				UserUUID:     q.Users[ctr.UUID],
 			}
 		}
 	}
@@ -42,6 +42,12 @@ type StubDriver struct {
 	// Destroy. 0=always succeed, 1=always fail.
 	ErrorRateDestroy float64
 
+	// If CreateError is not nil and returns a non-nil error for
+	// the requested instance type, Create fails with that error
+	// (e.g., to simulate a cloud provider with no capacity for
+	// preemptible instances).
+	CreateError func(arvados.InstanceType) error
+
 	// If Create() or Instances() is called too frequently, return
 	// rate-limiting errors.
 	MinTimeBetweenCreateCalls    time.Duration
@@ -115,6 +121,11 @@ func (sis *StubInstanceSet) Create(it arvados.InstanceType, image cloud.ImageID,
 	} else {
 		sis.allowCreateCall = time.Now().Add(sis.driver.MinTimeBetweenCreateCalls)
 	}
+	if sis.driver.CreateError != nil {
+		if err := sis.driver.CreateError(it); err != nil {
+			return nil, err
+		}
+	}
 
 	ak := sis.driver.AuthorizedKeys
 	if authKey != nil {
@@ -39,6 +39,7 @@ type InstanceView struct {
 	ProviderInstanceType string           `json:"provider_instance_type"`
 	LastContainerUUID    string           `json:"last_container_uuid"`
 	LastBusy             time.Time        `json:"last_busy"`
+	RunningContainers    int              `json:"running_containers"`
 	WorkerState          string           `json:"worker_state"`
 	IdleBehavior         IdleBehavior     `json:"idle_behavior"`
 }
@@ -96,27 +97,28 @@ func duration(conf arvados.Duration, def time.Duration) time.Duration {
 // cluster configuration.
 func NewPool(logger logrus.FieldLogger, arvClient *arvados.Client, reg *prometheus.Registry, instanceSetID cloud.InstanceSetID, instanceSet cloud.InstanceSet, newExecutor func(cloud.Instance) Executor, installPublicKey ssh.PublicKey, cluster *arvados.Cluster) *Pool {
 	wp := &Pool{
-		logger:             logger,
-		arvClient:          arvClient,
-		instanceSetID:      instanceSetID,
-		instanceSet:        &throttledInstanceSet{InstanceSet: instanceSet},
-		newExecutor:        newExecutor,
-		bootProbeCommand:   cluster.Containers.CloudVMs.BootProbeCommand,
-		runnerSource:       cluster.Containers.CloudVMs.DeployRunnerBinary,
-		imageID:            cloud.ImageID(cluster.Containers.CloudVMs.ImageID),
-		instanceTypes:      cluster.InstanceTypes,
-		maxProbesPerSecond: cluster.Containers.CloudVMs.MaxProbesPerSecond,
-		probeInterval:      duration(cluster.Containers.CloudVMs.ProbeInterval, defaultProbeInterval),
-		syncInterval:       duration(cluster.Containers.CloudVMs.SyncInterval, defaultSyncInterval),
-		timeoutIdle:        duration(cluster.Containers.CloudVMs.TimeoutIdle, defaultTimeoutIdle),
-		timeoutBooting:     duration(cluster.Containers.CloudVMs.TimeoutBooting, defaultTimeoutBooting),
-		timeoutProbe:       duration(cluster.Containers.CloudVMs.TimeoutProbe, defaultTimeoutProbe),
-		timeoutShutdown:    duration(cluster.Containers.CloudVMs.TimeoutShutdown, defaultTimeoutShutdown),
-		timeoutTERM:        duration(cluster.Containers.CloudVMs.TimeoutTERM, defaultTimeoutTERM),
-		timeoutSignal:      duration(cluster.Containers.CloudVMs.TimeoutSignal, defaultTimeoutSignal),
-		installPublicKey:   installPublicKey,
-		tagKeyPrefix:       cluster.Containers.CloudVMs.TagKeyPrefix,
-		stop:               make(chan bool),
+		logger:                   logger,
+		arvClient:                arvClient,
+		instanceSetID:            instanceSetID,
+		instanceSet:              &throttledInstanceSet{InstanceSet: instanceSet},
+		newExecutor:              newExecutor,
+		bootProbeCommand:         cluster.Containers.CloudVMs.BootProbeCommand,
+		runnerSource:             cluster.Containers.CloudVMs.DeployRunnerBinary,
+		imageID:                  cloud.ImageID(cluster.Containers.CloudVMs.ImageID),
+		instanceTypes:            cluster.InstanceTypes,
+		maxProbesPerSecond:       cluster.Containers.CloudVMs.MaxProbesPerSecond,
+		maxContainersPerInstance: cluster.Containers.CloudVMs.MaxContainersPerInstance,
+		probeInterval:            duration(cluster.Containers.CloudVMs.ProbeInterval, defaultProbeInterval),
+		syncInterval:             duration(cluster.Containers.CloudVMs.SyncInterval, defaultSyncInterval),
+		timeoutIdle:              duration(cluster.Containers.CloudVMs.TimeoutIdle, defaultTimeoutIdle),
+		timeoutBooting:           duration(cluster.Containers.CloudVMs.TimeoutBooting, defaultTimeoutBooting),
+		timeoutProbe:             duration(cluster.Containers.CloudVMs.TimeoutProbe, defaultTimeoutProbe),
+		timeoutShutdown:          duration(cluster.Containers.CloudVMs.TimeoutShutdown, defaultTimeoutShutdown),
+		timeoutTERM:              duration(cluster.Containers.CloudVMs.TimeoutTERM, defaultTimeoutTERM),
+		timeoutSignal:            duration(cluster.Containers.CloudVMs.TimeoutSignal, defaultTimeoutSignal),
+		installPublicKey:         installPublicKey,
+		tagKeyPrefix:             cluster.Containers.CloudVMs.TagKeyPrefix,
+		stop:                     make(chan bool),
 	}
 	wp.registerMetrics(reg)
 	go func() {
@@ -132,26 +134,27 @@ func NewPool(logger logrus.FieldLogger, arvClient *arvados.Client, reg *promethe
 // zero Pool should not be used. Call NewPool to create a new Pool.
 type Pool struct {
 	// configuration
-	logger             logrus.FieldLogger
-	arvClient          *arvados.Client
-	instanceSetID      cloud.InstanceSetID
-	instanceSet        *throttledInstanceSet
-	newExecutor        func(cloud.Instance) Executor
-	bootProbeCommand   string
-	runnerSource       string
-	imageID            cloud.ImageID
-	instanceTypes      map[string]arvados.InstanceType
-	syncInterval       time.Duration
-	probeInterval      time.Duration
-	maxProbesPerSecond int
-	timeoutIdle        time.Duration
-	timeoutBooting     time.Duration
-	timeoutProbe       time.Duration
-	timeoutShutdown    time.Duration
-	timeoutTERM        time.Duration
-	timeoutSignal      time.Duration
-	installPublicKey   ssh.PublicKey
-	tagKeyPrefix       string
+	logger                   logrus.FieldLogger
+	arvClient                *arvados.Client
+	instanceSetID            cloud.InstanceSetID
+	instanceSet              *throttledInstanceSet
+	newExecutor              func(cloud.Instance) Executor
+	bootProbeCommand         string
+	runnerSource             string
+	imageID                  cloud.ImageID
+	instanceTypes            map[string]arvados.InstanceType
+	syncInterval             time.Duration
+	probeInterval            time.Duration
+	maxProbesPerSecond       int
+	maxContainersPerInstance int
+	timeoutIdle              time.Duration
+	timeoutBooting           time.Duration
+	timeoutProbe             time.Duration
+	timeoutShutdown          time.Duration
+	timeoutTERM              time.Duration
+	timeoutSignal            time.Duration
+	installPublicKey         ssh.PublicKey
+	tagKeyPrefix             string
 
 	// private state
 	subscribers  map[<-chan struct{}]chan<- struct{}
@@ -161,6 +164,7 @@ type Pool struct {
 	exited       map[string]time.Time // containers whose crunch-run proc has exited, but ForgetContainer has not been called
 	atQuotaUntil time.Time
 	atQuotaErr   cloud.QuotaError
+	failed       map[arvados.InstanceType]int // failed Create calls, excluding quota and rate-limit errors
 	stop         chan bool
 	mtx          sync.RWMutex
 	setupOnce    sync.Once
@@ -324,6 +328,8 @@ func (wp *Pool) Create(it arvados.InstanceType) bool {
 				wp.atQuotaErr = err
 				wp.atQuotaUntil = time.Now().Add(quotaErrorTTL)
 				time.AfterFunc(quotaErrorTTL, wp.notify)
+			} else if _, ok := err.(cloud.RateLimitError); !ok {
+				wp.failed[it]++
 			}
 			logger.WithError(err).Error("create failed")
 			wp.instanceSet.throttleCreate.CheckRateLimitError(err, wp.logger, "create instance", wp.notify)
@@ -334,6 +340,21 @@ func (wp *Pool) Create(it arvados.InstanceType) bool {
 	return true
 }
 
+// CreateFailures returns the number of Create calls that have failed
+// asynchronously (i.e., Create returned true but the cloud provider
+// did not create the instance), by instance type. Quota and
+// rate-limit errors are not counted.
+func (wp *Pool) CreateFailures() map[arvados.InstanceType]int {
+	wp.setupOnce.Do(wp.setup)
+	wp.mtx.RLock()
+	defer wp.mtx.RUnlock()
+	r := map[arvados.InstanceType]int{}
+	for it, n := range wp.failed {
+		r[it] = n
+	}
+	return r
+}
+
 // AtQuota returns true if Create is not expected to work at the
 // moment.
 func (wp *Pool) AtQuota() bool {
@@ -488,25 +509,75 @@ func (wp *Pool) Running() map[string]time.Time {
 
 // StartContainer starts a container on an idle worker immediately if
 // possible, otherwise returns false.
+//
+// If the pool is configured to run more than one container per
+// instance, StartContainer prefers a worker that is already running
+// other containers and has room for this one (i.e., the resources of
+// instance type it), then an idle worker of type it, then the
+// cheapest idle worker of another type that has room.
 func (wp *Pool) StartContainer(it arvados.InstanceType, ctr arvados.Container) bool {
 	wp.setupOnce.Do(wp.setup)
 	wp.mtx.Lock()
 	defer wp.mtx.Unlock()
 	var wkr *worker
-	for _, w := range wp.workers {
-		if w.instType == it && w.state == StateIdle {
-			if wkr == nil || w.busy.After(wkr.busy) {
-				wkr = w
+	if wp.maxContainersPerInstance > 1 {
+		wkr = wp.packTarget(it)
+	} else {
+		for _, w := range wp.workers {
+			if w.instType == it && w.state == StateIdle {
+				if wkr == nil || w.busy.After(wkr.busy) {
+					wkr = w
+				}
 			}
 		}
 	}
 	if wkr == nil {
 		return false
 	}
-	wkr.startContainer(ctr)
+	wkr.startContainer(ctr, it)
 	return true
 }
 
+// packTarget returns the best worker to start a container that needs
+// the resources of the given instance type, or nil if no worker has
+// room. See StartContainer.
+//
+// Caller must have lock.
+func (wp *Pool) packTarget(it arvados.InstanceType) *worker {
+	rank := func(w *worker) int {
+		switch {
+		case w.state == StateRunning:
+			return 0
+		case w.instType == it:
+			return 1
+		default:
+			return 2
+		}
+	}
+	var wkr *worker
+	for _, w := range wp.workers {
+		if w.state != StateIdle && w.state != StateRunning ||
+			w.idleBehavior != IdleBehaviorRun ||
+			!w.hasRoomFor(it) {
+			continue
+		}
+		if wkr == nil {
+			wkr = w
+		} else if r, wr := rank(w), rank(wkr); r != wr {
+			if r < wr {
+				wkr = w
+			}
+		} else if r == 2 {
+			if w.instType.Price < wkr.instType.Price {
+				wkr = w
+			}
+		} else if w.busy.After(wkr.busy) {
+			wkr = w
+		}
+	}
+	return wkr
+}
+
 // KillContainer kills the crunch-run process for the given container
 // UUID, if it's running on any worker.
 //
@@ -746,6 +817,7 @@ func (wp *Pool) Instances() []InstanceView {
 			ProviderInstanceType: w.instType.ProviderType,
 			LastContainerUUID:    w.lastUUID,
 			LastBusy:             w.busy,
+			RunningContainers:    len(w.running) + len(w.starting),
 			WorkerState:          w.state.String(),
 			IdleBehavior:         w.idleBehavior,
 		})
@@ -771,6 +843,7 @@ func (wp *Pool) KillInstance(id cloud.InstanceID, reason string) error {
 
 func (wp *Pool) setup() {
 	wp.creating = map[string]createCall{}
+	wp.failed = map[arvados.InstanceType]int{}
 	wp.exited = map[string]time.Time{}
 	wp.workers = map[cloud.InstanceID]*worker{}
 	wp.subscribers = map[<-chan struct{}]chan<- struct{}{}
@@ -293,3 +293,60 @@ func (suite *PoolSuite) wait(c *check.C, pool *Pool, notify <-chan struct{}, rea
 	}
 	c.Check(ready(), check.Equals, true)
 }
+
+func (suite *PoolSuite) TestPackTarget(c *check.C) {
+	type1 := test.InstanceType(1)
+	type4 := test.InstanceType(4)
+	pool := &Pool{
+		maxContainersPerInstance: 3,
+		workers:                  map[cloud.InstanceID]*worker{},
+	}
+	addWorker := func(id string, it arvados.InstanceType, state State, running ...arvados.InstanceType) *worker {
+		wkr := &worker{
+			wp:           pool,
+			instType:     it,
+			state:        state,
+			idleBehavior: IdleBehaviorRun,
+			running:      map[string]*remoteRunner{},
+			starting:     map[string]*remoteRunner{},
+		}
+		for i, rit := range running {
+			uuid := test.ContainerUUID(len(pool.workers)*10 + i)
+			wkr.running[uuid] = &remoteRunner{uuid: uuid, resources: rit}
+		}
+		pool.workers[cloud.InstanceID(id)] = wkr
+		return wkr
+	}
+	idle1 := addWorker("idle1", type1, StateIdle)
+	idle4 := addWorker("idle4", type4, StateIdle)
+
+	// Prefer an idle worker of the requested type.
+	c.Check(pool.packTarget(type1), check.Equals, idle1)
+	// Otherwise, use a bigger idle worker.
+	c.Check(pool.packTarget(test.InstanceType(2)), check.Equals, idle4)
+	c.Check(pool.packTarget(test.InstanceType(5)), check.IsNil)
+
+	// Prefer a worker that is already running containers and
+	// has room.
+	busy4 := addWorker("busy4", type4, StateRunning, type1, type1)
+	c.Check(pool.packTarget(type1), check.Equals, busy4)
+	c.Check(pool.packTarget(test.InstanceType(2)), check.Equals, busy4)
+	c.Check(pool.packTarget(test.InstanceType(3)), check.Equals, idle4)
+
+	// Respect the per-instance container limit.
+	busy4.running[test.ContainerUUID(99)] = &remoteRunner{resources: type1}
+	c.Check(pool.packTarget(type1), check.Equals, idle1)
+
+	// A container started by a previous dispatcher process uses
+	// unknown resources, so its worker is full.
+	unknown := addWorker("unknown", type4, StateRunning, arvados.InstanceType{})
+	c.Check(unknown.hasRoomFor(type1), check.Equals, false)
+
+	// Non-preemptible containers don't run on preemptible
+	// workers.
+	preemptible := type1
+	preemptible.Preemptible = true
+	idle1.instType = preemptible
+	c.Check(idle1.hasRoomFor(type1), check.Equals, false)
+	c.Check(idle1.hasRoomFor(preemptible), check.Equals, true)
+}
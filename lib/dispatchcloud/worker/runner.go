@@ -11,6 +11,7 @@ import (
 	"syscall"
 	"time"
 
+	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"github.com/sirupsen/logrus"
 )
 
@@ -28,6 +29,11 @@ type remoteRunner struct {
 	onKilled      func(uuid string) // callback invoked when process exits after SIGTERM
 	logger        logrus.FieldLogger
 
+	// Resources allocated to the container (zero if unknown,
+	// e.g., the container was started by a previous dispatcher
+	// process).
+	resources arvados.InstanceType
+
 	stopping bool          // true if Stop() has been called
 	givenup  bool          // true if timeoutTERM has been reached
 	closed   chan struct{} // channel is closed if Close() has been called
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -120,13 +121,14 @@ func (wkr *worker) setIdleBehavior(idleBehavior IdleBehavior) {
 }
 
 // caller must have lock.
-func (wkr *worker) startContainer(ctr arvados.Container) {
+func (wkr *worker) startContainer(ctr arvados.Container, resources arvados.InstanceType) {
 	logger := wkr.logger.WithFields(logrus.Fields{
 		"ContainerUUID": ctr.UUID,
 		"Priority":      ctr.Priority,
 	})
 	logger.Debug("starting container")
 	rr := newRemoteRunner(ctr.UUID, wkr)
+	rr.resources = resources
 	wkr.starting[ctr.UUID] = rr
 	if wkr.state != StateRunning {
 		wkr.state = StateRunning
@@ -145,6 +147,39 @@ func (wkr *worker) startContainer(ctr arvados.Container) {
 	}()
 }
 
+// hasRoomFor returns true if a container that needs the resources
+// of the given instance type can start on this worker without
+// exceeding its VCPUs, RAM, scratch space, or the pool's
+// per-instance container limit.
+//
+// If the resources needed by any container already running on the
+// worker are unknown, the worker is considered full.
+//
+// Caller must have lock.
+func (wkr *worker) hasRoomFor(it arvados.InstanceType) bool {
+	if wkr.instType.Preemptible && !it.Preemptible {
+		return false
+	}
+	n := len(wkr.running) + len(wkr.starting)
+	if n > 0 && n >= wkr.wp.maxContainersPerInstance {
+		return false
+	}
+	vcpus, ram, scratch := it.VCPUs, it.RAM, it.Scratch
+	for _, runners := range []map[string]*remoteRunner{wkr.running, wkr.starting} {
+		for _, rr := range runners {
+			if rr.resources.VCPUs == 0 {
+				return false
+			}
+			vcpus += rr.resources.VCPUs
+			ram += rr.resources.RAM
+			scratch += rr.resources.Scratch
+		}
+	}
+	return vcpus <= wkr.instType.VCPUs &&
+		ram <= wkr.instType.RAM &&
+		scratch <= wkr.instType.Scratch
+}
+
 // ProbeAndUpdate conducts appropriate boot/running probes (if any)
 // for the worker's curent state. If a previous probe is still
 // running, it does nothing.
@@ -334,13 +369,19 @@ func (wkr *worker) probeRunning() (running []string, reportsBroken, ok bool) {
 		return
 	}
 	ok = true
+	seen := map[string]bool{}
 	for _, s := range strings.Split(string(stdout), "\n") {
 		if s == "broken" {
 			reportsBroken = true
-		} else if s != "" {
+		} else if s != "" && !seen[s] {
+			// A worker can run several containers at
+			// once (see hasRoomFor), so there can be
+			// more than one UUID here.
+			seen[s] = true
 			running = append(running, s)
 		}
 	}
+	sort.Strings(running)
 	return
 }
 
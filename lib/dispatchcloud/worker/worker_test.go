@@ -54,6 +54,8 @@ func (suite *WorkerSuite) TestProbeAndUpdate(c *check.C) {
 	errFail := errors.New("failed")
 	respFail := stubResp{"", "command failed\n", errFail}
 	respContainerRunning := stubResp{"zzzzz-dz642-abcdefghijklmno\n", "", nil}
+	respTwoContainersRunning := stubResp{"zzzzz-dz642-bcdefghijklmnop\nzzzzz-dz642-abcdefghijklmno\nzzzzz-dz642-bcdefghijklmnop\n", "", nil}
+	respOtherContainerRunning := stubResp{"zzzzz-dz642-bcdefghijklmnop\n", "", nil}
 	for idx, trial := range []trialT{
 		{
 			testCaseComment: "Unknown, probes fail",
@@ -186,6 +188,24 @@ func (suite *WorkerSuite) TestProbeAndUpdate(c *check.C) {
 			expectState:     StateIdle,
 			expectRunning:   0,
 		},
+		{
+			testCaseComment: "Running, two containers running",
+			state:           StateRunning,
+			running:         1,
+			starting:        1,
+			respRun:         respTwoContainersRunning,
+			expectState:     StateRunning,
+			expectRunning:   2,
+		},
+		{
+			testCaseComment: "Running, one of two containers has exited",
+			state:           StateRunning,
+			running:         1,
+			starting:        1,
+			respRun:         respOtherContainerRunning,
+			expectState:     StateRunning,
+			expectRunning:   1,
+		},
 		{
 			testCaseComment: "Running, probe timeout exceeded, nothing running, new container being started",
 			state:           StateRunning,
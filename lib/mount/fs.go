@@ -33,7 +33,13 @@ type keepFS struct {
 	Uid        int
 	Gid        int
 
+	// Names of writable top-level directories, each backed by
+	// a new empty collection. After Init, the collection
+	// filesystems are available in tmp.
+	Tmp []string
+
 	root   arvados.CustomFileSystem
+	tmp    map[string]arvados.CollectionFileSystem
 	open   map[uint64]*sharedFile
 	lastFH uint64
 	sync.RWMutex
@@ -71,6 +77,15 @@ func (fs *keepFS) Init() {
 	defer fs.debugPanics()
 	fs.root = fs.Client.SiteFileSystem(fs.KeepClient)
 	fs.root.MountProject("home", "")
+	fs.tmp = make(map[string]arvados.CollectionFileSystem)
+	for _, name := range fs.Tmp {
+		cfs, err := fs.root.MountTmp(name)
+		if err != nil {
+			log.Printf("error mounting tmp dir %q: %s", name, err)
+			continue
+		}
+		fs.tmp[name] = cfs
+	}
 	if fs.ready != nil {
 		close(fs.ready)
 	}
@@ -0,0 +1,91 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+	"git.arvados.org/arvados.git/sdk/go/keepclient"
+	"github.com/arvados/cgofuse/fuse"
+)
+
+// InProcessMount is a keepFS mounted by the calling process, rather
+// than by a separate "mount" command. Crunch-run uses this to
+// provide collection mounts without arv-mount.
+type InProcessMount struct {
+	fs   *keepFS
+	host *fuse.FileSystemHost
+	done chan struct{}
+}
+
+// MountInProcess mounts a writable keepFS at mountpoint, and returns
+// when the mount is ready.
+//
+// Each name in tmp becomes a writable top-level directory backed by
+// a new empty collection, which can be retrieved (e.g., to save its
+// manifest) with TmpCollection.
+//
+// blockCache is the number of 64 MiB blocks to cache (zero means use
+// the default). fuseOpts are passed to the FUSE library.
+func MountInProcess(mountpoint string, client *arvados.Client, tmp []string, blockCache int, fuseOpts []string) (*InProcessMount, error) {
+	ac, err := arvadosclient.New(client)
+	if err != nil {
+		return nil, err
+	}
+	kc, err := keepclient.MakeKeepClient(ac)
+	if err != nil {
+		return nil, err
+	}
+	if blockCache > 0 {
+		kc.BlockCache = &keepclient.BlockCache{MaxBlocks: blockCache}
+	}
+	m := &InProcessMount{
+		fs: &keepFS{
+			Client:     client,
+			KeepClient: kc,
+			Uid:        os.Getuid(),
+			Gid:        os.Getgid(),
+			Tmp:        tmp,
+			ready:      make(chan struct{}),
+		},
+		done: make(chan struct{}),
+	}
+	m.host = fuse.NewFileSystemHost(m.fs)
+	go func() {
+		defer close(m.done)
+		m.host.Mount(mountpoint, fuseOpts)
+	}()
+	select {
+	case <-m.fs.ready:
+		return m, nil
+	case <-m.done:
+		return nil, fmt.Errorf("error mounting %s", mountpoint)
+	}
+}
+
+// TmpCollection returns the collection filesystem backing the tmp
+// directory with the given name, or nil if there is no such
+// directory.
+func (m *InProcessMount) TmpCollection(name string) arvados.CollectionFileSystem {
+	return m.fs.tmp[name]
+}
+
+// Unmount unmounts the filesystem.
+func (m *InProcessMount) Unmount() error {
+	if !m.host.Unmount() {
+		return errors.New("unmount failed")
+	}
+	return nil
+}
+
+// Done returns a channel that is closed when the filesystem is
+// unmounted.
+func (m *InProcessMount) Done() <-chan struct{} {
+	return m.done
+}
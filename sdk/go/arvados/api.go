@@ -7,6 +7,7 @@ package arvados
 import (
 	"context"
 	"encoding/json"
+	"net/http"
 )
 
 type APIEndpoint struct {
@@ -41,6 +42,27 @@ var (
 	EndpointContainerDelete               = APIEndpoint{"DELETE", "arvados/v1/containers/{uuid}", ""}
 	EndpointContainerLock                 = APIEndpoint{"POST", "arvados/v1/containers/{uuid}/lock", ""}
 	EndpointContainerUnlock               = APIEndpoint{"POST", "arvados/v1/containers/{uuid}/unlock", ""}
+	EndpointContainerLog                  = APIEndpoint{"GET", "arvados/v1/containers/{uuid}/log", ""}
+	EndpointContainerRequestCreate        = APIEndpoint{"POST", "arvados/v1/container_requests", "container_request"}
+	EndpointContainerRequestUpdate        = APIEndpoint{"PATCH", "arvados/v1/container_requests/{uuid}", "container_request"}
+	EndpointContainerRequestGet           = APIEndpoint{"GET", "arvados/v1/container_requests/{uuid}", ""}
+	EndpointContainerRequestList          = APIEndpoint{"GET", "arvados/v1/container_requests", ""}
+	EndpointContainerRequestDelete        = APIEndpoint{"DELETE", "arvados/v1/container_requests/{uuid}", ""}
+	EndpointGroupCreate                   = APIEndpoint{"POST", "arvados/v1/groups", "group"}
+	EndpointGroupUpdate                   = APIEndpoint{"PATCH", "arvados/v1/groups/{uuid}", "group"}
+	EndpointGroupContents                 = APIEndpoint{"GET", "arvados/v1/groups/contents", ""}
+	EndpointGroupContentsUUID             = APIEndpoint{"GET", "arvados/v1/groups/{uuid}/contents", ""}
+	EndpointGroupGet                      = APIEndpoint{"GET", "arvados/v1/groups/{uuid}", ""}
+	EndpointGroupList                     = APIEndpoint{"GET", "arvados/v1/groups", ""}
+	EndpointGroupDelete                   = APIEndpoint{"DELETE", "arvados/v1/groups/{uuid}", ""}
+	EndpointGroupShared                   = APIEndpoint{"GET", "arvados/v1/groups/shared", ""}
+	EndpointGroupTrash                    = APIEndpoint{"POST", "arvados/v1/groups/{uuid}/trash", ""}
+	EndpointGroupUntrash                  = APIEndpoint{"POST", "arvados/v1/groups/{uuid}/untrash", ""}
+	EndpointLinkCreate                    = APIEndpoint{"POST", "arvados/v1/links", "link"}
+	EndpointLinkUpdate                    = APIEndpoint{"PATCH", "arvados/v1/links/{uuid}", "link"}
+	EndpointLinkGet                       = APIEndpoint{"GET", "arvados/v1/links/{uuid}", ""}
+	EndpointLinkList                      = APIEndpoint{"GET", "arvados/v1/links", ""}
+	EndpointLinkDelete                    = APIEndpoint{"DELETE", "arvados/v1/links/{uuid}", ""}
 	EndpointUserActivate                  = APIEndpoint{"POST", "arvados/v1/users/{uuid}/activate", ""}
 	EndpointUserCreate                    = APIEndpoint{"POST", "arvados/v1/users", "user"}
 	EndpointUserCurrent                   = APIEndpoint{"GET", "arvados/v1/users/current", ""}
@@ -58,6 +80,10 @@ var (
 	EndpointUserBatchUpdate               = APIEndpoint{"PATCH", "arvados/v1/users/batch_update", ""}
 	EndpointUserAuthenticate              = APIEndpoint{"POST", "arvados/v1/users/authenticate", ""}
 	EndpointAPIClientAuthorizationCurrent = APIEndpoint{"GET", "arvados/v1/api_client_authorizations/current", ""}
+	EndpointDeviceAuthorizationCreate     = APIEndpoint{"POST", "arvados/v1/device_authorizations", ""}
+	EndpointDeviceAuthorizationToken      = APIEndpoint{"POST", "arvados/v1/device_authorizations/token", ""}
+	EndpointDeviceAuthorizationVerify     = APIEndpoint{"GET", "device", ""}
+	EndpointDeviceAuthorizationApprove    = APIEndpoint{"POST", "device", ""}
 )
 
 type GetOptions struct {
@@ -68,6 +94,23 @@ type GetOptions struct {
 	Remote       string   `json:"remote,omitempty"`
 }
 
+type GroupContentsOptions struct {
+	ClusterID          string   `json:"cluster_id"`
+	UUID               string   `json:"uuid,omitempty"`
+	Select             []string `json:"select"`
+	Filters            []Filter `json:"filters"`
+	Limit              int64    `json:"limit"`
+	Offset             int64    `json:"offset"`
+	Order              []string `json:"order"`
+	Count              string   `json:"count"`
+	Include            string   `json:"include"`
+	Recursive          bool     `json:"recursive"`
+	IncludeTrash       bool     `json:"include_trash"`
+	IncludeOldVersions bool     `json:"include_old_versions"`
+	ExcludeHomeProject bool     `json:"exclude_home_project"`
+	Federated          bool     `json:"federated"` // Merge results from all clusters
+}
+
 type UntrashOptions struct {
 	UUID             string `json:"uuid"`
 	EnsureUniqueName bool   `json:"ensure_unique_name"`
@@ -86,6 +129,7 @@ type ListOptions struct {
 	IncludeTrash       bool                   `json:"include_trash"`
 	IncludeOldVersions bool                   `json:"include_old_versions"`
 	BypassFederation   bool                   `json:"bypass_federation"`
+	Include            string                 `json:"include,omitempty"` // "owner_uuid" (groups#shared only)
 }
 
 type CreateOptions struct {
@@ -138,6 +182,12 @@ type DeleteOptions struct {
 	UUID string `json:"uuid"`
 }
 
+type ContainerLogOptions struct {
+	UUID   string `json:"uuid"`
+	After  uint64 `json:"after"`  // only return entries with greater IDs
+	Follow bool   `json:"follow"` // wait for new entries until the container finishes
+}
+
 type LoginOptions struct {
 	ReturnTo string `json:"return_to"`        // On success, redirect to this target with api_token=xxx query param
 	Remote   string `json:"remote,omitempty"` // Salt token for remote Cluster ID
@@ -146,14 +196,22 @@ type LoginOptions struct {
 }
 
 type UserAuthenticateOptions struct {
-	Username string `json:"username,omitempty"` // PAM username
-	Password string `json:"password,omitempty"` // PAM password
+	Username string `json:"username,omitempty"` // PAM or LDAP username
+	Password string `json:"password,omitempty"` // PAM or LDAP password
 }
 
 type LogoutOptions struct {
 	ReturnTo string `json:"return_to"` // Redirect to this URL after logging out
 }
 
+type DeviceAuthorizationTokenOptions struct {
+	DeviceCode string `json:"device_code"` // DeviceCode from DeviceAuthorizationCreate response
+}
+
+type DeviceAuthorizationVerifyOptions struct {
+	UserCode string `json:"user_code"` // UserCode displayed by the client device
+}
+
 type API interface {
 	ConfigGet(ctx context.Context) (json.RawMessage, error)
 	Login(ctx context.Context, options LoginOptions) (LoginResponse, error)
@@ -174,6 +232,26 @@ type API interface {
 	ContainerDelete(ctx context.Context, options DeleteOptions) (Container, error)
 	ContainerLock(ctx context.Context, options GetOptions) (Container, error)
 	ContainerUnlock(ctx context.Context, options GetOptions) (Container, error)
+	ContainerLog(ctx context.Context, options ContainerLogOptions) (http.Handler, error)
+	ContainerRequestCreate(ctx context.Context, options CreateOptions) (ContainerRequest, error)
+	ContainerRequestUpdate(ctx context.Context, options UpdateOptions) (ContainerRequest, error)
+	ContainerRequestGet(ctx context.Context, options GetOptions) (ContainerRequest, error)
+	ContainerRequestList(ctx context.Context, options ListOptions) (ContainerRequestList, error)
+	ContainerRequestDelete(ctx context.Context, options DeleteOptions) (ContainerRequest, error)
+	GroupCreate(ctx context.Context, options CreateOptions) (Group, error)
+	GroupUpdate(ctx context.Context, options UpdateOptions) (Group, error)
+	GroupGet(ctx context.Context, options GetOptions) (Group, error)
+	GroupList(ctx context.Context, options ListOptions) (GroupList, error)
+	GroupDelete(ctx context.Context, options DeleteOptions) (Group, error)
+	GroupContents(ctx context.Context, options GroupContentsOptions) (ObjectList, error)
+	GroupShared(ctx context.Context, options ListOptions) (GroupList, error)
+	GroupTrash(ctx context.Context, options DeleteOptions) (Group, error)
+	GroupUntrash(ctx context.Context, options UntrashOptions) (Group, error)
+	LinkCreate(ctx context.Context, options CreateOptions) (Link, error)
+	LinkUpdate(ctx context.Context, options UpdateOptions) (Link, error)
+	LinkGet(ctx context.Context, options GetOptions) (Link, error)
+	LinkList(ctx context.Context, options ListOptions) (LinkList, error)
+	LinkDelete(ctx context.Context, options DeleteOptions) (Link, error)
 	SpecimenCreate(ctx context.Context, options CreateOptions) (Specimen, error)
 	SpecimenUpdate(ctx context.Context, options UpdateOptions) (Specimen, error)
 	SpecimenGet(ctx context.Context, options GetOptions) (Specimen, error)
@@ -194,4 +272,8 @@ type API interface {
 	UserBatchUpdate(context.Context, UserBatchUpdateOptions) (UserList, error)
 	UserAuthenticate(ctx context.Context, options UserAuthenticateOptions) (APIClientAuthorization, error)
 	APIClientAuthorizationCurrent(ctx context.Context, options GetOptions) (APIClientAuthorization, error)
+	DeviceAuthorizationCreate(ctx context.Context) (DeviceAuthorization, error)
+	DeviceAuthorizationToken(ctx context.Context, options DeviceAuthorizationTokenOptions) (APIClientAuthorization, error)
+	DeviceAuthorizationVerify(ctx context.Context, options DeviceAuthorizationVerifyOptions) (LoginResponse, error)
+	DeviceAuthorizationApprove(ctx context.Context, options DeviceAuthorizationVerifyOptions) (LoginResponse, error)
 }
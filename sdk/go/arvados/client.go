@@ -377,9 +377,25 @@ type Resource struct {
 }
 
 type ResourceMethod struct {
-	HTTPMethod string         `json:"httpMethod"`
-	Path       string         `json:"path"`
-	Response   MethodResponse `json:"response"`
+	HTTPMethod  string                     `json:"httpMethod"`
+	Path        string                     `json:"path"`
+	Description string                     `json:"description"`
+	Parameters  map[string]MethodParameter `json:"parameters"`
+	Request     MethodRequest              `json:"request"`
+	Response    MethodResponse             `json:"response"`
+}
+
+type MethodParameter struct {
+	Type        string      `json:"type"`
+	Description string      `json:"description"`
+	Required    bool        `json:"required"`
+	Location    string      `json:"location"`
+	Default     interface{} `json:"default"`
+}
+
+type MethodRequest struct {
+	Required   bool                      `json:"required"`
+	Properties map[string]MethodResponse `json:"properties"`
 }
 
 type MethodResponse struct {
@@ -125,7 +125,9 @@ type Cluster struct {
 		BalanceCollectionBatch   int
 		BalanceCollectionBuffers int
 
-		WebDAVCache WebDAVCacheConfig
+		WebDAVCache          WebDAVCacheConfig
+		WebDAVLocks          string
+		WebDAVLockMaxTimeout Duration
 	}
 	Git struct {
 		GitCommand   string
@@ -143,6 +145,39 @@ type Cluster struct {
 		ProviderAppSecret             string
 		LoginCluster                  string
 		RemoteTokenRefresh            Duration
+		OpenIDConnect                 struct {
+			Enable             bool
+			Issuer             string
+			ClientID           string
+			ClientSecret       string
+			EmailClaim         string
+			EmailVerifiedClaim string
+			UsernameClaim      string
+			NameClaim          string
+			Scopes             []string
+		}
+		LDAP struct {
+			Enable             bool
+			URL                URL
+			StartTLS           bool
+			InsecureTLS        bool
+			StripDomain        string
+			AppendDomain       string
+			SearchAttribute    string
+			SearchBindUser     string
+			SearchBindPassword string
+			SearchBase         string
+			SearchFilters      string
+			EmailAttribute     string
+			UsernameAttribute  string
+		}
+		DeviceAuthorization struct {
+			Enable        bool
+			CodeLifetime  Duration
+			PollInterval  Duration
+			TokenLifetime Duration
+			TokenScopes   []string
+		}
 	}
 	Mail struct {
 		MailchimpAPIKey                string
@@ -180,8 +215,9 @@ type Cluster struct {
 		UserProfileNotificationAddress        string
 		PreferDomainForUsername               string
 	}
-	Volumes   map[string]Volume
-	Workbench struct {
+	StorageClasses map[string]StorageClassConfig
+	Volumes        map[string]Volume
+	Workbench      struct {
 		ActivationContactLink            string
 		APIClientConnectTimeout          Duration
 		APIClientReceiveTimeout          Duration
@@ -224,6 +260,11 @@ type Cluster struct {
 	ForceLegacyAPI14 bool
 }
 
+type StorageClassConfig struct {
+	DataShards   int
+	ParityShards int
+}
+
 type Volume struct {
 	AccessViaHosts   map[URL]VolumeAccess
 	ReadOnly         bool
@@ -231,6 +272,9 @@ type Volume struct {
 	StorageClasses   map[string]bool
 	Driver           string
 	DriverParameters json.RawMessage
+	Compression      string
+
+	CompressionSizeCache string
 }
 
 type S3VolumeDriverParameters struct {
@@ -355,11 +399,19 @@ type ContainersConfig struct {
 	MaxDispatchAttempts         int
 	MaxRetryAttempts            int
 	MinRetryPeriod              Duration
+	PreemptibleFallbackAttempts int
+	PreemptibleFallbackDelay    Duration
 	ReserveExtraRAM             ByteSize
 	StaleLockTimeout            Duration
 	SupportedDockerImageFormats StringSet
 	UsePreemptibleInstances     bool
 
+	FairShare struct {
+		Enable        bool
+		UsageHalfLife Duration
+		DefaultShare  float64
+		Shares        map[string]float64
+	}
 	JobsAPI struct {
 		Enable         string
 		GitInternalDir string
@@ -376,6 +428,11 @@ type ContainersConfig struct {
 		LogUpdatePeriod              Duration
 		LogUpdateSize                ByteSize
 	}
+	Quotas struct {
+		PerUser    ContainerQuota
+		PerProject ContainerQuota
+		Overrides  map[string]ContainerQuota
+	}
 	SLURM struct {
 		PrioritySpread             int64
 		SbatchArgumentsList        []string
@@ -392,6 +449,14 @@ type ContainersConfig struct {
 	}
 }
 
+// ContainerQuota limits the resources that can be used at once by
+// containers belonging to a single user or project. Zero means no
+// limit.
+type ContainerQuota struct {
+	MaxContainers int
+	MaxVCPUs      int
+}
+
 type CloudVMsConfig struct {
 	Enable bool
 
@@ -413,6 +478,8 @@ type CloudVMsConfig struct {
 	ResourceTags         map[string]string
 	TagKeyPrefix         string
 
+	MaxContainersPerInstance int
+
 	Driver           string
 	DriverParameters json.RawMessage
 }
@@ -28,9 +28,10 @@ type Container struct {
 	SchedulingParameters SchedulingParameters   `json:"scheduling_parameters"`
 	ExitCode             int                    `json:"exit_code"`
 	RuntimeStatus        map[string]interface{} `json:"runtime_status"`
+	Checkpoint           string                 `json:"checkpoint"`
 }
 
-// Container is an arvados#container resource.
+// ContainerRequest is an arvados#container_request resource.
 type ContainerRequest struct {
 	UUID                    string                 `json:"uuid"`
 	OwnerUUID               string                 `json:"owner_uuid"`
@@ -47,6 +48,7 @@ type ContainerRequest struct {
 	State                   ContainerRequestState  `json:"state"`
 	RequestingContainerUUID string                 `json:"requesting_container_uuid"`
 	ContainerUUID           string                 `json:"container_uuid"`
+	ContainerCount          int                    `json:"container_count"`
 	ContainerCountMax       int                    `json:"container_count_max"`
 	Mounts                  map[string]Mount       `json:"mounts"`
 	RuntimeConstraints      RuntimeConstraints     `json:"runtime_constraints"`
@@ -63,6 +65,8 @@ type ContainerRequest struct {
 	LogUUID                 string                 `json:"log_uuid"`
 	OutputUUID              string                 `json:"output_uuid"`
 	RuntimeToken            string                 `json:"runtime_token"`
+	ExpiresAt               *time.Time             `json:"expires_at"`
+	Filters                 string                 `json:"filters"`
 }
 
 // Mount is special behavior to attach to a filesystem path or device.
@@ -106,6 +110,14 @@ type ContainerList struct {
 	Limit          int         `json:"limit"`
 }
 
+// ContainerRequestList is an arvados#containerRequestList resource.
+type ContainerRequestList struct {
+	Items          []ContainerRequest `json:"items"`
+	ItemsAvailable int                `json:"items_available"`
+	Offset         int                `json:"offset"`
+	Limit          int                `json:"limit"`
+}
+
 // ContainerState is a string corresponding to a valid Container state.
 type ContainerState string
 
@@ -16,6 +16,7 @@ type CustomFileSystem interface {
 	MountByID(mount string)
 	MountProject(mount, uuid string)
 	MountUsers(mount string)
+	MountTmp(mount string) (CollectionFileSystem, error)
 	ForwardSlashNameSubstitution(string)
 }
 
@@ -97,6 +98,25 @@ func (fs *customFileSystem) MountUsers(mount string) {
 	})
 }
 
+// MountTmp adds a writable directory backed by a new empty
+// collection. The collection is not saved: the caller can use the
+// returned CollectionFileSystem to get its manifest text.
+func (fs *customFileSystem) MountTmp(mount string) (CollectionFileSystem, error) {
+	cfs, err := (&Collection{}).FileSystem(fs, fs)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fs.root.inode.Child(mount, func(inode) (inode, error) {
+		root := cfs.rootnode()
+		root.SetParent(fs.root, mount)
+		return root, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cfs, nil
+}
+
 func (fs *customFileSystem) ForwardSlashNameSubstitution(repl string) {
 	fs.forwardSlashNameSubstitution = repl
 }
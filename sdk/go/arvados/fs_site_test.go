@@ -109,3 +109,24 @@ func (s *SiteFSSuite) TestByUUIDAndPDH(c *check.C) {
 	err = s.fs.Rename("/by_id", "/beep")
 	c.Check(err, check.Equals, ErrInvalidArgument)
 }
+
+func (s *SiteFSSuite) TestMountTmp(c *check.C) {
+	cfs, err := s.fs.MountTmp("tmp0")
+	c.Assert(err, check.IsNil)
+
+	err = s.fs.Mkdir("/tmp0/dir", 0755)
+	c.Assert(err, check.IsNil)
+	f, err := s.fs.OpenFile("/tmp0/dir/foo", os.O_CREATE|os.O_WRONLY, 0644)
+	c.Assert(err, check.IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Check(err, check.IsNil)
+	c.Check(f.Close(), check.IsNil)
+
+	fi, err := cfs.Stat("dir/foo")
+	c.Assert(err, check.IsNil)
+	c.Check(fi.Size(), check.Equals, int64(3))
+
+	txt, err := cfs.MarshalManifest(".")
+	c.Check(err, check.IsNil)
+	c.Check(txt, check.Matches, `\./dir acbd18db4cc2f85cedef654fccc4a4d8\+3\S* 0:3:foo\n`)
+}
@@ -4,20 +4,45 @@
 
 package arvados
 
+import "time"
+
 // Group is an arvados#group record
 type Group struct {
-	UUID       string `json:"uuid"`
-	Name       string `json:"name"`
-	OwnerUUID  string `json:"owner_uuid"`
-	GroupClass string `json:"group_class"`
+	UUID                 string                 `json:"uuid"`
+	Etag                 string                 `json:"etag"`
+	Name                 string                 `json:"name"`
+	OwnerUUID            string                 `json:"owner_uuid"`
+	GroupClass           string                 `json:"group_class"`
+	Description          string                 `json:"description"`
+	CreatedAt            time.Time              `json:"created_at"`
+	ModifiedAt           time.Time              `json:"modified_at"`
+	ModifiedByClientUUID string                 `json:"modified_by_client_uuid"`
+	ModifiedByUserUUID   string                 `json:"modified_by_user_uuid"`
+	TrashAt              *time.Time             `json:"trash_at"`
+	DeleteAt             *time.Time             `json:"delete_at"`
+	IsTrashed            bool                   `json:"is_trashed"`
+	Properties           map[string]interface{} `json:"properties"`
+	WritableBy           []string               `json:"writable_by,omitempty"`
 }
 
 // GroupList is an arvados#groupList resource.
 type GroupList struct {
-	Items          []Group `json:"items"`
-	ItemsAvailable int     `json:"items_available"`
-	Offset         int     `json:"offset"`
-	Limit          int     `json:"limit"`
+	Items          []Group       `json:"items"`
+	ItemsAvailable int           `json:"items_available"`
+	Offset         int           `json:"offset"`
+	Limit          int           `json:"limit"`
+	Included       []interface{} `json:"included,omitempty"` // groups#shared with include=owner_uuid
+}
+
+// ObjectList is an arvados#objectList resource, as returned by the
+// groups#contents API. Items may be of any type, so they are
+// returned as generic maps.
+type ObjectList struct {
+	Included       []interface{} `json:"included"`
+	Items          []interface{} `json:"items"`
+	ItemsAvailable int           `json:"items_available"`
+	Offset         int           `json:"offset"`
+	Limit          int           `json:"limit"`
 }
 
 func (g Group) resourceName() string {
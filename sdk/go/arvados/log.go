@@ -27,3 +27,12 @@ type LogList struct {
 	Offset         int   `json:"offset"`
 	Limit          int   `json:"limit"`
 }
+
+// ContainerLogEvent is one entry in a container log stream (see
+// EndpointContainerLog), which is sent as newline-delimited JSON.
+type ContainerLogEvent struct {
+	ID        uint64     `json:"id"`
+	EventType string     `json:"event_type"`
+	CreatedAt *time.Time `json:"created_at"`
+	Text      string     `json:"text"`
+}
@@ -33,3 +33,16 @@ func (resp LogoutResponse) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Location", resp.RedirectLocation)
 	w.WriteHeader(http.StatusFound)
 }
+
+// DeviceAuthorization is returned when a client device starts a
+// device authorization flow (see RFC 8628). The client displays
+// UserCode and VerificationURI to the user, then polls the token
+// endpoint with DeviceCode until the user approves the request.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"` // seconds
+	Interval                int    `json:"interval"`   // seconds to wait between polling requests
+}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"net/http"
 	"net/url"
 	"reflect"
 	"runtime"
@@ -105,6 +106,86 @@ func (as *APIStub) ContainerUnlock(ctx context.Context, options arvados.GetOptio
 	as.appendCall(as.ContainerUnlock, ctx, options)
 	return arvados.Container{}, as.Error
 }
+func (as *APIStub) ContainerLog(ctx context.Context, options arvados.ContainerLogOptions) (http.Handler, error) {
+	as.appendCall(as.ContainerLog, ctx, options)
+	return http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), as.Error
+}
+func (as *APIStub) ContainerRequestCreate(ctx context.Context, options arvados.CreateOptions) (arvados.ContainerRequest, error) {
+	as.appendCall(as.ContainerRequestCreate, ctx, options)
+	return arvados.ContainerRequest{}, as.Error
+}
+func (as *APIStub) ContainerRequestUpdate(ctx context.Context, options arvados.UpdateOptions) (arvados.ContainerRequest, error) {
+	as.appendCall(as.ContainerRequestUpdate, ctx, options)
+	return arvados.ContainerRequest{}, as.Error
+}
+func (as *APIStub) ContainerRequestGet(ctx context.Context, options arvados.GetOptions) (arvados.ContainerRequest, error) {
+	as.appendCall(as.ContainerRequestGet, ctx, options)
+	return arvados.ContainerRequest{}, as.Error
+}
+func (as *APIStub) ContainerRequestList(ctx context.Context, options arvados.ListOptions) (arvados.ContainerRequestList, error) {
+	as.appendCall(as.ContainerRequestList, ctx, options)
+	return arvados.ContainerRequestList{}, as.Error
+}
+func (as *APIStub) ContainerRequestDelete(ctx context.Context, options arvados.DeleteOptions) (arvados.ContainerRequest, error) {
+	as.appendCall(as.ContainerRequestDelete, ctx, options)
+	return arvados.ContainerRequest{}, as.Error
+}
+func (as *APIStub) GroupCreate(ctx context.Context, options arvados.CreateOptions) (arvados.Group, error) {
+	as.appendCall(as.GroupCreate, ctx, options)
+	return arvados.Group{}, as.Error
+}
+func (as *APIStub) GroupUpdate(ctx context.Context, options arvados.UpdateOptions) (arvados.Group, error) {
+	as.appendCall(as.GroupUpdate, ctx, options)
+	return arvados.Group{}, as.Error
+}
+func (as *APIStub) GroupGet(ctx context.Context, options arvados.GetOptions) (arvados.Group, error) {
+	as.appendCall(as.GroupGet, ctx, options)
+	return arvados.Group{}, as.Error
+}
+func (as *APIStub) GroupList(ctx context.Context, options arvados.ListOptions) (arvados.GroupList, error) {
+	as.appendCall(as.GroupList, ctx, options)
+	return arvados.GroupList{}, as.Error
+}
+func (as *APIStub) GroupDelete(ctx context.Context, options arvados.DeleteOptions) (arvados.Group, error) {
+	as.appendCall(as.GroupDelete, ctx, options)
+	return arvados.Group{}, as.Error
+}
+func (as *APIStub) GroupContents(ctx context.Context, options arvados.GroupContentsOptions) (arvados.ObjectList, error) {
+	as.appendCall(as.GroupContents, ctx, options)
+	return arvados.ObjectList{}, as.Error
+}
+func (as *APIStub) GroupShared(ctx context.Context, options arvados.ListOptions) (arvados.GroupList, error) {
+	as.appendCall(as.GroupShared, ctx, options)
+	return arvados.GroupList{}, as.Error
+}
+func (as *APIStub) GroupTrash(ctx context.Context, options arvados.DeleteOptions) (arvados.Group, error) {
+	as.appendCall(as.GroupTrash, ctx, options)
+	return arvados.Group{}, as.Error
+}
+func (as *APIStub) GroupUntrash(ctx context.Context, options arvados.UntrashOptions) (arvados.Group, error) {
+	as.appendCall(as.GroupUntrash, ctx, options)
+	return arvados.Group{}, as.Error
+}
+func (as *APIStub) LinkCreate(ctx context.Context, options arvados.CreateOptions) (arvados.Link, error) {
+	as.appendCall(as.LinkCreate, ctx, options)
+	return arvados.Link{}, as.Error
+}
+func (as *APIStub) LinkUpdate(ctx context.Context, options arvados.UpdateOptions) (arvados.Link, error) {
+	as.appendCall(as.LinkUpdate, ctx, options)
+	return arvados.Link{}, as.Error
+}
+func (as *APIStub) LinkGet(ctx context.Context, options arvados.GetOptions) (arvados.Link, error) {
+	as.appendCall(as.LinkGet, ctx, options)
+	return arvados.Link{}, as.Error
+}
+func (as *APIStub) LinkList(ctx context.Context, options arvados.ListOptions) (arvados.LinkList, error) {
+	as.appendCall(as.LinkList, ctx, options)
+	return arvados.LinkList{}, as.Error
+}
+func (as *APIStub) LinkDelete(ctx context.Context, options arvados.DeleteOptions) (arvados.Link, error) {
+	as.appendCall(as.LinkDelete, ctx, options)
+	return arvados.Link{}, as.Error
+}
 func (as *APIStub) SpecimenCreate(ctx context.Context, options arvados.CreateOptions) (arvados.Specimen, error) {
 	as.appendCall(as.SpecimenCreate, ctx, options)
 	return arvados.Specimen{}, as.Error
@@ -185,6 +266,22 @@ func (as *APIStub) APIClientAuthorizationCurrent(ctx context.Context, options ar
 	as.appendCall(as.APIClientAuthorizationCurrent, ctx, options)
 	return arvados.APIClientAuthorization{}, as.Error
 }
+func (as *APIStub) DeviceAuthorizationCreate(ctx context.Context) (arvados.DeviceAuthorization, error) {
+	as.appendCall(as.DeviceAuthorizationCreate, ctx, nil)
+	return arvados.DeviceAuthorization{}, as.Error
+}
+func (as *APIStub) DeviceAuthorizationToken(ctx context.Context, options arvados.DeviceAuthorizationTokenOptions) (arvados.APIClientAuthorization, error) {
+	as.appendCall(as.DeviceAuthorizationToken, ctx, options)
+	return arvados.APIClientAuthorization{}, as.Error
+}
+func (as *APIStub) DeviceAuthorizationVerify(ctx context.Context, options arvados.DeviceAuthorizationVerifyOptions) (arvados.LoginResponse, error) {
+	as.appendCall(as.DeviceAuthorizationVerify, ctx, options)
+	return arvados.LoginResponse{}, as.Error
+}
+func (as *APIStub) DeviceAuthorizationApprove(ctx context.Context, options arvados.DeviceAuthorizationVerifyOptions) (arvados.LoginResponse, error) {
+	as.appendCall(as.DeviceAuthorizationApprove, ctx, options)
+	return arvados.LoginResponse{}, as.Error
+}
 
 func (as *APIStub) appendCall(method interface{}, ctx context.Context, options interface{}) {
 	as.mtx.Lock()
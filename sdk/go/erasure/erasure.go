@@ -0,0 +1,275 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package erasure implements the Reed-Solomon erasure coding used to
+// store Keep blocks as shards in erasure-coded storage classes.
+//
+// A block encoded with scheme k+m is split into k data shards, and m
+// parity shards are computed, such that the block can be recovered
+// from any k of the k+m shards. Each shard is stored as an ordinary
+// object on a keepstore volume, under a locator derived from the
+// block locator, the scheme, and the shard index (see
+// ShardLocator). Since that locator is not the MD5 hash of the
+// shard's content, each stored shard starts with a header that
+// identifies the block it belongs to and carries a checksum of the
+// shard data.
+package erasure
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+)
+
+// HeaderSize is the size of the header at the start of each stored
+// shard.
+const HeaderSize = 44
+
+var (
+	shardMagic = []byte("\x00KEC")
+
+	ErrTooFewShards = errors.New("not enough shards available to reconstruct block")
+	ErrBadShard     = errors.New("invalid shard")
+)
+
+// Scheme is an erasure coding scheme with DataShards data shards and
+// ParityShards parity shards.
+type Scheme struct {
+	DataShards   int
+	ParityShards int
+}
+
+// Validate returns an error if the scheme cannot be used.
+func (s Scheme) Validate() error {
+	if s.DataShards < 1 || s.ParityShards < 1 {
+		return fmt.Errorf("invalid erasure coding scheme %s: DataShards and ParityShards must be at least 1", s)
+	}
+	if s.Shards() > 256 {
+		return fmt.Errorf("invalid erasure coding scheme %s: DataShards+ParityShards must not exceed 256", s)
+	}
+	return nil
+}
+
+// Shards returns the total number of shards.
+func (s Scheme) Shards() int {
+	return s.DataShards + s.ParityShards
+}
+
+// String returns the scheme in "k+m" form.
+func (s Scheme) String() string {
+	return fmt.Sprintf("%d+%d", s.DataShards, s.ParityShards)
+}
+
+// ParseScheme parses a scheme in "k+m" form.
+func ParseScheme(str string) (Scheme, error) {
+	var s Scheme
+	parts := strings.Split(str, "+")
+	if len(parts) != 2 {
+		return s, fmt.Errorf("invalid erasure coding scheme %q", str)
+	}
+	var err error
+	if s.DataShards, err = strconv.Atoi(parts[0]); err != nil {
+		return s, fmt.Errorf("invalid erasure coding scheme %q", str)
+	}
+	if s.ParityShards, err = strconv.Atoi(parts[1]); err != nil {
+		return s, fmt.Errorf("invalid erasure coding scheme %q", str)
+	}
+	return s, s.Validate()
+}
+
+// payloadSize returns the size of each shard's data (excluding the
+// header) for a block of the given size.
+func (s Scheme) payloadSize(blockSize int64) int64 {
+	return (blockSize + int64(s.DataShards) - 1) / int64(s.DataShards)
+}
+
+// ShardLocator returns the locator (hash+size) under which shard i
+// of the given block is stored.
+func (s Scheme) ShardLocator(blkid arvados.SizedDigest, i int) arvados.SizedDigest {
+	sum := md5.Sum([]byte(fmt.Sprintf("%s/erasure/%s/%d", blkid, s, i)))
+	return arvados.SizedDigest(fmt.Sprintf("%x+%d", sum, HeaderSize+s.payloadSize(blkid.Size())))
+}
+
+// Header identifies the block and scheme a stored shard belongs to.
+type Header struct {
+	Scheme
+	Index int
+	Block arvados.SizedDigest
+}
+
+// ParseShard checks the header and checksum of a stored shard, and
+// returns the header and the shard data.
+func ParseShard(shard []byte) (Header, []byte, error) {
+	var h Header
+	if len(shard) < HeaderSize || !bytes.Equal(shard[:4], shardMagic) || shard[4] != 1 {
+		return h, nil, ErrBadShard
+	}
+	h.DataShards = int(shard[5])
+	h.ParityShards = int(shard[6])
+	h.Index = int(shard[7])
+	h.Block = arvados.SizedDigest(fmt.Sprintf("%x+%d", shard[8:24], binary.BigEndian.Uint32(shard[24:28])))
+	payload := shard[HeaderSize:]
+	if sum := md5.Sum(payload); !bytes.Equal(sum[:], shard[28:44]) {
+		return h, nil, ErrBadShard
+	}
+	if h.Index >= h.Shards() || int64(len(payload)) != h.payloadSize(h.Block.Size()) {
+		return h, nil, ErrBadShard
+	}
+	return h, payload, nil
+}
+
+func (s Scheme) header(blkid arvados.SizedDigest, i int, payload []byte) ([]byte, error) {
+	hash, err := hex.DecodeString(string(blkid[:32]))
+	if err != nil || len(hash) != md5.Size {
+		return nil, fmt.Errorf("invalid block locator %q", blkid)
+	}
+	buf := make([]byte, HeaderSize, HeaderSize+len(payload))
+	copy(buf, shardMagic)
+	buf[4] = 1
+	// Shards() <= 256 and DataShards, ParityShards >= 1, so each
+	// of these fits in a byte.
+	buf[5] = byte(s.DataShards)
+	buf[6] = byte(s.ParityShards)
+	buf[7] = byte(i)
+	copy(buf[8:24], hash)
+	binary.BigEndian.PutUint32(buf[24:28], uint32(blkid.Size()))
+	sum := md5.Sum(payload)
+	copy(buf[28:44], sum[:])
+	return buf, nil
+}
+
+// dataShards returns the block split into DataShards equal-sized
+// pieces, padding the last ones with zeros if needed.
+func (s Scheme) dataShards(block []byte) [][]byte {
+	size := int(s.payloadSize(int64(len(block))))
+	padded := block
+	if size*s.DataShards > len(block) {
+		padded = make([]byte, size*s.DataShards)
+		copy(padded, block)
+	}
+	data := make([][]byte, s.DataShards)
+	for i := range data {
+		data[i] = padded[i*size : (i+1)*size]
+	}
+	return data
+}
+
+// EncodeShard returns shard i of the given block, including the
+// header, ready to be stored under ShardLocator(blkid, i).
+func (s Scheme) EncodeShard(blkid arvados.SizedDigest, block []byte, i int) ([]byte, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if i < 0 || i >= s.Shards() {
+		return nil, fmt.Errorf("shard index %d out of range for scheme %s", i, s)
+	}
+	if int64(len(block)) != blkid.Size() {
+		return nil, fmt.Errorf("block size %d does not match locator %s", len(block), blkid)
+	}
+	data := s.dataShards(block)
+	var payload []byte
+	if i < s.DataShards {
+		payload = data[i]
+	} else {
+		payload = make([]byte, len(data[0]))
+		row := encodingMatrix(s.DataShards, s.ParityShards)[i]
+		for c, coef := range row {
+			mulAdd(payload, coef, data[c])
+		}
+	}
+	hdr, err := s.header(blkid, i, payload)
+	if err != nil {
+		return nil, err
+	}
+	return append(hdr, payload...), nil
+}
+
+// Encode returns all shards of the given block.
+func (s Scheme) Encode(blkid arvados.SizedDigest, block []byte) ([][]byte, error) {
+	shards := make([][]byte, s.Shards())
+	for i := range shards {
+		var err error
+		shards[i], err = s.EncodeShard(blkid, block, i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return shards, nil
+}
+
+// Decode reconstructs a block from its stored shards. Missing shards
+// are nil. Shards that are damaged or belong to a different block or
+// scheme are ignored. The MD5 hash of the reconstructed block is
+// checked against blkid.
+func (s Scheme) Decode(blkid arvados.SizedDigest, shards [][]byte) ([]byte, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	payloads := make([][]byte, s.Shards())
+	var avail []int
+	for i, shard := range shards {
+		if i >= s.Shards() || shard == nil {
+			continue
+		}
+		h, payload, err := ParseShard(shard)
+		if err != nil || h.Scheme != s || h.Index != i || h.Block != blkid {
+			continue
+		}
+		payloads[i] = payload
+		avail = append(avail, i)
+	}
+	if len(avail) < s.DataShards {
+		return nil, ErrTooFewShards
+	}
+	size := int(s.payloadSize(blkid.Size()))
+	data := payloads[:s.DataShards]
+	var missing []int
+	for i, p := range data {
+		if p == nil {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) > 0 {
+		// Use the first DataShards available shards. Each
+		// missing data shard is a linear combination of
+		// those, given by the corresponding row of the
+		// inverse of their encoding matrix rows.
+		enc := encodingMatrix(s.DataShards, s.ParityShards)
+		sub := make(matrix, s.DataShards)
+		for r, i := range avail[:s.DataShards] {
+			sub[r] = enc[i]
+		}
+		inv, err := sub.invert()
+		if err != nil {
+			return nil, err
+		}
+		recovered := make([][]byte, len(missing))
+		for j, i := range missing {
+			recovered[j] = make([]byte, size)
+			for r, src := range avail[:s.DataShards] {
+				mulAdd(recovered[j], inv[i][r], payloads[src])
+			}
+		}
+		data = append([][]byte(nil), data...)
+		for j, i := range missing {
+			data[i] = recovered[j]
+		}
+	}
+	block := make([]byte, 0, size*s.DataShards)
+	for _, p := range data {
+		block = append(block, p...)
+	}
+	block = block[:blkid.Size()]
+	if fmt.Sprintf("%x", md5.Sum(block)) != string(blkid[:32]) {
+		return nil, fmt.Errorf("checksum mismatch in reconstructed block %s", blkid)
+	}
+	return block, nil
+}
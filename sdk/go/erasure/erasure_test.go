@@ -0,0 +1,153 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package erasure
+
+import (
+	"crypto/md5"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&Suite{})
+
+type Suite struct{}
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+func blockAndLocator(size int) ([]byte, arvados.SizedDigest) {
+	block := make([]byte, size)
+	rand.Read(block)
+	return block, arvados.SizedDigest(fmt.Sprintf("%x+%d", md5.Sum(block), size))
+}
+
+func (s *Suite) TestValidate(c *check.C) {
+	for _, trial := range []struct {
+		scheme Scheme
+		ok     bool
+	}{
+		{Scheme{4, 2}, true},
+		{Scheme{1, 1}, true},
+		{Scheme{200, 56}, true},
+		{Scheme{0, 2}, false},
+		{Scheme{4, 0}, false},
+		{Scheme{200, 57}, false},
+	} {
+		err := trial.scheme.Validate()
+		c.Check(err == nil, check.Equals, trial.ok, check.Commentf("%s: %v", trial.scheme, err))
+	}
+	sch, err := ParseScheme("10+4")
+	c.Check(err, check.IsNil)
+	c.Check(sch, check.Equals, Scheme{10, 4})
+	for _, bad := range []string{"", "10", "10+", "a+b", "10+4+1", "0+4"} {
+		_, err = ParseScheme(bad)
+		c.Check(err, check.NotNil, check.Commentf("%q", bad))
+	}
+}
+
+// Any DataShards shards are enough to reconstruct the block.
+func (s *Suite) TestRoundTrip(c *check.C) {
+	for _, sch := range []Scheme{{1, 1}, {2, 1}, {4, 2}, {6, 3}, {10, 4}} {
+		for _, size := range []int{0, 1, 7, 1000, 65536 + 3} {
+			block, blkid := blockAndLocator(size)
+			shards, err := sch.Encode(blkid, block)
+			c.Assert(err, check.IsNil)
+			c.Assert(shards, check.HasLen, sch.Shards())
+			for i, shard := range shards {
+				c.Check(arvados.SizedDigest(fmt.Sprintf("%x+%d", md5.Sum(shard), len(shard))).Size(), check.Equals, sch.ShardLocator(blkid, i).Size())
+				h, _, err := ParseShard(shard)
+				c.Check(err, check.IsNil)
+				c.Check(h, check.Equals, Header{Scheme: sch, Index: i, Block: blkid})
+			}
+			for trial := 0; trial < 10; trial++ {
+				avail := make([][]byte, sch.Shards())
+				for _, i := range rand.Perm(sch.Shards())[:sch.DataShards] {
+					avail[i] = shards[i]
+				}
+				got, err := sch.Decode(blkid, avail)
+				c.Assert(err, check.IsNil, check.Commentf("%s size %d", sch, size))
+				c.Check(got, check.DeepEquals, block)
+			}
+		}
+	}
+}
+
+func (s *Suite) TestTooFewShards(c *check.C) {
+	sch := Scheme{4, 2}
+	block, blkid := blockAndLocator(12345)
+	shards, err := sch.Encode(blkid, block)
+	c.Assert(err, check.IsNil)
+	shards[0], shards[3], shards[5] = nil, nil, nil
+	_, err = sch.Decode(blkid, shards)
+	c.Check(err, check.Equals, ErrTooFewShards)
+}
+
+// Damaged shards, and shards belonging to other blocks, are ignored.
+func (s *Suite) TestBadShards(c *check.C) {
+	sch := Scheme{4, 2}
+	block, blkid := blockAndLocator(12345)
+	shards, err := sch.Encode(blkid, block)
+	c.Assert(err, check.IsNil)
+	otherBlock, otherBlkid := blockAndLocator(12345)
+	otherShards, err := sch.Encode(otherBlkid, otherBlock)
+	c.Assert(err, check.IsNil)
+
+	shards[0] = append([]byte(nil), shards[0]...)
+	shards[0][HeaderSize+10] ^= 1
+	_, _, err = ParseShard(shards[0])
+	c.Check(err, check.Equals, ErrBadShard)
+	shards[1] = otherShards[1]
+	shards[2], shards[3] = shards[3], shards[2]
+
+	_, err = sch.Decode(blkid, shards)
+	c.Check(err, check.Equals, ErrTooFewShards)
+	shards[2], shards[3] = shards[3], shards[2]
+	got, err := sch.Decode(blkid, shards)
+	c.Check(err, check.IsNil)
+	c.Check(got, check.DeepEquals, block)
+}
+
+func (s *Suite) TestShardLocator(c *check.C) {
+	sch := Scheme{4, 2}
+	blkid := arvados.SizedDigest("acbd18db4cc2f85cedef654fccc4a4d8+3")
+	seen := map[arvados.SizedDigest]bool{}
+	for i := 0; i < sch.Shards(); i++ {
+		loc := sch.ShardLocator(blkid, i)
+		c.Check(string(loc), check.Matches, `[0-9a-f]{32}\+45`)
+		c.Check(seen[loc], check.Equals, false)
+		seen[loc] = true
+	}
+	c.Check(Scheme{2, 2}.ShardLocator(blkid, 0), check.Not(check.Equals), sch.ShardLocator(blkid, 0))
+}
+
+func (s *Suite) TestInvert(c *check.C) {
+	enc := encodingMatrix(5, 3)
+	for trial := 0; trial < 20; trial++ {
+		sub := make(matrix, 5)
+		for r, i := range rand.Perm(8)[:5] {
+			sub[r] = enc[i]
+		}
+		inv, err := sub.invert()
+		c.Assert(err, check.IsNil)
+		for i := 0; i < 5; i++ {
+			for j := 0; j < 5; j++ {
+				var sum byte
+				for k := 0; k < 5; k++ {
+					sum ^= gfMul(inv[i][k], sub[k][j])
+				}
+				if i == j {
+					c.Check(sum, check.Equals, byte(1))
+				} else {
+					c.Check(sum, check.Equals, byte(0))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package erasure
+
+import "errors"
+
+// Arithmetic in GF(2^8), using the primitive polynomial
+// x^8+x^4+x^3+x^2+1 (0x11d) and generator 2.
+
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfInv returns the multiplicative inverse of a, which must not be
+// zero.
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// mulAdd sets dst[i] ^= c*src[i] for each i < len(src).
+func mulAdd(dst []byte, c byte, src []byte) {
+	switch c {
+	case 0:
+		return
+	case 1:
+		for i, b := range src {
+			dst[i] ^= b
+		}
+		return
+	}
+	var table [256]byte
+	for b := 1; b < 256; b++ {
+		table[b] = gfMul(c, byte(b))
+	}
+	dst = dst[:len(src)]
+	for i, b := range src {
+		dst[i] ^= table[b]
+	}
+}
+
+// matrix is a row-major matrix over GF(2^8).
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for r := range m {
+		m[r] = make([]byte, cols)
+	}
+	return m
+}
+
+// encodingMatrix returns the (data+parity)×data systematic encoding
+// matrix: the identity matrix on top of a Cauchy matrix. Every square
+// submatrix of a Cauchy matrix is invertible, so the original data
+// can be recovered from any data rows of the result.
+func encodingMatrix(data, parity int) matrix {
+	m := newMatrix(data+parity, data)
+	for r := 0; r < data; r++ {
+		m[r][r] = 1
+	}
+	for r := data; r < data+parity; r++ {
+		for c := 0; c < data; c++ {
+			m[r][c] = gfInv(byte(r) ^ byte(c))
+		}
+	}
+	return m
+}
+
+var errSingular = errors.New("matrix is singular")
+
+// invert returns the inverse of the square matrix m, using
+// Gauss-Jordan elimination. It does not modify m.
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	work := newMatrix(n, 2*n)
+	for r := range m {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+	for c := 0; c < n; c++ {
+		pivot := -1
+		for r := c; r < n; r++ {
+			if work[r][c] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errSingular
+		}
+		work[c], work[pivot] = work[pivot], work[c]
+		if inv := gfInv(work[c][c]); inv != 1 {
+			for i := range work[c] {
+				work[c][i] = gfMul(work[c][i], inv)
+			}
+		}
+		for r := 0; r < n; r++ {
+			if r != c && work[r][c] != 0 {
+				f := work[r][c]
+				for i := range work[r] {
+					work[r][i] ^= gfMul(f, work[c][i])
+				}
+			}
+		}
+	}
+	inv := make(matrix, n)
+	for r := range work {
+		inv[r] = work[r][n:]
+	}
+	return inv, nil
+}
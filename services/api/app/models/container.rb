@@ -76,6 +76,7 @@ class Container < ArvadosModel
     t.add :runtime_user_uuid
     t.add :runtime_auth_scopes
     t.add :lock_count
+    t.add :checkpoint
   end
 
   # Supported states for a container
@@ -457,7 +458,7 @@ class Container < ArvadosModel
 
   def validate_change
     permitted = [:state]
-    progress_attrs = [:progress, :runtime_status, :log, :output]
+    progress_attrs = [:progress, :runtime_status, :log, :output, :checkpoint]
     final_attrs = [:exit_code, :finished_at]
 
     if self.new_record?
@@ -465,7 +466,8 @@ class Container < ArvadosModel
                      :environment, :mounts, :output_path, :priority,
                      :runtime_constraints, :scheduling_parameters,
                      :secret_mounts, :runtime_token,
-                     :runtime_user_uuid, :runtime_auth_scopes)
+                     :runtime_user_uuid, :runtime_auth_scopes,
+                     :checkpoint)
     end
 
     case self.state
@@ -681,7 +683,10 @@ class Container < ArvadosModel
               secret_mounts: prev_secret_mounts,
               runtime_token: prev_runtime_token,
               runtime_user_uuid: self.runtime_user_uuid,
-              runtime_auth_scopes: self.runtime_auth_scopes
+              runtime_auth_scopes: self.runtime_auth_scopes,
+              # Let the retry resume from the last checkpoint
+              # saved by this container, if any.
+              checkpoint: self.checkpoint
             }
             c = Container.create! c_attrs
             retryable_requests.each do |cr|
@@ -0,0 +1,9 @@
+# Copyright (C) The Arvados Authors. All rights reserved.
+#
+# SPDX-License-Identifier: AGPL-3.0
+
+class AddContainerCheckpoint < ActiveRecord::Migration[5.0]
+  def change
+    add_column :containers, :checkpoint, :string, :limit => 255
+  end
+end
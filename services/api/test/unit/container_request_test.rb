@@ -768,6 +768,29 @@ class ContainerRequestTest < ActiveSupport::TestCase
     assert_not_equal cr2.container_uuid, cr.container_uuid
   end
 
+  test "Retry on container cancelled resumes from checkpoint" do
+    set_user_from_auth :active
+    cr = create_minimal_req!(priority: 1, state: "Committed", container_count_max: 2)
+    prev_container_uuid = cr.container_uuid
+    ckpt = collections(:collection_owned_by_active).portable_data_hash
+
+    act_as_system_user do
+      c = Container.find_by_uuid(cr.container_uuid)
+      assert_nil c.checkpoint
+      c.update_attributes!(state: Container::Locked)
+      c.update_attributes!(state: Container::Running)
+      c.update_attributes!(checkpoint: ckpt)
+      c.update_attributes!(state: Container::Cancelled)
+    end
+
+    cr.reload
+    assert_equal "Committed", cr.state
+    assert_not_equal prev_container_uuid, cr.container_uuid
+    c = Container.find_by_uuid(cr.container_uuid)
+    assert_equal Container::Queued, c.state
+    assert_equal ckpt, c.checkpoint
+  end
+
   test "Retry on container cancelled with runtime_token" do
     set_user_from_auth :spectator
     spec = api_client_authorizations(:active)
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/erasure"
 	"git.arvados.org/arvados.git/sdk/go/keepclient"
 	"github.com/sirupsen/logrus"
 )
@@ -41,6 +42,14 @@ type Balancer struct {
 
 	LostBlocksFile string
 
+	// If not empty, write a machine-readable report of the
+	// computed changes to this file (see Report).
+	ReportFile string
+
+	// Storage class configuration (used to identify
+	// erasure-coded classes)
+	StorageClasses map[string]arvados.StorageClassConfig
+
 	*BlockStateMap
 	KeepServices       map[string]*KeepService
 	DefaultReplication int
@@ -49,6 +58,7 @@ type Balancer struct {
 	classes       []string
 	mounts        int
 	mountsByClass map[string]map[*KeepMount]bool
+	erasure       map[string]erasure.Scheme // class => scheme
 	collScanned   int
 	serviceRoots  map[string]string
 	errors        []error
@@ -145,6 +155,12 @@ func (bal *Balancer) Run(client *arvados.Client, cluster *arvados.Cluster, runOp
 		}
 		lbFile = nil
 	}
+	if bal.ReportFile != "" {
+		err = bal.WriteReport(bal.ReportFile, runOptions)
+		if err != nil {
+			return
+		}
+	}
 	if runOptions.CommitPulls {
 		err = bal.CommitPulls(client)
 		if err != nil {
@@ -442,10 +458,11 @@ func (bal *Balancer) addCollection(coll arvados.Collection) error {
 		repl = *coll.ReplicationDesired
 	}
 	bal.Logger.Debugf("%v: %d block x%d", coll.UUID, len(blkids), repl)
-	// Pass pdh to IncreaseDesired only if LostBlocksFile is being
-	// written -- otherwise it's just a waste of memory.
+	// Pass pdh to IncreaseDesired only if LostBlocksFile or
+	// ReportFile is being written -- otherwise it's just a waste
+	// of memory.
 	pdh := ""
-	if bal.LostBlocksFile != "" {
+	if bal.LostBlocksFile != "" || bal.ReportFile != "" {
 		pdh = coll.PortableDataHash
 	}
 	bal.BlockStateMap.IncreaseDesired(pdh, coll.StorageClassesDesired, repl, blkids)
@@ -463,6 +480,7 @@ func (bal *Balancer) ComputeChangeSets() {
 	// pool of worker goroutines.
 	defer bal.time("changeset_compute", "wall clock time to compute changesets")()
 	bal.setupLookupTables()
+	bal.BlockStateMap.AddShards(bal.erasure)
 
 	type balanceTask struct {
 		blkid arvados.SizedDigest
@@ -502,6 +520,13 @@ func (bal *Balancer) setupLookupTables() {
 	bal.classes = defaultClasses
 	bal.mountsByClass = map[string]map[*KeepMount]bool{"default": {}}
 	bal.mounts = 0
+	bal.erasure = map[string]erasure.Scheme{}
+	for class, sc := range bal.StorageClasses {
+		scheme := erasure.Scheme{DataShards: sc.DataShards, ParityShards: sc.ParityShards}
+		if scheme.Validate() == nil {
+			bal.erasure[class] = scheme
+		}
+	}
 	for _, srv := range bal.KeepServices {
 		bal.serviceRoots[srv.UUID] = srv.UUID
 		for _, mnt := range srv.mounts {
@@ -560,6 +585,12 @@ type balanceResult struct {
 	lost       bool
 	blockState balancedBlockState
 	classState map[string]balancedBlockState
+
+	// The block is an erasure-coded shard of a desired block.
+	shard bool
+	// All shards of the block are stored in at least one
+	// erasure-coded storage class.
+	erasureCoded bool
 }
 
 type slot struct {
@@ -573,6 +604,17 @@ type slot struct {
 func (bal *Balancer) balanceBlock(blkid arvados.SizedDigest, blk *BlockState) balanceResult {
 	bal.Logger.Debugf("balanceBlock: %v %+v", blkid, blk)
 
+	if blk.ShardOf != nil {
+		// Shards are pulled and trashed as needed when
+		// balancing the block they belong to.
+		return balanceResult{
+			blk:        blk,
+			blkid:      blkid,
+			shard:      true,
+			blockState: balancedBlockState{needed: len(blk.Replicas)},
+		}
+	}
+
 	// Build a list of all slots (one per mounted volume).
 	slots := make([]slot, 0, bal.mounts)
 	for _, srv := range bal.KeepServices {
@@ -608,6 +650,9 @@ func (bal *Balancer) balanceBlock(blkid arvados.SizedDigest, blk *BlockState) ba
 	// won't want to trash any replicas.
 	underreplicated := false
 
+	source, fromShards := bal.pullSource(blk)
+	shardState := map[string]balancedBlockState{}
+
 	unsafeToDelete := make(map[int64]bool, len(slots))
 	for _, class := range bal.classes {
 		desired := blk.Desired[class]
@@ -615,6 +660,19 @@ func (bal *Balancer) balanceBlock(blkid arvados.SizedDigest, blk *BlockState) ba
 			continue
 		}
 
+		if scheme, ok := bal.erasure[class]; ok && blk.Shards[class] != nil {
+			// Erasure-coded class: the desired
+			// replication is achieved by storing all
+			// shards, instead of whole replicas. Until
+			// that's done, keep all replicas.
+			bbs := bal.balanceShards(blkid, class, scheme, blk.Shards[class], source, fromShards)
+			shardState[class] = bbs
+			if bbs.needed < scheme.Shards() {
+				underreplicated = true
+			}
+			continue
+		}
+
 		// Sort the slots by desirability.
 		sort.Slice(slots, func(i, j int) bool {
 			si, sj := slots[i], slots[j]
@@ -753,9 +811,19 @@ func (bal *Balancer) balanceBlock(blkid arvados.SizedDigest, blk *BlockState) ba
 	for _, class := range bal.classes {
 		classState[class] = computeBlockState(slots, bal.mountsByClass[class], len(blk.Replicas), blk.Desired[class])
 	}
+	erasureCoded := false
+	for class, bbs := range shardState {
+		classState[class] = bbs
+		if bbs.needed == bal.erasure[class].Shards() {
+			erasureCoded = true
+		}
+	}
 	blockState := computeBlockState(slots, nil, len(blk.Replicas), 0)
 
-	var lost bool
+	lost := source == nil && len(shardState) > 0
+	if !underreplicated && !lost {
+		bal.trashUnneededShards(blkid, blk)
+	}
 	var changes []string
 	for _, slot := range slots {
 		// TODO: request a Touch if Mtime is duplicated.
@@ -768,14 +836,15 @@ func (bal *Balancer) balanceBlock(blkid arvados.SizedDigest, blk *BlockState) ba
 				From:        slot.mnt,
 			})
 			change = changeTrash
-		case slot.repl == nil && slot.want && len(blk.Replicas) == 0:
+		case slot.repl == nil && slot.want && source == nil:
 			lost = true
 			change = changeNone
 		case slot.repl == nil && slot.want && !slot.mnt.ReadOnly:
 			slot.mnt.KeepService.AddPull(Pull{
 				SizedDigest: blkid,
-				From:        blk.Replicas[0].KeepMount.KeepService,
+				From:        source,
 				To:          slot.mnt,
+				FromShards:  fromShards,
 			})
 			change = changePull
 		case slot.repl != nil:
@@ -796,14 +865,167 @@ func (bal *Balancer) balanceBlock(blkid arvados.SizedDigest, blk *BlockState) ba
 		bal.Dumper.Printf("%s refs=%d needed=%d unneeded=%d pulling=%v %v %v", blkid, blk.RefCount, blockState.needed, blockState.unneeded, blockState.pulling, blk.Desired, changes)
 	}
 	return balanceResult{
-		blk:        blk,
-		blkid:      blkid,
-		lost:       lost,
-		blockState: blockState,
-		classState: classState,
+		blk:          blk,
+		blkid:        blkid,
+		lost:         lost,
+		blockState:   blockState,
+		classState:   classState,
+		erasureCoded: erasureCoded,
+	}
+}
+
+// pullSource returns a server that can supply the content of the
+// given block: one that has a replica if possible, otherwise one that
+// has a shard, if there are enough shards to reconstruct the block
+// (in which case fromShards is true). It returns nil if the block
+// cannot be retrieved.
+func (bal *Balancer) pullSource(blk *BlockState) (srv *KeepService, fromShards bool) {
+	if len(blk.Replicas) > 0 {
+		return blk.Replicas[0].KeepMount.KeepService, false
+	}
+	var classes []string
+	for class := range blk.Shards {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		shards := blk.Shards[class]
+		have := 0
+		for _, shard := range shards {
+			if len(shard.Replicas) > 0 {
+				srv = shard.Replicas[0].KeepMount.KeepService
+				have++
+			}
+		}
+		if have >= bal.erasure[class].DataShards {
+			return srv, true
+		}
+	}
+	return nil, false
+}
+
+// balanceShards adds pull requests for any shards of an erasure-coded
+// block that are not stored on a mount in the given storage class
+// yet, and returns the resulting state of the class, counting shards
+// instead of replicas.
+//
+// Missing shards are pulled to writable mounts in the storage class,
+// preferring servers and devices that don't already have a shard of
+// the same block, then rendezvous order of the shard locator.
+func (bal *Balancer) balanceShards(blkid arvados.SizedDigest, class string, scheme erasure.Scheme, shards []*BlockState, source *KeepService, fromShards bool) (bbs balancedBlockState) {
+	usedSrv := map[*KeepService]bool{}
+	usedDev := map[string]bool{}
+	var missing []int
+	for i, shard := range shards {
+		have := false
+		for _, repl := range shard.Replicas {
+			if bal.mountsByClass[class][repl.KeepMount] {
+				have = true
+				usedSrv[repl.KeepService] = true
+				usedDev[mountDevice(repl.KeepMount)] = true
+			}
+		}
+		if have {
+			bbs.needed++
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	var mnts []*KeepMount
+	for mnt := range bal.mountsByClass[class] {
+		if !mnt.ReadOnly {
+			mnts = append(mnts, mnt)
+		}
+	}
+	if source == nil || len(mnts) == 0 {
+		bbs.unachievable = true
+		return
+	}
+	for _, i := range missing {
+		shardid := scheme.ShardLocator(blkid, i)
+		sort.Slice(mnts, func(a, b int) bool {
+			ma, mb := mnts[a], mnts[b]
+			if ua, ub := usedSrv[ma.KeepService], usedSrv[mb.KeepService]; ua != ub {
+				return ub
+			} else if ua, ub := usedDev[mountDevice(ma)], usedDev[mountDevice(mb)]; ua != ub {
+				return ub
+			} else {
+				return rendezvousLess(ma.KeepService.UUID+ma.UUID, mb.KeepService.UUID+mb.UUID, shardid)
+			}
+		})
+		mnt := mnts[0]
+		mnt.KeepService.AddPull(Pull{
+			SizedDigest: blkid,
+			From:        source,
+			To:          mnt,
+			Shard:       &ErasureShard{Scheme: scheme, Index: i},
+			FromShards:  fromShards,
+		})
+		usedSrv[mnt.KeepService] = true
+		usedDev[mountDevice(mnt)] = true
+		bbs.pulling++
+	}
+	return
+}
+
+// trashUnneededShards adds trash requests for stored shards of the
+// given block that are not needed to satisfy its desired
+// erasure-coded storage classes, e.g., after the block has been moved
+// to a different storage class. It should only be called when the
+// desired storage classes are satisfied.
+func (bal *Balancer) trashUnneededShards(blkid arvados.SizedDigest, blk *BlockState) {
+	seen := map[*BlockState]bool{}
+	for class, shards := range blk.Shards {
+		for i, shard := range shards {
+			if seen[shard] {
+				// Classes with the same scheme share
+				// shards.
+				continue
+			}
+			seen[shard] = true
+			for _, repl := range shard.Replicas {
+				if repl.Mtime >= bal.MinMtime || bal.shardWanted(blk, shard, repl.KeepMount) {
+					continue
+				}
+				repl.KeepService.AddTrash(Trash{
+					SizedDigest: bal.erasure[class].ShardLocator(blkid, i),
+					Mtime:       repl.Mtime,
+					From:        repl.KeepMount,
+				})
+			}
+		}
 	}
 }
 
+// shardWanted returns true if a replica of the given shard on the
+// given mount helps satisfy one of the block's desired storage
+// classes.
+func (bal *Balancer) shardWanted(blk *BlockState, shard *BlockState, mnt *KeepMount) bool {
+	for class, shards := range blk.Shards {
+		if blk.Desired[class] == 0 || !bal.mountsByClass[class][mnt] {
+			continue
+		}
+		for _, s := range shards {
+			if s == shard {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mountDevice returns the device ID of the given mount, or its UUID
+// if the device ID is unknown.
+func mountDevice(mnt *KeepMount) string {
+	if mnt.DeviceID != "" {
+		return mnt.DeviceID
+	}
+	return mnt.UUID
+}
+
 func computeBlockState(slots []slot, onlyCount map[*KeepMount]bool, have, needRepl int) (bbs balancedBlockState) {
 	repl := 0
 	countedDev := map[string]bool{}
@@ -862,10 +1084,12 @@ type balancerStats struct {
 	justright     blocksNBytes
 	desired       blocksNBytes
 	current       blocksNBytes
+	shards        blocksNBytes
 	pulls         int
 	trashes       int
 	replHistogram []int
 	classStats    map[string]replicationStats
+	lostBlocks    []ReportLostBlock // only collected if ReportFile is set
 
 	// collectionBytes / collectionBlockBytes = deduplication ratio
 	collectionBytes      int64 // sum(bytes in referenced blocks) across all collections
@@ -895,6 +1119,18 @@ func (bal *Balancer) collectStatistics(results <-chan balanceResult) {
 	for result := range results {
 		bytes := result.blkid.Size()
 
+		if result.shard {
+			if n := result.blockState.needed; n > 0 {
+				s.shards.replicas += n
+				s.shards.blocks++
+				s.shards.bytes += bytes * int64(n)
+				s.current.replicas += n
+				s.current.blocks++
+				s.current.bytes += bytes * int64(n)
+			}
+			continue
+		}
+
 		if rc := int64(result.blk.RefCount); rc > 0 {
 			s.collectionBytes += rc * bytes
 			s.collectionBlockBytes += bytes
@@ -903,6 +1139,11 @@ func (bal *Balancer) collectStatistics(results <-chan balanceResult) {
 		}
 
 		for class, state := range result.classState {
+			bytes := bytes
+			if scheme, ok := bal.erasure[class]; ok && result.blk.Shards[class] != nil {
+				// Counting shards, not replicas.
+				bytes = scheme.ShardLocator(result.blkid, 0).Size()
+			}
 			cs := s.classStats[class]
 			if state.unachievable {
 				cs.unachievable.replicas++
@@ -938,6 +1179,14 @@ func (bal *Balancer) collectStatistics(results <-chan balanceResult) {
 				fmt.Fprintf(bal.lostBlocks, " %s", pdh)
 			}
 			fmt.Fprint(bal.lostBlocks, "\n")
+			if bal.ReportFile != "" {
+				lb := ReportLostBlock{Locator: string(result.blkid), Collections: []string{}}
+				for pdh := range result.blk.Refs {
+					lb.Collections = append(lb.Collections, pdh)
+				}
+				sort.Strings(lb.Collections)
+				s.lostBlocks = append(s.lostBlocks, lb)
+			}
 		case bs.pulling > 0:
 			s.underrep.replicas += bs.pulling
 			s.underrep.blocks++
@@ -946,6 +1195,12 @@ func (bal *Balancer) collectStatistics(results <-chan balanceResult) {
 			s.underrep.replicas++
 			s.underrep.blocks++
 			s.underrep.bytes += bytes
+		case bs.unneeded > 0 && result.erasureCoded:
+			// Whole replicas that are no longer needed
+			// because the block is stored as shards.
+			s.overrep.replicas += bs.unneeded
+			s.overrep.blocks++
+			s.overrep.bytes += bytes * int64(bs.unneeded)
 		case bs.unneeded > 0 && bs.needed == 0:
 			// Count as "garbage" if all replicas are old
 			// enough to trash, otherwise count as
@@ -1005,6 +1260,7 @@ func (bal *Balancer) PrintStatistics() {
 	bal.logf("%s overreplicated (have>want>0)", bal.stats.overrep)
 	bal.logf("%s unreferenced (have>want=0, new)", bal.stats.unref)
 	bal.logf("%s garbage (have>want=0, old)", bal.stats.garbage)
+	bal.logf("%s erasure-coded shards", bal.stats.shards)
 	for _, class := range bal.classes {
 		cs := bal.stats.classStats[class]
 		bal.logf("===")
@@ -431,6 +431,45 @@ func (s *runSuite) TestWriteLostBlocks(c *check.C) {
 	c.Check(string(lost), check.Equals, "37b51d194a7513e45b56f6524f2d51f2 fa7aeb5140e2848d39b416daeef4ffc5+45\n")
 }
 
+func (s *runSuite) TestWriteReport(c *check.C) {
+	tmpdir, err := ioutil.TempDir("", "keep-balance-report-test-")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(tmpdir)
+	opts := RunOptions{
+		ReportFile: tmpdir + "/report.json",
+		Logger:     ctxlog.TestLogger(c),
+	}
+	s.stub.serveCurrentUserAdmin()
+	s.stub.serveFooBarFileCollections()
+	s.stub.serveKeepServices(stubServices)
+	s.stub.serveKeepstoreMounts()
+	s.stub.serveKeepstoreIndexFoo1()
+	trashReqs := s.stub.serveKeepstoreTrash()
+	pullReqs := s.stub.serveKeepstorePull()
+	srv := s.newServer(&opts)
+	bal, err := srv.runOnce()
+	c.Check(err, check.IsNil)
+	c.Check(trashReqs.Count(), check.Equals, 0)
+	c.Check(pullReqs.Count(), check.Equals, 0)
+
+	rpt, err := loadReport(opts.ReportFile)
+	c.Assert(err, check.IsNil)
+	c.Check(rpt.CommitPulls, check.Equals, false)
+	c.Check(rpt.LostBlocks, check.DeepEquals, []ReportLostBlock{{
+		Locator:     "37b51d194a7513e45b56f6524f2d51f2+3",
+		Collections: []string{"fa7aeb5140e2848d39b416daeef4ffc5+45"},
+	}})
+	c.Check(rpt.Summary["lost"], check.Equals, ReportCount{Replicas: 1, Blocks: 1, Bytes: 3})
+	c.Check(rpt.StorageClasses["default"]["pulling"], check.Equals, reportCount(bal.stats.classStats["default"].pulling))
+	c.Check(rpt.Mounts, check.HasLen, 4)
+	pulls := 0
+	for _, rm := range rpt.Mounts {
+		c.Check(rm.UUID, check.Matches, `zzzzz-ivpuk-.*`)
+		pulls += len(rm.Pulls)
+	}
+	c.Check(pulls, check.Equals, bal.stats.pulls)
+}
+
 func (s *runSuite) TestDryRun(c *check.C) {
 	opts := RunOptions{
 		CommitPulls: false,
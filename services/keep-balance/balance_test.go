@@ -14,6 +14,7 @@ import (
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"git.arvados.org/arvados.git/sdk/go/erasure"
 	check "gopkg.in/check.v1"
 )
 
@@ -94,6 +95,7 @@ func (bal *balancerSuite) SetUpTest(c *check.C) {
 	}
 
 	bal.MinMtime = time.Now().UnixNano() - bal.signatureTTL*1e9
+	bal.StorageClasses = nil
 	bal.cleanupMounts()
 }
 
@@ -657,6 +659,165 @@ func (bal *balancerSuite) TestChangeStorageClasses(c *check.C) {
 		current: slots{0, 1}})
 }
 
+func (bal *balancerSuite) TestErasureCodedClass(c *check.C) {
+	// Servers 0-7 have one mount each with class "archive",
+	// servers 12-15 have class "archive2".
+	for _, srv := range bal.srvs[:8] {
+		srv.mounts[0].StorageClasses = map[string]bool{"archive": true}
+	}
+	for _, srv := range bal.srvs[12:] {
+		srv.mounts[0].StorageClasses = map[string]bool{"archive2": true}
+	}
+	bal.StorageClasses = map[string]arvados.StorageClassConfig{
+		"archive":  {DataShards: 4, ParityShards: 2},
+		"archive2": {DataShards: 2, ParityShards: 1},
+	}
+	scheme := erasure.Scheme{DataShards: 4, ParityShards: 2}
+	blkid := knownBlkid(0)
+	oldMtime := time.Now().UnixNano() - (bal.signatureTTL+86400)*1e9
+
+	// setup returns the state of a block with replicas on the
+	// given servers, and the given shards stored on the
+	// correspondingly numbered servers, along with the state of
+	// its shards.
+	class := "archive"
+	setup := func(replicaSrvs []int, haveShards ...int) (*BlockState, []*BlockState) {
+		bal.setupLookupTables()
+		for _, srv := range bal.srvs {
+			srv.ChangeSet = &ChangeSet{}
+		}
+		bal.BlockStateMap = NewBlockStateMap()
+		bal.BlockStateMap.IncreaseDesired("", []string{class}, 1, []arvados.SizedDigest{blkid})
+		for _, i := range replicaSrvs {
+			bal.BlockStateMap.AddReplicas(bal.srvs[i].mounts[0], []arvados.KeepServiceIndexEntry{{SizedDigest: blkid, Mtime: oldMtime}})
+		}
+		for _, i := range haveShards {
+			bal.BlockStateMap.AddReplicas(bal.srvs[i].mounts[0], []arvados.KeepServiceIndexEntry{{SizedDigest: scheme.ShardLocator(blkid, i), Mtime: oldMtime}})
+		}
+		bal.BlockStateMap.AddShards(bal.erasure)
+		blk := bal.BlockStateMap.get(blkid)
+		c.Assert(blk.Shards["archive"], check.HasLen, 6)
+		return blk, blk.Shards["archive"]
+	}
+	pulls := func() (pulls []Pull) {
+		for _, srv := range bal.srvs {
+			pulls = append(pulls, srv.Pulls...)
+		}
+		return
+	}
+	trashes := func() (trashes []Trash) {
+		for _, srv := range bal.srvs {
+			trashes = append(trashes, srv.Trashes...)
+		}
+		return
+	}
+
+	// Pull each shard to a different archive server, and keep
+	// the existing replicas until that's done.
+	blk, _ := setup([]int{10, 11})
+	result := bal.balanceBlock(blkid, blk)
+	c.Check(result.lost, check.Equals, false)
+	c.Check(result.erasureCoded, check.Equals, false)
+	c.Check(result.classState["archive"], check.Equals, balancedBlockState{pulling: 6})
+	c.Check(trashes(), check.HasLen, 0)
+	seenSrv := map[*KeepService]bool{}
+	seenIdx := map[int]bool{}
+	for _, pull := range pulls() {
+		c.Check(pull.SizedDigest, check.Equals, blkid)
+		c.Check(pull.To.StorageClasses["archive"], check.Equals, true)
+		c.Check(pull.FromShards, check.Equals, false)
+		c.Assert(pull.Shard, check.NotNil)
+		c.Check(pull.Shard.Scheme, check.Equals, scheme)
+		c.Check(seenSrv[pull.To.KeepService], check.Equals, false)
+		c.Check(seenIdx[pull.Shard.Index], check.Equals, false)
+		seenSrv[pull.To.KeepService] = true
+		seenIdx[pull.Shard.Index] = true
+	}
+	c.Check(seenIdx, check.HasLen, 6)
+
+	// Pull only the missing shards, avoiding servers that
+	// already have shards of the same block.
+	blk, _ = setup([]int{10}, 0, 1, 2, 3)
+	bal.balanceBlock(blkid, blk)
+	c.Check(trashes(), check.HasLen, 0)
+	c.Check(pulls(), check.HasLen, 2)
+	for _, pull := range pulls() {
+		c.Check(pull.Shard.Index >= 4, check.Equals, true)
+		c.Check(pull.From, check.Equals, bal.srvs[10])
+		for _, srv := range bal.srvs[:4] {
+			c.Check(pull.To.KeepService, check.Not(check.Equals), srv)
+		}
+	}
+
+	// Once all shards are stored, trash the whole replicas.
+	blk, shards := setup([]int{10, 11}, 0, 1, 2, 3, 4, 5)
+	result = bal.balanceBlock(blkid, blk)
+	c.Check(result.erasureCoded, check.Equals, true)
+	c.Check(result.classState["archive"], check.Equals, balancedBlockState{needed: 6})
+	c.Check(pulls(), check.HasLen, 0)
+	c.Check(trashes(), check.HasLen, 2)
+
+	// Shards are never trashed, even though they are not
+	// referenced by any collection.
+	for i, shard := range shards {
+		result = bal.balanceBlock(scheme.ShardLocator(blkid, i), shard)
+		c.Check(result.shard, check.Equals, true)
+		c.Check(result.blockState, check.Equals, balancedBlockState{needed: 1})
+	}
+	c.Check(trashes(), check.HasLen, 2)
+
+	// With no whole replicas left, missing shards are
+	// reconstructed from the others.
+	blk, _ = setup(nil, 0, 2, 3, 5)
+	result = bal.balanceBlock(blkid, blk)
+	c.Check(result.lost, check.Equals, false)
+	c.Check(result.classState["archive"], check.Equals, balancedBlockState{needed: 4, pulling: 2})
+	c.Check(pulls(), check.HasLen, 2)
+	for _, pull := range pulls() {
+		c.Check(pull.FromShards, check.Equals, true)
+		c.Check(pull.From == bal.srvs[0] || pull.From == bal.srvs[2] || pull.From == bal.srvs[3] || pull.From == bal.srvs[5], check.Equals, true)
+	}
+
+	// Not enough shards to reconstruct the block.
+	blk, _ = setup(nil, 0, 2, 3)
+	result = bal.balanceBlock(blkid, blk)
+	c.Check(result.lost, check.Equals, true)
+	c.Check(result.classState["archive"], check.Equals, balancedBlockState{needed: 3, unachievable: true})
+	c.Check(pulls(), check.HasLen, 0)
+
+	// After the block is moved to a different erasure-coded
+	// class, encode it using shards from the old class...
+	class = "archive2"
+	blk, _ = setup(nil, 0, 1, 2, 3, 4, 5)
+	result = bal.balanceBlock(blkid, blk)
+	c.Check(result.lost, check.Equals, false)
+	c.Check(result.classState["archive2"], check.Equals, balancedBlockState{pulling: 3})
+	c.Check(pulls(), check.HasLen, 3)
+	for _, pull := range pulls() {
+		c.Check(pull.FromShards, check.Equals, true)
+		c.Check(pull.Shard.Scheme, check.Equals, erasure.Scheme{DataShards: 2, ParityShards: 1})
+		c.Check(pull.To.StorageClasses["archive2"], check.Equals, true)
+	}
+	c.Check(trashes(), check.HasLen, 0)
+
+	// ...then trash the old shards.
+	blk, _ = setup(nil, 0, 1, 2, 3, 4, 5)
+	for i, srv := range bal.srvs[12:15] {
+		bal.BlockStateMap.AddReplicas(srv.mounts[0], []arvados.KeepServiceIndexEntry{{
+			SizedDigest: erasure.Scheme{DataShards: 2, ParityShards: 1}.ShardLocator(blkid, i),
+			Mtime:       oldMtime,
+		}})
+	}
+	result = bal.balanceBlock(blkid, blk)
+	c.Check(result.erasureCoded, check.Equals, true)
+	c.Check(pulls(), check.HasLen, 0)
+	c.Check(trashes(), check.HasLen, 6)
+	for _, trash := range trashes() {
+		c.Check(trash.From.StorageClasses["archive"], check.Equals, true)
+		c.Check(trash.SizedDigest, check.Not(check.Equals), blkid)
+	}
+}
+
 // Clear all servers' changesets, balance a single block, and verify
 // the appropriate changes for that block have been added to the
 // changesets.
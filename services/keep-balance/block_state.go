@@ -8,6 +8,7 @@ import (
 	"sync"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/erasure"
 )
 
 // Replica is a file on disk (or object in an S3 bucket, or blob in an
@@ -35,6 +36,14 @@ type BlockState struct {
 	// TODO: Use a pool of semantically distinct Desired maps to
 	// conserve memory (typically there are far more BlockState
 	// objects in memory than distinct Desired profiles).
+
+	// Shards of this block, for each erasure-coded storage class
+	// where it is desired (class => shard index => state). See
+	// (*BlockStateMap)AddShards.
+	Shards map[string][]*BlockState
+	// If this is an erasure-coded shard of a desired block,
+	// ShardOf is the state of that block.
+	ShardOf *BlockState
 }
 
 var defaultClasses = []string{"default"}
@@ -133,3 +142,50 @@ func (bsm *BlockStateMap) IncreaseDesired(pdh string, classes []string, n int, b
 		bsm.get(blkid).increaseDesired(pdh, classes, n)
 	}
 }
+
+// AddShards updates the map to track the erasure-coded shards of
+// each block, in each of the given storage classes (class => scheme)
+// where the block is desired. Shards of blocks that have no whole
+// replicas are tracked in all of the given classes, so they can be
+// used to reconstruct the block even after it is moved to a
+// different storage class.
+func (bsm *BlockStateMap) AddShards(schemes map[string]erasure.Scheme) {
+	if len(schemes) == 0 {
+		return
+	}
+	bsm.mutex.Lock()
+	defer bsm.mutex.Unlock()
+
+	type todo struct {
+		blkid   arvados.SizedDigest
+		blk     *BlockState
+		classes []string
+	}
+	var todos []todo
+	for blkid, blk := range bsm.entries {
+		if blk.ShardOf != nil {
+			continue
+		}
+		var classes []string
+		for class := range schemes {
+			if len(blk.Replicas) == 0 || blk.Desired[class] > 0 {
+				classes = append(classes, class)
+			}
+		}
+		if len(classes) > 0 {
+			todos = append(todos, todo{blkid, blk, classes})
+		}
+	}
+	for _, t := range todos {
+		t.blk.Shards = make(map[string][]*BlockState, len(t.classes))
+		for _, class := range t.classes {
+			scheme := schemes[class]
+			shards := make([]*BlockState, scheme.Shards())
+			for i := range shards {
+				shards[i] = bsm.get(scheme.ShardLocator(t.blkid, i))
+				shards[i].ShardOf = t.blk
+			}
+			t.blk.Shards[class] = shards
+		}
+	}
+}
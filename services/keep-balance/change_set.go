@@ -10,6 +10,7 @@ import (
 	"sync"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/erasure"
 )
 
 // Pull is a request to retrieve a block from a remote server, and
@@ -18,21 +19,54 @@ type Pull struct {
 	arvados.SizedDigest
 	From *KeepService
 	To   *KeepMount
+
+	// If not nil, store this erasure-coded shard of the block
+	// instead of the block itself.
+	Shard *ErasureShard
+
+	// The source server has no replica of the block, only
+	// shards, so it will need to reconstruct it.
+	FromShards bool
+}
+
+// ErasureShard identifies one shard of an erasure-coded block.
+type ErasureShard struct {
+	erasure.Scheme
+	Index int
 }
 
 // MarshalJSON formats a pull request the way keepstore wants to see
 // it.
 func (p Pull) MarshalJSON() ([]byte, error) {
+	type KeepstoreErasureShard struct {
+		DataShards   int `json:"data_shards"`
+		ParityShards int `json:"parity_shards"`
+		Index        int `json:"index"`
+	}
 	type KeepstorePullRequest struct {
-		Locator   string   `json:"locator"`
-		Servers   []string `json:"servers"`
-		MountUUID string   `json:"mount_uuid"`
+		Locator      string                 `json:"locator"`
+		Servers      []string               `json:"servers"`
+		MountUUID    string                 `json:"mount_uuid"`
+		ErasureShard *KeepstoreErasureShard `json:"erasure_shard,omitempty"`
 	}
-	return json.Marshal(KeepstorePullRequest{
+	req := KeepstorePullRequest{
 		Locator:   string(p.SizedDigest[:32]),
 		Servers:   []string{p.From.URLBase()},
 		MountUUID: p.To.KeepMount.UUID,
-	})
+	}
+	if p.FromShards {
+		// The source server needs the size hint to find
+		// the shards.
+		req.Locator = string(p.SizedDigest)
+	}
+	if p.Shard != nil {
+		req.ErasureShard = &KeepstoreErasureShard{
+			DataShards:   p.Shard.DataShards,
+			ParityShards: p.Shard.ParityShards,
+			Index:        p.Shard.Index,
+		}
+	}
+	return json.Marshal(req)
 }
 
 // Trash is a request to delete a block.
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/erasure"
 
 	check "gopkg.in/check.v1"
 )
@@ -35,6 +36,15 @@ func (s *changeSetSuite) TestJSONFormat(c *check.C) {
 	c.Check(err, check.IsNil)
 	c.Check(string(buf), check.Equals, `[{"locator":"acbd18db4cc2f85cedef654fccc4a4d8","servers":["http://keep1.zzzzz.arvadosapi.com:25107"],"mount_uuid":"zzzzz-mount-abcdefghijklmno"}]`)
 
+	buf, err = json.Marshal([]Pull{{
+		SizedDigest: arvados.SizedDigest("acbd18db4cc2f85cedef654fccc4a4d8+3"),
+		To:          mnt,
+		From:        srv,
+		Shard:       &ErasureShard{Scheme: erasure.Scheme{DataShards: 4, ParityShards: 2}, Index: 5},
+		FromShards:  true}})
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, `[{"locator":"acbd18db4cc2f85cedef654fccc4a4d8+3","servers":["http://keep1.zzzzz.arvadosapi.com:25107"],"mount_uuid":"zzzzz-mount-abcdefghijklmno","erasure_shard":{"data_shards":4,"parity_shards":2,"index":5}}]`)
+
 	buf, err = json.Marshal([]Trash{{
 		SizedDigest: arvados.SizedDigest("acbd18db4cc2f85cedef654fccc4a4d8+3"),
 		From:        mnt,
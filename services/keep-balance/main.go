@@ -25,6 +25,10 @@ func main() {
 }
 
 func runCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "diff" {
+		return diffCommand{}.RunCommand(prog+" diff", args[1:], stdin, stdout, stderr)
+	}
+
 	logger := ctxlog.FromContext(context.Background())
 
 	var options RunOptions
@@ -35,6 +39,8 @@ func runCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.W
 		"send pull requests (make more replicas of blocks that are underreplicated or are not in optimal rendezvous probe order)")
 	flags.BoolVar(&options.CommitTrash, "commit-trash", false,
 		"send trash requests (delete unreferenced old blocks, and excess replicas of overreplicated blocks)")
+	flags.StringVar(&options.ReportFile, "report", "",
+		"write a JSON report of the computed changes (pull/trash lists, per-class replication, lost blocks) to `path`; compare two reports with \"keep-balance diff old new\"")
 	flags.Bool("version", false, "Write version information to stdout and exit 0")
 	dumpFlag := flags.Bool("dump", false, "dump details for each block to stdout")
 
@@ -58,6 +64,7 @@ func runCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.W
 		"once":         true,
 		"commit-pulls": true,
 		"commit-trash": true,
+		"report":       true,
 		"dump":         true,
 	}
 	flags.Visit(func(f *flag.Flag) {
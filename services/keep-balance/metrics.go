@@ -64,6 +64,7 @@ func (m *metrics) UpdateStats(s balancerStats) {
 		"overreplicated":    {s.overrep, "overreplicated"},
 		"underreplicated":   {s.underrep, "underreplicated"},
 		"lost":              {s.lost, "lost"},
+		"shards":            {s.shards, "erasure-coded shards"},
 		"dedup_byte_ratio":  {s.dedupByteRatio(), "deduplication ratio, bytes referenced / bytes stored"},
 		"dedup_block_ratio": {s.dedupBlockRatio(), "deduplication ratio, blocks referenced / blocks stored"},
 	}
@@ -0,0 +1,390 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report is a machine-readable summary of the changes computed by a
+// balancing operation, written to the file given by the -report
+// flag.
+type Report struct {
+	Time        time.Time `json:"time"`
+	CommitPulls bool      `json:"commit_pulls"`
+	CommitTrash bool      `json:"commit_trash"`
+
+	// Summary counts, keyed by category ("lost",
+	// "underreplicated", etc.; see reportSummaryKeys)
+	Summary map[string]ReportCount `json:"summary"`
+
+	// Per-class counts (class => "needed", "unneeded",
+	// "pulling", or "unachievable" => count)
+	StorageClasses map[string]map[string]ReportCount `json:"storage_classes"`
+
+	// Pull and trash lists, sorted by mount UUID
+	Mounts []ReportMount `json:"mounts"`
+
+	// Blocks that are referenced by collections but cannot be
+	// retrieved from any keepstore server, sorted by locator
+	LostBlocks []ReportLostBlock `json:"lost_blocks"`
+}
+
+// ReportCount is the JSON form of blocksNBytes.
+type ReportCount struct {
+	Replicas int   `json:"replicas"`
+	Blocks   int   `json:"blocks"`
+	Bytes    int64 `json:"bytes"`
+}
+
+func (rc ReportCount) String() string {
+	return blocksNBytes{replicas: rc.Replicas, blocks: rc.Blocks, bytes: rc.Bytes}.String()
+}
+
+// ReportMount lists the changes computed for one mount.
+type ReportMount struct {
+	UUID           string        `json:"uuid"`
+	KeepService    string        `json:"keep_service"`
+	DeviceID       string        `json:"device_id"`
+	StorageClasses []string      `json:"storage_classes"`
+	Pulls          []ReportPull  `json:"pulls"`
+	Trashes        []ReportTrash `json:"trashes"`
+}
+
+// ReportPull is a pull request in a Report.
+type ReportPull struct {
+	Locator string `json:"locator"`
+	// UUID of the keep service to pull from
+	From string `json:"from"`
+	// Erasure-coded shard to store instead of the block, in
+	// "k+m/index" form
+	Shard string `json:"shard,omitempty"`
+}
+
+// ReportTrash is a trash request in a Report.
+type ReportTrash struct {
+	Locator string `json:"locator"`
+	Mtime   int64  `json:"mtime"`
+}
+
+// ReportLostBlock is a lost block in a Report, along with the
+// portable data hashes of the collections that reference it.
+type ReportLostBlock struct {
+	Locator     string   `json:"locator"`
+	Collections []string `json:"collections"`
+}
+
+var reportSummaryKeys = []string{
+	"lost",
+	"underreplicated",
+	"just_right",
+	"overreplicated",
+	"unreferenced",
+	"garbage",
+	"erasure_coded_shards",
+	"total_commitment",
+	"total_usage",
+}
+
+var reportClassKeys = []string{"needed", "unneeded", "pulling", "unachievable"}
+
+func reportCount(bb blocksNBytes) ReportCount {
+	return ReportCount{Replicas: bb.replicas, Blocks: bb.blocks, Bytes: bb.bytes}
+}
+
+// Report returns a report of the changes computed by
+// ComputeChangeSets. It should not be called until ComputeChangeSets
+// has finished.
+func (bal *Balancer) Report(runOptions RunOptions) *Report {
+	s := bal.stats
+	rpt := &Report{
+		Time:        time.Now().UTC(),
+		CommitPulls: runOptions.CommitPulls,
+		CommitTrash: runOptions.CommitTrash,
+		Summary: map[string]ReportCount{
+			"lost":                 reportCount(s.lost),
+			"underreplicated":      reportCount(s.underrep),
+			"just_right":           reportCount(s.justright),
+			"overreplicated":       reportCount(s.overrep),
+			"unreferenced":         reportCount(s.unref),
+			"garbage":              reportCount(s.garbage),
+			"erasure_coded_shards": reportCount(s.shards),
+			"total_commitment":     reportCount(s.desired),
+			"total_usage":          reportCount(s.current),
+		},
+		StorageClasses: map[string]map[string]ReportCount{},
+		LostBlocks:     s.lostBlocks,
+	}
+	for _, class := range bal.classes {
+		cs := s.classStats[class]
+		rpt.StorageClasses[class] = map[string]ReportCount{
+			"needed":       reportCount(cs.needed),
+			"unneeded":     reportCount(cs.unneeded),
+			"pulling":      reportCount(cs.pulling),
+			"unachievable": reportCount(cs.unachievable),
+		}
+	}
+	mounts := map[*KeepMount]*ReportMount{}
+	for _, srv := range bal.KeepServices {
+		for _, mnt := range srv.mounts {
+			rm := &ReportMount{
+				UUID:           mnt.UUID,
+				KeepService:    srv.UUID,
+				DeviceID:       mnt.DeviceID,
+				StorageClasses: []string{},
+				Pulls:          []ReportPull{},
+				Trashes:        []ReportTrash{},
+			}
+			for class := range mnt.StorageClasses {
+				rm.StorageClasses = append(rm.StorageClasses, class)
+			}
+			sort.Strings(rm.StorageClasses)
+			mounts[mnt] = rm
+		}
+		if srv.ChangeSet == nil {
+			continue
+		}
+		for _, p := range srv.ChangeSet.Pulls {
+			rp := ReportPull{Locator: string(p.SizedDigest), From: p.From.UUID}
+			if p.Shard != nil {
+				rp.Shard = fmt.Sprintf("%s/%d", p.Shard.Scheme, p.Shard.Index)
+			}
+			if rm := mounts[p.To]; rm != nil {
+				rm.Pulls = append(rm.Pulls, rp)
+			}
+		}
+		for _, t := range srv.ChangeSet.Trashes {
+			if rm := mounts[t.From]; rm != nil {
+				rm.Trashes = append(rm.Trashes, ReportTrash{Locator: string(t.SizedDigest), Mtime: t.Mtime})
+			}
+		}
+	}
+	for _, rm := range mounts {
+		sort.Slice(rm.Pulls, func(i, j int) bool {
+			if rm.Pulls[i].Locator != rm.Pulls[j].Locator {
+				return rm.Pulls[i].Locator < rm.Pulls[j].Locator
+			}
+			return rm.Pulls[i].Shard < rm.Pulls[j].Shard
+		})
+		sort.Slice(rm.Trashes, func(i, j int) bool {
+			return rm.Trashes[i].Locator < rm.Trashes[j].Locator
+		})
+		rpt.Mounts = append(rpt.Mounts, *rm)
+	}
+	sort.Slice(rpt.Mounts, func(i, j int) bool {
+		return rpt.Mounts[i].UUID < rpt.Mounts[j].UUID
+	})
+	if rpt.LostBlocks == nil {
+		rpt.LostBlocks = []ReportLostBlock{}
+	}
+	sort.Slice(rpt.LostBlocks, func(i, j int) bool {
+		return rpt.LostBlocks[i].Locator < rpt.LostBlocks[j].Locator
+	})
+	return rpt
+}
+
+// WriteReport writes a report of the changes computed by
+// ComputeChangeSets to the given file. The file is replaced
+// atomically, so readers never see a partially written report.
+func (bal *Balancer) WriteReport(path string, runOptions RunOptions) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", " ")
+	if err = enc.Encode(bal.Report(runOptions)); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func loadReport(path string) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rpt Report
+	err = json.NewDecoder(f).Decode(&rpt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &rpt, nil
+}
+
+// diffCommand implements "keep-balance diff", which compares two
+// reports.
+type diffCommand struct{}
+
+func (diffCommand) RunCommand(prog string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet(prog, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s [options] old-report.json new-report.json\n", prog)
+		flags.PrintDefaults()
+	}
+	listBlocks := flags.Bool("blocks", false, "list each added/removed pull, trash, and lost block")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	} else if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	var rpts [2]*Report
+	for i, path := range flags.Args() {
+		rpt, err := loadReport(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		rpts[i] = rpt
+	}
+	diffReports(stdout, rpts[0], rpts[1], *listBlocks)
+	return 0
+}
+
+// diffReports writes a human-readable summary of the differences
+// between two reports.
+func diffReports(w io.Writer, old, new *Report, listBlocks bool) {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", old.Time.Format(time.RFC3339), new.Time.Format(time.RFC3339))
+	for _, key := range reportSummaryKeys {
+		if o, n := old.Summary[key], new.Summary[key]; o != n {
+			fmt.Fprintf(w, "%s: %s -> %s\n", key, o, n)
+		}
+	}
+
+	classes := map[string]bool{}
+	for class := range old.StorageClasses {
+		classes[class] = true
+	}
+	for class := range new.StorageClasses {
+		classes[class] = true
+	}
+	for _, class := range sortedKeys(classes) {
+		for _, key := range reportClassKeys {
+			if o, n := old.StorageClasses[class][key], new.StorageClasses[class][key]; o != n {
+				fmt.Fprintf(w, "storage class %q: %s: %s -> %s\n", class, key, o, n)
+			}
+		}
+	}
+
+	oldMounts := map[string]ReportMount{}
+	for _, rm := range old.Mounts {
+		oldMounts[rm.UUID] = rm
+	}
+	newMounts := map[string]ReportMount{}
+	for _, rm := range new.Mounts {
+		newMounts[rm.UUID] = rm
+	}
+	mounts := map[string]bool{}
+	for uuid := range oldMounts {
+		mounts[uuid] = true
+	}
+	for uuid := range newMounts {
+		mounts[uuid] = true
+	}
+	for _, uuid := range sortedKeys(mounts) {
+		o, n := oldMounts[uuid], newMounts[uuid]
+		var oldPulls, newPulls, oldTrashes, newTrashes []string
+		for _, p := range o.Pulls {
+			oldPulls = append(oldPulls, p.String())
+		}
+		for _, p := range n.Pulls {
+			newPulls = append(newPulls, p.String())
+		}
+		for _, t := range o.Trashes {
+			oldTrashes = append(oldTrashes, t.Locator)
+		}
+		for _, t := range n.Trashes {
+			newTrashes = append(newTrashes, t.Locator)
+		}
+		diffLists(w, "mount "+uuid+": pulls", "pull ", oldPulls, newPulls, listBlocks)
+		diffLists(w, "mount "+uuid+": trashes", "trash ", oldTrashes, newTrashes, listBlocks)
+	}
+
+	var oldLost, newLost []string
+	for _, lb := range old.LostBlocks {
+		oldLost = append(oldLost, lb.String())
+	}
+	for _, lb := range new.LostBlocks {
+		newLost = append(newLost, lb.String())
+	}
+	diffLists(w, "lost blocks", "", oldLost, newLost, listBlocks)
+}
+
+func (p ReportPull) String() string {
+	s := p.Locator + " from " + p.From
+	if p.Shard != "" {
+		s += " shard " + p.Shard
+	}
+	return s
+}
+
+func (lb ReportLostBlock) String() string {
+	return strings.Join(append([]string{lb.Locator}, lb.Collections...), " ")
+}
+
+// diffLists writes the number of items added to and removed from a
+// list, if any, and (if listItems is true) the items themselves.
+func diffLists(w io.Writer, label, prefix string, old, new []string, listItems bool) {
+	inOld := map[string]bool{}
+	for _, item := range old {
+		inOld[item] = true
+	}
+	inNew := map[string]bool{}
+	for _, item := range new {
+		inNew[item] = true
+	}
+	var added, removed []string
+	for _, item := range new {
+		if !inOld[item] {
+			added = append(added, item)
+		}
+	}
+	for _, item := range old {
+		if !inNew[item] {
+			removed = append(removed, item)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s: %d -> %d (+%d -%d)\n", label, len(old), len(new), len(added), len(removed))
+	if !listItems {
+		return
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	for _, item := range removed {
+		fmt.Fprintf(w, "- %s%s\n", prefix, item)
+	}
+	for _, item := range added {
+		fmt.Fprintf(w, "+ %s%s\n", prefix, item)
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
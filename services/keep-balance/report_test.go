@@ -0,0 +1,103 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&reportSuite{})
+
+type reportSuite struct{}
+
+func (s *reportSuite) writeReport(c *check.C, rpt *Report) string {
+	f, err := ioutil.TempFile("", "keep-balance-report-test-")
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	c.Assert(json.NewEncoder(f).Encode(rpt), check.IsNil)
+	return f.Name()
+}
+
+func (s *reportSuite) TestDiff(c *check.C) {
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	oldRpt := &Report{
+		Time: t0,
+		Summary: map[string]ReportCount{
+			"lost":       {Replicas: 1, Blocks: 1, Bytes: 3},
+			"just_right": {Replicas: 4, Blocks: 2, Bytes: 6},
+		},
+		StorageClasses: map[string]map[string]ReportCount{
+			"default": {"needed": {Replicas: 4, Blocks: 2, Bytes: 6}},
+		},
+		Mounts: []ReportMount{{
+			UUID:    "zzzzz-ivpuk-000000000000000",
+			Pulls:   []ReportPull{{Locator: "acbd18db4cc2f85cedef654fccc4a4d8+3", From: "zzzzz-bi6l4-000000000000001"}},
+			Trashes: []ReportTrash{{Locator: "37b51d194a7513e45b56f6524f2d51f2+3", Mtime: 12345}},
+		}},
+		LostBlocks: []ReportLostBlock{{Locator: "37b51d194a7513e45b56f6524f2d51f2+3", Collections: []string{"fa7aeb5140e2848d39b416daeef4ffc5+45"}}},
+	}
+	newRpt := &Report{
+		Time: t0.Add(time.Hour),
+		Summary: map[string]ReportCount{
+			"just_right": {Replicas: 4, Blocks: 2, Bytes: 6},
+		},
+		StorageClasses: map[string]map[string]ReportCount{
+			"default": {"needed": {Replicas: 4, Blocks: 2, Bytes: 6}},
+			"archive": {"pulling": {Replicas: 1, Blocks: 1, Bytes: 3}},
+		},
+		Mounts: []ReportMount{{
+			UUID:    "zzzzz-ivpuk-000000000000000",
+			Trashes: []ReportTrash{{Locator: "37b51d194a7513e45b56f6524f2d51f2+3", Mtime: 12345}},
+		}, {
+			UUID:  "zzzzz-ivpuk-100000000000000",
+			Pulls: []ReportPull{{Locator: "acbd18db4cc2f85cedef654fccc4a4d8+3", From: "zzzzz-bi6l4-000000000000000", Shard: "4+2/1"}},
+		}},
+	}
+	oldFile := s.writeReport(c, oldRpt)
+	defer os.Remove(oldFile)
+	newFile := s.writeReport(c, newRpt)
+	defer os.Remove(newFile)
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand("keep-balance", []string{"diff", oldFile, newFile}, nil, &stdout, &stderr)
+	c.Check(code, check.Equals, 0)
+	c.Check(stderr.String(), check.Equals, "")
+	c.Check(stdout.String(), check.Equals, `--- 2020-01-02T03:04:05Z
++++ 2020-01-02T04:04:05Z
+lost: 1 replicas (1 blocks, 3 bytes) -> 0 replicas (0 blocks, 0 bytes)
+storage class "archive": pulling: 0 replicas (0 blocks, 0 bytes) -> 1 replicas (1 blocks, 3 bytes)
+mount zzzzz-ivpuk-000000000000000: pulls: 1 -> 0 (+0 -1)
+mount zzzzz-ivpuk-100000000000000: pulls: 0 -> 1 (+1 -0)
+lost blocks: 1 -> 0 (+0 -1)
+`)
+
+	stdout.Reset()
+	code = runCommand("keep-balance", []string{"diff", "-blocks", oldFile, newFile}, nil, &stdout, &stderr)
+	c.Check(code, check.Equals, 0)
+	c.Check(stdout.String(), check.Matches, `(?ms).*
+mount zzzzz-ivpuk-000000000000000: pulls: 1 -> 0 \(\+0 -1\)
+- pull acbd18db4cc2f85cedef654fccc4a4d8\+3 from zzzzz-bi6l4-000000000000001
+mount zzzzz-ivpuk-100000000000000: pulls: 0 -> 1 \(\+1 -0\)
+\+ pull acbd18db4cc2f85cedef654fccc4a4d8\+3 from zzzzz-bi6l4-000000000000000 shard 4\+2/1
+lost blocks: 1 -> 0 \(\+0 -1\)
+- 37b51d194a7513e45b56f6524f2d51f2\+3 fa7aeb5140e2848d39b416daeef4ffc5\+45
+`)
+
+	stdout.Reset()
+	code = runCommand("keep-balance", []string{"diff", oldFile}, nil, &stdout, &stderr)
+	c.Check(code, check.Equals, 2)
+	c.Check(stderr.String(), check.Matches, `(?ms)usage: keep-balance diff .*`)
+
+	stderr.Reset()
+	code = runCommand("keep-balance", []string{"diff", oldFile, "/nonexistent"}, nil, &stdout, &stderr)
+	c.Check(code, check.Equals, 1)
+	c.Check(stderr.String(), check.Matches, `.*/nonexistent.*\n`)
+}
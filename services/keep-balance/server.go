@@ -26,6 +26,7 @@ type RunOptions struct {
 	Once        bool
 	CommitPulls bool
 	CommitTrash bool
+	ReportFile  string
 	Logger      logrus.FieldLogger
 	Dumper      logrus.FieldLogger
 
@@ -79,6 +80,8 @@ func (srv *Server) runOnce() (*Balancer, error) {
 		Dumper:         srv.Dumper,
 		Metrics:        srv.Metrics,
 		LostBlocksFile: srv.Cluster.Collections.BlobMissingReport,
+		StorageClasses: srv.Cluster.StorageClasses,
+		ReportFile:     srv.RunOptions.ReportFile,
 	}
 	var err error
 	srv.RunOptions, err = bal.Run(srv.ArvClient, srv.Cluster, srv.RunOptions)
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -146,13 +147,13 @@ func (s *IntegrationSuite) testCadaver(c *check.C, password string, pathFunc fun
 		},
 		{
 			path:  writePath,
-			cmd:   "lock newdir0/testfile\n",
-			match: `(?ms).*Locking .* succeeded.*`,
+			cmd:   "lock newdir0/testfile\nput '" + localfile.Name() + "' newdir0/testfile\nunlock newdir0/testfile\n",
+			match: `(?ms).*Locking .* succeeded.*Uploading .* succeeded.*Unlocking .* succeeded.*`,
 		},
 		{
 			path:  writePath,
 			cmd:   "unlock newdir0/testfile\nasdf\n",
-			match: `(?ms).*Unlocking .* succeeded.*`,
+			match: `(?ms).*Unlocking .* failed.*`,
 		},
 		{
 			path:  writePath,
@@ -362,3 +363,60 @@ func (s *IntegrationSuite) runCadaver(c *check.C, password, path, stdin string)
 	c.Check(err, check.Equals, nil)
 	return buf.String()
 }
+
+func (s *IntegrationSuite) TestCadaverLockConflict(c *check.C) {
+	var newCollection arvados.Collection
+	arv := arvados.NewClientFromEnv()
+	arv.AuthToken = arvadostest.ActiveToken
+	err := arv.RequestAndDecode(&newCollection, "POST", "arvados/v1/collections", nil, map[string]interface{}{
+		"collection": map[string]interface{}{
+			"manifest_text": ". acbd18db4cc2f85cedef654fccc4a4d8+3 0:3:foo\n",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	defer arv.RequestAndDecode(nil, "DELETE", "arvados/v1/collections/"+newCollection.UUID, nil, nil)
+
+	tempdir, err := ioutil.TempDir("", "keep-web-test-")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(tempdir)
+	localfile := filepath.Join(tempdir, "localfile")
+	c.Assert(ioutil.WriteFile(localfile, []byte("bar"), 0644), check.IsNil)
+
+	// Another client locks the file. (Setting the
+	// attachment-only host lets us send credentials in an
+	// Authorization header at /c=ID/ paths, as runCadaver does.)
+	s.testServer.Config.cluster.Services.WebDAVDownload.ExternalURL.Host = s.testServer.Addr
+	base := "http://" + s.testServer.Addr + "/c=" + newCollection.UUID + "/"
+	req, err := http.NewRequest("LOCK", base+"foo", bytes.NewBufferString(`<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner>other client</D:owner></D:lockinfo>`))
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Authorization", "Bearer "+arvadostest.ActiveToken)
+	req.Header.Set("Timeout", "Second-600")
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	token := resp.Header.Get("Lock-Token")
+	c.Check(token, check.Matches, `<opaquelocktoken:.*>`)
+
+	stdout := s.runCadaver(c, arvadostest.ActiveToken, "/c="+newCollection.UUID+"/", "put '"+localfile+"' foo\n")
+	c.Check(stdout, check.Matches, `(?ms).*Uploading .* failed.*423 Locked.*`)
+	stdout = s.runCadaver(c, arvadostest.ActiveToken, "/c="+newCollection.UUID+"/", "lock foo\n")
+	c.Check(stdout, check.Matches, `(?ms).*Locking .* failed.*`)
+
+	// Other files in the same collection are not locked
+	stdout = s.runCadaver(c, arvadostest.ActiveToken, "/c="+newCollection.UUID+"/", "put '"+localfile+"' bar\n")
+	c.Check(stdout, check.Matches, `(?ms).*Uploading .* succeeded.*`)
+
+	req, err = http.NewRequest("UNLOCK", base+"foo", nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Authorization", "Bearer "+arvadostest.ActiveToken)
+	req.Header.Set("Lock-Token", token)
+	resp, err = http.DefaultClient.Do(req)
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, check.Equals, http.StatusNoContent)
+
+	stdout = s.runCadaver(c, arvadostest.ActiveToken, "/c="+newCollection.UUID+"/", "put '"+localfile+"' foo\n")
+	c.Check(stdout, check.Matches, `(?ms).*Uploading .* succeeded.*`)
+}
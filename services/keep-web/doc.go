@@ -297,6 +297,15 @@
 // avoids redirecting requests to keep-web if they depend on
 // TrustAllContent being enabled.
 //
+// S3 API
+//
+// Keep-web also accepts requests from S3 clients, signed with AWS
+// Signature Version 4 using an Arvados token as the access key (see
+// doc/api/keep-s3). Buckets are projects and collections, named by
+// UUID, and objects are the files they contain:
+//
+//   https://collections.example.com/zzzzz-4zz18-znfnqtbbv4spc3w/dir/file.txt
+//
 // Metrics
 //
 // Keep-web exposes request metrics in Prometheus text-based format at
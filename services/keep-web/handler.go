@@ -17,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"git.arvados.org/arvados.git/sdk/go/arvados"
 	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
@@ -36,6 +37,7 @@ type handler struct {
 	setupOnce     sync.Once
 	healthHandler http.Handler
 	webdavLS      webdav.LockSystem
+	lockStore     lockStore
 }
 
 // parseCollectionIDFromDNSName returns a UUID or PDH if s begins with
@@ -86,9 +88,37 @@ func (h *handler) setup() {
 		Prefix: "/_health/",
 	}
 
-	// Even though we don't accept LOCK requests, every webdav
-	// handler must have a non-nil LockSystem.
+	// Read-only webdav handlers (and all handlers, if locking is
+	// disabled) use a LockSystem that doesn't lock anything.
 	h.webdavLS = &noLockSystem{}
+	switch h.Config.cluster.Collections.WebDAVLocks {
+	case "api":
+		h.lockStore = &apiLockStore{
+			client: &arvados.Client{
+				APIHost:   h.Config.cluster.Services.Controller.ExternalURL.Host,
+				AuthToken: h.Config.cluster.SystemRootToken,
+				Insecure:  h.Config.cluster.TLS.Insecure,
+			},
+		}
+	case "none":
+	default:
+		h.lockStore = &memLockStore{}
+	}
+}
+
+// lockSystem returns a webdav.LockSystem for the given collection,
+// for use in a single request. lockRequest indicates whether the
+// request is a LOCK request (see collectionLockSystem.temporary).
+func (h *handler) lockSystem(collectionUUID string, lockRequest bool) webdav.LockSystem {
+	if h.lockStore == nil {
+		return h.webdavLS
+	}
+	return &collectionLockSystem{
+		store:      h.lockStore,
+		collection: collectionUUID,
+		maxTimeout: time.Duration(h.Config.cluster.Collections.WebDAVLockMaxTimeout),
+		temporary:  !lockRequest,
+	}
 }
 
 func (h *handler) serveStatus(w http.ResponseWriter, r *http.Request) {
@@ -198,6 +228,10 @@ func (h *handler) ServeHTTP(wOrig http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.serveS3(w, r) {
+		return
+	}
+
 	if method := r.Header.Get("Access-Control-Request-Method"); method != "" && r.Method == "OPTIONS" {
 		if !browserMethod[method] && !webdavMethod[method] {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -463,7 +497,7 @@ func (h *handler) ServeHTTP(wOrig http.ResponseWriter, r *http.Request) {
 				writing:       writeMethod[r.Method],
 				alwaysReadEOF: r.Method == "PROPFIND",
 			},
-			LockSystem: h.webdavLS,
+			LockSystem: h.lockSystem(collection.UUID, r.Method == "LOCK"),
 			Logger: func(_ *http.Request, err error) {
 				if err != nil {
 					ctxlog.FromContext(r.Context()).WithError(err).Error("error reported by webdav handler")
@@ -0,0 +1,458 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"golang.org/x/net/webdav"
+)
+
+// webdavLock is a WebDAV lock on a file or directory in a
+// collection.
+type webdavLock struct {
+	Token      string
+	Collection string
+	Root       string
+	OwnerXML   string
+	ZeroDepth  bool
+	Duration   time.Duration
+	// Zero value means the lock never expires.
+	Expires time.Time
+
+	// Link record used by apiLockStore.
+	linkUUID  string
+	createdAt time.Time
+}
+
+func (lock *webdavLock) expired(now time.Time) bool {
+	return !lock.Expires.IsZero() && !now.Before(lock.Expires)
+}
+
+// covers returns true if the lock applies to the given path.
+func (lock *webdavLock) covers(name string) bool {
+	if name == lock.Root {
+		return true
+	} else if lock.ZeroDepth {
+		return false
+	}
+	return lock.Root == "/" || strings.HasPrefix(name, lock.Root+"/")
+}
+
+// conflicts returns true if the two locks cannot be held at the same
+// time. Only exclusive write locks are supported, so any overlap is a
+// conflict.
+func (lock *webdavLock) conflicts(other *webdavLock) bool {
+	return lock.Collection == other.Collection && (lock.covers(other.Root) || other.covers(lock.Root))
+}
+
+// A lockStore saves WebDAV locks for all collections.
+type lockStore interface {
+	// Locks returns the unexpired locks on the given collection.
+	Locks(collection string, now time.Time) ([]webdavLock, error)
+	// Get returns the lock with the given token, or
+	// webdav.ErrNoSuchLock if there is no such unexpired lock.
+	Get(token string, now time.Time) (webdavLock, error)
+	// Add saves a new lock, or returns webdav.ErrLocked if it
+	// conflicts with an existing unexpired lock.
+	Add(lock webdavLock, now time.Time) error
+	// Update saves a new expiry time for an existing lock.
+	Update(lock webdavLock) error
+	// Remove deletes an existing lock.
+	Remove(lock webdavLock) error
+}
+
+// collectionLockSystem implements webdav.LockSystem for a single
+// collection, using a lockStore that holds locks for all
+// collections. This way, coll1.vhost/foo and coll2.vhost/foo are
+// locked independently even though they have the same path.
+type collectionLockSystem struct {
+	store      lockStore
+	collection string
+	maxTimeout time.Duration
+
+	// If true, Create makes temporary locks. When serving a
+	// request other than LOCK, webdav.Handler only calls Create
+	// to lock the target of a write request that doesn't submit a
+	// lock token (an If header), and unlocks it when the request
+	// is done. Such a lock only needs to check that the target
+	// isn't locked by someone else.
+	temporary bool
+}
+
+func (ls *collectionLockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	locks, err := ls.store.Locks(ls.collection, now)
+	if err != nil {
+		return nil, err
+	}
+	// Each named resource must be covered by one of the locks
+	// the client has submitted.
+	for _, name := range []string{name0, name1} {
+		if name == "" {
+			continue
+		}
+		name = cleanLockPath(name)
+		confirmed := false
+		for _, cond := range conditions {
+			for _, lock := range locks {
+				if lock.Token == cond.Token && lock.covers(name) {
+					confirmed = true
+				}
+			}
+		}
+		if !confirmed {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	return noop, nil
+}
+
+// temporaryLockTokenPrefix identifies the tokens returned by Create
+// for temporary locks, which are not saved in the lockStore.
+const temporaryLockTokenPrefix = "opaquelocktoken:temporary-"
+
+func (ls *collectionLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	lock := webdavLock{
+		Token:      "opaquelocktoken:" + uuid(),
+		Collection: ls.collection,
+		Root:       cleanLockPath(details.Root),
+		OwnerXML:   details.OwnerXML,
+		ZeroDepth:  details.ZeroDepth,
+	}
+	if ls.temporary {
+		// Check for conflicts, but don't save the lock. This
+		// saves a round trip to the lockStore on each write,
+		// and we don't leave a lock behind if we crash
+		// before unlocking. Concurrent writes without lock
+		// tokens are not prevented from conflicting with
+		// each other.
+		locks, err := ls.store.Locks(ls.collection, now)
+		if err != nil {
+			return "", err
+		}
+		for _, other := range locks {
+			if lock.conflicts(&other) {
+				return "", webdav.ErrLocked
+			}
+		}
+		return temporaryLockTokenPrefix + uuid(), nil
+	}
+	ls.setDuration(&lock, now, details.Duration)
+	if err := ls.store.Add(lock, now); err != nil {
+		return "", err
+	}
+	return lock.Token, nil
+}
+
+func (ls *collectionLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	lock, err := ls.get(token, now)
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	ls.setDuration(&lock, now, duration)
+	if err := ls.store.Update(lock); err != nil {
+		return webdav.LockDetails{}, err
+	}
+	return webdav.LockDetails{
+		Root:      lock.Root,
+		Duration:  lock.Duration,
+		OwnerXML:  lock.OwnerXML,
+		ZeroDepth: lock.ZeroDepth,
+	}, nil
+}
+
+func (ls *collectionLockSystem) Unlock(now time.Time, token string) error {
+	if strings.HasPrefix(token, temporaryLockTokenPrefix) {
+		return nil
+	}
+	lock, err := ls.get(token, now)
+	if err != nil {
+		return err
+	}
+	return ls.store.Remove(lock)
+}
+
+// get returns the lock with the given token, or webdav.ErrNoSuchLock
+// if the token refers to a lock on a different collection.
+func (ls *collectionLockSystem) get(token string, now time.Time) (webdavLock, error) {
+	lock, err := ls.store.Get(token, now)
+	if err != nil {
+		return lock, err
+	} else if lock.Collection != ls.collection {
+		return webdavLock{}, webdav.ErrNoSuchLock
+	}
+	return lock, nil
+}
+
+// setDuration sets the lock's duration and expiry time, limited to
+// the configured maximum. A negative duration means the client asked
+// for an infinite timeout.
+func (ls *collectionLockSystem) setDuration(lock *webdavLock, now time.Time, duration time.Duration) {
+	if ls.maxTimeout > 0 && (duration < 0 || duration > ls.maxTimeout) {
+		duration = ls.maxTimeout
+	}
+	lock.Duration = duration
+	if duration < 0 {
+		lock.Expires = time.Time{}
+	} else {
+		lock.Expires = now.Add(duration)
+	}
+}
+
+func cleanLockPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// memLockStore is a lockStore that keeps locks in memory. It is only
+// suitable when all WebDAV requests are served by the same keep-web
+// process.
+type memLockStore struct {
+	mtx   sync.Mutex
+	locks map[string]webdavLock
+}
+
+func (ms *memLockStore) Locks(collection string, now time.Time) ([]webdavLock, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+	ms.expire(now)
+	var locks []webdavLock
+	for _, lock := range ms.locks {
+		if lock.Collection == collection {
+			locks = append(locks, lock)
+		}
+	}
+	return locks, nil
+}
+
+func (ms *memLockStore) Get(token string, now time.Time) (webdavLock, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+	ms.expire(now)
+	lock, ok := ms.locks[token]
+	if !ok {
+		return webdavLock{}, webdav.ErrNoSuchLock
+	}
+	return lock, nil
+}
+
+func (ms *memLockStore) Add(lock webdavLock, now time.Time) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+	ms.expire(now)
+	for _, other := range ms.locks {
+		if lock.conflicts(&other) {
+			return webdav.ErrLocked
+		}
+	}
+	if ms.locks == nil {
+		ms.locks = map[string]webdavLock{}
+	}
+	ms.locks[lock.Token] = lock
+	return nil
+}
+
+func (ms *memLockStore) Update(lock webdavLock) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+	if _, ok := ms.locks[lock.Token]; !ok {
+		return webdav.ErrNoSuchLock
+	}
+	ms.locks[lock.Token] = lock
+	return nil
+}
+
+func (ms *memLockStore) Remove(lock webdavLock) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+	if _, ok := ms.locks[lock.Token]; !ok {
+		return webdav.ErrNoSuchLock
+	}
+	delete(ms.locks, lock.Token)
+	return nil
+}
+
+// expire deletes expired locks. Caller must have ms.mtx locked.
+func (ms *memLockStore) expire(now time.Time) {
+	for token, lock := range ms.locks {
+		if lock.expired(now) {
+			delete(ms.locks, token)
+		}
+	}
+}
+
+const webdavLockLinkClass = "webdav_lock"
+
+// apiLockStore is a lockStore that saves locks as links on the API
+// server, so they are shared by all keep-web processes. Each lock is
+// a link whose head is the locked collection and whose name is the
+// lock token.
+//
+// Adding a lock is not atomic: after saving a new lock, Add checks
+// for a conflicting lock that was saved first (by another process)
+// and, if there is one, deletes the new lock and returns
+// webdav.ErrLocked.
+type apiLockStore struct {
+	// client must use a token that can read and write all
+	// webdav_lock links, i.e., the SystemRootToken.
+	client *arvados.Client
+}
+
+func (as *apiLockStore) Locks(collection string, now time.Time) ([]webdavLock, error) {
+	return as.list(now, []arvados.Filter{
+		{Attr: "link_class", Operator: "=", Operand: webdavLockLinkClass},
+		{Attr: "head_uuid", Operator: "=", Operand: collection},
+	})
+}
+
+func (as *apiLockStore) Get(token string, now time.Time) (webdavLock, error) {
+	locks, err := as.list(now, []arvados.Filter{
+		{Attr: "link_class", Operator: "=", Operand: webdavLockLinkClass},
+		{Attr: "name", Operator: "=", Operand: token},
+	})
+	if err != nil {
+		return webdavLock{}, err
+	} else if len(locks) == 0 {
+		return webdavLock{}, webdav.ErrNoSuchLock
+	}
+	return locks[0], nil
+}
+
+func (as *apiLockStore) Add(lock webdavLock, now time.Time) error {
+	locks, err := as.Locks(lock.Collection, now)
+	if err != nil {
+		return err
+	}
+	for _, other := range locks {
+		if lock.conflicts(&other) {
+			return webdav.ErrLocked
+		}
+	}
+	var link arvados.Link
+	err = as.client.RequestAndDecode(&link, "POST", "arvados/v1/links", nil, map[string]interface{}{
+		"link": map[string]interface{}{
+			"link_class": webdavLockLinkClass,
+			"name":       lock.Token,
+			"head_uuid":  lock.Collection,
+			"properties": lockProperties(lock),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	// Another process might have added a conflicting lock since
+	// we checked. The lock that was saved first wins.
+	locks, err = as.Locks(lock.Collection, now)
+	if err != nil {
+		return err
+	}
+	for _, other := range locks {
+		if other.linkUUID == link.UUID || !lock.conflicts(&other) {
+			continue
+		}
+		if other.createdAt.Before(link.CreatedAt) || (other.createdAt.Equal(link.CreatedAt) && other.linkUUID < link.UUID) {
+			as.client.RequestAndDecode(nil, "DELETE", "arvados/v1/links/"+link.UUID, nil, nil)
+			return webdav.ErrLocked
+		}
+	}
+	return nil
+}
+
+func (as *apiLockStore) Update(lock webdavLock) error {
+	return as.client.RequestAndDecode(nil, "PATCH", "arvados/v1/links/"+lock.linkUUID, nil, map[string]interface{}{
+		"link": map[string]interface{}{
+			"properties": lockProperties(lock),
+		},
+	})
+}
+
+func (as *apiLockStore) Remove(lock webdavLock) error {
+	err := as.client.RequestAndDecode(nil, "DELETE", "arvados/v1/links/"+lock.linkUUID, nil, nil)
+	if err, ok := err.(*arvados.TransactionError); ok && err.StatusCode == 404 {
+		return webdav.ErrNoSuchLock
+	}
+	return err
+}
+
+// list returns the unexpired locks matching the given filters, and
+// deletes any expired ones it finds.
+//
+// It stops when a page has fewer items than the page size reported
+// by the API server (which is reduced if the response is truncated
+// for other reasons), so the common case of zero or a few locks
+// needs only one API call.
+func (as *apiLockStore) list(now time.Time, filters []arvados.Filter) ([]webdavLock, error) {
+	var locks []webdavLock
+	params := arvados.ResourceListParams{
+		Count:   "none",
+		Filters: filters,
+		Order:   "uuid",
+	}
+	for {
+		var resp arvados.LinkList
+		err := as.client.RequestAndDecode(&resp, "GET", "arvados/v1/links", nil, params)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Items) == 0 {
+			break
+		}
+		for _, link := range resp.Items {
+			lock := lockFromLink(link)
+			if lock.expired(now) {
+				as.client.RequestAndDecode(nil, "DELETE", "arvados/v1/links/"+link.UUID, nil, nil)
+				continue
+			}
+			locks = append(locks, lock)
+		}
+		if len(resp.Items) < resp.Limit {
+			break
+		}
+		params.Filters = append(filters, arvados.Filter{Attr: "uuid", Operator: ">", Operand: resp.Items[len(resp.Items)-1].UUID})
+	}
+	return locks, nil
+}
+
+func lockProperties(lock webdavLock) map[string]interface{} {
+	props := map[string]interface{}{
+		"root":       lock.Root,
+		"owner_xml":  lock.OwnerXML,
+		"zero_depth": lock.ZeroDepth,
+		"duration":   lock.Duration.String(),
+		"expires_at": "",
+	}
+	if !lock.Expires.IsZero() {
+		props["expires_at"] = lock.Expires.UTC().Format(time.RFC3339Nano)
+	}
+	return props
+}
+
+func lockFromLink(link arvados.Link) webdavLock {
+	lock := webdavLock{
+		Token:      link.Name,
+		Collection: link.HeadUUID,
+		linkUUID:   link.UUID,
+		createdAt:  link.CreatedAt,
+	}
+	lock.Root, _ = link.Properties["root"].(string)
+	lock.OwnerXML, _ = link.Properties["owner_xml"].(string)
+	lock.ZeroDepth, _ = link.Properties["zero_depth"].(bool)
+	if s, ok := link.Properties["duration"].(string); ok {
+		lock.Duration, _ = time.ParseDuration(s)
+	}
+	if s, ok := link.Properties["expires_at"].(string); ok && s != "" {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			lock.Expires = t
+		} else {
+			// Unparseable expiry time: treat the lock as
+			// expired rather than holding it forever.
+			lock.Expires = link.CreatedAt
+		}
+	}
+	return lock
+}
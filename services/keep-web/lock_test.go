@@ -0,0 +1,128 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	"golang.org/x/net/webdav"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&lockSuite{})
+
+type lockSuite struct{}
+
+func (s *lockSuite) TestMemLockStore(c *check.C) {
+	testLockStore(c, &memLockStore{})
+}
+
+// apiLockStore.list stops after a short page, instead of asking for
+// another (empty) page.
+func (s *lockSuite) TestAPILockStoreListPages(c *check.C) {
+	var reqs []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		reqs = append(reqs, req.Form.Get("filters"))
+		items := []arvados.Link{}
+		if len(reqs) == 1 {
+			items = append(items, arvados.Link{UUID: "zzzzz-o0j2j-000000000000001", Name: "opaquelocktoken:x", HeadUUID: arvadostest.FooCollection})
+		}
+		json.NewEncoder(w).Encode(arvados.LinkList{Items: items, Limit: 100})
+	}))
+	defer srv.Close()
+	store := &apiLockStore{client: &arvados.Client{APIHost: srv.Listener.Addr().String(), Insecure: true}}
+	locks, err := store.Locks(arvadostest.FooCollection, time.Now())
+	c.Check(err, check.IsNil)
+	c.Check(locks, check.HasLen, 1)
+	c.Check(reqs, check.HasLen, 1)
+}
+
+func (s *IntegrationSuite) TestAPILockStore(c *check.C) {
+	client := arvados.NewClientFromEnv()
+	client.AuthToken = arvadostest.SystemRootToken
+	testLockStore(c, &apiLockStore{client: client})
+}
+
+func testLockStore(c *check.C, store lockStore) {
+	coll1 := arvadostest.FooCollection
+	coll2 := arvadostest.FooAndBarFilesInDirUUID
+	ls1 := &collectionLockSystem{store: store, collection: coll1, maxTimeout: time.Hour}
+	ls2 := &collectionLockSystem{store: store, collection: coll2, maxTimeout: time.Hour}
+	now := time.Now()
+
+	dirToken, err := ls1.Create(now, webdav.LockDetails{Root: "/dir", Duration: time.Minute})
+	c.Assert(err, check.IsNil)
+	defer ls1.Unlock(now, dirToken)
+
+	// Infinite-depth lock on /dir covers /dir/foo
+	_, err = ls1.Create(now, webdav.LockDetails{Root: "/dir/foo", Duration: time.Minute, ZeroDepth: true})
+	c.Check(err, check.Equals, webdav.ErrLocked)
+	_, err = ls1.Create(now, webdav.LockDetails{Root: "/", Duration: time.Minute})
+	c.Check(err, check.Equals, webdav.ErrLocked)
+	// ...but not /dirx
+	token, err := ls1.Create(now, webdav.LockDetails{Root: "/dirx", Duration: time.Minute, ZeroDepth: true})
+	c.Check(err, check.IsNil)
+	c.Check(ls1.Unlock(now, token), check.IsNil)
+	// ...or /dir/foo in a different collection
+	token, err = ls2.Create(now, webdav.LockDetails{Root: "/dir/foo", Duration: time.Minute, ZeroDepth: true})
+	c.Check(err, check.IsNil)
+	// A lock token can't be used in a different collection
+	c.Check(ls1.Unlock(now, token), check.Equals, webdav.ErrNoSuchLock)
+	c.Check(ls2.Unlock(now, token), check.IsNil)
+	c.Check(ls2.Unlock(now, token), check.Equals, webdav.ErrNoSuchLock)
+
+	// Confirm requires a submitted token that covers each name
+	release, err := ls1.Confirm(now, "/dir/foo", "/dir/bar", webdav.Condition{Token: dirToken})
+	c.Check(err, check.IsNil)
+	release()
+	_, err = ls1.Confirm(now, "/dir/foo", "/other", webdav.Condition{Token: dirToken})
+	c.Check(err, check.Equals, webdav.ErrConfirmationFailed)
+	_, err = ls1.Confirm(now, "/dir/foo", "", webdav.Condition{Token: "opaquelocktoken:bogus"})
+	c.Check(err, check.Equals, webdav.ErrConfirmationFailed)
+	_, err = ls2.Confirm(now, "/dir/foo", "", webdav.Condition{Token: dirToken})
+	c.Check(err, check.Equals, webdav.ErrConfirmationFailed)
+
+	// Refresh extends the lock, up to maxTimeout
+	details, err := ls1.Refresh(now, dirToken, 2*time.Minute)
+	c.Check(err, check.IsNil)
+	c.Check(details.Root, check.Equals, "/dir")
+	c.Check(details.Duration, check.Equals, 2*time.Minute)
+	details, err = ls1.Refresh(now, dirToken, -1)
+	c.Check(err, check.IsNil)
+	c.Check(details.Duration, check.Equals, time.Hour)
+	_, err = ls1.Confirm(now.Add(30*time.Minute), "/dir/foo", "", webdav.Condition{Token: dirToken})
+	c.Check(err, check.IsNil)
+
+	// Temporary locks (made by webdav.Handler for write requests
+	// without lock tokens) conflict with saved locks, but are
+	// not saved themselves.
+	tmp1 := &collectionLockSystem{store: store, collection: coll1, maxTimeout: time.Hour, temporary: true}
+	_, err = tmp1.Create(now, webdav.LockDetails{Root: "/dir/foo", Duration: -1, ZeroDepth: true})
+	c.Check(err, check.Equals, webdav.ErrLocked)
+	before, err := store.Locks(coll1, now)
+	c.Assert(err, check.IsNil)
+	token, err = tmp1.Create(now, webdav.LockDetails{Root: "/other", Duration: -1, ZeroDepth: true})
+	c.Check(err, check.IsNil)
+	after, err := store.Locks(coll1, now)
+	c.Assert(err, check.IsNil)
+	c.Check(after, check.HasLen, len(before))
+	c.Check(tmp1.Unlock(now, token), check.IsNil)
+
+	// Expired locks are gone
+	later := now.Add(2 * time.Hour)
+	_, err = ls1.Confirm(later, "/dir/foo", "", webdav.Condition{Token: dirToken})
+	c.Check(err, check.Equals, webdav.ErrConfirmationFailed)
+	_, err = ls1.Refresh(later, dirToken, time.Minute)
+	c.Check(err, check.Equals, webdav.ErrNoSuchLock)
+	token, err = ls1.Create(later, webdav.LockDetails{Root: "/dir/foo", Duration: time.Minute})
+	c.Check(err, check.IsNil)
+	c.Check(ls1.Unlock(later, token), check.IsNil)
+}
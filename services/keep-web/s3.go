@@ -0,0 +1,966 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadosclient"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"git.arvados.org/arvados.git/sdk/go/keepclient"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	s3SignAlgorithm    = "AWS4-HMAC-SHA256"
+	s3DateFormat       = "20060102T150405Z"
+	s3MaxClockSkew     = 15 * time.Minute
+	s3MaxKeys          = 1000
+	s3UnsignedPayload  = "UNSIGNED-PAYLOAD"
+	s3StreamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+)
+
+// s3Error is an S3 error response body. Code is one of the error
+// codes documented by AWS (NoSuchKey, AccessDenied, etc.) so S3
+// clients can recognize it.
+type s3Error struct {
+	XMLName    xml.Name `xml:"Error"`
+	Code       string
+	Message    string
+	Resource   string
+	RequestId  string
+	statusCode int
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newS3Error(statusCode int, code, message string) *s3Error {
+	return &s3Error{Code: code, Message: message, statusCode: statusCode}
+}
+
+var (
+	errS3AccessDenied     = newS3Error(http.StatusForbidden, "AccessDenied", "Access denied")
+	errS3InvalidAccessKey = newS3Error(http.StatusForbidden, "InvalidAccessKeyId", "The access key does not correspond to a valid Arvados token")
+	errS3SignatureInvalid = newS3Error(http.StatusForbidden, "SignatureDoesNotMatch", "The request signature does not match the signature calculated by the server")
+	errS3NoSuchBucket     = newS3Error(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+	errS3NoSuchKey        = newS3Error(http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+	errS3ReadOnly         = newS3Error(http.StatusMethodNotAllowed, "MethodNotAllowed", "Bucket is read-only")
+	errS3BadDigest        = newS3Error(http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what was received")
+	errS3SHA256Mismatch   = newS3Error(http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided x-amz-content-sha256 header does not match what was computed")
+	errS3NotImplemented   = newS3Error(http.StatusNotImplemented, "NotImplemented", "This operation is not supported")
+	errS3Locked           = newS3Error(http.StatusConflict, "OperationAborted", "The object is locked by a WebDAV client")
+)
+
+// s3ErrorResponse sends an S3 error response. If err is not an
+// *s3Error, it is reported as an InternalError (or, if it is an API
+// transaction error, mapped to the closest S3 equivalent).
+func s3ErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	s3err, ok := err.(*s3Error)
+	if !ok {
+		if os.IsNotExist(err) {
+			s3err = errS3NoSuchKey
+		} else if terr, ok := err.(*arvados.TransactionError); ok && (terr.StatusCode == http.StatusUnauthorized || terr.StatusCode == http.StatusForbidden) {
+			s3err = errS3AccessDenied
+		} else if terr, ok := err.(*arvados.TransactionError); ok && terr.StatusCode == http.StatusNotFound {
+			s3err = errS3NoSuchKey
+		} else {
+			ctxlog.FromContext(r.Context()).WithError(err).Error("error serving S3 request")
+			s3err = newS3Error(http.StatusInternalServerError, "InternalError", err.Error())
+		}
+	}
+	resp := *s3err
+	resp.Resource = r.URL.Path
+	resp.RequestId = r.Header.Get("X-Request-Id")
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(resp.statusCode)
+	if r.Method != http.MethodHead {
+		io.WriteString(w, xml.Header)
+		xml.NewEncoder(w).Encode(resp)
+	}
+}
+
+func s3XMLResponse(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		ctxlog.FromContext(r.Context()).WithError(err).Error("error encoding S3 response")
+	}
+}
+
+// serveS3 handles r and returns true if r is a request from an S3
+// client, otherwise it returns false.
+//
+// Buckets are Arvados projects and collections, identified by UUID
+// (or, for read-only access, portable data hash). Objects are files
+// in the bucket, addressed by their path. Requests must be signed
+// with AWS Signature Version 4 using an Arvados token as described
+// in checks3signature.
+func (h *handler) serveS3(w http.ResponseWriter, r *http.Request) bool {
+	authz := r.Header.Get("Authorization")
+	if strings.HasPrefix(authz, "AWS ") {
+		s3ErrorResponse(w, r, newS3Error(http.StatusBadRequest, "InvalidRequest", "Signature Version 2 is not supported, use "+s3SignAlgorithm))
+		return true
+	} else if !strings.HasPrefix(authz, s3SignAlgorithm+" ") {
+		return false
+	}
+
+	token, err := h.checks3signature(r)
+	if err != nil {
+		s3ErrorResponse(w, r, err)
+		return true
+	}
+	if _, ok := r.URL.Query()["uploads"]; ok {
+		s3ErrorResponse(w, r, errS3NotImplemented)
+		return true
+	} else if _, ok := r.URL.Query()["uploadId"]; ok {
+		s3ErrorResponse(w, r, errS3NotImplemented)
+		return true
+	} else if r.Header.Get("X-Amz-Copy-Source") != "" {
+		s3ErrorResponse(w, r, errS3NotImplemented)
+		return true
+	}
+
+	arv := h.clientPool.Get()
+	if arv == nil {
+		s3ErrorResponse(w, r, errors.New("client pool error: "+h.clientPool.Err().Error()))
+		return true
+	}
+	defer h.clientPool.Put(arv)
+	arv.ApiToken = token
+	kc, err := keepclient.MakeKeepClient(arv)
+	if err != nil {
+		s3ErrorResponse(w, r, fmt.Errorf("error setting up keep client: %s", err))
+		return true
+	}
+	kc.RequestID = r.Header.Get("X-Request-Id")
+	client := (&arvados.Client{
+		APIHost:   arv.ApiServer,
+		AuthToken: arv.ApiToken,
+		Insecure:  arv.ApiInsecure,
+	}).WithRequestID(r.Header.Get("X-Request-Id"))
+
+	bucket, key := s3splitPath(r.URL.Path)
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		err = h.s3listBuckets(w, r, client)
+	case bucket == "":
+		err = newS3Error(http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource")
+	case key == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		fs := client.SiteFileSystem(kc)
+		fs.ForwardSlashNameSubstitution(h.Config.cluster.Collections.ForwardSlashNameSubstitution)
+		if _, err = fs.Stat("/by_id/" + bucket); os.IsNotExist(err) {
+			err = errS3NoSuchBucket
+		} else if err == nil && r.Method == http.MethodGet {
+			err = h.s3list(w, r, fs, bucket)
+		}
+	case key == "":
+		err = newS3Error(http.StatusMethodNotAllowed, "MethodNotAllowed", "Creating and deleting buckets is not supported")
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		fs := client.SiteFileSystem(kc)
+		fs.ForwardSlashNameSubstitution(h.Config.cluster.Collections.ForwardSlashNameSubstitution)
+		err = h.s3getObject(w, r, fs, bucket, key)
+	case r.Method == http.MethodPut || r.Method == http.MethodDelete:
+		err = h.s3writeObject(w, r, arv, kc, client, bucket, key)
+	default:
+		err = newS3Error(http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource")
+	}
+	if err != nil {
+		s3ErrorResponse(w, r, err)
+	}
+	return true
+}
+
+// s3splitPath returns the bucket and object key addressed by a
+// path-style S3 request path. A bucket that looks like a portable
+// data hash with "+" replaced by "-" (which S3 clients are more
+// likely to accept as a bucket name) is converted back to a PDH.
+func s3splitPath(p string) (bucket, key string) {
+	p = strings.TrimPrefix(p, "/")
+	if i := strings.Index(p, "/"); i >= 0 {
+		bucket, key = p[:i], p[i+1:]
+	} else {
+		bucket = p
+	}
+	if pdh := urlPDHDecoder.Replace(bucket); pdh != bucket && arvadosclient.PDHMatch(pdh) {
+		bucket = pdh
+	}
+	return
+}
+
+type s3Owner struct {
+	ID          string
+	DisplayName string
+}
+
+type s3Bucket struct {
+	Name         string
+	CreationDate string
+}
+
+type s3ListAllMyBucketsResult struct {
+	XMLName xml.Name   `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+// s3listBuckets responds with the projects and collections in the
+// current user's home project.
+func (h *handler) s3listBuckets(w http.ResponseWriter, r *http.Request, client *arvados.Client) error {
+	var user arvados.User
+	err := client.RequestAndDecode(&user, "GET", "arvados/v1/users/current", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp := s3ListAllMyBucketsResult{
+		Owner:   s3Owner{ID: user.UUID, DisplayName: user.FullName},
+		Buckets: []s3Bucket{},
+	}
+	filters := []arvados.Filter{{Attr: "owner_uuid", Operator: "=", Operand: user.UUID}}
+	params := arvados.ResourceListParams{
+		Count:   "none",
+		Filters: filters,
+		Order:   "uuid",
+		Select:  []string{"uuid", "created_at"},
+	}
+	for {
+		var list arvados.CollectionList
+		err = client.RequestAndDecode(&list, "GET", "arvados/v1/collections", nil, params)
+		if err != nil {
+			return err
+		}
+		if len(list.Items) == 0 {
+			break
+		}
+		for _, coll := range list.Items {
+			resp.Buckets = append(resp.Buckets, s3Bucket{Name: coll.UUID, CreationDate: coll.CreatedAt.UTC().Format(time.RFC3339)})
+		}
+		params.Filters = append(filters, arvados.Filter{Attr: "uuid", Operator: ">", Operand: list.Items[len(list.Items)-1].UUID})
+	}
+	filters = append(filters, arvados.Filter{Attr: "group_class", Operator: "=", Operand: "project"})
+	params.Filters = filters
+	for {
+		var list arvados.GroupList
+		err = client.RequestAndDecode(&list, "GET", "arvados/v1/groups", nil, params)
+		if err != nil {
+			return err
+		}
+		if len(list.Items) == 0 {
+			break
+		}
+		for _, group := range list.Items {
+			resp.Buckets = append(resp.Buckets, s3Bucket{Name: group.UUID, CreationDate: group.CreatedAt.UTC().Format(time.RFC3339)})
+		}
+		params.Filters = append(filters, arvados.Filter{Attr: "uuid", Operator: ">", Operand: list.Items[len(list.Items)-1].UUID})
+	}
+	sort.Slice(resp.Buckets, func(i, j int) bool {
+		return resp.Buckets[i].Name < resp.Buckets[j].Name
+	})
+	s3XMLResponse(w, r, resp)
+	return nil
+}
+
+type s3Object struct {
+	Key          string
+	LastModified string
+	Size         int64
+	StorageClass string
+}
+
+type s3CommonPrefix struct {
+	Prefix string
+}
+
+type s3ListResult struct {
+	Name           string
+	Prefix         string
+	Delimiter      string `xml:",omitempty"`
+	MaxKeys        int
+	IsTruncated    bool
+	Contents       []s3Object
+	CommonPrefixes []s3CommonPrefix
+}
+
+type s3ListV1Result struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	s3ListResult
+	Marker     string
+	NextMarker string `xml:",omitempty"`
+}
+
+type s3ListV2Result struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	s3ListResult
+	KeyCount              int
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+}
+
+// s3list responds to a ListObjects (V1) or ListObjectsV2 request
+// with the files in the given bucket.
+func (h *handler) s3list(w http.ResponseWriter, r *http.Request, fs arvados.CustomFileSystem, bucket string) error {
+	q := r.URL.Query()
+	v2 := q.Get("list-type") == "2"
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	maxKeys := s3MaxKeys
+	if s := q.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return newS3Error(http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	marker := q.Get("marker")
+	if v2 {
+		marker = q.Get("start-after")
+		if token := q.Get("continuation-token"); token != "" {
+			buf, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				return newS3Error(http.StatusBadRequest, "InvalidArgument", "invalid continuation-token")
+			}
+			marker = string(buf)
+		}
+	}
+
+	// Get one more entry than we need, so we know whether the
+	// result is truncated.
+	objects, prefixes, err := s3walk(fs, "/by_id/"+bucket, prefix, delimiter, marker, maxKeys+1)
+	if err != nil {
+		return err
+	}
+
+	// Merge objects and common prefixes into a single sorted
+	// sequence, starting after marker, and stop after maxKeys.
+	result := s3ListResult{
+		Name:      bucket,
+		Prefix:    prefix,
+		Delimiter: delimiter,
+		MaxKeys:   maxKeys,
+	}
+	var last string
+	oi, pi := 0, 0
+	for oi < len(objects) || pi < len(prefixes) {
+		var name string
+		isPrefix := oi == len(objects) || (pi < len(prefixes) && prefixes[pi] < objects[oi].Key)
+		if isPrefix {
+			name = prefixes[pi]
+			pi++
+		} else {
+			name = objects[oi].Key
+			oi++
+		}
+		if name <= marker {
+			continue
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) >= maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if isPrefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: name})
+		} else {
+			result.Contents = append(result.Contents, objects[oi-1])
+		}
+		last = name
+	}
+
+	if v2 {
+		resp := s3ListV2Result{
+			s3ListResult:      result,
+			KeyCount:          len(result.Contents) + len(result.CommonPrefixes),
+			ContinuationToken: q.Get("continuation-token"),
+			StartAfter:        q.Get("start-after"),
+		}
+		if result.IsTruncated {
+			resp.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+		s3XMLResponse(w, r, resp)
+	} else {
+		resp := s3ListV1Result{
+			s3ListResult: result,
+			Marker:       marker,
+		}
+		if result.IsTruncated && delimiter != "" {
+			// Clients use the last key as the next marker
+			// unless NextMarker is given, but NextMarker
+			// is only documented in responses to
+			// requests that specify a delimiter.
+			resp.NextMarker = last
+		}
+		s3XMLResponse(w, r, resp)
+	}
+	return nil
+}
+
+// errS3WalkDone is used to stop s3walk once it has found enough
+// entries.
+var errS3WalkDone = errors.New("done")
+
+// s3walk returns the files below root whose paths (relative to root)
+// begin with prefix and sort after marker, sorted by path, along with
+// the "common prefixes" that group the remaining matches when a
+// delimiter is given. It stops after finding limit entries (files
+// plus common prefixes).
+//
+// Directories are visited in sorted order, so s3walk does not need
+// to load directories whose entries would all sort before marker or
+// after the last entry returned. When the delimiter is "/", s3walk
+// also avoids loading subdirectories that would only be reported as
+// common prefixes.
+func s3walk(fs arvados.CustomFileSystem, root, prefix, delimiter, marker string, limit int) ([]s3Object, []string, error) {
+	var objects []s3Object
+	var prefixes []string
+	found := func() error {
+		if len(objects)+len(prefixes) >= limit {
+			return errS3WalkDone
+		}
+		return nil
+	}
+	addPrefix := func(p string) error {
+		if p <= marker || (len(prefixes) > 0 && prefixes[len(prefixes)-1] == p) {
+			return nil
+		}
+		prefixes = append(prefixes, p)
+		return found()
+	}
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		fspath := root
+		if dir != "" {
+			fspath += "/" + strings.TrimSuffix(dir, "/")
+		}
+		f, err := fs.Open(fspath)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		defer f.Close()
+		ents, err := f.Readdir(-1)
+		if err != nil {
+			return err
+		}
+		keys := make([]string, len(ents))
+		for i, ent := range ents {
+			keys[i] = dir + ent.Name()
+			if ent.IsDir() {
+				keys[i] += "/"
+			}
+		}
+		sort.Sort(s3walkEntries{keys, ents})
+		for i, ent := range ents {
+			key := keys[i]
+			if ent.IsDir() {
+				if strings.HasPrefix(prefix, key) {
+					// Prefix is below this dir
+				} else if !strings.HasPrefix(key, prefix) {
+					continue
+				} else if delimiter == "/" {
+					i := strings.Index(key[len(prefix):], "/")
+					if err := addPrefix(key[:len(prefix)+i+1]); err != nil {
+						return err
+					}
+					continue
+				}
+				if key < marker && !strings.HasPrefix(marker, key) {
+					// Everything in this dir sorts
+					// before marker.
+					continue
+				}
+				if err := walk(key); err != nil {
+					return err
+				}
+				continue
+			}
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if delimiter != "" {
+				if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+					if err := addPrefix(key[:len(prefix)+i+len(delimiter)]); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if key <= marker {
+				continue
+			}
+			objects = append(objects, s3Object{
+				Key:          key,
+				LastModified: ent.ModTime().UTC().Format("2006-01-02T15:04:05.000Z"),
+				Size:         ent.Size(),
+				StorageClass: "STANDARD",
+			})
+			if err := found(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// Start at the deepest directory that contains everything
+	// matching prefix.
+	startdir := prefix[:strings.LastIndex(prefix, "/")+1]
+	if err := walk(startdir); err != nil && err != errS3WalkDone {
+		return nil, nil, err
+	}
+	return objects, prefixes, nil
+}
+
+// s3walkEntries sorts directory entries by key. A subdirectory's
+// key has a trailing "/", so all of the keys below it sort
+// immediately after it.
+type s3walkEntries struct {
+	keys []string
+	ents []os.FileInfo
+}
+
+func (se s3walkEntries) Len() int           { return len(se.keys) }
+func (se s3walkEntries) Less(i, j int) bool { return se.keys[i] < se.keys[j] }
+func (se s3walkEntries) Swap(i, j int) {
+	se.keys[i], se.keys[j] = se.keys[j], se.keys[i]
+	se.ents[i], se.ents[j] = se.ents[j], se.ents[i]
+}
+
+// s3getObject responds to a GetObject or HeadObject request.
+func (h *handler) s3getObject(w http.ResponseWriter, r *http.Request, fs arvados.CustomFileSystem, bucket, key string) error {
+	if _, err := fs.Stat("/by_id/" + bucket); os.IsNotExist(err) {
+		return errS3NoSuchBucket
+	} else if err != nil {
+		return err
+	}
+	f, err := fs.Open("/by_id/" + bucket + "/" + key)
+	if os.IsNotExist(err) {
+		return errS3NoSuchKey
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	} else if fi.IsDir() {
+		return errS3NoSuchKey
+	}
+	http.ServeContent(w, r, path.Base(key), fi.ModTime(), f)
+	return nil
+}
+
+// s3writeObject responds to a PutObject or DeleteObject request.
+//
+// The bucket must be a collection UUID, or a project UUID in which
+// case the leading components of key name a collection (possibly in
+// a subproject). A PutObject request that names a collection that
+// does not exist yet creates it.
+func (h *handler) s3writeObject(w http.ResponseWriter, r *http.Request, arv *arvadosclient.ArvadosClient, kc *keepclient.KeepClient, client *arvados.Client, bucket, key string) error {
+	if arvadosclient.PDHMatch(bucket) {
+		return errS3ReadOnly
+	} else if !strings.Contains(bucket, "-4zz18-") && !strings.Contains(bucket, "-j7d0g-") {
+		return errS3NoSuchBucket
+	}
+	collectionID, fspath, err := h.s3resolveCollection(client, bucket, key, r.Method == http.MethodPut)
+	if err != nil {
+		return err
+	}
+	collection, err := h.Config.Cache.Get(arv, collectionID, true)
+	if srvErr, ok := err.(arvadosclient.APIServerError); ok && srvErr.HttpStatusCode == http.StatusNotFound {
+		return errS3NoSuchBucket
+	} else if err != nil {
+		return err
+	}
+	fs, err := collection.FileSystem(client, kc)
+	if err != nil {
+		return err
+	}
+	if err := h.s3checkLock(collection.UUID, fspath); err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodDelete {
+		// Deleting a nonexistent key (or a directory, which
+		// isn't an object) succeeds without doing anything.
+		if fi, err := fs.Stat(fspath); err == nil && !fi.IsDir() {
+			if err := fs.Remove(fspath); err != nil {
+				return err
+			}
+			if err := h.Config.Cache.Update(client, *collection, fs); err != nil {
+				return err
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	if strings.HasSuffix(fspath, "/") || fspath == "" {
+		// Zero-length objects with names ending in "/" are
+		// how S3 clients create "folders".
+		if r.ContentLength > 0 {
+			return newS3Error(http.StatusBadRequest, "InvalidArgument", "object names ending in \"/\" must have zero length")
+		}
+		if dir := strings.TrimSuffix(fspath, "/"); dir != "" {
+			if err := s3mkdirs(fs, dir); err != nil {
+				return err
+			}
+		}
+		if err := h.Config.Cache.Update(client, *collection, fs); err != nil {
+			return err
+		}
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	if dir := path.Dir(fspath); dir != "." {
+		if err := s3mkdirs(fs, dir); err != nil {
+			return err
+		}
+	}
+	f, err := fs.OpenFile(fspath, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	md5hash := md5.New()
+	var sha256hash hash.Hash
+	if r.Header.Get("X-Amz-Content-Sha256") != s3UnsignedPayload {
+		sha256hash = sha256.New()
+	}
+	var dst io.Writer = io.MultiWriter(f, md5hash)
+	if sha256hash != nil {
+		dst = io.MultiWriter(dst, sha256hash)
+	}
+	_, err = io.Copy(dst, r.Body)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if expect := r.Header.Get("Content-Md5"); expect != "" && expect != base64.StdEncoding.EncodeToString(md5hash.Sum(nil)) {
+		return errS3BadDigest
+	}
+	if sha256hash != nil && r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sha256hash.Sum(nil)) {
+		return errS3SHA256Mismatch
+	}
+	if err = h.Config.Cache.Update(client, *collection, fs); err != nil {
+		return err
+	}
+	w.Header().Set("ETag", `"`+hex.EncodeToString(md5hash.Sum(nil))+`"`)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// s3checkLock returns errS3Locked if the given path in the
+// collection is covered by a WebDAV lock on the path itself or one of
+// its parent directories. S3 clients can't submit lock tokens, so
+// any such lock conflicts with an S3 write.
+func (h *handler) s3checkLock(collectionUUID, fspath string) error {
+	ls := h.lockSystem(collectionUUID, false)
+	now := time.Now()
+	token, err := ls.Create(now, webdav.LockDetails{Root: fspath, ZeroDepth: true})
+	if err == webdav.ErrLocked {
+		return errS3Locked
+	} else if err != nil {
+		return err
+	}
+	return ls.Unlock(now, token)
+}
+
+// s3mkdirs creates dir and any missing parent directories.
+func s3mkdirs(fs arvados.CollectionFileSystem, dir string) error {
+	var parent string
+	for _, name := range strings.Split(dir, "/") {
+		parent = path.Join(parent, name)
+		if err := fs.Mkdir(parent, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// s3resolveCollection returns the UUID of the collection addressed
+// by the given bucket and key, along with the path of the object
+// within that collection.
+//
+// If the bucket is a project, each leading component of key is
+// looked up by name: projects are traversed until a collection is
+// found. If create is true and the collection does not exist, it is
+// created in the last project found.
+func (h *handler) s3resolveCollection(client *arvados.Client, bucket, key string, create bool) (string, string, error) {
+	if strings.Contains(bucket, "-4zz18-") {
+		return bucket, key, nil
+	}
+	parent := bucket
+	for {
+		i := strings.Index(key, "/")
+		if i < 0 {
+			// Files can only be stored in collections,
+			// not directly in projects.
+			return "", "", errS3AccessDenied
+		}
+		name := key[:i]
+		key = key[i+1:]
+		if subst := h.Config.cluster.Collections.ForwardSlashNameSubstitution; subst != "" {
+			name = strings.Replace(name, subst, "/", -1)
+		}
+		var contents arvados.CollectionList
+		err := client.RequestAndDecode(&contents, "GET", "arvados/v1/groups/"+parent+"/contents", nil, arvados.ResourceListParams{
+			Count: "none",
+			Filters: []arvados.Filter{
+				{Attr: "name", Operator: "=", Operand: name},
+				{Attr: "uuid", Operator: "is_a", Operand: []string{"arvados#collection", "arvados#group"}},
+				{Attr: "groups.group_class", Operator: "=", Operand: "project"},
+			},
+		})
+		if terr, ok := err.(*arvados.TransactionError); ok && terr.StatusCode == http.StatusNotFound {
+			return "", "", errS3NoSuchBucket
+		} else if err != nil {
+			return "", "", err
+		}
+		if len(contents.Items) == 0 {
+			if !create {
+				return "", "", errS3NoSuchKey
+			}
+			var coll arvados.Collection
+			err = client.RequestAndDecode(&coll, "POST", "arvados/v1/collections", nil, map[string]interface{}{
+				"collection": map[string]string{
+					"owner_uuid": parent,
+					"name":       name,
+				},
+			})
+			if err != nil {
+				return "", "", err
+			}
+			return coll.UUID, key, nil
+		}
+		uuid := contents.Items[0].UUID
+		if strings.Contains(uuid, "-4zz18-") {
+			return uuid, key, nil
+		}
+		parent = uuid
+	}
+}
+
+// checks3signature verifies the AWS Signature Version 4
+// Authorization header in r, and returns the Arvados token that
+// corresponds to its access key.
+//
+// The access key is either the UUID of an Arvados token (in which
+// case the secret key is the token's secret part, and the token is
+// looked up using the cluster's SystemRootToken), or an entire
+// Arvados token (in which case the secret key is the same token). In
+// the latter case, "/" in a v2 token can be replaced with "_" so the
+// access key doesn't get confused with the rest of the credential
+// scope.
+func (h *handler) checks3signature(r *http.Request) (string, error) {
+	var key, scope, signedHeaders, signature string
+	authstring := strings.TrimPrefix(r.Header.Get("Authorization"), s3SignAlgorithm+" ")
+	for _, cmpt := range strings.Split(authstring, ",") {
+		cmpt = strings.TrimSpace(cmpt)
+		split := strings.SplitN(cmpt, "=", 2)
+		switch {
+		case len(split) != 2:
+			// (?) ignore
+		case split[0] == "Credential":
+			keyandscope := strings.SplitN(split[1], "/", 2)
+			if len(keyandscope) == 2 {
+				key, scope = keyandscope[0], keyandscope[1]
+			}
+		case split[0] == "SignedHeaders":
+			signedHeaders = split[1]
+		case split[0] == "Signature":
+			signature = split[1]
+		}
+	}
+	if key == "" || signedHeaders == "" || signature == "" {
+		return "", newS3Error(http.StatusBadRequest, "AuthorizationHeaderMalformed", "Authorization header is missing Credential, SignedHeaders, or Signature")
+	}
+
+	t, err := time.Parse(s3DateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return "", newS3Error(http.StatusForbidden, "AccessDenied", "X-Amz-Date header is missing or invalid")
+	} else if skew := time.Since(t); skew > s3MaxClockSkew || skew < -s3MaxClockSkew {
+		return "", newS3Error(http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large")
+	}
+	if payloadHash := r.Header.Get("X-Amz-Content-Sha256"); payloadHash == "" {
+		return "", newS3Error(http.StatusBadRequest, "InvalidRequest", "X-Amz-Content-Sha256 header is missing")
+	} else if payloadHash == s3StreamingPayload {
+		return "", newS3Error(http.StatusNotImplemented, "NotImplemented", "Chunked (streaming) payload signing is not supported")
+	}
+
+	client := (&arvados.Client{
+		APIHost:  h.Config.cluster.Services.Controller.ExternalURL.Host,
+		Insecure: h.Config.cluster.TLS.Insecure,
+	}).WithRequestID(r.Header.Get("X-Request-Id"))
+	var aca arvados.APIClientAuthorization
+	var secret, token string
+	if len(key) == 27 && key[5:12] == "-gj3su-" {
+		if h.Config.cluster.SystemRootToken == "" {
+			return "", errS3InvalidAccessKey
+		}
+		ctx := arvados.ContextWithAuthorization(r.Context(), "Bearer "+h.Config.cluster.SystemRootToken)
+		err = client.RequestAndDecodeContext(ctx, &aca, "GET", "arvados/v1/api_client_authorizations/"+key, nil, nil)
+		secret = aca.APIToken
+		token = aca.TokenV2()
+	} else {
+		token = strings.Replace(key, "_", "/", -1)
+		ctx := arvados.ContextWithAuthorization(r.Context(), "Bearer "+token)
+		err = client.RequestAndDecodeContext(ctx, &aca, "GET", "arvados/v1/api_client_authorizations/current", nil, nil)
+		secret = key
+	}
+	if err != nil {
+		ctxlog.FromContext(r.Context()).WithError(err).WithField("UUID", key).Info("token lookup failed")
+		return "", errS3InvalidAccessKey
+	}
+
+	stringToSign, err := s3stringToSign(s3SignAlgorithm, scope, signedHeaders, r)
+	if err != nil {
+		return "", err
+	}
+	expect, err := s3signature(secret, scope, stringToSign)
+	if err != nil {
+		return "", err
+	} else if !hmac.Equal([]byte(expect), []byte(signature)) {
+		ctxlog.FromContext(r.Context()).WithField("stringToSign", stringToSign).Info("S3 signature mismatch")
+		return "", errS3SignatureInvalid
+	}
+	return token, nil
+}
+
+// s3stringToSign returns the V4 "string to sign" for r, given the
+// credential scope and signed headers from its Authorization header.
+func s3stringToSign(alg, scope, signedHeaders string, r *http.Request) (string, error) {
+	var canonicalHeaders string
+	for _, h := range strings.Split(signedHeaders, ";") {
+		var value string
+		switch h {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = strconv.FormatInt(r.ContentLength, 10)
+		default:
+			vals, ok := r.Header[http.CanonicalHeaderKey(h)]
+			if !ok {
+				return "", newS3Error(http.StatusBadRequest, "AuthorizationHeaderMalformed", "signed header "+h+" is missing from request")
+			}
+			trimmed := make([]string, len(vals))
+			for i, v := range vals {
+				trimmed[i] = strings.Join(strings.Fields(v), " ")
+			}
+			value = strings.Join(trimmed, ",")
+		}
+		canonicalHeaders += h + ":" + value + "\n"
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		s3escapePath(r.URL.Path),
+		s3querystring(r),
+		canonicalHeaders,
+		signedHeaders,
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	return strings.Join([]string{
+		alg,
+		r.Header.Get("X-Amz-Date"),
+		scope,
+		hashdigest(sha256.New(), canonicalRequest),
+	}, "\n"), nil
+}
+
+// s3signature returns the hex-encoded V4 signature of stringToSign.
+func s3signature(secretKey, scope, stringToSign string) (string, error) {
+	// scope is {datestamp}/{region}/{service}/aws4_request
+	drs := strings.Split(scope, "/")
+	if len(drs) != 4 {
+		return "", newS3Error(http.StatusBadRequest, "AuthorizationHeaderMalformed", fmt.Sprintf("invalid credential scope %q", scope))
+	}
+	key := hmacstring([]byte("AWS4"+secretKey), drs[0])
+	key = hmacstring(key, drs[1])
+	key = hmacstring(key, drs[2])
+	key = hmacstring(key, "aws4_request")
+	return hex.EncodeToString(hmacstring(key, stringToSign)), nil
+}
+
+func hmacstring(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, data)
+	return h.Sum(nil)
+}
+
+func hashdigest(h hash.Hash, payload string) string {
+	io.WriteString(h, payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// s3querystring returns the canonical query string of r: each
+// parameter name and value is URI-encoded, and the parameters are
+// sorted by name, then value.
+func s3querystring(r *http.Request) string {
+	type param struct{ k, v string }
+	var params []param
+	for k, vs := range r.URL.Query() {
+		for _, v := range vs {
+			params = append(params, param{s3escape(k), s3escape(v)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].k != params[j].k {
+			return params[i].k < params[j].k
+		}
+		return params[i].v < params[j].v
+	})
+	var parts []string
+	for _, p := range params {
+		parts = append(parts, p.k+"="+p.v)
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3escapePath URI-encodes each segment of a path the way S3 clients
+// do when computing the canonical request.
+func s3escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = s3escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3escape URI-encodes every byte of s except the unreserved
+// characters A-Z, a-z, 0-9, "-", ".", "_", and "~".
+func s3escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,437 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/net/webdav"
+	check "gopkg.in/check.v1"
+)
+
+func (s *UnitSuite) TestS3Signature(c *check.C) {
+	secret := "3kg6k6lzmp9kj5cpkcoxie963cmvjahbt2fod9zru30k1jqdmi"
+	signer := v4.NewSigner(credentials.NewStaticCredentials("zzzzz-gj3su-077z32aux8dg2s1", secret, ""), func(signer *v4.Signer) {
+		// S3 clients sign the request path as sent,
+		// without escaping it a second time.
+		signer.DisableURIPathEscaping = true
+	})
+	for _, trial := range []struct {
+		method string
+		url    string
+		body   string
+	}{
+		{"GET", "http://keep-web.example/", ""},
+		{"GET", "http://keep-web.example/zzzzz-4zz18-fy296fx3hot09f7?list-type=2&prefix=dir%2F&delimiter=%2F", ""},
+		{"GET", "http://keep-web.example/zzzzz-4zz18-fy296fx3hot09f7/file%20with%20spaces%2Band%2Bplus", ""},
+		{"PUT", "http://keep-web.example/zzzzz-4zz18-fy296fx3hot09f7/dir/newfile", "new file content"},
+	} {
+		c.Logf("trial %+v", trial)
+		req, err := http.NewRequest(trial.method, trial.url, nil)
+		c.Assert(err, check.IsNil)
+		req.Header.Set("Range", "bytes=1-2")
+		_, err = signer.Sign(req, bytes.NewReader([]byte(trial.body)), "s3", "us-east-1", time.Now())
+		c.Assert(err, check.IsNil)
+		// Server side sees Host in req.Host, not the header
+		req.Host = req.URL.Host
+
+		authz := strings.TrimPrefix(req.Header.Get("Authorization"), s3SignAlgorithm+" ")
+		var scope, signedHeaders, signature string
+		for _, cmpt := range strings.Split(authz, ", ") {
+			kv := strings.SplitN(cmpt, "=", 2)
+			switch kv[0] {
+			case "Credential":
+				scope = strings.SplitN(kv[1], "/", 2)[1]
+			case "SignedHeaders":
+				signedHeaders = kv[1]
+			case "Signature":
+				signature = kv[1]
+			}
+		}
+		stringToSign, err := s3stringToSign(s3SignAlgorithm, scope, signedHeaders, req)
+		c.Assert(err, check.IsNil)
+		expect, err := s3signature(secret, scope, stringToSign)
+		c.Assert(err, check.IsNil)
+		c.Check(expect, check.Equals, signature)
+
+		expect, err = s3signature("wrong"+secret, scope, stringToSign)
+		c.Assert(err, check.IsNil)
+		c.Check(expect, check.Not(check.Equals), signature)
+	}
+}
+
+func (s *UnitSuite) TestS3SplitPath(c *check.C) {
+	for _, trial := range []struct {
+		path   string
+		bucket string
+		key    string
+	}{
+		{"/", "", ""},
+		{"/zzzzz-4zz18-fy296fx3hot09f7", "zzzzz-4zz18-fy296fx3hot09f7", ""},
+		{"/zzzzz-4zz18-fy296fx3hot09f7/", "zzzzz-4zz18-fy296fx3hot09f7", ""},
+		{"/zzzzz-4zz18-fy296fx3hot09f7/dir/foo", "zzzzz-4zz18-fy296fx3hot09f7", "dir/foo"},
+		{"/1f4b0bc7583c2a7f9102c395f4ffc5e3-45/foo", "1f4b0bc7583c2a7f9102c395f4ffc5e3+45", "foo"},
+		{"/1f4b0bc7583c2a7f9102c395f4ffc5e3+45/foo", "1f4b0bc7583c2a7f9102c395f4ffc5e3+45", "foo"},
+	} {
+		bucket, key := s3splitPath(trial.path)
+		c.Check(bucket, check.Equals, trial.bucket, check.Commentf("%q", trial.path))
+		c.Check(key, check.Equals, trial.key, check.Commentf("%q", trial.path))
+	}
+}
+
+// openLogFS records the paths opened by s3walk.
+type openLogFS struct {
+	arvados.CustomFileSystem
+	opened []string
+}
+
+func (fs *openLogFS) Open(name string) (http.File, error) {
+	fs.opened = append(fs.opened, name)
+	return fs.CustomFileSystem.Open(name)
+}
+
+func (s *UnitSuite) TestS3Walk(c *check.C) {
+	fs := &openLogFS{CustomFileSystem: (&arvados.Client{}).SiteFileSystem(nil)}
+	_, err := fs.MountTmp("tmp")
+	c.Assert(err, check.IsNil)
+	for _, dir := range []string{"b", "c", "c/2"} {
+		c.Assert(fs.Mkdir("/tmp/"+dir, 0755), check.IsNil)
+	}
+	for _, name := range []string{"d", "c/2/x", "a", "b/2", "c/1", "b/1"} {
+		f, err := fs.OpenFile("/tmp/"+name, os.O_CREATE|os.O_WRONLY, 0644)
+		c.Assert(err, check.IsNil)
+		c.Assert(f.Close(), check.IsNil)
+	}
+
+	for _, trial := range []struct {
+		prefix    string
+		delimiter string
+		marker    string
+		limit     int
+		objects   []string
+		prefixes  []string
+		opened    []string
+	}{
+		{"", "", "", 100, []string{"a", "b/1", "b/2", "c/1", "c/2/x", "d"}, nil, []string{"", "b", "c", "c/2"}},
+		{"", "", "", 1, []string{"a"}, nil, []string{""}},
+		{"", "", "b/1", 3, []string{"b/2", "c/1", "c/2/x"}, nil, []string{"", "b", "c", "c/2"}},
+		{"", "", "c/1", 2, []string{"c/2/x", "d"}, nil, []string{"", "c", "c/2"}},
+		{"", "/", "", 100, []string{"a", "d"}, []string{"b/", "c/"}, []string{""}},
+		{"", "/", "b/", 2, []string{"d"}, []string{"c/"}, []string{""}},
+		{"c/", "/", "", 100, []string{"c/1"}, []string{"c/2/"}, []string{"c"}},
+	} {
+		comment := check.Commentf("%+v", trial)
+		fs.opened = nil
+		objects, prefixes, err := s3walk(fs, "/tmp", trial.prefix, trial.delimiter, trial.marker, trial.limit)
+		c.Assert(err, check.IsNil)
+		var keys []string
+		for _, obj := range objects {
+			keys = append(keys, obj.Key)
+		}
+		c.Check(keys, check.DeepEquals, trial.objects, comment)
+		c.Check(prefixes, check.DeepEquals, trial.prefixes, comment)
+		var opened []string
+		for _, p := range fs.opened {
+			opened = append(opened, strings.TrimPrefix(strings.TrimPrefix(p, "/tmp"), "/"))
+		}
+		c.Check(opened, check.DeepEquals, trial.opened, comment)
+	}
+}
+
+func (s *UnitSuite) TestS3CheckLock(c *check.C) {
+	h := handler{Config: newConfig(s.Config)}
+	h.lockStore = &memLockStore{}
+	coll := arvadostest.FooCollection
+	ls := h.lockSystem(coll, true)
+	token, err := ls.Create(time.Now(), webdav.LockDetails{Root: "/dir", Duration: time.Minute})
+	c.Assert(err, check.IsNil)
+
+	for _, trial := range []struct {
+		collection string
+		path       string
+		locked     bool
+	}{
+		{coll, "dir", true},
+		{coll, "dir/", true},
+		{coll, "dir/foo", true},
+		{coll, "dir/sub/foo", true},
+		{coll, "dirx", false},
+		{coll, "foo", false},
+		{coll, "", false},
+		{arvadostest.FooAndBarFilesInDirUUID, "dir/foo", false},
+	} {
+		err := h.s3checkLock(trial.collection, trial.path)
+		if trial.locked {
+			c.Check(err, check.Equals, errS3Locked, check.Commentf("%+v", trial))
+		} else {
+			c.Check(err, check.IsNil, check.Commentf("%+v", trial))
+		}
+	}
+
+	// Checking doesn't leave a lock behind.
+	locks, err := h.lockStore.Locks(coll, time.Now())
+	c.Check(err, check.IsNil)
+	c.Check(locks, check.HasLen, 1)
+
+	c.Assert(ls.Unlock(time.Now(), token), check.IsNil)
+	c.Check(h.s3checkLock(coll, "dir/foo"), check.IsNil)
+}
+
+func (s *IntegrationSuite) s3setup(c *check.C) (*s3.S3, arvados.Collection, func()) {
+	arv := arvados.NewClientFromEnv()
+	arv.AuthToken = arvadostest.ActiveToken
+	var coll arvados.Collection
+	err := arv.RequestAndDecode(&coll, "POST", "arvados/v1/collections", nil, map[string]interface{}{
+		"collection": map[string]string{
+			"owner_uuid":    arvadostest.AProjectUUID,
+			"name":          "keep-web s3 test",
+			"manifest_text": ". acbd18db4cc2f85cedef654fccc4a4d8+3 0:0:emptyfile 0:3:foo\n./dir1 acbd18db4cc2f85cedef654fccc4a4d8+3 0:3:bar\n",
+		},
+	})
+	c.Assert(err, check.IsNil)
+
+	accessKey := strings.Replace(arvadostest.ActiveTokenV2, "/", "_", -1)
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials(accessKey, accessKey, ""),
+		Endpoint:         aws.String("http://" + s.testServer.Addr),
+		Region:           aws.String("zzzzz"),
+		S3ForcePathStyle: aws.Bool(true),
+	}))
+	return s3.New(sess), coll, func() {
+		arv.RequestAndDecode(nil, "DELETE", "arvados/v1/collections/"+coll.UUID, nil, nil)
+	}
+}
+
+func (s *IntegrationSuite) TestS3ListBuckets(c *check.C) {
+	client, coll, cleanup := s.s3setup(c)
+	defer cleanup()
+	resp, err := client.ListBuckets(&s3.ListBucketsInput{})
+	c.Assert(err, check.IsNil)
+	c.Check(*resp.Owner.ID, check.Equals, arvadostest.ActiveUserUUID)
+	found := map[string]bool{}
+	for _, b := range resp.Buckets {
+		found[*b.Name] = true
+	}
+	c.Check(found[arvadostest.AProjectUUID], check.Equals, true)
+	c.Check(found[arvadostest.FooCollection], check.Equals, true)
+	// coll is in a subproject, not the home project
+	c.Check(found[coll.UUID], check.Equals, false)
+}
+
+func (s *IntegrationSuite) TestS3ListObjects(c *check.C) {
+	client, coll, cleanup := s.s3setup(c)
+	defer cleanup()
+
+	resp, err := client.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(coll.UUID)})
+	c.Assert(err, check.IsNil)
+	var keys []string
+	for _, obj := range resp.Contents {
+		keys = append(keys, *obj.Key)
+	}
+	c.Check(keys, check.DeepEquals, []string{"dir1/bar", "emptyfile", "foo"})
+	c.Check(*resp.IsTruncated, check.Equals, false)
+
+	resp, err = client.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(coll.UUID), Delimiter: aws.String("/")})
+	c.Assert(err, check.IsNil)
+	c.Check(resp.Contents, check.HasLen, 2)
+	c.Assert(resp.CommonPrefixes, check.HasLen, 1)
+	c.Check(*resp.CommonPrefixes[0].Prefix, check.Equals, "dir1/")
+	c.Check(*resp.KeyCount, check.Equals, int64(3))
+
+	resp, err = client.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(coll.UUID), Prefix: aws.String("dir1/")})
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.Contents, check.HasLen, 1)
+	c.Check(*resp.Contents[0].Key, check.Equals, "dir1/bar")
+	c.Check(*resp.Contents[0].Size, check.Equals, int64(3))
+
+	// Paginate one key at a time
+	keys = nil
+	err = client.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String(coll.UUID), MaxKeys: aws.Int64(1)}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		c.Check(page.Contents, check.HasLen, 1)
+		for _, obj := range page.Contents {
+			keys = append(keys, *obj.Key)
+		}
+		return true
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(keys, check.DeepEquals, []string{"dir1/bar", "emptyfile", "foo"})
+
+	// Project bucket: collections appear as top-level prefixes
+	resp, err = client.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(arvadostest.AProjectUUID), Prefix: aws.String(coll.Name + "/"), Delimiter: aws.String("/")})
+	c.Assert(err, check.IsNil)
+	c.Check(resp.Contents, check.HasLen, 2)
+	c.Check(resp.CommonPrefixes, check.HasLen, 1)
+
+	_, err = client.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String("zzzzz-4zz18-aaaaaaaaaaaaaaa")})
+	c.Assert(err, check.NotNil)
+	c.Check(err.(awserr.Error).Code(), check.Equals, "NoSuchBucket")
+}
+
+func (s *IntegrationSuite) TestS3GetObject(c *check.C) {
+	client, coll, cleanup := s.s3setup(c)
+	defer cleanup()
+
+	for _, bucket := range []string{coll.UUID, strings.Replace(coll.PortableDataHash, "+", "-", 1)} {
+		resp, err := client.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("dir1/bar")})
+		c.Assert(err, check.IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Check(err, check.IsNil)
+		c.Check(string(buf), check.Equals, "foo")
+
+		resp, err = client.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("foo"), Range: aws.String("bytes=1-")})
+		c.Assert(err, check.IsNil)
+		buf, err = ioutil.ReadAll(resp.Body)
+		c.Check(err, check.IsNil)
+		c.Check(string(buf), check.Equals, "oo")
+
+		head, err := client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String("foo")})
+		c.Assert(err, check.IsNil)
+		c.Check(*head.ContentLength, check.Equals, int64(3))
+
+		_, err = client.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("dir1")})
+		c.Assert(err, check.NotNil)
+		c.Check(err.(awserr.Error).Code(), check.Equals, "NoSuchKey")
+	}
+
+	// Same file via project bucket
+	resp, err := client.GetObject(&s3.GetObjectInput{Bucket: aws.String(arvadostest.AProjectUUID), Key: aws.String(coll.Name + "/dir1/bar")})
+	c.Assert(err, check.IsNil)
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "foo")
+}
+
+func (s *IntegrationSuite) TestS3PutDeleteObject(c *check.C) {
+	client, coll, cleanup := s.s3setup(c)
+	defer cleanup()
+
+	for i, trial := range []struct {
+		bucket string
+		key    string
+	}{
+		{coll.UUID, "newfile"},
+		{coll.UUID, "newdir/newfile"},
+		{arvadostest.AProjectUUID, coll.Name + "/newdir2/newfile"},
+	} {
+		content := fmt.Sprintf("new content %d", i)
+		put, err := client.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(trial.bucket),
+			Key:    aws.String(trial.key),
+			Body:   bytes.NewReader([]byte(content)),
+		})
+		c.Assert(err, check.IsNil)
+		c.Check(*put.ETag, check.Not(check.Equals), "")
+
+		resp, err := client.GetObject(&s3.GetObjectInput{Bucket: aws.String(trial.bucket), Key: aws.String(trial.key)})
+		c.Assert(err, check.IsNil)
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Check(err, check.IsNil)
+		c.Check(string(buf), check.Equals, content)
+
+		_, err = client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(trial.bucket), Key: aws.String(trial.key)})
+		c.Assert(err, check.IsNil)
+		_, err = client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(trial.bucket), Key: aws.String(trial.key)})
+		c.Check(err, check.NotNil)
+
+		// Deleting a nonexistent object succeeds
+		_, err = client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(trial.bucket), Key: aws.String(trial.key)})
+		c.Check(err, check.IsNil)
+	}
+
+	// PDH buckets are read-only
+	_, err := client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(strings.Replace(coll.PortableDataHash, "+", "-", 1)),
+		Key:    aws.String("newfile"),
+		Body:   bytes.NewReader([]byte("foo")),
+	})
+	c.Assert(err, check.NotNil)
+	c.Check(err.(awserr.Error).Code(), check.Equals, "MethodNotAllowed")
+}
+
+// S3 writes fail while the target is locked by a WebDAV client.
+func (s *IntegrationSuite) TestS3PutDeleteLocked(c *check.C) {
+	client, coll, cleanup := s.s3setup(c)
+	defer cleanup()
+
+	// A WebDAV client locks dir1.
+	s.testServer.Config.cluster.Services.WebDAVDownload.ExternalURL.Host = s.testServer.Addr
+	base := "http://" + s.testServer.Addr + "/c=" + coll.UUID + "/"
+	req, err := http.NewRequest("LOCK", base+"dir1", bytes.NewBufferString(`<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner>other client</D:owner></D:lockinfo>`))
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Authorization", "Bearer "+arvadostest.ActiveToken)
+	req.Header.Set("Timeout", "Second-600")
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	token := resp.Header.Get("Lock-Token")
+
+	_, err = client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(coll.UUID),
+		Key:    aws.String("dir1/bar"),
+		Body:   bytes.NewReader([]byte("new content")),
+	})
+	c.Assert(err, check.NotNil)
+	c.Check(err.(awserr.Error).Code(), check.Equals, "OperationAborted")
+	_, err = client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(coll.UUID), Key: aws.String("dir1/bar")})
+	c.Assert(err, check.NotNil)
+	c.Check(err.(awserr.Error).Code(), check.Equals, "OperationAborted")
+
+	// Other files in the collection can still be written.
+	_, err = client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(coll.UUID),
+		Key:    aws.String("foo"),
+		Body:   bytes.NewReader([]byte("new content")),
+	})
+	c.Check(err, check.IsNil)
+
+	req, err = http.NewRequest("UNLOCK", base+"dir1", nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Authorization", "Bearer "+arvadostest.ActiveToken)
+	req.Header.Set("Lock-Token", token)
+	resp, err = http.DefaultClient.Do(req)
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, check.Equals, http.StatusNoContent)
+	_, err = client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(coll.UUID), Key: aws.String("dir1/bar")})
+	c.Check(err, check.IsNil)
+}
+
+func (s *IntegrationSuite) TestS3BadSignature(c *check.C) {
+	accessKey := strings.Replace(arvadostest.ActiveTokenV2, "/", "_", -1)
+	for _, trial := range []struct {
+		accessKey string
+		secretKey string
+		code      string
+	}{
+		{accessKey, "wrong" + accessKey, "SignatureDoesNotMatch"},
+		{"v2_zzzzz-gj3su-077z32aux8dg2s1_badsecret", "v2_zzzzz-gj3su-077z32aux8dg2s1_badsecret", "InvalidAccessKeyId"},
+	} {
+		sess := session.Must(session.NewSession(&aws.Config{
+			Credentials:      credentials.NewStaticCredentials(trial.accessKey, trial.secretKey, ""),
+			Endpoint:         aws.String("http://" + s.testServer.Addr),
+			Region:           aws.String("zzzzz"),
+			S3ForcePathStyle: aws.Bool(true),
+		}))
+		_, err := s3.New(sess).ListBuckets(&s3.ListBucketsInput{})
+		c.Assert(err, check.NotNil)
+		c.Check(err.(awserr.Error).Code(), check.Equals, trial.code)
+	}
+}
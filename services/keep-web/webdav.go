@@ -160,9 +160,10 @@ func (readEOF) Read(p []byte) (int, error) {
 // conflicting locks and releasing non-existent locks.  This might
 // confuse some clients if they try to probe for correctness.
 //
-// Currently this is a moot point: the LOCK and UNLOCK methods are not
-// accepted by keep-web, so it suffices to implement the
-// webdav.LockSystem interface.
+// noLockSystem is used for read-only filesystems, where LOCK and
+// UNLOCK requests are rejected before reaching the webdav handler,
+// and when locking is disabled by setting Collections.WebDAVLocks to
+// "none". Otherwise, writable collections use collectionLockSystem.
 type noLockSystem struct{}
 
 func (*noLockSystem) Confirm(time.Time, string, string, ...webdav.Condition) (func(), error) {
@@ -310,6 +310,28 @@ func (v *AzureBlobVolume) get(ctx context.Context, loc string, buf []byte) (int,
 	return actualSize, nil
 }
 
+// ReadBlockHeader implements BlockHeaderReader, using a range
+// request so only the requested bytes are transferred. Like
+// IndexTo, it does not check whether the block has been trashed.
+func (v *AzureBlobVolume) ReadBlockHeader(ctx context.Context, loc string, buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	rdr, err := v.container.GetBlobRange(loc, 0, len(buf)-1, nil)
+	if err != nil && strings.Contains(err.Error(), "StatusCode=416") {
+		// Range not satisfiable, i.e., the blob is empty.
+		return 0, nil
+	} else if err != nil {
+		return 0, v.translateError(err)
+	}
+	defer rdr.Close()
+	n, err := io.ReadFull(rdr, buf)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
 // Compare the given data with existing stored data.
 func (v *AzureBlobVolume) Compare(ctx context.Context, loc string, expect []byte) error {
 	trashed, _, err := v.checkTrashed(loc)
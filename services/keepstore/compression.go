@@ -0,0 +1,596 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Compressed blocks are stored with a 9-byte header: the 4-byte
+// magic string, a 1-byte codec ID, and the uncompressed size as a
+// 4-byte big-endian integer. Blocks that don't start with the magic
+// string are stored verbatim, which lets compressed and uncompressed
+// blocks coexist on a volume. (Put never stores a block verbatim if
+// it starts with the magic string.)
+const compressedHeaderSize = 9
+
+var compressedMagic = []byte("\x00KZ\xa7")
+
+type compressionCodec struct {
+	id         byte
+	compress   func(dst *bytes.Buffer, src []byte) error
+	decompress func(dst []byte, src []byte) (int, error)
+}
+
+var compressionCodecs = map[string]*compressionCodec{
+	"none": {
+		id: 0,
+		compress: func(dst *bytes.Buffer, src []byte) error {
+			_, err := dst.Write(src)
+			return err
+		},
+		decompress: func(dst, src []byte) (int, error) {
+			if len(src) > len(dst) {
+				return 0, errors.New("decompressed data too long")
+			}
+			return copy(dst, src), nil
+		},
+	},
+	"gzip": {
+		id: 1,
+		compress: func(dst *bytes.Buffer, src []byte) error {
+			w := gzip.NewWriter(dst)
+			_, err := w.Write(src)
+			if err != nil {
+				return err
+			}
+			return w.Close()
+		},
+		decompress: func(dst, src []byte) (int, error) {
+			r, err := gzip.NewReader(bytes.NewReader(src))
+			if err != nil {
+				return 0, err
+			}
+			n, err := io.ReadFull(r, dst)
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				err = nil
+			} else if err == nil {
+				// Filled dst -- make sure there's no
+				// more data.
+				var b [1]byte
+				if _, err = r.Read(b[:]); err == io.EOF {
+					err = nil
+				} else if err == nil {
+					err = errors.New("decompressed data too long")
+				}
+			}
+			return n, err
+		},
+	},
+	"zstd": {
+		id: 2,
+		compress: func(dst *bytes.Buffer, src []byte) error {
+			dst.Write(zstdEncoder.EncodeAll(src, nil))
+			return nil
+		},
+		decompress: func(dst, src []byte) (int, error) {
+			out, err := zstdDecoder.DecodeAll(src, dst[:0])
+			if err != nil {
+				return 0, err
+			}
+			if len(out) > len(dst) || (len(out) > 0 && &out[0] != &dst[0]) {
+				return 0, errors.New("decompressed data too long")
+			}
+			return len(out), nil
+		},
+	},
+}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(BlockSize))
+)
+
+func compressionCodecByID(id byte) *compressionCodec {
+	for _, codec := range compressionCodecs {
+		if codec.id == id {
+			return codec
+		}
+	}
+	return nil
+}
+
+// compressionBufs holds BlockSize buffers used to hold compressed
+// data. These are separate from the main buffer pool, so a request
+// that already holds a buffer can't deadlock waiting for a second
+// one.
+var compressionBufs = sync.Pool{New: func() interface{} { return make([]byte, BlockSize) }}
+
+// compressedIndexConcurrency is the maximum number of concurrent
+// header reads when IndexTo needs to find the uncompressed size of
+// blocks it hasn't seen before.
+const compressedIndexConcurrency = 8
+
+// compressedVolume wraps a Volume, transparently compressing blocks
+// on Put and decompressing them on Get and Compare. Block locators
+// and index sizes refer to the uncompressed data.
+type compressedVolume struct {
+	Volume
+	codec     *compressionCodec
+	codecName string
+	stats     compressionStats
+
+	// Uncompressed sizes of blocks seen by Put, Get, and IndexTo,
+	// so IndexTo doesn't need to read every block's header every
+	// time. Entries for blocks that are no longer on the volume
+	// are removed by IndexTo.
+	sizes    map[[md5.Size]byte]compressedSize
+	sizesMtx sync.Mutex
+
+	// If sizeCache is not empty, sizes are loaded from that file
+	// at startup and saved there after IndexTo, so a restarted
+	// keepstore doesn't need to read every block's header again.
+	sizeCache  string
+	sizesDirty bool
+
+	bytesCV *prometheus.CounterVec
+	ratio   prometheus.Gauge
+}
+
+type compressedSize struct {
+	stored uint32
+	size   uint32
+}
+
+// sizeKey returns the binary hash of the given locator, for use as a
+// sizes key.
+func sizeKey(loc string) (key [md5.Size]byte, ok bool) {
+	if len(loc) < 2*md5.Size {
+		return key, false
+	}
+	_, err := hex.Decode(key[:], []byte(loc[:2*md5.Size]))
+	return key, err == nil
+}
+
+func newCompressedVolume(vol Volume, codecName, sizeCache string, metrics *volumeMetricsVecs) (*compressedVolume, error) {
+	codec, ok := compressionCodecs[codecName]
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression %q", codecName)
+	}
+	v := &compressedVolume{
+		Volume:    vol,
+		codec:     codec,
+		codecName: codecName,
+		sizes:     map[[md5.Size]byte]compressedSize{},
+		sizeCache: sizeCache,
+	}
+	if sizeCache != "" {
+		err := v.loadSizes()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error loading size cache: %s", err)
+		}
+	}
+	v.stats.Codec = codecName
+	lbls := prometheus.Labels{"device_id": vol.GetDeviceID()}
+	v.bytesCV, v.ratio = metrics.getCompressionVecsFor(lbls)
+	return v, nil
+}
+
+// String implements fmt.Stringer.
+func (v *compressedVolume) String() string {
+	return v.Volume.String() + " (" + v.codecName + ")"
+}
+
+// Put compresses and stores the block. If compression doesn't make
+// the block smaller, the block is stored verbatim.
+func (v *compressedVolume) Put(ctx context.Context, loc string, block []byte) error {
+	buf := compressionBufs.Get().([]byte)
+	defer compressionBufs.Put(buf)
+	data := bytes.NewBuffer(buf[:0])
+	data.Write(compressedMagic)
+	data.WriteByte(v.codec.id)
+	binary.Write(data, binary.BigEndian, uint32(len(block)))
+	err := v.codec.compress(data, block)
+	if err != nil {
+		return err
+	}
+
+	var stored []byte
+	if data.Len() < len(block) {
+		stored = data.Bytes()
+		atomic.AddUint64(&v.stats.CompressedBlocks, 1)
+	} else if bytes.HasPrefix(block, compressedMagic) {
+		// Can't store verbatim, because Get would try to
+		// decompress it.
+		data.Truncate(len(compressedMagic))
+		data.WriteByte(compressionCodecs["none"].id)
+		binary.Write(data, binary.BigEndian, uint32(len(block)))
+		data.Write(block)
+		stored = data.Bytes()
+		atomic.AddUint64(&v.stats.UncompressedBlocks, 1)
+	} else {
+		stored = block
+		atomic.AddUint64(&v.stats.UncompressedBlocks, 1)
+	}
+	err = v.Volume.Put(ctx, loc, stored)
+	if err != nil {
+		return err
+	}
+	v.setSize(loc, len(stored), len(block))
+	atomic.AddUint64(&v.stats.UncompressedBytes, uint64(len(block)))
+	atomic.AddUint64(&v.stats.StoredBytes, uint64(len(stored)))
+	v.bytesCV.WithLabelValues("uncompressed").Add(float64(len(block)))
+	v.bytesCV.WithLabelValues("stored").Add(float64(len(stored)))
+	v.ratio.Set(v.stats.ratio())
+	return nil
+}
+
+// Get retrieves the block and decompresses it (if needed) into buf.
+func (v *compressedVolume) Get(ctx context.Context, loc string, buf []byte) (int, error) {
+	n, err := v.Volume.Get(ctx, loc, buf)
+	if err != nil || !bytes.HasPrefix(buf[:n], compressedMagic) {
+		return n, err
+	}
+	tmp := compressionBufs.Get().([]byte)
+	defer compressionBufs.Put(tmp)
+	stored := copy(tmp, buf[:n])
+	size, err := v.decompress(buf, tmp[:stored])
+	if err != nil {
+		// Probably a block that was stored verbatim before
+		// compression was enabled, and happens to start with
+		// our magic string.
+		return copy(buf, tmp[:stored]), nil
+	}
+	v.setSize(loc, stored, size)
+	return size, nil
+}
+
+// Compare returns nil if Get(loc) would return the same content as
+// expect.
+func (v *compressedVolume) Compare(ctx context.Context, loc string, expect []byte) error {
+	buf := compressionBufs.Get().([]byte)
+	defer compressionBufs.Put(buf)
+	n, err := v.Get(ctx, loc, buf)
+	if err != nil {
+		return err
+	}
+	return compareReaderWithBuf(ctx, bytes.NewReader(buf[:n]), expect, loc[:32])
+}
+
+// decompress decodes the header and compressed data in src into
+// dst, and returns the uncompressed size.
+func (v *compressedVolume) decompress(dst, src []byte) (int, error) {
+	if len(src) < compressedHeaderSize {
+		return 0, errors.New("short header")
+	}
+	codec := compressionCodecByID(src[len(compressedMagic)])
+	if codec == nil {
+		return 0, fmt.Errorf("unknown codec ID %d", src[len(compressedMagic)])
+	}
+	size := int(binary.BigEndian.Uint32(src[len(compressedMagic)+1:]))
+	if size > len(dst) {
+		return 0, fmt.Errorf("uncompressed size %d exceeds buffer size %d", size, len(dst))
+	}
+	n, err := codec.decompress(dst[:size], src[compressedHeaderSize:])
+	if err != nil {
+		return 0, err
+	} else if n != size {
+		return 0, fmt.Errorf("uncompressed size %d does not match header (%d)", n, size)
+	}
+	return n, nil
+}
+
+func (v *compressedVolume) setSize(loc string, stored, size int) {
+	key, ok := sizeKey(loc)
+	if !ok {
+		return
+	}
+	v.sizesMtx.Lock()
+	defer v.sizesMtx.Unlock()
+	s := compressedSize{stored: uint32(stored), size: uint32(size)}
+	if v.sizes[key] != s {
+		v.sizes[key] = s
+		v.sizesDirty = true
+	}
+}
+
+// sizeRecordSize is the length of a size cache file record: the
+// binary block hash, then the stored and uncompressed sizes as
+// 4-byte big-endian integers.
+const sizeRecordSize = md5.Size + 8
+
+// loadSizes adds the entries in the size cache file to v.sizes.
+func (v *compressedVolume) loadSizes() error {
+	data, err := ioutil.ReadFile(v.sizeCache)
+	if err != nil {
+		return err
+	}
+	if len(data)%sizeRecordSize != 0 {
+		return fmt.Errorf("%s: file size %d is not a multiple of %d", v.sizeCache, len(data), sizeRecordSize)
+	}
+	v.sizesMtx.Lock()
+	defer v.sizesMtx.Unlock()
+	for rec := data; len(rec) > 0; rec = rec[sizeRecordSize:] {
+		var key [md5.Size]byte
+		copy(key[:], rec)
+		v.sizes[key] = compressedSize{
+			stored: binary.BigEndian.Uint32(rec[md5.Size:]),
+			size:   binary.BigEndian.Uint32(rec[md5.Size+4:]),
+		}
+	}
+	return nil
+}
+
+// saveSizes writes v.sizes to the size cache file, if sizes have
+// changed since the last save.
+func (v *compressedVolume) saveSizes() error {
+	if v.sizeCache == "" {
+		return nil
+	}
+	v.sizesMtx.Lock()
+	defer v.sizesMtx.Unlock()
+	if !v.sizesDirty {
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(v.sizeCache), "."+filepath.Base(v.sizeCache)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	var rec [sizeRecordSize]byte
+	for key, s := range v.sizes {
+		copy(rec[:], key[:])
+		binary.BigEndian.PutUint32(rec[md5.Size:], s.stored)
+		binary.BigEndian.PutUint32(rec[md5.Size+4:], s.size)
+		w.Write(rec[:])
+	}
+	err = w.Flush()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.Name(), v.sizeCache)
+	if err != nil {
+		return err
+	}
+	v.sizesDirty = false
+	return nil
+}
+
+// IndexTo writes the wrapped volume's index, with the stored sizes
+// of compressed blocks replaced by their uncompressed sizes.
+func (v *compressedVolume) IndexTo(prefix string, writer io.Writer) error {
+	pr, pw := io.Pipe()
+	errIndex := make(chan error, 1)
+	go func() {
+		err := v.Volume.IndexTo(prefix, pw)
+		pw.CloseWithError(err)
+		errIndex <- err
+	}()
+
+	// Keys of all listed blocks, so we can remove sizes entries
+	// for blocks that are gone.
+	listed := map[[md5.Size]byte]bool{}
+
+	var wmtx sync.Mutex
+	var werr error
+	write := func(loc string, size int, mtime string) {
+		wmtx.Lock()
+		defer wmtx.Unlock()
+		if werr == nil {
+			_, werr = fmt.Fprintf(writer, "%s+%d %s\n", loc, size, mtime)
+		}
+	}
+
+	todo := make(chan []string)
+	var wg sync.WaitGroup
+	for i := 0; i < compressedIndexConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range todo {
+				loc, mtime := f[0], f[2]
+				stored, _ := strconv.Atoi(f[1])
+				size, err := v.uncompressedSize(loc, stored)
+				if err != nil {
+					// The block might have been
+					// deleted since it was listed.
+					continue
+				}
+				write(loc, size, mtime)
+			}
+		}()
+	}
+
+	scanner := bufio.NewScanner(pr)
+	var err error
+	for scanner.Scan() {
+		line := scanner.Text()
+		sp := strings.IndexByte(line, ' ')
+		plus := strings.IndexByte(line, '+')
+		if sp < 0 || plus < 0 || plus > sp {
+			err = fmt.Errorf("error parsing index line %q", line)
+			break
+		}
+		loc, stored, mtime := line[:plus], line[plus+1:sp], line[sp+1:]
+		if key, ok := sizeKey(loc); ok {
+			listed[key] = true
+		}
+		if s, ok := v.cachedSize(loc, stored); ok {
+			write(loc, s, mtime)
+		} else {
+			todo <- []string{loc, stored, mtime}
+		}
+	}
+	close(todo)
+	wg.Wait()
+	if err == nil {
+		err = scanner.Err()
+	}
+	if err != nil {
+		// Unblock the IndexTo goroutine if we stopped early.
+		pr.CloseWithError(err)
+		go io.Copy(ioutil.Discard, pr)
+		return err
+	}
+	if err := <-errIndex; err != nil {
+		return err
+	}
+	v.pruneSizes(prefix, listed)
+	if werr != nil {
+		return werr
+	}
+	return v.saveSizes()
+}
+
+// pruneSizes removes sizes entries for blocks with the given prefix
+// that are not in listed.
+func (v *compressedVolume) pruneSizes(prefix string, listed map[[md5.Size]byte]bool) {
+	v.sizesMtx.Lock()
+	defer v.sizesMtx.Unlock()
+	for key := range v.sizes {
+		if !listed[key] && strings.HasPrefix(hex.EncodeToString(key[:]), prefix) {
+			delete(v.sizes, key)
+			v.sizesDirty = true
+		}
+	}
+}
+
+func (v *compressedVolume) cachedSize(loc, stored string) (int, bool) {
+	n, err := strconv.Atoi(stored)
+	if err != nil {
+		return 0, false
+	}
+	key, ok := sizeKey(loc)
+	if !ok {
+		return 0, false
+	}
+	v.sizesMtx.Lock()
+	defer v.sizesMtx.Unlock()
+	if s, ok := v.sizes[key]; ok && int(s.stored) == n {
+		return int(s.size), true
+	}
+	return 0, false
+}
+
+// errHeaderComplete is used to stop ReadBlock after reading a block
+// header.
+var errHeaderComplete = errors.New("header complete")
+
+// headerWriter accepts up to len(buf) bytes, then returns
+// errHeaderComplete.
+type headerWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	n := copy(w.buf[w.n:], p)
+	w.n += n
+	if w.n == len(w.buf) {
+		return n, errHeaderComplete
+	}
+	return n, nil
+}
+
+// uncompressedSize reads the header of the given block (if the
+// wrapped volume implements BlockHeaderReader or BlockReader) or the
+// entire block (if not) to determine its uncompressed size. This is
+// only needed for blocks that aren't in the size cache.
+func (v *compressedVolume) uncompressedSize(loc string, stored int) (int, error) {
+	var hdr []byte
+	if hr, ok := v.Volume.(BlockHeaderReader); ok {
+		buf := make([]byte, compressedHeaderSize)
+		n, err := hr.ReadBlockHeader(context.Background(), loc, buf)
+		if err != nil {
+			return 0, err
+		}
+		hdr = buf[:n]
+	} else if br, ok := v.Volume.(BlockReader); ok {
+		w := &headerWriter{buf: make([]byte, compressedHeaderSize)}
+		err := br.ReadBlock(context.Background(), loc, w)
+		if err != nil && err != errHeaderComplete {
+			return 0, err
+		}
+		hdr = w.buf[:w.n]
+	} else {
+		buf := compressionBufs.Get().([]byte)
+		defer compressionBufs.Put(buf)
+		n, err := v.Volume.Get(context.Background(), loc, buf)
+		if err != nil {
+			return 0, err
+		}
+		hdr = buf[:n]
+	}
+	size := stored
+	if len(hdr) >= compressedHeaderSize && bytes.HasPrefix(hdr, compressedMagic) && compressionCodecByID(hdr[len(compressedMagic)]) != nil {
+		size = int(binary.BigEndian.Uint32(hdr[len(compressedMagic)+1:]))
+	}
+	v.setSize(loc, stored, size)
+	return size, nil
+}
+
+// InternalStats returns the wrapped volume's internal stats.
+func (v *compressedVolume) InternalStats() interface{} {
+	if is, ok := v.Volume.(InternalStatser); ok {
+		return is.InternalStats()
+	}
+	return nil
+}
+
+// CompressionStats returns compression counters for the blocks
+// written since keepstore started.
+func (v *compressedVolume) CompressionStats() *compressionStats {
+	return &compressionStats{
+		Codec:              v.stats.Codec,
+		UncompressedBytes:  atomic.LoadUint64(&v.stats.UncompressedBytes),
+		StoredBytes:        atomic.LoadUint64(&v.stats.StoredBytes),
+		CompressedBlocks:   atomic.LoadUint64(&v.stats.CompressedBlocks),
+		UncompressedBlocks: atomic.LoadUint64(&v.stats.UncompressedBlocks),
+		Ratio:              v.stats.ratio(),
+	}
+}
+
+type compressionStats struct {
+	Codec              string
+	UncompressedBytes  uint64
+	StoredBytes        uint64
+	CompressedBlocks   uint64
+	UncompressedBlocks uint64
+	// UncompressedBytes / StoredBytes
+	Ratio float64
+}
+
+func (s *compressionStats) ratio() float64 {
+	stored := atomic.LoadUint64(&s.StoredBytes)
+	if stored == 0 {
+		return 1
+	}
+	return float64(atomic.LoadUint64(&s.UncompressedBytes)) / float64(stored)
+}
@@ -0,0 +1,328 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&CompressedVolumeSuite{})
+
+type CompressedVolumeSuite struct {
+	cluster *arvados.Cluster
+	metrics *volumeMetricsVecs
+	unix    UnixVolumeSuite
+}
+
+func (s *CompressedVolumeSuite) SetUpTest(c *check.C) {
+	s.unix.SetUpTest(c)
+	s.cluster = s.unix.cluster
+	s.metrics = s.unix.metrics
+}
+
+func (s *CompressedVolumeSuite) TearDownTest(c *check.C) {
+	s.unix.TearDownTest(c)
+}
+
+type TestableCompressedVolume struct {
+	*compressedVolume
+	inner *TestableUnixVolume
+}
+
+func (s *CompressedVolumeSuite) newTestableCompressedVolume(c *check.C, cluster *arvados.Cluster, volume arvados.Volume, metrics *volumeMetricsVecs, codec string) *TestableCompressedVolume {
+	inner := s.unix.newTestableUnixVolume(c, cluster, volume, metrics, false)
+	v, err := newCompressedVolume(inner, codec, "", metrics)
+	c.Assert(err, check.IsNil)
+	return &TestableCompressedVolume{compressedVolume: v, inner: inner}
+}
+
+// PutRaw stores a block on the wrapped volume without compressing
+// it.
+func (v *TestableCompressedVolume) PutRaw(loc string, data []byte) {
+	v.inner.PutRaw(loc, data)
+}
+
+func (v *TestableCompressedVolume) TouchWithDate(loc string, lastPut time.Time) {
+	v.inner.TouchWithDate(loc, lastPut)
+}
+
+func (v *TestableCompressedVolume) Teardown() {
+	v.inner.Teardown()
+}
+
+func (v *TestableCompressedVolume) ReadWriteOperationLabelValues() (r, w string) {
+	return v.inner.ReadWriteOperationLabelValues()
+}
+
+func (s *CompressedVolumeSuite) TestGeneric(c *check.C) {
+	for _, codec := range []string{"zstd", "gzip"} {
+		c.Logf("=== %s", codec)
+		DoGenericVolumeTests(c, false, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+			return s.newTestableCompressedVolume(c, cluster, volume, metrics, codec)
+		})
+	}
+}
+
+func (s *CompressedVolumeSuite) TestGenericReadOnly(c *check.C) {
+	DoGenericVolumeTests(c, true, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+		return s.newTestableCompressedVolume(c, cluster, volume, metrics, "zstd")
+	})
+}
+
+func (s *CompressedVolumeSuite) TestUnsupportedCodec(c *check.C) {
+	_, err := newCompressedVolume(&MockVolume{}, "lzma", "", s.metrics)
+	c.Check(err, check.ErrorMatches, `unsupported compression "lzma"`)
+}
+
+func (s *CompressedVolumeSuite) TestCompress(c *check.C) {
+	v := s.newTestableCompressedVolume(c, s.cluster, arvados.Volume{}, s.metrics, "zstd")
+	block := bytes.Repeat([]byte("ACGTTGCA"), 1<<17)
+	hash := fmt.Sprintf("%x", md5.Sum(block))
+	c.Assert(v.Put(context.Background(), hash, block), check.IsNil)
+
+	fi, err := os.Stat(v.inner.blockPath(hash))
+	c.Assert(err, check.IsNil)
+	c.Check(fi.Size() < int64(len(block))/10, check.Equals, true)
+
+	buf := make([]byte, BlockSize)
+	n, err := v.Get(context.Background(), hash, buf)
+	c.Check(err, check.IsNil)
+	c.Check(bytes.Equal(buf[:n], block), check.Equals, true)
+	c.Check(v.Compare(context.Background(), hash, block), check.IsNil)
+	c.Check(v.Compare(context.Background(), hash, block[1:]), check.Equals, CollisionError)
+
+	stats := v.CompressionStats()
+	c.Check(stats.Codec, check.Equals, "zstd")
+	c.Check(stats.CompressedBlocks, check.Equals, uint64(1))
+	c.Check(stats.UncompressedBytes, check.Equals, uint64(len(block)))
+	c.Check(stats.StoredBytes, check.Equals, uint64(fi.Size()))
+	c.Check(stats.Ratio > 10, check.Equals, true)
+
+	bytesCV, ratio := s.metrics.getCompressionVecsFor(prometheus.Labels{"device_id": v.GetDeviceID()})
+	c.Check(getValueFrom(bytesCV, prometheus.Labels{"type": "uncompressed"}), check.Equals, float64(len(block)))
+	c.Check(getValueFrom(bytesCV, prometheus.Labels{"type": "stored"}), check.Equals, float64(fi.Size()))
+	c.Check(ratio, check.NotNil)
+}
+
+func (s *CompressedVolumeSuite) TestIncompressible(c *check.C) {
+	v := s.newTestableCompressedVolume(c, s.cluster, arvados.Volume{}, s.metrics, "zstd")
+	c.Assert(v.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+	fi, err := os.Stat(v.inner.blockPath(TestHash))
+	c.Assert(err, check.IsNil)
+	c.Check(fi.Size(), check.Equals, int64(len(TestBlock)))
+	c.Check(v.CompressionStats().UncompressedBlocks, check.Equals, uint64(1))
+}
+
+// Blocks that happen to start with the magic string must survive a
+// round trip, whether they were written before compression was
+// enabled or after.
+func (s *CompressedVolumeSuite) TestMagicPrefix(c *check.C) {
+	v := s.newTestableCompressedVolume(c, s.cluster, arvados.Volume{}, s.metrics, "zstd")
+	for _, block := range [][]byte{
+		append(append([]byte(nil), compressedMagic...), "foo"...),
+		append(append([]byte(nil), compressedMagic...), "\x02\x00\x00\x00\x03bar"...),
+	} {
+		hash := fmt.Sprintf("%x", md5.Sum(block))
+		for _, put := range []func(){
+			func() { v.PutRaw(hash, block) },
+			func() { c.Check(v.Put(context.Background(), hash, block), check.IsNil) },
+		} {
+			put()
+			buf := make([]byte, BlockSize)
+			n, err := v.Get(context.Background(), hash, buf)
+			c.Check(err, check.IsNil)
+			c.Check(buf[:n], check.DeepEquals, block)
+		}
+	}
+}
+
+// Compressed and uncompressed blocks can coexist on a volume, and
+// IndexTo reports uncompressed sizes for both, even if the blocks
+// were written by a different process.
+func (s *CompressedVolumeSuite) TestMixedIndex(c *check.C) {
+	v := s.newTestableCompressedVolume(c, s.cluster, arvados.Volume{}, s.metrics, "gzip")
+	blocks := map[string][]byte{}
+	for i := 0; i < 20; i++ {
+		block := bytes.Repeat([]byte{byte(i)}, 1000*(i+1))
+		hash := fmt.Sprintf("%x", md5.Sum(block))
+		blocks[hash] = block
+		if i%2 == 0 {
+			v.PutRaw(hash, block)
+		} else {
+			c.Assert(v.Put(context.Background(), hash, block), check.IsNil)
+		}
+	}
+
+	for trial := 0; trial < 2; trial++ {
+		// Start with an empty size cache, then make sure the
+		// cached sizes are correct too.
+		if trial == 0 {
+			v2, err := newCompressedVolume(v.inner, "gzip", "", s.metrics)
+			c.Assert(err, check.IsNil)
+			v.compressedVolume = v2
+		}
+		buf := &bytes.Buffer{}
+		c.Assert(v.IndexTo("", buf), check.IsNil)
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		sort.Strings(lines)
+		c.Check(lines, check.HasLen, len(blocks))
+		for _, line := range lines {
+			var hash string
+			var size int
+			var mtime int64
+			_, err := fmt.Sscanf(strings.Replace(line, "+", " ", 1), "%s %d %d", &hash, &size, &mtime)
+			c.Assert(err, check.IsNil)
+			c.Check(size, check.Equals, len(blocks[hash]), check.Commentf("%s", line))
+			c.Check(mtime > 0, check.Equals, true)
+		}
+	}
+
+	for hash, block := range blocks {
+		buf := make([]byte, BlockSize)
+		n, err := v.Get(context.Background(), hash, buf)
+		c.Check(err, check.IsNil)
+		c.Check(buf[:n], check.DeepEquals, block)
+	}
+}
+
+// headerReaderVolume is a Volume that implements BlockHeaderReader
+// and counts header reads.
+type headerReaderVolume struct {
+	Volume
+	headerReads int64
+}
+
+func (v *headerReaderVolume) ReadBlockHeader(ctx context.Context, loc string, buf []byte) (int, error) {
+	atomic.AddInt64(&v.headerReads, 1)
+	data := make([]byte, BlockSize)
+	n, err := v.Volume.Get(ctx, loc, data)
+	return copy(buf, data[:n]), err
+}
+
+// IndexTo reads only block headers to find sizes it hasn't seen
+// before, and forgets the sizes of blocks that are no longer on the
+// volume.
+func (s *CompressedVolumeSuite) TestIndexSizes(c *check.C) {
+	inner := s.unix.newTestableUnixVolume(c, s.cluster, arvados.Volume{}, s.metrics, false)
+	hr := &headerReaderVolume{Volume: inner}
+	v, err := newCompressedVolume(hr, "gzip", "", s.metrics)
+	c.Assert(err, check.IsNil)
+	var hashes []string
+	for i := 0; i < 4; i++ {
+		block := bytes.Repeat([]byte{byte(i)}, 1000*(i+1))
+		hash := fmt.Sprintf("%x", md5.Sum(block))
+		hashes = append(hashes, hash)
+		c.Assert(v.Put(context.Background(), hash, block), check.IsNil)
+	}
+
+	// Start with an empty size cache, as if keepstore had
+	// restarted.
+	v, err = newCompressedVolume(hr, "gzip", "", s.metrics)
+	c.Assert(err, check.IsNil)
+	c.Assert(v.IndexTo("", ioutil.Discard), check.IsNil)
+	c.Check(hr.headerReads, check.Equals, int64(4))
+	c.Check(v.sizes, check.HasLen, 4)
+
+	c.Assert(v.IndexTo("", ioutil.Discard), check.IsNil)
+	c.Check(hr.headerReads, check.Equals, int64(4))
+
+	c.Assert(os.Remove(inner.blockPath(hashes[0])), check.IsNil)
+	// An index with a non-matching prefix doesn't tell us the
+	// block is gone.
+	c.Assert(v.IndexTo("x", ioutil.Discard), check.IsNil)
+	c.Check(v.sizes, check.HasLen, 4)
+	c.Assert(v.IndexTo("", ioutil.Discard), check.IsNil)
+	c.Check(v.sizes, check.HasLen, 3)
+	c.Check(hr.headerReads, check.Equals, int64(4))
+}
+
+// Sizes saved in the size cache file by IndexTo are used after
+// restarting, so block headers don't need to be read again.
+func (s *CompressedVolumeSuite) TestSizeCacheFile(c *check.C) {
+	tmpdir, err := ioutil.TempDir("", "keepstore-sizecache-")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(tmpdir)
+	sizeCache := tmpdir + "/sizes"
+
+	inner := s.unix.newTestableUnixVolume(c, s.cluster, arvados.Volume{}, s.metrics, false)
+	hr := &headerReaderVolume{Volume: inner}
+	v, err := newCompressedVolume(hr, "gzip", sizeCache, s.metrics)
+	c.Assert(err, check.IsNil)
+	var hashes []string
+	for i := 0; i < 4; i++ {
+		block := bytes.Repeat([]byte{byte(i)}, 1000*(i+1))
+		hash := fmt.Sprintf("%x", md5.Sum(block))
+		hashes = append(hashes, hash)
+		c.Assert(v.Put(context.Background(), hash, block), check.IsNil)
+	}
+	c.Assert(v.IndexTo("", ioutil.Discard), check.IsNil)
+	c.Check(hr.headerReads, check.Equals, int64(0))
+
+	// Restart, and add a block without going through the
+	// compressedVolume (e.g., written by another keepstore
+	// process).
+	c.Assert(os.Remove(inner.blockPath(hashes[0])), check.IsNil)
+	v, err = newCompressedVolume(hr, "gzip", sizeCache, s.metrics)
+	c.Assert(err, check.IsNil)
+	c.Check(v.sizes, check.HasLen, 4)
+	v2, err := newCompressedVolume(inner, "gzip", "", s.metrics)
+	c.Assert(err, check.IsNil)
+	block := bytes.Repeat([]byte{9}, 5000)
+	c.Assert(v2.Put(context.Background(), fmt.Sprintf("%x", md5.Sum(block)), block), check.IsNil)
+
+	var index bytes.Buffer
+	c.Assert(v.IndexTo("", &index), check.IsNil)
+	c.Check(hr.headerReads, check.Equals, int64(1))
+	c.Check(strings.Count(index.String(), "\n"), check.Equals, 4)
+	for i := 1; i < 4; i++ {
+		c.Check(index.String(), check.Matches, fmt.Sprintf(`(?ms).*^%s\+%d .*`, hashes[i], 1000*(i+1)))
+	}
+	c.Check(index.String(), check.Matches, fmt.Sprintf(`(?ms).*^%x\+5000 .*`, md5.Sum(block)))
+
+	// The removed block's entry was pruned, and the new block's
+	// size was saved.
+	v, err = newCompressedVolume(hr, "gzip", sizeCache, s.metrics)
+	c.Assert(err, check.IsNil)
+	c.Check(v.sizes, check.HasLen, 4)
+	c.Assert(v.IndexTo("", ioutil.Discard), check.IsNil)
+	c.Check(hr.headerReads, check.Equals, int64(1))
+
+	// A corrupt size cache file is an error.
+	c.Assert(ioutil.WriteFile(sizeCache, []byte("foo"), 0600), check.IsNil)
+	_, err = newCompressedVolume(hr, "gzip", sizeCache, s.metrics)
+	c.Check(err, check.ErrorMatches, `error loading size cache: .*`)
+}
+
+func (s *CompressedVolumeSuite) TestVolumeManager(c *check.C) {
+	s.cluster.Volumes = map[string]arvados.Volume{
+		"zzzzz-nyw5e-000000000000000": {Driver: "mock", Compression: "zstd"},
+		"zzzzz-nyw5e-111111111111111": {Driver: "mock"},
+	}
+	vm, err := makeRRVolumeManager(ctxlog.TestLogger(c), s.cluster, arvados.URL{}, s.metrics)
+	c.Assert(err, check.IsNil)
+	_, ok := vm.Lookup("zzzzz-nyw5e-000000000000000", false).Volume.(*compressedVolume)
+	c.Check(ok, check.Equals, true)
+	_, ok = vm.Lookup("zzzzz-nyw5e-111111111111111", false).Volume.(*compressedVolume)
+	c.Check(ok, check.Equals, false)
+
+	s.cluster.Volumes["zzzzz-nyw5e-000000000000000"] = arvados.Volume{Driver: "mock", Compression: "bogus"}
+	_, err = makeRRVolumeManager(ctxlog.TestLogger(c), s.cluster, arvados.URL{}, s.metrics)
+	c.Check(err, check.ErrorMatches, `.*unsupported compression "bogus"`)
+}
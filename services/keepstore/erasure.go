@@ -0,0 +1,457 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"git.arvados.org/arvados.git/sdk/go/erasure"
+	"git.arvados.org/arvados.git/sdk/go/keepclient"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// ErasureShardRequest is the part of a pull request that asks for a
+// single erasure-coded shard of the block to be stored, instead of
+// the whole block.
+type ErasureShardRequest struct {
+	DataShards   int `json:"data_shards"`
+	ParityShards int `json:"parity_shards"`
+	Index        int `json:"index"`
+}
+
+func (esr *ErasureShardRequest) scheme() erasure.Scheme {
+	return erasure.Scheme{DataShards: esr.DataShards, ParityShards: esr.ParityShards}
+}
+
+// erasureSchemes returns the distinct erasure coding schemes used by
+// the cluster's storage classes.
+func erasureSchemes(cluster *arvados.Cluster) []erasure.Scheme {
+	var classes []string
+	for class := range cluster.StorageClasses {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	var schemes []erasure.Scheme
+	seen := map[erasure.Scheme]bool{}
+	for _, class := range classes {
+		sc := cluster.StorageClasses[class]
+		scheme := erasure.Scheme{DataShards: sc.DataShards, ParityShards: sc.ParityShards}
+		if scheme.Validate() != nil || seen[scheme] {
+			continue
+		}
+		seen[scheme] = true
+		schemes = append(schemes, scheme)
+	}
+	return schemes
+}
+
+// writePulledShard computes the requested shard of a pulled block and
+// stores it on the given volume (or the next writable volume, if
+// volume is nil).
+func writePulledShard(volmgr *RRVolumeManager, volume Volume, data []byte, locator string, esr *ErasureShardRequest) error {
+	if len(locator) < 32 {
+		return fmt.Errorf("invalid locator %q", locator)
+	}
+	scheme := esr.scheme()
+	blkid := arvados.SizedDigest(fmt.Sprintf("%s+%d", locator[:32], len(data)))
+	shard, err := scheme.EncodeShard(blkid, data, esr.Index)
+	if err != nil {
+		return err
+	}
+	if volume == nil {
+		mnt := volmgr.NextWritable()
+		if mnt == nil {
+			return FullError
+		}
+		volume = mnt.Volume
+	}
+	hash := string(scheme.ShardLocator(blkid, esr.Index)[:32])
+	err = volume.Put(context.Background(), hash, shard)
+	if err != nil {
+		return err
+	}
+	volmgr.shards.add(hash)
+	return nil
+}
+
+// localShardIndex is an in-memory set of the hashes stored on local
+// volumes, so a GET request for a missing block can check for local
+// shards without calling Mtime on every volume for every shard.
+//
+// A volume index doesn't distinguish shards from other blocks, so
+// the set is populated with every hash stored at startup. After
+// that, shards are added when they are written by pull requests, and
+// hashes are removed when they are trashed.
+type localShardIndex struct {
+	mtx    sync.RWMutex
+	hashes map[[md5.Size]byte]bool
+	// true when hashes has been populated from the index of
+	// every readable volume.
+	loaded bool
+}
+
+// newLocalShardIndex returns a new localShardIndex, and starts
+// loading it from the given volumes' indexes in the background.
+func newLocalShardIndex(logger logrus.FieldLogger, mounts []*VolumeMount) *localShardIndex {
+	si := &localShardIndex{hashes: map[[md5.Size]byte]bool{}}
+	go si.load(logger, mounts)
+	return si
+}
+
+// load adds the hashes listed in the given volumes' indexes. If any
+// volume's index can't be read, the index is not marked as loaded,
+// and callers keep checking the volumes directly.
+func (si *localShardIndex) load(logger logrus.FieldLogger, mounts []*VolumeMount) {
+	for _, mnt := range mounts {
+		pr, pw := io.Pipe()
+		go func(mnt *VolumeMount) {
+			pw.CloseWithError(mnt.IndexTo("", pw))
+		}(mnt)
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			si.add(scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			pr.CloseWithError(err)
+			logger.WithError(err).Warnf("error reading index of %s; local shard lookups will use Mtime", mnt)
+			return
+		}
+	}
+	si.mtx.Lock()
+	defer si.mtx.Unlock()
+	si.loaded = true
+}
+
+// add adds the given hash (or the hash at the start of the given
+// locator or index line) to the set.
+func (si *localShardIndex) add(hash string) {
+	if si == nil {
+		return
+	}
+	if key, ok := sizeKey(hash); ok {
+		si.mtx.Lock()
+		defer si.mtx.Unlock()
+		si.hashes[key] = true
+	}
+}
+
+// remove removes the given hash from the set.
+func (si *localShardIndex) remove(hash string) {
+	if si == nil {
+		return
+	}
+	if key, ok := sizeKey(hash); ok {
+		si.mtx.Lock()
+		defer si.mtx.Unlock()
+		delete(si.hashes, key)
+	}
+}
+
+// has returns true if the given hash is in the set. If the set has
+// not been loaded yet, ok is false.
+func (si *localShardIndex) has(hash string) (found, ok bool) {
+	key, valid := sizeKey(hash)
+	si.mtx.RLock()
+	defer si.mtx.RUnlock()
+	return valid && si.hashes[key], si.loaded
+}
+
+// trashed updates the local shard index after the given hash has
+// been trashed on one of the volumes: it is removed from the index
+// unless it is still stored on another readable volume.
+func (vm *RRVolumeManager) trashed(hash string) {
+	if vm.shards == nil {
+		return
+	}
+	for _, mnt := range vm.readables {
+		if _, err := mnt.Mtime(hash); err == nil {
+			return
+		}
+	}
+	vm.shards.remove(hash)
+}
+
+// handleGetShard serves "GET /shards/{hash}" requests, which return
+// an erasure-coded shard stored on a local volume. Unlike a regular
+// GET request, the content is checked against the shard's own header
+// instead of the hash. Privileged client only.
+func (rtr *router) handleGetShard(resp http.ResponseWriter, req *http.Request) {
+	if !rtr.isSystemAuth(GetAPIToken(req)) {
+		http.Error(resp, UnauthorizedError.Error(), UnauthorizedError.HTTPCode)
+		return
+	}
+	ctx, cancel := contextForResponse(context.TODO(), resp)
+	defer cancel()
+	buf, err := getBufferWithContext(ctx, bufs, BlockSize)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer bufs.Put(buf)
+	size, err := getShard(ctx, rtr.volmgr, mux.Vars(req)["hash"], buf)
+	if err != nil {
+		http.Error(resp, err.Error(), err.(*KeepError).HTTPCode)
+		return
+	}
+	resp.Header().Set("Content-Length", strconv.Itoa(size))
+	resp.Header().Set("Content-Type", "application/octet-stream")
+	resp.Write(buf[:size])
+}
+
+// getShard reads a stored shard from the first local volume that has
+// an intact copy.
+func getShard(ctx context.Context, volmgr *RRVolumeManager, hash string, buf []byte) (int, error) {
+	log := ctxlog.FromContext(ctx)
+	errorToCaller := NotFoundError
+	for _, vol := range volmgr.AllReadable() {
+		size, err := vol.Get(ctx, hash, buf)
+		if ctx.Err() != nil {
+			return 0, ErrClientDisconnect
+		}
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.WithError(err).Errorf("Get(%s) failed on %s", hash, vol)
+			}
+			if err == VolumeBusyError {
+				errorToCaller = err.(*KeepError)
+			}
+			continue
+		}
+		if _, _, err := erasure.ParseShard(buf[:size]); err != nil {
+			log.Errorf("invalid shard %s on %s", hash, vol)
+			errorToCaller = DiskHashError
+			continue
+		}
+		return size, nil
+	}
+	return 0, errorToCaller
+}
+
+// erasureReconstructor reconstructs blocks from erasure-coded shards
+// stored on local volumes and other keepstore servers.
+type erasureReconstructor struct {
+	cluster *arvados.Cluster
+	volmgr  *RRVolumeManager
+	schemes []erasure.Scheme
+
+	// Client used to list keep services and retrieve shards;
+	// created from the cluster config if nil.
+	client *arvados.Client
+
+	mtx             sync.Mutex
+	services        map[string]string // uuid => base URL
+	servicesUpdated time.Time
+}
+
+// newErasureReconstructor returns an erasureReconstructor, or nil if
+// the cluster does not have any erasure-coded storage classes.
+func newErasureReconstructor(cluster *arvados.Cluster, volmgr *RRVolumeManager) *erasureReconstructor {
+	schemes := erasureSchemes(cluster)
+	if len(schemes) == 0 {
+		return nil
+	}
+	return &erasureReconstructor{
+		cluster: cluster,
+		volmgr:  volmgr,
+		schemes: schemes,
+	}
+}
+
+// Get reconstructs the block with the given locator into buf. It
+// returns NotFoundError if not enough shards are available with any
+// of the configured erasure coding schemes.
+//
+// Shards are spread across servers, so any block that can be
+// reconstructed has a shard on some server. To avoid asking other
+// servers for shards every time a block is not found, Get only tries
+// a scheme if at least one of the block's shards is stored on a local
+// volume. Clients try each server in turn, and keep-balance sends
+// pull requests for shard-only blocks to a server that has a shard.
+func (er *erasureReconstructor) Get(ctx context.Context, locator string, buf []byte) (int, error) {
+	parts := strings.SplitN(locator, "+", 3)
+	if len(parts) < 2 {
+		// Without a size hint we can't compute the shard
+		// locators.
+		return 0, NotFoundError
+	}
+	if _, err := strconv.ParseUint(parts[1], 10, 32); err != nil {
+		return 0, NotFoundError
+	}
+	blkid := arvados.SizedDigest(parts[0] + "+" + parts[1])
+	if blkid.Size() > int64(len(buf)) {
+		return 0, TooLongError
+	}
+	for _, scheme := range er.schemes {
+		if !er.haveLocalShard(ctx, scheme, blkid) {
+			continue
+		}
+		block, err := scheme.Decode(blkid, er.fetchShards(ctx, scheme, blkid))
+		if err == erasure.ErrTooFewShards {
+			continue
+		} else if err != nil {
+			ctxlog.FromContext(ctx).WithError(err).Warnf("cannot reconstruct %s from %s shards", blkid, scheme)
+			continue
+		}
+		return copy(buf, block), nil
+	}
+	if ctx.Err() != nil {
+		return 0, ErrClientDisconnect
+	}
+	return 0, NotFoundError
+}
+
+// haveLocalShard returns true if any of the block's shards are
+// stored on a local volume. It uses the volume manager's local shard
+// index, or (until the index is loaded) checks each volume.
+func (er *erasureReconstructor) haveLocalShard(ctx context.Context, scheme erasure.Scheme, blkid arvados.SizedDigest) bool {
+	for i := 0; i < scheme.Shards(); i++ {
+		hash := string(scheme.ShardLocator(blkid, i)[:32])
+		if er.volmgr.shards != nil {
+			if found, ok := er.volmgr.shards.has(hash); found {
+				return true
+			} else if ok {
+				continue
+			}
+		}
+		for _, vol := range er.volmgr.AllReadable() {
+			if ctx.Err() != nil {
+				return false
+			}
+			if _, err := vol.Mtime(hash); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fetchShards returns the block's shards, trying local volumes first
+// and then other keepstore servers. Missing shards are nil. It stops
+// looking once it has enough shards to reconstruct the block.
+func (er *erasureReconstructor) fetchShards(ctx context.Context, scheme erasure.Scheme, blkid arvados.SizedDigest) [][]byte {
+	shards := make([][]byte, scheme.Shards())
+	found := 0
+	var missing []int
+	for i := range shards {
+		loc := scheme.ShardLocator(blkid, i)
+		buf := make([]byte, loc.Size())
+		if n, err := getShard(ctx, er.volmgr, string(loc[:32]), buf); err == nil && int64(n) == loc.Size() {
+			shards[i] = buf
+			found++
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	if found >= scheme.DataShards || len(missing) == 0 {
+		return shards
+	}
+	services, err := er.keepServices()
+	if err != nil {
+		ctxlog.FromContext(ctx).WithError(err).Warn("error listing keep services")
+		return shards
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	for _, i := range missing {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			loc := scheme.ShardLocator(blkid, i)
+			shard := er.fetchRemoteShard(ctx, services, loc)
+			if shard == nil {
+				return
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			shards[i] = shard
+			if found++; found >= scheme.DataShards {
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return shards
+}
+
+// fetchRemoteShard tries to retrieve a shard from each of the given
+// keepstore servers, in rendezvous order.
+func (er *erasureReconstructor) fetchRemoteShard(ctx context.Context, services map[string]string, loc arvados.SizedDigest) []byte {
+	hash := string(loc[:32])
+	for _, root := range keepclient.NewRootSorter(services, hash).GetSortedRoots() {
+		req, err := http.NewRequest("GET", root+"/shards/"+hash, nil)
+		if err != nil {
+			return nil
+		}
+		resp, err := er.client.Do(req.WithContext(ctx))
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			continue
+		}
+		shard, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK || int64(len(shard)) != loc.Size() {
+			continue
+		}
+		if _, _, err := erasure.ParseShard(shard); err != nil {
+			continue
+		}
+		return shard
+	}
+	return nil
+}
+
+// keepServices returns the cluster's keepstore servers, refreshing
+// the cached list if it is more than a minute old.
+func (er *erasureReconstructor) keepServices() (map[string]string, error) {
+	er.mtx.Lock()
+	defer er.mtx.Unlock()
+	if er.services != nil && time.Since(er.servicesUpdated) < time.Minute {
+		return er.services, nil
+	}
+	if er.client == nil {
+		client, err := arvados.NewClientFromConfig(er.cluster)
+		if err != nil {
+			return nil, err
+		}
+		client.AuthToken = er.cluster.SystemRootToken
+		er.client = client
+	}
+	services := map[string]string{}
+	err := er.client.EachKeepService(func(srv arvados.KeepService) error {
+		if srv.ServiceType == "disk" {
+			scheme := "http"
+			if srv.ServiceSSLFlag {
+				scheme = "https"
+			}
+			services[srv.UUID] = fmt.Sprintf("%s://%s:%d", scheme, srv.ServiceHost, srv.ServicePort)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	er.services = services
+	er.servicesUpdated = time.Now()
+	return services, nil
+}
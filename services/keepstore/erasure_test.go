@@ -0,0 +1,244 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/arvadostest"
+	"git.arvados.org/arvados.git/sdk/go/erasure"
+	"git.arvados.org/arvados.git/sdk/go/keepclient"
+	"github.com/prometheus/client_golang/prometheus"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&ErasureSuite{})
+
+type ErasureSuite struct {
+	cluster *arvados.Cluster
+	scheme  erasure.Scheme
+	block   []byte
+	blkid   arvados.SizedDigest
+	shards  [][]byte
+}
+
+func (s *ErasureSuite) SetUpTest(c *check.C) {
+	s.cluster = testCluster(c)
+	s.cluster.Collections.BlobSigning = false
+	s.cluster.StorageClasses = map[string]arvados.StorageClassConfig{
+		"archive": {DataShards: 4, ParityShards: 2},
+	}
+	s.scheme = erasure.Scheme{DataShards: 4, ParityShards: 2}
+	s.block = make([]byte, 100000)
+	rand.Read(s.block)
+	s.blkid = arvados.SizedDigest(fmt.Sprintf("%x+%d", md5.Sum(s.block), len(s.block)))
+	var err error
+	s.shards, err = s.scheme.Encode(s.blkid, s.block)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *ErasureSuite) newHandler(c *check.C) *handler {
+	cluster := *s.cluster
+	cluster.Volumes = map[string]arvados.Volume{
+		"zzzzz-nyw5e-000000000000000": {Replication: 1, Driver: "mock"},
+		"zzzzz-nyw5e-111111111111111": {Replication: 1, Driver: "mock"},
+	}
+	h := &handler{}
+	c.Assert(h.setup(context.Background(), &cluster, "", prometheus.NewRegistry(), testServiceURL), check.IsNil)
+	if h.volmgr.shards != nil {
+		s.waitShardIndex(c, h.volmgr.shards)
+	}
+	return h
+}
+
+// waitShardIndex waits for the given local shard index to finish
+// loading.
+func (s *ErasureSuite) waitShardIndex(c *check.C, si *localShardIndex) {
+	expectEqualWithin(c, time.Second, true, func() interface{} {
+		_, ok := si.has(TestHash)
+		return ok
+	})
+}
+
+// putShards stores the given shards on h's first volume, and adds
+// them to the local shard index as a pull request would.
+func (s *ErasureSuite) putShards(c *check.C, h *handler, idxs ...int) {
+	vol := h.volmgr.Mounts()[0].Volume
+	for _, i := range idxs {
+		hash := string(s.scheme.ShardLocator(s.blkid, i)[:32])
+		c.Assert(vol.Put(context.Background(), hash, s.shards[i]), check.IsNil)
+		h.volmgr.shards.add(hash)
+	}
+}
+
+func (s *ErasureSuite) TestPullShard(c *check.C) {
+	defer func(orig func(string, *keepclient.KeepClient) (io.ReadCloser, int64, string, error)) {
+		GetContent = orig
+	}(GetContent)
+	GetContent = func(signedLocator string, keepClient *keepclient.KeepClient) (io.ReadCloser, int64, string, error) {
+		return ioutil.NopCloser(bytes.NewReader(s.block)), int64(len(s.block)), "", nil
+	}
+
+	h := s.newHandler(c)
+	mnt := h.volmgr.Mounts()[1]
+	resp := IssueRequest(h, &RequestTester{
+		uri:      "/pull",
+		apiToken: s.cluster.SystemRootToken,
+		method:   "PUT",
+		requestBody: []byte(`[{
+			"locator":"` + string(s.blkid[:32]) + `",
+			"servers":["server_1"],
+			"mount_uuid":"` + mnt.UUID + `",
+			"erasure_shard":{"data_shards":4,"parity_shards":2,"index":5}}]`),
+	})
+	c.Assert(resp.Code, check.Equals, http.StatusOK)
+	pullq := h.Handler.(*router).pullq
+	expectEqualWithin(c, time.Second, 0, func() interface{} {
+		st := pullq.Status()
+		return st.InProgress + st.Queued
+	})
+	loc := string(s.scheme.ShardLocator(s.blkid, 5)[:32])
+	c.Check(mnt.Volume.(*MockVolume).Store[loc], check.DeepEquals, s.shards[5])
+	c.Check(h.volmgr.Mounts()[0].Volume.(*MockVolume).Store, check.HasLen, 0)
+}
+
+func (s *ErasureSuite) TestGetShard(c *check.C) {
+	h := s.newHandler(c)
+	s.putShards(c, h, 2)
+	vol := h.volmgr.Mounts()[0].Volume
+	c.Assert(vol.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+
+	loc := string(s.scheme.ShardLocator(s.blkid, 2)[:32])
+	for _, trial := range []struct {
+		uri   string
+		token string
+		code  int
+	}{
+		{"/shards/" + loc, s.cluster.SystemRootToken, http.StatusOK},
+		{"/shards/" + loc, "", http.StatusUnauthorized},
+		{"/shards/" + loc, arvadostest.ActiveToken, http.StatusUnauthorized},
+		{"/shards/" + TestHash, s.cluster.SystemRootToken, DiskHashError.HTTPCode},
+		{"/shards/" + TestHash2, s.cluster.SystemRootToken, http.StatusNotFound},
+	} {
+		resp := IssueRequest(h, &RequestTester{uri: trial.uri, apiToken: trial.token, method: "GET"})
+		c.Check(resp.Code, check.Equals, trial.code, check.Commentf("%s", trial.uri))
+		if trial.code == http.StatusOK {
+			c.Check(resp.Body.Bytes(), check.DeepEquals, s.shards[2])
+		}
+	}
+
+	// A regular GET request for a shard fails its checksum.
+	resp := IssueRequest(h, &RequestTester{uri: "/" + loc, method: "GET"})
+	c.Check(resp.Code, check.Equals, DiskHashError.HTTPCode)
+}
+
+func (s *ErasureSuite) TestReconstructLocal(c *check.C) {
+	h := s.newHandler(c)
+	s.putShards(c, h, 0, 2, 4)
+	resp := IssueRequest(h, &RequestTester{uri: "/" + string(s.blkid), method: "GET"})
+	c.Check(resp.Code, check.Equals, http.StatusNotFound)
+
+	s.putShards(c, h, 5)
+	resp = IssueRequest(h, &RequestTester{uri: "/" + string(s.blkid), method: "GET"})
+	c.Check(resp.Code, check.Equals, http.StatusOK)
+	c.Check(resp.Body.Bytes(), check.DeepEquals, s.block)
+
+	// Reconstruction needs the size hint.
+	resp = IssueRequest(h, &RequestTester{uri: "/" + string(s.blkid[:32]), method: "GET"})
+	c.Check(resp.Code, check.Equals, http.StatusNotFound)
+}
+
+// Once the local shard index is loaded, GET requests for missing
+// blocks don't check every volume for every shard.
+func (s *ErasureSuite) TestLocalShardIndex(c *check.C) {
+	s.cluster.Collections.BlobTrash = true
+	h := s.newHandler(c)
+	vol := h.volmgr.Mounts()[0].Volume.(*MockVolume)
+	for _, i := range []int{0, 2, 4, 5} {
+		hash := string(s.scheme.ShardLocator(s.blkid, i)[:32])
+		c.Assert(vol.Put(context.Background(), hash, s.shards[i]), check.IsNil)
+		vol.Timestamps[hash] = time.Now().Add(-2 * s.cluster.Collections.BlobSigningTTL.Duration())
+	}
+	// Simulate a restart, so the shards are found in the
+	// volume index.
+	h.volmgr.shards = newLocalShardIndex(h.Logger, h.volmgr.AllReadable())
+	s.waitShardIndex(c, h.volmgr.shards)
+
+	resp := IssueRequest(h, &RequestTester{uri: "/" + string(s.blkid), method: "GET"})
+	c.Check(resp.Code, check.Equals, http.StatusOK)
+	c.Check(resp.Body.Bytes(), check.DeepEquals, s.block)
+	resp = IssueRequest(h, &RequestTester{uri: fmt.Sprintf("/%s+%d", TestHash, len(TestBlock)), method: "GET"})
+	c.Check(resp.Code, check.Equals, http.StatusNotFound)
+	for _, mnt := range h.volmgr.Mounts() {
+		c.Check(mnt.Volume.(*MockVolume).CallCount("Mtime"), check.Equals, 0)
+	}
+
+	// Trashed shards are removed from the index.
+	for _, i := range []int{0, 2, 4, 5} {
+		hash := string(s.scheme.ShardLocator(s.blkid, i)[:32])
+		resp = IssueRequest(h, &RequestTester{uri: "/" + hash, apiToken: s.cluster.SystemRootToken, method: "DELETE"})
+		c.Check(resp.Code, check.Equals, http.StatusOK)
+		found, _ := h.volmgr.shards.has(hash)
+		c.Check(found, check.Equals, false)
+	}
+	resp = IssueRequest(h, &RequestTester{uri: "/" + string(s.blkid), method: "GET"})
+	c.Check(resp.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *ErasureSuite) TestReconstructRemote(c *check.C) {
+	local := s.newHandler(c)
+	remote := s.newHandler(c)
+	s.putShards(c, local, 1)
+	s.putShards(c, remote, 0, 3, 4)
+	srv := httptest.NewServer(remote)
+	defer srv.Close()
+
+	er := local.Handler.(*router).erasure
+	c.Assert(er, check.NotNil)
+	er.client = &arvados.Client{AuthToken: s.cluster.SystemRootToken}
+	er.services = map[string]string{"zzzzz-bi6l4-000000000000000": srv.URL}
+	er.servicesUpdated = time.Now()
+
+	resp := IssueRequest(local, &RequestTester{uri: "/" + string(s.blkid), method: "GET"})
+	c.Check(resp.Code, check.Equals, http.StatusOK)
+	c.Check(resp.Body.Bytes(), check.DeepEquals, s.block)
+
+	// Without any local shards, a missing block is not
+	// reconstructed, and other servers are not asked for shards.
+	var shardRequests int64
+	srv2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&shardRequests, 1)
+		remote.ServeHTTP(w, req)
+	}))
+	defer srv2.Close()
+	local = s.newHandler(c)
+	er = local.Handler.(*router).erasure
+	er.client = &arvados.Client{AuthToken: s.cluster.SystemRootToken}
+	er.services = map[string]string{"zzzzz-bi6l4-000000000000000": srv2.URL}
+	er.servicesUpdated = time.Now()
+	resp = IssueRequest(local, &RequestTester{uri: "/" + string(s.blkid), method: "GET"})
+	c.Check(resp.Code, check.Equals, http.StatusNotFound)
+	c.Check(atomic.LoadInt64(&shardRequests), check.Equals, int64(0))
+
+	// Without erasure-coded storage classes, there is no
+	// reconstruction.
+	s.cluster.StorageClasses = nil
+	local = s.newHandler(c)
+	c.Check(local.Handler.(*router).erasure, check.IsNil)
+	s.putShards(c, local, 0, 1, 2, 3)
+	resp = IssueRequest(local, &RequestTester{uri: "/" + string(s.blkid), method: "GET"})
+	c.Check(resp.Code, check.Equals, http.StatusNotFound)
+}
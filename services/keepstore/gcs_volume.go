@@ -0,0 +1,527 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+	htransport "google.golang.org/api/transport/http"
+)
+
+func init() {
+	driver["GCS"] = newGCSVolume
+}
+
+func newGCSVolume(cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) (Volume, error) {
+	v := &GCSVolume{cluster: cluster, volume: volume, metrics: metrics}
+	err := json.Unmarshal(volume.DriverParameters, &v)
+	if err != nil {
+		return nil, err
+	}
+	v.logger = logger.WithField("Volume", v.String())
+	return v, v.check()
+}
+
+// check validates the configuration and sets up the API client.
+// Extra client options (e.g., a test server endpoint) are passed
+// through to the storage API client.
+func (v *GCSVolume) check(opts ...option.ClientOption) error {
+	if v.Bucket == "" {
+		return errors.New("DriverParameters: Bucket must be provided")
+	}
+	if v.IndexPageSize == 0 {
+		v.IndexPageSize = 1000
+	}
+	if v.RequestTimeout == 0 {
+		v.RequestTimeout = gcsDefaultRequestTimeout
+	}
+	if v.CredentialsJSON != "" {
+		opts = append([]option.ClientOption{option.WithCredentialsJSON([]byte(v.CredentialsJSON))}, opts...)
+	}
+	if v.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(v.Endpoint))
+	}
+	opts = append(opts, option.WithScopes(storage.DevstorageReadWriteScope))
+
+	// Zero timeouts mean "wait forever", so we build our own
+	// (authenticated) http client instead of letting NewService
+	// use the default one.
+	client, _, err := htransport.NewClient(context.Background(), opts...)
+	if err != nil {
+		return fmt.Errorf("error setting up GCS client: %s", err)
+	}
+	client.Timeout = v.RequestTimeout.Duration()
+	svc, err := storage.NewService(context.Background(), append(opts, option.WithHTTPClient(client))...)
+	if err != nil {
+		return fmt.Errorf("error setting up GCS client: %s", err)
+	}
+	v.bucket = &gcsBucket{svc: svc, name: v.Bucket}
+
+	// Set up prometheus metrics
+	lbls := prometheus.Labels{"device_id": v.GetDeviceID()}
+	v.bucket.stats.opsCounters, v.bucket.stats.errCounters, v.bucket.stats.ioBytes = v.metrics.getCounterVecsFor(lbls)
+	return nil
+}
+
+const (
+	gcsDefaultRequestTimeout = arvados.Duration(10 * time.Minute)
+
+	// Object metadata keys. The mtime is stored explicitly
+	// (rather than using the object's "updated" timestamp) so
+	// that marking an object as trash doesn't count as a write.
+	gcsMtimeKey     = "mtime"
+	gcsExpiresAtKey = "expires_at"
+)
+
+// GCSVolume implements Volume using the Google Cloud Storage JSON
+// API.
+type GCSVolume struct {
+	// Contents of a service account key file. If empty, use the
+	// default credentials of the host.
+	CredentialsJSON string
+	Bucket          string
+	Endpoint        string // "" means default, "https://storage.googleapis.com/storage/v1/"
+	IndexPageSize   int
+	RequestTimeout  arvados.Duration
+
+	cluster *arvados.Cluster
+	volume  arvados.Volume
+	logger  logrus.FieldLogger
+	metrics *volumeMetricsVecs
+	bucket  *gcsBucket
+}
+
+// GetDeviceID returns a globally unique ID for the storage bucket.
+func (v *GCSVolume) GetDeviceID() string {
+	return "gs://" + v.Endpoint + "/" + v.Bucket
+}
+
+// getMetadata returns the object resource for the given block,
+// returning os.ErrNotExist if it is missing or trashed.
+func (v *GCSVolume) getMetadata(ctx context.Context, loc string) (*storage.Object, error) {
+	obj, err := v.bucket.GetMetadata(ctx, loc)
+	if err != nil {
+		return nil, v.translateError(err)
+	}
+	if obj.Metadata[gcsExpiresAtKey] != "" {
+		return nil, os.ErrNotExist
+	}
+	return obj, nil
+}
+
+// Get reads a Keep block that has been stored as an object in the
+// bucket.
+func (v *GCSVolume) Get(ctx context.Context, loc string, buf []byte) (int, error) {
+	obj, err := v.getMetadata(ctx, loc)
+	if err != nil {
+		return 0, err
+	}
+	if obj.Size > uint64(len(buf)) {
+		return 0, fmt.Errorf("block %s invalid size %d (max %d)", loc, obj.Size, len(buf))
+	}
+	if obj.Size == 0 {
+		return 0, nil
+	}
+	rdr, err := v.bucket.Download(ctx, loc, obj.Generation)
+	if err != nil {
+		return 0, v.translateError(err)
+	}
+	defer rdr.Close()
+	n, err := io.ReadFull(rdr, buf[:obj.Size])
+	if err != nil {
+		return 0, v.translateError(err)
+	}
+	return n, nil
+}
+
+// ReadBlock implements BlockReader.
+func (v *GCSVolume) ReadBlock(ctx context.Context, loc string, w io.Writer) error {
+	obj, err := v.getMetadata(ctx, loc)
+	if err != nil {
+		return err
+	}
+	rdr, err := v.bucket.Download(ctx, loc, obj.Generation)
+	if err != nil {
+		return v.translateError(err)
+	}
+	defer rdr.Close()
+	_, err = io.Copy(w, rdr)
+	return err
+}
+
+// Compare the given data with the stored data.
+func (v *GCSVolume) Compare(ctx context.Context, loc string, expect []byte) error {
+	obj, err := v.getMetadata(ctx, loc)
+	if err != nil {
+		return err
+	}
+	rdr, err := v.bucket.Download(ctx, loc, obj.Generation)
+	if err != nil {
+		return v.translateError(err)
+	}
+	defer rdr.Close()
+	return v.translateError(compareReaderWithBuf(ctx, rdr, expect, loc[:32]))
+}
+
+// Put writes a block.
+func (v *GCSVolume) Put(ctx context.Context, loc string, block []byte) error {
+	if v.volume.ReadOnly {
+		return MethodDisabledError
+	}
+	md5sum := md5.Sum(block)
+	obj := &storage.Object{
+		Name:        loc,
+		ContentType: "application/octet-stream",
+		Md5Hash:     base64.StdEncoding.EncodeToString(md5sum[:]),
+		Metadata:    map[string]string{gcsMtimeKey: gcsFormatMtime(time.Now())},
+	}
+	return v.translateError(v.bucket.Insert(ctx, obj, block))
+}
+
+// Touch sets the timestamp for the given locator to the current time.
+func (v *GCSVolume) Touch(loc string) error {
+	if v.volume.ReadOnly {
+		return MethodDisabledError
+	}
+	ctx := context.Background()
+	_, err := v.getMetadata(ctx, loc)
+	if err != nil {
+		return err
+	}
+	err = v.bucket.Patch(ctx, loc, &storage.Object{
+		Metadata: map[string]string{gcsMtimeKey: gcsFormatMtime(time.Now())},
+	}, 0, 0)
+	return v.translateError(err)
+}
+
+// Mtime returns the stored timestamp for the given locator.
+func (v *GCSVolume) Mtime(loc string) (time.Time, error) {
+	obj, err := v.getMetadata(context.Background(), loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return v.mtime(obj)
+}
+
+// mtime returns the timestamp stored in the object's metadata, or
+// (for objects written by other programs) the object's last update
+// time.
+func (v *GCSVolume) mtime(obj *storage.Object) (time.Time, error) {
+	if s := obj.Metadata[gcsMtimeKey]; s != "" {
+		ns, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("object %s has invalid %s metadata %q: %s", obj.Name, gcsMtimeKey, s, err)
+		}
+		return time.Unix(0, ns), nil
+	}
+	return time.Parse(time.RFC3339Nano, obj.Updated)
+}
+
+func gcsFormatMtime(t time.Time) string {
+	return fmt.Sprintf("%d", t.UnixNano())
+}
+
+// IndexTo writes a complete list of locators with the given prefix
+// for which Get() can retrieve data.
+func (v *GCSVolume) IndexTo(prefix string, writer io.Writer) error {
+	pageToken := ""
+	for {
+		resp, err := v.bucket.List(context.Background(), prefix, pageToken, v.IndexPageSize)
+		if err != nil {
+			return v.translateError(err)
+		}
+		for _, obj := range resp.Items {
+			if !v.isKeepBlock(obj.Name) || obj.Metadata[gcsExpiresAtKey] != "" {
+				continue
+			}
+			t, err := v.mtime(obj)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(writer, "%s+%d %d\n", obj.Name, obj.Size, t.UnixNano())
+		}
+		if resp.NextPageToken == "" {
+			return nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+// Trash a Keep block.
+func (v *GCSVolume) Trash(loc string) error {
+	if v.volume.ReadOnly {
+		return MethodDisabledError
+	}
+	ctx := context.Background()
+	obj, err := v.getMetadata(ctx, loc)
+	if err != nil {
+		return err
+	}
+	if t, err := v.mtime(obj); err != nil {
+		return err
+	} else if time.Since(t) < v.cluster.Collections.BlobSigningTTL.Duration() {
+		return nil
+	}
+
+	// The generation and metageneration preconditions ensure we
+	// don't delete data if Put() or Touch() happens between our
+	// calls to getMetadata() and Delete()/Patch().
+	if v.cluster.Collections.BlobTrashLifetime == 0 {
+		return v.translateError(v.bucket.Delete(ctx, loc, obj.Generation, obj.Metageneration))
+	}
+	err = v.bucket.Patch(ctx, loc, &storage.Object{
+		Metadata: map[string]string{
+			gcsExpiresAtKey: fmt.Sprintf("%d", time.Now().Add(v.cluster.Collections.BlobTrashLifetime.Duration()).Unix()),
+		},
+	}, obj.Generation, obj.Metageneration)
+	return v.translateError(err)
+}
+
+// Untrash a Keep block: delete the expires_at metadata attribute,
+// and reset the mtime so the block doesn't get trashed again right
+// away.
+func (v *GCSVolume) Untrash(loc string) error {
+	ctx := context.Background()
+	obj, err := v.bucket.GetMetadata(ctx, loc)
+	if err != nil {
+		return v.translateError(err)
+	}
+	if obj.Metadata[gcsExpiresAtKey] == "" {
+		return os.ErrNotExist
+	}
+	err = v.bucket.Patch(ctx, loc, &storage.Object{
+		Metadata:   map[string]string{gcsMtimeKey: gcsFormatMtime(time.Now())},
+		NullFields: []string{"Metadata." + gcsExpiresAtKey},
+	}, obj.Generation, obj.Metageneration)
+	return v.translateError(err)
+}
+
+// Status returns a VolumeStatus struct with placeholder data.
+func (v *GCSVolume) Status() *VolumeStatus {
+	return &VolumeStatus{
+		DeviceNum: 1,
+		BytesFree: BlockSize * 1000,
+		BytesUsed: 1,
+	}
+}
+
+// InternalStats returns bucket I/O and API call counters.
+func (v *GCSVolume) InternalStats() interface{} {
+	return &v.bucket.stats
+}
+
+// String implements fmt.Stringer.
+func (v *GCSVolume) String() string {
+	return fmt.Sprintf("gcs-bucket:%+q", v.Bucket)
+}
+
+var gcsKeepBlockRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func (v *GCSVolume) isKeepBlock(s string) bool {
+	return gcsKeepBlockRegexp.MatchString(s)
+}
+
+// If possible, translate a GCS API error to a recognizable error
+// like os.ErrNotExist.
+func (v *GCSVolume) translateError(err error) error {
+	if err, ok := err.(*googleapi.Error); ok {
+		switch err.Code {
+		case http.StatusNotFound:
+			return os.ErrNotExist
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return VolumeBusyError
+		}
+	}
+	return err
+}
+
+// EmptyTrash looks for trashed blocks that exceeded
+// BlobTrashLifetime and deletes them from the volume.
+func (v *GCSVolume) EmptyTrash() {
+	if v.cluster.Collections.BlobDeleteConcurrency < 1 {
+		return
+	}
+
+	var bytesDeleted, bytesInTrash int64
+	var blocksDeleted, blocksInTrash int64
+
+	doObject := func(obj *storage.Object) {
+		if obj.Metadata[gcsExpiresAtKey] == "" {
+			return
+		}
+		atomic.AddInt64(&blocksInTrash, 1)
+		atomic.AddInt64(&bytesInTrash, int64(obj.Size))
+
+		expiresAt, err := strconv.ParseInt(obj.Metadata[gcsExpiresAtKey], 10, 64)
+		if err != nil {
+			v.logger.Printf("EmptyTrash: ParseInt(%v): %v", obj.Metadata[gcsExpiresAtKey], err)
+			return
+		}
+		if expiresAt > time.Now().Unix() {
+			return
+		}
+		err = v.bucket.Delete(context.Background(), obj.Name, obj.Generation, obj.Metageneration)
+		if err != nil {
+			v.logger.Printf("EmptyTrash: Delete(%v): %v", obj.Name, err)
+			return
+		}
+		atomic.AddInt64(&blocksDeleted, 1)
+		atomic.AddInt64(&bytesDeleted, int64(obj.Size))
+	}
+
+	var wg sync.WaitGroup
+	todo := make(chan *storage.Object, v.cluster.Collections.BlobDeleteConcurrency)
+	for i := 0; i < v.cluster.Collections.BlobDeleteConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range todo {
+				doObject(obj)
+			}
+		}()
+	}
+
+	pageToken := ""
+	for {
+		resp, err := v.bucket.List(context.Background(), "", pageToken, v.IndexPageSize)
+		if err != nil {
+			v.logger.Printf("EmptyTrash: List: %v", err)
+			break
+		}
+		for _, obj := range resp.Items {
+			if v.isKeepBlock(obj.Name) {
+				todo <- obj
+			}
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+	close(todo)
+	wg.Wait()
+
+	v.logger.Printf("EmptyTrash stats for %v: Deleted %v bytes in %v blocks. Remaining in trash: %v bytes in %v blocks.", v.String(), bytesDeleted, blocksDeleted, bytesInTrash-bytesDeleted, blocksInTrash-blocksDeleted)
+}
+
+type gcsBucketStats struct {
+	statsTicker
+	Ops            uint64
+	GetOps         uint64
+	GetMetadataOps uint64
+	PutOps         uint64
+	PatchOps       uint64
+	DelOps         uint64
+	ListOps        uint64
+}
+
+func (s *gcsBucketStats) TickErr(err error) {
+	if err == nil {
+		return
+	}
+	errType := fmt.Sprintf("%T", err)
+	if err, ok := err.(*googleapi.Error); ok {
+		errType = errType + fmt.Sprintf(" %d", err.Code)
+	}
+	s.statsTicker.TickErr(err, errType)
+}
+
+// gcsBucket wraps the storage API service in order to count I/O and
+// API usage stats.
+type gcsBucket struct {
+	svc   *storage.Service
+	name  string
+	stats gcsBucketStats
+}
+
+// gcsListFields are the object attributes used by IndexTo and
+// EmptyTrash.
+const gcsListFields = "items(name,size,updated,generation,metageneration,metadata),nextPageToken"
+
+func (b *gcsBucket) GetMetadata(ctx context.Context, name string) (*storage.Object, error) {
+	b.stats.TickOps("get_metadata")
+	b.stats.Tick(&b.stats.Ops, &b.stats.GetMetadataOps)
+	obj, err := b.svc.Objects.Get(b.name, name).Context(ctx).Do()
+	b.stats.TickErr(err)
+	return obj, err
+}
+
+// Download returns the content of the given generation of an object.
+func (b *gcsBucket) Download(ctx context.Context, name string, generation int64) (io.ReadCloser, error) {
+	b.stats.TickOps("get")
+	b.stats.Tick(&b.stats.Ops, &b.stats.GetOps)
+	resp, err := b.svc.Objects.Get(b.name, name).Generation(generation).Context(ctx).Download()
+	b.stats.TickErr(err)
+	if err != nil {
+		return nil, err
+	}
+	return NewCountingReader(resp.Body, b.stats.TickInBytes), nil
+}
+
+func (b *gcsBucket) Insert(ctx context.Context, obj *storage.Object, data []byte) error {
+	b.stats.TickOps("put")
+	b.stats.Tick(&b.stats.Ops, &b.stats.PutOps)
+	// ChunkSize(0) sends the whole block in a single request
+	// instead of using a resumable upload session.
+	_, err := b.svc.Objects.Insert(b.name, obj).
+		Media(NewCountingReader(bytes.NewReader(data), b.stats.TickOutBytes), googleapi.ChunkSize(0), googleapi.ContentType(obj.ContentType)).
+		Context(ctx).Do()
+	b.stats.TickErr(err)
+	return err
+}
+
+// Patch updates an object's metadata. If generation and
+// metageneration are non-zero, the update only succeeds if the
+// object has not been replaced or modified since they were
+// retrieved.
+func (b *gcsBucket) Patch(ctx context.Context, name string, obj *storage.Object, generation, metageneration int64) error {
+	b.stats.TickOps("patch")
+	b.stats.Tick(&b.stats.Ops, &b.stats.PatchOps)
+	call := b.svc.Objects.Patch(b.name, name, obj).Context(ctx)
+	if generation != 0 {
+		call = call.IfGenerationMatch(generation).IfMetagenerationMatch(metageneration)
+	}
+	_, err := call.Do()
+	b.stats.TickErr(err)
+	return err
+}
+
+// Delete deletes an object if it has not been replaced or modified
+// since the given generation and metageneration were retrieved.
+func (b *gcsBucket) Delete(ctx context.Context, name string, generation, metageneration int64) error {
+	b.stats.TickOps("delete")
+	b.stats.Tick(&b.stats.Ops, &b.stats.DelOps)
+	err := b.svc.Objects.Delete(b.name, name).IfGenerationMatch(generation).IfMetagenerationMatch(metageneration).Context(ctx).Do()
+	b.stats.TickErr(err)
+	return err
+}
+
+func (b *gcsBucket) List(ctx context.Context, prefix, pageToken string, pageSize int) (*storage.Objects, error) {
+	b.stats.TickOps("list")
+	b.stats.Tick(&b.stats.Ops, &b.stats.ListOps)
+	resp, err := b.svc.Objects.List(b.name).Prefix(prefix).PageToken(pageToken).MaxResults(int64(pageSize)).Fields(gcsListFields).Context(ctx).Do()
+	b.stats.TickErr(err)
+	return resp, err
+}
@@ -0,0 +1,316 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+	check "gopkg.in/check.v1"
+)
+
+const gcsTestBucketName = "testbucket"
+
+// fakeGCS is a minimal implementation of the parts of the GCS JSON
+// API used by GCSVolume.
+type fakeGCS struct {
+	*httptest.Server
+	mtx     sync.Mutex
+	objects map[string]*fakeGCSObject
+	nextGen int64
+}
+
+type fakeGCSObject struct {
+	storage.Object
+	data []byte
+}
+
+func newFakeGCS() *fakeGCS {
+	f := &fakeGCS{objects: map[string]*fakeGCSObject{}}
+	f.Server = httptest.NewServer(f)
+	return f
+}
+
+// put stores an object without checking its MD5 hash.
+func (f *fakeGCS) put(name string, data []byte, metadata map[string]string) {
+	f.nextGen++
+	md5sum := md5.Sum(data)
+	f.objects[name] = &fakeGCSObject{
+		Object: storage.Object{
+			Bucket:         gcsTestBucketName,
+			Name:           name,
+			Size:           uint64(len(data)),
+			Md5Hash:        base64.StdEncoding.EncodeToString(md5sum[:]),
+			Generation:     f.nextGen,
+			Metageneration: 1,
+			Metadata:       metadata,
+			Updated:        time.Now().UTC().Format(time.RFC3339Nano),
+		},
+		data: append([]byte(nil), data...),
+	}
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	objPrefix := "/storage/v1/b/" + gcsTestBucketName + "/o"
+	switch {
+	case req.Method == "POST" && req.URL.Path == "/upload"+objPrefix:
+		f.serveUpload(w, req)
+	case req.Method == "GET" && req.URL.Path == objPrefix:
+		f.serveList(w, req)
+	case strings.HasPrefix(req.URL.Path, objPrefix+"/"):
+		name := strings.TrimPrefix(req.URL.Path, objPrefix+"/")
+		obj, ok := f.objects[name]
+		if !ok {
+			f.error(w, http.StatusNotFound)
+			return
+		}
+		if !f.checkPreconditions(req, obj) {
+			f.error(w, http.StatusPreconditionFailed)
+			return
+		}
+		switch req.Method {
+		case "GET":
+			if req.FormValue("alt") == "media" {
+				w.Write(obj.data)
+			} else {
+				json.NewEncoder(w).Encode(&obj.Object)
+			}
+		case "PATCH":
+			var patch map[string]map[string]*string
+			if err := json.NewDecoder(req.Body).Decode(&patch); err != nil {
+				f.error(w, http.StatusBadRequest)
+				return
+			}
+			for k, v := range patch["metadata"] {
+				if v == nil {
+					delete(obj.Metadata, k)
+				} else {
+					if obj.Metadata == nil {
+						obj.Metadata = map[string]string{}
+					}
+					obj.Metadata[k] = *v
+				}
+			}
+			obj.Metageneration++
+			obj.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+			json.NewEncoder(w).Encode(&obj.Object)
+		case "DELETE":
+			delete(f.objects, name)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			f.error(w, http.StatusMethodNotAllowed)
+		}
+	default:
+		f.error(w, http.StatusNotFound)
+	}
+}
+
+func (f *fakeGCS) checkPreconditions(req *http.Request, obj *fakeGCSObject) bool {
+	for param, val := range map[string]int64{
+		"generation":            obj.Generation,
+		"ifGenerationMatch":     obj.Generation,
+		"ifMetagenerationMatch": obj.Metageneration,
+	} {
+		if s := req.FormValue(param); s != "" && s != strconv.FormatInt(val, 10) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeGCS) serveUpload(w http.ResponseWriter, req *http.Request) {
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" || req.FormValue("uploadType") != "multipart" {
+		f.error(w, http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(req.Body, params["boundary"])
+	var obj storage.Object
+	part, err := mr.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&obj)
+	}
+	if err == nil {
+		part, err = mr.NextPart()
+	}
+	var data []byte
+	if err == nil {
+		data, err = ioutil.ReadAll(part)
+	}
+	if err != nil {
+		f.error(w, http.StatusBadRequest)
+		return
+	}
+	md5sum := md5.Sum(data)
+	if obj.Md5Hash != "" && obj.Md5Hash != base64.StdEncoding.EncodeToString(md5sum[:]) {
+		f.error(w, http.StatusBadRequest)
+		return
+	}
+	f.put(obj.Name, data, obj.Metadata)
+	json.NewEncoder(w).Encode(&f.objects[obj.Name].Object)
+}
+
+func (f *fakeGCS) serveList(w http.ResponseWriter, req *http.Request) {
+	var names []string
+	for name := range f.objects {
+		if strings.HasPrefix(name, req.FormValue("prefix")) && name > req.FormValue("pageToken") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var resp storage.Objects
+	max, _ := strconv.Atoi(req.FormValue("maxResults"))
+	if max > 0 && len(names) > max {
+		names = names[:max]
+		resp.NextPageToken = names[max-1]
+	}
+	for _, name := range names {
+		resp.Items = append(resp.Items, &f.objects[name].Object)
+	}
+	json.NewEncoder(w).Encode(&resp)
+}
+
+func (f *fakeGCS) error(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{"error":{"code":%d,"message":%q}}`, code, http.StatusText(code))
+}
+
+var _ = check.Suite(&StubbedGCSSuite{})
+
+type StubbedGCSSuite struct {
+	cluster *arvados.Cluster
+}
+
+func (s *StubbedGCSSuite) SetUpTest(c *check.C) {
+	s.cluster = testCluster(c)
+}
+
+func (s *StubbedGCSSuite) TestGeneric(c *check.C) {
+	DoGenericVolumeTests(c, false, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+		return s.newTestableVolume(c, cluster, volume, metrics)
+	})
+}
+
+func (s *StubbedGCSSuite) TestGenericReadOnly(c *check.C) {
+	DoGenericVolumeTests(c, true, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+		return s.newTestableVolume(c, cluster, volume, metrics)
+	})
+}
+
+func (s *StubbedGCSSuite) TestIndexPagination(c *check.C) {
+	v := s.newTestableVolume(c, s.cluster, arvados.Volume{Replication: 2}, newVolumeMetricsVecs(prometheus.NewRegistry()))
+	defer v.Teardown()
+	v.IndexPageSize = 3
+	for i := 0; i < 8; i++ {
+		v.PutRaw(fmt.Sprintf("%032x", i), []byte{byte(i)})
+	}
+	v.PutRaw("not-a-block", nil)
+	buf := &strings.Builder{}
+	c.Assert(v.IndexTo("", buf), check.IsNil)
+	c.Check(strings.Count(buf.String(), "\n"), check.Equals, 8)
+}
+
+func (s *StubbedGCSSuite) TestTrashRace(c *check.C) {
+	v := s.newTestableVolume(c, s.cluster, arvados.Volume{Replication: 2}, newVolumeMetricsVecs(prometheus.NewRegistry()))
+	defer v.Teardown()
+	s.cluster.Collections.BlobTrashLifetime = arvados.Duration(time.Hour)
+	v.PutRaw(TestHash, TestBlock)
+	v.TouchWithDate(TestHash, time.Now().Add(-2*s.cluster.Collections.BlobSigningTTL.Duration()))
+
+	// Simulate a Touch that happens after Trash checks the mtime
+	// but before it updates the metadata.
+	obj, err := v.bucket.GetMetadata(context.Background(), TestHash)
+	c.Assert(err, check.IsNil)
+	c.Assert(v.Touch(TestHash), check.IsNil)
+	err = v.bucket.Patch(context.Background(), TestHash, &storage.Object{
+		Metadata: map[string]string{gcsExpiresAtKey: "1"},
+	}, obj.Generation, obj.Metageneration)
+	c.Check(err, check.NotNil)
+
+	buf := make([]byte, BlockSize)
+	_, err = v.Get(context.Background(), TestHash, buf)
+	c.Check(err, check.IsNil)
+}
+
+func (s *StubbedGCSSuite) TestMtimeFromUpdated(c *check.C) {
+	v := s.newTestableVolume(c, s.cluster, arvados.Volume{Replication: 2}, newVolumeMetricsVecs(prometheus.NewRegistry()))
+	defer v.Teardown()
+	v.fake.mtx.Lock()
+	v.fake.put(TestHash, TestBlock, nil)
+	v.fake.objects[TestHash].Updated = "2019-01-02T03:04:05.678Z"
+	v.fake.mtx.Unlock()
+	t, err := v.Mtime(TestHash)
+	c.Check(err, check.IsNil)
+	c.Check(t.Equal(time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC)), check.Equals, true)
+}
+
+type TestableGCSVolume struct {
+	*GCSVolume
+	fake *fakeGCS
+}
+
+func (s *StubbedGCSSuite) newTestableVolume(c *check.C, cluster *arvados.Cluster, volume arvados.Volume, metrics *volumeMetricsVecs) *TestableGCSVolume {
+	fake := newFakeGCS()
+	v := &TestableGCSVolume{
+		GCSVolume: &GCSVolume{
+			Bucket:        gcsTestBucketName,
+			Endpoint:      fake.URL + "/storage/v1/",
+			IndexPageSize: 1000,
+			cluster:       cluster,
+			volume:        volume,
+			logger:        ctxlog.TestLogger(c),
+			metrics:       metrics,
+		},
+		fake: fake,
+	}
+	c.Assert(v.GCSVolume.check(option.WithoutAuthentication()), check.IsNil)
+	return v
+}
+
+// PutRaw stores an object without checking its MD5 hash.
+func (v *TestableGCSVolume) PutRaw(loc string, block []byte) {
+	v.fake.mtx.Lock()
+	defer v.fake.mtx.Unlock()
+	v.fake.put(loc, block, map[string]string{gcsMtimeKey: gcsFormatMtime(time.Now())})
+}
+
+// TouchWithDate sets the mtime metadata of an object.
+func (v *TestableGCSVolume) TouchWithDate(loc string, lastPut time.Time) {
+	v.fake.mtx.Lock()
+	defer v.fake.mtx.Unlock()
+	if obj, ok := v.fake.objects[loc]; ok {
+		obj.Metadata[gcsMtimeKey] = gcsFormatMtime(lastPut)
+	}
+}
+
+func (v *TestableGCSVolume) Teardown() {
+	v.fake.Close()
+}
+
+func (v *TestableGCSVolume) ReadWriteOperationLabelValues() (r, w string) {
+	return "get", "put"
+}
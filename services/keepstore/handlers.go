@@ -34,6 +34,7 @@ type router struct {
 	cluster     *arvados.Cluster
 	logger      logrus.FieldLogger
 	remoteProxy remoteProxy
+	erasure     *erasureReconstructor
 	metrics     *nodeMetrics
 	volmgr      *RRVolumeManager
 	pullq       *WorkQueue
@@ -51,6 +52,7 @@ func MakeRESTRouter(ctx context.Context, cluster *arvados.Cluster, reg *promethe
 		volmgr:  volmgr,
 		pullq:   pullq,
 		trashq:  trashq,
+		erasure: newErasureReconstructor(cluster, volmgr),
 	}
 
 	rtr.HandleFunc(
@@ -87,6 +89,10 @@ func MakeRESTRouter(ctx context.Context, cluster *arvados.Cluster, reg *promethe
 	// Untrash moves blocks from trash back into store
 	rtr.HandleFunc(`/untrash/{hash:[0-9a-f]{32}}`, rtr.handleUntrash).Methods("PUT")
 
+	// Get an erasure-coded shard stored here. Privileged client
+	// only.
+	rtr.HandleFunc(`/shards/{hash:[0-9a-f]{32}}`, rtr.handleGetShard).Methods("GET", "HEAD")
+
 	rtr.Handle("/_health/{check}", &health.Handler{
 		Token:  cluster.ManagementToken,
 		Prefix: "/_health/",
@@ -141,6 +147,11 @@ func (rtr *router) handleGET(resp http.ResponseWriter, req *http.Request) {
 	defer bufs.Put(buf)
 
 	size, err := GetBlock(ctx, rtr.volmgr, mux.Vars(req)["hash"], buf, resp)
+	if err == NotFoundError && rtr.erasure != nil {
+		// Try to reconstruct the block from erasure-coded
+		// shards.
+		size, err = rtr.erasure.Get(ctx, req.URL.Path[1:], buf)
+	}
 	if err != nil {
 		code := http.StatusInternalServerError
 		if err, ok := err.(*KeepError); ok {
@@ -314,10 +325,11 @@ type PoolStatus struct {
 }
 
 type volumeStatusEnt struct {
-	Label         string
-	Status        *VolumeStatus `json:",omitempty"`
-	VolumeStats   *ioStats      `json:",omitempty"`
-	InternalStats interface{}   `json:",omitempty"`
+	Label            string
+	Status           *VolumeStatus     `json:",omitempty"`
+	VolumeStats      *ioStats          `json:",omitempty"`
+	InternalStats    interface{}       `json:",omitempty"`
+	CompressionStats *compressionStats `json:",omitempty"`
 }
 
 // NodeStatus struct
@@ -375,10 +387,15 @@ func (rtr *router) readNodeStatus(st *NodeStatus) {
 		if vol, ok := vol.Volume.(InternalStatser); ok {
 			internalStats = vol.InternalStats()
 		}
+		var compressionStats *compressionStats
+		if vol, ok := vol.Volume.(*compressedVolume); ok {
+			compressionStats = vol.CompressionStats()
+		}
 		st.Volumes = append(st.Volumes, &volumeStatusEnt{
-			Label:         vol.String(),
-			Status:        vol.Status(),
-			InternalStats: internalStats,
+			Label:            vol.String(),
+			Status:           vol.Status(),
+			InternalStats:    internalStats,
+			CompressionStats: compressionStats,
 			//VolumeStats: rtr.volmgr.VolumeStats(vol),
 		})
 	}
@@ -452,6 +469,7 @@ func (rtr *router) handleDELETE(resp http.ResponseWriter, req *http.Request) {
 	for _, vol := range rtr.volmgr.AllWritable() {
 		if err := vol.Trash(hash); err == nil {
 			result.Deleted++
+			rtr.volmgr.trashed(hash)
 		} else if os.IsNotExist(err) {
 			continue
 		} else {
@@ -511,6 +529,10 @@ type PullRequest struct {
 
 	// Destination mount, or "" for "anywhere"
 	MountUUID string `json:"mount_uuid"`
+
+	// If not nil, store the indicated erasure-coded shard instead
+	// of the whole block.
+	ErasureShard *ErasureShardRequest `json:"erasure_shard,omitempty"`
 }
 
 // PullHandler processes "PUT /pull" requests for the data manager.
@@ -611,6 +633,7 @@ func (rtr *router) handleUntrash(resp http.ResponseWriter, req *http.Request) {
 		} else {
 			log.Infof("Untrashed %v on volume %v", hash, vol.String())
 			untrashedOn = append(untrashedOn, vol.String())
+			rtr.volmgr.shards.add(hash)
 		}
 	}
 
@@ -66,9 +66,11 @@ func (m *nodeMetrics) setupWorkQueueMetrics(q *WorkQueue, qName string) {
 }
 
 type volumeMetricsVecs struct {
-	ioBytes     *prometheus.CounterVec
-	errCounters *prometheus.CounterVec
-	opsCounters *prometheus.CounterVec
+	ioBytes          *prometheus.CounterVec
+	errCounters      *prometheus.CounterVec
+	opsCounters      *prometheus.CounterVec
+	compressionBytes *prometheus.CounterVec
+	compressionRatio *prometheus.GaugeVec
 }
 
 func newVolumeMetricsVecs(reg *prometheus.Registry) *volumeMetricsVecs {
@@ -103,6 +105,26 @@ func newVolumeMetricsVecs(reg *prometheus.Registry) *volumeMetricsVecs {
 		[]string{"device_id", "direction"},
 	)
 	reg.MustRegister(m.ioBytes)
+	m.compressionBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "arvados",
+			Subsystem: "keepstore",
+			Name:      "volume_compression_bytes",
+			Help:      "Data written to compressed volumes in bytes, before (uncompressed) and after (stored) compression",
+		},
+		[]string{"device_id", "type"},
+	)
+	reg.MustRegister(m.compressionBytes)
+	m.compressionRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "arvados",
+			Subsystem: "keepstore",
+			Name:      "volume_compression_ratio",
+			Help:      "Ratio of uncompressed to stored size of data written to compressed volumes",
+		},
+		[]string{"device_id"},
+	)
+	reg.MustRegister(m.compressionRatio)
 
 	return m
 }
@@ -113,3 +135,9 @@ func (vm *volumeMetricsVecs) getCounterVecsFor(lbls prometheus.Labels) (opsCV, e
 	ioCV = vm.ioBytes.MustCurryWith(lbls)
 	return
 }
+
+func (vm *volumeMetricsVecs) getCompressionVecsFor(lbls prometheus.Labels) (bytesCV *prometheus.CounterVec, ratio prometheus.Gauge) {
+	bytesCV = vm.compressionBytes.MustCurryWith(lbls)
+	ratio = vm.compressionRatio.With(lbls)
+	return
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"strings"
 	"time"
 
 	"git.arvados.org/arvados.git/sdk/go/keepclient"
@@ -77,7 +78,20 @@ func (h *handler) pullItemAndProcess(pullRequest PullRequest) error {
 		return fmt.Errorf("Content not found for: %s", signedLocator)
 	}
 
-	return writePulledBlock(h.volmgr, vol, readContent, pullRequest.Locator)
+	if pullRequest.ErasureShard != nil {
+		var volume Volume
+		if vol != nil {
+			volume = vol.Volume
+		}
+		return writePulledShard(h.volmgr, volume, readContent, pullRequest.Locator, pullRequest.ErasureShard)
+	}
+	// The locator has a size hint if the source server needs to
+	// reconstruct the block from erasure-coded shards.
+	locator := pullRequest.Locator
+	if i := strings.IndexByte(locator, '+'); i >= 0 {
+		locator = locator[:i]
+	}
+	return writePulledBlock(h.volmgr, vol, readContent, locator)
 }
 
 // Fetch the content for the given locator using keepclient.
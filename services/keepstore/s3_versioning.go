@@ -0,0 +1,466 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdRoll/goamz/s3"
+)
+
+// This file implements the S3 volume's "versioned bucket" trash mode
+// (DriverParameters.UseBucketVersioning).
+//
+// In this mode, Keep blocks are stored as ordinary objects, without
+// "trash/X" copies. The bucket's versioning feature provides the
+// trash:
+//
+// Trash deletes the current version of X, which leaves a delete
+// marker as the current version and the trashed data as a
+// noncurrent version.
+//
+// Untrash removes the delete marker, which makes the trashed data
+// current again, and then updates its timestamp.
+//
+// EmptyTrash permanently deletes all versions of X (and recent/X) if
+// X has been in the trash longer than BlobTrashLifetime, and deletes
+// noncurrent versions that are no longer needed (e.g., old recent/X
+// markers left behind by Touch).
+//
+// Touch updates the timestamp of X by writing an empty recent/X
+// marker, as in unversioned mode. Put does not write recent/X, so
+// the timestamp of X is the later of the two.
+//
+// This mode relies on the S3 service providing strong
+// read-after-write consistency, so there is no RaceWindow.
+
+// ErrS3VersioningDisabled is returned by Trash if the volume is
+// configured with UseBucketVersioning but versioning is not enabled
+// on the bucket. Trashing a block in that case would delete it
+// permanently.
+var ErrS3VersioningDisabled = errors.New("bucket versioning is not enabled, but DriverParameters.UseBucketVersioning is true")
+
+// s3Version is an entry in a ListObjectVersions response: either an
+// object version or a delete marker.
+type s3Version struct {
+	XMLName      xml.Name
+	Key          string
+	VersionId    string
+	IsLatest     bool
+	LastModified string
+	Size         int64
+}
+
+func (ver *s3Version) isDeleteMarker() bool {
+	return ver.XMLName.Local == "DeleteMarker"
+}
+
+type s3VersionsResp struct {
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIdMarker string
+	// Versions and DeleteMarkers, in the order returned by S3
+	// (grouped by key, newest first).
+	Entries []s3Version `xml:",any"`
+}
+
+// versionsOf returns all versions (including delete markers) of the
+// object with the given key, newest first.
+func (v *S3Volume) versionsOf(key string) ([]s3Version, error) {
+	var versions []s3Version
+	lister := s3VersionLister{bucket: v.bucket, Prefix: key, PageSize: v.IndexPageSize}
+	for ver := lister.First(); ver != nil; ver = lister.Next() {
+		if ver.Key == key {
+			versions = append(versions, *ver)
+		}
+	}
+	return versions, lister.Error()
+}
+
+// checkVersioning returns ErrS3VersioningDisabled if versioning is not
+// enabled on the bucket. Once versioning has been confirmed, the
+// result is cached.
+func (v *S3Volume) checkVersioning() error {
+	v.versioningMtx.Lock()
+	defer v.versioningMtx.Unlock()
+	if v.versioningOK {
+		return nil
+	}
+	var resp struct {
+		Status string
+	}
+	err := v.bucket.signedRequest("GET", "", url.Values{"versioning": {""}}, &resp)
+	if err != nil {
+		return fmt.Errorf("error getting bucket versioning status: %s", err)
+	}
+	if resp.Status != "Enabled" {
+		return ErrS3VersioningDisabled
+	}
+	v.versioningOK = true
+	return nil
+}
+
+// mtimeVersioned returns the timestamp of the given block in
+// versioned-bucket mode, given the response to a HEAD request for
+// the data object.
+func (v *S3Volume) mtimeVersioned(loc string, resp *http.Response) (time.Time, error) {
+	t, err := v.lastModified(resp)
+	if err != nil {
+		return zeroTime, err
+	}
+	resp, err = v.bucket.Head("recent/"+loc, nil)
+	err = v.translateError(err)
+	if os.IsNotExist(err) {
+		return t, nil
+	} else if err != nil {
+		return zeroTime, err
+	}
+	recentT, err := v.lastModified(resp)
+	if err != nil {
+		return zeroTime, err
+	}
+	if recentT.After(t) {
+		t = recentT
+	}
+	return t, nil
+}
+
+// deleteVersions permanently deletes the given object versions.
+func (v *S3Volume) deleteVersions(versions []s3Version) error {
+	for _, ver := range versions {
+		err := v.bucket.DelVersion(ver.Key, ver.VersionId)
+		if err != nil {
+			return fmt.Errorf("error deleting %q version %q: %s", ver.Key, ver.VersionId, v.translateError(err))
+		}
+	}
+	return nil
+}
+
+// trashVersioned implements Trash in versioned-bucket mode. The caller
+// has already checked that the block is old enough to trash.
+func (v *S3Volume) trashVersioned(loc string) error {
+	if err := v.checkVersioning(); err != nil {
+		return err
+	}
+	if v.cluster.Collections.BlobTrashLifetime == 0 {
+		if !v.UnsafeDelete {
+			return ErrS3TrashDisabled
+		}
+		versions, err := v.versionsOf(loc)
+		if err != nil {
+			return v.translateError(err)
+		}
+		return v.deleteVersions(versions)
+	}
+	err := v.translateError(v.bucket.Del(loc))
+	if err != nil {
+		return err
+	}
+
+	// If the block was written (or touched) by someone else
+	// between our Mtime check and our Del, we have just trashed
+	// a new block that might not be referenced by any collection
+	// yet. Detect and undo that.
+	versions, err := v.versionsOf(loc)
+	if err != nil {
+		v.logger.WithError(err).Warnf("Trash: error checking for concurrent write after trashing %q", loc)
+		return nil
+	}
+	for _, ver := range versions {
+		if ver.isDeleteMarker() {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, ver.LastModified)
+		if err == nil && time.Since(t) < v.cluster.Collections.BlobSigningTTL.Duration() {
+			v.logger.Infof("Trash: %q was written at %s, after Trash started; calling Untrash", loc, t)
+			return v.Untrash(loc)
+		}
+		break
+	}
+	if resp, err := v.bucket.Head("recent/"+loc, nil); err == nil {
+		t, err := v.lastModified(resp)
+		if err == nil && time.Since(t) < v.cluster.Collections.BlobSigningTTL.Duration() {
+			v.logger.Infof("Trash: %q was touched at %s, after Trash started; calling Untrash", loc, t)
+			return v.Untrash(loc)
+		}
+	}
+	return nil
+}
+
+// untrashVersioned implements Untrash in versioned-bucket mode.
+func (v *S3Volume) untrashVersioned(loc string) error {
+	versions, err := v.versionsOf(loc)
+	if err != nil {
+		return v.translateError(err)
+	}
+	if len(versions) == 0 || !versions[0].isDeleteMarker() {
+		// Nothing to untrash: either there is no such block,
+		// or it is not in the trash.
+		return os.ErrNotExist
+	}
+	var markers []s3Version
+	for _, ver := range versions {
+		if !ver.isDeleteMarker() {
+			break
+		}
+		markers = append(markers, ver)
+	}
+	if len(markers) == len(versions) {
+		// Only delete markers, no data.
+		return os.ErrNotExist
+	}
+	err = v.deleteVersions(markers)
+	if err != nil {
+		return err
+	}
+	// Update the timestamp on the newly restored version, so it
+	// isn't immediately eligible to be trashed again.
+	err = v.bucket.PutReader("recent/"+loc, nil, 0, "application/octet-stream", s3ACL, s3.Options{})
+	return v.translateError(err)
+}
+
+// emptyTrashVersioned implements EmptyTrash in versioned-bucket mode.
+func (v *S3Volume) emptyTrashVersioned() {
+	var bytesInTrash, blocksInTrash, bytesDeleted, blocksDeleted int64
+
+	// Define "ready to delete" as "...when EmptyTrash started".
+	startT := time.Now()
+
+	emptyOneKey := func(versions []s3Version) {
+		loc := versions[0].Key
+		if strings.HasPrefix(loc, "recent/") && v.isKeepBlock(loc[7:]) {
+			// Only the current recent/X marker is
+			// needed. All versions of recent/X are
+			// deleted along with X below, but a marker
+			// might also be left behind by a
+			// Touch/Trash race.
+			if !versions[0].isDeleteMarker() {
+				versions = versions[1:]
+			}
+			if len(versions) > 0 {
+				err := v.deleteVersions(versions)
+				if err != nil {
+					v.logger.WithError(err).Warnf("EmptyTrash: error deleting old versions of %q", loc)
+				}
+			}
+			return
+		}
+		if !v.isKeepBlock(loc) {
+			return
+		}
+		if !versions[0].isDeleteMarker() {
+			// The block is not in the trash. Any
+			// noncurrent versions are redundant copies
+			// left behind by Put or a trash/write race.
+			if len(versions) > 1 {
+				err := v.deleteVersions(versions[1:])
+				if err != nil {
+					v.logger.WithError(err).Warnf("EmptyTrash: error deleting noncurrent versions of %q", loc)
+				}
+			}
+			return
+		}
+
+		// The block is in the trash. Find the data version
+		// that would be restored by Untrash.
+		var data *s3Version
+		for i := range versions {
+			if !versions[i].isDeleteMarker() {
+				data = &versions[i]
+				break
+			}
+		}
+		if data == nil {
+			// Nothing but delete markers. Clean them up.
+			err := v.deleteVersions(versions)
+			if err != nil {
+				v.logger.WithError(err).Warnf("EmptyTrash: error deleting delete markers for %q", loc)
+			}
+			return
+		}
+		atomic.AddInt64(&bytesInTrash, data.Size)
+		atomic.AddInt64(&blocksInTrash, 1)
+
+		trashT, err := time.Parse(time.RFC3339, versions[0].LastModified)
+		if err != nil {
+			v.logger.Warnf("EmptyTrash: %q: parse %q: %s", loc, versions[0].LastModified, err)
+			return
+		}
+		if startT.Sub(trashT) < v.cluster.Collections.BlobTrashLifetime.Duration() {
+			return
+		}
+		err = v.deleteVersions(versions)
+		if err != nil {
+			v.logger.WithError(err).Errorf("EmptyTrash: error deleting %q", loc)
+			return
+		}
+		atomic.AddInt64(&bytesDeleted, data.Size)
+		atomic.AddInt64(&blocksDeleted, 1)
+		recent, err := v.versionsOf("recent/" + loc)
+		if err == nil {
+			err = v.deleteVersions(recent)
+		}
+		if err != nil {
+			v.logger.WithError(err).Warnf("EmptyTrash: error deleting %q", "recent/"+loc)
+		}
+	}
+
+	var wg sync.WaitGroup
+	todo := make(chan []s3Version, v.cluster.Collections.BlobDeleteConcurrency)
+	for i := 0; i < v.cluster.Collections.BlobDeleteConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for versions := range todo {
+				emptyOneKey(versions)
+			}
+		}()
+	}
+
+	lister := s3VersionLister{bucket: v.bucket, PageSize: v.IndexPageSize}
+	var versions []s3Version
+	for ver := lister.First(); ver != nil; ver = lister.Next() {
+		if len(versions) > 0 && ver.Key != versions[0].Key {
+			todo <- versions
+			versions = nil
+		}
+		versions = append(versions, *ver)
+	}
+	if len(versions) > 0 && lister.Error() == nil {
+		todo <- versions
+	}
+	close(todo)
+	wg.Wait()
+
+	if err := lister.Error(); err != nil {
+		v.logger.WithError(err).Error("EmptyTrash: lister failed")
+	}
+	v.logger.Infof("EmptyTrash stats for %v: Deleted %v bytes in %v blocks. Remaining in trash: %v bytes in %v blocks.", v.String(), bytesDeleted, blocksDeleted, bytesInTrash-bytesDeleted, blocksInTrash-blocksDeleted)
+}
+
+// s3VersionLister lists object versions and delete markers, one page
+// at a time.
+type s3VersionLister struct {
+	bucket   *s3bucket
+	Prefix   string
+	PageSize int
+
+	nextKeyMarker       string
+	nextVersionIdMarker string
+	buf                 []s3Version
+	err                 error
+}
+
+// First fetches the first page and returns the first item. It returns
+// nil if the response is the empty set or an error occurs.
+func (lister *s3VersionLister) First() *s3Version {
+	lister.getPage()
+	return lister.pop()
+}
+
+// Next returns the next item, fetching the next page if necessary. It
+// returns nil if the last available item has already been fetched, or
+// an error occurs.
+func (lister *s3VersionLister) Next() *s3Version {
+	if len(lister.buf) == 0 && lister.nextKeyMarker != "" {
+		lister.getPage()
+	}
+	return lister.pop()
+}
+
+// Return the most recent error encountered by First or Next.
+func (lister *s3VersionLister) Error() error {
+	return lister.err
+}
+
+func (lister *s3VersionLister) getPage() {
+	lister.bucket.stats.TickOps("list")
+	lister.bucket.stats.Tick(&lister.bucket.stats.Ops, &lister.bucket.stats.ListOps)
+	params := url.Values{"versions": {""}, "prefix": {lister.Prefix}}
+	if lister.PageSize > 0 {
+		params.Set("max-keys", fmt.Sprintf("%d", lister.PageSize))
+	}
+	if lister.nextKeyMarker != "" {
+		params.Set("key-marker", lister.nextKeyMarker)
+		params.Set("version-id-marker", lister.nextVersionIdMarker)
+	}
+	lister.nextKeyMarker, lister.nextVersionIdMarker = "", ""
+	var resp s3VersionsResp
+	err := lister.bucket.signedRequest("GET", "", params, &resp)
+	lister.bucket.stats.TickErr(err)
+	if err != nil {
+		lister.err = err
+		return
+	}
+	if resp.IsTruncated {
+		lister.nextKeyMarker = resp.NextKeyMarker
+		lister.nextVersionIdMarker = resp.NextVersionIdMarker
+	}
+	lister.buf = make([]s3Version, 0, len(resp.Entries))
+	for _, ver := range resp.Entries {
+		if ver.XMLName.Local == "Version" || ver.isDeleteMarker() {
+			lister.buf = append(lister.buf, ver)
+		}
+	}
+}
+
+func (lister *s3VersionLister) pop() (ver *s3Version) {
+	if len(lister.buf) > 0 {
+		ver = &lister.buf[0]
+		lister.buf = lister.buf[1:]
+	}
+	return
+}
+
+// DelVersion permanently deletes the given version of an object.
+func (b *s3bucket) DelVersion(path, versionID string) error {
+	err := b.signedRequest("DELETE", path, url.Values{"versionId": {versionID}}, nil)
+	b.stats.TickOps("delete")
+	b.stats.Tick(&b.stats.Ops, &b.stats.DelOps)
+	b.stats.TickErr(err)
+	return err
+}
+
+// signedRequest sends a request that isn't supported by the goamz
+// Bucket API, using a presigned URL. If dst is not nil, the XML
+// response body is decoded into it.
+func (b *s3bucket) signedRequest(method, path string, params url.Values, dst interface{}) error {
+	bucket := b.Bucket()
+	u := bucket.SignedURLWithMethod(method, path, time.Now().Add(time.Minute), params, nil)
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		s3err := &s3.Error{}
+		xml.NewDecoder(resp.Body).Decode(s3err)
+		s3err.StatusCode = resp.StatusCode
+		if s3err.Message == "" {
+			s3err.Message = resp.Status
+		}
+		return s3err
+	}
+	if dst == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return xml.NewDecoder(resp.Body).Decode(dst)
+}
@@ -0,0 +1,364 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	check "gopkg.in/check.v1"
+)
+
+// versionedS3Stub is a minimal S3 service with object versioning,
+// sufficient for testing S3Volume with UseBucketVersioning.
+type versionedS3Stub struct {
+	*httptest.Server
+	versioning string
+	mtx        sync.Mutex
+	objects    map[string][]*stubVersion // newest first
+	nextID     int
+	requests   []string
+}
+
+type stubVersion struct {
+	id           string
+	data         []byte // nil for a delete marker
+	deleteMarker bool
+	modified     time.Time
+}
+
+func newVersionedS3Stub() *versionedS3Stub {
+	stub := &versionedS3Stub{
+		versioning: "Enabled",
+		objects:    map[string][]*stubVersion{},
+	}
+	stub.Server = httptest.NewServer(stub)
+	return stub
+}
+
+func (stub *versionedS3Stub) addVersion(key string, ver *stubVersion) {
+	stub.nextID++
+	ver.id = fmt.Sprintf("v%d", stub.nextID)
+	stub.objects[key] = append([]*stubVersion{ver}, stub.objects[key]...)
+}
+
+func (stub *versionedS3Stub) current(key string) *stubVersion {
+	if vers := stub.objects[key]; len(vers) > 0 && !vers[0].deleteMarker {
+		return vers[0]
+	}
+	return nil
+}
+
+func (stub *versionedS3Stub) sortedKeys(prefix string) []string {
+	var keys []string
+	for key := range stub.objects {
+		if strings.HasPrefix(key, prefix) && len(stub.objects[key]) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (stub *versionedS3Stub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	stub.mtx.Lock()
+	defer stub.mtx.Unlock()
+	req.ParseForm()
+	stub.requests = append(stub.requests, req.Method+" "+req.URL.Path+"?"+req.URL.RawQuery)
+	// Path-style addressing: /bucket/key
+	key := strings.TrimPrefix(req.URL.Path, "/"+TestBucketName)
+	key = strings.TrimPrefix(key, "/")
+	_, versions := req.Form["versions"]
+	_, versioning := req.Form["versioning"]
+	switch {
+	case key == "" && req.Method == "PUT":
+		// create bucket
+	case key == "" && versioning:
+		fmt.Fprintf(w, `<VersioningConfiguration><Status>%s</Status></VersioningConfiguration>`, stub.versioning)
+	case key == "" && versions:
+		stub.listVersions(w, req)
+	case key == "":
+		stub.list(w, req)
+	case req.Method == "PUT" && req.Header.Get("X-Amz-Copy-Source") != "":
+		src, _ := url.PathUnescape(strings.TrimPrefix(req.Header.Get("X-Amz-Copy-Source"), "/"))
+		src = strings.TrimPrefix(src, TestBucketName+"/")
+		cur := stub.current(src)
+		if cur == nil {
+			stub.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		ver := &stubVersion{data: cur.data, modified: time.Now()}
+		stub.addVersion(key, ver)
+		fmt.Fprintf(w, `<CopyObjectResult><LastModified>%s</LastModified><ETag>"%x"</ETag></CopyObjectResult>`, ver.modified.UTC().Format(time.RFC3339Nano), md5.Sum(ver.data))
+	case req.Method == "PUT":
+		data, _ := ioutil.ReadAll(req.Body)
+		stub.addVersion(key, &stubVersion{data: data, modified: time.Now()})
+	case req.Method == "DELETE" && req.Form.Get("versionId") != "":
+		vers := stub.objects[key]
+		for i, ver := range vers {
+			if ver.id == req.Form.Get("versionId") {
+				stub.objects[key] = append(vers[:i:i], vers[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		stub.error(w, http.StatusNotFound, "NoSuchVersion")
+	case req.Method == "DELETE":
+		if len(stub.objects[key]) > 0 {
+			stub.addVersion(key, &stubVersion{deleteMarker: true, modified: time.Now()})
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case req.Method == "GET" || req.Method == "HEAD":
+		cur := stub.current(key)
+		if cur == nil {
+			stub.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Last-Modified", cur.modified.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(cur.data)))
+		if req.Method == "GET" {
+			w.Write(cur.data)
+		}
+	default:
+		stub.error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (stub *versionedS3Stub) error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message></Error>`, code, http.StatusText(status))
+}
+
+func (stub *versionedS3Stub) list(w http.ResponseWriter, req *http.Request) {
+	type content struct {
+		Key          string
+		LastModified string
+		Size         int
+	}
+	var resp struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Contents []content
+	}
+	for _, key := range stub.sortedKeys(req.Form.Get("prefix")) {
+		if cur := stub.current(key); cur != nil && key > req.Form.Get("marker") {
+			resp.Contents = append(resp.Contents, content{key, cur.modified.UTC().Format(time.RFC3339), len(cur.data)})
+		}
+	}
+	xml.NewEncoder(w).Encode(resp)
+}
+
+// listVersions returns all versions in a single page, ignoring
+// max-keys.
+func (stub *versionedS3Stub) listVersions(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprint(w, `<ListVersionsResult><Name>`+TestBucketName+`</Name><IsTruncated>false</IsTruncated>`)
+	for _, key := range stub.sortedKeys(req.Form.Get("prefix")) {
+		for i, ver := range stub.objects[key] {
+			elt := "Version"
+			if ver.deleteMarker {
+				elt = "DeleteMarker"
+			}
+			fmt.Fprintf(w, `<%s><Key>%s</Key><VersionId>%s</VersionId><IsLatest>%v</IsLatest><LastModified>%s</LastModified><Size>%d</Size></%s>`,
+				elt, key, ver.id, i == 0, ver.modified.UTC().Format(time.RFC3339), len(ver.data), elt)
+		}
+	}
+	fmt.Fprint(w, `</ListVersionsResult>`)
+}
+
+var _ = check.Suite(&StubbedS3VersioningSuite{})
+
+type StubbedS3VersioningSuite struct {
+	cluster *arvados.Cluster
+}
+
+func (s *StubbedS3VersioningSuite) SetUpTest(c *check.C) {
+	s.cluster = testCluster(c)
+}
+
+func (s *StubbedS3VersioningSuite) TestGeneric(c *check.C) {
+	DoGenericVolumeTests(c, false, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+		return s.newTestableVolume(c, cluster, volume, metrics)
+	})
+}
+
+func (s *StubbedS3VersioningSuite) TestGenericReadOnly(c *check.C) {
+	DoGenericVolumeTests(c, true, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+		return s.newTestableVolume(c, cluster, volume, metrics)
+	})
+}
+
+func (s *StubbedS3VersioningSuite) TestMarkers(c *check.C) {
+	v := s.newTestableVolume(c, s.cluster, arvados.Volume{Replication: 2}, newVolumeMetricsVecs(prometheus.NewRegistry()))
+	defer v.Teardown()
+	err := v.Put(context.Background(), TestHash, TestBlock)
+	c.Assert(err, check.IsNil)
+	c.Check(v.stub.sortedKeys(""), check.DeepEquals, []string{TestHash})
+
+	// Touch writes an empty recent/X marker instead of copying
+	// the data.
+	err = v.Touch(TestHash)
+	c.Assert(err, check.IsNil)
+	c.Check(v.stub.sortedKeys(""), check.DeepEquals, []string{TestHash, "recent/" + TestHash})
+	c.Check(v.stub.objects[TestHash], check.HasLen, 1)
+	c.Check(v.stub.current("recent/"+TestHash).data, check.HasLen, 0)
+
+	// Mtime and IndexTo use the later of the X and recent/X
+	// timestamps.
+	old := time.Now().Add(-2 * s.cluster.Collections.BlobSigningTTL.Duration()).Truncate(time.Second)
+	v.stub.current(TestHash).modified = old
+	t, err := v.Mtime(TestHash)
+	c.Check(err, check.IsNil)
+	c.Check(t.After(old), check.Equals, true)
+	var index bytes.Buffer
+	c.Check(v.IndexTo("", &index), check.IsNil)
+	c.Check(index.String(), check.Not(check.Matches), fmt.Sprintf(`(?s).* %d\n`, old.UnixNano()))
+	v.stub.current("recent/" + TestHash).modified = old.Add(-time.Hour)
+	t, err = v.Mtime(TestHash)
+	c.Check(err, check.IsNil)
+	c.Check(t.Equal(old), check.Equals, true)
+	index.Reset()
+	c.Check(v.IndexTo("", &index), check.IsNil)
+	c.Check(index.String(), check.Equals, fmt.Sprintf("%s+%d %d\n", TestHash, len(TestBlock), old.UnixNano()))
+
+	s.cluster.Collections.BlobTrashLifetime = arvados.Duration(time.Hour)
+	err = v.Trash(TestHash)
+	c.Assert(err, check.IsNil)
+	for _, req := range v.stub.requests {
+		c.Check(req, check.Not(check.Matches), `.*/trash/.*`)
+	}
+}
+
+func (s *StubbedS3VersioningSuite) TestEmptyTrashDeletesNoncurrentVersions(c *check.C) {
+	v := s.newTestableVolume(c, s.cluster, arvados.Volume{Replication: 2}, newVolumeMetricsVecs(prometheus.NewRegistry()))
+	defer v.Teardown()
+	v.PutRaw(TestHash, TestBlock)
+	v.PutRaw(TestHash, TestBlock)
+	c.Assert(v.Touch(TestHash), check.IsNil)
+	c.Assert(v.Touch(TestHash), check.IsNil)
+	c.Check(v.stub.objects[TestHash], check.HasLen, 2)
+	c.Check(v.stub.objects["recent/"+TestHash], check.HasLen, 2)
+	v.EmptyTrash()
+	c.Check(v.stub.objects[TestHash], check.HasLen, 1)
+	c.Check(v.stub.objects["recent/"+TestHash], check.HasLen, 1)
+	buf := make([]byte, BlockSize)
+	n, err := v.Get(context.Background(), TestHash, buf)
+	c.Check(err, check.IsNil)
+	c.Check(buf[:n], check.DeepEquals, TestBlock)
+}
+
+func (s *StubbedS3VersioningSuite) TestEmptyTrashDeletesMarkers(c *check.C) {
+	v := s.newTestableVolume(c, s.cluster, arvados.Volume{Replication: 2}, newVolumeMetricsVecs(prometheus.NewRegistry()))
+	defer v.Teardown()
+	s.cluster.Collections.BlobTrashLifetime = arvados.Duration(time.Hour)
+	v.PutRaw(TestHash, TestBlock)
+	c.Assert(v.Touch(TestHash), check.IsNil)
+	v.TouchWithDate(TestHash, time.Now().Add(-2*s.cluster.Collections.BlobSigningTTL.Duration()))
+	c.Assert(v.Trash(TestHash), check.IsNil)
+	c.Check(v.stub.current(TestHash), check.IsNil)
+	v.stub.objects[TestHash][0].modified = time.Now().Add(-2 * time.Hour)
+	v.EmptyTrash()
+	c.Check(v.stub.sortedKeys(""), check.HasLen, 0)
+}
+
+func (s *StubbedS3VersioningSuite) TestTrashRace(c *check.C) {
+	v := s.newTestableVolume(c, s.cluster, arvados.Volume{Replication: 2}, newVolumeMetricsVecs(prometheus.NewRegistry()))
+	defer v.Teardown()
+	s.cluster.Collections.BlobTrashLifetime = arvados.Duration(time.Hour)
+	v.PutRaw(TestHash, TestBlock)
+	v.TouchWithDate(TestHash, time.Now().Add(-2*s.cluster.Collections.BlobSigningTTL.Duration()))
+
+	// Simulate a Put that happens after Trash checks Mtime but
+	// before it deletes the block: trashVersioned's post-delete
+	// check should notice the new version and restore it.
+	v.PutRaw(TestHash, TestBlock)
+	c.Assert(v.trashVersioned(TestHash), check.IsNil)
+	buf := make([]byte, BlockSize)
+	_, err := v.Get(context.Background(), TestHash, buf)
+	c.Check(err, check.IsNil)
+}
+
+func (s *StubbedS3VersioningSuite) TestVersioningNotEnabled(c *check.C) {
+	v := s.newTestableVolume(c, s.cluster, arvados.Volume{Replication: 2}, newVolumeMetricsVecs(prometheus.NewRegistry()))
+	defer v.Teardown()
+	v.stub.versioning = "Suspended"
+	s.cluster.Collections.BlobTrashLifetime = arvados.Duration(time.Hour)
+	v.PutRaw(TestHash, TestBlock)
+	v.TouchWithDate(TestHash, time.Now().Add(-2*s.cluster.Collections.BlobSigningTTL.Duration()))
+	err := v.Trash(TestHash)
+	c.Check(err, check.Equals, ErrS3VersioningDisabled)
+	buf := make([]byte, BlockSize)
+	_, err = v.Get(context.Background(), TestHash, buf)
+	c.Check(err, check.IsNil)
+}
+
+type TestableS3VersioningVolume struct {
+	*S3Volume
+	stub *versionedS3Stub
+}
+
+func (s *StubbedS3VersioningSuite) newTestableVolume(c *check.C, cluster *arvados.Cluster, volume arvados.Volume, metrics *volumeMetricsVecs) *TestableS3VersioningVolume {
+	stub := newVersionedS3Stub()
+	v := &TestableS3VersioningVolume{
+		S3Volume: &S3Volume{
+			AccessKey:           "xxx",
+			SecretKey:           "xxx",
+			Bucket:              TestBucketName,
+			Endpoint:            stub.URL,
+			Region:              "test-region-1",
+			LocationConstraint:  true,
+			UnsafeDelete:        true,
+			UseBucketVersioning: true,
+			IndexPageSize:       1000,
+			cluster:             cluster,
+			volume:              volume,
+			logger:              ctxlog.TestLogger(c),
+			metrics:             metrics,
+		},
+		stub: stub,
+	}
+	c.Assert(v.S3Volume.check(), check.IsNil)
+	return v
+}
+
+// PutRaw adds a new version without checking ContentMD5.
+func (v *TestableS3VersioningVolume) PutRaw(loc string, block []byte) {
+	v.stub.mtx.Lock()
+	defer v.stub.mtx.Unlock()
+	v.stub.addVersion(loc, &stubVersion{data: append([]byte(nil), block...), modified: time.Now()})
+}
+
+// TouchWithDate changes the timestamp of the current versions of X
+// and recent/X.
+func (v *TestableS3VersioningVolume) TouchWithDate(loc string, lastPut time.Time) {
+	v.stub.mtx.Lock()
+	defer v.stub.mtx.Unlock()
+	for _, key := range []string{loc, "recent/" + loc} {
+		if cur := v.stub.current(key); cur != nil {
+			cur.modified = lastPut
+		}
+	}
+}
+
+func (v *TestableS3VersioningVolume) Teardown() {
+	v.stub.Close()
+}
+
+func (v *TestableS3VersioningVolume) ReadWriteOperationLabelValues() (r, w string) {
+	return "get", "put"
+}
@@ -16,6 +16,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"regexp"
@@ -87,6 +88,7 @@ func (v *S3Volume) check() error {
 			S3:   v.newS3Client(),
 			Name: v.Bucket,
 		},
+		client: v.newHTTPClient(),
 	}
 	// Set up prometheus metrics
 	lbls := prometheus.Labels{"device_id": v.GetDeviceID()}
@@ -144,6 +146,10 @@ type S3Volume struct {
 	RaceWindow         arvados.Duration
 	UnsafeDelete       bool
 
+	// Use bucket versioning instead of trash/ and recent/
+	// objects to implement trash (see s3_versioning.go).
+	UseBucketVersioning bool
+
 	cluster   *arvados.Cluster
 	volume    arvados.Volume
 	logger    logrus.FieldLogger
@@ -151,6 +157,9 @@ type S3Volume struct {
 	bucket    *s3bucket
 	region    aws.Region
 	startOnce sync.Once
+
+	versioningMtx sync.Mutex
+	versioningOK  bool // bucket versioning is known to be enabled
 }
 
 // GetDeviceID returns a globally unique ID for the storage bucket.
@@ -197,6 +206,21 @@ func (v *S3Volume) newS3Client() *s3.S3 {
 	return client
 }
 
+// newHTTPClient returns an HTTP client for requests that aren't
+// supported by the goamz Bucket API (see signedRequest), with the
+// same connect and read timeouts as the goamz client.
+func (v *S3Volume) newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: time.Duration(v.ReadTimeout),
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout: time.Duration(v.ConnectTimeout),
+			}).DialContext,
+		},
+	}
+}
+
 // returned by AWS metadata endpoint .../security-credentials/${rolename}
 type iamCredentials struct {
 	Code            string
@@ -322,7 +346,7 @@ func (v *S3Volume) getReaderWithContext(ctx context.Context, loc string) (rdr io
 func (v *S3Volume) getReader(loc string) (rdr io.ReadCloser, err error) {
 	rdr, err = v.bucket.GetReader(loc)
 	err = v.translateError(err)
-	if err == nil || !os.IsNotExist(err) {
+	if err == nil || !os.IsNotExist(err) || v.UseBucketVersioning {
 		return
 	}
 
@@ -383,10 +407,47 @@ func (v *S3Volume) Get(ctx context.Context, loc string, buf []byte) (int, error)
 	}
 }
 
+// ReadBlock implements BlockReader.
+func (v *S3Volume) ReadBlock(ctx context.Context, loc string, w io.Writer) error {
+	rdr, err := v.getReaderWithContext(ctx, loc)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+	_, err = io.Copy(w, rdr)
+	return err
+}
+
+// ReadBlockHeader implements BlockHeaderReader, using a range
+// request so only the requested bytes are transferred.
+func (v *S3Volume) ReadBlockHeader(ctx context.Context, loc string, buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	rdr, err := v.bucket.GetRange(loc, 0, len(buf)-1)
+	if err != nil {
+		return 0, v.translateError(err)
+	}
+	defer rdr.Close()
+	n, err := io.ReadFull(rdr, buf)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
 // Compare the given data with the stored data.
 func (v *S3Volume) Compare(ctx context.Context, loc string, expect []byte) error {
 	errChan := make(chan error, 1)
 	go func() {
+		if v.UseBucketVersioning {
+			// Put doesn't write recent/X markers,
+			// and there's no need for them (see
+			// below): S3 services that support
+			// versioning are strongly consistent.
+			errChan <- nil
+			return
+		}
 		_, err := v.bucket.Head("recent/"+loc, nil)
 		errChan <- err
 	}()
@@ -470,7 +531,7 @@ func (v *S3Volume) Put(ctx context.Context, loc string, block []byte) error {
 		}()
 		defer close(ready)
 		err = v.bucket.PutReader(loc, bufr, int64(size), "application/octet-stream", s3ACL, opts)
-		if err != nil {
+		if err != nil || v.UseBucketVersioning {
 			return
 		}
 		err = v.bucket.PutReader("recent/"+loc, nil, 0, "application/octet-stream", s3ACL, s3.Options{})
@@ -501,7 +562,11 @@ func (v *S3Volume) Touch(loc string) error {
 	}
 	_, err := v.bucket.Head(loc, nil)
 	err = v.translateError(err)
-	if os.IsNotExist(err) && v.fixRace(loc) {
+	if err == nil && v.UseBucketVersioning {
+		// Fall through to update recent/X. Unlike the
+		// unversioned case, a missing X cannot be rescued
+		// by fixRace.
+	} else if os.IsNotExist(err) && v.fixRace(loc) {
 		// The data object got trashed in a race, but fixRace
 		// rescued it.
 	} else if err != nil {
@@ -513,11 +578,14 @@ func (v *S3Volume) Touch(loc string) error {
 
 // Mtime returns the stored timestamp for the given locator.
 func (v *S3Volume) Mtime(loc string) (time.Time, error) {
-	_, err := v.bucket.Head(loc, nil)
+	resp, err := v.bucket.Head(loc, nil)
 	if err != nil {
 		return zeroTime, v.translateError(err)
 	}
-	resp, err := v.bucket.Head("recent/"+loc, nil)
+	if v.UseBucketVersioning {
+		return v.mtimeVersioned(loc, resp)
+	}
+	resp, err = v.bucket.Head("recent/"+loc, nil)
 	err = v.translateError(err)
 	if os.IsNotExist(err) {
 		// The data object X exists, but recent/X is missing.
@@ -557,7 +625,8 @@ func (v *S3Volume) IndexTo(prefix string, writer io.Writer) error {
 		PageSize: v.IndexPageSize,
 		Stats:    &v.bucket.stats,
 	}
-	for data, recent := dataL.First(), recentL.First(); data != nil && dataL.Error() == nil; data = dataL.Next() {
+	recent := recentL.First()
+	for data := dataL.First(); data != nil && dataL.Error() == nil; data = dataL.Next() {
 		if data.Key >= "g" {
 			// Conveniently, "recent/*" and "trash/*" are
 			// lexically greater than all hex-encoded data
@@ -598,6 +667,13 @@ func (v *S3Volume) IndexTo(prefix string, writer io.Writer) error {
 		if err != nil {
 			return err
 		}
+		if v.UseBucketVersioning && stamp != data {
+			// Put doesn't update recent/X in versioned
+			// mode, so the data object might be newer.
+			if dt, err := time.Parse(time.RFC3339, data.LastModified); err == nil && dt.After(t) {
+				t = dt
+			}
+		}
 		fmt.Fprintf(writer, "%s+%d %d\n", data.Key, data.Size, t.UnixNano())
 	}
 	return dataL.Error()
@@ -613,6 +689,9 @@ func (v *S3Volume) Trash(loc string) error {
 	} else if time.Since(t) < v.cluster.Collections.BlobSigningTTL.Duration() {
 		return nil
 	}
+	if v.UseBucketVersioning {
+		return v.trashVersioned(loc)
+	}
 	if v.cluster.Collections.BlobTrashLifetime == 0 {
 		if !v.UnsafeDelete {
 			return ErrS3TrashDisabled
@@ -703,6 +782,9 @@ func (v *S3Volume) lastModified(resp *http.Response) (t time.Time, err error) {
 
 // Untrash moves block from trash back into store
 func (v *S3Volume) Untrash(loc string) error {
+	if v.UseBucketVersioning {
+		return v.untrashVersioned(loc)
+	}
 	err := v.safeCopy(loc, "trash/"+loc)
 	if err != nil {
 		return err
@@ -805,6 +887,10 @@ func (v *S3Volume) EmptyTrash() {
 	if v.cluster.Collections.BlobDeleteConcurrency < 1 {
 		return
 	}
+	if v.UseBucketVersioning {
+		v.emptyTrashVersioned()
+		return
+	}
 
 	var bytesInTrash, blocksInTrash, bytesDeleted, blocksDeleted int64
 
@@ -991,6 +1077,7 @@ func (lister *s3Lister) pop() (k *s3.Key) {
 // to update credentials.
 type s3bucket struct {
 	bucket *s3.Bucket
+	client *http.Client
 	stats  s3bucketStats
 	mu     sync.Mutex
 }
@@ -1015,6 +1102,21 @@ func (b *s3bucket) GetReader(path string) (io.ReadCloser, error) {
 	return NewCountingReader(rdr, b.stats.TickInBytes), err
 }
 
+// GetRange returns a reader for bytes first..last (inclusive) of the
+// given object.
+func (b *s3bucket) GetRange(path string, first, last int) (io.ReadCloser, error) {
+	resp, err := b.Bucket().GetResponseWithHeaders(path, map[string][]string{
+		"Range": {fmt.Sprintf("bytes=%d-%d", first, last)},
+	})
+	b.stats.TickOps("get")
+	b.stats.Tick(&b.stats.Ops, &b.stats.GetOps)
+	b.stats.TickErr(err)
+	if err != nil {
+		return nil, err
+	}
+	return NewCountingReader(resp.Body, b.stats.TickInBytes), nil
+}
+
 func (b *s3bucket) Head(path string, headers map[string][]string) (*http.Response, error) {
 	resp, err := b.Bucket().Head(path, headers)
 	b.stats.TickOps("head")
@@ -101,6 +101,23 @@ func (s *StubbedS3Suite) TestIndex(c *check.C) {
 	}
 }
 
+func (s *StubbedS3Suite) TestReadBlockHeader(c *check.C) {
+	v := s.newTestableVolume(c, s.cluster, arvados.Volume{Replication: 2}, newVolumeMetricsVecs(prometheus.NewRegistry()), 0)
+	v.PutRaw(TestHash, TestBlock)
+	buf := make([]byte, 4)
+	n, err := v.ReadBlockHeader(context.Background(), TestHash, buf)
+	c.Check(err, check.IsNil)
+	c.Check(buf[:n], check.DeepEquals, TestBlock[:4])
+
+	buf = make([]byte, len(TestBlock)+10)
+	n, err = v.ReadBlockHeader(context.Background(), TestHash, buf)
+	c.Check(err, check.IsNil)
+	c.Check(buf[:n], check.DeepEquals, TestBlock)
+
+	_, err = v.ReadBlockHeader(context.Background(), TestHash2, buf)
+	c.Check(os.IsNotExist(err), check.Equals, true)
+}
+
 func (s *StubbedS3Suite) TestIAMRoleCredentials(c *check.C) {
 	s.metadata = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		upd := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
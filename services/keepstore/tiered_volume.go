@@ -0,0 +1,395 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	driver["Tiered"] = newTieredVolume
+}
+
+func newTieredVolume(cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) (Volume, error) {
+	v := &TieredVolume{cluster: cluster, volume: volume, logger: logger, metrics: metrics}
+	err := json.Unmarshal(volume.DriverParameters, &v)
+	if err != nil {
+		return nil, err
+	}
+	if v.Backend.Driver == "" || v.Backend.Driver == "Tiered" {
+		return nil, fmt.Errorf("DriverParameters: invalid Backend.Driver %q", v.Backend.Driver)
+	}
+	if v.CacheRoot == "" {
+		return nil, errors.New("DriverParameters: CacheRoot must be provided")
+	}
+	if v.CacheSize <= 0 {
+		return nil, errors.New("DriverParameters: CacheSize must be greater than zero")
+	}
+	dri, ok := driver[v.Backend.Driver]
+	if !ok {
+		return nil, fmt.Errorf("DriverParameters: invalid Backend.Driver %q", v.Backend.Driver)
+	}
+	backendVolume := volume
+	backendVolume.Driver = v.Backend.Driver
+	backendVolume.DriverParameters = v.Backend.DriverParameters
+	v.backend, err = dri(cluster, backendVolume, logger, metrics)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing backend volume: %s", err)
+	}
+
+	// The cache volume deletes blocks immediately when we evict
+	// them, regardless of the cluster's trash settings.
+	cacheCluster := *cluster
+	cacheCluster.Collections.BlobTrash = true
+	cacheCluster.Collections.BlobSigningTTL = 0
+	cacheCluster.Collections.BlobTrashLifetime = 0
+	cacheParams, err := json.Marshal(map[string]string{"Root": v.CacheRoot})
+	if err != nil {
+		return nil, err
+	}
+	v.cache, err = newDirectoryVolume(&cacheCluster, arvados.Volume{Driver: "Directory", DriverParameters: cacheParams}, logger, metrics)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing cache volume: %s", err)
+	}
+	v.logger = logger.WithField("Volume", v.String())
+	return v, v.loadCache()
+}
+
+// TieredVolume implements Volume using a local directory as a
+// read/write-through cache in front of another (typically slower,
+// remote) volume.
+//
+// The backend volume is the source of truth for everything except
+// block content: Mtime, Touch, IndexTo, Trash, and Untrash are all
+// passed through to the backend. Get and Compare use the cached copy
+// of a block if there is one and the block still exists on the
+// backend, and Get adds blocks to the cache when they are read from
+// the backend. When the cache exceeds CacheSize,
+// the least recently used blocks are deleted from the cache.
+type TieredVolume struct {
+	Backend struct {
+		Driver           string
+		DriverParameters json.RawMessage
+	}
+	CacheRoot string
+	CacheSize arvados.ByteSize
+
+	cluster *arvados.Cluster
+	volume  arvados.Volume
+	logger  logrus.FieldLogger
+	metrics *volumeMetricsVecs
+	backend Volume
+	cache   Volume
+
+	lru       *list.List // of *tieredCacheEntry, most recently used first
+	lruMap    map[string]*list.Element
+	cacheUsed int64
+	lruMtx    sync.Mutex
+	stats     tieredStats
+}
+
+type tieredCacheEntry struct {
+	loc  string
+	size int64
+}
+
+type tieredStats struct {
+	CacheHits      uint64
+	CacheMisses    uint64
+	CacheEvictions uint64
+	CacheBytes     int64
+	CacheBlocks    int64
+	Backend        interface{} `json:",omitempty"`
+	Cache          interface{} `json:",omitempty"`
+}
+
+// loadCache populates the LRU list with the blocks that are already
+// in the cache directory, oldest first.
+func (v *TieredVolume) loadCache() error {
+	v.lru = list.New()
+	v.lruMap = map[string]*list.Element{}
+	v.cacheUsed = 0
+	type ent struct {
+		tieredCacheEntry
+		mtime int64
+	}
+	var ents []ent
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(v.cache.IndexTo("", pw))
+	}()
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() {
+		// Index lines look like "{hash}+{size} {mtime}"
+		line := scanner.Text()
+		sp := strings.IndexByte(line, ' ')
+		plus := strings.IndexByte(line, '+')
+		if sp < 0 || plus < 0 || plus > sp {
+			return fmt.Errorf("error parsing cache index line %q", line)
+		}
+		size, err := strconv.ParseInt(line[plus+1:sp], 10, 64)
+		if err != nil {
+			return fmt.Errorf("error parsing cache index line %q: %s", line, err)
+		}
+		mtime, err := strconv.ParseInt(line[sp+1:], 10, 64)
+		if err != nil {
+			return fmt.Errorf("error parsing cache index line %q: %s", line, err)
+		}
+		ents = append(ents, ent{tieredCacheEntry{loc: line[:plus], size: size}, mtime})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading cache index: %s", err)
+	}
+	sort.Slice(ents, func(i, j int) bool { return ents[i].mtime < ents[j].mtime })
+	for _, e := range ents {
+		v.addToLRU(e.loc, e.size)
+	}
+	v.logger.Printf("found %d blocks (%d bytes) in cache", len(ents), v.cacheUsed)
+	v.evictExcess()
+	return nil
+}
+
+// addToLRU adds (or moves) the given block to the front of the LRU
+// list.
+func (v *TieredVolume) addToLRU(loc string, size int64) {
+	v.lruMtx.Lock()
+	defer v.lruMtx.Unlock()
+	if elt, ok := v.lruMap[loc]; ok {
+		ent := elt.Value.(*tieredCacheEntry)
+		v.cacheUsed += size - ent.size
+		ent.size = size
+		v.lru.MoveToFront(elt)
+	} else {
+		v.lruMap[loc] = v.lru.PushFront(&tieredCacheEntry{loc: loc, size: size})
+		v.cacheUsed += size
+	}
+	atomic.StoreInt64(&v.stats.CacheBytes, v.cacheUsed)
+	atomic.StoreInt64(&v.stats.CacheBlocks, int64(v.lru.Len()))
+}
+
+// removeFromLRU removes the given block from the LRU list, and
+// returns true if it was there.
+func (v *TieredVolume) removeFromLRU(loc string) bool {
+	v.lruMtx.Lock()
+	defer v.lruMtx.Unlock()
+	elt, ok := v.lruMap[loc]
+	if !ok {
+		return false
+	}
+	v.lru.Remove(elt)
+	delete(v.lruMap, loc)
+	v.cacheUsed -= elt.Value.(*tieredCacheEntry).size
+	atomic.StoreInt64(&v.stats.CacheBytes, v.cacheUsed)
+	atomic.StoreInt64(&v.stats.CacheBlocks, int64(v.lru.Len()))
+	return true
+}
+
+// evictExcess deletes the least recently used blocks from the cache
+// until the total size is within CacheSize.
+func (v *TieredVolume) evictExcess() {
+	var victims []string
+	v.lruMtx.Lock()
+	for v.cacheUsed > int64(v.CacheSize) && v.lru.Len() > 0 {
+		elt := v.lru.Back()
+		ent := elt.Value.(*tieredCacheEntry)
+		v.lru.Remove(elt)
+		delete(v.lruMap, ent.loc)
+		v.cacheUsed -= ent.size
+		victims = append(victims, ent.loc)
+	}
+	atomic.StoreInt64(&v.stats.CacheBytes, v.cacheUsed)
+	atomic.StoreInt64(&v.stats.CacheBlocks, int64(v.lru.Len()))
+	v.lruMtx.Unlock()
+	for _, loc := range victims {
+		v.deleteCached(loc)
+		atomic.AddUint64(&v.stats.CacheEvictions, 1)
+	}
+}
+
+// deleteCached deletes a block from the cache directory.
+func (v *TieredVolume) deleteCached(loc string) {
+	err := v.cache.Trash(loc)
+	if err != nil && !os.IsNotExist(err) {
+		v.logger.WithError(err).Warnf("error deleting %s from cache", loc)
+	}
+}
+
+// fillCache adds a block to the cache.
+func (v *TieredVolume) fillCache(ctx context.Context, loc string, data []byte) {
+	if int64(len(data)) > int64(v.CacheSize) {
+		return
+	}
+	err := v.cache.Put(ctx, loc, data)
+	if err != nil {
+		v.logger.WithError(err).Warnf("error adding %s to cache", loc)
+		return
+	}
+	v.addToLRU(loc, int64(len(data)))
+	v.evictExcess()
+}
+
+// checkBackend returns os.ErrNotExist, and deletes the cached copy,
+// if the given block no longer exists on the backend -- e.g., it was
+// trashed or deleted by another keepstore process that shares the
+// backend. Other errors are logged and otherwise ignored, so cached
+// blocks are still available when the backend is having trouble.
+func (v *TieredVolume) checkBackend(loc string) error {
+	_, err := v.backend.Mtime(loc)
+	if os.IsNotExist(err) {
+		v.removeFromLRU(loc)
+		v.deleteCached(loc)
+		return os.ErrNotExist
+	} else if err != nil {
+		v.logger.WithError(err).Warnf("error checking backend for cached block %s", loc)
+	}
+	return nil
+}
+
+// Get returns the cached copy of a block if there is one and the
+// block still exists on the backend. Otherwise, it reads the block
+// from the backend and adds it to the cache.
+func (v *TieredVolume) Get(ctx context.Context, loc string, buf []byte) (int, error) {
+	n, err := v.cache.Get(ctx, loc, buf)
+	if err == nil {
+		if fmt.Sprintf("%x", md5.Sum(buf[:n])) == loc {
+			if err := v.checkBackend(loc); err != nil {
+				return 0, err
+			}
+			atomic.AddUint64(&v.stats.CacheHits, 1)
+			v.addToLRU(loc, int64(n))
+			// Update the cached file's timestamp, so the
+			// LRU order is preserved across restarts.
+			v.cache.Touch(loc)
+			return n, nil
+		}
+		v.logger.Warnf("checksum mismatch for cached block %s, deleting from cache", loc)
+		v.removeFromLRU(loc)
+		v.deleteCached(loc)
+	} else if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	atomic.AddUint64(&v.stats.CacheMisses, 1)
+	n, err = v.backend.Get(ctx, loc, buf)
+	if err != nil {
+		return n, err
+	}
+	if fmt.Sprintf("%x", md5.Sum(buf[:n])) == loc {
+		v.fillCache(ctx, loc, buf[:n])
+	}
+	return n, nil
+}
+
+// Compare compares the given data with the cached copy if there is
+// one and the block still exists on the backend, otherwise with the
+// backend copy.
+func (v *TieredVolume) Compare(ctx context.Context, loc string, expect []byte) error {
+	if err := v.cache.Compare(ctx, loc, expect); err == nil {
+		return v.checkBackend(loc)
+	}
+	return v.backend.Compare(ctx, loc, expect)
+}
+
+// Put writes the block to the backend, then adds it to the cache.
+func (v *TieredVolume) Put(ctx context.Context, loc string, block []byte) error {
+	err := v.backend.Put(ctx, loc, block)
+	if err != nil {
+		return err
+	}
+	v.fillCache(ctx, loc, block)
+	return nil
+}
+
+// Touch updates the block's timestamp on the backend.
+func (v *TieredVolume) Touch(loc string) error {
+	return v.backend.Touch(loc)
+}
+
+// Mtime returns the block's timestamp on the backend.
+func (v *TieredVolume) Mtime(loc string) (time.Time, error) {
+	return v.backend.Mtime(loc)
+}
+
+// IndexTo writes the backend's index. Blocks that are in the cache
+// but not the backend are not included.
+func (v *TieredVolume) IndexTo(prefix string, writer io.Writer) error {
+	return v.backend.IndexTo(prefix, writer)
+}
+
+// Trash trashes the block on the backend, and deletes it from the
+// cache.
+//
+// The cached copy is deleted even if the backend decides not to
+// trash the block (e.g., because it was written recently). This
+// doesn't lose any data. Blocks trashed on the backend by other
+// means (e.g., another keepstore process sharing the backend) are
+// removed from the cache the next time Get or Compare finds them
+// there.
+func (v *TieredVolume) Trash(loc string) error {
+	err := v.backend.Trash(loc)
+	v.removeFromLRU(loc)
+	v.deleteCached(loc)
+	return err
+}
+
+// Untrash untrashes the block on the backend.
+func (v *TieredVolume) Untrash(loc string) error {
+	return v.backend.Untrash(loc)
+}
+
+// EmptyTrash empties the backend's trash.
+func (v *TieredVolume) EmptyTrash() {
+	v.backend.EmptyTrash()
+}
+
+// Status returns the backend's status.
+func (v *TieredVolume) Status() *VolumeStatus {
+	return v.backend.Status()
+}
+
+// GetDeviceID returns the backend's device ID.
+func (v *TieredVolume) GetDeviceID() string {
+	return v.backend.GetDeviceID()
+}
+
+// String implements fmt.Stringer.
+func (v *TieredVolume) String() string {
+	return fmt.Sprintf("%s (cache %s)", v.backend, v.cache)
+}
+
+// InternalStats returns cache usage and hit/miss counters, and the
+// backend and cache volumes' own internal stats.
+func (v *TieredVolume) InternalStats() interface{} {
+	stats := &tieredStats{
+		CacheHits:      atomic.LoadUint64(&v.stats.CacheHits),
+		CacheMisses:    atomic.LoadUint64(&v.stats.CacheMisses),
+		CacheEvictions: atomic.LoadUint64(&v.stats.CacheEvictions),
+		CacheBytes:     atomic.LoadInt64(&v.stats.CacheBytes),
+		CacheBlocks:    atomic.LoadInt64(&v.stats.CacheBlocks),
+	}
+	if is, ok := v.backend.(InternalStatser); ok {
+		stats.Backend = is.InternalStats()
+	}
+	if is, ok := v.cache.(InternalStatser); ok {
+		stats.Cache = is.InternalStats()
+	}
+	return stats
+}
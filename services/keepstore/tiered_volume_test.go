@@ -0,0 +1,295 @@
+// Copyright (C) The Arvados Authors. All rights reserved.
+//
+// SPDX-License-Identifier: AGPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"git.arvados.org/arvados.git/sdk/go/arvados"
+	"git.arvados.org/arvados.git/sdk/go/ctxlog"
+	"github.com/sirupsen/logrus"
+	check "gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&TieredVolumeSuite{})
+
+type TieredVolumeSuite struct {
+	cluster *arvados.Cluster
+	metrics *volumeMetricsVecs
+	unix    UnixVolumeSuite
+}
+
+func (s *TieredVolumeSuite) SetUpTest(c *check.C) {
+	s.unix.SetUpTest(c)
+	s.cluster = s.unix.cluster
+	s.metrics = s.unix.metrics
+}
+
+func (s *TieredVolumeSuite) TearDownTest(c *check.C) {
+	s.unix.TearDownTest(c)
+}
+
+type TestableTieredVolume struct {
+	*TieredVolume
+	backend *TestableUnixVolume
+	cache   *TestableUnixVolume
+}
+
+func (s *TieredVolumeSuite) newTestableTieredVolume(c *check.C, cluster *arvados.Cluster, volume arvados.Volume, metrics *volumeMetricsVecs, cacheSize arvados.ByteSize) *TestableTieredVolume {
+	backend := s.unix.newTestableUnixVolume(c, cluster, volume, metrics, false)
+	cacheCluster := *cluster
+	cacheCluster.Collections.BlobTrash = true
+	cacheCluster.Collections.BlobSigningTTL = 0
+	cacheCluster.Collections.BlobTrashLifetime = 0
+	cache := s.unix.newTestableUnixVolume(c, &cacheCluster, arvados.Volume{}, metrics, false)
+	v := &TieredVolume{
+		CacheRoot: cache.Root,
+		CacheSize: cacheSize,
+		cluster:   cluster,
+		volume:    volume,
+		logger:    ctxlog.TestLogger(c),
+		metrics:   metrics,
+		backend:   backend,
+		cache:     cache,
+	}
+	c.Assert(v.loadCache(), check.IsNil)
+	return &TestableTieredVolume{TieredVolume: v, backend: backend, cache: cache}
+}
+
+// PutRaw stores a block on both the backend and the cache without
+// checking its hash.
+func (v *TestableTieredVolume) PutRaw(loc string, data []byte) {
+	v.backend.PutRaw(loc, data)
+	v.cache.PutRaw(loc, data)
+	v.addToLRU(loc, int64(len(data)))
+}
+
+func (v *TestableTieredVolume) TouchWithDate(loc string, lastPut time.Time) {
+	v.backend.TouchWithDate(loc, lastPut)
+}
+
+func (v *TestableTieredVolume) Teardown() {
+	v.backend.Teardown()
+	v.cache.Teardown()
+}
+
+func (v *TestableTieredVolume) ReadWriteOperationLabelValues() (r, w string) {
+	return v.backend.ReadWriteOperationLabelValues()
+}
+
+// GetDeviceID returns the cache's device ID, rather than the
+// backend's, so the generic metrics test finds the read operations
+// for cache hits.
+func (v *TestableTieredVolume) GetDeviceID() string {
+	return v.cache.GetDeviceID()
+}
+
+func (s *TieredVolumeSuite) TestGeneric(c *check.C) {
+	DoGenericVolumeTests(c, false, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+		return s.newTestableTieredVolume(c, cluster, volume, metrics, 1<<30)
+	})
+}
+
+func (s *TieredVolumeSuite) TestGenericReadOnly(c *check.C) {
+	DoGenericVolumeTests(c, true, func(t TB, cluster *arvados.Cluster, volume arvados.Volume, logger logrus.FieldLogger, metrics *volumeMetricsVecs) TestableVolume {
+		return s.newTestableTieredVolume(c, cluster, volume, metrics, 1<<30)
+	})
+}
+
+func (s *TieredVolumeSuite) TestConfig(c *check.C) {
+	cacheRoot, err := ioutil.TempDir("", "tiered_test")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(cacheRoot)
+	backendRoot, err := ioutil.TempDir("", "tiered_test")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(backendRoot)
+
+	for _, trial := range []struct {
+		params string
+		err    string
+	}{
+		{`{"Backend":{"Driver":"Directory","DriverParameters":{"Root":"` + backendRoot + `"}},"CacheRoot":"` + cacheRoot + `","CacheSize":"1GiB"}`, ``},
+		{`{"Backend":{"Driver":"Tiered"},"CacheRoot":"` + cacheRoot + `","CacheSize":"1GiB"}`, `.*invalid Backend.Driver "Tiered"`},
+		{`{"Backend":{"Driver":"Bogus"},"CacheRoot":"` + cacheRoot + `","CacheSize":"1GiB"}`, `.*invalid Backend.Driver "Bogus"`},
+		{`{"Backend":{"Driver":"Directory","DriverParameters":{"Root":"` + backendRoot + `"}},"CacheSize":"1GiB"}`, `.*CacheRoot must be provided`},
+		{`{"Backend":{"Driver":"Directory","DriverParameters":{"Root":"` + backendRoot + `"}},"CacheRoot":"` + cacheRoot + `"}`, `.*CacheSize must be greater than zero`},
+		{`{"Backend":{"Driver":"Directory","DriverParameters":{}},"CacheRoot":"` + cacheRoot + `","CacheSize":"1GiB"}`, `error initializing backend volume: .*Root was not provided`},
+	} {
+		c.Logf("trial: %s", trial.params)
+		v, err := newTieredVolume(s.cluster, arvados.Volume{DriverParameters: json.RawMessage(trial.params)}, ctxlog.TestLogger(c), s.metrics)
+		if trial.err == "" {
+			c.Check(err, check.IsNil)
+			c.Check(v.(*TieredVolume).CacheSize, check.Equals, arvados.ByteSize(1<<30))
+		} else {
+			c.Check(err, check.ErrorMatches, trial.err)
+		}
+	}
+}
+
+func (s *TieredVolumeSuite) putBlocks(c *check.C, v *TestableTieredVolume, n, size int) []string {
+	var hashes []string
+	for i := 0; i < n; i++ {
+		block := bytes.Repeat([]byte{byte(i)}, size)
+		hash := fmt.Sprintf("%x", md5.Sum(block))
+		c.Assert(v.Put(context.Background(), hash, block), check.IsNil)
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+func (s *TieredVolumeSuite) cached(v *TestableTieredVolume, hash string) bool {
+	_, err := os.Stat(v.cache.blockPath(hash))
+	return err == nil
+}
+
+func (s *TieredVolumeSuite) TestEvictLRU(c *check.C) {
+	v := s.newTestableTieredVolume(c, s.cluster, arvados.Volume{}, s.metrics, 3000)
+	hashes := s.putBlocks(c, v, 3, 1000)
+	for _, hash := range hashes {
+		c.Check(s.cached(v, hash), check.Equals, true)
+	}
+
+	// Reading the oldest block makes it the most recently used,
+	// so the next Put evicts hashes[1] instead.
+	buf := make([]byte, BlockSize)
+	_, err := v.Get(context.Background(), hashes[0], buf)
+	c.Check(err, check.IsNil)
+	block := bytes.Repeat([]byte{3}, 1000)
+	hashes = append(hashes, fmt.Sprintf("%x", md5.Sum(block)))
+	c.Assert(v.Put(context.Background(), hashes[3], block), check.IsNil)
+	c.Check(s.cached(v, hashes[0]), check.Equals, true)
+	c.Check(s.cached(v, hashes[1]), check.Equals, false)
+	c.Check(s.cached(v, hashes[2]), check.Equals, true)
+	c.Check(s.cached(v, hashes[3]), check.Equals, true)
+
+	// Evicted blocks are still available from the backend, and
+	// go back into the cache when read.
+	n, err := v.Get(context.Background(), hashes[1], buf)
+	c.Check(err, check.IsNil)
+	c.Check(n, check.Equals, 1000)
+	c.Check(s.cached(v, hashes[1]), check.Equals, true)
+	c.Check(s.cached(v, hashes[2]), check.Equals, false)
+
+	stats := v.InternalStats().(*tieredStats)
+	c.Check(stats.CacheHits, check.Equals, uint64(1))
+	c.Check(stats.CacheMisses, check.Equals, uint64(1))
+	c.Check(stats.CacheEvictions, check.Equals, uint64(2))
+	c.Check(stats.CacheBytes, check.Equals, int64(3000))
+	c.Check(stats.CacheBlocks, check.Equals, int64(3))
+}
+
+func (s *TieredVolumeSuite) TestLoadCache(c *check.C) {
+	v := s.newTestableTieredVolume(c, s.cluster, arvados.Volume{}, s.metrics, 3000)
+	hashes := s.putBlocks(c, v, 3, 1000)
+	for i, hash := range hashes {
+		v.cache.TouchWithDate(hash, time.Now().Add(time.Duration(i-10)*time.Minute))
+	}
+	// Reading hashes[0] from the cache updates its timestamp.
+	buf := make([]byte, BlockSize)
+	_, err := v.Get(context.Background(), hashes[0], buf)
+	c.Check(err, check.IsNil)
+
+	// After a restart with a smaller cache, the least recently
+	// used block is evicted.
+	v.CacheSize = 2000
+	c.Assert(v.loadCache(), check.IsNil)
+	c.Check(s.cached(v, hashes[0]), check.Equals, true)
+	c.Check(s.cached(v, hashes[1]), check.Equals, false)
+	c.Check(s.cached(v, hashes[2]), check.Equals, true)
+	c.Check(v.InternalStats().(*tieredStats).CacheBytes, check.Equals, int64(2000))
+}
+
+func (s *TieredVolumeSuite) TestMissFillsCache(c *check.C) {
+	v := s.newTestableTieredVolume(c, s.cluster, arvados.Volume{}, s.metrics, 1<<20)
+	v.backend.PutRaw(TestHash, TestBlock)
+	c.Check(s.cached(v, TestHash), check.Equals, false)
+	buf := make([]byte, BlockSize)
+	n, err := v.Get(context.Background(), TestHash, buf)
+	c.Check(err, check.IsNil)
+	c.Check(buf[:n], check.DeepEquals, TestBlock)
+	c.Check(s.cached(v, TestHash), check.Equals, true)
+
+	// Corrupt data from the backend is returned to the caller
+	// (which will detect the problem) but not cached.
+	v.backend.PutRaw(TestHash2, TestBlock)
+	n, err = v.Get(context.Background(), TestHash2, buf)
+	c.Check(err, check.IsNil)
+	c.Check(buf[:n], check.DeepEquals, TestBlock)
+	c.Check(s.cached(v, TestHash2), check.Equals, false)
+}
+
+func (s *TieredVolumeSuite) TestCorruptCache(c *check.C) {
+	v := s.newTestableTieredVolume(c, s.cluster, arvados.Volume{}, s.metrics, 1<<20)
+	c.Assert(v.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+	v.cache.PutRaw(TestHash, TestBlock2)
+	buf := make([]byte, BlockSize)
+	n, err := v.Get(context.Background(), TestHash, buf)
+	c.Check(err, check.IsNil)
+	c.Check(buf[:n], check.DeepEquals, TestBlock)
+	c.Check(v.InternalStats().(*tieredStats).CacheMisses, check.Equals, uint64(1))
+
+	// The bad copy was replaced with a good one.
+	n, err = v.cache.Get(context.Background(), TestHash, buf)
+	c.Check(err, check.IsNil)
+	c.Check(buf[:n], check.DeepEquals, TestBlock)
+}
+
+// A block that is trashed on the backend without going through the
+// TieredVolume (e.g., by another keepstore process sharing the
+// backend) is not served from the cache.
+func (s *TieredVolumeSuite) TestBackendTrashed(c *check.C) {
+	v := s.newTestableTieredVolume(c, s.cluster, arvados.Volume{}, s.metrics, 1<<20)
+	for _, hash := range []string{TestHash, TestHash2} {
+		block := map[string][]byte{TestHash: TestBlock, TestHash2: TestBlock2}[hash]
+		c.Assert(v.Put(context.Background(), hash, block), check.IsNil)
+		v.backend.TouchWithDate(hash, time.Now().Add(-2*s.cluster.Collections.BlobSigningTTL.Duration()))
+		c.Assert(v.backend.Trash(hash), check.IsNil)
+		c.Check(s.cached(v, hash), check.Equals, true)
+	}
+
+	buf := make([]byte, BlockSize)
+	_, err := v.Get(context.Background(), TestHash, buf)
+	c.Check(os.IsNotExist(err), check.Equals, true)
+	c.Check(s.cached(v, TestHash), check.Equals, false)
+
+	err = v.Compare(context.Background(), TestHash2, TestBlock2)
+	c.Check(os.IsNotExist(err), check.Equals, true)
+	c.Check(s.cached(v, TestHash2), check.Equals, false)
+	c.Check(v.InternalStats().(*tieredStats).CacheBlocks, check.Equals, int64(0))
+}
+
+// The backend is the source of truth for the index and for trash:
+// blocks that exist only in the cache are not listed, and trashing a
+// block removes it from the cache even if the backend refuses to
+// trash it yet.
+func (s *TieredVolumeSuite) TestIndexAndTrash(c *check.C) {
+	v := s.newTestableTieredVolume(c, s.cluster, arvados.Volume{}, s.metrics, 1<<20)
+	c.Assert(v.Put(context.Background(), TestHash, TestBlock), check.IsNil)
+	v.cache.PutRaw(TestHash2, TestBlock2)
+
+	buf := &bytes.Buffer{}
+	c.Assert(v.IndexTo("", buf), check.IsNil)
+	c.Check(buf.String(), check.Matches, TestHash+`\+\d+ \d+\n`)
+	c.Check(strings.Contains(buf.String(), TestHash2), check.Equals, false)
+
+	// The block is too new to trash on the backend.
+	c.Check(v.Trash(TestHash), check.IsNil)
+	c.Check(s.cached(v, TestHash), check.Equals, false)
+	_, err := v.backend.Mtime(TestHash)
+	c.Check(err, check.IsNil)
+
+	v.backend.TouchWithDate(TestHash, time.Now().Add(-2*s.cluster.Collections.BlobSigningTTL.Duration()))
+	mtime, err := v.Mtime(TestHash)
+	c.Check(err, check.IsNil)
+	c.Check(time.Since(mtime) > s.cluster.Collections.BlobSigningTTL.Duration(), check.Equals, true)
+}
@@ -70,6 +70,7 @@ func TrashItem(volmgr *RRVolumeManager, logger logrus.FieldLogger, cluster *arva
 			logger.WithError(err).Errorf("%v Trash(%v)", volume, trashRequest.Locator)
 		} else {
 			logger.Infof("%v Trash(%v) OK", volume, trashRequest.Locator)
+			volmgr.trashed(trashRequest.Locator)
 		}
 	}
 }
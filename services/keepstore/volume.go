@@ -29,6 +29,14 @@ type BlockReader interface {
 	ReadBlock(ctx context.Context, loc string, w io.Writer) error
 }
 
+type BlockHeaderReader interface {
+	// ReadBlockHeader copies the first len(buf) bytes of the
+	// data stored as "loc" (or all of it, if it is shorter) into
+	// buf, without retrieving the rest, and returns the number of
+	// bytes copied.
+	ReadBlockHeader(ctx context.Context, loc string, buf []byte) (int, error)
+}
+
 var driver = map[string]func(*arvados.Cluster, arvados.Volume, logrus.FieldLogger, *volumeMetricsVecs) (Volume, error){}
 
 // A Volume is an interface representing a Keep back-end storage unit:
@@ -295,6 +303,10 @@ type RRVolumeManager struct {
 	writables []*VolumeMount
 	counter   uint32
 	iostats   map[Volume]*ioStats
+
+	// Hashes stored on readable volumes, if the cluster has
+	// erasure-coded storage classes (otherwise nil).
+	shards *localShardIndex
 }
 
 func makeRRVolumeManager(logger logrus.FieldLogger, cluster *arvados.Cluster, myURL arvados.URL, metrics *volumeMetricsVecs) (*RRVolumeManager, error) {
@@ -315,6 +327,12 @@ func makeRRVolumeManager(logger logrus.FieldLogger, cluster *arvados.Cluster, my
 		if err != nil {
 			return nil, fmt.Errorf("error initializing volume %s: %s", uuid, err)
 		}
+		if cfgvol.Compression != "" {
+			vol, err = newCompressedVolume(vol, cfgvol.Compression, cfgvol.CompressionSizeCache, metrics)
+			if err != nil {
+				return nil, fmt.Errorf("error initializing volume %s: %s", uuid, err)
+			}
+		}
 		logger.Printf("started volume %s (%s), ReadOnly=%v", uuid, vol, cfgvol.ReadOnly)
 
 		sc := cfgvol.StorageClasses
@@ -343,6 +361,9 @@ func makeRRVolumeManager(logger logrus.FieldLogger, cluster *arvados.Cluster, my
 			vm.writables = append(vm.writables, mnt)
 		}
 	}
+	if len(erasureSchemes(cluster)) > 0 {
+		vm.shards = newLocalShardIndex(logger, vm.readables)
+	}
 	return vm, nil
 }
 
@@ -232,6 +232,7 @@ func (v *MockVolume) Trash(loc string) error {
 			return nil
 		}
 		delete(v.Store, loc)
+		delete(v.Timestamps, loc)
 		return nil
 	}
 	return os.ErrNotExist